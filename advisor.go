@@ -0,0 +1,121 @@
+package tls
+
+import "fmt"
+
+// An AdviceKind identifies a recommendation made by Conn.Advise.
+type AdviceKind int
+
+const (
+	// AdviceNoOffload reports that kernel TLS was not enabled for a
+	// direction, with the reason.
+	AdviceNoOffload AdviceKind = iota + 1
+	// AdviceSmallRecords reports that records are too small on average
+	// for kernel TLS to pay off.
+	AdviceSmallRecords
+	// AdviceHighRetransmits reports a high TCP retransmission rate on an
+	// offloaded connection, which defeats NIC TLS offload.
+	AdviceHighRetransmits
+	// AdviceDeviceResyncs reports that the NIC had to resynchronize its
+	// TLS RX offload state with the stream.
+	AdviceDeviceResyncs
+)
+
+// Advice is a tuning recommendation derived from the statistics of a
+// connection.
+type Advice struct {
+	Kind    AdviceKind
+	Message string
+}
+
+func (a Advice) String() string {
+	return a.Message
+}
+
+const (
+	// adviceMinRecords is the number of records below which record sizes
+	// are not judged.
+	adviceMinRecords = 32
+	// adviceSmallRecord is the mean payload size, in bytes, below which
+	// records are considered too small for offload.
+	adviceSmallRecord = 1024
+	// adviceRetransmitRate is the fraction of retransmitted segments
+	// above which NIC offload is discouraged.
+	adviceRetransmitRate = 0.02
+)
+
+// adviceInput is what the advice of a connection is derived from.
+type adviceInput struct {
+	state KTLSState
+	stats ConnStats
+
+	// retransmits and segments are the retransmitted and sent TCP
+	// segments, if tcpInfo is set.
+	tcpInfo     bool
+	retransmits uint64
+	segments    uint64
+
+	// deviceResyncs is the growth of the host-wide count of NIC TLS RX
+	// resyncs since RX was offloaded on the connection.
+	deviceResyncs uint64
+}
+
+// Advise returns recommendations for tuning kernel TLS based on the
+// statistics of the connection so far, such as "records too small for
+// offload benefit" or "high retransmits: consider disabling NIC offload".
+// It returns nil if there is nothing to recommend or the handshake has not
+// completed. See also Config.OnAdvice.
+func (c *Conn) Advise() []Advice {
+	if !c.isHandshakeComplete.Load() {
+		return nil
+	}
+	c.handshakeMutex.Lock()
+	in := adviceInput{state: c.ktlsState}
+	resyncBase := c.ktlsResyncBase
+	c.handshakeMutex.Unlock()
+	in.stats = c.Stats()
+	if retrans, sent, err := tcpSegments(c.conn, c.config.KTLSSeccompCompat); err == nil {
+		in.tcpInfo = true
+		in.retransmits, in.segments = retrans, sent
+	}
+	if in.state.RXEnabled {
+		if resyncs, err := tlsDeviceResyncs(); err == nil && resyncs > resyncBase {
+			in.deviceResyncs = resyncs - resyncBase
+		}
+	}
+	return advise(in)
+}
+
+func advise(in adviceInput) []Advice {
+	var advice []Advice
+	add := func(kind AdviceKind, format string, args ...any) {
+		advice = append(advice, Advice{Kind: kind, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if in.state.TXReason != "" {
+		add(AdviceNoOffload, "kernel TLS TX not enabled: %s", in.state.TXReason)
+	}
+	if in.state.RXReason != "" {
+		add(AdviceNoOffload, "kernel TLS RX not enabled: %s", in.state.RXReason)
+	}
+
+	if tx := in.stats.TXRecordSize; in.state.TXEnabled && tx.Count >= adviceMinRecords && tx.Mean() < adviceSmallRecord {
+		add(AdviceSmallRecords, "records too small for offload benefit: %.0f bytes sent per record on average; "+
+			"consider Config.WriteCoalesceDelay or larger writes", tx.Mean())
+	}
+	if rx := in.stats.RXRecordSize; in.state.RXEnabled && rx.Count >= adviceMinRecords && rx.Mean() < adviceSmallRecord {
+		add(AdviceSmallRecords, "records too small for offload benefit: %.0f bytes received per record on average", rx.Mean())
+	}
+
+	if in.tcpInfo && in.state.TXEnabled && in.segments >= adviceMinRecords {
+		if rate := float64(in.retransmits) / float64(in.segments); rate > adviceRetransmitRate {
+			add(AdviceHighRetransmits, "high retransmits (%.1f%% of segments): consider disabling NIC offload "+
+				"with ethtool -K <iface> tls-hw-tx-offload off", 100*rate)
+		}
+	}
+
+	if in.deviceResyncs > 0 {
+		add(AdviceDeviceResyncs, "NIC TLS RX offload resynchronized %d times on this host since RX was offloaded: "+
+			"consider disabling it with ethtool -K <iface> tls-hw-rx-offload off", in.deviceResyncs)
+	}
+	return advice
+}
@@ -0,0 +1,62 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"errors"
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	errTCPInfoConn      = errors.New("tls: TCP_INFO requires a *net.TCPConn")
+	errTCPInfoNoSegsOut = errors.New("tls: TCP_INFO lacks tcpi_segs_out")
+)
+
+// tcpSegments returns the numbers of segments retransmitted and sent on
+// conn, read with TCP_INFO. Kernels before 4.2 don't count sent segments.
+func tcpSegments(conn net.Conn, compat bool) (retransmits, sent uint64, err error) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return 0, 0, errTCPInfoConn
+	}
+	rwc, err := tcpConn.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+	var info unix.TCPInfo
+	n := uint32(unsafe.Sizeof(info))
+	var err0 error
+	err = rwc.Control(func(fd uintptr) {
+		if compat {
+			var b string
+			b, err0 = unix.GetsockoptString(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+			n = uint32(copy(unsafe.Slice((*byte)(unsafe.Pointer(&info)), n), b))
+			return
+		}
+		err0 = getsockopt(fd, unix.IPPROTO_TCP, unix.TCP_INFO, unsafe.Pointer(&info), &n)
+	})
+	if err == nil {
+		err = err0
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if uintptr(n) < unsafe.Offsetof(info.Segs_out)+unsafe.Sizeof(info.Segs_out) {
+		return 0, 0, errTCPInfoNoSegsOut
+	}
+	return uint64(info.Total_retrans), uint64(info.Segs_out), nil
+}
+
+// tlsDeviceResyncs returns the TlsRxDeviceResync counter of
+// /proc/net/tls_stat.
+func tlsDeviceResyncs() (uint64, error) {
+	stats, err := KTLSStats()
+	if err != nil {
+		return 0, err
+	}
+	return stats.RxDeviceResync, nil
+}
@@ -0,0 +1,32 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import "testing"
+
+func TestTCPSegments(t *testing.T) {
+	c, s := localPipe(t)
+	defer c.Close()
+	defer s.Close()
+	if _, err := c.Write(make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Read(make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	// compat reads TCP_INFO through the x/sys wrapper, which must agree.
+	for _, compat := range []bool{false, true} {
+		retransmits, sent, err := tcpSegments(c, compat)
+		if err == errTCPInfoNoSegsOut {
+			t.Skip(err)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The handshake ACK and the data at least.
+		if sent < 2 || retransmits > sent {
+			t.Errorf("tcpSegments(compat %v) = %d retransmits, %d sent", compat, retransmits, sent)
+		}
+	}
+}
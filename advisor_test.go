@@ -0,0 +1,81 @@
+package tls
+
+import "testing"
+
+func adviceKinds(advice []Advice) map[AdviceKind]int {
+	kinds := make(map[AdviceKind]int)
+	for _, a := range advice {
+		kinds[a.Kind]++
+	}
+	return kinds
+}
+
+func TestAdvise(t *testing.T) {
+	small := Histogram{Count: 100, Sum: 100 * 200}
+	large := Histogram{Count: 100, Sum: 100 * 16384}
+
+	tests := []struct {
+		name string
+		in   adviceInput
+		want map[AdviceKind]int
+	}{
+		{"healthy", adviceInput{
+			state:   KTLSState{TXEnabled: true, RXEnabled: true},
+			stats:   ConnStats{TXRecordSize: large, RXRecordSize: large},
+			tcpInfo: true, retransmits: 1, segments: 1000,
+		}, map[AdviceKind]int{}},
+		{"fallback", adviceInput{
+			state: KTLSState{TXEnabled: true, RXReason: "TLS_RX setsockopt failed"},
+		}, map[AdviceKind]int{AdviceNoOffload: 1}},
+		{"small records", adviceInput{
+			state: KTLSState{TXEnabled: true, RXEnabled: true},
+			stats: ConnStats{TXRecordSize: small, RXRecordSize: small},
+		}, map[AdviceKind]int{AdviceSmallRecords: 2}},
+		{"small records without offload", adviceInput{
+			stats: ConnStats{TXRecordSize: small, RXRecordSize: small},
+		}, map[AdviceKind]int{}},
+		{"few records", adviceInput{
+			state: KTLSState{TXEnabled: true},
+			stats: ConnStats{TXRecordSize: Histogram{Count: 3, Sum: 30}},
+		}, map[AdviceKind]int{}},
+		{"retransmits", adviceInput{
+			state:   KTLSState{TXEnabled: true},
+			tcpInfo: true, retransmits: 50, segments: 1000,
+		}, map[AdviceKind]int{AdviceHighRetransmits: 1}},
+		{"resyncs", adviceInput{
+			state:         KTLSState{RXEnabled: true},
+			deviceResyncs: 3,
+		}, map[AdviceKind]int{AdviceDeviceResyncs: 1}},
+	}
+	for _, tt := range tests {
+		got := adviceKinds(advise(tt.in))
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for k, n := range tt.want {
+			if got[k] != n {
+				t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestOnAdvice(t *testing.T) {
+	client, server := coalescePipe(t, 0)
+	defer server.Close()
+
+	var got []Advice
+	client.config = client.config.Clone()
+	client.config.OnAdvice = func(conn *Conn, advice Advice) {
+		if conn != client {
+			t.Errorf("OnAdvice called with the wrong connection")
+		}
+		got = append(got, advice)
+	}
+	client.ktlsState = KTLSState{TXReason: "test"}
+	client.Close()
+	if len(got) != 1 || got[0].Kind != AdviceNoOffload {
+		t.Errorf("got advice %v, want one AdviceNoOffload", got)
+	}
+}
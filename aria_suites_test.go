@@ -0,0 +1,67 @@
+package tls
+
+import (
+	"testing"
+)
+
+func TestHandshakeARIA(t *testing.T) {
+	for _, suite := range []uint16{
+		TLS_ECDHE_RSA_WITH_ARIA_128_GCM_SHA256,
+		TLS_ECDHE_RSA_WITH_ARIA_256_GCM_SHA384,
+		TLS_RSA_WITH_ARIA_128_GCM_SHA256,
+		TLS_RSA_WITH_ARIA_256_GCM_SHA384,
+	} {
+		t.Run(CipherSuiteName(suite), func(t *testing.T) {
+			clientConfig := testConfig.Clone()
+			clientConfig.MaxVersion = VersionTLS12
+			clientConfig.CipherSuites = []uint16{suite}
+			serverConfig := testConfig.Clone()
+			serverConfig.CipherSuites = []uint16{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, suite}
+			c, s := localPipe(t)
+			client := Client(c, clientConfig)
+			server := Server(s, serverConfig)
+			defer client.Close()
+			defer server.Close()
+
+			errs := make(chan error, 1)
+			go func() {
+				buf := make([]byte, 5)
+				_, err := server.Read(buf)
+				if err == nil {
+					_, err = server.Write(buf)
+				}
+				errs <- err
+			}()
+			if _, err := client.Write([]byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 5)
+			if _, err := client.Read(buf); err != nil {
+				t.Fatal(err)
+			}
+			if err := <-errs; err != nil {
+				t.Fatal(err)
+			}
+			if string(buf) != "hello" {
+				t.Errorf("got %q", buf)
+			}
+			if got := client.ConnectionState().CipherSuite; got != suite {
+				t.Errorf("negotiated %s, want %s", CipherSuiteName(got), CipherSuiteName(suite))
+			}
+		})
+	}
+}
+
+func TestARIANotOfferedByDefault(t *testing.T) {
+	hello, _, err := (&Conn{config: testConfig.Clone()}).makeClientHello()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range hello.cipherSuites {
+		for _, suite := range optionalCipherSuites {
+			if id == suite.id {
+				t.Errorf("default ClientHello offers %s", CipherSuiteName(id))
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package tls
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+// ccm implements the CCM mode of NIST SP 800-38C and RFC 3610, as used by
+// TLS_AES_128_CCM_SHA256 (RFC 8446, Section B.4). The standard library does
+// not provide it.
+type ccm struct {
+	b         cipher.Block
+	nonceSize int
+	tagSize   int
+}
+
+var errCCMOpen = errors.New("tls: CCM message authentication failed")
+
+// newCCM returns the CCM mode of b, which must have a 16-byte block size,
+// with the given nonce and tag sizes. Like the AEADs of crypto/cipher, it
+// may seal and open in place, with dst sharing the start of the input.
+func newCCM(b cipher.Block, nonceSize, tagSize int) (cipher.AEAD, error) {
+	if b.BlockSize() != 16 {
+		return nil, errors.New("tls: CCM requires a 128-bit block cipher")
+	}
+	if nonceSize < 7 || nonceSize > 13 {
+		return nil, errors.New("tls: invalid CCM nonce size")
+	}
+	if tagSize < 4 || tagSize > 16 || tagSize%2 != 0 {
+		return nil, errors.New("tls: invalid CCM tag size")
+	}
+	return &ccm{b: b, nonceSize: nonceSize, tagSize: tagSize}, nil
+}
+
+func (c *ccm) NonceSize() int { return c.nonceSize }
+func (c *ccm) Overhead() int  { return c.tagSize }
+
+// maxLength returns the length of the longest message the length field of
+// the first block can encode.
+func (c *ccm) maxLength() uint64 {
+	q := 15 - c.nonceSize
+	if q >= 8 {
+		return 1<<63 - 1
+	}
+	return 1<<(8*q) - 1
+}
+
+// counter returns counter block i, A_i in SP 800-38C.
+func (c *ccm) counter(nonce []byte, i byte) [16]byte {
+	var a [16]byte
+	a[0] = byte(14 - c.nonceSize) // q - 1
+	copy(a[1:], nonce)
+	a[15] = i
+	return a
+}
+
+// mac returns the CBC-MAC of the formatted nonce, additional data and
+// plaintext, T in SP 800-38C.
+func (c *ccm) mac(nonce, plaintext, additionalData []byte) [16]byte {
+	var x [16]byte
+	q := 15 - c.nonceSize
+	x[0] = byte(8*((c.tagSize-2)/2) + q - 1)
+	if len(additionalData) > 0 {
+		x[0] |= 1 << 6
+	}
+	copy(x[1:], nonce)
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(plaintext)))
+	copy(x[16-q:], n[8-q:])
+	c.b.Encrypt(x[:], x[:])
+
+	if len(additionalData) > 0 {
+		var block [16]byte
+		var hdr []byte
+		switch a := uint64(len(additionalData)); {
+		case a < 1<<16-1<<8:
+			hdr = block[:2]
+			binary.BigEndian.PutUint16(hdr, uint16(a))
+		case a <= 1<<32-1:
+			hdr = block[:6]
+			hdr[0], hdr[1] = 0xff, 0xfe
+			binary.BigEndian.PutUint32(hdr[2:], uint32(a))
+		default:
+			hdr = block[:10]
+			hdr[0], hdr[1] = 0xff, 0xff
+			binary.BigEndian.PutUint64(hdr[2:], a)
+		}
+		n := copy(block[len(hdr):], additionalData)
+		c.macBlock(&x, block[:])
+		c.macPadded(&x, additionalData[n:])
+	}
+	c.macPadded(&x, plaintext)
+	return x
+}
+
+// macPadded feeds b, padded with zeros to a multiple of the block size, to
+// the CBC-MAC state x.
+func (c *ccm) macPadded(x *[16]byte, b []byte) {
+	for len(b) >= 16 {
+		c.macBlock(x, b[:16])
+		b = b[16:]
+	}
+	if len(b) > 0 {
+		var block [16]byte
+		copy(block[:], b)
+		c.macBlock(x, block[:])
+	}
+}
+
+func (c *ccm) macBlock(x *[16]byte, b []byte) {
+	subtle.XORBytes(x[:], x[:], b)
+	c.b.Encrypt(x[:], x[:])
+}
+
+func (c *ccm) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != c.nonceSize {
+		panic("tls: incorrect nonce length given to CCM")
+	}
+	if uint64(len(plaintext)) > c.maxLength() {
+		panic("tls: message too large for CCM")
+	}
+	ret, out := sliceForAppend(dst, len(plaintext)+c.tagSize)
+
+	tag := c.mac(nonce, plaintext, additionalData)
+	a := c.counter(nonce, 1)
+	cipher.NewCTR(c.b, a[:]).XORKeyStream(out, plaintext)
+	a = c.counter(nonce, 0)
+	var s0 [16]byte
+	c.b.Encrypt(s0[:], a[:])
+	subtle.XORBytes(out[len(plaintext):], tag[:c.tagSize], s0[:c.tagSize])
+	return ret
+}
+
+func (c *ccm) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != c.nonceSize {
+		panic("tls: incorrect nonce length given to CCM")
+	}
+	if len(ciphertext) < c.tagSize || uint64(len(ciphertext)-c.tagSize) > c.maxLength() {
+		return nil, errCCMOpen
+	}
+	tag := ciphertext[len(ciphertext)-c.tagSize:]
+	ciphertext = ciphertext[:len(ciphertext)-c.tagSize]
+	ret, out := sliceForAppend(dst, len(ciphertext))
+
+	a := c.counter(nonce, 1)
+	cipher.NewCTR(c.b, a[:]).XORKeyStream(out, ciphertext)
+	expected := c.mac(nonce, out, additionalData)
+	a = c.counter(nonce, 0)
+	var s0 [16]byte
+	c.b.Encrypt(s0[:], a[:])
+	subtle.XORBytes(expected[:c.tagSize], expected[:c.tagSize], s0[:c.tagSize])
+	if subtle.ConstantTimeCompare(expected[:c.tagSize], tag) != 1 {
+		for i := range out {
+			out[i] = 0
+		}
+		return nil, errCCMOpen
+	}
+	return ret, nil
+}
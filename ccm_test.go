@@ -0,0 +1,171 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// Test vectors from NIST SP 800-38C, Appendix C, and RFC 3610, Section 8.
+var ccmTests = []struct {
+	key, nonce, ad, plaintext, ciphertext string
+	tagSize                               int
+}{
+	{
+		"404142434445464748494a4b4c4d4e4f", "10111213141516", "0001020304050607",
+		"20212223", "7162015b4dac255d", 4,
+	},
+	{
+		"404142434445464748494a4b4c4d4e4f", "1011121314151617", "000102030405060708090a0b0c0d0e0f",
+		"202122232425262728292a2b2c2d2e2f", "d2a1f0e051ea5f62081a7792073d593d1fc64fbfaccd", 6,
+	},
+	{
+		"404142434445464748494a4b4c4d4e4f", "101112131415161718191a1b", "000102030405060708090a0b0c0d0e0f10111213",
+		"202122232425262728292a2b2c2d2e2f3031323334353637", "e3b201a9f5b71a7a9b1ceaeccd97e70b6176aad9a4428aa5484392fbc1b09951", 8,
+	},
+	{
+		"c0c1c2c3c4c5c6c7c8c9cacbcccdcecf", "00000003020100a0a1a2a3a4a5", "0001020304050607",
+		"08090a0b0c0d0e0f101112131415161718191a1b1c1d1e",
+		"588c979a61c663d2f066d0c2c0f989806d5f6b61dac38417e8d12cfdf926e0", 8,
+	},
+}
+
+func TestCCM(t *testing.T) {
+	for i, tt := range ccmTests {
+		decode := func(s string) []byte {
+			b, err := hex.DecodeString(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return b
+		}
+		key, nonce, ad := decode(tt.key), decode(tt.nonce), decode(tt.ad)
+		plaintext, ciphertext := decode(tt.plaintext), decode(tt.ciphertext)
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		aead, err := newCCM(block, len(nonce), tt.tagSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := aead.Seal(nil, nonce, plaintext, ad); !bytes.Equal(got, ciphertext) {
+			t.Errorf("#%d: Seal = %x, want %x", i, got, ciphertext)
+		}
+		got, err := aead.Open(nil, nonce, ciphertext, ad)
+		if err != nil || !bytes.Equal(got, plaintext) {
+			t.Errorf("#%d: Open = %x, %v; want %x", i, got, err, plaintext)
+		}
+
+		// In place, as the record layer does.
+		buf := append([]byte(nil), ciphertext...)
+		if got, err := aead.Open(buf[:0], nonce, buf, ad); err != nil || !bytes.Equal(got, plaintext) {
+			t.Errorf("#%d: in-place Open = %x, %v; want %x", i, got, err, plaintext)
+		}
+
+		for _, tamper := range []int{0, len(ciphertext) - 1} {
+			bad := append([]byte(nil), ciphertext...)
+			bad[tamper] ^= 1
+			if _, err := aead.Open(nil, nonce, bad, ad); err == nil {
+				t.Errorf("#%d: Open accepted a ciphertext modified at byte %d", i, tamper)
+			}
+		}
+		if _, err := aead.Open(nil, nonce, ciphertext, append(ad, 0)); err == nil {
+			t.Errorf("#%d: Open accepted modified additional data", i)
+		}
+	}
+}
+
+func TestCCMLongAdditionalData(t *testing.T) {
+	block, err := aes.NewCipher(make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := newCCM(block, 12, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, 12)
+	// 0xff00 bytes and more take the six-byte additional data length.
+	for _, n := range []int{0, 1, 15, 16, 17, 0xfeff, 0xff00} {
+		ad := bytes.Repeat([]byte{'a'}, n)
+		sealed := aead.Seal(nil, nonce, []byte("hello"), ad)
+		if got, err := aead.Open(nil, nonce, sealed, ad); err != nil || string(got) != "hello" {
+			t.Errorf("%d bytes of additional data: Open = %q, %v", n, got, err)
+		}
+	}
+}
+
+func TestHandshakeAESCCM(t *testing.T) {
+	defer func(suites, noAES []uint16) {
+		defaultCipherSuitesTLS13, defaultCipherSuitesTLS13NoAES = suites, noAES
+	}(defaultCipherSuitesTLS13, defaultCipherSuitesTLS13NoAES)
+	// Make the client offer only TLS_AES_128_CCM_SHA256, as constrained
+	// devices do.
+	defaultCipherSuitesTLS13, defaultCipherSuitesTLS13NoAES = nil, nil
+
+	clientConfig := testConfig.Clone()
+	clientConfig.MinVersion = VersionTLS13
+	clientConfig.CipherSuites = []uint16{TLS_AES_128_CCM_SHA256}
+	c, s := localPipe(t)
+	client := Client(c, clientConfig)
+	serverConfig := testConfig.Clone()
+	serverConfig.CipherSuites = []uint16{TLS_AES_128_CCM_SHA256}
+	server := Server(s, serverConfig)
+	defer client.Close()
+	defer server.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 5)
+		_, err := server.Read(buf)
+		if err == nil {
+			_, err = server.Write(buf)
+		}
+		errs <- err
+	}()
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := client.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("got %q", buf)
+	}
+	if got := client.ConnectionState().CipherSuite; got != TLS_AES_128_CCM_SHA256 {
+		t.Errorf("negotiated %s, want TLS_AES_128_CCM_SHA256", CipherSuiteName(got))
+	}
+}
+
+// TestHandshakeAESCCMServerOptIn checks that servers don't select
+// TLS_AES_128_CCM_SHA256 unless it is in their Config.CipherSuites.
+func TestHandshakeAESCCMServerOptIn(t *testing.T) {
+	defer func(suites, noAES []uint16) {
+		defaultCipherSuitesTLS13, defaultCipherSuitesTLS13NoAES = suites, noAES
+	}(defaultCipherSuitesTLS13, defaultCipherSuitesTLS13NoAES)
+	defaultCipherSuitesTLS13, defaultCipherSuitesTLS13NoAES = nil, nil
+
+	clientConfig := testConfig.Clone()
+	clientConfig.MinVersion = VersionTLS13
+	clientConfig.CipherSuites = []uint16{TLS_AES_128_CCM_SHA256}
+	c, s := localPipe(t)
+	client := Client(c, clientConfig)
+	server := Server(s, testConfig.Clone())
+	defer client.Close()
+	defer server.Close()
+
+	errs := make(chan error, 1)
+	go func() { errs <- server.Handshake() }()
+	client.Handshake()
+	if err := <-errs; err == nil || !strings.Contains(err.Error(), "no cipher suite supported") {
+		t.Errorf("server handshake = %v, want no mutual cipher suite", err)
+	}
+}
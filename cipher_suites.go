@@ -17,7 +17,10 @@ import (
 	"hash"
 	"runtime"
 
+	"github.com/secure-for-ai/goktls/internal/aria"
 	"github.com/secure-for-ai/goktls/internal/boring"
+	"github.com/secure-for-ai/goktls/internal/sm3"
+	"github.com/secure-for-ai/goktls/internal/sm4"
 	"golang.org/x/crypto/chacha20poly1305"
 	"golang.org/x/sys/cpu"
 )
@@ -57,9 +60,13 @@ func CipherSuites() []*CipherSuite {
 		{TLS_RSA_WITH_AES_128_GCM_SHA256, "TLS_RSA_WITH_AES_128_GCM_SHA256", supportedOnlyTLS12, false},
 		{TLS_RSA_WITH_AES_256_GCM_SHA384, "TLS_RSA_WITH_AES_256_GCM_SHA384", supportedOnlyTLS12, false},
 
+		{TLS_SM4_GCM_SM3, "TLS_SM4_GCM_SM3", supportedOnlyTLS13, false},
+		{TLS_SM4_CCM_SM3, "TLS_SM4_CCM_SM3", supportedOnlyTLS13, false},
+
 		{TLS_AES_128_GCM_SHA256, "TLS_AES_128_GCM_SHA256", supportedOnlyTLS13, false},
 		{TLS_AES_256_GCM_SHA384, "TLS_AES_256_GCM_SHA384", supportedOnlyTLS13, false},
 		{TLS_CHACHA20_POLY1305_SHA256, "TLS_CHACHA20_POLY1305_SHA256", supportedOnlyTLS13, false},
+		{TLS_AES_128_CCM_SHA256, "TLS_AES_128_CCM_SHA256", supportedOnlyTLS13, false},
 
 		{TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA, "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA", supportedUpToTLS12, false},
 		{TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA, "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA", supportedUpToTLS12, false},
@@ -69,6 +76,12 @@ func CipherSuites() []*CipherSuite {
 		{TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", supportedOnlyTLS12, false},
 		{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", supportedOnlyTLS12, false},
 		{TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384", supportedOnlyTLS12, false},
+		{TLS_RSA_WITH_ARIA_128_GCM_SHA256, "TLS_RSA_WITH_ARIA_128_GCM_SHA256", supportedOnlyTLS12, false},
+		{TLS_RSA_WITH_ARIA_256_GCM_SHA384, "TLS_RSA_WITH_ARIA_256_GCM_SHA384", supportedOnlyTLS12, false},
+		{TLS_ECDHE_ECDSA_WITH_ARIA_128_GCM_SHA256, "TLS_ECDHE_ECDSA_WITH_ARIA_128_GCM_SHA256", supportedOnlyTLS12, false},
+		{TLS_ECDHE_ECDSA_WITH_ARIA_256_GCM_SHA384, "TLS_ECDHE_ECDSA_WITH_ARIA_256_GCM_SHA384", supportedOnlyTLS12, false},
+		{TLS_ECDHE_RSA_WITH_ARIA_128_GCM_SHA256, "TLS_ECDHE_RSA_WITH_ARIA_128_GCM_SHA256", supportedOnlyTLS12, false},
+		{TLS_ECDHE_RSA_WITH_ARIA_256_GCM_SHA384, "TLS_ECDHE_RSA_WITH_ARIA_256_GCM_SHA384", supportedOnlyTLS12, false},
 		{TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256, "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256", supportedOnlyTLS12, false},
 		{TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256, "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256", supportedOnlyTLS12, false},
 	}
@@ -171,6 +184,20 @@ var cipherSuites = []*cipherSuite{ // TODO: replace with a map, since the order
 	{TLS_ECDHE_ECDSA_WITH_RC4_128_SHA, 16, 20, 0, ecdheECDSAKA, suiteECDHE | suiteECSign, cipherRC4, macSHA1, nil},
 }
 
+// optionalCipherSuites are the TLS 1.2 cipher suites that are neither in
+// the preference order nor used by default: they are offered and selected
+// only when listed in Config.CipherSuites, after every other listed suite.
+// They are for deployments required to use them, such as the ARIA suites
+// of RFC 6209 in Korean government networks.
+var optionalCipherSuites = []*cipherSuite{
+	{TLS_ECDHE_ECDSA_WITH_ARIA_128_GCM_SHA256, 16, 0, 4, ecdheECDSAKA, suiteECDHE | suiteECSign | suiteTLS12, nil, nil, aeadARIAGCM},
+	{TLS_ECDHE_RSA_WITH_ARIA_128_GCM_SHA256, 16, 0, 4, ecdheRSAKA, suiteECDHE | suiteTLS12, nil, nil, aeadARIAGCM},
+	{TLS_ECDHE_ECDSA_WITH_ARIA_256_GCM_SHA384, 32, 0, 4, ecdheECDSAKA, suiteECDHE | suiteECSign | suiteTLS12 | suiteSHA384, nil, nil, aeadARIAGCM},
+	{TLS_ECDHE_RSA_WITH_ARIA_256_GCM_SHA384, 32, 0, 4, ecdheRSAKA, suiteECDHE | suiteTLS12 | suiteSHA384, nil, nil, aeadARIAGCM},
+	{TLS_RSA_WITH_ARIA_128_GCM_SHA256, 16, 0, 4, rsaKA, suiteTLS12, nil, nil, aeadARIAGCM},
+	{TLS_RSA_WITH_ARIA_256_GCM_SHA384, 32, 0, 4, rsaKA, suiteTLS12 | suiteSHA384, nil, nil, aeadARIAGCM},
+}
+
 // selectCipherSuite returns the first TLS 1.0–1.2 cipher suite from ids which
 // is also in supportedIDs and passes the ok filter.
 func selectCipherSuite(ids, supportedIDs []uint16, ok func(*cipherSuite) bool) *cipherSuite {
@@ -195,13 +222,29 @@ type cipherSuiteTLS13 struct {
 	id     uint16
 	keyLen int
 	aead   func(key, fixedNonce []byte) aead
-	hash   crypto.Hash
+	hash   suiteHash
+}
+
+// A suiteHash is the hash of a TLS 1.3 cipher suite: a crypto.Hash, or
+// sm3Hash, which crypto.Hash cannot represent.
+type suiteHash interface {
+	New() hash.Hash
+	Size() int
 }
 
+// sm3Hash is the SM3 hash of the cipher suites of RFC 8998.
+type sm3Hash struct{}
+
+func (sm3Hash) New() hash.Hash { return sm3.New() }
+func (sm3Hash) Size() int      { return sm3.Size }
+
 var cipherSuitesTLS13 = []*cipherSuiteTLS13{ // TODO: replace with a map.
 	{TLS_AES_128_GCM_SHA256, 16, aeadAESGCMTLS13, crypto.SHA256},
 	{TLS_CHACHA20_POLY1305_SHA256, 32, aeadChaCha20Poly1305, crypto.SHA256},
 	{TLS_AES_256_GCM_SHA384, 32, aeadAESGCMTLS13, crypto.SHA384},
+	{TLS_AES_128_CCM_SHA256, 16, aeadAESCCMTLS13, crypto.SHA256},
+	{TLS_SM4_GCM_SM3, 16, aeadSM4GCMTLS13, sm3Hash{}},
+	{TLS_SM4_CCM_SM3, 16, aeadSM4CCMTLS13, sm3Hash{}},
 }
 
 // cipherSuitesPreferenceOrder is the order in which we'll select (on the
@@ -354,6 +397,32 @@ var defaultCipherSuitesTLS13NoAES = []uint16{
 	TLS_AES_256_GCM_SHA384,
 }
 
+// optionalCipherSuitesTLS13 are the TLS 1.3 cipher suites that are not in
+// the defaults: they are offered and selected only when listed in
+// Config.CipherSuites, after the default suites. They are for constrained
+// devices and deployments required to use the ShangMi suites of RFC 8998,
+// and let those connections use kernel TLS too. The SM4 implementation is
+// not constant time.
+var optionalCipherSuitesTLS13 = []uint16{
+	TLS_AES_128_CCM_SHA256,
+	TLS_SM4_GCM_SM3,
+	TLS_SM4_CCM_SM3,
+}
+
+// appendOptionalCipherSuitesTLS13 appends to suites the optional TLS 1.3
+// cipher suites listed in configured.
+func appendOptionalCipherSuitesTLS13(suites, configured []uint16) []uint16 {
+	for _, id := range optionalCipherSuitesTLS13 {
+		for _, c := range configured {
+			if c == id {
+				suites = append(suites, id)
+				break
+			}
+		}
+	}
+	return suites
+}
+
 var (
 	hasGCMAsmAMD64 = cpu.X86.HasAES && cpu.X86.HasPCLMULQDQ
 	hasGCMAsmARM64 = cpu.ARM64.HasAES && cpu.ARM64.HasPMULL
@@ -550,6 +619,80 @@ func aeadAESGCMTLS13(key, nonceMask []byte) aead {
 	return ret
 }
 
+func aeadAESCCMTLS13(key, nonceMask []byte) aead {
+	if len(nonceMask) != aeadNonceLength {
+		panic("tls: internal error: wrong nonce length")
+	}
+	aes, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := newCCM(aes, aeadNonceLength, 16)
+	if err != nil {
+		panic(err)
+	}
+
+	ret := &xorNonceAEAD{aead: aead}
+	copy(ret.nonceMask[:], nonceMask)
+	return ret
+}
+
+// aeadARIAGCM is the AEAD of the ARIA-GCM suites, which RFC 6209 builds
+// like the AES-GCM ones.
+func aeadARIAGCM(key, noncePrefix []byte) aead {
+	if len(noncePrefix) != noncePrefixLength {
+		panic("tls: internal error: wrong nonce length")
+	}
+	aria, err := aria.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(aria)
+	if err != nil {
+		panic(err)
+	}
+
+	ret := &prefixNonceAEAD{aead: aead}
+	copy(ret.nonce[:], noncePrefix)
+	return ret
+}
+
+func aeadSM4GCMTLS13(key, nonceMask []byte) aead {
+	if len(nonceMask) != aeadNonceLength {
+		panic("tls: internal error: wrong nonce length")
+	}
+	sm4, err := sm4.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(sm4)
+	if err != nil {
+		panic(err)
+	}
+
+	ret := &xorNonceAEAD{aead: aead}
+	copy(ret.nonceMask[:], nonceMask)
+	return ret
+}
+
+func aeadSM4CCMTLS13(key, nonceMask []byte) aead {
+	if len(nonceMask) != aeadNonceLength {
+		panic("tls: internal error: wrong nonce length")
+	}
+	sm4, err := sm4.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := newCCM(sm4, aeadNonceLength, 16)
+	if err != nil {
+		panic(err)
+	}
+
+	ret := &xorNonceAEAD{aead: aead}
+	copy(ret.nonceMask[:], nonceMask)
+	return ret
+}
+
 func aeadChaCha20Poly1305(key, nonceMask []byte) aead {
 	if len(nonceMask) != aeadNonceLength {
 		panic("tls: internal error: wrong nonce length")
@@ -636,6 +779,11 @@ func cipherSuiteByID(id uint16) *cipherSuite {
 			return cipherSuite
 		}
 	}
+	for _, cipherSuite := range optionalCipherSuites {
+		if cipherSuite.id == id {
+			return cipherSuite
+		}
+	}
 	return nil
 }
 
@@ -690,6 +838,19 @@ const (
 	TLS_AES_128_GCM_SHA256       uint16 = 0x1301
 	TLS_AES_256_GCM_SHA384       uint16 = 0x1302
 	TLS_CHACHA20_POLY1305_SHA256 uint16 = 0x1303
+	TLS_AES_128_CCM_SHA256       uint16 = 0x1304
+
+	// TLS 1.3 ShangMi cipher suites, see RFC 8998.
+	TLS_SM4_GCM_SM3 uint16 = 0x00c6
+	TLS_SM4_CCM_SM3 uint16 = 0x00c7
+
+	// TLS 1.2 ARIA cipher suites, see RFC 6209.
+	TLS_RSA_WITH_ARIA_128_GCM_SHA256         uint16 = 0xc050
+	TLS_RSA_WITH_ARIA_256_GCM_SHA384         uint16 = 0xc051
+	TLS_ECDHE_ECDSA_WITH_ARIA_128_GCM_SHA256 uint16 = 0xc05c
+	TLS_ECDHE_ECDSA_WITH_ARIA_256_GCM_SHA384 uint16 = 0xc05d
+	TLS_ECDHE_RSA_WITH_ARIA_128_GCM_SHA256   uint16 = 0xc060
+	TLS_ECDHE_RSA_WITH_ARIA_256_GCM_SHA384   uint16 = 0xc061
 
 	// TLS_FALLBACK_SCSV isn't a standard cipher suite but an indicator
 	// that the client is doing version fallback. See RFC 7507.
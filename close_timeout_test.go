@@ -0,0 +1,49 @@
+package tls
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCloseTimeout(t *testing.T) {
+	c, s := localPipe(t)
+	defer s.Close()
+	config := testConfig.Clone()
+	config.CloseTimeout = 100 * time.Millisecond
+	client := Client(c, config)
+	server := Server(s, testConfig)
+	errs := make(chan error, 1)
+	go func() { errs <- server.Handshake() }()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	// The server no longer reads: fill the socket buffers so that the
+	// close_notify can't be sent.
+	junk := make([]byte, 64<<10)
+	for size := len(junk); ; size = 1 {
+		wrote := false
+		c.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+		for {
+			if _, err := c.Write(junk[:size]); err != nil {
+				break
+			}
+			wrote = true
+		}
+		if !wrote && size == 1 {
+			break
+		}
+	}
+	c.SetWriteDeadline(time.Time{})
+
+	start := time.Now()
+	if err := client.Close(); err == nil {
+		t.Error("Close reported close_notify as sent")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Close took %v with a CloseTimeout of 100ms", d)
+	}
+}
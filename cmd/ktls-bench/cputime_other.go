@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "time"
+
+// cpuTime reports no CPU time where getrusage is not available.
+func cpuTime() time.Duration {
+	return 0
+}
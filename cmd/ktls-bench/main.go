@@ -0,0 +1,386 @@
+// Command ktls-bench measures the throughput and CPU cost of TLS with and
+// without kernel TLS offload, to decide whether offload helps a workload.
+//
+// Each case transfers -size bytes over a fresh connection. The send cases
+// write from the client, either with Write or with sendfile from a
+// temporary file, and the recv cases read on the client; the client
+// offloads nothing (off), only the direction under test (tx or rx):
+//
+//	ktls-bench [-size 1GiB] [-cases send/off/write,send/tx/sendfile,...] [-cipher TLS_AES_128_GCM_SHA256]
+//	ktls-bench -listen :4433 [-ktls=false]
+//	ktls-bench -connect host:4433 [-size 1GiB] [-cases ...]
+//
+// By default the server runs in the same process over loopback, with
+// offload off, and the CPU time includes both sides. With -listen and
+// -connect, the server runs elsewhere and the CPU time is the client's.
+// The server uses a self-signed certificate, which the client doesn't
+// verify.
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	tls "github.com/secure-for-ai/goktls"
+)
+
+// A benchCase is a transfer measured by ktls-bench.
+type benchCase struct {
+	name string
+	send bool   // the client sends, rather than receives
+	ktls string // what the client offloads: "off", "tx" or "rx"
+	file bool   // the client sends with sendfile from a file
+}
+
+var allCases = []benchCase{
+	{"send/off/write", true, "off", false},
+	{"send/tx/write", true, "tx", false},
+	{"send/off/sendfile", true, "off", true},
+	{"send/tx/sendfile", true, "tx", true},
+	{"recv/off", false, "off", false},
+	{"recv/rx", false, "rx", false},
+}
+
+// Operations the client requests from the server, followed by the size
+// of the transfer as a big-endian uint64.
+const (
+	opServerSend = 's'
+	opServerRecv = 'r'
+)
+
+const bufSize = 256 << 10
+
+// parseSize parses a byte count with an optional KiB, MiB or GiB suffix.
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}} {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mult = strings.TrimSuffix(s, u.suffix), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
+
+// selectCases returns the cases named in the comma-separated list, or all
+// of them if it is empty.
+func selectCases(list string) ([]benchCase, error) {
+	if list == "" {
+		return allCases, nil
+	}
+	var cases []benchCase
+	for _, name := range strings.Split(list, ",") {
+		found := false
+		for _, c := range allCases {
+			if c.name == name {
+				cases, found = append(cases, c), true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown case %q", name)
+		}
+	}
+	return cases, nil
+}
+
+// clientConfig returns the client configuration of c.
+func (c benchCase) clientConfig(suite uint16) *tls.Config {
+	config := &tls.Config{InsecureSkipVerify: true}
+	if suite != 0 {
+		config.CipherSuites = []uint16{suite}
+		config.KTLSCipherSuites = []uint16{suite}
+	}
+	switch c.ktls {
+	case "off":
+		config.KTLSMode = tls.KTLSModeOff
+	case "tx":
+		config.KTLSRXDisabled = true
+	case "rx":
+		config.KTLSTXDisabled = true
+	}
+	return config
+}
+
+// cipherSuiteID returns the ID of the named cipher suite, or 0 for "".
+func cipherSuiteID(name string) (uint16, error) {
+	if name == "" {
+		return 0, nil
+	}
+	for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		if s.Name == name {
+			return s.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown cipher suite %q", name)
+}
+
+// A result is the outcome of a benchCase.
+type result struct {
+	bytes   int64
+	elapsed time.Duration
+	cpu     time.Duration
+	state   tls.KTLSState
+}
+
+func (r result) String() string {
+	secs := r.elapsed.Seconds()
+	offload := "none"
+	switch {
+	case r.state.TXEnabled && r.state.RXEnabled:
+		offload = "TX+RX"
+	case r.state.TXEnabled:
+		offload = "TX"
+	case r.state.RXEnabled:
+		offload = "RX"
+	}
+	return fmt.Sprintf("%8.2fs %10.1f MB/s %8.2fs CPU %8.2fs CPU/GB  offload %s",
+		secs, float64(r.bytes)/secs/1e6, r.cpu.Seconds(),
+		r.cpu.Seconds()/(float64(r.bytes)/1e9), offload)
+}
+
+// run performs c against the server at addr.
+func run(c benchCase, addr string, suite uint16, size int64, file *os.File) (result, error) {
+	conn, err := tls.Dial("tcp", addr, c.clientConfig(suite))
+	if err != nil {
+		return result{}, err
+	}
+	defer conn.Close()
+
+	var hdr [9]byte
+	hdr[0] = opServerSend
+	if c.send {
+		hdr[0] = opServerRecv
+	}
+	binary.BigEndian.PutUint64(hdr[1:], uint64(size))
+
+	start, startCPU := time.Now(), cpuTime()
+	if _, err := conn.Write(hdr[:]); err != nil {
+		return result{}, err
+	}
+	if c.send {
+		err = send(conn, size, file, c.file)
+		if err == nil {
+			// Wait for the server to confirm it received everything.
+			_, err = io.ReadFull(conn, hdr[:1])
+		}
+	} else {
+		err = recv(conn, size)
+	}
+	if err != nil {
+		return result{}, err
+	}
+	return result{
+		bytes:   size,
+		elapsed: time.Since(start),
+		cpu:     cpuTime() - startCPU,
+		state:   conn.ConnectionState().KTLS,
+	}, nil
+}
+
+// send writes size bytes to conn, with sendfile from file if useFile is
+// set.
+func send(conn *tls.Conn, size int64, file *os.File, useFile bool) error {
+	if useFile {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		n, err := conn.ReadFrom(io.LimitReader(file, size))
+		if err == nil && n < size {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	buf := make([]byte, bufSize)
+	for size > 0 {
+		n := int64(len(buf))
+		if n > size {
+			n = size
+		}
+		if _, err := conn.Write(buf[:n]); err != nil {
+			return err
+		}
+		size -= n
+	}
+	return nil
+}
+
+// recv reads size bytes from conn.
+func recv(conn *tls.Conn, size int64) error {
+	n, err := io.CopyBuffer(io.Discard, io.LimitReader(struct{ io.Reader }{conn}, size), make([]byte, bufSize))
+	if err == nil && n < size {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// serve answers the requests of ktls-bench clients on ln.
+func serve(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Print(err)
+			}
+			return
+		}
+		go func() {
+			defer conn.Close()
+			if err := handle(conn.(*tls.Conn)); err != nil {
+				log.Print(err)
+			}
+		}()
+	}
+}
+
+func handle(conn *tls.Conn) error {
+	var hdr [9]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return err
+	}
+	size := int64(binary.BigEndian.Uint64(hdr[1:]))
+	switch hdr[0] {
+	case opServerSend:
+		return send(conn, size, nil, false)
+	case opServerRecv:
+		if err := recv(conn, size); err != nil {
+			return err
+		}
+		_, err := conn.Write(hdr[:1])
+		return err
+	}
+	return fmt.Errorf("unknown operation %q", hdr[0])
+}
+
+// selfSigned returns a self-signed ECDSA P-256 certificate.
+func selfSigned() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ktls-bench"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// tempFile returns an unlinked temporary file holding size bytes.
+func tempFile(size int64) (*os.File, error) {
+	f, err := os.CreateTemp("", "ktls-bench")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(f.Name())
+	buf := make([]byte, bufSize)
+	rand.Read(buf)
+	for written := int64(0); written < size; written += int64(len(buf)) {
+		if _, err := f.Write(buf); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func main() {
+	sizeFlag := flag.String("size", "1GiB", "bytes transferred per case, with an optional KiB, MiB or GiB suffix")
+	casesFlag := flag.String("cases", "", "comma-separated `cases` to run; all if empty")
+	cipher := flag.String("cipher", "", "cipher suite to negotiate, such as TLS_AES_128_GCM_SHA256")
+	listen := flag.String("listen", "", "run a server on `address` for remote clients")
+	connect := flag.String("connect", "", "benchmark against the server at `address` instead of over loopback")
+	serverKTLS := flag.Bool("ktls", true, "with -listen, offload the server side to the kernel")
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("ktls-bench: ")
+
+	cert, err := selfSigned()
+	if err != nil {
+		log.Fatal(err)
+	}
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if *listen != "" {
+		if !*serverKTLS {
+			serverConfig.KTLSMode = tls.KTLSModeOff
+		}
+		ln, err := tls.Listen("tcp", *listen, serverConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		serve(ln)
+		return
+	}
+
+	size, err := parseSize(*sizeFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cases, err := selectCases(*casesFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	suite, err := cipherSuiteID(*cipher)
+	if err != nil {
+		log.Fatal(err)
+	}
+	addr := *connect
+	if addr == "" {
+		serverConfig.KTLSMode = tls.KTLSModeOff
+		ln, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer ln.Close()
+		go serve(ln)
+		addr = ln.Addr().String()
+	}
+
+	var file *os.File
+	for _, c := range cases {
+		if c.file && file == nil {
+			if file, err = tempFile(size); err != nil {
+				log.Fatal(err)
+			}
+			defer file.Close()
+		}
+	}
+	if reason := tls.KTLSUnsupportedReason(); reason != "" {
+		log.Printf("kernel TLS unavailable, offload cases run in user space: %s", reason)
+	}
+	for _, c := range cases {
+		r, err := run(c, addr, suite, size, file)
+		if err != nil {
+			log.Fatalf("%s: %v", c.name, err)
+		}
+		fmt.Printf("%-20s %s\n", c.name, r)
+	}
+}
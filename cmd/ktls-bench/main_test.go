@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+
+	tls "github.com/secure-for-ai/goktls"
+)
+
+func TestParseSize(t *testing.T) {
+	for in, want := range map[string]int64{"100": 100, "4KiB": 4 << 10, "2MiB": 2 << 20, "1GiB": 1 << 30} {
+		if got, err := parseSize(in); err != nil || got != want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "0", "-1", "1TiB", "KiB"} {
+		if _, err := parseSize(in); err == nil {
+			t.Errorf("parseSize(%q) succeeded", in)
+		}
+	}
+}
+
+func TestSelectCases(t *testing.T) {
+	cases, err := selectCases("recv/rx,send/tx/sendfile")
+	if err != nil || len(cases) != 2 || cases[0].name != "recv/rx" || !cases[1].file {
+		t.Errorf("selectCases = %v, %v", cases, err)
+	}
+	if _, err := selectCases("send/rx"); err == nil {
+		t.Error("unknown case accepted")
+	}
+}
+
+func TestLoopback(t *testing.T) {
+	cert, err := selfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go serve(ln)
+
+	const size = 1<<20 + 17
+	file, err := tempFile(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	for _, c := range allCases {
+		r, err := run(c, ln.Addr().String(), 0, size, file)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if r.bytes != size || r.elapsed <= 0 {
+			t.Errorf("%s: %+v", c.name, r)
+		}
+		if c.ktls == "off" && (r.state.TXEnabled || r.state.RXEnabled) {
+			t.Errorf("%s: offloaded with KTLSModeOff", c.name)
+		}
+	}
+}
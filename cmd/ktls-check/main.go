@@ -0,0 +1,249 @@
+// Command ktls-check reports whether kernel TLS can be used on this host
+// and, if not, why.
+//
+// It prints the kernel release, the state of the tls module and upper
+// layer protocol, the ciphers and features the kernel accepted when probed
+// on loopback connections, the TLS offload features of the network
+// interfaces, the sysctls that affect kernel TLS, the outcome of
+// tls.ProbeKTLS, which sends data over loopback connections offloaded to
+// the kernel, and hints derived from them:
+//
+//	ktls-check [-json]
+//
+// It exits with status 1 if kernel TLS can't be used.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	tls "github.com/secure-for-ai/goktls"
+)
+
+// procSys is where sysctls are read from. Tests replace it.
+var procSys = "/proc/sys"
+
+// sysctls are the sysctls that affect kernel TLS.
+var sysctls = []string{
+	"net/ipv4/tcp_available_ulp", // must list tls
+	"net/core/optmem_max",        // bounds the control messages of records
+	"net/ipv4/tcp_rmem",
+	"net/ipv4/tcp_wmem",
+	"fs/pipe-max-size", // bounds the pipes of splice
+}
+
+// A result is the output of ktls-check.
+type result struct {
+	tls.KTLSReport
+	// FromKernelVersion is true if the kernel could not be probed and the
+	// features were derived from its version.
+	FromKernelVersion bool              `json:"from_kernel_version,omitempty"`
+	Sysctls           map[string]string `json:"sysctls,omitempty"`
+	// Probe is the outcome of tls.ProbeKTLS.
+	Probe string   `json:"probe,omitempty"`
+	Hints []string `json:"hints,omitempty"`
+}
+
+func check() *result {
+	r := &result{
+		KTLSReport:        tls.Report(),
+		FromKernelVersion: tls.KTLSCapabilities().FromKernelVersion,
+		Sysctls:           readSysctls(),
+	}
+	if probe, err := tls.ProbeKTLS(); err != nil {
+		r.Probe = "failed: " + err.Error()
+	} else {
+		r.Probe = strings.TrimSuffix(probe.String(), "\n")
+	}
+	r.Hints = hints(r)
+	return r
+}
+
+// readSysctls reads sysctls from procSys, skipping those that don't exist.
+func readSysctls() map[string]string {
+	values := make(map[string]string)
+	for _, name := range sysctls {
+		b, err := os.ReadFile(filepath.Join(procSys, name))
+		if err != nil {
+			continue
+		}
+		values[strings.ReplaceAll(name, "/", ".")] = strings.Join(strings.Fields(string(b)), " ")
+	}
+	return values
+}
+
+// hints explains the findings of r that commonly keep kernel TLS from being
+// enabled or from performing well.
+func hints(r *result) []string {
+	var h []string
+	f := r.Features
+	if r.UnsupportedReason != "" {
+		h = append(h, "kernel TLS is unavailable: "+r.UnsupportedReason)
+	}
+	if ulps, ok := r.Sysctls["net.ipv4.tcp_available_ulp"]; ok && !containsField(ulps, "tls") &&
+		!strings.Contains(r.UnsupportedReason, "tcp_available_ulp") {
+		h = append(h, "the tls upper layer protocol is not registered: load it with modprobe tls, or grant CAP_NET_ADMIN for autoloading")
+	}
+	if r.FromKernelVersion {
+		h = append(h, "probing on loopback failed, features are guessed from the kernel version")
+	}
+	if r.UnsupportedReason != "" {
+		return h
+	}
+	if !f.RX {
+		h = append(h, "the kernel can't offload TLS_RX: received records are decrypted in user space")
+	}
+	if f.TLS13TX && !f.TLS13RX {
+		h = append(h, "TLS 1.3 TLS_RX needs Linux 6.0: TLS 1.3 connections decrypt in user space")
+	}
+	if !f.TLS13TX {
+		h = append(h, "TLS 1.3 offload needs Linux 5.1: only TLS 1.2 connections are offloaded")
+	}
+	if !f.ChaCha20Poly1305 {
+		h = append(h, "ChaCha20-Poly1305 offload needs Linux 5.11")
+	}
+	if !f.TXZerocopy {
+		h = append(h, "TLS_TX_ZEROCOPY_RO is unsupported: sendfile copies file pages before encrypting them")
+	}
+	device := false
+	for _, iface := range r.Interfaces {
+		device = device || iface.Features["tls-hw-tx-offload"] || iface.Features["tls-hw-rx-offload"]
+	}
+	if !device {
+		h = append(h, "no interface has TLS offload active: the kernel encrypts in software")
+	}
+	return h
+}
+
+func containsField(s, field string) bool {
+	for _, f := range strings.Fields(s) {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// features lists the kernel TLS features by name, in a fixed order.
+func features(f tls.KTLSFeatures) []struct {
+	name string
+	ok   bool
+} {
+	return []struct {
+		name string
+		ok   bool
+	}{
+		{"TLS 1.2 TX", f.TX},
+		{"TLS 1.2 RX", f.RX},
+		{"TLS 1.3 TX", f.TLS13TX},
+		{"TLS 1.3 RX", f.TLS13RX},
+		{"AES-GCM-128", f.AESGCM128},
+		{"AES-GCM-256", f.AESGCM256},
+		{"AES-CCM-128", f.AESCCM128},
+		{"ChaCha20-Poly1305", f.ChaCha20Poly1305},
+		{"SM4-GCM/SM4-CCM", f.SM4},
+		{"ARIA-GCM", f.ARIAGCM},
+		{"TX zerocopy sendfile", f.TXZerocopy},
+		{"RX expect no pad", f.RXNoPad},
+		{"TLS 1.3 KeyUpdate", f.KeyUpdate},
+	}
+}
+
+func yesNo(ok bool) string {
+	if ok {
+		return "yes"
+	}
+	return "no"
+}
+
+// printResult writes r as text.
+func printResult(w io.Writer, r *result) {
+	fmt.Fprintf(w, "go:     %s %s/%s\n", r.GoVersion, r.GOOS, r.GOARCH)
+	fmt.Fprintf(w, "kernel: %s\n", r.Kernel)
+	if r.UnsupportedReason != "" {
+		fmt.Fprintf(w, "kernel TLS: unavailable: %s\n", r.UnsupportedReason)
+	} else {
+		fmt.Fprintf(w, "kernel TLS: available\n")
+	}
+	if len(r.Module) > 0 {
+		fmt.Fprintf(w, "\ntls module:\n")
+		for _, k := range sortedKeys(r.Module) {
+			fmt.Fprintf(w, "  %-24s %s\n", k, r.Module[k])
+		}
+	}
+	source := "probed"
+	if r.FromKernelVersion {
+		source = "from kernel version"
+	}
+	fmt.Fprintf(w, "\nfeatures (%s):\n", source)
+	for _, f := range features(r.Features) {
+		fmt.Fprintf(w, "  %-24s %s\n", f.name, yesNo(f.ok))
+	}
+	for _, iface := range r.Interfaces {
+		fmt.Fprintf(w, "\ninterface %s:\n", iface.Name)
+		if len(iface.Features) == 0 {
+			fmt.Fprintf(w, "  no TLS offload features\n")
+		}
+		for _, k := range sortedKeys(iface.Features) {
+			fmt.Fprintf(w, "  %-24s %s\n", k, yesNo(iface.Features[k]))
+		}
+	}
+	if len(r.Sysctls) > 0 {
+		fmt.Fprintf(w, "\nsysctls:\n")
+		for _, k := range sortedKeys(r.Sysctls) {
+			fmt.Fprintf(w, "  %-28s %s\n", k, r.Sysctls[k])
+		}
+	}
+	if r.Probe != "" {
+		fmt.Fprintf(w, "\nloopback probe:\n")
+		for _, line := range strings.Split(r.Probe, "\n") {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+	if len(r.RecentErrors) > 0 || len(r.Errors) > 0 {
+		fmt.Fprintf(w, "\nerrors:\n")
+		for _, e := range r.RecentErrors {
+			fmt.Fprintf(w, "  %s\n", e.Error)
+		}
+		for _, e := range r.Errors {
+			fmt.Fprintf(w, "  %s\n", e)
+		}
+	}
+	if len(r.Hints) > 0 {
+		fmt.Fprintf(w, "\nhints:\n")
+		for _, h := range r.Hints {
+			fmt.Fprintf(w, "  - %s\n", h)
+		}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func main() {
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	r := check()
+	if *asJSON {
+		b, _ := json.MarshalIndent(r, "", "  ")
+		os.Stdout.Write(append(b, '\n'))
+	} else {
+		printResult(os.Stdout, r)
+	}
+	if r.UnsupportedReason != "" {
+		os.Exit(1)
+	}
+}
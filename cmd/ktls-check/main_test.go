@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tls "github.com/secure-for-ai/goktls"
+)
+
+func TestReadSysctls(t *testing.T) {
+	defer func(dir string) { procSys = dir }(procSys)
+	procSys = t.TempDir()
+	if err := os.MkdirAll(filepath.Join(procSys, "net/ipv4"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(procSys, "net/ipv4/tcp_wmem"), []byte("4096\t16384\t4194304\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got := readSysctls()
+	if len(got) != 1 || got["net.ipv4.tcp_wmem"] != "4096 16384 4194304" {
+		t.Errorf("readSysctls() = %v", got)
+	}
+}
+
+func TestHints(t *testing.T) {
+	r := &result{Sysctls: map[string]string{"net.ipv4.tcp_available_ulp": "mptcp"}}
+	r.UnsupportedReason = "kernel tls module not available"
+	h := hints(r)
+	if len(h) != 2 || !strings.Contains(h[1], "modprobe tls") {
+		t.Errorf("hints for a missing module = %q", h)
+	}
+
+	r = &result{Sysctls: map[string]string{"net.ipv4.tcp_available_ulp": "tls"}}
+	r.Features = tls.KTLSFeatures{TX: true, RX: true, TLS13TX: true, AESGCM128: true}
+	r.Interfaces = []tls.KTLSInterfaceReport{{Name: "eth0", Features: map[string]bool{"tls-hw-tx-offload": true}}}
+	h = hints(r)
+	for _, want := range []string{"Linux 6.0", "ChaCha20", "TLS_TX_ZEROCOPY_RO"} {
+		if !strings.Contains(strings.Join(h, "\n"), want) {
+			t.Errorf("hints = %q, missing %q", h, want)
+		}
+	}
+	for _, hint := range h {
+		if strings.Contains(hint, "modprobe") || strings.Contains(hint, "no interface") {
+			t.Errorf("unexpected hint %q", hint)
+		}
+	}
+
+	var b bytes.Buffer
+	printResult(&b, r)
+	if out := b.String(); !strings.Contains(out, "kernel TLS: available") || !strings.Contains(out, "tls-hw-tx-offload") {
+		t.Errorf("printResult output:\n%s", out)
+	}
+}
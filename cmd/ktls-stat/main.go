@@ -0,0 +1,209 @@
+// Command ktls-stat watches the kernel TLS counters while a workload runs.
+//
+// It polls /proc/net/tls_stat and, for the interfaces given with -i, the TLS
+// offload counters reported by "ethtool -S", and prints the counters that
+// changed since the previous poll with their delta and rate:
+//
+//	ktls-stat [-interval 1s] [-i eth0,eth1] [-all] [-push http://host:9091] [-job ktls-stat]
+//
+// With -push, every sample is also sent to a Prometheus Pushgateway, using
+// the metric ktls_stat{counter="..."} for /proc/net/tls_stat and
+// ktls_nic_stat{iface="...",counter="..."} for interface counters.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tls "github.com/secure-for-ai/goktls"
+)
+
+// A counterKey identifies a counter. Iface is empty for the counters of
+// /proc/net/tls_stat.
+type counterKey struct {
+	Iface string
+	Name  string
+}
+
+func (k counterKey) String() string {
+	if k.Iface == "" {
+		return k.Name
+	}
+	return k.Iface + "/" + k.Name
+}
+
+type sample struct {
+	at       time.Time
+	counters map[counterKey]uint64
+}
+
+// parseEthtoolStats parses the output of "ethtool -S", keeping the counters
+// whose name mentions TLS.
+func parseEthtoolStats(r io.Reader, iface string, counters map[counterKey]uint64) error {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		name, value, ok := strings.Cut(s.Text(), ":")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		if !strings.Contains(strings.ToLower(name), "tls") {
+			continue
+		}
+		v, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			continue // "NIC statistics:" and other headers
+		}
+		counters[counterKey{Iface: iface, Name: name}] = v
+	}
+	return s.Err()
+}
+
+// collect returns a sample of the counters of stat and of the interfaces
+// ifaces.
+func collect(stat tls.KTLSStat, ifaces []string) (*sample, error) {
+	smp := &sample{at: time.Now(), counters: make(map[counterKey]uint64)}
+	for name, v := range stat.Counters {
+		smp.counters[counterKey{Name: name}] = v
+	}
+	for _, iface := range ifaces {
+		out, err := exec.Command("ethtool", "-S", iface).Output()
+		if err != nil {
+			return nil, fmt.Errorf("ethtool -S %s: %v", iface, err)
+		}
+		if err := parseEthtoolStats(bytes.NewReader(out), iface, smp.counters); err != nil {
+			return nil, err
+		}
+	}
+	return smp, nil
+}
+
+func sortedKeys(counters map[counterKey]uint64) []counterKey {
+	keys := make([]counterKey, 0, len(counters))
+	for k := range counters {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Iface != keys[j].Iface {
+			return keys[i].Iface < keys[j].Iface
+		}
+		return keys[i].Name < keys[j].Name
+	})
+	return keys
+}
+
+// printDeltas writes the counters of cur that changed since prev, or all of
+// them if all is set, with their delta and rate per second.
+func printDeltas(w io.Writer, prev, cur *sample, all bool) {
+	elapsed := cur.at.Sub(prev.at).Seconds()
+	fmt.Fprintf(w, "%s\n", cur.at.Format(time.RFC3339))
+	for _, k := range sortedKeys(cur.counters) {
+		v := cur.counters[k]
+		delta := int64(v - prev.counters[k])
+		if delta == 0 && !all {
+			continue
+		}
+		rate := 0.0
+		if elapsed > 0 {
+			rate = float64(delta) / elapsed
+		}
+		fmt.Fprintf(w, "  %-40s %16d %+12d %12.1f/s\n", k, v, delta, rate)
+	}
+}
+
+// pushBody formats smp in the Prometheus text exposition format.
+func pushBody(smp *sample) []byte {
+	var b bytes.Buffer
+	b.WriteString("# TYPE ktls_stat untyped\n")
+	keys := sortedKeys(smp.counters)
+	for _, k := range keys {
+		if k.Iface == "" {
+			fmt.Fprintf(&b, "ktls_stat{counter=%q} %d\n", k.Name, smp.counters[k])
+		}
+	}
+	typed := false
+	for _, k := range keys {
+		if k.Iface == "" {
+			continue
+		}
+		if !typed {
+			b.WriteString("# TYPE ktls_nic_stat untyped\n")
+			typed = true
+		}
+		fmt.Fprintf(&b, "ktls_nic_stat{iface=%q,counter=%q} %d\n", k.Iface, k.Name, smp.counters[k])
+	}
+	return b.Bytes()
+}
+
+func push(client *http.Client, url, job string, smp *sample) error {
+	req, err := http.NewRequest(http.MethodPut, strings.TrimSuffix(url, "/")+"/metrics/job/"+job, bytes.NewReader(pushBody(smp)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("pushgateway returned %s", resp.Status)
+	}
+	return nil
+}
+
+func main() {
+	interval := flag.Duration("interval", time.Second, "polling `interval`")
+	ifaceList := flag.String("i", "", "comma-separated `interfaces` whose TLS offload counters are read with ethtool")
+	all := flag.Bool("all", false, "print counters that did not change")
+	pushURL := flag.String("push", "", "Prometheus Pushgateway `URL` to push samples to")
+	job := flag.String("job", "ktls-stat", "Pushgateway job name")
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("ktls-stat: ")
+
+	var ifaces []string
+	if *ifaceList != "" {
+		ifaces = strings.Split(*ifaceList, ",")
+	}
+	client := &http.Client{Timeout: *interval}
+
+	stat, err := tls.KTLSStats()
+	if err != nil {
+		log.Fatalf("%v (is the tls module loaded?)", err)
+	}
+	prev, err := collect(stat, ifaces)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := &tls.KTLSStatWatcher{
+		Interval: *interval,
+		OnUpdate: func(current, _ tls.KTLSStat) {
+			cur, err := collect(current, ifaces)
+			if err != nil {
+				log.Fatal(err)
+			}
+			printDeltas(os.Stdout, prev, cur, *all)
+			if *pushURL != "" {
+				if err := push(client, *pushURL, *job, cur); err != nil {
+					log.Print(err)
+				}
+			}
+			prev = cur
+		},
+	}
+	log.Fatal(w.Run(context.Background()))
+}
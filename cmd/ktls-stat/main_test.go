@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	tls "github.com/secure-for-ai/goktls"
+)
+
+var testTLSStat = tls.KTLSStat{Counters: map[string]uint64{
+	"TlsCurrTxSw":     2,
+	"TlsCurrRxSw":     2,
+	"TlsTxSw":         10,
+	"TlsRxSw":         7,
+	"TlsDecryptError": 0,
+}}
+
+const testEthtool = `NIC statistics:
+     rx_packets: 1234
+     tx_tls_encrypted_packets: 40
+     tx_tls_ooo: 1
+     rx_tls_decrypted_bytes: 9000
+`
+
+func TestCollect(t *testing.T) {
+	smp, err := collect(testTLSStat, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counters := smp.counters
+	if err := parseEthtoolStats(strings.NewReader(testEthtool), "eth0", counters); err != nil {
+		t.Fatal(err)
+	}
+	want := map[counterKey]uint64{
+		{Name: "TlsCurrTxSw"}:                             2,
+		{Name: "TlsCurrRxSw"}:                             2,
+		{Name: "TlsTxSw"}:                                 10,
+		{Name: "TlsRxSw"}:                                 7,
+		{Name: "TlsDecryptError"}:                         0,
+		{Iface: "eth0", Name: "tx_tls_encrypted_packets"}: 40,
+		{Iface: "eth0", Name: "tx_tls_ooo"}:               1,
+		{Iface: "eth0", Name: "rx_tls_decrypted_bytes"}:   9000,
+	}
+	if len(counters) != len(want) {
+		t.Fatalf("got %d counters, want %d: %v", len(counters), len(want), counters)
+	}
+	for k, v := range want {
+		if counters[k] != v {
+			t.Errorf("%v = %d, want %d", k, counters[k], v)
+		}
+	}
+}
+
+func TestPrintDeltas(t *testing.T) {
+	now := time.Now()
+	prev := &sample{at: now, counters: map[counterKey]uint64{
+		{Name: "TlsTxSw"}: 10,
+		{Name: "TlsRxSw"}: 7,
+	}}
+	cur := &sample{at: now.Add(2 * time.Second), counters: map[counterKey]uint64{
+		{Name: "TlsTxSw"}: 30,
+		{Name: "TlsRxSw"}: 7,
+	}}
+
+	var b bytes.Buffer
+	printDeltas(&b, prev, cur, false)
+	out := b.String()
+	if !strings.Contains(out, "TlsTxSw") || !strings.Contains(out, "+20") || !strings.Contains(out, "10.0/s") {
+		t.Errorf("missing TlsTxSw delta in:\n%s", out)
+	}
+	if strings.Contains(out, "TlsRxSw") {
+		t.Errorf("unchanged TlsRxSw printed in:\n%s", out)
+	}
+}
+
+func TestPushBody(t *testing.T) {
+	smp := &sample{counters: map[counterKey]uint64{
+		{Name: "TlsTxSw"}:                   10,
+		{Iface: "eth0", Name: "tx_tls_ooo"}: 1,
+	}}
+	want := `# TYPE ktls_stat untyped
+ktls_stat{counter="TlsTxSw"} 10
+# TYPE ktls_nic_stat untyped
+ktls_nic_stat{iface="eth0",counter="tx_tls_ooo"} 1
+`
+	if got := string(pushBody(smp)); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
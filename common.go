@@ -97,6 +97,7 @@ const (
 	extensionCookie                  uint16 = 44
 	extensionPSKModes                uint16 = 45
 	extensionCertificateAuthorities  uint16 = 47
+	extensionPostHandshakeAuth       uint16 = 49
 	extensionSignatureAlgorithmsCert uint16 = 50
 	extensionKeyShare                uint16 = 51
 	extensionRenegotiationInfo       uint16 = 0xff01
@@ -278,6 +279,15 @@ type ConnectionState struct {
 	// RFC 7627, and https://mitls.org/pages/attacks/3SHAKE#channelbindings.
 	TLSUnique []byte
 
+	// KTLS describes whether record protection for the connection has been
+	// offloaded to the kernel.
+	KTLS KTLSState
+
+	// SCTResults holds the outcome of verifying each signed certificate
+	// timestamp of the server's certificate. It is only set on clients with
+	// Config.CTLogs set, and is nil on resumed connections.
+	SCTResults []SCTResult
+
 	// ekm is a closure exposed via ExportKeyingMaterial.
 	ekm func(label string, context []byte, length int) ([]byte, error)
 }
@@ -286,7 +296,14 @@ type ConnectionState struct {
 // slice as defined in RFC 5705. If context is nil, it is not used as part of
 // the seed. If the connection was set to allow renegotiation via
 // Config.Renegotiation, this function will return an error.
+//
+// The exporter secret is retained for the lifetime of the connection, so
+// exporting keeps working when the record layer is offloaded to kernel TLS,
+// after Conn.Handover and after Conn.Close.
 func (cs *ConnectionState) ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error) {
+	if cs.ekm == nil {
+		return nil, errors.New("tls: ExportKeyingMaterial is unavailable before the handshake completes")
+	}
 	return cs.ekm(label, context, length)
 }
 
@@ -656,7 +673,15 @@ type Config struct {
 	InsecureSkipVerify bool
 
 	// CipherSuites is a list of enabled TLS 1.0–1.2 cipher suites. The order of
-	// the list is ignored. Note that TLS 1.3 ciphersuites are not configurable.
+	// the list is ignored. Note that TLS 1.3 ciphersuites are not configurable,
+	// except that TLS_AES_128_CCM_SHA256, TLS_SM4_GCM_SM3 and TLS_SM4_CCM_SM3
+	// are only offered by clients and selected by servers, after the default
+	// TLS 1.3 cipher suites, if they are in the list. The ShangMi suites of
+	// RFC 8998 are used with the configured curves and certificates: SM2 is
+	// not implemented, and the SM4 implementation is not constant time.
+	//
+	// The TLS 1.2 ARIA-GCM suites of RFC 6209 are only offered and selected
+	// when they are in the list, and then after any other listed suite.
 	//
 	// If CipherSuites is nil, a safe default list is used. The default cipher
 	// suites might change over time.
@@ -687,6 +712,11 @@ type Config struct {
 	// terminating connections for the same host, use SetSessionTicketKeys.
 	SessionTicketKey [32]byte
 
+	// TicketKeyProvider, if not nil, supplies the session ticket keys used by
+	// a server. It takes precedence over SessionTicketKey,
+	// SetSessionTicketKeys and automatic key rotation.
+	TicketKeyProvider TicketKeyProvider
+
 	// ClientSessionCache is a cache of ClientSessionState entries for TLS
 	// session resumption. It is only used by clients.
 	ClientSessionCache ClientSessionCache
@@ -733,6 +763,333 @@ type Config struct {
 	// used for debugging.
 	KeyLogWriter io.Writer
 
+	// WriteCoalesceDelay, if positive, enables coalescing of small
+	// application data writes. Writes are buffered for at most
+	// WriteCoalesceDelay, or until WriteCoalesceSize bytes are pending, and
+	// then sent as a single record. Conn.Flush and Conn.Close send any
+	// pending data immediately, as do Conn.Read and the connection's other
+	// read methods, so that a request is not held back while waiting for
+	// its response.
+	//
+	// This reduces the record count and packet rate for protocols that
+	// issue many small writes, at the cost of up to WriteCoalesceDelay of
+	// added latency per write.
+	WriteCoalesceDelay time.Duration
+
+	// WriteCoalesceSize is the number of pending bytes at which coalesced
+	// writes are flushed without waiting for WriteCoalesceDelay. If zero, a
+	// size that fits a typical TCP segment is used. It has no effect unless
+	// WriteCoalesceDelay is set.
+	WriteCoalesceSize int
+
+	// OnKTLSFallback, if not nil, is called after a handshake in which
+	// kernel TLS could not be enabled for one or both directions, whether
+	// because the kernel, socket or cipher suite does not support it or
+	// because programming it failed. A direction disabled by configuration
+	// is not a fallback, see KTLSState.TXDisabled. The connection remains usable, with
+	// user space handling the directions that were not offloaded, and state
+	// describes why. With KTLSDeferRX, it is called once the first Read has
+	// tried to offload TLS_RX.
+	OnKTLSFallback func(conn *Conn, state KTLSState)
+
+	// OnKTLSEnabled, if not nil, is called instead of OnKTLSFallback when
+	// every direction not disabled by configuration was offloaded to the
+	// kernel. Neither is called if configuration disables both.
+	OnKTLSEnabled func(conn *Conn, state KTLSState)
+
+	// KTLSMode controls kernel TLS for the connections of this Config. The
+	// zero value, KTLSModeAuto, offloads what the kernel supports unless
+	// kernel TLS is disabled process-wide, see SetKTLSEnabled. KTLSModeOff
+	// keeps these connections in user space, and KTLSModeRequire fails
+	// their handshakes when offload is not possible.
+	KTLSMode KTLSMode
+
+	// KTLSProtocolModes overrides KTLSMode for the connections that
+	// negotiated the given ALPN protocols, the empty string standing for
+	// connections without ALPN. For example, offload can be turned on for
+	// "http/1.1", whose file responses use sendfile, and off for "h2",
+	// whose frames can't. Connections that negotiated "acme-tls/1", which
+	// only serve TLS-ALPN-01 validation, are not offloaded unless listed.
+	KTLSProtocolModes map[string]KTLSMode
+
+	// KTLSDeferRX postpones programming kernel TLS RX offload from the end
+	// of the handshake until the first call to Read, once any records that
+	// arrived together with the handshake have been consumed in user space.
+	// This improves compatibility with peers that send post-handshake
+	// messages, such as TLS 1.3 session tickets, right after the handshake,
+	// while still offloading the bulk of the received data.
+	KTLSDeferRX bool
+
+	// KTLSTXDisabled and KTLSRXDisabled keep sending and receiving,
+	// respectively, in user space even when the kernel could offload them.
+	// For example, setting KTLSRXDisabled offloads only TLS_TX, which is
+	// enough for SendFile and ReadFrom to send files without copying them,
+	// without exposing the receive path to TLS 1.3 post-handshake messages
+	// or record padding. WriteTo and SpliceTo only splice from a connection
+	// with TLS_RX offloaded, and copy through user space otherwise.
+	KTLSTXDisabled bool
+	KTLSRXDisabled bool
+
+	// KTLSCipherSuites, if not empty, restricts kernel TLS offload to
+	// connections that negotiated one of the listed cipher suites. Other
+	// connections keep protecting records in user space. For example,
+	// listing only the AES-GCM suites leaves ChaCha20-Poly1305, which Go
+	// often implements faster than the kernel, in user space.
+	KTLSCipherSuites []uint16
+
+	// KTLSRXExpectNoPad sets TLS_RX_EXPECT_NO_PAD on TLS 1.3 connections
+	// with kernel TLS RX, letting the kernel decrypt application data
+	// directly into the Read buffer. Each record that turns out to be
+	// padded, or not to carry application data, is then decrypted twice, so
+	// a peer can double the cost of receiving: only set it for trusted
+	// peers. It is turned off again for the rest of the connection once a
+	// few non-application data records have been received.
+	KTLSRXExpectNoPad bool
+
+	// KTLSZerocopySendfile controls whether sendfile over kernel TLS TX is
+	// zero-copy (TLS_TX_ZEROCOPY_RO). By default it is attempted and
+	// failures, common with NICs without TLS offload, are ignored.
+	KTLSZerocopySendfile KTLSZerocopySendfileMode
+
+	// KTLSDeferEnable leaves kernel TLS unprogrammed at the end of the
+	// handshake, with records protected in user space, until
+	// Conn.EnableKTLS or EnableKTLSBatch is called. It lets proxies
+	// pre-establishing large connection pools program the kernel for all
+	// of them concurrently rather than one handshake at a time.
+	KTLSDeferEnable bool
+
+	// KTLSVerifyInterval, if positive, enables a diagnostic shadow check
+	// for bringing up kernel TLS on new kernels. The user-space cipher of
+	// each offloaded direction is kept, and on every KTLSVerifyInterval-th
+	// send or receive over that direction the kernel's crypto state is read
+	// back with getsockopt and programmed, as read, into a shadow pair of
+	// loopback sockets. For TX, the data just sent is encrypted by the
+	// shadow socket and decrypted in user space; for RX, the data just
+	// received is encrypted in user space and decrypted by the shadow
+	// socket. If the two disagree, or the kernel's record sequence number
+	// fell behind the records sent, the connection fails with a
+	// *KTLSVerifyError. This catches a key, IV or crypto_info layout that
+	// was programmed wrongly, which the peer would otherwise only report as
+	// a bad_record_mac alert.
+	//
+	// A send or receive may cover several records, or part of one, so the
+	// interval counts system calls rather than records. Each check costs a
+	// loopback connection and several system calls, and the user-space
+	// ciphers keep the traffic keys in memory for the lifetime of the
+	// connection, so this should not be enabled in production.
+	KTLSVerifyInterval int
+
+	// HandshakeSignLimiter, if not nil, limits the concurrency and duration
+	// of the private key operations performed by handshakes. See
+	// HandshakeSignLimiter.
+	HandshakeSignLimiter *HandshakeSignLimiter
+
+	// HandshakeTimeout, if positive, bounds the duration of each call to
+	// Handshake or HandshakeContext, including the implicit handshake run by
+	// the first Read or Write and the enabling of kernel TLS. When it
+	// expires the underlying connection is closed and the handshake fails
+	// with context.DeadlineExceeded, as if the context passed to
+	// HandshakeContext had expired.
+	HandshakeTimeout time.Duration
+
+	// CloseTimeout bounds the time Conn.Close spends sending the
+	// close_notify alert, and any data held back by the write coalescer,
+	// to a peer that stopped reading. If zero, 5 seconds is used. A write
+	// deadline set on the Conn applies instead if it is earlier.
+	CloseTimeout time.Duration
+
+	// RecordPadding, if not nil, returns the number of zero bytes of padding
+	// to add to a TLS 1.3 record carrying n bytes of application data, to
+	// make traffic analysis harder. See RFC 8446, Section 5.4. The record is
+	// never padded beyond the maximum record size. PadToMultiple returns a
+	// policy that pads records to a fixed block size.
+	//
+	// Since the kernel cannot pad records, kernel TLS TX is not enabled on
+	// TLS 1.3 connections when RecordPadding is set; the reason is reported
+	// in KTLSState.TXReason. RecordPadding has no effect before TLS 1.3.
+	RecordPadding func(n int) int
+
+	// CTLogs, if not empty, makes clients verify the Certificate
+	// Transparency signed certificate timestamps (SCTs) embedded in the
+	// server's certificate or sent in the handshake against these logs.
+	// SCTs delivered in stapled OCSP responses are not checked. The results
+	// are passed to CTPolicy and reported in ConnectionState.SCTResults.
+	//
+	// SCTs are not verified again on resumed connections.
+	CTLogs []*CTLog
+
+	// CTPolicy, if not nil, is called with the results of SCT verification
+	// when CTLogs is not empty. If it returns a non-nil error, the handshake
+	// is aborted and that error results. If CTPolicy is nil, the handshake
+	// fails unless at least one SCT was issued by one of CTLogs.
+	CTPolicy func(results []SCTResult) error
+
+	// GetTLSARecords, if not nil, is called by clients to obtain the DNS
+	// TLSA records for the server, which the application must have
+	// validated with DNSSEC. serverName is Config.ServerName; the
+	// application is responsible for the port and transport labels of the
+	// query.
+	//
+	// If records are returned, the server's certificate is verified against
+	// them as described in RFC 7671 instead of with the usual PKIX rules,
+	// and InsecureSkipVerify is ignored. If no records are returned, the
+	// certificate is verified as if GetTLSARecords were nil. If an error is
+	// returned, the handshake is aborted with that error.
+	GetTLSARecords func(serverName string) ([]TLSARecord, error)
+
+	// PostHandshakeAuth, on a client, offers TLS 1.3 post-handshake client
+	// authentication, allowing the server to request a certificate with
+	// Conn.RequestClientCertificate after the handshake. The certificate is
+	// selected as for a handshake CertificateRequest, see Certificates and
+	// GetClientCertificate. It is ignored by servers.
+	PostHandshakeAuth bool
+
+	// UnknownRecords is the policy for records received after the handshake
+	// with a content type this package doesn't handle, such as heartbeat
+	// records. The zero value, UnknownRecordError, fails the connection.
+	UnknownRecords UnknownRecordPolicy
+
+	// OnUnknownRecord, if not nil, is called with the records dropped by
+	// the UnknownRecordDeliver policy. data is only valid for the duration
+	// of the call.
+	OnUnknownRecord func(contentType uint8, data []byte)
+
+	// AlignRecordsToMSS sizes application data records so that each one
+	// fills an integral number of TCP segments, avoiding a small trailing
+	// segment per record. The maximum segment size is read with TCP_MAXSEG
+	// and read again every few records to follow path MTU changes. It has
+	// no effect if the MSS can't be read or RecordPadding is set.
+	AlignRecordsToMSS bool
+
+	// DebugConn, if not nil, is called before the first handshake of each
+	// connection. If it returns true, debug logging is enabled for that
+	// connection, as if Conn.SetDebug(true) had been called. It may use
+	// the Conn's RemoteAddr and CorrelationID to pick connections.
+	DebugConn func(*Conn) bool
+
+	// Logger, if not nil, receives the connection's log messages, such as
+	// whether kernel TLS was enabled or why it failed, and its debug
+	// messages, see Conn.SetDebug. If nil, the Logger set with SetLogger
+	// is used.
+	Logger Logger
+
+	// KTLSSkipLocal skips kernel TLS offload for connections whose peer is
+	// on the same host, that is whose remote address is a loopback address
+	// or the connection's own local address. Offload brings no benefit on
+	// such hops but still costs the socket option calls and kernel state.
+	KTLSSkipLocal bool
+
+	// KTLSUnwrapConn lets kernel TLS be enabled on connections whose
+	// underlying net.Conn is not a *net.TCPConn but wraps one, such as
+	// PROXY protocol decoders, connection trackers or custom dialers. The
+	// socket is then found by following the connection's syscall.Conn or
+	// Unwrap() net.Conn methods. Once kernel TLS RX is enabled, records are
+	// read from the socket directly, bypassing the wrappers' Read methods,
+	// so wrappers must not hold bytes read from the socket but not yet
+	// returned, as buffered readers do, or those bytes are lost. If false,
+	// only a *net.TCPConn is offloaded.
+	KTLSUnwrapConn bool
+
+	// KTLSSeccompCompat keeps connections in user space, instead of failing
+	// the handshake, when a seccomp filter, such as that of a locked-down
+	// container, refuses to program TLS_TX or TLS_RX with EPERM or ENOSYS.
+	// The *KTLSSyscallError is reported through OnKTLSFallback.
+	//
+	// It also sends and receives records, and sets and reads socket
+	// options, with the golang.org/x/sys/unix wrappers of sendmsg, recvmsg,
+	// setsockopt and getsockopt instead of direct system calls. The
+	// wrappers make the same system calls, so a profile must allow
+	// KTLSSyscalls either way for offload to work. The setsockopt and
+	// getsockopt wrappers copy the value to a string, leaving copies of the
+	// traffic keys that can't be wiped.
+	KTLSSeccompCompat bool
+
+	// KTLSFallbackOnError keeps the connection in user space for any
+	// direction whose TLS_TX or TLS_RX setsockopt fails, for example with
+	// ENOTSUPP for a cipher the kernel module lacks, instead of failing
+	// the handshake. The other direction may still be offloaded. The
+	// *KTLSSyscallError is recorded in KTLSState.TXReason or RXReason and
+	// reported through OnKTLSFallback.
+	KTLSFallbackOnError bool
+
+	// KTLSRequireDevice only uses kernel TLS when the NIC encrypts or
+	// decrypts the records, since for some workloads the kernel's software
+	// crypto is slower than user space. A direction is not programmed
+	// unless the connection's interface has tls-hw-tx-offload or
+	// tls-hw-rx-offload active, which fails the handshake, or with
+	// KTLSFallbackOnError keeps the direction in user space. If the kernel
+	// still picks software crypto once programmed, which can't be undone,
+	// the handshake fails regardless.
+	KTLSRequireDevice bool
+
+	// KTLSRXIOUring receives the records of connections with kernel TLS
+	// RX through an io_uring shared by the process, rather than with a
+	// recvmsg call per record. Each connection keeps a multishot recvmsg
+	// request armed, which the kernel completes into a shared pool of
+	// buffers as records arrive, so that servers with many offloaded
+	// connections spend fewer system calls per record. It requires Linux
+	// 6.0; elsewhere, or when io_uring is disabled, records are received
+	// with recvmsg. Conn.WriteTo no longer splices once the ring was used.
+	KTLSRXIOUring bool
+
+	// KTLSSpliceIOUring submits the splices of Conn.SendFile,
+	// SendSection, WriteTo and SpliceTo on kernel TLS connections to the
+	// io_uring shared by the process, as linked pairs moving a chunk into
+	// a pipe and out of it, so that each chunk costs one system call and
+	// the copies run in the kernel's workers, batched with those of other
+	// connections. SendFile and SendSection then use splice rather than
+	// sendfile. Where io_uring is unavailable, they work as before.
+	KTLSSpliceIOUring bool
+
+	// SpliceChunkSize is the most data moved per splice call when
+	// Conn.WriteTo and Conn.SpliceTo splice from a kernel TLS socket. The
+	// pipe the data goes through is grown to match, up to
+	// /proc/sys/fs/pipe-max-size, which also bounds the chunks. Larger
+	// chunks mean fewer system calls on high bandwidth-delay links, smaller
+	// ones less pinned memory. If zero, the pipes are used at the size set
+	// with SetSplicePipePool.
+	SpliceChunkSize int
+
+	// OnAdvice, if not nil, is called by Conn.Close with each of the
+	// recommendations returned by Conn.Advise, before the connection is
+	// closed.
+	OnAdvice func(conn *Conn, advice Advice)
+
+	// OnRXOffloadLost, if not nil, is called at most once per connection
+	// when the NIC stops decrypting the records it receives with kernel
+	// TLS, for example because the interface went down or the kernel
+	// disabled the device offload after failed resynchronizations. The
+	// kernel then keeps decrypting the records in software. reason
+	// describes what was observed.
+	//
+	// The offload is checked with sock_diag every 1024 records received,
+	// and only connections whose records were seen decrypted by the NIC
+	// are reported. See also Conn.KTLSOffloadModes and DeviceTLSStats.
+	OnRXOffloadLost func(conn *Conn, reason string)
+
+	// OnWarningAlert, if not nil, is called with each alert the peer sends
+	// that doesn't close the connection, before the record is dropped and
+	// reading continues: any warning alert other than close_notify in TLS
+	// 1.2 and earlier, and user_canceled in TLS 1.3. Such alerts are
+	// otherwise ignored silently.
+	OnWarningAlert func(conn *Conn, desc AlertDescription)
+
+	// RecordObserver, if not nil, is given metadata about every record
+	// the connection sends and receives through Write, Read and their
+	// variants, and the handshake, whether they are protected in user
+	// space or by kernel TLS.
+	//
+	// Data moved by the kernel without passing through user space cannot
+	// be observed: ReadFrom and SendSection from files and sockets when
+	// they use sendfile or splice, WriteTo when it splices into a file,
+	// and anything sent or received on the socket after Handover.
+	RecordObserver RecordObserver
+
+	// RecordSampleBytes is the number of leading plaintext bytes of each
+	// record passed to RecordObserver in RecordInfo.Sample. If zero, only
+	// metadata is passed.
+	RecordSampleBytes int
+
 	// mutex protects sessionTicketKeys and autoSessionTicketKeys.
 	mutex sync.RWMutex
 	// sessionTicketKeys contains zero or more ticket keys. If set, it means
@@ -744,6 +1101,13 @@ type Config struct {
 	// autoSessionTicketKeys is like sessionTicketKeys but is owned by the
 	// auto-rotation logic. See Config.ticketKeys.
 	autoSessionTicketKeys []ticketKey
+	// providerTicketKeys is like sessionTicketKeys but is fetched from
+	// TicketKeyProvider, and is refreshed at providerTicketKeysRefresh. See
+	// Config.providerTicketKeysRLocked. providerTicketKeysFetch is closed
+	// once the running fetch, if any, completes.
+	providerTicketKeys        []ticketKey
+	providerTicketKeysRefresh time.Time
+	providerTicketKeysFetch   chan struct{}
 }
 
 const (
@@ -815,6 +1179,7 @@ func (c *Config) Clone() *Config {
 		PreferServerCipherSuites:    c.PreferServerCipherSuites,
 		SessionTicketsDisabled:      c.SessionTicketsDisabled,
 		SessionTicketKey:            c.SessionTicketKey,
+		TicketKeyProvider:           c.TicketKeyProvider,
 		ClientSessionCache:          c.ClientSessionCache,
 		MinVersion:                  c.MinVersion,
 		MaxVersion:                  c.MaxVersion,
@@ -822,8 +1187,50 @@ func (c *Config) Clone() *Config {
 		DynamicRecordSizingDisabled: c.DynamicRecordSizingDisabled,
 		Renegotiation:               c.Renegotiation,
 		KeyLogWriter:                c.KeyLogWriter,
+		WriteCoalesceDelay:          c.WriteCoalesceDelay,
+		WriteCoalesceSize:           c.WriteCoalesceSize,
+		OnKTLSFallback:              c.OnKTLSFallback,
+		OnKTLSEnabled:               c.OnKTLSEnabled,
+		KTLSDeferRX:                 c.KTLSDeferRX,
+		KTLSTXDisabled:              c.KTLSTXDisabled,
+		KTLSRXDisabled:              c.KTLSRXDisabled,
+		KTLSCipherSuites:            c.KTLSCipherSuites,
+		KTLSRXExpectNoPad:           c.KTLSRXExpectNoPad,
+		KTLSZerocopySendfile:        c.KTLSZerocopySendfile,
+		KTLSMode:                    c.KTLSMode,
+		KTLSProtocolModes:           c.KTLSProtocolModes,
+		KTLSDeferEnable:             c.KTLSDeferEnable,
+		KTLSVerifyInterval:          c.KTLSVerifyInterval,
+		HandshakeSignLimiter:        c.HandshakeSignLimiter,
+		HandshakeTimeout:            c.HandshakeTimeout,
+		CloseTimeout:                c.CloseTimeout,
+		RecordPadding:               c.RecordPadding,
+		CTLogs:                      c.CTLogs,
+		CTPolicy:                    c.CTPolicy,
+		GetTLSARecords:              c.GetTLSARecords,
+		PostHandshakeAuth:           c.PostHandshakeAuth,
+		UnknownRecords:              c.UnknownRecords,
+		OnUnknownRecord:             c.OnUnknownRecord,
+		AlignRecordsToMSS:           c.AlignRecordsToMSS,
+		DebugConn:                   c.DebugConn,
+		Logger:                      c.Logger,
+		KTLSSkipLocal:               c.KTLSSkipLocal,
+		KTLSUnwrapConn:              c.KTLSUnwrapConn,
+		KTLSSeccompCompat:           c.KTLSSeccompCompat,
+		KTLSFallbackOnError:         c.KTLSFallbackOnError,
+		KTLSRequireDevice:           c.KTLSRequireDevice,
+		KTLSRXIOUring:               c.KTLSRXIOUring,
+		KTLSSpliceIOUring:           c.KTLSSpliceIOUring,
+		SpliceChunkSize:             c.SpliceChunkSize,
+		OnAdvice:                    c.OnAdvice,
+		OnRXOffloadLost:             c.OnRXOffloadLost,
+		OnWarningAlert:              c.OnWarningAlert,
+		RecordObserver:              c.RecordObserver,
+		RecordSampleBytes:           c.RecordSampleBytes,
 		sessionTicketKeys:           c.sessionTicketKeys,
 		autoSessionTicketKeys:       c.autoSessionTicketKeys,
+		providerTicketKeys:          c.providerTicketKeys,
+		providerTicketKeysRefresh:   c.providerTicketKeysRefresh,
 	}
 }
 
@@ -878,6 +1285,11 @@ func (c *Config) ticketKeys(configForClient *Config) []ticketKey {
 		if configForClient.SessionTicketsDisabled {
 			return nil
 		}
+		if configForClient.TicketKeyProvider != nil {
+			ret := configForClient.providerTicketKeysRLocked()
+			configForClient.mutex.RUnlock()
+			return ret
+		}
 		configForClient.initLegacySessionTicketKeyRLocked()
 		if len(configForClient.sessionTicketKeys) != 0 {
 			ret := configForClient.sessionTicketKeys
@@ -892,6 +1304,9 @@ func (c *Config) ticketKeys(configForClient *Config) []ticketKey {
 	if c.SessionTicketsDisabled {
 		return nil
 	}
+	if c.TicketKeyProvider != nil {
+		return c.providerTicketKeysRLocked()
+	}
 	c.initLegacySessionTicketKeyRLocked()
 	if len(c.sessionTicketKeys) != 0 {
 		return c.sessionTicketKeys
@@ -969,6 +1384,15 @@ func (c *Config) time() time.Time {
 	return t()
 }
 
+// closeTimeout returns the bound on sending close_notify in Conn.Close,
+// see CloseTimeout.
+func (c *Config) closeTimeout() time.Duration {
+	if c.CloseTimeout > 0 {
+		return c.CloseTimeout
+	}
+	return 5 * time.Second
+}
+
 func (c *Config) cipherSuites() []uint16 {
 	if needFIPS() {
 		return fipsCipherSuites(c)
@@ -991,6 +1415,20 @@ var supportedVersions = []uint16{
 const roleClient = true
 const roleServer = false
 
+// ktlsAllowsSuite reports whether Config.KTLSCipherSuites lets connections
+// that negotiated the cipher suite id be offloaded.
+func (c *Config) ktlsAllowsSuite(id uint16) bool {
+	if len(c.KTLSCipherSuites) == 0 {
+		return true
+	}
+	for _, suite := range c.KTLSCipherSuites {
+		if suite == id {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Config) supportedVersions(isClient bool) []uint16 {
 	versions := make([]uint16, 0, len(supportedVersions))
 	for _, v := range supportedVersions {
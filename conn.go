@@ -19,6 +19,7 @@ import (
 	"net"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -46,8 +47,9 @@ type Conn struct {
 	handshakes       int
 	didResume        bool // whether this connection was a session resumption
 	cipherSuite      uint16
-	ocspResponse     []byte   // stapled OCSP response
-	scts             [][]byte // signed certificate timestamps from server
+	ocspResponse     []byte      // stapled OCSP response
+	scts             [][]byte    // signed certificate timestamps from server
+	sctResults       []SCTResult // results of verifying scts, see Config.CTLogs
 	peerCertificates []*x509.Certificate
 	// activeCertHandles contains the cache handles to certificates in
 	// peerCertificates that are used to track active references.
@@ -61,11 +63,21 @@ type Conn struct {
 	// renegotiation extension. (This is meaningless as a server because
 	// renegotiation is not supported in that case.)
 	secureRenegotiation bool
-	// ekm is a closure for exporting keying material.
+	// ekm is a closure for exporting keying material. It holds its own
+	// copy of the exporter secret, so it keeps working once the traffic
+	// keys are handed to the kernel, the Conn is handed over or closed.
+	// Nothing may clear the secrets it captures.
 	ekm func(label string, context []byte, length int) ([]byte, error)
 	// resumptionSecret is the resumption_master_secret for handling
 	// NewSessionTicket messages. nil if config.SessionTicketsDisabled.
 	resumptionSecret []byte
+	// postHandshakeTranscript is the transcript of the handshake up to the
+	// client Finished, kept if the client offered post-handshake
+	// authentication. postHandshakeAuth holds a CertificateRequest sent by
+	// RequestClientCertificate and not yet answered. Both are protected by
+	// in.Mutex.
+	postHandshakeTranscript hash.Hash
+	postHandshakeAuth       *postHandshakeAuthRequest
 
 	// ticketKeys is the set of active session ticket keys for this
 	// connection. The first one is used to encrypt new tickets and
@@ -94,19 +106,138 @@ type Conn struct {
 	// clientProtocol is the negotiated ALPN protocol.
 	clientProtocol string
 
+	// ktlsState records the outcome of enabling kernel TLS. ktlsReport is
+	// set when that outcome should be reported to the Config callbacks once
+	// the handshake locks are released.
+	ktlsState  KTLSState
+	ktlsReport atomic.Bool
+	// ktlsSock is the socket kernel TLS was programmed on, which may be
+	// wrapped by conn, see ktlsSocket.
+	ktlsSock syscall.Conn
+	// handshakeTime is the duration of the last successful handshake and
+	// ktlsTXEnableTime and ktlsRXEnableTime that of the last programming of
+	// TLS_TX and TLS_RX, for HandshakeWithReport. They are protected by
+	// handshakeMutex.
+	handshakeTime    time.Duration
+	ktlsTXEnableTime time.Duration
+	ktlsRXEnableTime time.Duration
+	// ktlsResyncBase is the host-wide TlsRxDeviceResync counter when RX was
+	// offloaded, so that Advise only reports the resyncs since. It is
+	// protected like ktlsState.
+	ktlsResyncBase uint64
+	// ktlsULP is true once the "tls" upper layer protocol is attached to
+	// the socket. ktlsDeferRX is true while TLS_RX programming is postponed
+	// until the first Read, see Config.KTLSDeferRX. ktlsDeferEnable is true
+	// while all programming is postponed until EnableKTLS, see
+	// Config.KTLSDeferEnable. ktlsAdopted is true if c was received with
+	// AdoptConn, without the traffic secrets.
+	ktlsULP         bool
+	ktlsDeferRX     bool
+	ktlsDeferEnable bool
+	ktlsAdopted     bool
+	// ktlsVerifyTX and ktlsVerifyRX check the kernel against the
+	// user-space ciphers when Config.KTLSVerifyInterval is set. They are protected by out.Mutex
+	// and in.Mutex respectively.
+	ktlsVerifyTX *ktlsVerifier
+	ktlsVerifyRX *ktlsVerifier
+	// ktlsCheckpoint is non-nil between CheckpointKTLS and RestoreKTLS,
+	// and closed when either RestoreKTLS or Close ends the checkpoint. It is
+	// protected by ktlsCheckpointMu, and only set while in.Mutex and
+	// out.Mutex are held.
+	ktlsCheckpointMu sync.Mutex
+	ktlsCheckpoint   chan struct{}
+	// ktlsSavedTX and ktlsSavedRX hold the crypto_info, keys included,
+	// that CheckpointKTLS read back from the kernel for RestoreKTLS. They
+	// are zeroed when the checkpoint ends, and belong to whichever of
+	// RestoreKTLS and Close took ktlsCheckpoint.
+	ktlsSavedTX []byte
+	ktlsSavedRX []byte
+	// ktlsPendingType and ktlsPending hold a non-application data record
+	// that ReadExact received with kernel TLS, for readRecord to process.
+	// They are protected by in.Mutex.
+	ktlsPendingType recordType
+	ktlsPending     []byte
+	// While captureRecords is set, readRecord appends a copy of each
+	// record it decrypts to capturedRecords, for ReadRecord. They are
+	// protected by in.Mutex.
+	captureRecords  bool
+	capturedRecords []capturedRecord
+	// rxOffload tracks the NIC offload of received records for
+	// Config.OnRXOffloadLost. It is protected by in.Mutex, except lost.
+	rxOffload struct {
+		records uint64
+		device  bool
+		ifindex int
+		ifname  string
+		lost    atomic.Bool
+	}
+
 	// input/output
-	in, out   halfConn
-	rawInput  bytes.Buffer // raw input, starting with a record header
-	input     bytes.Reader // application data waiting to be read, from rawInput.Next
-	hand      bytes.Buffer // handshake data waiting to be read
-	buffering bool         // whether records are buffered in sendBuf
-	sendBuf   []byte       // a buffer of records waiting to be sent
+	in, out  halfConn
+	rawInput bytes.Buffer // raw input, starting with a record header
+	// rawInputBuf is the pooled buffer backing rawInput, if any. See
+	// acquireRawInput and releaseRawInput.
+	rawInputBuf *[]byte
+	input       bytes.Reader // application data waiting to be read, from rawInput.Next
+	hand        bytes.Buffer // handshake data waiting to be read
+	buffering   bool         // whether records are buffered in sendBuf
+	sendBuf     []byte       // a buffer of records waiting to be sent
+
+	// coalesceBuf holds application data held back by the write coalescer
+	// and coalesceTimer flushes it once Config.WriteCoalesceDelay expires.
+	// Both are protected by out.Mutex.
+	coalesceBuf   []byte
+	coalesceTimer *time.Timer
+	coalesceStart time.Time // when the oldest pending coalesced write began
+	coalesceErr   error     // failed timer flush not yet returned by a read
+
+	// stats collects the record statistics returned by Stats. txStart is
+	// when the Write being sent began, protected by out.Mutex, and
+	// rxRecordAt is when the record in input was received, protected by
+	// in.Mutex.
+	stats      connStats
+	txStart    time.Time
+	rxRecordAt time.Time
+
+	// writeDeadline is the write deadline set with SetDeadline or
+	// SetWriteDeadline, in Unix nanoseconds, or zero if there is none.
+	writeDeadline atomic.Int64
+
+	// ctx is the context attached by SetContext.
+	ctx atomic.Pointer[connContext]
+	// debug enables debug logging for this connection, see SetDebug.
+	// debugChecked is set once Config.DebugConn has been consulted.
+	debug        atomic.Bool
+	debugChecked atomic.Bool
 
 	// bytesSent counts the bytes of application data sent.
 	// packetsSent counts packets.
 	bytesSent   int64
 	packetsSent int64
 
+	// mss is the TCP maximum segment size that records are aligned to, see
+	// Config.AlignRecordsToMSS, and mssRecords the number of records until
+	// it is queried again. Both are protected by out.Mutex.
+	mss        int
+	mssRecords int
+
+	// ktlsRecv holds the recvmsg arguments reused for every record received
+	// with kernel TLS RX. Protected by in.Mutex.
+	ktlsRecv *ktlsRecvState
+	// ktlsRing receives the records with io_uring once it was set up, see
+	// Config.KTLSRXIOUring. It waits out the read deadline itself, which
+	// readDeadline holds in Unix nanoseconds, or zero if there is none.
+	ktlsRing     atomic.Pointer[ktlsRingRecv]
+	readDeadline atomic.Int64
+	// ktlsNoPadViolations counts the records that defeated
+	// TLS_RX_EXPECT_NO_PAD, protected by in.Mutex. ktlsNoPadOff is set once
+	// it was turned off because of them, see ktlsNoPadViolation.
+	ktlsNoPadViolations int
+	ktlsNoPadOff        atomic.Bool
+	// ktlsCounted is set while c is counted in
+	// KTLSProcessStats.OffloadedConns.
+	ktlsCounted atomic.Bool
+
 	// retryCount counts the number of consecutive non-advancing records
 	// received by Conn.readRecord. That is, records that neither advance the
 	// handshake, nor deliver application data. Protected by in.Mutex.
@@ -139,12 +270,15 @@ func (c *Conn) RemoteAddr() net.Addr {
 // A zero value for t means Read and Write will not time out.
 // After a Write has timed out, the TLS state is corrupt and all future writes will return the same error.
 func (c *Conn) SetDeadline(t time.Time) error {
+	c.storeWriteDeadline(t)
+	c.storeReadDeadline(t)
 	return c.conn.SetDeadline(t)
 }
 
 // SetReadDeadline sets the read deadline on the underlying connection.
 // A zero value for t means Read will not time out.
 func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.storeReadDeadline(t)
 	return c.conn.SetReadDeadline(t)
 }
 
@@ -152,9 +286,31 @@ func (c *Conn) SetReadDeadline(t time.Time) error {
 // A zero value for t means Write will not time out.
 // After a Write has timed out, the TLS state is corrupt and all future writes will return the same error.
 func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.storeWriteDeadline(t)
 	return c.conn.SetWriteDeadline(t)
 }
 
+// storeWriteDeadline records the write deadline set by the user, which
+// closeNotify keeps if it is earlier than Config.CloseTimeout.
+func (c *Conn) storeWriteDeadline(t time.Time) {
+	if t.IsZero() {
+		c.writeDeadline.Store(0)
+	} else {
+		c.writeDeadline.Store(t.UnixNano())
+	}
+}
+
+// storeReadDeadline records the read deadline set by the user, for reads
+// that wait outside the network poller, see Config.KTLSRXIOUring.
+func (c *Conn) storeReadDeadline(t time.Time) {
+	if t.IsZero() {
+		c.readDeadline.Store(0)
+	} else {
+		c.readDeadline.Store(t.UnixNano())
+	}
+	c.ktlsWakeRing()
+}
+
 // NetConn returns the underlying connection that is wrapped by c.
 // Note that writing to or reading from this connection directly will corrupt the
 // TLS session.
@@ -174,14 +330,18 @@ type halfConn struct {
 	seq     [8]byte // 64-bit sequence number
 
 	scratchBuf [13]byte // to avoid allocs; interface method args escape
+	padding    int      // bytes of padding to add to the next TLS 1.3 record
 
 	nextCipher any       // next encryption state
 	nextMac    hash.Hash // next MAC algorithm
 
 	trafficSecret []byte // current TLS 1.3 traffic secret
 
-	key []byte // encrypt or decrypt key for kernel tls
-	iv  []byte // encrypt or decrypt iv for kernel tls
+	// key and iv are the traffic key and IV for kernel TLS. They are
+	// zeroed once enabling kernel TLS for this direction is settled, see
+	// wipeKey.
+	key []byte
+	iv  []byte
 }
 
 type permanentError struct {
@@ -235,6 +395,15 @@ func (hc *halfConn) setTrafficSecret(suite *cipherSuiteTLS13, secret []byte) {
 	}
 }
 
+// wipeKey zeroes and drops hc.key and hc.iv, which kernel TLS no longer
+// needs once the kernel holds them or the direction stays in user space,
+// where hc.cipher has its own copy.
+func (hc *halfConn) wipeKey() {
+	wipe(hc.key)
+	wipe(hc.iv)
+	hc.key, hc.iv = nil, nil
+}
+
 // incSeq increments the sequence number.
 func (hc *halfConn) incSeq() {
 	for i := 7; i >= 0; i-- {
@@ -524,8 +693,11 @@ func (hc *halfConn) encrypt(record, payload []byte, rand io.Reader) ([]byte, err
 			// Encrypt the actual ContentType and replace the plaintext one.
 			record = append(record, record[0])
 			record[0] = byte(recordTypeApplicationData)
+			for ; hc.padding > 0; hc.padding-- {
+				record = append(record, 0)
+			}
 
-			n := len(payload) + 1 + c.Overhead()
+			n := len(record) - recordHeaderLen + c.Overhead()
 			record[3] = byte(n >> 8)
 			record[4] = byte(n)
 
@@ -631,15 +803,26 @@ func (c *Conn) readRecordOrCCS(expectChangeCipherSpec bool) error {
 		err    error
 	)
 
-	if _, ok := c.in.cipher.(kTLSCipher); ok {
+	if _, ok := c.in.cipher.(kTLSCipher); ok && c.ktlsPending != nil {
+		typ, data = c.ktlsPendingType, c.ktlsPending
+		c.ktlsPending = nil
+	} else if ok {
 		if c.rawInput.Len() < 0xfff {
-			c.rawInput.Grow(0xfff - c.rawInput.Len())
+			c.acquireRawInput(0xfff - c.rawInput.Len())
 		}
 		data = c.rawInput.Bytes()[:0xfff]
-		if typ, n, err = ktlsReadRecord(c.conn.(*net.TCPConn), data); err != nil {
+		if typ, n, err = c.ktlsReadRecord(data); err != nil {
 			return err
 		}
 		data = data[:n]
+		if c.ktlsVerifyRX != nil {
+			if err := c.ktlsVerifyRX.record(c.ktlsConn(), data); err != nil {
+				return c.in.setErrorLocked(err)
+			}
+		}
+		if c.config.OnRXOffloadLost != nil {
+			c.observeRXOffload()
+		}
 	} else {
 		// Read header, payload.
 		if err := c.readFromUntil(c.conn, recordHeaderLen); err != nil {
@@ -705,13 +888,20 @@ func (c *Conn) readRecordOrCCS(expectChangeCipherSpec bool) error {
 	if len(data) > maxPlaintext {
 		return c.in.setErrorLocked(c.sendAlert(alertRecordOverflow))
 	}
+	if c.config.RecordObserver != nil {
+		_, ktls := c.in.cipher.(kTLSCipher)
+		c.observeRecord(false, typ, data, ktls)
+	}
+	if c.captureRecords {
+		c.capturedRecords = append(c.capturedRecords, capturedRecord{typ, append([]byte(nil), data...)})
+	}
 
 	// Application Data messages are always protected.
 	if c.in.cipher == nil && typ == recordTypeApplicationData {
 		return c.in.setErrorLocked(c.sendAlert(alertUnexpectedMessage))
 	}
 
-	if typ != recordTypeAlert && typ != recordTypeChangeCipherSpec && len(data) > 0 {
+	if (typ == recordTypeHandshake || typ == recordTypeApplicationData) && len(data) > 0 {
 		// This is a state-advancing message: reset the retry count.
 		c.retryCount = 0
 	}
@@ -723,9 +913,17 @@ func (c *Conn) readRecordOrCCS(expectChangeCipherSpec bool) error {
 
 	switch typ {
 	default:
+		// Records of unknown types are dropped, like warning alerts, if
+		// Config.UnknownRecords allows it.
+		if handshakeComplete && c.handleUnknownRecord(typ, data) {
+			return c.retryReadRecord(expectChangeCipherSpec)
+		}
 		return c.in.setErrorLocked(c.sendAlert(alertUnexpectedMessage))
 
 	case recordTypeAlert:
+		if _, ok := c.in.cipher.(kTLSCipher); ok {
+			return c.handleKTLSAlert(data, expectChangeCipherSpec)
+		}
 		if len(data) != 2 {
 			return c.in.setErrorLocked(c.sendAlert(alertUnexpectedMessage))
 		}
@@ -733,11 +931,19 @@ func (c *Conn) readRecordOrCCS(expectChangeCipherSpec bool) error {
 			return c.in.setErrorLocked(io.EOF)
 		}
 		if c.vers == VersionTLS13 {
-			return c.in.setErrorLocked(&net.OpError{Op: "remote error", Err: alert(data[1])})
+			// The level of TLS 1.3 alerts is implicit in their description,
+			// and user_canceled is the only one that doesn't end the
+			// connection (RFC 8446, Section 6.1).
+			if alert(data[1]) != alertUserCanceled {
+				return c.in.setErrorLocked(&net.OpError{Op: "remote error", Err: alert(data[1])})
+			}
+			c.handleWarningAlert(alert(data[1]))
+			return c.retryReadRecord(expectChangeCipherSpec)
 		}
 		switch data[0] {
 		case alertLevelWarning:
 			// Drop the record on the floor and retry.
+			c.handleWarningAlert(alert(data[1]))
 			return c.retryReadRecord(expectChangeCipherSpec)
 		case alertLevelError:
 			return c.in.setErrorLocked(&net.OpError{Op: "remote error", Err: alert(data[1])})
@@ -781,6 +987,7 @@ func (c *Conn) readRecordOrCCS(expectChangeCipherSpec bool) error {
 		// to avoid copying the plaintext. This is safe because c.rawInput is
 		// not read from or written to until c.input is drained.
 		c.input.Reset(data)
+		c.observeRXRecord(len(data))
 
 	case recordTypeHandshake:
 		if len(data) == 0 || expectChangeCipherSpec {
@@ -836,7 +1043,7 @@ func (c *Conn) readFromUntil(r io.Reader, n int) error {
 	// There might be extra input waiting on the wire. Make a best effort
 	// attempt to fetch it so that it can be used in (*Conn).Read to
 	// "predict" closeNotify alerts.
-	c.rawInput.Grow(needs + bytes.MinRead)
+	c.acquireRawInput(needs + bytes.MinRead)
 	c.atLeastReader = atLeastReader{
 		R: r, N: int64(needs),
 	}
@@ -845,6 +1052,30 @@ func (c *Conn) readFromUntil(r io.Reader, n int) error {
 	return err
 }
 
+// writeControlRecordLocked writes an alert, handshake or change_cipher_spec
+// record. With kernel TLS TX, the record type is passed to the kernel with
+// TLS_SET_RECORD_TYPE, so that the kernel encrypts the data as a record of
+// that type rather than as application data. c.out must be locked.
+func (c *Conn) writeControlRecordLocked(typ recordType, data []byte) (int, error) {
+	if _, ok := c.out.cipher.(kTLSCipher); !ok {
+		return c.writeRecordLocked(typ, data)
+	}
+	switch typ {
+	case recordTypeAlert, recordTypeHandshake, recordTypeChangeCipherSpec:
+	default:
+		panic("tls: internal error: not a control record type")
+	}
+	n, err := ktlsSendCtrlMessage(c.ktlsConn(), typ, data, c.config.KTLSSeccompCompat)
+	if n > 0 {
+		c.stats.ktlsControlRecordsSent.Add(1)
+		c.observeRecord(true, typ, data[:n], true)
+	}
+	if err == nil && n > 0 && c.ktlsVerifyTX != nil {
+		err = c.ktlsVerifyTX.record(c.ktlsConn(), data[:n])
+	}
+	return n, err
+}
+
 // sendAlert sends a TLS alert message.
 func (c *Conn) sendAlertLocked(err alert) error {
 	switch err {
@@ -855,7 +1086,7 @@ func (c *Conn) sendAlertLocked(err alert) error {
 	}
 	c.tmp[1] = byte(err)
 
-	_, writeErr := c.writeRecordLocked(recordTypeAlert, c.tmp[0:2])
+	_, writeErr := c.writeControlRecordLocked(recordTypeAlert, c.tmp[0:2])
 	if err == alertCloseNotify {
 		// closeNotify is a special case in that it isn't an error.
 		return writeErr
@@ -902,16 +1133,48 @@ const (
 // In the interests of simplicity and determinism, this code does not attempt
 // to reset the record size once the connection is idle, however.
 func (c *Conn) maxPayloadSizeForWrite(typ recordType) int {
-	if c.config.DynamicRecordSizingDisabled || typ != recordTypeApplicationData {
+	if typ != recordTypeApplicationData {
 		return maxPlaintext
 	}
+	mss := c.alignedMSS()
 
-	if c.bytesSent >= recordSizeBoostThreshold {
-		return maxPlaintext
+	if c.config.DynamicRecordSizingDisabled || c.bytesSent >= recordSizeBoostThreshold {
+		return c.maxAlignedPayload(mss)
 	}
 
 	// Subtract TLS overheads to get the maximum payload size.
-	payloadBytes := tcpMSSEstimate - recordHeaderLen - c.out.explicitNonceLen()
+	segment := tcpMSSEstimate
+	if mss > 0 {
+		segment = mss
+	}
+	payloadBytes := c.payloadForRecordSize(segment)
+
+	// Allow packet growth in arithmetic progression up to max.
+	pkt := c.packetsSent
+	c.packetsSent++
+	if pkt > 1000 {
+		return c.maxAlignedPayload(mss) // avoid overflow in multiply below
+	}
+
+	n := payloadBytes * int(pkt+1)
+	if mss > 0 {
+		n = c.payloadForRecordSize(segment * int(pkt+1))
+	}
+	if n > maxPlaintext {
+		n = c.maxAlignedPayload(mss)
+	}
+	return n
+}
+
+// payloadForRecordSize returns the payload size of an application data
+// record of size bytes, including its header, with the current cipher.
+func (c *Conn) payloadForRecordSize(size int) int {
+	var payloadBytes int
+	if _, ok := c.out.cipher.(kTLSCipher); ok {
+		payloadBytes = size - recordHeaderLen - c.ktlsRecordOverhead()
+	} else {
+		payloadBytes = size - recordHeaderLen - c.out.explicitNonceLen()
+	}
 	if c.out.cipher != nil {
 		switch ciph := c.out.cipher.(type) {
 		case cipher.Stream:
@@ -926,9 +1189,8 @@ func (c *Conn) maxPayloadSizeForWrite(typ recordType) int {
 			// The MAC is appended before padding so affects the
 			// payload size directly.
 			payloadBytes -= c.out.mac.Size()
-		// never reached, thus dead code
-		// case kTLSCipher:
-		// 	payloadBytes -= kTLSOverhead
+		case kTLSCipher:
+			// Accounted for by ktlsRecordOverhead above.
 		default:
 			panic("unknown cipher type")
 		}
@@ -936,19 +1198,7 @@ func (c *Conn) maxPayloadSizeForWrite(typ recordType) int {
 	if c.vers == VersionTLS13 {
 		payloadBytes-- // encrypted ContentType
 	}
-
-	// Allow packet growth in arithmetic progression up to max.
-	pkt := c.packetsSent
-	c.packetsSent++
-	if pkt > 1000 {
-		return maxPlaintext // avoid overflow in multiply below
-	}
-
-	n := payloadBytes * int(pkt+1)
-	if n > maxPlaintext {
-		n = maxPlaintext
-	}
-	return n
+	return payloadBytes
 }
 
 func (c *Conn) write(data []byte) (int, error) {
@@ -974,29 +1224,34 @@ func (c *Conn) flush() (int, error) {
 	return n, err
 }
 
-// outBufPool pools the record-sized scratch buffers used by writeRecordLocked.
-var outBufPool = sync.Pool{
-	New: func() any {
-		return new([]byte)
-	},
-}
-
 // writeRecordLocked writes a TLS record with the given type and payload to the
 // connection and updates the record layer state.
 func (c *Conn) writeRecordLocked(typ recordType, data []byte) (int, error) {
 	if _, ok := c.out.cipher.(kTLSCipher); ok {
-		switch typ {
-		case recordTypeAlert:
-			return ktlsSendCtrlMessage(c.conn.(*net.TCPConn), typ, data)
-		case recordTypeHandshake, recordTypeChangeCipherSpec:
-			return ktlsSendCtrlMessage(c.conn.(*net.TCPConn), typ, data)
-		case recordTypeApplicationData:
-			return c.write(data)
-		default:
-			panic("unknown record type")
+		if typ != recordTypeApplicationData {
+			return c.writeControlRecordLocked(typ, data)
 		}
+		var n int
+		var err error
+		if c.config.AlignRecordsToMSS {
+			n, err = c.writeAlignedKTLS(data)
+		} else {
+			n, err = c.write(data)
+		}
+		c.observeTXRecord(n)
+		if n > 0 {
+			c.observeRecord(true, typ, data[:n], true)
+		}
+		if err == nil && n > 0 && c.ktlsVerifyTX != nil {
+			err = c.ktlsVerifyTX.record(c.ktlsConn(), data[:n])
+		}
+		return n, err
 	}
-	outBufPtr := outBufPool.Get().(*[]byte)
+	sizeHint := len(data)
+	if sizeHint > maxPlaintext {
+		sizeHint = maxPlaintext
+	}
+	outBufPtr := getRecordBuf(sizeHint + recordBufOverhead)
 	outBuf := *outBufPtr
 	defer func() {
 		// You might be tempted to simplify this by just passing &outBuf to Put,
@@ -1005,7 +1260,7 @@ func (c *Conn) writeRecordLocked(typ recordType, data []byte) (int, error) {
 		// pointer to the slice header returned by Get, which is already on the
 		// heap, and overwrite and return that.
 		*outBufPtr = outBuf
-		outBufPool.Put(outBufPtr)
+		putRecordBuf(outBufPtr)
 	}()
 
 	var n int
@@ -1032,6 +1287,9 @@ func (c *Conn) writeRecordLocked(typ recordType, data []byte) (int, error) {
 		outBuf[3] = byte(m >> 8)
 		outBuf[4] = byte(m)
 
+		if typ == recordTypeApplicationData && c.vers == VersionTLS13 {
+			c.out.padding = c.config.recordPadding(m)
+		}
 		var err error
 		outBuf, err = c.out.encrypt(outBuf, data[:m], c.config.rand())
 		if err != nil {
@@ -1040,6 +1298,10 @@ func (c *Conn) writeRecordLocked(typ recordType, data []byte) (int, error) {
 		if _, err := c.write(outBuf); err != nil {
 			return n, err
 		}
+		if typ == recordTypeApplicationData {
+			c.observeTXRecord(m)
+		}
+		c.observeRecord(true, typ, data[:m], false)
 		n += m
 		data = data[m:]
 	}
@@ -1199,6 +1461,9 @@ func (c *Conn) Write(b []byte) (int, error) {
 
 	c.out.Lock()
 	defer c.out.Unlock()
+	if err := c.ktlsAwaitRestoreLocked(&c.out); err != nil {
+		return 0, err
+	}
 
 	if err := c.out.err; err != nil {
 		return 0, err
@@ -1208,10 +1473,17 @@ func (c *Conn) Write(b []byte) (int, error) {
 		return 0, alertInternalError
 	}
 
+	c.txStart = time.Now()
+	defer func() { c.txStart = time.Time{} }()
+
 	if c.closeNotifySent {
 		return 0, errShutdown
 	}
 
+	if c.config.WriteCoalesceDelay > 0 && c.vers != VersionTLS10 {
+		return c.coalesceWriteLocked(b)
+	}
+
 	// TLS 1.0 is susceptible to a chosen-plaintext
 	// attack when using block mode ciphers due to predictable IVs.
 	// This can be prevented by splitting each Application Data
@@ -1304,6 +1576,10 @@ func (c *Conn) handlePostHandshakeMessage() error {
 		return c.handleNewSessionTicket(msg)
 	case *keyUpdateMsg:
 		return c.handleKeyUpdate(msg)
+	case *certificateRequestMsgTLS13:
+		return c.handlePostHandshakeCertificateRequest(msg)
+	case *certificateMsgTLS13:
+		return c.handlePostHandshakeCertificate(msg)
 	default:
 		c.sendAlert(alertUnexpectedMessage)
 		return fmt.Errorf("tls: received unexpected handshake message of type %T", msg)
@@ -1316,8 +1592,19 @@ func (c *Conn) handleKeyUpdate(keyUpdate *keyUpdateMsg) error {
 		return c.in.setErrorLocked(c.sendAlert(alertInternalError))
 	}
 
+	// With kernel TLS, the new keys are programmed into the kernel, which
+	// must support replacing them. Check both directions before acting on
+	// the message, as the kernel can't be told to go back.
+	if err := c.ktlsCheckKeyUpdate(keyUpdate.updateRequested); err != nil {
+		c.sendAlert(alertInternalError)
+		return c.in.setErrorLocked(err)
+	}
+
 	newSecret := cipherSuite.nextTrafficSecret(c.in.trafficSecret)
-	c.in.setTrafficSecret(cipherSuite, newSecret)
+	if err := c.setTrafficSecret(&c.in, cipherSuite, newSecret); err != nil {
+		c.sendAlert(alertInternalError)
+		return c.in.setErrorLocked(err)
+	}
 
 	if keyUpdate.updateRequested {
 		c.out.Lock()
@@ -1336,7 +1623,10 @@ func (c *Conn) handleKeyUpdate(keyUpdate *keyUpdateMsg) error {
 		}
 
 		newSecret := cipherSuite.nextTrafficSecret(c.out.trafficSecret)
-		c.out.setTrafficSecret(cipherSuite, newSecret)
+		if err := c.setTrafficSecret(&c.out, cipherSuite, newSecret); err != nil {
+			// Surface the error at the next write.
+			c.out.setErrorLocked(err)
+		}
 	}
 
 	return nil
@@ -1357,9 +1647,22 @@ func (c *Conn) Read(b []byte) (int, error) {
 		// Read(nil) for the side effect of the Handshake.
 		return 0, nil
 	}
+	if err := c.flushCoalescedForRead(); err != nil {
+		return 0, err
+	}
+
+	// Report a deferred TLS_RX fallback once c.in is released.
+	defer c.reportKTLS()
 
 	c.in.Lock()
 	defer c.in.Unlock()
+	if err := c.ktlsAwaitRestoreLocked(&c.in); err != nil {
+		return 0, err
+	}
+
+	if c.ktlsDeferRX {
+		c.enableDeferredKTLSRX()
+	}
 
 	for c.input.Len() == 0 {
 		if err := c.readRecord(); err != nil {
@@ -1373,6 +1676,9 @@ func (c *Conn) Read(b []byte) (int, error) {
 	}
 
 	n, _ := c.input.Read(b)
+	if c.input.Len() == 0 {
+		c.observeRXDelivered()
+	}
 
 	// If a close-notify alert is waiting, read it so that we can return (n,
 	// EOF) instead of (n, nil), to signal to the HTTP response reading
@@ -1387,6 +1693,7 @@ func (c *Conn) Read(b []byte) (int, error) {
 			return n, err // will be io.EOF on closeNotify
 		}
 	}
+	c.releaseRawInput()
 
 	return n, nil
 }
@@ -1404,6 +1711,14 @@ func (c *Conn) Close() error {
 			break
 		}
 	}
+	c.uncountKTLSOffload()
+	c.ktlsCloseRing()
+	if c.ktlsAbandonCheckpoint() {
+		// The Read and Write calls waiting for RestoreKTLS fail rather than
+		// use a kernel state that may be stale, and no close_notify is
+		// sent for the same reason.
+		return c.conn.Close()
+	}
 	if x != 0 {
 		// io.Writer and io.Closer should not be used concurrently.
 		// If Close is called while a Write is currently in-flight,
@@ -1416,6 +1731,11 @@ func (c *Conn) Close() error {
 
 	var alertErr error
 	if c.isHandshakeComplete.Load() {
+		if c.config.OnAdvice != nil {
+			for _, advice := range c.Advise() {
+				c.config.OnAdvice(c, advice)
+			}
+		}
 		if err := c.closeNotify(); err != nil {
 			alertErr = fmt.Errorf("tls: failed to send closeNotify alert (but connection was closed anyway): %w", err)
 		}
@@ -1445,12 +1765,21 @@ func (c *Conn) closeNotify() error {
 	defer c.out.Unlock()
 
 	if !c.closeNotifySent {
-		// Set a Write Deadline to prevent possibly blocking forever.
-		c.SetWriteDeadline(time.Now().Add(time.Second * 5))
+		// Set a Write Deadline to prevent possibly blocking forever, for
+		// example on a peer that stopped reading and advertises a zero
+		// window, unless the user already set an earlier one.
+		deadline := time.Now().Add(c.config.closeTimeout())
+		if d := c.writeDeadline.Load(); d != 0 && d < deadline.UnixNano() {
+			deadline = time.Unix(0, d)
+		}
+		c.conn.SetWriteDeadline(deadline)
+		if err := c.flushCoalescedLocked(); err != nil {
+			c.debugln("tls: failed to flush coalesced writes:", err)
+		}
 		c.closeNotifyErr = c.sendAlertLocked(alertCloseNotify)
 		c.closeNotifySent = true
 		// Any subsequent writes will fail.
-		c.SetWriteDeadline(time.Now())
+		c.conn.SetWriteDeadline(time.Now())
 	}
 	return c.closeNotifyErr
 }
@@ -1491,6 +1820,12 @@ func (c *Conn) handshakeContext(ctx context.Context) (ret error) {
 		return nil
 	}
 
+	if timeout := c.config.HandshakeTimeout; timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+		defer cancelTimeout()
+	}
+
 	handshakeCtx, cancel := context.WithCancel(ctx)
 	// Note: defer this before starting the "interrupter" goroutine
 	// so that we can tell the difference between the input being canceled and
@@ -1524,6 +1859,14 @@ func (c *Conn) handshakeContext(ctx context.Context) (ret error) {
 		}()
 	}
 
+	if c.config.DebugConn != nil && c.debugChecked.CompareAndSwap(false, true) && c.config.DebugConn(c) {
+		c.debug.Store(true)
+	}
+
+	// Report the kernel TLS outcome once the locks below are released, so
+	// that callbacks are free to use c.
+	defer c.reportKTLS()
+
 	c.handshakeMutex.Lock()
 	defer c.handshakeMutex.Unlock()
 
@@ -1537,9 +1880,13 @@ func (c *Conn) handshakeContext(ctx context.Context) (ret error) {
 	c.in.Lock()
 	defer c.in.Unlock()
 
+	start := time.Now()
 	c.handshakeErr = c.handshakeFn(handshakeCtx)
 	if c.handshakeErr == nil {
+		c.handshakeTime = time.Since(start)
+		c.stats.handshakeLatency.observe(latencyBounds, int64(c.handshakeTime))
 		c.handshakes++
+		c.releaseRawInput()
 	} else {
 		// If an error occurred during the handshake try to flush the
 		// alert that might be left in the buffer.
@@ -1580,6 +1927,8 @@ func (c *Conn) connectionStateLocked() ConnectionState {
 	state.VerifiedChains = c.verifiedChains
 	state.SignedCertificateTimestamps = c.scts
 	state.OCSPResponse = c.ocspResponse
+	state.KTLS = c.kernelTLSState()
+	state.SCTResults = c.sctResults
 	if !c.didResume && c.vers != VersionTLS13 {
 		if c.clientFinishedIsFirst {
 			state.TLSUnique = c.clientFinished[:]
@@ -0,0 +1,54 @@
+package tls
+
+import "context"
+
+type correlationIDKey struct{}
+
+// WithCorrelationID returns a copy of ctx carrying the correlation ID id.
+// A Conn whose context is set with SetContext reports the ID through
+// CorrelationID, its debug log, ConnStats and KTLSState.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// CorrelationIDFromContext returns the correlation ID carried by ctx, or
+// the empty string if there is none.
+func CorrelationIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// connContext is the context attached to a Conn by SetContext.
+type connContext struct {
+	ctx context.Context
+	id  string
+}
+
+// SetContext attaches ctx to the connection, so that its events can be tied
+// back to the request that created it. Only the values of ctx are used: its
+// cancellation and deadline do not affect the connection. If ctx carries a
+// correlation ID, see WithCorrelationID, it is included in the connection's
+// debug log, in Stats and in the KTLSState reported to Config.OnKTLSFallback.
+//
+// SetContext may be called at any time, concurrently with other methods.
+func (c *Conn) SetContext(ctx context.Context) {
+	c.ctx.Store(&connContext{ctx: ctx, id: CorrelationIDFromContext(ctx)})
+}
+
+// Context returns the context attached to the connection with SetContext,
+// or context.Background if there is none.
+func (c *Conn) Context() context.Context {
+	if cc := c.ctx.Load(); cc != nil {
+		return cc.ctx
+	}
+	return context.Background()
+}
+
+// CorrelationID returns the correlation ID of the context attached to the
+// connection with SetContext, or the empty string if there is none.
+func (c *Conn) CorrelationID() string {
+	if cc := c.ctx.Load(); cc != nil {
+		return cc.id
+	}
+	return ""
+}
@@ -0,0 +1,38 @@
+package tls
+
+import (
+	"context"
+	"testing"
+)
+
+func TestConnContext(t *testing.T) {
+	var got KTLSState
+	config := testConfig.Clone()
+	config.OnKTLSFallback = func(conn *Conn, state KTLSState) {
+		got = state
+	}
+	c := &Conn{config: config}
+	if c.Context() == nil || c.CorrelationID() != "" {
+		t.Fatalf("unexpected context on a new Conn")
+	}
+
+	type key struct{}
+	ctx := context.WithValue(WithCorrelationID(context.Background(), "req-42"), key{}, "v")
+	c.SetContext(ctx)
+	if c.Context().Value(key{}) != "v" {
+		t.Errorf("Context does not return the attached context")
+	}
+	if id := c.CorrelationID(); id != "req-42" {
+		t.Errorf("CorrelationID = %q, want %q", id, "req-42")
+	}
+	if id := c.Stats().CorrelationID; id != "req-42" {
+		t.Errorf("Stats().CorrelationID = %q, want %q", id, "req-42")
+	}
+
+	c.ktlsState = KTLSState{RXReason: "TLS_RX setsockopt failed"}
+	c.ktlsReport.Store(true)
+	c.reportKTLS()
+	if got.CorrelationID != "req-42" {
+		t.Errorf("OnKTLSFallback CorrelationID = %q, want %q", got.CorrelationID, "req-42")
+	}
+}
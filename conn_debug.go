@@ -0,0 +1,56 @@
+package tls
+
+import (
+	"fmt"
+	"log"
+)
+
+// SetDebug enables or disables debug logging for the connection, such as
+// each step of enabling and restoring kernel TLS and why it failed.
+// Messages are written with the standard log package and prefixed with the
+// connection's correlation ID, if any, see SetContext.
+// If a Logger is set with Config.Logger or SetLogger, messages are passed
+// to its Debug method instead, whether or not SetDebug was called.
+//
+// Builds with the debug tag log for every connection regardless of
+// SetDebug. See also Config.DebugConn. SetDebug may be called at any time,
+// concurrently with other methods.
+func (c *Conn) SetDebug(on bool) {
+	c.debug.Store(on)
+}
+
+// debugln logs a debug message for c to its Logger or, without one, with
+// the log package, prefixed with its correlation ID. Outside builds with
+// the debug tag, it only logs for connections with debug logging enabled
+// or a Logger, see SetDebug.
+func (c *Conn) debugln(a ...interface{}) {
+	l := c.logger()
+	if !Dev && l == nil && !c.debug.Load() {
+		return
+	}
+	if l != nil {
+		l.Debug(sprintln(a...), c.logArgs(nil)...)
+		return
+	}
+	if id := c.CorrelationID(); id != "" {
+		a = append([]interface{}{"[" + id + "]"}, a...)
+	}
+	log.Println(a...)
+}
+
+// debugf is like debugln, formatting the message as log.Printf.
+func (c *Conn) debugf(format string, a ...interface{}) {
+	l := c.logger()
+	if !Dev && l == nil && !c.debug.Load() {
+		return
+	}
+	if l != nil {
+		l.Debug(fmt.Sprintf(format, a...), c.logArgs(nil)...)
+		return
+	}
+	if id := c.CorrelationID(); id != "" {
+		format = "[%s] " + format
+		a = append([]interface{}{id}, a...)
+	}
+	log.Printf(format, a...)
+}
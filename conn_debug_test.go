@@ -0,0 +1,57 @@
+package tls
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestConnDebug(t *testing.T) {
+	var buf bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&buf)
+
+	var calls int
+	config := testConfig.Clone()
+	config.DebugConn = func(c *Conn) bool {
+		calls++
+		return c.CorrelationID() == "debug-me"
+	}
+
+	c, s := localPipe(t)
+	client := Client(c, config)
+	client.SetContext(WithCorrelationID(client.Context(), "debug-me"))
+	server := Server(s, testConfig.Clone())
+	defer client.Close()
+	defer server.Close()
+
+	errs := make(chan error, 1)
+	go func() { errs <- server.Handshake() }()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	client.Handshake()
+	if calls != 1 {
+		t.Errorf("DebugConn called %d times, want 1", calls)
+	}
+	if !client.debug.Load() || server.debug.Load() {
+		t.Fatalf("debug = %v, %v; want true, false", client.debug.Load(), server.debug.Load())
+	}
+
+	buf.Reset()
+	client.debugf("kTLS: %s", "test")
+	server.debugf("kTLS: %s", "other")
+	client.SetDebug(false)
+	client.debugln("kTLS:", "disabled")
+	out := buf.String()
+	if !strings.Contains(out, "[debug-me] kTLS: test") {
+		t.Errorf("missing prefixed message in %q", out)
+	}
+	if !Dev && (strings.Contains(out, "other") || strings.Contains(out, "disabled")) {
+		t.Errorf("message logged without debug enabled: %q", out)
+	}
+}
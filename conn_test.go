@@ -285,3 +285,59 @@ func TestHairpinInClose(t *testing.T) {
 	// This call should not deadlock.
 	tlsConn.Close()
 }
+
+func TestKeyUpdate(t *testing.T) {
+	clientConfig := testConfig.Clone()
+	clientConfig.MinVersion = VersionTLS13
+	c, s := localPipe(t)
+	client := Client(c, clientConfig)
+	server := Server(s, testConfig.Clone())
+	defer client.Close()
+	defer server.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		if err := server.Handshake(); err != nil {
+			errs <- err
+			return
+		}
+		// Send a KeyUpdate requesting one from the client, then switch
+		// to the next traffic secret, like a peer rotating its keys.
+		server.out.Lock()
+		msg, err := (&keyUpdateMsg{updateRequested: true}).marshal()
+		if err == nil {
+			_, err = server.writeRecordLocked(recordTypeHandshake, msg)
+		}
+		if err == nil {
+			suite := cipherSuiteTLS13ByID(server.cipherSuite)
+			err = server.setTrafficSecret(&server.out, suite, suite.nextTrafficSecret(server.out.trafficSecret))
+		}
+		server.out.Unlock()
+		if err == nil {
+			_, err = server.Write([]byte("ping"))
+		}
+		if err == nil {
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(server, buf)
+		}
+		errs <- err
+	}()
+
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("got %q, want ping", buf)
+	}
+	if _, err := client.Write([]byte("pong")); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(client.in.trafficSecret, server.out.trafficSecret) ||
+		!bytes.Equal(client.out.trafficSecret, server.in.trafficSecret) {
+		t.Error("client and server traffic secrets differ after KeyUpdate")
+	}
+}
@@ -0,0 +1,291 @@
+package tls
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// A CTLog is a Certificate Transparency log whose signed certificate
+// timestamps are accepted by Config.CTLogs.
+type CTLog struct {
+	// Description is a human-readable name for the log.
+	Description string
+	// PublicKey is the log's ECDSA P-256 or RSA public key.
+	PublicKey crypto.PublicKey
+}
+
+// SCTSource identifies how a signed certificate timestamp was delivered.
+type SCTSource int
+
+const (
+	// SCTSourceEmbedded is an SCT embedded in the certificate.
+	SCTSourceEmbedded SCTSource = iota
+	// SCTSourceTLSExtension is an SCT sent in the signed_certificate_timestamp
+	// TLS extension.
+	SCTSourceTLSExtension
+)
+
+func (s SCTSource) String() string {
+	switch s {
+	case SCTSourceEmbedded:
+		return "embedded"
+	case SCTSourceTLSExtension:
+		return "TLS extension"
+	}
+	return fmt.Sprintf("SCTSource(%d)", int(s))
+}
+
+// SCTResult is the outcome of verifying one signed certificate timestamp.
+type SCTResult struct {
+	Source SCTSource
+	// LogID is the SHA-256 hash of the issuing log's public key.
+	LogID [32]byte
+	// Log is the entry of Config.CTLogs with a matching LogID, or nil if
+	// the log is unknown.
+	Log       *CTLog
+	Timestamp time.Time
+	// Err is nil if the SCT was issued by Log for the server's certificate.
+	Err error
+}
+
+var (
+	errSCTMalformed  = errors.New("tls: malformed signed certificate timestamp")
+	errSCTUnknownLog = errors.New("tls: signed certificate timestamp from an unknown log")
+	errSCTSignature  = errors.New("tls: invalid signed certificate timestamp signature")
+	errSCTNoIssuer   = errors.New("tls: issuer of certificate with embedded SCTs is unknown")
+	errSCTFuture     = errors.New("tls: signed certificate timestamp is in the future")
+	errSCTPolicy     = errors.New("tls: no valid signed certificate timestamp from a known log")
+)
+
+// oidExtensionSCT is the X.509 extension carrying embedded SCTs. See RFC
+// 6962, Section 3.3.
+var oidExtensionSCT = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// defaultCTPolicy accepts the connection if at least one SCT was verified.
+func defaultCTPolicy(results []SCTResult) error {
+	for _, r := range results {
+		if r.Err == nil {
+			return nil
+		}
+	}
+	return errSCTPolicy
+}
+
+// verifySCTs verifies the SCTs embedded in the server's certificate and
+// those received in the handshake against c.config.CTLogs, records the
+// results and applies c.config.CTPolicy. It must be called after
+// c.peerCertificates and c.verifiedChains are set.
+func (c *Conn) verifySCTs() error {
+	logs := make(map[[32]byte]*CTLog, len(c.config.CTLogs))
+	for _, l := range c.config.CTLogs {
+		spki, err := x509.MarshalPKIXPublicKey(l.PublicKey)
+		if err != nil {
+			return fmt.Errorf("tls: invalid CT log public key for %q: %v", l.Description, err)
+		}
+		logs[sha256.Sum256(spki)] = l
+	}
+
+	leaf := c.peerCertificates[0]
+	var issuer *x509.Certificate
+	if len(c.verifiedChains) > 0 && len(c.verifiedChains[0]) > 1 {
+		issuer = c.verifiedChains[0][1]
+	} else if len(c.peerCertificates) > 1 {
+		issuer = c.peerCertificates[1]
+	}
+
+	var results []SCTResult
+	for _, ext := range leaf.Extensions {
+		if !ext.Id.Equal(oidExtensionSCT) {
+			continue
+		}
+		var list []byte
+		rest, err := asn1.Unmarshal(ext.Value, &list)
+		if err == nil && len(rest) != 0 {
+			err = errSCTMalformed
+		}
+		scts, ok := parseSCTList(list)
+		if err != nil || !ok {
+			results = append(results, SCTResult{Source: SCTSourceEmbedded, Err: errSCTMalformed})
+			continue
+		}
+		for _, sct := range scts {
+			results = append(results, c.verifySCT(logs, sct, SCTSourceEmbedded, leaf, issuer))
+		}
+	}
+	for _, sct := range c.scts {
+		results = append(results, c.verifySCT(logs, sct, SCTSourceTLSExtension, leaf, issuer))
+	}
+	c.sctResults = results
+
+	policy := c.config.CTPolicy
+	if policy == nil {
+		policy = defaultCTPolicy
+	}
+	return policy(results)
+}
+
+func parseSCTList(data []byte) ([][]byte, bool) {
+	s := cryptobyte.String(data)
+	var list cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&list) || !s.Empty() {
+		return nil, false
+	}
+	var scts [][]byte
+	for !list.Empty() {
+		var sct []byte
+		if !readUint16LengthPrefixed(&list, &sct) {
+			return nil, false
+		}
+		scts = append(scts, sct)
+	}
+	return scts, true
+}
+
+// verifySCT verifies a single serialized SCT. See RFC 6962, Section 3.2.
+func (c *Conn) verifySCT(logs map[[32]byte]*CTLog, sct []byte, source SCTSource, leaf, issuer *x509.Certificate) SCTResult {
+	r := SCTResult{Source: source}
+
+	s := cryptobyte.String(sct)
+	var version, hashAlg, sigAlg uint8
+	var logID, extensions, sig []byte
+	var timestamp uint64
+	if !s.ReadUint8(&version) || version != 0 ||
+		!s.ReadBytes(&logID, 32) ||
+		!readUint64(&s, &timestamp) ||
+		!readUint16LengthPrefixed(&s, &extensions) ||
+		!s.ReadUint8(&hashAlg) || !s.ReadUint8(&sigAlg) ||
+		!readUint16LengthPrefixed(&s, &sig) ||
+		!s.Empty() {
+		r.Err = errSCTMalformed
+		return r
+	}
+	copy(r.LogID[:], logID)
+	r.Timestamp = time.UnixMilli(int64(timestamp))
+
+	if r.Log = logs[r.LogID]; r.Log == nil {
+		r.Err = errSCTUnknownLog
+		return r
+	}
+	if r.Timestamp.After(c.config.time()) {
+		r.Err = errSCTFuture
+		return r
+	}
+
+	var b cryptobyte.Builder
+	b.AddUint8(0) // version v1
+	b.AddUint8(0) // signature_type certificate_timestamp
+	addUint64(&b, timestamp)
+	if source == SCTSourceEmbedded {
+		if issuer == nil {
+			r.Err = errSCTNoIssuer
+			return r
+		}
+		tbs, ok := removeSCTExtension(leaf.RawTBSCertificate)
+		if !ok {
+			r.Err = errSCTMalformed
+			return r
+		}
+		issuerKeyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+		b.AddUint16(1) // precert_entry
+		b.AddBytes(issuerKeyHash[:])
+		b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(tbs)
+		})
+	} else {
+		b.AddUint16(0) // x509_entry
+		b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(leaf.Raw)
+		})
+	}
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(extensions)
+	})
+	signed, err := b.Bytes()
+	if err != nil {
+		r.Err = err
+		return r
+	}
+
+	// Logs sign with SHA-256 and either ECDSA or RSA PKCS #1 v1.5.
+	const hashSHA256, sigRSA, sigECDSA = 4, 1, 3
+	h := sha256.Sum256(signed)
+	r.Err = errSCTSignature
+	switch pub := r.Log.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		if hashAlg == hashSHA256 && sigAlg == sigECDSA && ecdsa.VerifyASN1(pub, h[:], sig) {
+			r.Err = nil
+		}
+	case *rsa.PublicKey:
+		if hashAlg == hashSHA256 && sigAlg == sigRSA && rsa.VerifyPKCS1v15(pub, crypto.SHA256, h[:], sig) == nil {
+			r.Err = nil
+		}
+	}
+	return r
+}
+
+// removeSCTExtension returns tbs, a DER-encoded TBSCertificate, with the
+// embedded SCT extension removed, reconstructing the precertificate
+// TBSCertificate that the log signed. See RFC 6962, Section 3.2.
+func removeSCTExtension(tbs []byte) ([]byte, bool) {
+	input := cryptobyte.String(tbs)
+	var fields cryptobyte.String
+	if !input.ReadASN1(&fields, cryptobyte_asn1.SEQUENCE) || !input.Empty() {
+		return nil, false
+	}
+
+	extensionsTag := cryptobyte_asn1.Tag(3).Constructed().ContextSpecific()
+	var b cryptobyte.Builder
+	b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		for !fields.Empty() {
+			var field cryptobyte.String
+			var tag cryptobyte_asn1.Tag
+			if !fields.ReadAnyASN1Element(&field, &tag) {
+				b.SetError(errSCTMalformed)
+				return
+			}
+			if tag != extensionsTag {
+				b.AddBytes(field)
+				continue
+			}
+			var wrapped, exts cryptobyte.String
+			if !field.ReadASN1(&wrapped, extensionsTag) ||
+				!wrapped.ReadASN1(&exts, cryptobyte_asn1.SEQUENCE) {
+				b.SetError(errSCTMalformed)
+				return
+			}
+			b.AddASN1(extensionsTag, func(b *cryptobyte.Builder) {
+				b.AddASN1(cryptobyte_asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					for !exts.Empty() {
+						var ext, body cryptobyte.String
+						var id asn1.ObjectIdentifier
+						if !exts.ReadASN1Element(&ext, cryptobyte_asn1.SEQUENCE) {
+							b.SetError(errSCTMalformed)
+							return
+						}
+						elem := ext
+						if !elem.ReadASN1(&body, cryptobyte_asn1.SEQUENCE) ||
+							!body.ReadASN1ObjectIdentifier(&id) {
+							b.SetError(errSCTMalformed)
+							return
+						}
+						if !id.Equal(oidExtensionSCT) {
+							b.AddBytes(ext)
+						}
+					}
+				})
+			})
+		}
+	})
+	out, err := b.Bytes()
+	return out, err == nil
+}
@@ -0,0 +1,193 @@
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// testCTLog signs SCTs as a Certificate Transparency log would.
+type testCTLog struct {
+	key *ecdsa.PrivateKey
+	log *CTLog
+}
+
+func newTestCTLog(t *testing.T) *testCTLog {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCTLog{key: key, log: &CTLog{Description: "test log", PublicKey: &key.PublicKey}}
+}
+
+// sign returns a serialized SCT over entry, the entry_type and signed_entry
+// fields of the signed structure.
+func (l *testCTLog) sign(t *testing.T, timestamp time.Time, entry []byte) []byte {
+	var signed cryptobyte.Builder
+	signed.AddUint8(0)
+	signed.AddUint8(0)
+	addUint64(&signed, uint64(timestamp.UnixMilli()))
+	signed.AddBytes(entry)
+	signed.AddUint16(0) // no extensions
+	h := sha256.Sum256(signed.BytesOrPanic())
+	sig, err := ecdsa.SignASN1(rand.Reader, l.key, h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spki, err := x509.MarshalPKIXPublicKey(&l.key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logID := sha256.Sum256(spki)
+	var b cryptobyte.Builder
+	b.AddUint8(0)
+	b.AddBytes(logID[:])
+	addUint64(&b, uint64(timestamp.UnixMilli()))
+	b.AddUint16(0)
+	b.AddUint8(4) // sha256
+	b.AddUint8(3) // ecdsa
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(sig) })
+	return b.BytesOrPanic()
+}
+
+func ctTestCertificates(t *testing.T, log *testCTLog, now time.Time) (leaf Certificate, roots *x509.CertPool) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "CT test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.golang"},
+		DNSNames:     []string{"example.golang"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	precertDER, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	precert, err := x509.ParseCertificate(precertDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Embed an SCT over the certificate without the SCT extension.
+	var entry cryptobyte.Builder
+	entry.AddUint16(1)
+	issuerKeyHash := sha256.Sum256(ca.RawSubjectPublicKeyInfo)
+	entry.AddBytes(issuerKeyHash[:])
+	entry.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(precert.RawTBSCertificate) })
+	sct := log.sign(t, now.Add(-time.Minute), entry.BytesOrPanic())
+	var list cryptobyte.Builder
+	list.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(sct) })
+	})
+	value, err := asn1.Marshal(list.BytesOrPanic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.ExtraExtensions = []pkix.Extension{{Id: oidExtensionSCT, Value: value}}
+	leafDER, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots = x509.NewCertPool()
+	roots.AddCert(ca)
+	return Certificate{Certificate: [][]byte{leafDER, caDER}, PrivateKey: key}, roots
+}
+
+func TestCTVerification(t *testing.T) {
+	now := time.Now()
+	log := newTestCTLog(t)
+	cert, roots := ctTestCertificates(t, log, now)
+
+	// Also send an SCT for the final certificate in the TLS extension.
+	var entry cryptobyte.Builder
+	entry.AddUint16(0)
+	entry.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(cert.Certificate[0]) })
+	cert.SignedCertificateTimestamps = [][]byte{log.sign(t, now.Add(-time.Minute), entry.BytesOrPanic())}
+
+	for _, vers := range []uint16{VersionTLS12, VersionTLS13} {
+		serverConfig := testConfig.Clone()
+		serverConfig.Time = nil
+		serverConfig.MaxVersion = vers
+		serverConfig.Certificates = []Certificate{cert}
+		clientConfig := testConfig.Clone()
+		clientConfig.Time = nil
+		clientConfig.InsecureSkipVerify = false
+		clientConfig.RootCAs = roots
+		clientConfig.ServerName = "example.golang"
+		clientConfig.CTLogs = []*CTLog{log.log}
+
+		_, state, err := testHandshake(t, clientConfig, serverConfig)
+		if err != nil {
+			t.Fatalf("TLS %x: %v", vers, err)
+		}
+		if len(state.SCTResults) != 2 {
+			t.Fatalf("TLS %x: got %d results, want 2", vers, len(state.SCTResults))
+		}
+		for i, source := range []SCTSource{SCTSourceEmbedded, SCTSourceTLSExtension} {
+			r := state.SCTResults[i]
+			if r.Source != source || r.Err != nil || r.Log != log.log {
+				t.Errorf("TLS %x: result %d = %+v, want a valid %v SCT", vers, i, r, source)
+			}
+		}
+
+		// SCTs from another log are rejected by the default policy.
+		clientConfig.CTLogs = []*CTLog{newTestCTLog(t).log}
+		if _, _, err := testHandshake(t, clientConfig, serverConfig); err == nil {
+			t.Errorf("TLS %x: handshake succeeded without SCTs from a known log", vers)
+		}
+	}
+}
+
+func TestRemoveSCTExtension(t *testing.T) {
+	log := newTestCTLog(t)
+	cert, _ := ctTestCertificates(t, log, time.Now())
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbs, ok := removeSCTExtension(leaf.RawTBSCertificate)
+	if !ok {
+		t.Fatal("failed to remove the SCT extension")
+	}
+	if len(tbs) >= len(leaf.RawTBSCertificate) {
+		t.Errorf("TBSCertificate did not shrink")
+	}
+}
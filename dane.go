@@ -0,0 +1,147 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"errors"
+)
+
+// TLSA certificate usages. See RFC 6698, Section 2.1.1, and RFC 7218.
+const (
+	TLSAUsagePKIXTA = 0
+	TLSAUsagePKIXEE = 1
+	TLSAUsageDANETA = 2
+	TLSAUsageDANEEE = 3
+)
+
+// TLSA selectors. See RFC 6698, Section 2.1.2.
+const (
+	TLSASelectorCert = 0
+	TLSASelectorSPKI = 1
+)
+
+// TLSA matching types. See RFC 6698, Section 2.1.3.
+const (
+	TLSAMatchingFull   = 0
+	TLSAMatchingSHA256 = 1
+	TLSAMatchingSHA512 = 2
+)
+
+// A TLSARecord is a DNS TLSA resource record associating a certificate or
+// public key with a server. See RFC 6698.
+type TLSARecord struct {
+	Usage        uint8
+	Selector     uint8
+	MatchingType uint8
+	Data         []byte
+}
+
+// matches reports whether cert matches the selector and data of r.
+func (r TLSARecord) matches(cert *x509.Certificate) bool {
+	var selected []byte
+	switch r.Selector {
+	case TLSASelectorCert:
+		selected = cert.Raw
+	case TLSASelectorSPKI:
+		selected = cert.RawSubjectPublicKeyInfo
+	default:
+		return false
+	}
+	switch r.MatchingType {
+	case TLSAMatchingFull:
+		return bytes.Equal(selected, r.Data)
+	case TLSAMatchingSHA256:
+		h := sha256.Sum256(selected)
+		return bytes.Equal(h[:], r.Data)
+	case TLSAMatchingSHA512:
+		h := sha512.Sum512(selected)
+		return bytes.Equal(h[:], r.Data)
+	}
+	return false
+}
+
+var errTLSANoMatch = errors.New("tls: server certificate does not match any TLSA record")
+
+// verifyDANE verifies the server's certificate chain, certs, against the
+// TLSA records, following RFC 7671. It returns the verified chains.
+//
+// DANE-EE records match the leaf certificate, with no further checks of the
+// certificate's names or validity period. DANE-TA records match a
+// certificate presented by the server, which is then used as the trust
+// anchor for the leaf. PKIX-TA and PKIX-EE records additionally require the
+// chain to be valid under the usual PKIX rules, with Config.RootCAs.
+func (c *Conn) verifyDANE(records []TLSARecord, certs []*x509.Certificate) ([][]*x509.Certificate, error) {
+	leaf := certs[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	var pkixChains [][]*x509.Certificate
+	var pkixErr error
+	pkixVerified := false
+	verifyPKIX := func() ([][]*x509.Certificate, error) {
+		if !pkixVerified {
+			pkixVerified = true
+			pkixChains, pkixErr = leaf.Verify(x509.VerifyOptions{
+				Roots:         c.config.RootCAs,
+				CurrentTime:   c.config.time(),
+				DNSName:       c.config.ServerName,
+				Intermediates: intermediates,
+			})
+		}
+		return pkixChains, pkixErr
+	}
+
+	err := errTLSANoMatch
+	for _, r := range records {
+		switch r.Usage {
+		case TLSAUsageDANEEE:
+			if r.matches(leaf) {
+				return [][]*x509.Certificate{{leaf}}, nil
+			}
+
+		case TLSAUsageDANETA:
+			for _, anchor := range certs[1:] {
+				if !r.matches(anchor) {
+					continue
+				}
+				roots := x509.NewCertPool()
+				roots.AddCert(anchor)
+				chains, verr := leaf.Verify(x509.VerifyOptions{
+					Roots:         roots,
+					CurrentTime:   c.config.time(),
+					DNSName:       c.config.ServerName,
+					Intermediates: intermediates,
+				})
+				if verr == nil {
+					return chains, nil
+				}
+				err = verr
+			}
+
+		case TLSAUsagePKIXTA, TLSAUsagePKIXEE:
+			chains, verr := verifyPKIX()
+			if verr != nil {
+				err = verr
+				continue
+			}
+			if r.Usage == TLSAUsagePKIXEE {
+				if r.matches(leaf) {
+					return chains, nil
+				}
+				continue
+			}
+			for _, chain := range chains {
+				for _, cert := range chain[1:] {
+					if r.matches(cert) {
+						return chains, nil
+					}
+				}
+			}
+		}
+	}
+	return nil, err
+}
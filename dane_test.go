@@ -0,0 +1,61 @@
+package tls
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestDANE(t *testing.T) {
+	now := time.Now()
+	cert, roots := ctTestCertificates(t, newTestCTLog(t), now)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafSPKI := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	caCert := sha256.Sum256(cert.Certificate[1])
+
+	tests := []struct {
+		name    string
+		records []TLSARecord
+		roots   *x509.CertPool
+		ok      bool
+	}{
+		{"DANE-EE", []TLSARecord{{TLSAUsageDANEEE, TLSASelectorSPKI, TLSAMatchingSHA256, leafSPKI[:]}}, nil, true},
+		{"DANE-EE full", []TLSARecord{{TLSAUsageDANEEE, TLSASelectorCert, TLSAMatchingFull, cert.Certificate[0]}}, nil, true},
+		{"DANE-EE mismatch", []TLSARecord{{TLSAUsageDANEEE, TLSASelectorSPKI, TLSAMatchingSHA256, caCert[:]}}, nil, false},
+		{"DANE-TA", []TLSARecord{{TLSAUsageDANETA, TLSASelectorCert, TLSAMatchingSHA256, caCert[:]}}, nil, true},
+		{"PKIX-EE", []TLSARecord{{TLSAUsagePKIXEE, TLSASelectorSPKI, TLSAMatchingSHA256, leafSPKI[:]}}, roots, true},
+		{"PKIX-EE untrusted", []TLSARecord{{TLSAUsagePKIXEE, TLSASelectorSPKI, TLSAMatchingSHA256, leafSPKI[:]}}, x509.NewCertPool(), false},
+		{"PKIX-TA", []TLSARecord{{TLSAUsagePKIXTA, TLSASelectorCert, TLSAMatchingSHA256, caCert[:]}}, roots, true},
+		{"unknown usage", []TLSARecord{{9, TLSASelectorSPKI, TLSAMatchingSHA256, leafSPKI[:]}}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConfig := testConfig.Clone()
+			serverConfig.Time = nil
+			serverConfig.Certificates = []Certificate{cert}
+			clientConfig := testConfig.Clone()
+			clientConfig.Time = nil
+			clientConfig.InsecureSkipVerify = false
+			clientConfig.RootCAs = tt.roots
+			clientConfig.ServerName = "example.golang"
+			clientConfig.GetTLSARecords = func(serverName string) ([]TLSARecord, error) {
+				if serverName != "example.golang" {
+					t.Errorf("GetTLSARecords called with %q", serverName)
+				}
+				return tt.records, nil
+			}
+
+			_, _, err := testHandshake(t, clientConfig, serverConfig)
+			if tt.ok && err != nil {
+				t.Fatalf("handshake failed: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("handshake succeeded")
+			}
+		})
+	}
+}
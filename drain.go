@@ -0,0 +1,60 @@
+package tls
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// drainBufSize is the size of the buffer late data is read into by Drain.
+const drainBufSize = 16 << 10
+
+// Drain shuts the connection down politely, as a client would before a
+// rolling restart: it stops writing by sending close_notify, as CloseWrite
+// does, and then reads and discards the data the peer still sends until the
+// peer closes its side of the connection. It returns the number of bytes
+// discarded.
+//
+// Drain returns nil once the peer's close_notify is received, and ctx.Err()
+// if ctx is done first, in which case the read deadline of the connection is
+// left in the past. Drain does not close the connection; Close must still be
+// called. It must not be called concurrently with Read.
+func (c *Conn) Drain(ctx context.Context) (n int64, ret error) {
+	if err := c.CloseWrite(); err != nil && err != errShutdown {
+		return 0, err
+	}
+
+	if ctx.Done() != nil {
+		done := make(chan struct{})
+		interruptRes := make(chan error, 1)
+		defer func() {
+			close(done)
+			if ctxErr := <-interruptRes; ctxErr != nil {
+				// Return context error to user.
+				ret = ctxErr
+			}
+		}()
+		go func() {
+			select {
+			case <-ctx.Done():
+				// Unblock Read, leaving the connection open for Close.
+				_ = c.conn.SetReadDeadline(time.Unix(1, 0))
+				interruptRes <- ctx.Err()
+			case <-done:
+				interruptRes <- nil
+			}
+		}()
+	}
+
+	buf := make([]byte, drainBufSize)
+	for {
+		m, err := c.Read(buf)
+		n += int64(m)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package tls
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	client, server := coalescePipe(t, 0)
+	defer client.Close()
+	defer server.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		// The server sees the client's close_notify, sends what it still
+		// had in flight and closes.
+		if _, err := server.Read(make([]byte, 1)); err != io.EOF {
+			serverErr <- err
+			return
+		}
+		if _, err := server.Write(make([]byte, 3000)); err != nil {
+			serverErr <- err
+			return
+		}
+		serverErr <- server.Close()
+	}()
+
+	n, err := client.Drain(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3000 {
+		t.Errorf("Drain discarded %d bytes, want 3000", n)
+	}
+	if err := <-serverErr; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Write([]byte("x")); err == nil {
+		t.Error("Write after Drain succeeded")
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	client, server := coalescePipe(t, 0)
+	defer client.Close()
+	defer server.Close()
+
+	go server.Write([]byte("late"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	n, err := client.Drain(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if n != 4 {
+		t.Errorf("Drain discarded %d bytes, want 4", n)
+	}
+}
@@ -0,0 +1,85 @@
+package tls
+
+import (
+	"bytes"
+	"testing"
+)
+
+func ekmPipe(t *testing.T, version uint16) (client, server *Conn) {
+	c, s := localPipe(t)
+	config := testConfig.Clone()
+	config.MinVersion = version
+	config.MaxVersion = version
+	client = Client(c, config)
+	server = Server(s, config)
+
+	errs := make(chan error, 1)
+	go func() { errs <- server.Handshake() }()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+func exportEKM(t *testing.T, c *Conn) []byte {
+	t.Helper()
+	cs := c.ConnectionState()
+	ekm, err := cs.ExportKeyingMaterial("EXPORTER_EAP_TLS_Key_Material", []byte{0x0d}, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ekm
+}
+
+// TestExportKeyingMaterialRetained checks that exported keying material does
+// not depend on the record layer state, which is handed to the kernel with
+// kernel TLS, nor on the connection being open.
+func TestExportKeyingMaterialRetained(t *testing.T) {
+	for _, version := range []uint16{VersionTLS12, VersionTLS13} {
+		client, server := ekmPipe(t, version)
+		want := exportEKM(t, client)
+		if got := exportEKM(t, server); !bytes.Equal(got, want) {
+			t.Fatalf("%x: client and server exported different keying material", version)
+		}
+		clientUnique := client.ConnectionState().TLSUnique
+		serverUnique := server.ConnectionState().TLSUnique
+		if version == VersionTLS13 {
+			if clientUnique != nil {
+				t.Errorf("%x: TLSUnique = %x, want nil", version, clientUnique)
+			}
+		} else if len(clientUnique) == 0 || !bytes.Equal(clientUnique, serverUnique) {
+			t.Errorf("%x: TLSUnique = %x and %x, want the same value", version, clientUnique, serverUnique)
+		}
+
+		// Drop the traffic secrets and keys as if they had been handed
+		// to the kernel.
+		for _, hc := range []*halfConn{&client.in, &client.out} {
+			hc.cipher = kTLSCipher{}
+			hc.trafficSecret, hc.key, hc.iv = nil, nil, nil
+		}
+		if got := exportEKM(t, client); !bytes.Equal(got, want) {
+			t.Errorf("%x: keying material changed once the keys were handed over", version)
+		}
+		if got := client.ConnectionState().TLSUnique; !bytes.Equal(got, clientUnique) {
+			t.Errorf("%x: TLSUnique changed once the keys were handed over", version)
+		}
+
+		server.Close()
+		client.conn.Close()
+		if got := exportEKM(t, server); !bytes.Equal(got, want) {
+			t.Errorf("%x: keying material changed after Close", version)
+		}
+	}
+}
+
+func TestExportKeyingMaterialBeforeHandshake(t *testing.T) {
+	c, _ := localPipe(t)
+	defer c.Close()
+	cs := Client(c, testConfig).ConnectionState()
+	if _, err := cs.ExportKeyingMaterial("label", nil, 32); err == nil {
+		t.Error("ExportKeyingMaterial succeeded before the handshake")
+	}
+}
@@ -3,7 +3,9 @@ module github.com/secure-for-ai/goktls
 go 1.20
 
 require (
+	github.com/google/go-tpm v0.9.0
+	github.com/miekg/pkcs11 v1.1.1
 	github.com/rogpeppe/go-internal v1.9.0
 	golang.org/x/crypto v0.5.0
-	golang.org/x/sys v0.4.0
+	golang.org/x/sys v0.8.0
 )
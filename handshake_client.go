@@ -105,6 +105,15 @@ func (c *Conn) makeClientHello() (*clientHelloMsg, *ecdh.PrivateKey, error) {
 		}
 		hello.cipherSuites = append(hello.cipherSuites, suiteId)
 	}
+	for _, suite := range optionalCipherSuites {
+		if mutualCipherSuite(configCipherSuites, suite.id) == nil {
+			continue
+		}
+		if hello.vers < VersionTLS12 && suite.flags&suiteTLS12 != 0 {
+			continue
+		}
+		hello.cipherSuites = append(hello.cipherSuites, suite.id)
+	}
 
 	_, err := io.ReadFull(config.rand(), hello.random)
 	if err != nil {
@@ -132,6 +141,7 @@ func (c *Conn) makeClientHello() (*clientHelloMsg, *ecdh.PrivateKey, error) {
 		} else {
 			hello.cipherSuites = append(hello.cipherSuites, defaultCipherSuitesTLS13NoAES...)
 		}
+		hello.cipherSuites = appendOptionalCipherSuitesTLS13(hello.cipherSuites, config.CipherSuites)
 
 		curveID := config.curvePreferences()[0]
 		if _, ok := curveForCurveID(curveID); !ok {
@@ -142,6 +152,7 @@ func (c *Conn) makeClientHello() (*clientHelloMsg, *ecdh.PrivateKey, error) {
 			return nil, nil, err
 		}
 		hello.keyShares = []keyShare{{group: curveID, data: key.PublicKey().Bytes()}}
+		hello.postHandshakeAuth = config.PostHandshakeAuth
 	}
 
 	return hello, key, nil
@@ -646,7 +657,7 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 		if sigType == signatureRSAPSS {
 			signOpts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: sigHash}
 		}
-		certVerify.signature, err = key.Sign(c.config.rand(), signed, signOpts)
+		certVerify.signature, err = c.config.handshakeSign(key, signed, signOpts)
 		if err != nil {
 			c.sendAlert(alertInternalError)
 			return err
@@ -879,7 +890,23 @@ func (c *Conn) verifyServerCertificate(certificates [][]byte) error {
 		certs[i] = cert.cert
 	}
 
-	if !c.config.InsecureSkipVerify {
+	var tlsaRecords []TLSARecord
+	if c.config.GetTLSARecords != nil {
+		var err error
+		if tlsaRecords, err = c.config.GetTLSARecords(c.config.ServerName); err != nil {
+			c.sendAlert(alertInternalError)
+			return err
+		}
+	}
+
+	if len(tlsaRecords) > 0 {
+		var err error
+		c.verifiedChains, err = c.verifyDANE(tlsaRecords, certs)
+		if err != nil {
+			c.sendAlert(alertBadCertificate)
+			return &CertificateVerificationError{UnverifiedCertificates: certs, Err: err}
+		}
+	} else if !c.config.InsecureSkipVerify {
 		opts := x509.VerifyOptions{
 			Roots:         c.config.RootCAs,
 			CurrentTime:   c.config.time(),
@@ -909,6 +936,13 @@ func (c *Conn) verifyServerCertificate(certificates [][]byte) error {
 	c.activeCertHandles = activeHandles
 	c.peerCertificates = certs
 
+	if len(c.config.CTLogs) > 0 {
+		if err := c.verifySCTs(); err != nil {
+			c.sendAlert(alertBadCertificate)
+			return err
+		}
+	}
+
 	if c.config.VerifyPeerCertificate != nil {
 		if err := c.config.VerifyPeerCertificate(certificates, c.verifiedChains); err != nil {
 			c.sendAlert(alertBadCertificate)
@@ -458,6 +458,10 @@ func (hs *clientHandshakeStateTLS13) readServerCertificate() error {
 
 	certReq, ok := msg.(*certificateRequestMsgTLS13)
 	if ok {
+		if len(certReq.context) != 0 {
+			c.sendAlert(alertIllegalParameter)
+			return errors.New("tls: received certificate request with a non-empty context")
+		}
 		hs.certReq = certReq
 
 		msg, err = c.readHandshake(hs.transcript)
@@ -475,6 +479,10 @@ func (hs *clientHandshakeStateTLS13) readServerCertificate() error {
 		c.sendAlert(alertDecodeError)
 		return errors.New("tls: received empty certificates message")
 	}
+	if len(certMsg.context) != 0 {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: received certificates message with a non-empty context")
+	}
 
 	c.scts = certMsg.certificate.SignedCertificateTimestamps
 	c.ocspResponse = certMsg.certificate.OCSPStaple
@@ -628,7 +636,7 @@ func (hs *clientHandshakeStateTLS13) sendClientCertificate() error {
 	if sigType == signatureRSAPSS {
 		signOpts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: sigHash}
 	}
-	sig, err := cert.PrivateKey.(crypto.Signer).Sign(c.config.rand(), signed, signOpts)
+	sig, err := c.config.handshakeSign(cert.PrivateKey.(crypto.Signer), signed, signOpts)
 	if err != nil {
 		c.sendAlert(alertInternalError)
 		return errors.New("tls: failed to sign handshake: " + err.Error())
@@ -653,6 +661,12 @@ func (hs *clientHandshakeStateTLS13) sendClientFinished() error {
 		return err
 	}
 
+	// Keep the handshake context for post-handshake authentication. See RFC
+	// 8446, Section 4.4.
+	if hs.hello.postHandshakeAuth {
+		c.postHandshakeTranscript = cloneHash(hs.transcript, hs.suite.hash)
+	}
+
 	c.out.setTrafficSecret(hs.suite, hs.trafficSecret)
 
 	if !c.config.SessionTicketsDisabled && c.config.ClientSessionCache != nil {
@@ -669,7 +683,9 @@ func (c *Conn) handleNewSessionTicket(msg *newSessionTicketMsgTLS13) error {
 		return errors.New("tls: received new session ticket from a client")
 	}
 
-	if c.config.SessionTicketsDisabled || c.config.ClientSessionCache == nil {
+	// A connection received with AdoptConn has neither the resumption
+	// secret nor the peer certificates a session needs.
+	if c.config.SessionTicketsDisabled || c.config.ClientSessionCache == nil || c.ktlsAdopted {
 		return nil
 	}
 
@@ -91,6 +91,7 @@ type clientHelloMsg struct {
 	keyShares                        []keyShare
 	earlyData                        bool
 	pskModes                         []uint8
+	postHandshakeAuth                bool
 	pskIdentities                    []pskIdentity
 	pskBinders                       [][]byte
 }
@@ -246,6 +247,11 @@ func (m *clientHelloMsg) marshal() ([]byte, error) {
 			})
 		})
 	}
+	if m.postHandshakeAuth {
+		// RFC 8446, Section 4.2.6
+		exts.AddUint16(extensionPostHandshakeAuth)
+		exts.AddUint16(0) // empty extension_data
+	}
 	if len(m.pskIdentities) > 0 { // pre_shared_key must be the last extension
 		// RFC 8446, Section 4.2.11
 		exts.AddUint16(extensionPreSharedKey)
@@ -560,6 +566,9 @@ func (m *clientHelloMsg) unmarshal(data []byte) bool {
 			if !readUint8LengthPrefixed(&extData, &m.pskModes) {
 				return false
 			}
+		case extensionPostHandshakeAuth:
+			// RFC 8446, Section 4.2.6
+			m.postHandshakeAuth = true
 		case extensionPreSharedKey:
 			// RFC 8446, Section 4.2.11
 			if !extensions.Empty() {
@@ -1075,6 +1084,7 @@ func (m *newSessionTicketMsgTLS13) unmarshal(data []byte) bool {
 
 type certificateRequestMsgTLS13 struct {
 	raw                              []byte
+	context                          []byte
 	ocspStapling                     bool
 	scts                             bool
 	supportedSignatureAlgorithms     []SignatureScheme
@@ -1092,7 +1102,9 @@ func (m *certificateRequestMsgTLS13) marshal() ([]byte, error) {
 	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
 		// certificate_request_context (SHALL be zero length unless used for
 		// post-handshake authentication)
-		b.AddUint8(0)
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(m.context)
+		})
 
 		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
 			if m.ocspStapling {
@@ -1154,11 +1166,14 @@ func (m *certificateRequestMsgTLS13) unmarshal(data []byte) bool {
 
 	var context, extensions cryptobyte.String
 	if !s.Skip(4) || // message type and uint24 length field
-		!s.ReadUint8LengthPrefixed(&context) || !context.Empty() ||
+		!s.ReadUint8LengthPrefixed(&context) ||
 		!s.ReadUint16LengthPrefixed(&extensions) ||
 		!s.Empty() {
 		return false
 	}
+	if !context.Empty() {
+		m.context = context
+	}
 
 	for !extensions.Empty() {
 		var extension uint16
@@ -1303,6 +1318,7 @@ func (m *certificateMsg) unmarshal(data []byte) bool {
 
 type certificateMsgTLS13 struct {
 	raw          []byte
+	context      []byte
 	certificate  Certificate
 	ocspStapling bool
 	scts         bool
@@ -1316,7 +1332,9 @@ func (m *certificateMsgTLS13) marshal() ([]byte, error) {
 	var b cryptobyte.Builder
 	b.AddUint8(typeCertificate)
 	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
-		b.AddUint8(0) // certificate_request_context
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(m.context) // certificate_request_context
+		})
 
 		certificate := m.certificate
 		if !m.ocspStapling {
@@ -1376,11 +1394,14 @@ func (m *certificateMsgTLS13) unmarshal(data []byte) bool {
 
 	var context cryptobyte.String
 	if !s.Skip(4) || // message type and uint24 length field
-		!s.ReadUint8LengthPrefixed(&context) || !context.Empty() ||
+		!s.ReadUint8LengthPrefixed(&context) ||
 		!unmarshalCertificate(&s, &m.certificate) ||
 		!s.Empty() {
 		return false
 	}
+	if !context.Empty() {
+		m.context = context
+	}
 
 	m.scts = m.certificate.SignedCertificateTimestamps != nil
 	m.ocspStapling = m.certificate.OCSPStaple != nil
@@ -200,6 +200,9 @@ func (*clientHelloMsg) Generate(rand *rand.Rand, size int) reflect.Value {
 	if rand.Intn(10) > 5 {
 		m.earlyData = true
 	}
+	if rand.Intn(10) > 5 {
+		m.postHandshakeAuth = true
+	}
 
 	return reflect.ValueOf(m)
 }
@@ -372,6 +375,9 @@ func (*newSessionTicketMsgTLS13) Generate(rand *rand.Rand, size int) reflect.Val
 
 func (*certificateRequestMsgTLS13) Generate(rand *rand.Rand, size int) reflect.Value {
 	m := &certificateRequestMsgTLS13{}
+	if rand.Intn(10) > 5 {
+		m.context = randomBytes(rand.Intn(32)+1, rand)
+	}
 	if rand.Intn(10) > 5 {
 		m.ocspStapling = true
 	}
@@ -395,6 +401,9 @@ func (*certificateRequestMsgTLS13) Generate(rand *rand.Rand, size int) reflect.V
 
 func (*certificateMsgTLS13) Generate(rand *rand.Rand, size int) reflect.Value {
 	m := &certificateMsgTLS13{}
+	if rand.Intn(10) > 5 {
+		m.context = randomBytes(rand.Intn(32)+1, rand)
+	}
 	for i := 0; i < rand.Intn(2)+1; i++ {
 		m.certificate.Certificate = append(
 			m.certificate.Certificate, randomBytes(rand.Intn(500)+1, rand))
@@ -0,0 +1,51 @@
+package tls
+
+import (
+	"context"
+	"time"
+)
+
+// HandshakeReport describes the outcome of a handshake, see
+// Conn.HandshakeWithReport.
+type HandshakeReport struct {
+	Version            uint16
+	CipherSuite        uint16
+	NegotiatedProtocol string
+	ServerName         string
+	DidResume          bool
+
+	// KTLS is the kernel TLS outcome of each direction, with the reason a
+	// direction was not offloaded and its zerocopy and no pad status.
+	// With Config.KTLSDeferRX, RX is only offloaded by the first Read.
+	KTLS KTLSState
+
+	// HandshakeDuration is how long the handshake took, including
+	// programming kernel TLS. KTLSTXEnableDuration and
+	// KTLSRXEnableDuration are the time spent programming TLS_TX and
+	// TLS_RX, or zero if that was not attempted.
+	HandshakeDuration    time.Duration
+	KTLSTXEnableDuration time.Duration
+	KTLSRXEnableDuration time.Duration
+}
+
+// HandshakeWithReport runs the handshake like HandshakeContext, if it has not
+// yet been run, and returns a report of its outcome. If the handshake
+// failed, the report holds what was known when it failed.
+func (c *Conn) HandshakeWithReport(ctx context.Context) (HandshakeReport, error) {
+	err := c.HandshakeContext(ctx)
+
+	c.handshakeMutex.Lock()
+	defer c.handshakeMutex.Unlock()
+	report := HandshakeReport{
+		Version:              c.vers,
+		CipherSuite:          c.cipherSuite,
+		NegotiatedProtocol:   c.clientProtocol,
+		ServerName:           c.serverName,
+		DidResume:            c.didResume,
+		KTLS:                 c.kernelTLSState(),
+		HandshakeDuration:    c.handshakeTime,
+		KTLSTXEnableDuration: c.ktlsTXEnableTime,
+		KTLSRXEnableDuration: c.ktlsRXEnableTime,
+	}
+	return report, err
+}
@@ -0,0 +1,42 @@
+package tls
+
+import (
+	"context"
+	"testing"
+)
+
+func TestHandshakeWithReport(t *testing.T) {
+	c, s := localPipe(t)
+	clientConfig := testConfig.Clone()
+	clientConfig.NextProtos = []string{"h2"}
+	serverConfig := testConfig.Clone()
+	serverConfig.NextProtos = []string{"h2"}
+	client := Client(c, clientConfig)
+	server := Server(s, serverConfig)
+	defer client.Close()
+	defer server.Close()
+
+	go server.Handshake()
+	report, err := client.HandshakeWithReport(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := client.ConnectionState()
+	if report.Version != state.Version || report.CipherSuite != state.CipherSuite ||
+		report.NegotiatedProtocol != "h2" || report.ServerName != state.ServerName ||
+		report.DidResume != state.DidResume {
+		t.Errorf("report %+v does not match connection state %+v", report, state)
+	}
+	if report.KTLS != state.KTLS {
+		t.Errorf("report KTLS = %+v, want %+v", report.KTLS, state.KTLS)
+	}
+	if report.HandshakeDuration <= 0 {
+		t.Errorf("HandshakeDuration = %v, want a positive duration", report.HandshakeDuration)
+	}
+
+	// A completed handshake is reported again without running it.
+	again, err := client.HandshakeWithReport(context.Background())
+	if err != nil || again != report {
+		t.Errorf("second report = %+v, %v; want %+v", again, err, report)
+	}
+}
@@ -123,7 +123,7 @@ func (hs *serverHandshakeState) handshake() error {
 	c.ekm = ekmFromMasterSecret(c.vers, hs.suite, hs.masterSecret, hs.clientHello.random, hs.hello.random)
 
 	// Enable kernel TLS if possible
-	if err := c.enableKernelTLS(c.cipherSuite, c.in.key, c.out.key, c.in.iv, c.out.iv, &c.in.cipher, &c.out.cipher); err != nil {
+	if err := c.enableKernelTLS(c.cipherSuite, c.in.key, c.out.key, c.in.iv, c.out.iv, &c.out.cipher, &c.in.cipher); err != nil {
 		return err
 	}
 	c.isHandshakeComplete.Store(true)
@@ -356,6 +356,14 @@ func (hs *serverHandshakeState) pickCipherSuite() error {
 			}
 		}
 	}
+	for _, suite := range optionalCipherSuites {
+		for _, id := range configCipherSuites {
+			if id == suite.id {
+				preferenceList = append(preferenceList, id)
+				break
+			}
+		}
+	}
 
 	hs.suite = selectCipherSuite(preferenceList, hs.clientHello.cipherSuites, hs.cipherSuiteOk)
 	if hs.suite == nil {
@@ -501,7 +509,8 @@ func (hs *serverHandshakeState) doFullHandshake() error {
 		hs.hello.ocspStapling = true
 	}
 
-	hs.hello.ticketSupported = hs.clientHello.ticketSupported && !c.config.SessionTicketsDisabled
+	hs.hello.ticketSupported = hs.clientHello.ticketSupported && !c.config.SessionTicketsDisabled &&
+		len(c.ticketKeys) > 0
 	hs.hello.cipherSuite = hs.suite.id
 
 	hs.finishedHash = newFinishedHash(hs.c.vers, hs.suite)
@@ -817,6 +826,12 @@ func (hs *serverHandshakeState) sendFinished(out []byte) error {
 // Certificates message or from a sessionState and verifies them. It returns
 // the public key of the leaf certificate.
 func (c *Conn) processCertsFromClient(certificate Certificate) error {
+	return c.processCertsFromClientAuth(certificate, c.config.ClientAuth)
+}
+
+// processCertsFromClientAuth is like processCertsFromClient, but applies the
+// clientAuth policy instead of Config.ClientAuth.
+func (c *Conn) processCertsFromClientAuth(certificate Certificate, clientAuth ClientAuthType) error {
 	certificates := certificate.Certificate
 	certs := make([]*x509.Certificate, len(certificates))
 	var err error
@@ -827,12 +842,12 @@ func (c *Conn) processCertsFromClient(certificate Certificate) error {
 		}
 	}
 
-	if len(certs) == 0 && requiresClientCert(c.config.ClientAuth) {
+	if len(certs) == 0 && requiresClientCert(clientAuth) {
 		c.sendAlert(alertBadCertificate)
 		return errors.New("tls: client didn't provide a certificate")
 	}
 
-	if c.config.ClientAuth >= VerifyClientCertIfGiven && len(certs) > 0 {
+	if clientAuth >= VerifyClientCertIfGiven && len(certs) > 0 {
 		opts := x509.VerifyOptions{
 			Roots:         c.config.ClientCAs,
 			CurrentTime:   c.config.time(),
@@ -82,7 +82,7 @@ func (hs *serverHandshakeStateTLS13) handshake() error {
 	}
 
 	// Enable kernel TLS if possible
-	if err := c.enableKernelTLS(c.cipherSuite, c.in.key, c.out.key, c.in.iv, c.out.iv, &c.in.cipher, &c.out.cipher); err != nil {
+	if err := c.enableKernelTLS(c.cipherSuite, c.in.key, c.out.key, c.in.iv, c.out.iv, &c.out.cipher, &c.in.cipher); err != nil {
 		return err
 	}
 	c.isHandshakeComplete.Store(true)
@@ -161,6 +161,7 @@ func (hs *serverHandshakeStateTLS13) processClientHello() error {
 	if !hasAESGCMHardwareSupport || !aesgcmPreferred(hs.clientHello.cipherSuites) {
 		preferenceList = defaultCipherSuitesTLS13NoAES
 	}
+	preferenceList = appendOptionalCipherSuitesTLS13(preferenceList[:len(preferenceList):len(preferenceList)], c.config.CipherSuites)
 	for _, suiteID := range preferenceList {
 		hs.suite = mutualCipherSuiteTLS13(hs.clientHello.cipherSuites, suiteID)
 		if hs.suite != nil {
@@ -339,7 +340,7 @@ func (hs *serverHandshakeStateTLS13) checkForResumption() error {
 // cloneHash uses the encoding.BinaryMarshaler and encoding.BinaryUnmarshaler
 // interfaces implemented by standard library hashes to clone the state of in
 // to a new instance of h. It returns nil if the operation fails.
-func cloneHash(in hash.Hash, h crypto.Hash) hash.Hash {
+func cloneHash(in hash.Hash, h suiteHash) hash.Hash {
 	// Recreate the interface to avoid importing encoding.
 	type binaryMarshaler interface {
 		MarshalBinary() (data []byte, err error)
@@ -636,7 +637,7 @@ func (hs *serverHandshakeStateTLS13) sendServerCertificate() error {
 	if sigType == signatureRSAPSS {
 		signOpts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: sigHash}
 	}
-	sig, err := hs.cert.PrivateKey.(crypto.Signer).Sign(c.config.rand(), signed, signOpts)
+	sig, err := c.config.handshakeSign(hs.cert.PrivateKey.(crypto.Signer), signed, signOpts)
 	if err != nil {
 		public := hs.cert.PrivateKey.(crypto.Signer).Public()
 		if rsaKey, ok := public.(*rsa.PublicKey); ok && sigType == signatureRSAPSS &&
@@ -704,7 +705,7 @@ func (hs *serverHandshakeStateTLS13) sendServerFinished() error {
 }
 
 func (hs *serverHandshakeStateTLS13) shouldSendSessionTickets() bool {
-	if hs.c.config.SessionTicketsDisabled {
+	if hs.c.config.SessionTicketsDisabled || len(hs.c.ticketKeys) == 0 {
 		return false
 	}
 
@@ -810,6 +811,10 @@ func (hs *serverHandshakeStateTLS13) readClientCertificate() error {
 		c.sendAlert(alertUnexpectedMessage)
 		return unexpectedMessageError(certMsg, msg)
 	}
+	if len(certMsg.context) != 0 {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: received certificates message with a non-empty context")
+	}
 
 	if err := c.processCertsFromClient(certMsg.certificate); err != nil {
 		return err
@@ -891,6 +896,12 @@ func (hs *serverHandshakeStateTLS13) readClientFinished() error {
 		return errors.New("tls: invalid client finished hash")
 	}
 
+	// Keep the handshake context for post-handshake authentication. The
+	// client Finished was added to the transcript by sendSessionTickets.
+	if hs.clientHello.postHandshakeAuth {
+		c.postHandshakeTranscript = cloneHash(hs.transcript, hs.suite.hash)
+	}
+
 	c.in.setTrafficSecret(hs.suite, hs.trafficSecret)
 
 	return nil
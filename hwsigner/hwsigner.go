@@ -0,0 +1,176 @@
+// Package hwsigner pools sessions with hardware holding a private key, such
+// as a PKCS#11 token or a TPM 2.0, behind a crypto.Signer and
+// crypto.Decrypter suitable for Certificate.PrivateKey.
+//
+// The package is only the pool and doesn't link against a PKCS#11 module
+// or a TPM stack. The subpackages pkcs11 and tpm open sessions with the
+// miekg/pkcs11 and go-tpm bindings; other hardware is supported by passing
+// New an Opener using the binding of its choice. Signer pools sessions,
+// since opening and logging into a session is usually much slower than a
+// single signing operation and tokens limit the number of concurrent
+// sessions.
+//
+// Only the handshake uses the private key. Once the handshake completes,
+// the record layer, in user space or in the kernel with kTLS, never calls
+// into the hardware.
+package hwsigner
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"io"
+	"sync"
+)
+
+// A Session is an open session with a hardware token that can use a single
+// private key. A Session is only used by one goroutine at a time.
+type Session interface {
+	// Sign signs digest, which was hashed as described by opts, as
+	// crypto.Signer.Sign does.
+	Sign(digest []byte, opts crypto.SignerOpts) ([]byte, error)
+
+	// Decrypt decrypts msg, as crypto.Decrypter.Decrypt does. Sessions for
+	// keys that can't decrypt return an error.
+	Decrypt(msg []byte, opts crypto.DecrypterOpts) ([]byte, error)
+
+	// Close releases the session.
+	Close() error
+}
+
+// An Opener opens a new Session. It is called whenever Signer needs a
+// session and none is idle, up to the maximum set with New.
+type Opener func(ctx context.Context) (Session, error)
+
+// ErrClosed is returned by Signer methods called after Close.
+var ErrClosed = errors.New("hwsigner: signer is closed")
+
+// A Signer is a crypto.Signer and crypto.Decrypter backed by a pool of
+// Sessions. It is safe for concurrent use.
+//
+// A Session that returns an error is closed and replaced rather than
+// returned to the pool, as the error may be caused by the session itself
+// having been invalidated, for example by a token being removed.
+type Signer struct {
+	pub  crypto.PublicKey
+	open Opener
+
+	// tokens holds one element per session that may be open.
+	tokens chan struct{}
+
+	mu     sync.Mutex
+	idle   []Session
+	closed bool
+}
+
+// New returns a Signer for the key with public half pub, whose operations
+// are performed by sessions opened with open. At most maxSessions are open
+// at any time; if maxSessions is < 1, one session is used.
+func New(pub crypto.PublicKey, open Opener, maxSessions int) *Signer {
+	if maxSessions < 1 {
+		maxSessions = 1
+	}
+	return &Signer{
+		pub:    pub,
+		open:   open,
+		tokens: make(chan struct{}, maxSessions),
+	}
+}
+
+// Public returns the public key corresponding to the hardware-held key.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// Sign signs digest with the hardware-held key. rand is ignored, as the
+// hardware uses its own source of randomness.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.SignContext(context.Background(), digest, opts)
+}
+
+// SignContext is like Sign, but waits for a free session only until ctx is
+// done.
+func (s *Signer) SignContext(ctx context.Context, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var sig []byte
+	err := s.do(ctx, func(sess Session) (err error) {
+		sig, err = sess.Sign(digest, opts)
+		return err
+	})
+	return sig, err
+}
+
+// Decrypt decrypts msg with the hardware-held key. rand is ignored.
+func (s *Signer) Decrypt(rand io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	var plaintext []byte
+	err := s.do(context.Background(), func(sess Session) (err error) {
+		plaintext, err = sess.Decrypt(msg, opts)
+		return err
+	})
+	return plaintext, err
+}
+
+// Close closes every idle session. Sessions in use are closed when the
+// operation using them completes. Operations started after Close fail with
+// ErrClosed.
+func (s *Signer) Close() error {
+	s.mu.Lock()
+	idle := s.idle
+	s.idle = nil
+	s.closed = true
+	s.mu.Unlock()
+
+	var err error
+	for _, sess := range idle {
+		if cerr := sess.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// do runs f with an idle session, opening one if needed.
+func (s *Signer) do(ctx context.Context, f func(Session) error) error {
+	select {
+	case s.tokens <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-s.tokens }()
+
+	sess, err := s.get(ctx)
+	if err != nil {
+		return err
+	}
+	if err := f(sess); err != nil {
+		sess.Close()
+		return err
+	}
+	s.put(sess)
+	return nil
+}
+
+func (s *Signer) get(ctx context.Context) (Session, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if n := len(s.idle); n > 0 {
+		sess := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mu.Unlock()
+		return sess, nil
+	}
+	s.mu.Unlock()
+	return s.open(ctx)
+}
+
+func (s *Signer) put(sess Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		sess.Close()
+		return
+	}
+	s.idle = append(s.idle, sess)
+}
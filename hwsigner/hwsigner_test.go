@@ -0,0 +1,141 @@
+package hwsigner
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type softSession struct {
+	key    *ecdsa.PrivateKey
+	open   *atomic.Int32
+	fail   bool
+	closed bool
+}
+
+func (s *softSession) Sign(digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if s.fail {
+		return nil, errors.New("token removed")
+	}
+	return s.key.Sign(rand.Reader, digest, opts)
+}
+
+func (s *softSession) Decrypt(msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	return nil, errors.New("not a decryption key")
+}
+
+func (s *softSession) Close() error {
+	s.closed = true
+	s.open.Add(-1)
+	return nil
+}
+
+func TestSignerPool(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var open, opened atomic.Int32
+	var maxOpen atomic.Int32
+	signer := New(key.Public(), func(ctx context.Context) (Session, error) {
+		n := open.Add(1)
+		opened.Add(1)
+		for {
+			m := maxOpen.Load()
+			if n <= m || maxOpen.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		return &softSession{key: key, open: &open}, nil
+	}, 2)
+
+	digest := sha256.Sum256([]byte("hello"))
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig) {
+				t.Error("invalid signature")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := maxOpen.Load(); n > 2 {
+		t.Errorf("%d sessions open at once, want at most 2", n)
+	}
+	if n := opened.Load(); n > 2 {
+		t.Errorf("opened %d sessions, want idle sessions to be reused", n)
+	}
+	if err := signer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := open.Load(); n != 0 {
+		t.Errorf("%d sessions left open after Close", n)
+	}
+	if _, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256); err != ErrClosed {
+		t.Errorf("Sign after Close: got %v, want ErrClosed", err)
+	}
+}
+
+func TestSignerFailedSession(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var open atomic.Int32
+	first := &softSession{key: key, open: &open, fail: true}
+	signer := New(key.Public(), func(ctx context.Context) (Session, error) {
+		open.Add(1)
+		if first != nil {
+			s := first
+			first = nil
+			return s, nil
+		}
+		return &softSession{key: key, open: &open}, nil
+	}, 1)
+	defer signer.Close()
+
+	digest := sha256.Sum256([]byte("hello"))
+	if _, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256); err == nil {
+		t.Fatal("expected an error from the failing session")
+	}
+	if _, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256); err != nil {
+		t.Errorf("failed session was not replaced: %v", err)
+	}
+}
+
+func TestSignerContext(t *testing.T) {
+	entered := make(chan struct{})
+	block := make(chan struct{})
+	signer := New(nil, func(ctx context.Context) (Session, error) {
+		close(entered)
+		<-block
+		return nil, errors.New("unreachable")
+	}, 1)
+	defer close(block)
+
+	// Hold the only session slot.
+	go signer.Sign(rand.Reader, nil, crypto.SHA256)
+	<-entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := signer.SignContext(ctx, nil, crypto.SHA256); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want context.DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,7 @@
+// Package pkcs11 provides hwsigner sessions for a key held by a PKCS #11
+// token, such as an HSM, a smart card or SoftHSM, using the miekg/pkcs11
+// binding.
+//
+// The binding loads the token's module with cgo, so Open is only available
+// when cgo is enabled.
+package pkcs11
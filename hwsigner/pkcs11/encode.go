@@ -0,0 +1,89 @@
+package pkcs11
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+)
+
+// digestInfoPrefixes are the DER DigestInfo headers prepended to a digest
+// before signing it with CKM_RSA_PKCS, which only applies the PKCS #1 v1.5
+// padding. A zero hash, as used by TLS 1.0 and 1.1, signs the bare
+// MD5+SHA1 concatenation.
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	0:              {},
+	crypto.MD5SHA1: {},
+	crypto.SHA1:    {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA256:  {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384:  {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512:  {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// digestInfo returns digest wrapped in the DigestInfo structure for hash.
+func digestInfo(hash crypto.Hash, digest []byte) ([]byte, error) {
+	prefix, ok := digestInfoPrefixes[hash]
+	if !ok {
+		return nil, errors.New("pkcs11: unsupported hash for PKCS #1 v1.5 signature")
+	}
+	if hash != 0 && len(digest) != hash.Size() {
+		return nil, errors.New("pkcs11: digest length does not match hash")
+	}
+	return append(append([]byte(nil), prefix...), digest...), nil
+}
+
+// ecdsaSignature converts the r || s signature returned by CKM_ECDSA to
+// the ASN.1 form crypto.Signer returns.
+func ecdsaSignature(raw []byte) ([]byte, error) {
+	if len(raw) == 0 || len(raw)%2 != 0 {
+		return nil, errors.New("pkcs11: malformed ECDSA signature")
+	}
+	var sig struct{ R, S *big.Int }
+	sig.R = new(big.Int).SetBytes(raw[:len(raw)/2])
+	sig.S = new(big.Int).SetBytes(raw[len(raw)/2:])
+	return asn1.Marshal(sig)
+}
+
+var curveOIDs = []struct {
+	oid   asn1.ObjectIdentifier
+	curve elliptic.Curve
+}{
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}, elliptic.P256()},
+	{asn1.ObjectIdentifier{1, 3, 132, 0, 34}, elliptic.P384()},
+	{asn1.ObjectIdentifier{1, 3, 132, 0, 35}, elliptic.P521()},
+}
+
+// ecdsaPublicKey parses the CKA_EC_PARAMS and CKA_EC_POINT attributes of an
+// EC public key object. params must name the curve; point is an uncompressed
+// point wrapped in an OCTET STRING as the standard requires, or bare as
+// some tokens return it.
+func ecdsaPublicKey(params, point []byte) (*ecdsa.PublicKey, error) {
+	var oid asn1.ObjectIdentifier
+	if rest, err := asn1.Unmarshal(params, &oid); err != nil || len(rest) != 0 {
+		return nil, errors.New("pkcs11: EC key parameters are not a named curve")
+	}
+	var curve elliptic.Curve
+	for _, c := range curveOIDs {
+		if c.oid.Equal(oid) {
+			curve = c.curve
+		}
+	}
+	if curve == nil {
+		return nil, errors.New("pkcs11: unsupported EC curve " + oid.String())
+	}
+
+	var x, y *big.Int
+	var wrapped []byte
+	if rest, err := asn1.Unmarshal(point, &wrapped); err == nil && len(rest) == 0 {
+		x, y = elliptic.Unmarshal(curve, wrapped)
+	}
+	if x == nil {
+		x, y = elliptic.Unmarshal(curve, point)
+	}
+	if x == nil {
+		return nil, errors.New("pkcs11: malformed EC point")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
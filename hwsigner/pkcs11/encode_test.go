@@ -0,0 +1,99 @@
+package pkcs11
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"testing"
+)
+
+func TestDigestInfo(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("hello"))
+	want, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Signing the DigestInfo with bare PKCS #1 v1.5 padding, as
+	// CKM_RSA_PKCS does, must produce the same signature.
+	data, err := digestInfo(crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := rsa.SignPKCS1v15(rand.Reader, key, 0, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Error("signature over the DigestInfo differs from SignPKCS1v15")
+	}
+
+	if _, err := digestInfo(crypto.SHA256, digest[:16]); err == nil {
+		t.Error("short digest accepted")
+	}
+	if _, err := digestInfo(crypto.SHA3_256, digest[:]); err == nil {
+		t.Error("unsupported hash accepted")
+	}
+}
+
+func TestECDSASignature(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("hello"))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := make([]byte, 64)
+	r.FillBytes(raw[:32])
+	s.FillBytes(raw[32:])
+
+	sig, err := ecdsaSignature(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig) {
+		t.Error("converted signature doesn't verify")
+	}
+	if _, err := ecdsaSignature(raw[:63]); err == nil {
+		t.Error("odd-length signature accepted")
+	}
+}
+
+func TestECDSAPublicKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, _ := asn1.Marshal(asn1.ObjectIdentifier{1, 3, 132, 0, 34})
+	point := elliptic.Marshal(key.Curve, key.X, key.Y)
+	wrapped, _ := asn1.Marshal(point)
+
+	for name, p := range map[string][]byte{"wrapped": wrapped, "bare": point} {
+		pub, err := ecdsaPublicKey(params, p)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !pub.Equal(&key.PublicKey) {
+			t.Errorf("%s: got a different key", name)
+		}
+	}
+
+	unknown, _ := asn1.Marshal(asn1.ObjectIdentifier{1, 3, 132, 0, 10})
+	if _, err := ecdsaPublicKey(unknown, wrapped); err == nil {
+		t.Error("unsupported curve accepted")
+	}
+	if _, err := ecdsaPublicKey(params, []byte{4, 1, 2}); err == nil {
+		t.Error("malformed point accepted")
+	}
+}
@@ -0,0 +1,368 @@
+//go:build cgo
+// +build cgo
+
+package pkcs11
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+
+	"github.com/secure-for-ai/goktls/hwsigner"
+
+	p11 "github.com/miekg/pkcs11"
+)
+
+// Config selects a private key on a PKCS #11 token.
+type Config struct {
+	// Module is the path of the PKCS #11 module to load, for example
+	// /usr/lib/softhsm/libsofthsm2.so.
+	Module string
+
+	// TokenLabel selects the token by its label. If empty, Slot is used.
+	TokenLabel string
+	// Slot is the ID of the slot holding the token, used if TokenLabel is
+	// empty.
+	Slot uint
+
+	// PIN is the user PIN of the token.
+	PIN string
+
+	// KeyLabel and KeyID select the key by its CKA_LABEL and CKA_ID. At
+	// least one must be set, and together they must match exactly one
+	// private key.
+	KeyLabel string
+	KeyID    []byte
+
+	// Public is the public half of the key, for example the public key of
+	// the certificate it belongs to. If nil, it is read from the public key
+	// object with the same label and ID.
+	Public crypto.PublicKey
+
+	// MaxSessions is the maximum number of sessions opened with the token,
+	// see hwsigner.New.
+	MaxSessions int
+}
+
+// A Signer is a hwsigner.Signer whose sessions are PKCS #11 sessions with
+// a token.
+type Signer struct {
+	*hwsigner.Signer
+
+	ctx       *p11.Ctx
+	finalize  bool // whether Open initialized the module
+	rsa       bool // whether the key is an RSA key rather than an EC key
+	sessions  sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// Open loads the module, finds the token and key selected by cfg and
+// returns a Signer for the key. Sessions are opened and logged in as the
+// Signer needs them.
+//
+// RSA keys sign with CKM_RSA_PKCS or CKM_RSA_PKCS_PSS and decrypt with
+// CKM_RSA_PKCS or CKM_RSA_PKCS_OAEP; EC keys sign with CKM_ECDSA.
+func Open(cfg Config) (*Signer, error) {
+	if cfg.KeyLabel == "" && cfg.KeyID == nil {
+		return nil, errors.New("pkcs11: neither KeyLabel nor KeyID is set")
+	}
+	ctx := p11.New(cfg.Module)
+	if ctx == nil {
+		return nil, fmt.Errorf("pkcs11: cannot load module %s", cfg.Module)
+	}
+	s := &Signer{ctx: ctx, finalize: true}
+	if err := ctx.Initialize(); err != nil {
+		if !errors.Is(err, p11.Error(p11.CKR_CRYPTOKI_ALREADY_INITIALIZED)) {
+			ctx.Destroy()
+			return nil, err
+		}
+		// Another user of the module in this process initialized it and
+		// is responsible for finalizing it.
+		s.finalize = false
+	}
+
+	slot, err := findSlot(ctx, cfg)
+	if err != nil {
+		s.release()
+		return nil, err
+	}
+	open := func(context.Context) (hwsigner.Session, error) {
+		sess, err := s.openSession(slot, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return sess, nil
+	}
+
+	pub := cfg.Public
+	if pub == nil {
+		sess, err := s.openSession(slot, cfg)
+		if err != nil {
+			s.release()
+			return nil, err
+		}
+		pub, err = sess.public(cfg)
+		sess.Close()
+		if err != nil {
+			s.release()
+			return nil, err
+		}
+	}
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		s.rsa = true
+	case *ecdsa.PublicKey:
+	default:
+		s.release()
+		return nil, fmt.Errorf("pkcs11: unsupported public key type %T", pub)
+	}
+	s.Signer = hwsigner.New(pub, open, cfg.MaxSessions)
+	return s, nil
+}
+
+// Close closes the pool, waits for operations in progress to complete and
+// unloads the module.
+func (s *Signer) Close() error {
+	err := s.Signer.Close()
+	s.closeOnce.Do(func() {
+		s.sessions.Wait()
+		s.release()
+	})
+	return err
+}
+
+func (s *Signer) release() {
+	if s.finalize {
+		s.ctx.Finalize()
+	}
+	s.ctx.Destroy()
+}
+
+func findSlot(ctx *p11.Ctx, cfg Config) (uint, error) {
+	if cfg.TokenLabel == "" {
+		return cfg.Slot, nil
+	}
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, err
+	}
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			return 0, err
+		}
+		if info.Label == cfg.TokenLabel {
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("pkcs11: no token labeled %q", cfg.TokenLabel)
+}
+
+// session is a logged in PKCS #11 session and the handle of the key in it.
+type session struct {
+	s   *Signer
+	h   p11.SessionHandle
+	key p11.ObjectHandle
+}
+
+func (s *Signer) openSession(slot uint, cfg Config) (*session, error) {
+	h, err := s.ctx.OpenSession(slot, p11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return nil, err
+	}
+	s.sessions.Add(1)
+	sess := &session{s: s, h: h}
+	// The login state is shared by all sessions of the application with
+	// the token, so every session after the first finds it logged in.
+	if err := s.ctx.Login(h, p11.CKU_USER, cfg.PIN); err != nil && !errors.Is(err, p11.Error(p11.CKR_USER_ALREADY_LOGGED_IN)) {
+		sess.Close()
+		return nil, err
+	}
+	if sess.key, err = sess.find(p11.CKO_PRIVATE_KEY, cfg); err != nil {
+		sess.Close()
+		return nil, err
+	}
+	return sess, nil
+}
+
+func (sess *session) find(class uint, cfg Config) (p11.ObjectHandle, error) {
+	template := []*p11.Attribute{p11.NewAttribute(p11.CKA_CLASS, class)}
+	if cfg.KeyLabel != "" {
+		template = append(template, p11.NewAttribute(p11.CKA_LABEL, cfg.KeyLabel))
+	}
+	if cfg.KeyID != nil {
+		template = append(template, p11.NewAttribute(p11.CKA_ID, cfg.KeyID))
+	}
+	ctx := sess.s.ctx
+	if err := ctx.FindObjectsInit(sess.h, template); err != nil {
+		return 0, err
+	}
+	objs, _, err := ctx.FindObjects(sess.h, 2)
+	if ferr := ctx.FindObjectsFinal(sess.h); err == nil {
+		err = ferr
+	}
+	if err != nil {
+		return 0, err
+	}
+	switch len(objs) {
+	case 0:
+		return 0, fmt.Errorf("pkcs11: no key labeled %q with ID %x", cfg.KeyLabel, cfg.KeyID)
+	case 1:
+		return objs[0], nil
+	default:
+		return 0, fmt.Errorf("pkcs11: more than one key labeled %q with ID %x", cfg.KeyLabel, cfg.KeyID)
+	}
+}
+
+// public reads the public key object matching the private key.
+func (sess *session) public(cfg Config) (crypto.PublicKey, error) {
+	obj, err := sess.find(p11.CKO_PUBLIC_KEY, cfg)
+	if err != nil {
+		return nil, err
+	}
+	ctx := sess.s.ctx
+	attrs, err := ctx.GetAttributeValue(sess.h, obj, []*p11.Attribute{
+		p11.NewAttribute(p11.CKA_MODULUS, nil),
+		p11.NewAttribute(p11.CKA_PUBLIC_EXPONENT, nil),
+	})
+	if err == nil && len(attrs) == 2 {
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(attrs[0].Value),
+			E: int(new(big.Int).SetBytes(attrs[1].Value).Int64()),
+		}, nil
+	}
+	attrs, err = ctx.GetAttributeValue(sess.h, obj, []*p11.Attribute{
+		p11.NewAttribute(p11.CKA_EC_PARAMS, nil),
+		p11.NewAttribute(p11.CKA_EC_POINT, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(attrs) != 2 {
+		return nil, errors.New("pkcs11: public key is neither RSA nor EC")
+	}
+	return ecdsaPublicKey(attrs[0].Value, attrs[1].Value)
+}
+
+func (sess *session) Sign(digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hash := opts.HashFunc()
+	var mech *p11.Mechanism
+	var data []byte
+	if sess.s.rsa {
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			h, ok := hashMechanisms[hash]
+			if !ok {
+				return nil, errors.New("pkcs11: unsupported hash for RSA-PSS")
+			}
+			saltLen := pss.SaltLength
+			if saltLen == rsa.PSSSaltLengthAuto || saltLen == rsa.PSSSaltLengthEqualsHash {
+				saltLen = hash.Size()
+			}
+			mech = p11.NewMechanism(p11.CKM_RSA_PKCS_PSS, p11.NewPSSParams(h.hash, h.mgf, uint(saltLen)))
+			data = digest
+		} else {
+			var err error
+			if data, err = digestInfo(hash, digest); err != nil {
+				return nil, err
+			}
+			mech = p11.NewMechanism(p11.CKM_RSA_PKCS, nil)
+		}
+	} else {
+		mech = p11.NewMechanism(p11.CKM_ECDSA, nil)
+		data = digest
+	}
+
+	ctx := sess.s.ctx
+	if err := ctx.SignInit(sess.h, []*p11.Mechanism{mech}, sess.key); err != nil {
+		return nil, err
+	}
+	sig, err := ctx.Sign(sess.h, data)
+	if err != nil {
+		return nil, err
+	}
+	if mech.Mechanism == p11.CKM_ECDSA {
+		return ecdsaSignature(sig)
+	}
+	return sig, nil
+}
+
+func (sess *session) Decrypt(msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	if !sess.s.rsa {
+		return nil, errors.New("pkcs11: only RSA keys can decrypt")
+	}
+	var mech *p11.Mechanism
+	var sessionKeyLen int
+	switch opts := opts.(type) {
+	case nil:
+		mech = p11.NewMechanism(p11.CKM_RSA_PKCS, nil)
+	case *rsa.PKCS1v15DecryptOptions:
+		mech = p11.NewMechanism(p11.CKM_RSA_PKCS, nil)
+		sessionKeyLen = opts.SessionKeyLen
+	case *rsa.OAEPOptions:
+		h, ok := hashMechanisms[opts.Hash]
+		if !ok {
+			return nil, errors.New("pkcs11: unsupported hash for RSA-OAEP")
+		}
+		mech = p11.NewMechanism(p11.CKM_RSA_PKCS_OAEP, p11.NewOAEPParams(h.hash, h.mgf, p11.CKZ_DATA_SPECIFIED, opts.Label))
+	default:
+		return nil, errors.New("pkcs11: unsupported decrypter options")
+	}
+
+	ctx := sess.s.ctx
+	plaintext, err := func() ([]byte, error) {
+		if err := ctx.DecryptInit(sess.h, []*p11.Mechanism{mech}, sess.key); err != nil {
+			return nil, err
+		}
+		return ctx.Decrypt(sess.h, msg)
+	}()
+	if sessionKeyLen == 0 {
+		return plaintext, err
+	}
+	// As rsa.DecryptPKCS1v15SessionKey does, return a random key rather
+	// than an error on a padding failure, so that a TLS server doesn't
+	// reveal whether the padding was valid. A failure to talk to the
+	// token still surfaces as an error.
+	var perr p11.Error
+	if err != nil && !(errors.As(err, &perr) && (perr == p11.CKR_ENCRYPTED_DATA_INVALID || perr == p11.CKR_ENCRYPTED_DATA_LEN_RANGE)) {
+		return nil, err
+	}
+	key := make([]byte, sessionKeyLen)
+	if _, rerr := io.ReadFull(rand.Reader, key); rerr != nil {
+		return nil, rerr
+	}
+	if err == nil {
+		subtle.ConstantTimeCopy(subtle.ConstantTimeEq(int32(len(plaintext)), int32(sessionKeyLen)), key, padTo(plaintext, sessionKeyLen))
+	}
+	return key, nil
+}
+
+// padTo returns b truncated or zero-padded to n bytes, so that it can be
+// passed to subtle.ConstantTimeCopy whatever its length.
+func padTo(b []byte, n int) []byte {
+	out := make([]byte, n)
+	copy(out, b)
+	return out
+}
+
+func (sess *session) Close() error {
+	defer sess.s.sessions.Done()
+	return sess.s.ctx.CloseSession(sess.h)
+}
+
+// hashMechanisms maps a hash to its PKCS #11 mechanism and the MGF1
+// generator using it, for RSA-PSS and RSA-OAEP parameters.
+var hashMechanisms = map[crypto.Hash]struct{ hash, mgf uint }{
+	crypto.SHA1:   {p11.CKM_SHA_1, p11.CKG_MGF1_SHA1},
+	crypto.SHA224: {p11.CKM_SHA224, p11.CKG_MGF1_SHA224},
+	crypto.SHA256: {p11.CKM_SHA256, p11.CKG_MGF1_SHA256},
+	crypto.SHA384: {p11.CKM_SHA384, p11.CKG_MGF1_SHA384},
+	crypto.SHA512: {p11.CKM_SHA512, p11.CKG_MGF1_SHA512},
+}
@@ -0,0 +1,66 @@
+//go:build cgo
+// +build cgo
+
+package pkcs11
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"os"
+	"testing"
+)
+
+// TestSignToken signs with a key on a real token, such as one created with
+//
+//	softhsm2-util --init-token --free --label test --pin 1234 --so-pin 1234
+//	pkcs11-tool --module $MODULE --login --pin 1234 --keypairgen --key-type EC:prime256v1 --label key
+//
+// It is skipped unless HWSIGNER_PKCS11_MODULE is set, along with
+// HWSIGNER_PKCS11_TOKEN, HWSIGNER_PKCS11_PIN and HWSIGNER_PKCS11_KEY.
+func TestSignToken(t *testing.T) {
+	module := os.Getenv("HWSIGNER_PKCS11_MODULE")
+	if module == "" {
+		t.Skip("HWSIGNER_PKCS11_MODULE not set")
+	}
+	s, err := Open(Config{
+		Module:      module,
+		TokenLabel:  os.Getenv("HWSIGNER_PKCS11_TOKEN"),
+		PIN:         os.Getenv("HWSIGNER_PKCS11_PIN"),
+		KeyLabel:    os.Getenv("HWSIGNER_PKCS11_KEY"),
+		MaxSessions: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	digest := sha256.Sum256([]byte("hello"))
+	opts := []crypto.SignerOpts{crypto.SHA256}
+	if _, ok := s.Public().(*rsa.PublicKey); ok {
+		opts = append(opts, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256})
+	}
+	for _, opt := range opts {
+		sig, err := s.Sign(rand.Reader, digest[:], opt)
+		if err != nil {
+			t.Fatalf("%T: %v", opt, err)
+		}
+		switch pub := s.Public().(type) {
+		case *ecdsa.PublicKey:
+			if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+				t.Error("ECDSA signature doesn't verify")
+			}
+		case *rsa.PublicKey:
+			if pss, ok := opt.(*rsa.PSSOptions); ok {
+				err = rsa.VerifyPSS(pub, crypto.SHA256, digest[:], sig, pss)
+			} else {
+				err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig)
+			}
+			if err != nil {
+				t.Errorf("%T: %v", opt, err)
+			}
+		}
+	}
+}
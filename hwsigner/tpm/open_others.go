@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package tpm
+
+import (
+	"io"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+)
+
+// openDefault opens the TPM device or simulator socket at path, or the
+// default device if path is empty.
+func openDefault(path string) (io.ReadWriteCloser, error) {
+	if path == "" {
+		return tpm2.OpenTPM()
+	}
+	return tpm2.OpenTPM(path)
+}
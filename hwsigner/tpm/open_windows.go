@@ -0,0 +1,16 @@
+package tpm
+
+import (
+	"errors"
+	"io"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+)
+
+// openDefault opens the TPM through TBS, which doesn't take a path.
+func openDefault(path string) (io.ReadWriteCloser, error) {
+	if path != "" {
+		return nil, errors.New("tpm: Path is not supported on Windows")
+	}
+	return tpm2.OpenTPM()
+}
@@ -0,0 +1,199 @@
+// Package tpm provides hwsigner sessions for a key held by a TPM 2.0, using
+// the go-tpm library.
+//
+// The key must be an unrestricted signing or decryption key loaded at a
+// persistent handle, for example with tpm2_evictcontrol. Restricted keys
+// only sign digests produced by the TPM itself and can't sign handshake
+// transcripts.
+package tpm
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/secure-for-ai/goktls/hwsigner"
+)
+
+// Config selects a key held by a TPM.
+type Config struct {
+	// Path is the TPM device or simulator socket to open. If empty,
+	// /dev/tpmrm0 is used, or /dev/tpm0 if it doesn't exist. Path must be
+	// empty on Windows, where the TPM is reached through TBS.
+	Path string
+
+	// Open, if not nil, is called instead of opening Path to connect to
+	// the TPM.
+	Open func() (io.ReadWriteCloser, error)
+
+	// Handle is the persistent handle of the key, such as 0x81000001.
+	Handle uint32
+
+	// Password is the authorization value of the key.
+	Password string
+
+	// MaxSessions is the maximum number of connections to the TPM, see
+	// hwsigner.New. Connections through the kernel resource manager, at
+	// /dev/tpmrm0, can be used concurrently; /dev/tpm0 only allows one.
+	MaxSessions int
+}
+
+// Open reads the public half of the key selected by cfg and returns a
+// Signer for it. Connections to the TPM are opened as the Signer needs
+// them.
+//
+// RSA keys sign with RSASSA or RSAPSS and decrypt with RSAES or OAEP; EC
+// keys sign with ECDSA.
+func Open(cfg Config) (*hwsigner.Signer, error) {
+	connect := cfg.Open
+	if connect == nil {
+		connect = func() (io.ReadWriteCloser, error) { return openDefault(cfg.Path) }
+	}
+
+	rw, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	pub, _, _, err := tpm2.ReadPublic(rw, tpmutil.Handle(cfg.Handle))
+	rw.Close()
+	if err != nil {
+		return nil, err
+	}
+	key, err := pub.Key()
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return nil, fmt.Errorf("tpm: unsupported public key type %T", key)
+	}
+
+	open := func(context.Context) (hwsigner.Session, error) {
+		rw, err := connect()
+		if err != nil {
+			return nil, err
+		}
+		return &session{rw: rw, pub: key, handle: tpmutil.Handle(cfg.Handle), password: cfg.Password}, nil
+	}
+	return hwsigner.New(key, open, cfg.MaxSessions), nil
+}
+
+// session is a connection to the TPM.
+type session struct {
+	rw       io.ReadWriteCloser
+	pub      crypto.PublicKey
+	handle   tpmutil.Handle
+	password string
+}
+
+func (s *session) Sign(digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	scheme, err := sigScheme(s.pub, opts)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := tpm2.Sign(s.rw, s.handle, s.password, digest, nil, scheme)
+	if err != nil {
+		return nil, err
+	}
+	return signatureBytes(sig)
+}
+
+func (s *session) Decrypt(msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	if _, ok := s.pub.(*rsa.PublicKey); !ok {
+		return nil, errors.New("tpm: only RSA keys can decrypt")
+	}
+	scheme := &tpm2.AsymScheme{Alg: tpm2.AlgRSAES}
+	var label string
+	var sessionKeyLen int
+	switch opts := opts.(type) {
+	case nil:
+	case *rsa.PKCS1v15DecryptOptions:
+		sessionKeyLen = opts.SessionKeyLen
+	case *rsa.OAEPOptions:
+		alg, err := tpm2.HashToAlgorithm(opts.Hash)
+		if err != nil {
+			return nil, err
+		}
+		scheme = &tpm2.AsymScheme{Alg: tpm2.AlgOAEP, Hash: alg}
+		label = string(opts.Label)
+	default:
+		return nil, errors.New("tpm: unsupported decrypter options")
+	}
+
+	plaintext, err := tpm2.RSADecrypt(s.rw, s.handle, s.password, msg, scheme, label)
+	if sessionKeyLen == 0 {
+		return plaintext, err
+	}
+	// As rsa.DecryptPKCS1v15SessionKey does, return a random key rather
+	// than an error on a padding failure, so that a TLS server doesn't
+	// reveal whether the padding was valid. The TPM reports one as
+	// TPM_RC_VALUE for the ciphertext; any other failure still surfaces as
+	// an error.
+	var perr tpm2.ParameterError
+	if err != nil && !errors.As(err, &perr) {
+		return nil, err
+	}
+	key := make([]byte, sessionKeyLen)
+	if _, rerr := io.ReadFull(rand.Reader, key); rerr != nil {
+		return nil, rerr
+	}
+	if err == nil {
+		padded := make([]byte, sessionKeyLen)
+		copy(padded, plaintext)
+		subtle.ConstantTimeCopy(subtle.ConstantTimeEq(int32(len(plaintext)), int32(sessionKeyLen)), key, padded)
+	}
+	return key, nil
+}
+
+func (s *session) Close() error {
+	return s.rw.Close()
+}
+
+// sigScheme returns the TPM signature scheme for signing a digest with a
+// key with public half pub as described by opts.
+func sigScheme(pub crypto.PublicKey, opts crypto.SignerOpts) (*tpm2.SigScheme, error) {
+	hash := opts.HashFunc()
+	alg, err := tpm2.HashToAlgorithm(hash)
+	if err != nil {
+		return nil, fmt.Errorf("tpm: unsupported hash %v", hash)
+	}
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		pss, ok := opts.(*rsa.PSSOptions)
+		if !ok {
+			return &tpm2.SigScheme{Alg: tpm2.AlgRSASSA, Hash: alg}, nil
+		}
+		// The TPM always uses a salt as long as the hash.
+		switch pss.SaltLength {
+		case rsa.PSSSaltLengthAuto, rsa.PSSSaltLengthEqualsHash, hash.Size():
+		default:
+			return nil, errors.New("tpm: RSA-PSS salt length must equal the hash length")
+		}
+		return &tpm2.SigScheme{Alg: tpm2.AlgRSAPSS, Hash: alg}, nil
+	case *ecdsa.PublicKey:
+		return &tpm2.SigScheme{Alg: tpm2.AlgECDSA, Hash: alg}, nil
+	}
+	return nil, fmt.Errorf("tpm: unsupported public key type %T", pub)
+}
+
+// signatureBytes returns sig in the form crypto.Signer returns.
+func signatureBytes(sig *tpm2.Signature) ([]byte, error) {
+	switch {
+	case sig.RSA != nil:
+		return sig.RSA.Signature, nil
+	case sig.ECC != nil:
+		return asn1.Marshal(struct{ R, S *big.Int }{sig.ECC.R, sig.ECC.S})
+	}
+	return nil, fmt.Errorf("tpm: unexpected signature algorithm %v", sig.Alg)
+}
@@ -0,0 +1,110 @@
+package tpm
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+)
+
+func TestSigScheme(t *testing.T) {
+	rsaPub, ecPub := &rsa.PublicKey{}, &ecdsa.PublicKey{}
+	tests := []struct {
+		pub  crypto.PublicKey
+		opts crypto.SignerOpts
+		alg  tpm2.Algorithm
+	}{
+		{rsaPub, crypto.SHA256, tpm2.AlgRSASSA},
+		{rsaPub, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}, tpm2.AlgRSAPSS},
+		{rsaPub, &rsa.PSSOptions{SaltLength: 32, Hash: crypto.SHA256}, tpm2.AlgRSAPSS},
+		{rsaPub, &rsa.PSSOptions{SaltLength: 20, Hash: crypto.SHA256}, 0},
+		{rsaPub, crypto.MD5SHA1, 0},
+		{ecPub, crypto.SHA384, tpm2.AlgECDSA},
+	}
+	for i, tt := range tests {
+		scheme, err := sigScheme(tt.pub, tt.opts)
+		if tt.alg == 0 {
+			if err == nil {
+				t.Errorf("#%d: got scheme %v, want an error", i, scheme.Alg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		hash, _ := scheme.Hash.Hash()
+		if scheme.Alg != tt.alg || hash != tt.opts.HashFunc() {
+			t.Errorf("#%d: got %v/%v, want %v/%v", i, scheme.Alg, hash, tt.alg, tt.opts.HashFunc())
+		}
+	}
+}
+
+func TestSignatureBytes(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("hello"))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := signatureBytes(&tpm2.Signature{Alg: tpm2.AlgECDSA, ECC: &tpm2.SignatureECC{HashAlg: tpm2.AlgSHA256, R: r, S: s}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig) {
+		t.Error("converted signature doesn't verify")
+	}
+
+	if _, err := signatureBytes(&tpm2.Signature{Alg: tpm2.AlgHMAC}); err == nil {
+		t.Error("HMAC signature accepted")
+	}
+}
+
+// TestSignTPM signs with a key held by a real TPM or simulator. It is
+// skipped unless HWSIGNER_TPM_HANDLE is set to the persistent handle of a
+// signing key; HWSIGNER_TPM_PATH and HWSIGNER_TPM_PASSWORD are optional.
+func TestSignTPM(t *testing.T) {
+	h := os.Getenv("HWSIGNER_TPM_HANDLE")
+	if h == "" {
+		t.Skip("HWSIGNER_TPM_HANDLE not set")
+	}
+	handle, err := strconv.ParseUint(h, 0, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(Config{
+		Path:     os.Getenv("HWSIGNER_TPM_PATH"),
+		Handle:   uint32(handle),
+		Password: os.Getenv("HWSIGNER_TPM_PASSWORD"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	digest := sha256.Sum256([]byte("hello"))
+	sig, err := s.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch pub := s.Public().(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+			t.Error("ECDSA signature doesn't verify")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package aria
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestSBoxesAreInverses(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		if sb3[sb1[i]] != byte(i) || sb4[sb2[i]] != byte(i) {
+			t.Fatalf("S-boxes do not invert at %#x", i)
+		}
+	}
+}
+
+// Test vectors from RFC 5794, Appendix A.1.
+var ariaTests = []struct {
+	key, ciphertext string
+}{
+	{"000102030405060708090a0b0c0d0e0f", "d718fbd6ab644c739da95f3be6451778"},
+	{"000102030405060708090a0b0c0d0e0f1011121314151617", "26449c1805dbe7aa25a468ce263a9e79"},
+	{"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f", "f92bd7c79fb72e2f2b8f80c1972d24fc"},
+}
+
+func TestRFCVectors(t *testing.T) {
+	plaintext, _ := hex.DecodeString("00112233445566778899aabbccddeeff")
+	for _, tt := range ariaTests {
+		key, _ := hex.DecodeString(tt.key)
+		want, _ := hex.DecodeString(tt.ciphertext)
+		c, err := NewCipher(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make([]byte, BlockSize)
+		c.Encrypt(out, plaintext)
+		if !bytes.Equal(out, want) {
+			t.Errorf("ARIA-%d: Encrypt = %x, want %x", 8*len(key), out, want)
+		}
+		c.Decrypt(out, out)
+		if !bytes.Equal(out, plaintext) {
+			t.Errorf("ARIA-%d: Decrypt = %x, want %x", 8*len(key), out, plaintext)
+		}
+	}
+}
+
+func TestKeySize(t *testing.T) {
+	for _, n := range []int{0, 15, 20, 33} {
+		if _, err := NewCipher(make([]byte, n)); err == nil {
+			t.Errorf("NewCipher accepted a %d-byte key", n)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+// Package sm3 implements the SM3 hash function of GB/T 32905-2016, as used
+// by the TLS 1.3 cipher suites of RFC 8998.
+package sm3
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash"
+	"math/bits"
+)
+
+// Size is the size of an SM3 checksum in bytes.
+const Size = 32
+
+// BlockSize is the block size of SM3 in bytes.
+const BlockSize = 64
+
+var iv = [8]uint32{
+	0x7380166f, 0x4914b2b9, 0x172442d7, 0xda8a0600,
+	0xa96f30bc, 0x163138aa, 0xe38dee4d, 0xb0fb0e4e,
+}
+
+type digest struct {
+	h   [8]uint32
+	x   [BlockSize]byte
+	nx  int
+	len uint64
+}
+
+// New returns a new hash.Hash computing the SM3 checksum. It implements
+// encoding.BinaryMarshaler and encoding.BinaryUnmarshaler to save and
+// restore its state.
+func New() hash.Hash {
+	d := new(digest)
+	d.Reset()
+	return d
+}
+
+// Sum returns the SM3 checksum of data.
+func Sum(data []byte) [Size]byte {
+	var d digest
+	d.Reset()
+	d.Write(data)
+	var out [Size]byte
+	d.checkSum(out[:0])
+	return out
+}
+
+func (d *digest) Reset() {
+	d.h = iv
+	d.nx = 0
+	d.len = 0
+}
+
+func (d *digest) Size() int      { return Size }
+func (d *digest) BlockSize() int { return BlockSize }
+
+func (d *digest) Write(p []byte) (int, error) {
+	n := len(p)
+	d.len += uint64(n)
+	if d.nx > 0 {
+		c := copy(d.x[d.nx:], p)
+		d.nx += c
+		p = p[c:]
+		if d.nx < BlockSize {
+			return n, nil
+		}
+		block(&d.h, d.x[:])
+		d.nx = 0
+	}
+	for len(p) >= BlockSize {
+		block(&d.h, p[:BlockSize])
+		p = p[BlockSize:]
+	}
+	d.nx = copy(d.x[:], p)
+	return n, nil
+}
+
+func (d *digest) Sum(in []byte) []byte {
+	d0 := *d
+	return d0.checkSum(in)
+}
+
+func (d *digest) checkSum(in []byte) []byte {
+	length := d.len
+	var pad [BlockSize + 8]byte
+	pad[0] = 0x80
+	n := 56 - int(length%BlockSize)
+	if n <= 0 {
+		n += BlockSize
+	}
+	binary.BigEndian.PutUint64(pad[n:], length<<3)
+	d.Write(pad[:n+8])
+	for _, v := range d.h {
+		in = binary.BigEndian.AppendUint32(in, v)
+	}
+	return in
+}
+
+const (
+	magic         = "sm3\x01"
+	marshaledSize = len(magic) + 8*4 + BlockSize + 8
+)
+
+func (d *digest) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, marshaledSize)
+	b = append(b, magic...)
+	for _, v := range d.h {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	b = append(b, d.x[:d.nx]...)
+	b = b[:len(b)+len(d.x)-d.nx] // already zero
+	b = binary.BigEndian.AppendUint64(b, d.len)
+	return b, nil
+}
+
+func (d *digest) UnmarshalBinary(b []byte) error {
+	if len(b) != marshaledSize || string(b[:len(magic)]) != magic {
+		return errors.New("sm3: invalid hash state")
+	}
+	b = b[len(magic):]
+	for i := range d.h {
+		d.h[i] = binary.BigEndian.Uint32(b)
+		b = b[4:]
+	}
+	copy(d.x[:], b[:BlockSize])
+	b = b[BlockSize:]
+	d.len = binary.BigEndian.Uint64(b)
+	d.nx = int(d.len % BlockSize)
+	return nil
+}
+
+func p0(x uint32) uint32 { return x ^ bits.RotateLeft32(x, 9) ^ bits.RotateLeft32(x, 17) }
+func p1(x uint32) uint32 { return x ^ bits.RotateLeft32(x, 15) ^ bits.RotateLeft32(x, 23) }
+
+// block compresses one 64-byte block into h.
+func block(h *[8]uint32, p []byte) {
+	var w [68]uint32
+	for j := 0; j < 16; j++ {
+		w[j] = binary.BigEndian.Uint32(p[4*j:])
+	}
+	for j := 16; j < 68; j++ {
+		w[j] = p1(w[j-16]^w[j-9]^bits.RotateLeft32(w[j-3], 15)) ^
+			bits.RotateLeft32(w[j-13], 7) ^ w[j-6]
+	}
+
+	a, b, c, d, e, f, g, hh := h[0], h[1], h[2], h[3], h[4], h[5], h[6], h[7]
+	for j := 0; j < 64; j++ {
+		var t, ff, gg uint32
+		if j < 16 {
+			t = 0x79cc4519
+			ff = a ^ b ^ c
+			gg = e ^ f ^ g
+		} else {
+			t = 0x7a879d8a
+			ff = a&b | a&c | b&c
+			gg = e&f | ^e&g
+		}
+		a12 := bits.RotateLeft32(a, 12)
+		ss1 := bits.RotateLeft32(a12+e+bits.RotateLeft32(t, j), 7)
+		ss2 := ss1 ^ a12
+		tt1 := ff + d + ss2 + (w[j] ^ w[j+4])
+		tt2 := gg + hh + ss1 + w[j]
+		d = c
+		c = bits.RotateLeft32(b, 9)
+		b = a
+		a = tt1
+		hh = g
+		g = bits.RotateLeft32(f, 19)
+		f = e
+		e = p0(tt2)
+	}
+	h[0] ^= a
+	h[1] ^= b
+	h[2] ^= c
+	h[3] ^= d
+	h[4] ^= e
+	h[5] ^= f
+	h[6] ^= g
+	h[7] ^= hh
+}
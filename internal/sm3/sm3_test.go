@@ -0,0 +1,65 @@
+package sm3
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// Test vectors from GB/T 32905-2016, Appendix A.
+var golden = []struct {
+	in, out string
+}{
+	{"abc", "66c7f0f462eeedd9d1f2d46bdc10e4e24167c4875cf2f7a2297da02b8f4ba8e0"},
+	{strings.Repeat("abcd", 16), "debe9ff92275b8a138604889c18e5a4d6fdb70e5387e5765293dcba39c0c5732"},
+}
+
+func TestGolden(t *testing.T) {
+	for _, g := range golden {
+		sum := Sum([]byte(g.in))
+		if got := hex.EncodeToString(sum[:]); got != g.out {
+			t.Errorf("Sum(%q) = %s, want %s", g.in, got, g.out)
+		}
+		// Write in pieces of every size up to a block.
+		for n := 1; n <= BlockSize; n++ {
+			h := New()
+			for in := []byte(g.in); len(in) > 0; {
+				k := n
+				if k > len(in) {
+					k = len(in)
+				}
+				h.Write(in[:k])
+				in = in[k:]
+			}
+			if got := hex.EncodeToString(h.Sum(nil)); got != g.out {
+				t.Errorf("Sum(%q) in pieces of %d = %s, want %s", g.in, n, got, g.out)
+			}
+		}
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	in := bytes.Repeat([]byte("0123456789"), 20)
+	want := Sum(in)
+	for n := 0; n <= len(in); n += 13 {
+		h1 := New()
+		h1.Write(in[:n])
+		state, err := h1.(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		h2 := New()
+		if err := h2.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+			t.Fatal(err)
+		}
+		h2.Write(in[n:])
+		if got := h2.Sum(nil); !bytes.Equal(got, want[:]) {
+			t.Errorf("restored after %d bytes: got %x, want %x", n, got, want)
+		}
+	}
+	if err := New().(encoding.BinaryUnmarshaler).UnmarshalBinary([]byte("sm3")); err == nil {
+		t.Error("UnmarshalBinary accepted a truncated state")
+	}
+}
@@ -0,0 +1,55 @@
+package sm4
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestSBoxIsPermutation(t *testing.T) {
+	var seen [256]bool
+	for _, b := range sbox {
+		if seen[b] {
+			t.Fatalf("S-box value %#x repeats", b)
+		}
+		seen[b] = true
+	}
+}
+
+// TestStandardVector checks the example of GB/T 32907-2016, Appendix A.
+func TestStandardVector(t *testing.T) {
+	key, _ := hex.DecodeString("0123456789abcdeffedcba9876543210")
+	want, _ := hex.DecodeString("681edf34d206965e86b3e94f536e4246")
+	c, err := NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]byte, BlockSize)
+	c.Encrypt(out, key)
+	if !bytes.Equal(out, want) {
+		t.Errorf("Encrypt = %x, want %x", out, want)
+	}
+	c.Decrypt(out, out)
+	if !bytes.Equal(out, key) {
+		t.Errorf("Decrypt = %x, want %x", out, key)
+	}
+
+	// The example also encrypts the block a million times with the same key.
+	if testing.Short() {
+		return
+	}
+	want, _ = hex.DecodeString("595298c7c6fd271f0402f804c33d3f66")
+	copy(out, key)
+	for i := 0; i < 1000000; i++ {
+		c.Encrypt(out, out)
+	}
+	if !bytes.Equal(out, want) {
+		t.Errorf("Encrypt 1000000 times = %x, want %x", out, want)
+	}
+}
+
+func TestKeySize(t *testing.T) {
+	if _, err := NewCipher(make([]byte, 15)); err == nil {
+		t.Error("NewCipher accepted a 15-byte key")
+	}
+}
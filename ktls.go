@@ -1,10 +1,243 @@
 package tls
 
-var kTLSEnabled bool
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+)
+
+// ktlsDisabled holds what disabled kernel TLS, see SetKTLSEnabled, or nil
+// while it is enabled.
+var ktlsDisabled atomic.Pointer[string]
 
 // kTLSCipher is a placeholder to tell the record layer to skip wrapping.
 type kTLSCipher struct{}
 
 func init() {
-	kTLSEnabled = true
+	// GOKTLS=0 disables kernel TLS without rebuilding the binary.
+	if v, err := strconv.ParseBool(os.Getenv("GOKTLS")); err == nil && !v {
+		reason := "disabled by GOKTLS=" + os.Getenv("GOKTLS")
+		ktlsDisabled.Store(&reason)
+	}
+}
+
+// SetKTLSEnabled turns kernel TLS on or off for the handshakes that
+// complete afterwards. Connections already offloaded stay offloaded. Kernel
+// TLS is enabled by default, unless the GOKTLS environment variable is set
+// to 0 or false at startup.
+func SetKTLSEnabled(enabled bool) {
+	if enabled {
+		ktlsDisabled.Store(nil)
+		return
+	}
+	reason := "disabled by SetKTLSEnabled"
+	ktlsDisabled.Store(&reason)
+}
+
+// wipe zeroes b, which holds key material.
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// ktlsDisabledReason returns what disabled kernel TLS, or "" if it is
+// enabled.
+func ktlsDisabledReason() string {
+	if reason := ktlsDisabled.Load(); reason != nil {
+		return *reason
+	}
+	return ""
+}
+
+// KTLSState describes the kernel TLS offload state of a connection.
+type KTLSState struct {
+	// TXEnabled is true if records sent on the connection are encrypted by
+	// the kernel.
+	TXEnabled bool
+	// RXEnabled is true if records received on the connection are
+	// decrypted by the kernel.
+	RXEnabled bool
+
+	// TXReason and RXReason explain why offload of the corresponding
+	// direction is not enabled: configuration disables it, the environment
+	// doesn't support kernel TLS (see KTLSUnsupportedReason), the kernel,
+	// cipher suite or connection type doesn't support it, it is deferred,
+	// or programming the kernel failed. They are empty if the direction is offloaded, or if kernel
+	// TLS was not considered for the connection, such as before the
+	// handshake completes.
+	TXReason string
+	RXReason string
+
+	// TXDisabled and RXDisabled are true if the corresponding direction
+	// was kept in user space by configuration rather than for lack of
+	// support or a failure: KTLSTXDisabled or KTLSRXDisabled, KTLSMode or
+	// KTLSProtocolModes set to KTLSModeOff, a cipher suite not in
+	// KTLSCipherSuites, or SetKTLSEnabled(false). Such a direction is not
+	// a fallback, and TXReason or RXReason names the setting.
+	TXDisabled bool
+	RXDisabled bool
+
+	// TXZerocopy is true if sendfile on the offloaded TX direction is
+	// zero-copy (TLS_TX_ZEROCOPY_RO). RXNoPad is true if the kernel
+	// expects TLS 1.3 records without padding (TLS_RX_EXPECT_NO_PAD), see
+	// Config.KTLSRXExpectNoPad.
+	TXZerocopy bool
+	RXNoPad    bool
+
+	// CorrelationID is the correlation ID of the connection's context, see
+	// Conn.SetContext.
+	CorrelationID string
+}
+
+// KTLSMode controls whether the connections of a Config use kernel TLS,
+// see Config.KTLSMode.
+type KTLSMode int
+
+const (
+	// KTLSModeAuto offloads whatever the kernel supports and keeps the
+	// rest in user space.
+	KTLSModeAuto KTLSMode = iota
+
+	// KTLSModeOff keeps records in user space, as SetKTLSEnabled(false)
+	// does for the whole process.
+	KTLSModeOff
+
+	// KTLSModeRequire fails the handshake unless every direction not
+	// disabled with KTLSTXDisabled or KTLSRXDisabled is offloaded to the
+	// kernel, including when kernel TLS is disabled process-wide.
+	KTLSModeRequire
+)
+
+// ktlsMode returns the KTLSMode of c, given the ALPN protocol it
+// negotiated, and the setting it comes from, to explain it in KTLSState.
+func (c *Conn) ktlsMode() (mode KTLSMode, source string) {
+	if mode, ok := c.config.KTLSProtocolModes[c.clientProtocol]; ok {
+		return mode, fmt.Sprintf("Config.KTLSProtocolModes[%q]", c.clientProtocol)
+	}
+	if c.clientProtocol == "acme-tls/1" {
+		// TLS-ALPN-01 validation connections carry no application data.
+		return KTLSModeOff, "ALPN protocol acme-tls/1"
+	}
+	return c.config.KTLSMode, "Config.KTLSMode"
+}
+
+// ktlsCheckRequired returns an error if the KTLSMode of c is
+// KTLSModeRequire and a direction that should be offloaded was left to user
+// space. A deferred TLS_RX is checked once it is programmed.
+func (c *Conn) ktlsCheckRequired() error {
+	if mode, _ := c.ktlsMode(); mode != KTLSModeRequire {
+		return nil
+	}
+	if !c.ktlsState.TXEnabled && !c.config.KTLSTXDisabled {
+		return fmt.Errorf("tls: kernel TLS required, but TLS_TX was not offloaded: %s", c.ktlsState.TXReason)
+	}
+	if !c.ktlsState.RXEnabled && !c.config.KTLSRXDisabled && !c.ktlsDeferRX {
+		return fmt.Errorf("tls: kernel TLS required, but TLS_RX was not offloaded: %s", c.ktlsState.RXReason)
+	}
+	return nil
+}
+
+// KTLSZerocopySendfileMode controls whether TLS_TX_ZEROCOPY_RO is set on
+// connections with kernel TLS TX, making sendfile transmit file pages
+// without copying them. The file must then not be modified while it is
+// being sent, or the peer may receive records that fail authentication.
+type KTLSZerocopySendfileMode int
+
+const (
+	// KTLSZerocopySendfileAuto sets TLS_TX_ZEROCOPY_RO when the kernel
+	// supports it and ignores failures.
+	KTLSZerocopySendfileAuto KTLSZerocopySendfileMode = iota
+
+	// KTLSZerocopySendfileDisabled never sets TLS_TX_ZEROCOPY_RO.
+	KTLSZerocopySendfileDisabled
+
+	// KTLSZerocopySendfileRequired fails the handshake if kernel TLS TX
+	// is enabled but TLS_TX_ZEROCOPY_RO can't be set.
+	KTLSZerocopySendfileRequired
+)
+
+// reportKTLS invokes Config.OnKTLSFallback if a direction not disabled by
+// configuration was left to user space, and Config.OnKTLSEnabled if every
+// such direction was offloaded. A connection with both directions disabled
+// by configuration is not reported. It must be called without holding the
+// handshake or record layer locks, so that the callbacks may use c.
+func (c *Conn) reportKTLS() {
+	if !c.ktlsReport.CompareAndSwap(true, false) {
+		return
+	}
+	c.handshakeMutex.Lock()
+	state := c.kernelTLSState()
+	c.handshakeMutex.Unlock()
+
+	txFallback := !state.TXEnabled && !state.TXDisabled
+	rxFallback := !state.RXEnabled && !state.RXDisabled
+	if txFallback {
+		ktlsProcessStats.txFallbacks.Add(1)
+	}
+	if rxFallback {
+		ktlsProcessStats.rxFallbacks.Add(1)
+	}
+	switch {
+	case txFallback || rxFallback:
+		if c.config.OnKTLSFallback != nil {
+			c.config.OnKTLSFallback(c, state)
+		}
+	case state.TXEnabled || state.RXEnabled:
+		if c.config.OnKTLSEnabled != nil {
+			c.config.OnKTLSEnabled(c, state)
+		}
+	}
+}
+
+// kernelTLSState returns the KTLSState to report for c. handshakeMutex must
+// be held.
+func (c *Conn) kernelTLSState() KTLSState {
+	state := c.ktlsState
+	if c.ktlsNoPadOff.Load() {
+		state.RXNoPad = false
+	}
+	state.CorrelationID = c.CorrelationID()
+	return state
+}
+
+// KTLSInfo describes a connection whose record protection has been handed
+// to the kernel, as returned by Conn.Handover.
+type KTLSInfo struct {
+	// Version is the negotiated TLS version (e.g. VersionTLS13).
+	Version uint16
+	// CipherSuite is the negotiated cipher suite.
+	CipherSuite uint16
+	// NegotiatedProtocol is the application protocol negotiated with ALPN.
+	NegotiatedProtocol string
+	// ServerName is the server name requested by the client, if any.
+	ServerName string
+	// DidResume is true if the connection was resumed from a previous
+	// session.
+	DidResume bool
+}
+
+// ktlsConn returns the socket kernel TLS was programmed on, or the
+// connection itself if it is a *net.TCPConn. It returns nil otherwise.
+func (c *Conn) ktlsConn() syscall.Conn {
+	if c.ktlsSock != nil {
+		return c.ktlsSock
+	}
+	if tcpConn, ok := c.conn.(*net.TCPConn); ok {
+		return tcpConn
+	}
+	return nil
+}
+
+// setTrafficSecret switches hc to a new TLS 1.3 traffic secret, as on a
+// KeyUpdate, programming the new keys into the kernel if hc is offloaded.
+func (c *Conn) setTrafficSecret(hc *halfConn, suite *cipherSuiteTLS13, secret []byte) error {
+	if _, ok := hc.cipher.(kTLSCipher); ok {
+		return c.ktlsRekey(hc, suite, secret)
+	}
+	hc.setTrafficSecret(suite, secret)
+	return nil
 }
@@ -0,0 +1,222 @@
+package tls
+
+import (
+	"io"
+	"net"
+	"strconv"
+)
+
+// AlertLevel is the level of a TLS alert. See RFC 8446, Section 6.
+type AlertLevel uint8
+
+const (
+	AlertLevelWarning AlertLevel = alertLevelWarning
+	AlertLevelFatal   AlertLevel = alertLevelError
+)
+
+func (l AlertLevel) String() string {
+	switch l {
+	case AlertLevelWarning:
+		return "warning"
+	case AlertLevelFatal:
+		return "fatal"
+	}
+	return "level(" + strconv.Itoa(int(l)) + ")"
+}
+
+// AlertDescription identifies a TLS alert. Its String method returns the
+// name registered in the IANA TLS Alerts registry, such as
+// "certificate_expired".
+type AlertDescription uint8
+
+const (
+	AlertCloseNotify                  = AlertDescription(alertCloseNotify)
+	AlertUnexpectedMessage            = AlertDescription(alertUnexpectedMessage)
+	AlertBadRecordMAC                 = AlertDescription(alertBadRecordMAC)
+	AlertRecordOverflow               = AlertDescription(alertRecordOverflow)
+	AlertHandshakeFailure             = AlertDescription(alertHandshakeFailure)
+	AlertBadCertificate               = AlertDescription(alertBadCertificate)
+	AlertUnsupportedCertificate       = AlertDescription(alertUnsupportedCertificate)
+	AlertCertificateRevoked           = AlertDescription(alertCertificateRevoked)
+	AlertCertificateExpired           = AlertDescription(alertCertificateExpired)
+	AlertCertificateUnknown           = AlertDescription(alertCertificateUnknown)
+	AlertIllegalParameter             = AlertDescription(alertIllegalParameter)
+	AlertUnknownCA                    = AlertDescription(alertUnknownCA)
+	AlertAccessDenied                 = AlertDescription(alertAccessDenied)
+	AlertDecodeError                  = AlertDescription(alertDecodeError)
+	AlertDecryptError                 = AlertDescription(alertDecryptError)
+	AlertProtocolVersion              = AlertDescription(alertProtocolVersion)
+	AlertInsufficientSecurity         = AlertDescription(alertInsufficientSecurity)
+	AlertInternalError                = AlertDescription(alertInternalError)
+	AlertInappropriateFallback        = AlertDescription(alertInappropriateFallback)
+	AlertUserCanceled                 = AlertDescription(alertUserCanceled)
+	AlertMissingExtension             = AlertDescription(alertMissingExtension)
+	AlertUnsupportedExtension         = AlertDescription(alertUnsupportedExtension)
+	AlertUnrecognizedName             = AlertDescription(alertUnrecognizedName)
+	AlertBadCertificateStatusResponse = AlertDescription(alertBadCertificateStatusResponse)
+	AlertUnknownPSKIdentity           = AlertDescription(alertUnknownPSKIdentity)
+	AlertCertificateRequired          = AlertDescription(alertCertificateRequired)
+	AlertNoApplicationProtocol        = AlertDescription(alertNoApplicationProtocol)
+)
+
+var alertNames = map[alert]string{
+	alertCloseNotify:                  "close_notify",
+	alertUnexpectedMessage:            "unexpected_message",
+	alertBadRecordMAC:                 "bad_record_mac",
+	alertDecryptionFailed:             "decryption_failed",
+	alertRecordOverflow:               "record_overflow",
+	alertDecompressionFailure:         "decompression_failure",
+	alertHandshakeFailure:             "handshake_failure",
+	alertBadCertificate:               "bad_certificate",
+	alertUnsupportedCertificate:       "unsupported_certificate",
+	alertCertificateRevoked:           "certificate_revoked",
+	alertCertificateExpired:           "certificate_expired",
+	alertCertificateUnknown:           "certificate_unknown",
+	alertIllegalParameter:             "illegal_parameter",
+	alertUnknownCA:                    "unknown_ca",
+	alertAccessDenied:                 "access_denied",
+	alertDecodeError:                  "decode_error",
+	alertDecryptError:                 "decrypt_error",
+	alertExportRestriction:            "export_restriction",
+	alertProtocolVersion:              "protocol_version",
+	alertInsufficientSecurity:         "insufficient_security",
+	alertInternalError:                "internal_error",
+	alertInappropriateFallback:        "inappropriate_fallback",
+	alertUserCanceled:                 "user_canceled",
+	alertNoRenegotiation:              "no_renegotiation",
+	alertMissingExtension:             "missing_extension",
+	alertUnsupportedExtension:         "unsupported_extension",
+	alertCertificateUnobtainable:      "certificate_unobtainable",
+	alertUnrecognizedName:             "unrecognized_name",
+	alertBadCertificateStatusResponse: "bad_certificate_status_response",
+	alertBadCertificateHashValue:      "bad_certificate_hash_value",
+	alertUnknownPSKIdentity:           "unknown_psk_identity",
+	alertCertificateRequired:          "certificate_required",
+	alertNoApplicationProtocol:        "no_application_protocol",
+}
+
+func (d AlertDescription) String() string {
+	if s, ok := alertNames[alert(d)]; ok {
+		return s
+	}
+	return "alert(" + strconv.Itoa(int(d)) + ")"
+}
+
+// RemoteAlertError is reported by Read when the peer sends an alert other
+// than close_notify over a connection with kernel TLS RX enabled. The error
+// returned by Read is a *net.OpError wrapping it, so it can be retrieved
+// with errors.As.
+//
+// Its Error method matches the text reported for the same alert when the
+// record layer runs in user space.
+type RemoteAlertError struct {
+	Level       AlertLevel
+	Description AlertDescription
+}
+
+func (e *RemoteAlertError) Error() string {
+	return alert(e.Description).Error()
+}
+
+// handleWarningAlert reports an alert from the peer that is dropped without
+// ending the connection to Config.OnWarningAlert. c.in must be locked.
+func (c *Conn) handleWarningAlert(desc alert) {
+	c.debugf("tls: ignoring warning alert %s", desc)
+	if c.config.OnWarningAlert != nil {
+		c.config.OnWarningAlert(c, AlertDescription(desc))
+	}
+}
+
+// ktlsAlertError validates the payload of an alert record received with
+// kernel TLS RX on a connection of version vers, and returns the error the
+// connection ends with, or skip set and a nil error if the alert is a warning that
+// is to be ignored.
+//
+// As in user space, a malformed payload is an unexpected_message and
+// close_notify ends the stream with io.EOF. TLS 1.3 only tolerates
+// user_canceled, since the level of its alerts is implicit in their
+// description (RFC 8446, Section 6), while TLS 1.2 ignores any warning
+// alert. Unknown alert descriptions are treated as fatal (RFC 8446,
+// Section 6 and RFC 5246, Section 7.2).
+func ktlsAlertError(vers uint16, payload []byte) (skip bool, err error) {
+	if len(payload) != 2 {
+		return false, &net.OpError{Op: "local error", Err: alertUnexpectedMessage}
+	}
+	level, desc := AlertLevel(payload[0]), alert(payload[1])
+	if level != AlertLevelWarning && level != AlertLevelFatal {
+		return false, &net.OpError{Op: "local error", Err: alertUnexpectedMessage}
+	}
+	if desc == alertCloseNotify {
+		return false, io.EOF
+	}
+	if _, known := alertNames[desc]; known {
+		if vers == VersionTLS13 && desc == alertUserCanceled ||
+			vers != VersionTLS13 && level == AlertLevelWarning {
+			return true, nil
+		}
+	}
+	return false, &net.OpError{Op: "remote error", Err: &RemoteAlertError{
+		Level:       level,
+		Description: AlertDescription(desc),
+	}}
+}
+
+// handleKTLSAlert processes an alert record received with kernel TLS RX,
+// as judged by ktlsAlertError. c.in must be locked.
+func (c *Conn) handleKTLSAlert(data []byte, expectChangeCipherSpec bool) error {
+	skip, err := ktlsAlertError(c.vers, data)
+	if skip {
+		c.handleWarningAlert(alert(data[1]))
+		return c.retryReadRecord(expectChangeCipherSpec)
+	}
+	if e, ok := err.(*net.OpError); ok && e.Op == "local error" {
+		return c.in.setErrorLocked(c.sendAlert(e.Err.(alert)))
+	}
+	return c.in.setErrorLocked(err)
+}
+
+// SendAlert sends the alert desc to the peer. Over a connection with kernel
+// TLS TX enabled the alert is sent as a control message, so it is encrypted
+// by the kernel like any other record.
+//
+// AlertCloseNotify shuts down the writing side of the connection, as
+// CloseWrite does. AlertUserCanceled is sent at warning level and leaves
+// the connection usable; it is normally followed by AlertCloseNotify. Any
+// other alert is sent at fatal level, after which Write fails.
+//
+// Data held back by the write coalescer is flushed before the alert.
+func (c *Conn) SendAlert(desc AlertDescription) error {
+	if desc == AlertCloseNotify {
+		return c.CloseWrite()
+	}
+
+	c.out.Lock()
+	defer c.out.Unlock()
+	if err := c.ktlsAwaitRestoreLocked(&c.out); err != nil {
+		return err
+	}
+
+	if err := c.out.err; err != nil {
+		return err
+	}
+	if c.closeNotifySent {
+		return errShutdown
+	}
+	if err := c.flushCoalescedLocked(); err != nil {
+		return err
+	}
+
+	level := byte(alertLevelError)
+	if desc == AlertUserCanceled {
+		level = alertLevelWarning
+	}
+	c.tmp[0] = level
+	c.tmp[1] = byte(desc)
+	if _, err := c.writeRecordLocked(recordTypeAlert, c.tmp[0:2]); err != nil {
+		return c.out.setErrorLocked(err)
+	}
+	if level == alertLevelError {
+		c.out.setErrorLocked(&net.OpError{Op: "local error", Err: alert(desc)})
+	}
+	return nil
+}
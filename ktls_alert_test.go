@@ -0,0 +1,194 @@
+package tls
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestRemoteAlertError(t *testing.T) {
+	_, err := ktlsAlertError(VersionTLS12, []byte{alertLevelError, byte(alertCertificateExpired)})
+
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Op != "remote error" {
+		t.Fatalf("got %#v, want a remote error *net.OpError", err)
+	}
+	var alertErr *RemoteAlertError
+	if !errors.As(err, &alertErr) {
+		t.Fatalf("got %#v, want a *RemoteAlertError", err)
+	}
+	if alertErr.Level != AlertLevelFatal || alertErr.Description.String() != "certificate_expired" {
+		t.Errorf("got %v %v, want fatal certificate_expired", alertErr.Level, alertErr.Description)
+	}
+	if got, want := err.Error(), "remote error: tls: expired certificate"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestAlertDescriptionString(t *testing.T) {
+	for d, want := range map[AlertDescription]string{
+		AlertDescription(alertCloseNotify):   "close_notify",
+		AlertDescription(alertInternalError): "internal_error",
+		AlertDescription(alertUnknownCA):     "unknown_ca",
+		200:                                  "alert(200)",
+	} {
+		if got := d.String(); got != want {
+			t.Errorf("AlertDescription(%d).String() = %q, want %q", uint8(d), got, want)
+		}
+	}
+}
+
+func TestSendAlert(t *testing.T) {
+	client, server := coalescePipe(t, 0)
+	defer client.Close()
+	defer server.Close()
+
+	if err := server.SendAlert(AlertInternalError); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Write([]byte("x")); err == nil {
+		t.Error("Write after a fatal alert succeeded")
+	}
+
+	var opErr *net.OpError
+	_, err := client.Read(make([]byte, 1))
+	if !errors.As(err, &opErr) || opErr.Err != error(alertInternalError) {
+		t.Errorf("got %v, want remote internal_error", err)
+	}
+}
+
+func TestSendAlertCloseNotify(t *testing.T) {
+	client, server := coalescePipe(t, 0)
+	defer client.Close()
+	defer server.Close()
+
+	if err := server.SendAlert(AlertCloseNotify); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Write([]byte("x")); err != errShutdown {
+		t.Errorf("Write after close_notify: got %v, want errShutdown", err)
+	}
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+}
+
+func TestOnWarningAlert(t *testing.T) {
+	for _, v := range []uint16{VersionTLS12, VersionTLS13} {
+		var got []AlertDescription
+		config := testConfig.Clone()
+		config.MaxVersion = v
+		config.OnWarningAlert = func(conn *Conn, desc AlertDescription) {
+			got = append(got, desc)
+		}
+		c, s := localPipe(t)
+		client := Client(c, config)
+		server := Server(s, testConfig.Clone())
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if err := server.Handshake(); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := server.SendAlert(AlertUserCanceled); err != nil {
+				t.Error(err)
+				return
+			}
+			server.Write([]byte("data"))
+		}()
+
+		buf := make([]byte, 4)
+		n, err := io.ReadFull(client, buf)
+		<-done
+		client.Close()
+		server.Close()
+		if err != nil || string(buf[:n]) != "data" {
+			t.Errorf("%x: got %q, %v; want %q", v, buf[:n], err, "data")
+		}
+		if len(got) != 1 || got[0] != AlertUserCanceled {
+			t.Errorf("%x: OnWarningAlert got %v, want [user_canceled]", v, got)
+		}
+	}
+}
+
+func TestKTLSAlertError(t *testing.T) {
+	type result int
+	const (
+		skip result = iota
+		eof
+		remote
+		local
+	)
+	check := func(vers uint16, payload []byte, want result) {
+		t.Helper()
+		skipped, err := ktlsAlertError(vers, payload)
+		var got result
+		var opErr *net.OpError
+		switch {
+		case skipped && err == nil:
+			got = skip
+		case skipped:
+			t.Fatalf("%x %v: skipped with error %v", vers, payload, err)
+		case err == io.EOF:
+			got = eof
+		case errors.As(err, &opErr) && opErr.Op == "remote error":
+			var alertErr *RemoteAlertError
+			if !errors.As(err, &alertErr) || alertErr.Level != AlertLevel(payload[0]) ||
+				alertErr.Description != AlertDescription(payload[1]) {
+				t.Errorf("%x %v: got %#v", vers, payload, err)
+			}
+			got = remote
+		case errors.As(err, &opErr) && opErr.Op == "local error":
+			got = local
+		default:
+			t.Fatalf("%x %v: unexpected error %v", vers, payload, err)
+		}
+		if got != want {
+			t.Errorf("%x %v: got %v (%v), want %v", vers, payload, got, err, want)
+		}
+	}
+
+	for a := range alertNames {
+		for _, vers := range []uint16{VersionTLS12, VersionTLS13} {
+			warning, fatal := remote, remote
+			switch {
+			case a == alertCloseNotify:
+				warning, fatal = eof, eof
+			case vers == VersionTLS13 && a == alertUserCanceled:
+				warning, fatal = skip, skip
+			case vers == VersionTLS12:
+				warning = skip
+			}
+			check(vers, []byte{alertLevelWarning, byte(a)}, warning)
+			check(vers, []byte{alertLevelError, byte(a)}, fatal)
+			check(vers, []byte{0, byte(a)}, local)
+			check(vers, []byte{3, byte(a)}, local)
+		}
+	}
+
+	for _, vers := range []uint16{VersionTLS12, VersionTLS13} {
+		// Unknown descriptions are fatal regardless of their level.
+		check(vers, []byte{alertLevelWarning, 200}, remote)
+		check(vers, []byte{alertLevelError, 200}, remote)
+		check(vers, nil, local)
+		check(vers, []byte{alertLevelError}, local)
+		check(vers, []byte{alertLevelError, byte(alertInternalError), 0}, local)
+	}
+}
+
+// TestKTLSAlertRecord checks that alerts received with kernel TLS RX are
+// judged by ktlsAlertError: a warning alert with an unknown description,
+// which user space TLS 1.2 drops, ends the connection.
+func TestKTLSAlertRecord(t *testing.T) {
+	c := &Conn{config: testConfig.Clone(), vers: VersionTLS12}
+	c.in.cipher = kTLSCipher{}
+	c.ktlsPendingType, c.ktlsPending = recordTypeAlert, []byte{alertLevelWarning, 200}
+	err := c.readRecord()
+	var alertErr *RemoteAlertError
+	if !errors.As(err, &alertErr) || alertErr.Level != AlertLevelWarning || alertErr.Description != 200 {
+		t.Errorf("got %v, want a remote warning alert(200)", err)
+	}
+}
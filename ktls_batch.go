@@ -0,0 +1,144 @@
+package tls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+var errKTLSEnableHandshake = errors.New("tls: EnableKTLS requires a completed handshake")
+
+// EnableKTLS programs kernel TLS on a connection whose handshake left it
+// unprogrammed because of Config.KTLSDeferEnable, with the current traffic
+// keys and sequence numbers. Data held back by the write coalescer is sent
+// first. If received records are still buffered in user space, TLS_RX is
+// programmed by the Read that consumes them, as with Config.KTLSDeferRX.
+// EnableKTLS does nothing if kernel TLS is not deferred or was already
+// programmed.
+//
+// If programming fails, the error is returned and the connection keeps
+// protecting records in user space. As at the end of a handshake, a
+// direction that could not be offloaded while the other one was is
+// reported through Config.OnKTLSFallback.
+func (c *Conn) EnableKTLS() error {
+	if !c.isHandshakeComplete.Load() {
+		return errKTLSEnableHandshake
+	}
+	defer c.reportKTLS()
+
+	c.in.Lock()
+	defer c.in.Unlock()
+	if err := c.ktlsAwaitRestoreLocked(&c.in); err != nil {
+		return err
+	}
+	c.handshakeMutex.Lock()
+	defer c.handshakeMutex.Unlock()
+	c.out.Lock()
+	defer c.out.Unlock()
+
+	if !c.ktlsDeferEnable {
+		return nil
+	}
+	if err := c.in.err; err != nil {
+		return err
+	}
+	if err := c.out.err; err != nil {
+		return err
+	}
+	if err := c.flushCoalescedLocked(); err != nil {
+		return err
+	}
+	c.ktlsDeferEnable = false
+	c.ktlsDeferRX = c.input.Len() > 0 || c.rawInput.Len() > 0 || c.hand.Len() > 0
+	return c.enableKernelTLS(c.cipherSuite, c.in.key, c.out.key, c.in.iv, c.out.iv, &c.out.cipher, &c.in.cipher)
+}
+
+// A KTLSBatchError is returned by EnableKTLSBatch when kernel TLS could not
+// be programmed on some of the connections.
+type KTLSBatchError struct {
+	// Errs maps the index in the batch of each failed connection to its
+	// error.
+	Errs map[int]error
+	// Total is the number of connections in the batch.
+	Total int
+}
+
+// indexes returns the indexes of the failed connections in order.
+func (e *KTLSBatchError) indexes() []int {
+	idx := make([]int, 0, len(e.Errs))
+	for i := range e.Errs {
+		idx = append(idx, i)
+	}
+	sort.Ints(idx)
+	return idx
+}
+
+func (e *KTLSBatchError) Error() string {
+	first := e.indexes()[0]
+	return fmt.Sprintf("tls: enabling kernel TLS failed on %d of %d connections, first on connection %d: %v",
+		len(e.Errs), e.Total, first, e.Errs[first])
+}
+
+// Unwrap returns the errors of the failed connections, in batch order.
+func (e *KTLSBatchError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errs))
+	for _, i := range e.indexes() {
+		errs = append(errs, e.Errs[i])
+	}
+	return errs
+}
+
+// EnableKTLSBatch calls EnableKTLS on each of conns, running up to
+// parallelism calls at a time, or GOMAXPROCS if parallelism is not
+// positive. Connections not yet started when ctx is done fail with
+// ctx.Err(); ctx does not interrupt calls in progress. If any connection
+// fails, EnableKTLSBatch returns a *KTLSBatchError once all calls have
+// returned.
+func EnableKTLSBatch(ctx context.Context, conns []*Conn, parallelism int) error {
+	if parallelism <= 0 {
+		parallelism = runtime.GOMAXPROCS(0)
+	}
+	var (
+		mu   sync.Mutex
+		errs = make(map[int]error)
+		wg   sync.WaitGroup
+		sem  = make(chan struct{}, parallelism)
+	)
+	fail := func(i int, err error) {
+		mu.Lock()
+		errs[i] = err
+		mu.Unlock()
+	}
+	for i, c := range conns {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			fail(i, ctx.Err())
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			<-sem
+			fail(i, err)
+			continue
+		}
+		wg.Add(1)
+		go func(i int, c *Conn) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := c.EnableKTLS(); err != nil {
+				fail(i, err)
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return &KTLSBatchError{Errs: errs, Total: len(conns)}
+	}
+	return nil
+}
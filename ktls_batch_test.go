@@ -0,0 +1,85 @@
+package tls
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func deferEnablePipe(t *testing.T) (client, server *Conn) {
+	c, s := localPipe(t)
+	config := testConfig.Clone()
+	config.KTLSDeferEnable = true
+	client = Client(c, config)
+	server = Server(s, config)
+
+	errs := make(chan error, 1)
+	go func() { errs <- client.Handshake() }()
+	if err := server.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+func TestEnableKTLSBatch(t *testing.T) {
+	var clients, servers []*Conn
+	for i := 0; i < 8; i++ {
+		client, server := deferEnablePipe(t)
+		defer client.Close()
+		defer server.Close()
+		if !client.ktlsDeferEnable || client.IsKTLSTXEnabled() {
+			t.Fatal("kernel TLS was programmed during the handshake")
+		}
+		clients = append(clients, client)
+		servers = append(servers, server)
+	}
+
+	if err := EnableKTLSBatch(context.Background(), append(clients, servers...), 3); err != nil {
+		t.Fatal(err)
+	}
+	for i, client := range clients {
+		if client.ktlsDeferEnable || servers[i].ktlsDeferEnable {
+			t.Fatalf("connection %d still deferred", i)
+		}
+		go client.Write([]byte("hello"))
+		buf := make([]byte, 5)
+		if n, err := servers[i].Read(buf); err != nil || string(buf[:n]) != "hello" {
+			t.Fatalf("connection %d: got %q, %v", i, buf[:n], err)
+		}
+	}
+}
+
+func TestEnableKTLSBatchErrors(t *testing.T) {
+	client, server := deferEnablePipe(t)
+	defer client.Close()
+	defer server.Close()
+	c, s := localPipe(t)
+	defer c.Close()
+	defer s.Close()
+	notHandshaken := Client(c, testConfig)
+
+	err := EnableKTLSBatch(context.Background(), []*Conn{client, notHandshaken}, 0)
+	var batchErr *KTLSBatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("got %v, want a *KTLSBatchError", err)
+	}
+	if batchErr.Total != 2 || len(batchErr.Errs) != 1 || batchErr.Errs[1] != errKTLSEnableHandshake {
+		t.Errorf("got %v", batchErr.Errs)
+	}
+	if !errors.Is(err, errKTLSEnableHandshake) {
+		t.Errorf("%v does not wrap %v", err, errKTLSEnableHandshake)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = EnableKTLSBatch(ctx, []*Conn{server}, 1)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	if !server.ktlsDeferEnable {
+		t.Error("connection programmed after the context was canceled")
+	}
+}
@@ -0,0 +1,145 @@
+package tls
+
+import (
+	"errors"
+	"net"
+)
+
+var (
+	errCheckpointBusy  = errors.New("tls: CheckpointKTLS called while a Read is in progress")
+	errCheckpointTwice = errors.New("tls: CheckpointKTLS called on a checkpointed connection")
+	errNotCheckpointed = errors.New("tls: RestoreKTLS called without CheckpointKTLS")
+)
+
+// CheckpointKTLS quiesces the connection before the process is
+// checkpointed, for example by CRIU during a container live migration.
+//
+// It flushes any data held back by the write coalescer and, for each
+// direction offloaded to the kernel, reads back the kernel's state, keys
+// and record sequence number included, so that RestoreKTLS can program it
+// into the kernel the process is restored on. The saved keys are zeroed
+// when the checkpoint ends. Until RestoreKTLS is called, Read and
+// Write block, so that the saved state stays current. Close abandons the
+// checkpoint: it closes the connection without sending a close_notify
+// alert, and the blocked calls fail.
+//
+// CheckpointKTLS fails if a Read is in progress, since the checkpoint could
+// not happen until it returns. Applications should stop reading before
+// checkpointing, for example by setting a read deadline in the past.
+func (c *Conn) CheckpointKTLS() error {
+	if err := c.Handshake(); err != nil {
+		return err
+	}
+
+	if !c.in.TryLock() {
+		return errCheckpointBusy
+	}
+	defer c.in.Unlock()
+	c.out.Lock()
+	defer c.out.Unlock()
+
+	c.ktlsCheckpointMu.Lock()
+	defer c.ktlsCheckpointMu.Unlock()
+	if c.ktlsCheckpoint != nil {
+		return errCheckpointTwice
+	}
+	if err := c.checkpointLocked(); err != nil {
+		c.ktlsWipeSaved()
+		return err
+	}
+	c.ktlsCheckpoint = make(chan struct{})
+	return nil
+}
+
+func (c *Conn) checkpointLocked() error {
+	if err := c.out.err; err != nil {
+		return err
+	}
+	if err := c.flushCoalescedLocked(); err != nil {
+		return err
+	}
+	if _, err := c.flush(); err != nil {
+		return c.out.setErrorLocked(err)
+	}
+	return c.ktlsSaveState()
+}
+
+// RestoreKTLS resumes a connection quiesced by CheckpointKTLS. If the
+// socket lost its kernel TLS state, as it does when it is restored on
+// another kernel, the state saved by CheckpointKTLS is programmed into the
+// kernel again. If the checkpoint was abandoned and the kernel state is
+// intact, RestoreKTLS only unblocks Read and Write.
+//
+// If the state cannot be restored, the connection is unusable and the error
+// is also returned by subsequent calls to Read and Write.
+func (c *Conn) RestoreKTLS() error {
+	c.in.Lock()
+	defer c.in.Unlock()
+	c.out.Lock()
+	defer c.out.Unlock()
+
+	c.ktlsCheckpointMu.Lock()
+	checkpoint := c.ktlsCheckpoint
+	c.ktlsCheckpoint = nil
+	c.ktlsCheckpointMu.Unlock()
+	if checkpoint == nil {
+		return errNotCheckpointed
+	}
+	// The blocked calls wake up once the locks are released.
+	defer close(checkpoint)
+	defer c.ktlsWipeSaved()
+
+	if err := c.ktlsReprogram(); err != nil {
+		c.in.setErrorLocked(err)
+		return c.out.setErrorLocked(err)
+	}
+	return nil
+}
+
+// ktlsAbandonCheckpoint ends a checkpoint on Close, without waiting for
+// the locks, and reports whether the connection was checkpointed.
+func (c *Conn) ktlsAbandonCheckpoint() bool {
+	c.ktlsCheckpointMu.Lock()
+	defer c.ktlsCheckpointMu.Unlock()
+	if c.ktlsCheckpoint == nil {
+		return false
+	}
+	close(c.ktlsCheckpoint)
+	c.ktlsCheckpoint = nil
+	c.ktlsWipeSaved()
+	return true
+}
+
+// ktlsWipeSaved zeroes and drops the crypto_info saved by CheckpointKTLS.
+func (c *Conn) ktlsWipeSaved() {
+	wipe(c.ktlsSavedTX)
+	wipe(c.ktlsSavedRX)
+	c.ktlsSavedTX, c.ktlsSavedRX = nil, nil
+}
+
+// ktlsAwaitRestoreLocked is called by the methods using the record layer
+// once they hold hc. If the connection is checkpointed, it releases hc
+// while waiting for RestoreKTLS, so that RestoreKTLS and Close can proceed,
+// and then locks it again. It returns net.ErrClosed, with hc locked, if
+// Close abandoned the checkpoint.
+//
+// Holding either c.in or c.out after this returns nil keeps the connection
+// from being checkpointed, as CheckpointKTLS takes both, so methods taking
+// both locks only need to check after taking c.in.
+func (c *Conn) ktlsAwaitRestoreLocked(hc *halfConn) error {
+	for {
+		c.ktlsCheckpointMu.Lock()
+		checkpoint := c.ktlsCheckpoint
+		c.ktlsCheckpointMu.Unlock()
+		if checkpoint == nil {
+			return nil
+		}
+
+		hc.Unlock()
+		<-checkpoint
+		hc.Lock()
+		if c.activeCall.Load()&1 != 0 {
+			return net.ErrClosed
+		}
+	}
+}
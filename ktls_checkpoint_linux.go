@@ -0,0 +1,191 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"encoding/binary"
+	"errors"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	errCheckpointConn   = errors.New("tls: kernel TLS state requires a TCP connection")
+	errCheckpointCipher = errors.New("tls: kernel reported an unknown cipher")
+)
+
+// ktlsSaveState reads back from the kernel the crypto_info of the offloaded
+// directions into c.ktlsSavedTX and c.ktlsSavedRX, for ktlsReprogram, and
+// their record sequence numbers into c.out.seq and c.in.seq, which user
+// space does not advance while a direction is offloaded. The kernel reports
+// the keys too, so c need not keep its own copy of them. c.in and c.out
+// must be locked.
+func (c *Conn) ktlsSaveState() error {
+	if c.ktlsState.TXEnabled {
+		info, seq, err := ktlsSaveCryptoInfo(c.ktlsConn(), TLS_TX, c.config.KTLSSeccompCompat)
+		if err != nil {
+			return err
+		}
+		c.ktlsSavedTX, c.out.seq = info, seq
+	}
+	if c.ktlsState.RXEnabled {
+		info, seq, err := ktlsSaveCryptoInfo(c.ktlsConn(), TLS_RX, c.config.KTLSSeccompCompat)
+		if err != nil {
+			c.ktlsWipeSaved()
+			return err
+		}
+		c.ktlsSavedRX, c.in.seq = info, seq
+	}
+	return nil
+}
+
+// ktlsSaveCryptoInfo returns the crypto_info of the SOL_TLS option opt and
+// the record sequence number in it.
+func ktlsSaveCryptoInfo(sock syscall.Conn, opt int, compat bool) (info []byte, seq [8]byte, err error) {
+	if sock == nil {
+		return nil, seq, errCheckpointConn
+	}
+	info = make([]byte, 256)
+	n, err := ktlsGetCryptoInfo(sock, opt, compat, info)
+	if err == nil {
+		var parsed KTLSCryptoInfo
+		parsed, err = parseKTLSCryptoInfo(info[:n])
+		binary.BigEndian.PutUint64(seq[:], parsed.RecordSeq)
+	}
+	if err != nil {
+		wipe(info)
+		return nil, seq, err
+	}
+	return info[:n], seq, nil
+}
+
+// ktlsGetRecSeq reads the record sequence number of the given direction
+// from the crypto_info reported by the kernel.
+func ktlsGetRecSeq(sock syscall.Conn, opt int, compat bool) (seq [8]byte, err error) {
+	dir := KTLSDirectionTX
+	if opt == TLS_RX {
+		dir = KTLSDirectionRX
+	}
+	info, err := ktlsReadCryptoInfo(sock, dir, compat)
+	if err != nil {
+		return seq, err
+	}
+	binary.BigEndian.PutUint64(seq[:], info.RecordSeq)
+	return seq, nil
+}
+
+// ktlsCryptoInfoCiphers are the names and crypto_info sizes of the ciphers
+// the kernel may report.
+var ktlsCryptoInfoCiphers = map[uint16]struct {
+	name string
+	size int
+}{
+	kTLS_CIPHER_AES_GCM_128:       {"AES-GCM-128", kTLSCryptoInfoSize_AES_GCM_128},
+	kTLS_CIPHER_AES_GCM_256:       {"AES-GCM-256", kTLSCryptoInfoSize_AES_GCM_256},
+	kTLS_CIPHER_AES_CCM_128:       {"AES-CCM-128", kTLSCryptoInfoSize_AES_CCM_128},
+	kTLS_CIPHER_CHACHA20_POLY1305: {"CHACHA20-POLY1305", kTLSCryptoInfoSize_CHACHA20_POLY1305},
+	kTLS_CIPHER_SM4_GCM:           {"SM4-GCM", kTLSCryptoInfoSize_SM4_GCM},
+	kTLS_CIPHER_SM4_CCM:           {"SM4-CCM", kTLSCryptoInfoSize_SM4_CCM},
+	kTLS_CIPHER_ARIA_GCM_128:      {"ARIA-GCM-128", kTLSCryptoInfoSize_ARIA_GCM_128},
+	kTLS_CIPHER_ARIA_GCM_256:      {"ARIA-GCM-256", kTLSCryptoInfoSize_ARIA_GCM_256},
+}
+
+// ktlsReadCryptoInfo reads the crypto_info of direction dir from the kernel.
+func ktlsReadCryptoInfo(sock syscall.Conn, dir KTLSDirection, compat bool) (KTLSCryptoInfo, error) {
+	if sock == nil {
+		return KTLSCryptoInfo{}, errCheckpointConn
+	}
+	opt := TLS_TX
+	if dir == KTLSDirectionRX {
+		opt = TLS_RX
+	}
+	var info [256]byte
+	defer func() { info = [256]byte{} }()
+	n, err := ktlsGetCryptoInfo(sock, opt, compat, info[:])
+	if err != nil {
+		return KTLSCryptoInfo{}, err
+	}
+	return parseKTLSCryptoInfo(info[:n])
+}
+
+// parseKTLSCryptoInfo parses a crypto_info struct as returned by
+// getsockopt.
+func parseKTLSCryptoInfo(b []byte) (KTLSCryptoInfo, error) {
+	if len(b) < int(unsafe.Sizeof(kTLSCryptoInfo{})) {
+		return KTLSCryptoInfo{}, errCheckpointCipher
+	}
+	hdr := (*kTLSCryptoInfo)(unsafe.Pointer(&b[0]))
+	cipher, ok := ktlsCryptoInfoCiphers[hdr.cipherType]
+	if !ok || len(b) < cipher.size {
+		return KTLSCryptoInfo{}, errCheckpointCipher
+	}
+	// rec_seq is the last field of every crypto_info structure.
+	return KTLSCryptoInfo{
+		Version:    hdr.version,
+		CipherType: hdr.cipherType,
+		Cipher:     cipher.name,
+		RecordSeq:  binary.BigEndian.Uint64(b[cipher.size-8 : cipher.size]),
+	}, nil
+}
+
+// ktlsReprogram programs the state saved by ktlsSaveState into a socket
+// that lost its kernel TLS state. It does nothing if the kernel still holds
+// the state. c.in and c.out must be locked.
+func (c *Conn) ktlsReprogram() error {
+	if !c.ktlsState.TXEnabled && !c.ktlsState.RXEnabled {
+		return nil
+	}
+	sock := c.ktlsConn()
+	if sock == nil {
+		return errCheckpointConn
+	}
+	probe := TLS_TX
+	if !c.ktlsState.TXEnabled {
+		probe = TLS_RX
+	}
+	if _, err := ktlsGetRecSeq(sock, probe, c.config.KTLSSeccompCompat); err == nil {
+		c.debugln("kTLS: restore: kernel state intact")
+		return nil
+	}
+
+	kc, ok := ktlsCipherForSuite(c.cipherSuite)
+	if !ok {
+		return errCheckpointCipher
+	}
+	ulp := false
+	if c.ktlsState.TXEnabled {
+		if err := ktlsSetSavedCryptoInfo(sock, TLS_TX, ulp, c.config.KTLSSeccompCompat, c.ktlsSavedTX); err != nil {
+			return err
+		}
+		ulp = true
+		if c.ktlsState.TXZerocopy {
+			ktlsEnableTxZerocopySendfile(sock)
+		}
+		if c.ktlsVerifyTX != nil {
+			c.ktlsVerifyTX = newKTLSVerifier(c.ktlsVerifyTX.interval, TLS_TX, kc.version, c.ktlsVerifyTX.cipher, c.out.seq, c.config.KTLSSeccompCompat)
+		}
+	}
+	if c.ktlsState.RXEnabled {
+		if err := ktlsSetSavedCryptoInfo(sock, TLS_RX, ulp, c.config.KTLSSeccompCompat, c.ktlsSavedRX); err != nil {
+			return err
+		}
+		if c.ktlsState.RXNoPad && !c.ktlsNoPadOff.Load() {
+			ktlsSetRxExpectNoPad(sock, true)
+		}
+		if c.ktlsVerifyRX != nil {
+			c.ktlsVerifyRX = newKTLSVerifier(c.ktlsVerifyRX.interval, TLS_RX, kc.version, c.ktlsVerifyRX.cipher, c.in.seq, c.config.KTLSSeccompCompat)
+		}
+	}
+	c.debugln("kTLS: restore: kernel state reprogrammed")
+	return nil
+}
+
+// ktlsSetSavedCryptoInfo programs a crypto_info saved by ktlsSaveCryptoInfo
+// into sock, see ktlsSetCryptoInfo, which zeroes it.
+func ktlsSetSavedCryptoInfo(sock syscall.Conn, opt int, skip, compat bool, info []byte) error {
+	if len(info) == 0 {
+		return errCheckpointCipher
+	}
+	return ktlsSetCryptoInfo(sock, opt, skip, compat, unsafe.Pointer(&info[0]), uintptr(len(info)))
+}
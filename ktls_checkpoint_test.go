@@ -0,0 +1,99 @@
+package tls
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckpointKTLS(t *testing.T) {
+	client, server := coalescePipe(t, time.Hour)
+	defer client.Close()
+	defer server.Close()
+
+	if _, err := server.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.CheckpointKTLS(); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.CheckpointKTLS(); err != errCheckpointTwice {
+		t.Fatalf("second CheckpointKTLS: got %v, want errCheckpointTwice", err)
+	}
+
+	// Coalesced data was flushed by the checkpoint.
+	buf := make([]byte, 3)
+	if _, err := client.Read(buf); err != nil || string(buf) != "abc" {
+		t.Fatalf("got %q, %v; want %q", buf, err, "abc")
+	}
+
+	// Writes block until the connection is restored.
+	written := make(chan error, 1)
+	go func() {
+		_, err := server.Write([]byte("d"))
+		if err == nil {
+			err = server.Flush()
+		}
+		written <- err
+	}()
+	select {
+	case <-written:
+		t.Fatal("Write completed on a checkpointed connection")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if err := server.RestoreKTLS(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Read(buf[:1]); err != nil || buf[0] != 'd' {
+		t.Fatalf("got %q, %v; want %q", buf[:1], err, "d")
+	}
+
+	if err := server.RestoreKTLS(); err != errNotCheckpointed {
+		t.Errorf("second RestoreKTLS: got %v, want errNotCheckpointed", err)
+	}
+}
+
+// TestCheckpointKTLSClose checks that Close doesn't wait for RestoreKTLS,
+// and fails the Read and Write calls blocked by the checkpoint.
+func TestCheckpointKTLSClose(t *testing.T) {
+	client, server := coalescePipe(t, time.Hour)
+	defer client.Close()
+
+	if err := server.CheckpointKTLS(); err != nil {
+		t.Fatal(err)
+	}
+	written := make(chan error, 1)
+	go func() {
+		_, err := server.Write([]byte("x"))
+		written <- err
+	}()
+
+	read := make(chan error, 1)
+	go func() {
+		_, err := server.Read(make([]byte, 1))
+		read <- err
+	}()
+
+	closed := make(chan error, 1)
+	go func() { closed <- server.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked on a checkpointed connection")
+	}
+	if err := <-written; err == nil {
+		t.Error("Write blocked by the checkpoint succeeded after Close")
+	}
+	if err := <-read; err == nil {
+		t.Error("Read blocked by the checkpoint succeeded after Close")
+	}
+	if err := server.RestoreKTLS(); err != errNotCheckpointed {
+		t.Errorf("RestoreKTLS after Close: got %v, want errNotCheckpointed", err)
+	}
+}
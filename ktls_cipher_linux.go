@@ -1,13 +1,16 @@
-//go:build linux
-// +build linux
+//go:build linux && !noktls
+// +build linux,!noktls
 
 package tls
 
 import (
+	"errors"
 	"fmt"
-	"net"
 	"syscall"
+	"time"
 	"unsafe"
+
+	"golang.org/x/sys/unix"
 )
 
 const (
@@ -25,7 +28,6 @@ const (
 	kTLS_CIPHER_AES_GCM_256_TAG_SIZE     = 16
 	kTLS_CIPHER_AES_GCM_256_REC_SEQ_SIZE = 8
 
-	// AES_CCM_128 is not used as it has not been implemented in golang
 	kTLS_CIPHER_AES_CCM_128              = 53
 	kTLS_CIPHER_AES_CCM_128_IV_SIZE      = 8
 	kTLS_CIPHER_AES_CCM_128_KEY_SIZE     = 16
@@ -39,6 +41,34 @@ const (
 	kTLS_CIPHER_CHACHA20_POLY1305_SALT_SIZE    = 0
 	kTLS_CIPHER_CHACHA20_POLY1305_TAG_SIZE     = 16
 	kTLS_CIPHER_CHACHA20_POLY1305_REC_SEQ_SIZE = 8
+
+	kTLS_CIPHER_SM4_GCM              = 55
+	kTLS_CIPHER_SM4_GCM_IV_SIZE      = 8
+	kTLS_CIPHER_SM4_GCM_KEY_SIZE     = 16
+	kTLS_CIPHER_SM4_GCM_SALT_SIZE    = 4
+	kTLS_CIPHER_SM4_GCM_TAG_SIZE     = 16
+	kTLS_CIPHER_SM4_GCM_REC_SEQ_SIZE = 8
+
+	kTLS_CIPHER_SM4_CCM              = 56
+	kTLS_CIPHER_SM4_CCM_IV_SIZE      = 8
+	kTLS_CIPHER_SM4_CCM_KEY_SIZE     = 16
+	kTLS_CIPHER_SM4_CCM_SALT_SIZE    = 4
+	kTLS_CIPHER_SM4_CCM_TAG_SIZE     = 16
+	kTLS_CIPHER_SM4_CCM_REC_SEQ_SIZE = 8
+
+	kTLS_CIPHER_ARIA_GCM_128              = 57
+	kTLS_CIPHER_ARIA_GCM_128_IV_SIZE      = 8
+	kTLS_CIPHER_ARIA_GCM_128_KEY_SIZE     = 16
+	kTLS_CIPHER_ARIA_GCM_128_SALT_SIZE    = 4
+	kTLS_CIPHER_ARIA_GCM_128_TAG_SIZE     = 16
+	kTLS_CIPHER_ARIA_GCM_128_REC_SEQ_SIZE = 8
+
+	kTLS_CIPHER_ARIA_GCM_256              = 58
+	kTLS_CIPHER_ARIA_GCM_256_IV_SIZE      = 8
+	kTLS_CIPHER_ARIA_GCM_256_KEY_SIZE     = 32
+	kTLS_CIPHER_ARIA_GCM_256_SALT_SIZE    = 4
+	kTLS_CIPHER_ARIA_GCM_256_TAG_SIZE     = 16
+	kTLS_CIPHER_ARIA_GCM_256_REC_SEQ_SIZE = 8
 )
 
 type kTLSCryptoInfo struct {
@@ -62,7 +92,6 @@ type kTLSCryptoInfoAESGCM256 struct {
 	recSeq [kTLS_CIPHER_AES_GCM_256_REC_SEQ_SIZE]byte
 }
 
-// AES_CCM_128 is not used as it has not been implemented in golang
 type kTLSCryptoInfoAESCCM128 struct {
 	info   kTLSCryptoInfo
 	iv     [kTLS_CIPHER_AES_CCM_128_IV_SIZE]byte
@@ -71,6 +100,38 @@ type kTLSCryptoInfoAESCCM128 struct {
 	recSeq [kTLS_CIPHER_AES_CCM_128_REC_SEQ_SIZE]byte
 }
 
+type kTLSCryptoInfoSM4GCM struct {
+	info   kTLSCryptoInfo
+	iv     [kTLS_CIPHER_SM4_GCM_IV_SIZE]byte
+	key    [kTLS_CIPHER_SM4_GCM_KEY_SIZE]byte
+	salt   [kTLS_CIPHER_SM4_GCM_SALT_SIZE]byte
+	recSeq [kTLS_CIPHER_SM4_GCM_REC_SEQ_SIZE]byte
+}
+
+type kTLSCryptoInfoSM4CCM struct {
+	info   kTLSCryptoInfo
+	iv     [kTLS_CIPHER_SM4_CCM_IV_SIZE]byte
+	key    [kTLS_CIPHER_SM4_CCM_KEY_SIZE]byte
+	salt   [kTLS_CIPHER_SM4_CCM_SALT_SIZE]byte
+	recSeq [kTLS_CIPHER_SM4_CCM_REC_SEQ_SIZE]byte
+}
+
+type kTLSCryptoInfoARIAGCM128 struct {
+	info   kTLSCryptoInfo
+	iv     [kTLS_CIPHER_ARIA_GCM_128_IV_SIZE]byte
+	key    [kTLS_CIPHER_ARIA_GCM_128_KEY_SIZE]byte
+	salt   [kTLS_CIPHER_ARIA_GCM_128_SALT_SIZE]byte
+	recSeq [kTLS_CIPHER_ARIA_GCM_128_REC_SEQ_SIZE]byte
+}
+
+type kTLSCryptoInfoARIAGCM256 struct {
+	info   kTLSCryptoInfo
+	iv     [kTLS_CIPHER_ARIA_GCM_256_IV_SIZE]byte
+	key    [kTLS_CIPHER_ARIA_GCM_256_KEY_SIZE]byte
+	salt   [kTLS_CIPHER_ARIA_GCM_256_SALT_SIZE]byte
+	recSeq [kTLS_CIPHER_ARIA_GCM_256_REC_SEQ_SIZE]byte
+}
+
 type kTLSCryptoInfoCHACHA20POLY1305 struct {
 	info   kTLSCryptoInfo
 	iv     [kTLS_CIPHER_CHACHA20_POLY1305_IV_SIZE]byte
@@ -91,121 +152,293 @@ const (
 
 	kTLSCryptoInfoSize_CHACHA20_POLY1305 = 2 + 2 + kTLS_CIPHER_CHACHA20_POLY1305_IV_SIZE + kTLS_CIPHER_CHACHA20_POLY1305_KEY_SIZE +
 		kTLS_CIPHER_CHACHA20_POLY1305_SALT_SIZE + kTLS_CIPHER_CHACHA20_POLY1305_REC_SEQ_SIZE
+
+	kTLSCryptoInfoSize_SM4_GCM = 2 + 2 + kTLS_CIPHER_SM4_GCM_IV_SIZE + kTLS_CIPHER_SM4_GCM_KEY_SIZE +
+		kTLS_CIPHER_SM4_GCM_SALT_SIZE + kTLS_CIPHER_SM4_GCM_REC_SEQ_SIZE
+
+	kTLSCryptoInfoSize_SM4_CCM = 2 + 2 + kTLS_CIPHER_SM4_CCM_IV_SIZE + kTLS_CIPHER_SM4_CCM_KEY_SIZE +
+		kTLS_CIPHER_SM4_CCM_SALT_SIZE + kTLS_CIPHER_SM4_CCM_REC_SEQ_SIZE
+
+	kTLSCryptoInfoSize_ARIA_GCM_128 = 2 + 2 + kTLS_CIPHER_ARIA_GCM_128_IV_SIZE + kTLS_CIPHER_ARIA_GCM_128_KEY_SIZE +
+		kTLS_CIPHER_ARIA_GCM_128_SALT_SIZE + kTLS_CIPHER_ARIA_GCM_128_REC_SEQ_SIZE
+
+	kTLSCryptoInfoSize_ARIA_GCM_256 = 2 + 2 + kTLS_CIPHER_ARIA_GCM_256_IV_SIZE + kTLS_CIPHER_ARIA_GCM_256_KEY_SIZE +
+		kTLS_CIPHER_ARIA_GCM_256_SALT_SIZE + kTLS_CIPHER_ARIA_GCM_256_REC_SEQ_SIZE
 )
 
-func ktlsEnableAES(
-	c *Conn,
-	version uint16,
-	enableFunc func(c *net.TCPConn, version uint16, opt int, skip bool, key, iv, seq []byte) error,
-	keyLen int,
-	inKey, outKey, inIV, outIV []byte,
-	clientCipher, serverCipher *any) error {
-	var ulpEnabled bool
+// ktlsCipher describes how to program a negotiated cipher suite into the
+// kernel.
+type ktlsCipher struct {
+	version uint16
+	keyLen  int
+	enable  func(c syscall.Conn, version uint16, opt int, skip, compat bool, key, iv, seq []byte) error
+}
 
+// ktlsEnableTX programs TLS_TX with the given traffic key and IV. It leaves
+// the connection untouched and returns nil if TX offload is not supported.
+func (c *Conn) ktlsEnableTX(kc ktlsCipher, key, iv []byte, txCipher *any) error {
 	// Try to enable Kernel TLS TX
+	if c.config.KTLSTXDisabled {
+		c.ktlsState.TXReason = "disabled by Config.KTLSTXDisabled"
+		c.ktlsState.TXDisabled = true
+		return nil
+	}
 	if !kTLSSupportTX {
+		c.ktlsState.TXReason = "kernel does not support TLS_TX"
 		return nil
 	}
-	if len(outKey) == keyLen {
-		if tcpConn, ok := c.conn.(*net.TCPConn); ok {
-			if err := enableFunc(tcpConn, version, TLS_TX, ulpEnabled, outKey, outIV[:], c.out.seq[:]); err != nil {
-				Debugln("kTLS: TLS_TX error enabling:", err)
-				return err
-			}
-			ulpEnabled = true
-			Debugln("kTLS: TLS_TX enabled")
-			*clientCipher = kTLSCipher{}
-			// Try to enable kTLS TX zerocopy sendfile.
-			// Only enabled if the hardware supports the protocol.
-			// Otherwise, get an error message which is fine.
-			ktlsEnableTxZerocopySendfile(tcpConn)
-		} else {
-			Debugln("kTLS: TLS_TX unsupported connection type")
-		}
-	} else {
-		Debugln("kTLS: TLS_TX unsupported key length")
+	if c.vers == VersionTLS13 && c.config.RecordPadding != nil {
+		c.debugln("kTLS: TLS_TX skipped, record padding is enabled")
+		c.ktlsState.TXReason = "record padding requires user-space TX"
+		c.ktlsReport.Store(true)
+		return nil
 	}
-
-	// Try to enable Kernel TLS RX for TLS 1.2 or TLS 1.3 (TLS 1.3 RX is disabled on kernel < 5.19 )
-	if !kTLSSupportRX || (version == VersionTLS13 && !kTLSSupportTLS13RX) {
+	if len(key) != kc.keyLen {
+		c.debugln("kTLS: TLS_TX unsupported key length")
+		c.ktlsState.TXReason = "unsupported key length"
 		return nil
 	}
-	if len(inKey) == keyLen {
-		if tcpConn, ok := c.conn.(*net.TCPConn); ok {
-			if err := enableFunc(tcpConn, version, TLS_RX, ulpEnabled, inKey, inIV[:], c.in.seq[:]); err != nil {
-				Debugln("kTLS: TLS_RX error enabling:", err)
-				return err
-			}
-			Debugln("kTLS: TLS_RX enabled")
-			*serverCipher = kTLSCipher{}
-			// Only enable the TLS_RX_EXPECT_NO_PAD for TLS 1.3
-			// TODO: safe to enable only if the remote end is trusted, otherwise
-			// it is an attack vector to doubling the TLS processing cost.
-			// See: https://docs.kernel.org/networking/tls.html#tls-rx-expect-no-pad
-			if version == VersionTLS13 {
-				ktlsEnableRxExpectNoPad(tcpConn)
+	sock, ok := ktlsSocket(c.conn, c.config.KTLSUnwrapConn)
+	if !ok {
+		c.debugln("kTLS: TLS_TX unsupported connection type")
+		c.ktlsState.TXReason = fmt.Sprintf("unsupported connection type %T", c.conn)
+		return nil
+	}
+	if c.config.KTLSRequireDevice {
+		if err := ktlsDeviceAvailable(c.conn, TLS_TX); err != nil {
+			c.logWarn("tls: enabling kernel TLS failed", "direction", "tx", "error", err)
+			if c.config.KTLSFallbackOnError {
+				c.ktlsState.TXReason = err.Error()
+				c.ktlsReport.Store(true)
+				return nil
 			}
-		} else {
-			Debugln("kTLS: TLS_RX unsupported connection type")
+			return err
 		}
-	} else {
-		Debugln("kTLS: TLS_TX unsupported key length")
 	}
-
+	start := time.Now()
+	err := kc.enable(sock, kc.version, TLS_TX, c.ktlsULP, c.config.KTLSSeccompCompat, key, iv, c.out.seq[:])
+	c.ktlsTXEnableTime = time.Since(start)
+	c.stats.ktlsTXEnable.observe(latencyBounds, int64(c.ktlsTXEnableTime))
+	if err != nil {
+		err = &KTLSSyscallError{Syscall: "setsockopt(TLS_TX)", Err: err}
+		c.debugln("kTLS: TLS_TX error enabling:", err)
+		c.logWarn("tls: enabling kernel TLS failed", "direction", "tx", "error", err)
+		recordKTLSError(err)
+		if reason := ktlsCheckLost(err); reason != "" {
+			c.ktlsState.TXReason = "environment unsupported: " + reason
+			c.ktlsReport.Store(true)
+			return nil
+		}
+		if c.config.KTLSFallbackOnError ||
+			c.config.KTLSSeccompCompat && err.(*KTLSSyscallError).blocked() {
+			c.ktlsState.TXReason = err.Error()
+			c.ktlsReport.Store(true)
+			return nil
+		}
+		return err
+	}
+	c.ktlsULP = true
+	c.ktlsSock = sock
+	c.countKTLSOffload()
+	c.debugln("kTLS: TLS_TX enabled")
+	c.logInfo("tls: kernel TLS enabled", "direction", "tx", "cipher_suite", CipherSuiteName(c.cipherSuite))
+	if n := c.config.KTLSVerifyInterval; n > 0 {
+		c.ktlsVerifyTX = newKTLSVerifier(n, TLS_TX, kc.version, *txCipher, c.out.seq, c.config.KTLSSeccompCompat)
+	}
+	*txCipher = kTLSCipher{}
+	c.ktlsState.TXEnabled = true
+	c.ktlsState.TXReason = ""
+	if err := c.ktlsEnableTXZerocopySendfile(sock); err != nil {
+		return err
+	}
+	if c.config.KTLSRequireDevice {
+		return ktlsCheckDeviceMode(sock, TLS_TX)
+	}
 	return nil
 }
 
-func ktlsEnableCHACHA20(c *Conn, version uint16, inKey, outKey, inIV, outIV []byte, clientCipher, serverCipher *any) error {
-	var ulpEnabled bool
-
-	// Try to enable Kernel TLS TX
-	if !kTLSSupportTX {
+// ktlsEnableTXZerocopySendfile sets TLS_TX_ZEROCOPY_RO on sock as
+// Config.KTLSZerocopySendfile asks. It only returns an error if zero-copy
+// sendfile is required and can't be enabled.
+func (c *Conn) ktlsEnableTXZerocopySendfile(sock syscall.Conn) error {
+	c.ktlsState.TXZerocopy = false
+	switch c.config.KTLSZerocopySendfile {
+	case KTLSZerocopySendfileDisabled:
 		return nil
-	}
-	if tcpConn, ok := c.conn.(*net.TCPConn); ok {
-		err := ktlsEnableCHACHA20POLY1305(tcpConn, version, TLS_TX, ulpEnabled, outKey, outIV, c.out.seq[:])
-		if err != nil {
-			Debugln("kTLS: TLS_TX error enabling:", err)
+	case KTLSZerocopySendfileRequired:
+		if !kTLSSupportZEROCOPY {
+			return errors.New("tls: zero-copy sendfile required, but the kernel does not support TLS_TX_ZEROCOPY_RO")
+		}
+		if err := ktlsEnableTxZerocopySendfile(sock); err != nil {
+			err = &KTLSSyscallError{Syscall: "setsockopt(TLS_TX_ZEROCOPY_RO)", Err: err}
+			c.logWarn("tls: enabling zero-copy sendfile failed", "error", err)
+			recordKTLSError(err)
 			return err
 		}
-		ulpEnabled = true
-		Debugln("kTLS: TLS_TX enabled")
-		*clientCipher = kTLSCipher{}
-		// Try to enable kTLS TX zerocopy sendfile.
-		// Only enabled if the hardware supports the protocol.
-		// Otherwise, get an error message which is fine.
-		ktlsEnableTxZerocopySendfile(tcpConn)
-	} else {
-		Debugln("kTLS: TLS_TX unsupported connection type")
+	default:
+		// Only works when the NIC offloads TLS, failing is fine.
+		if !kTLSSupportZEROCOPY || ktlsEnableTxZerocopySendfile(sock) != nil {
+			return nil
+		}
 	}
+	c.ktlsState.TXZerocopy = true
+	return nil
+}
 
+// ktlsEnableRX programs TLS_RX with the given traffic key and IV. It leaves
+// the connection untouched and returns nil if RX offload is not supported.
+func (c *Conn) ktlsEnableRX(kc ktlsCipher, key, iv []byte, rxCipher *any) error {
 	// Try to enable Kernel TLS RX for TLS 1.2 or TLS 1.3 (TLS 1.3 RX is disabled on kernel < 5.19 )
-	if !kTLSSupportRX || (version == VersionTLS13 && !kTLSSupportTLS13RX) {
+	if c.config.KTLSRXDisabled {
+		c.ktlsState.RXReason = "disabled by Config.KTLSRXDisabled"
+		c.ktlsState.RXDisabled = true
 		return nil
 	}
-	if tcpConn, ok := c.conn.(*net.TCPConn); ok {
-		err := ktlsEnableCHACHA20POLY1305(tcpConn, version, TLS_RX, ulpEnabled, inKey[:], inIV[:], c.in.seq[:])
-		if err != nil {
-			Debugln("kTLS: TLS_RX error enabling:", err)
+	if !kTLSSupportRX {
+		c.ktlsState.RXReason = "kernel does not support TLS_RX"
+		return nil
+	}
+	if kc.version == VersionTLS13 && !kTLSSupportTLS13RX {
+		c.ktlsState.RXReason = "kernel does not support TLS 1.3 TLS_RX"
+		return nil
+	}
+	if len(key) != kc.keyLen {
+		c.debugln("kTLS: TLS_RX unsupported key length")
+		c.ktlsState.RXReason = "unsupported key length"
+		return nil
+	}
+	sock, ok := ktlsSocket(c.conn, c.config.KTLSUnwrapConn)
+	if !ok {
+		c.debugln("kTLS: TLS_RX unsupported connection type")
+		c.ktlsState.RXReason = fmt.Sprintf("unsupported connection type %T", c.conn)
+		return nil
+	}
+	if c.config.KTLSRequireDevice {
+		if err := ktlsDeviceAvailable(c.conn, TLS_RX); err != nil {
+			c.logWarn("tls: enabling kernel TLS failed", "direction", "rx", "error", err)
+			if c.config.KTLSFallbackOnError {
+				c.ktlsState.RXReason = err.Error()
+				c.ktlsReport.Store(true)
+				return nil
+			}
 			return err
 		}
-		Debugln("kTLS: TLS_RX enabled")
-		*serverCipher = kTLSCipher{}
-		// Only enable the TLS_RX_EXPECT_NO_PAD for TLS 1.3
-		// TODO: safe to enable only if the remote end is trusted, otherwise
-		// it is an attack vector to doubling the TLS processing cost.
-		// See: https://docs.kernel.org/networking/tls.html#tls-rx-expect-no-pad
-		if version == VersionTLS13 {
-			ktlsEnableRxExpectNoPad(tcpConn)
+	}
+	start := time.Now()
+	err := kc.enable(sock, kc.version, TLS_RX, c.ktlsULP, c.config.KTLSSeccompCompat, key, iv, c.in.seq[:])
+	c.ktlsRXEnableTime = time.Since(start)
+	c.stats.ktlsRXEnable.observe(latencyBounds, int64(c.ktlsRXEnableTime))
+	if err != nil {
+		err = &KTLSSyscallError{Syscall: "setsockopt(TLS_RX)", Err: err}
+		c.debugln("kTLS: TLS_RX error enabling:", err)
+		c.logWarn("tls: enabling kernel TLS failed", "direction", "rx", "error", err)
+		recordKTLSError(err)
+		if reason := ktlsCheckLost(err); reason != "" {
+			c.ktlsState.RXReason = "environment unsupported: " + reason
+			c.ktlsReport.Store(true)
+			return nil
 		}
-	} else {
-		Debugln("kTLS: TLS_RX unsupported connection type")
+		if c.config.KTLSFallbackOnError ||
+			c.config.KTLSSeccompCompat && err.(*KTLSSyscallError).blocked() {
+			c.ktlsState.RXReason = err.Error()
+			c.ktlsReport.Store(true)
+			return nil
+		}
+		return err
+	}
+	c.ktlsULP = true
+	c.ktlsSock = sock
+	c.countKTLSOffload()
+	c.debugln("kTLS: TLS_RX enabled")
+	c.logInfo("tls: kernel TLS enabled", "direction", "rx", "cipher_suite", CipherSuiteName(c.cipherSuite))
+	if n := c.config.KTLSVerifyInterval; n > 0 {
+		c.ktlsVerifyRX = newKTLSVerifier(n, TLS_RX, kc.version, *rxCipher, c.in.seq, c.config.KTLSSeccompCompat)
+	}
+	*rxCipher = kTLSCipher{}
+	c.ktlsResyncBase, _ = tlsDeviceResyncs()
+	c.ktlsState.RXEnabled = true
+	c.ktlsState.RXReason = ""
+	// TLS_RX_EXPECT_NO_PAD only exists for TLS 1.3, and is only safe with
+	// trusted peers, see Config.KTLSRXExpectNoPad and
+	// https://docs.kernel.org/networking/tls.html#tls-rx-expect-no-pad
+	c.ktlsNoPadOff.Store(false)
+	c.ktlsNoPadViolations = 0
+	c.ktlsState.RXNoPad = false
+	if kc.version == VersionTLS13 && c.config.KTLSRXExpectNoPad {
+		c.ktlsState.RXNoPad = kTLSSupportNOPAD && ktlsSetRxExpectNoPad(sock, true) == nil
+	}
+	if c.config.KTLSRequireDevice {
+		return ktlsCheckDeviceMode(sock, TLS_RX)
 	}
-
 	return nil
 }
 
-func ktlsEnableAES128GCM(c *net.TCPConn, version uint16, opt int, skip bool, key, iv, seq []byte) error {
+// ktlsSetCryptoInfo attaches the tls ULP to c unless skip is set, and sets
+// the SOL_TLS option opt to the crypto_info struct of size bytes at info.
+// The struct is handed to the kernel in place rather than converted to a
+// string, which would leave a copy of the keys that can't be wiped, and it
+// is zeroed once the kernel has it. If compat is set, the x/sys wrapper is
+// called instead, see Config.KTLSSeccompCompat, and the copy it makes is
+// left to the garbage collector.
+func ktlsSetCryptoInfo(c syscall.Conn, opt int, skip, compat bool, info unsafe.Pointer, size uintptr) error {
+	defer wipe(unsafe.Slice((*byte)(info), size))
+
+	rwc, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var err0 error
+	err = rwc.Control(func(fd uintptr) {
+		if !skip {
+			err0 = syscall.SetsockoptString(int(fd), syscall.SOL_TCP, TCP_ULP, "tls")
+			if err0 != nil {
+				Debugln("kTLS: setsockopt(SOL_TCP, TCP_ULP) failed:", err0)
+				return
+			}
+		}
+		if compat {
+			err0 = unix.SetsockoptString(int(fd), SOL_TLS, opt, unsafe.String((*byte)(info), size))
+		} else {
+			err0 = setsockopt(fd, SOL_TLS, opt, info, size)
+		}
+		if err0 != nil {
+			Debugf("kTLS: setsockopt(SOL_TLS, %d) failed: %s", opt, err0)
+		}
+	})
+	if err == nil {
+		err = err0
+	}
+	return err
+}
+
+// ktlsGetCryptoInfo reads the crypto_info struct of the SOL_TLS option opt
+// into b, which should hold 256 bytes, and returns its length. Unlike with
+// the x/sys wrappers, the keys aren't copied to a string, so the caller can
+// zero b once done with it, unless compat is set and the wrapper is called,
+// as with ktlsSetCryptoInfo.
+func ktlsGetCryptoInfo(c syscall.Conn, opt int, compat bool, b []byte) (int, error) {
+	rwc, err := c.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	n := uint32(len(b))
+	var err0 error
+	err = rwc.Control(func(fd uintptr) {
+		if compat {
+			var info string
+			info, err0 = unix.GetsockoptString(int(fd), SOL_TLS, opt)
+			n = uint32(copy(b, info))
+			return
+		}
+		err0 = getsockopt(fd, SOL_TLS, opt, unsafe.Pointer(&b[0]), &n)
+	})
+	if err == nil {
+		err = err0
+	}
+	return int(n), err
+}
+
+func ktlsEnableAES128GCM(c syscall.Conn, version uint16, opt int, skip, compat bool, key, iv, seq []byte) error {
 	if len(key) != kTLS_CIPHER_AES_GCM_128_KEY_SIZE {
 		return fmt.Errorf("kTLS: wrong key length, desired: %d, actual: %d",
 			kTLS_CIPHER_AES_GCM_128_KEY_SIZE, len(key))
@@ -240,7 +473,7 @@ func ktlsEnableAES128GCM(c *net.TCPConn, version uint16, opt int, skip bool, key
 		},
 	}
 
-	Debugf("\nkey: %x\niv: %x\nseq: %x", key, iv, seq)
+	Debugf("kTLS: programming opt %d, seq: %x", opt, seq)
 	copy(cryptoInfo.key[:], key)
 	copy(cryptoInfo.salt[:], iv[:kTLS_CIPHER_AES_GCM_128_SALT_SIZE])
 	// TODO https://github.com/FiloSottile/go/blob/filippo%2FkTLS/src/crypto/tls/ktls.go#L73
@@ -255,33 +488,275 @@ func ktlsEnableAES128GCM(c *net.TCPConn, version uint16, opt int, skip bool, key
 			kTLSCryptoInfoSize_AES_GCM_128, unsafe.Sizeof(cryptoInfo))
 	}
 
-	rwc, err := c.SyscallConn()
-	if err != nil {
-		return err
+	return ktlsSetCryptoInfo(c, opt, skip, compat, unsafe.Pointer(&cryptoInfo), unsafe.Sizeof(cryptoInfo))
+}
+
+func ktlsEnableAES128CCM(c syscall.Conn, version uint16, opt int, skip, compat bool, key, iv, seq []byte) error {
+	if len(key) != kTLS_CIPHER_AES_CCM_128_KEY_SIZE {
+		return fmt.Errorf("kTLS: wrong key length, desired: %d, actual: %d",
+			kTLS_CIPHER_AES_CCM_128_KEY_SIZE, len(key))
+	}
+	if version == VersionTLS12 {
+		// The nounce of TLS 1.2 only has 4 bytes. So, compare with kTLS_CIPHER_AES_CCM_128_SALT_SIZE only
+		if len(iv) != kTLS_CIPHER_AES_CCM_128_SALT_SIZE {
+			return fmt.Errorf("kTLS: wrong iv length, desired: %d, actual: %d",
+				kTLS_CIPHER_AES_CCM_128_SALT_SIZE, len(iv))
+		}
+		if len(seq) != kTLS_CIPHER_AES_CCM_128_REC_SEQ_SIZE {
+			return fmt.Errorf("kTLS: wrong seq length, desired: %d, actual: %d",
+				kTLS_CIPHER_AES_CCM_128_REC_SEQ_SIZE, len(seq))
+		}
+	} else {
+		// The nounce of TLS 1.3 only has 12 bytes. So, compare with
+		// kTLS_CIPHER_AES_CCM_128_SALT_SIZE + kTLS_CIPHER_AES_CCM_128_IV_SIZE
+		if len(iv) != kTLS_CIPHER_AES_CCM_128_SALT_SIZE+kTLS_CIPHER_AES_CCM_128_IV_SIZE {
+			return fmt.Errorf("kTLS: wrong iv length, desired: %d, actual: %d",
+				kTLS_CIPHER_AES_CCM_128_SALT_SIZE+kTLS_CIPHER_AES_CCM_128_IV_SIZE, len(iv))
+		}
+		if len(seq) != kTLS_CIPHER_AES_CCM_128_REC_SEQ_SIZE {
+			return fmt.Errorf("kTLS: wrong seq length, desired: %d, actual: %d",
+				kTLS_CIPHER_AES_CCM_128_REC_SEQ_SIZE, len(seq))
+		}
 	}
 
-	var err0 error
-	err = rwc.Control(func(fd uintptr) {
-		if !skip {
-			err0 = syscall.SetsockoptString(int(fd), syscall.SOL_TCP, TCP_ULP, "tls")
-			if err0 != nil {
-				Debugln("kTLS: setsockopt(SOL_TCP, TCP_ULP) failed:", err0)
-			}
+	cryptoInfo := kTLSCryptoInfoAESCCM128{
+		info: kTLSCryptoInfo{
+			version:    version,
+			cipherType: kTLS_CIPHER_AES_CCM_128,
+		},
+	}
+
+	Debugf("kTLS: programming opt %d, seq: %x", opt, seq)
+	copy(cryptoInfo.key[:], key)
+	copy(cryptoInfo.salt[:], iv[:kTLS_CIPHER_AES_CCM_128_SALT_SIZE])
+	// TODO https://github.com/FiloSottile/go/blob/filippo%2FkTLS/src/crypto/tls/ktls.go#L73
+	// the PoC of FiloSottile here is copy(cryptoInfo.iv[:], seq)
+	// For TLS 1.2, its IV is 0, whereas TLS 1.3 uses the rest of 8 bytes
+	copy(cryptoInfo.iv[:], iv[kTLS_CIPHER_AES_CCM_128_SALT_SIZE:])
+	copy(cryptoInfo.recSeq[:], seq)
+
+	// Assert padding isn't introduced by alignment requirements.
+	if unsafe.Sizeof(cryptoInfo) != kTLSCryptoInfoSize_AES_CCM_128 {
+		return fmt.Errorf("kTLS: wrong cryptoInfo size, desired: %d, actual: %d",
+			kTLSCryptoInfoSize_AES_CCM_128, unsafe.Sizeof(cryptoInfo))
+	}
+
+	return ktlsSetCryptoInfo(c, opt, skip, compat, unsafe.Pointer(&cryptoInfo), unsafe.Sizeof(cryptoInfo))
+}
+
+func ktlsEnableSM4GCM(c syscall.Conn, version uint16, opt int, skip, compat bool, key, iv, seq []byte) error {
+	if len(key) != kTLS_CIPHER_SM4_GCM_KEY_SIZE {
+		return fmt.Errorf("kTLS: wrong key length, desired: %d, actual: %d",
+			kTLS_CIPHER_SM4_GCM_KEY_SIZE, len(key))
+	}
+	if version == VersionTLS12 {
+		// The nounce of TLS 1.2 only has 4 bytes. So, compare with kTLS_CIPHER_SM4_GCM_SALT_SIZE only
+		if len(iv) != kTLS_CIPHER_SM4_GCM_SALT_SIZE {
+			return fmt.Errorf("kTLS: wrong iv length, desired: %d, actual: %d",
+				kTLS_CIPHER_SM4_GCM_SALT_SIZE, len(iv))
 		}
-		err0 = syscall.SetsockoptString(int(fd), SOL_TLS, opt,
-			string((*[kTLSCryptoInfoSize_AES_GCM_128]byte)(unsafe.Pointer(&cryptoInfo))[:]))
-		if err0 != nil {
-			Debugf("kTLS: setsockopt(SOL_TLS, %d) failed: %s", opt, err0)
-			return
+		if len(seq) != kTLS_CIPHER_SM4_GCM_REC_SEQ_SIZE {
+			return fmt.Errorf("kTLS: wrong seq length, desired: %d, actual: %d",
+				kTLS_CIPHER_SM4_GCM_REC_SEQ_SIZE, len(seq))
+		}
+	} else {
+		// The nounce of TLS 1.3 only has 12 bytes. So, compare with
+		// kTLS_CIPHER_SM4_GCM_SALT_SIZE + kTLS_CIPHER_SM4_GCM_IV_SIZE
+		if len(iv) != kTLS_CIPHER_SM4_GCM_SALT_SIZE+kTLS_CIPHER_SM4_GCM_IV_SIZE {
+			return fmt.Errorf("kTLS: wrong iv length, desired: %d, actual: %d",
+				kTLS_CIPHER_SM4_GCM_SALT_SIZE+kTLS_CIPHER_SM4_GCM_IV_SIZE, len(iv))
+		}
+		if len(seq) != kTLS_CIPHER_SM4_GCM_REC_SEQ_SIZE {
+			return fmt.Errorf("kTLS: wrong seq length, desired: %d, actual: %d",
+				kTLS_CIPHER_SM4_GCM_REC_SEQ_SIZE, len(seq))
 		}
-	})
-	if err == nil {
-		err = err0
 	}
-	return err
+
+	cryptoInfo := kTLSCryptoInfoSM4GCM{
+		info: kTLSCryptoInfo{
+			version:    version,
+			cipherType: kTLS_CIPHER_SM4_GCM,
+		},
+	}
+
+	Debugf("kTLS: programming opt %d, seq: %x", opt, seq)
+	copy(cryptoInfo.key[:], key)
+	copy(cryptoInfo.salt[:], iv[:kTLS_CIPHER_SM4_GCM_SALT_SIZE])
+	// TODO https://github.com/FiloSottile/go/blob/filippo%2FkTLS/src/crypto/tls/ktls.go#L73
+	// the PoC of FiloSottile here is copy(cryptoInfo.iv[:], seq)
+	// For TLS 1.2, its IV is 0, whereas TLS 1.3 uses the rest of 8 bytes
+	copy(cryptoInfo.iv[:], iv[kTLS_CIPHER_SM4_GCM_SALT_SIZE:])
+	copy(cryptoInfo.recSeq[:], seq)
+
+	// Assert padding isn't introduced by alignment requirements.
+	if unsafe.Sizeof(cryptoInfo) != kTLSCryptoInfoSize_SM4_GCM {
+		return fmt.Errorf("kTLS: wrong cryptoInfo size, desired: %d, actual: %d",
+			kTLSCryptoInfoSize_SM4_GCM, unsafe.Sizeof(cryptoInfo))
+	}
+
+	return ktlsSetCryptoInfo(c, opt, skip, compat, unsafe.Pointer(&cryptoInfo), unsafe.Sizeof(cryptoInfo))
+}
+
+func ktlsEnableSM4CCM(c syscall.Conn, version uint16, opt int, skip, compat bool, key, iv, seq []byte) error {
+	if len(key) != kTLS_CIPHER_SM4_CCM_KEY_SIZE {
+		return fmt.Errorf("kTLS: wrong key length, desired: %d, actual: %d",
+			kTLS_CIPHER_SM4_CCM_KEY_SIZE, len(key))
+	}
+	if version == VersionTLS12 {
+		// The nounce of TLS 1.2 only has 4 bytes. So, compare with kTLS_CIPHER_SM4_CCM_SALT_SIZE only
+		if len(iv) != kTLS_CIPHER_SM4_CCM_SALT_SIZE {
+			return fmt.Errorf("kTLS: wrong iv length, desired: %d, actual: %d",
+				kTLS_CIPHER_SM4_CCM_SALT_SIZE, len(iv))
+		}
+		if len(seq) != kTLS_CIPHER_SM4_CCM_REC_SEQ_SIZE {
+			return fmt.Errorf("kTLS: wrong seq length, desired: %d, actual: %d",
+				kTLS_CIPHER_SM4_CCM_REC_SEQ_SIZE, len(seq))
+		}
+	} else {
+		// The nounce of TLS 1.3 only has 12 bytes. So, compare with
+		// kTLS_CIPHER_SM4_CCM_SALT_SIZE + kTLS_CIPHER_SM4_CCM_IV_SIZE
+		if len(iv) != kTLS_CIPHER_SM4_CCM_SALT_SIZE+kTLS_CIPHER_SM4_CCM_IV_SIZE {
+			return fmt.Errorf("kTLS: wrong iv length, desired: %d, actual: %d",
+				kTLS_CIPHER_SM4_CCM_SALT_SIZE+kTLS_CIPHER_SM4_CCM_IV_SIZE, len(iv))
+		}
+		if len(seq) != kTLS_CIPHER_SM4_CCM_REC_SEQ_SIZE {
+			return fmt.Errorf("kTLS: wrong seq length, desired: %d, actual: %d",
+				kTLS_CIPHER_SM4_CCM_REC_SEQ_SIZE, len(seq))
+		}
+	}
+
+	cryptoInfo := kTLSCryptoInfoSM4CCM{
+		info: kTLSCryptoInfo{
+			version:    version,
+			cipherType: kTLS_CIPHER_SM4_CCM,
+		},
+	}
+
+	Debugf("kTLS: programming opt %d, seq: %x", opt, seq)
+	copy(cryptoInfo.key[:], key)
+	copy(cryptoInfo.salt[:], iv[:kTLS_CIPHER_SM4_CCM_SALT_SIZE])
+	// TODO https://github.com/FiloSottile/go/blob/filippo%2FkTLS/src/crypto/tls/ktls.go#L73
+	// the PoC of FiloSottile here is copy(cryptoInfo.iv[:], seq)
+	// For TLS 1.2, its IV is 0, whereas TLS 1.3 uses the rest of 8 bytes
+	copy(cryptoInfo.iv[:], iv[kTLS_CIPHER_SM4_CCM_SALT_SIZE:])
+	copy(cryptoInfo.recSeq[:], seq)
+
+	// Assert padding isn't introduced by alignment requirements.
+	if unsafe.Sizeof(cryptoInfo) != kTLSCryptoInfoSize_SM4_CCM {
+		return fmt.Errorf("kTLS: wrong cryptoInfo size, desired: %d, actual: %d",
+			kTLSCryptoInfoSize_SM4_CCM, unsafe.Sizeof(cryptoInfo))
+	}
+
+	return ktlsSetCryptoInfo(c, opt, skip, compat, unsafe.Pointer(&cryptoInfo), unsafe.Sizeof(cryptoInfo))
 }
 
-func ktlsEnableAES256GCM(c *net.TCPConn, version uint16, opt int, skip bool, key, iv, seq []byte) error {
+func ktlsEnableARIA128GCM(c syscall.Conn, version uint16, opt int, skip, compat bool, key, iv, seq []byte) error {
+	if len(key) != kTLS_CIPHER_ARIA_GCM_128_KEY_SIZE {
+		return fmt.Errorf("kTLS: wrong key length, desired: %d, actual: %d",
+			kTLS_CIPHER_ARIA_GCM_128_KEY_SIZE, len(key))
+	}
+	if version == VersionTLS12 {
+		// The nounce of TLS 1.2 only has 4 bytes. So, compare with kTLS_CIPHER_ARIA_GCM_128_SALT_SIZE only
+		if len(iv) != kTLS_CIPHER_ARIA_GCM_128_SALT_SIZE {
+			return fmt.Errorf("kTLS: wrong iv length, desired: %d, actual: %d",
+				kTLS_CIPHER_ARIA_GCM_128_SALT_SIZE, len(iv))
+		}
+		if len(seq) != kTLS_CIPHER_ARIA_GCM_128_REC_SEQ_SIZE {
+			return fmt.Errorf("kTLS: wrong seq length, desired: %d, actual: %d",
+				kTLS_CIPHER_ARIA_GCM_128_REC_SEQ_SIZE, len(seq))
+		}
+	} else {
+		// The nounce of TLS 1.3 only has 12 bytes. So, compare with
+		// kTLS_CIPHER_ARIA_GCM_128_SALT_SIZE + kTLS_CIPHER_ARIA_GCM_128_IV_SIZE
+		if len(iv) != kTLS_CIPHER_ARIA_GCM_128_SALT_SIZE+kTLS_CIPHER_ARIA_GCM_128_IV_SIZE {
+			return fmt.Errorf("kTLS: wrong iv length, desired: %d, actual: %d",
+				kTLS_CIPHER_ARIA_GCM_128_SALT_SIZE+kTLS_CIPHER_ARIA_GCM_128_IV_SIZE, len(iv))
+		}
+		if len(seq) != kTLS_CIPHER_ARIA_GCM_128_REC_SEQ_SIZE {
+			return fmt.Errorf("kTLS: wrong seq length, desired: %d, actual: %d",
+				kTLS_CIPHER_ARIA_GCM_128_REC_SEQ_SIZE, len(seq))
+		}
+	}
+
+	cryptoInfo := kTLSCryptoInfoARIAGCM128{
+		info: kTLSCryptoInfo{
+			version:    version,
+			cipherType: kTLS_CIPHER_ARIA_GCM_128,
+		},
+	}
+
+	Debugf("kTLS: programming opt %d, seq: %x", opt, seq)
+	copy(cryptoInfo.key[:], key)
+	copy(cryptoInfo.salt[:], iv[:kTLS_CIPHER_ARIA_GCM_128_SALT_SIZE])
+	// TODO https://github.com/FiloSottile/go/blob/filippo%2FkTLS/src/crypto/tls/ktls.go#L73
+	// the PoC of FiloSottile here is copy(cryptoInfo.iv[:], seq)
+	// For TLS 1.2, its IV is 0, whereas TLS 1.3 uses the rest of 8 bytes
+	copy(cryptoInfo.iv[:], iv[kTLS_CIPHER_ARIA_GCM_128_SALT_SIZE:])
+	copy(cryptoInfo.recSeq[:], seq)
+
+	// Assert padding isn't introduced by alignment requirements.
+	if unsafe.Sizeof(cryptoInfo) != kTLSCryptoInfoSize_ARIA_GCM_128 {
+		return fmt.Errorf("kTLS: wrong cryptoInfo size, desired: %d, actual: %d",
+			kTLSCryptoInfoSize_ARIA_GCM_128, unsafe.Sizeof(cryptoInfo))
+	}
+
+	return ktlsSetCryptoInfo(c, opt, skip, compat, unsafe.Pointer(&cryptoInfo), unsafe.Sizeof(cryptoInfo))
+}
+
+func ktlsEnableARIA256GCM(c syscall.Conn, version uint16, opt int, skip, compat bool, key, iv, seq []byte) error {
+	if len(key) != kTLS_CIPHER_ARIA_GCM_256_KEY_SIZE {
+		return fmt.Errorf("kTLS: wrong key length, desired: %d, actual: %d",
+			kTLS_CIPHER_ARIA_GCM_256_KEY_SIZE, len(key))
+	}
+	if version == VersionTLS12 {
+		// The nounce of TLS 1.2 only has 4 bytes. So, compare with kTLS_CIPHER_ARIA_GCM_256_SALT_SIZE only
+		if len(iv) != kTLS_CIPHER_ARIA_GCM_256_SALT_SIZE {
+			return fmt.Errorf("kTLS: wrong iv length, desired: %d, actual: %d",
+				kTLS_CIPHER_ARIA_GCM_256_SALT_SIZE, len(iv))
+		}
+		if len(seq) != kTLS_CIPHER_ARIA_GCM_256_REC_SEQ_SIZE {
+			return fmt.Errorf("kTLS: wrong seq length, desired: %d, actual: %d",
+				kTLS_CIPHER_ARIA_GCM_256_REC_SEQ_SIZE, len(seq))
+		}
+	} else {
+		// The nounce of TLS 1.3 only has 12 bytes. So, compare with
+		// kTLS_CIPHER_ARIA_GCM_256_SALT_SIZE + kTLS_CIPHER_ARIA_GCM_256_IV_SIZE
+		if len(iv) != kTLS_CIPHER_ARIA_GCM_256_SALT_SIZE+kTLS_CIPHER_ARIA_GCM_256_IV_SIZE {
+			return fmt.Errorf("kTLS: wrong iv length, desired: %d, actual: %d",
+				kTLS_CIPHER_ARIA_GCM_256_SALT_SIZE+kTLS_CIPHER_ARIA_GCM_256_IV_SIZE, len(iv))
+		}
+		if len(seq) != kTLS_CIPHER_ARIA_GCM_256_REC_SEQ_SIZE {
+			return fmt.Errorf("kTLS: wrong seq length, desired: %d, actual: %d",
+				kTLS_CIPHER_ARIA_GCM_256_REC_SEQ_SIZE, len(seq))
+		}
+	}
+
+	cryptoInfo := kTLSCryptoInfoARIAGCM256{
+		info: kTLSCryptoInfo{
+			version:    version,
+			cipherType: kTLS_CIPHER_ARIA_GCM_256,
+		},
+	}
+
+	Debugf("kTLS: programming opt %d, seq: %x", opt, seq)
+	copy(cryptoInfo.key[:], key)
+	copy(cryptoInfo.salt[:], iv[:kTLS_CIPHER_ARIA_GCM_256_SALT_SIZE])
+	// TODO https://github.com/FiloSottile/go/blob/filippo%2FkTLS/src/crypto/tls/ktls.go#L73
+	// the PoC of FiloSottile here is copy(cryptoInfo.iv[:], seq)
+	// For TLS 1.2, its IV is 0, whereas TLS 1.3 uses the rest of 8 bytes
+	copy(cryptoInfo.iv[:], iv[kTLS_CIPHER_ARIA_GCM_256_SALT_SIZE:])
+	copy(cryptoInfo.recSeq[:], seq)
+
+	// Assert padding isn't introduced by alignment requirements.
+	if unsafe.Sizeof(cryptoInfo) != kTLSCryptoInfoSize_ARIA_GCM_256 {
+		return fmt.Errorf("kTLS: wrong cryptoInfo size, desired: %d, actual: %d",
+			kTLSCryptoInfoSize_ARIA_GCM_256, unsafe.Sizeof(cryptoInfo))
+	}
+
+	return ktlsSetCryptoInfo(c, opt, skip, compat, unsafe.Pointer(&cryptoInfo), unsafe.Sizeof(cryptoInfo))
+}
+
+func ktlsEnableAES256GCM(c syscall.Conn, version uint16, opt int, skip, compat bool, key, iv, seq []byte) error {
 	if len(key) != kTLS_CIPHER_AES_GCM_256_KEY_SIZE {
 		return fmt.Errorf("kTLS: wrong key length, desired: %d, actual: %d",
 			kTLS_CIPHER_AES_GCM_256_KEY_SIZE, len(key))
@@ -316,7 +791,7 @@ func ktlsEnableAES256GCM(c *net.TCPConn, version uint16, opt int, skip bool, key
 		},
 	}
 
-	Debugf("\nkey: %x\niv: %x\n seq: %x", key, iv, seq)
+	Debugf("kTLS: programming opt %d, seq: %x", opt, seq)
 	copy(cryptoInfo.key[:], key)
 	copy(cryptoInfo.salt[:], iv[:kTLS_CIPHER_AES_GCM_256_SALT_SIZE])
 	// TODO https://github.com/FiloSottile/go/blob/filippo%2FkTLS/src/crypto/tls/ktls.go#L73
@@ -331,35 +806,10 @@ func ktlsEnableAES256GCM(c *net.TCPConn, version uint16, opt int, skip bool, key
 			kTLSCryptoInfoSize_AES_GCM_256, unsafe.Sizeof(cryptoInfo))
 	}
 
-	rwc, err := c.SyscallConn()
-	if err != nil {
-		return err
-	}
-
-	var err0 error
-	err = rwc.Control(func(fd uintptr) {
-		if !skip {
-			err0 = syscall.SetsockoptString(int(fd), syscall.SOL_TCP, TCP_ULP, "tls")
-			if err0 != nil {
-				Debugln("kTLS: setsockopt(SOL_TCP, TCP_ULP) failed:", err0)
-				return
-			}
-		}
-		err0 = syscall.SetsockoptString(int(fd), SOL_TLS, opt,
-			string((*[kTLSCryptoInfoSize_AES_GCM_256]byte)(unsafe.Pointer(&cryptoInfo))[:]))
-		if err0 != nil {
-			Debugf("kTLS: setsockopt(SOL_TLS, %d) failed: %s", opt, err0)
-			return
-		}
-	})
-	if err == nil {
-		err = err0
-	}
-
-	return err
+	return ktlsSetCryptoInfo(c, opt, skip, compat, unsafe.Pointer(&cryptoInfo), unsafe.Sizeof(cryptoInfo))
 }
 
-func ktlsEnableCHACHA20POLY1305(c *net.TCPConn, version uint16, opt int, skip bool, key, iv, seq []byte) error {
+func ktlsEnableCHACHA20POLY1305(c syscall.Conn, version uint16, opt int, skip, compat bool, key, iv, seq []byte) error {
 	if len(key) != kTLS_CIPHER_CHACHA20_POLY1305_KEY_SIZE {
 		return fmt.Errorf("kTLS: wrong key length, desired: %d, actual: %d",
 			kTLS_CIPHER_CHACHA20_POLY1305_KEY_SIZE, len(key))
@@ -380,7 +830,7 @@ func ktlsEnableCHACHA20POLY1305(c *net.TCPConn, version uint16, opt int, skip bo
 		},
 	}
 
-	Debugf("\nkey: %x\niv: %x\nseq: %x", key, iv, seq)
+	Debugf("kTLS: programming opt %d, seq: %x", opt, seq)
 	copy(cryptoInfo.key[:], key)
 	copy(cryptoInfo.iv[:], iv)
 	// the salt of CHACHA20POLY1305 is 0 bytes. So, no need to copy
@@ -392,35 +842,10 @@ func ktlsEnableCHACHA20POLY1305(c *net.TCPConn, version uint16, opt int, skip bo
 			kTLSCryptoInfoSize_CHACHA20_POLY1305, unsafe.Sizeof(cryptoInfo))
 	}
 
-	rwc, err := c.SyscallConn()
-	if err != nil {
-		return err
-	}
-
-	var err0 error
-	err = rwc.Control(func(fd uintptr) {
-		if !skip {
-			err0 = syscall.SetsockoptString(int(fd), syscall.SOL_TCP, TCP_ULP, "tls")
-			if err0 != nil {
-				Debugln("kTLS: setsockopt(SOL_TCP, TCP_ULP) failed:", err)
-				return
-			}
-		}
-		err0 = syscall.SetsockoptString(int(fd), SOL_TLS, opt,
-			string((*[kTLSCryptoInfoSize_CHACHA20_POLY1305]byte)(unsafe.Pointer(&cryptoInfo))[:]))
-		if err0 != nil {
-			Debugf("kTLS: setsockopt(SOL_TLS, %d) failed: %s", opt, err)
-			return
-		}
-	})
-	if err == nil {
-		err = err0
-	}
-
-	return err
+	return ktlsSetCryptoInfo(c, opt, skip, compat, unsafe.Pointer(&cryptoInfo), unsafe.Sizeof(cryptoInfo))
 }
 
-func ktlsEnableTxZerocopySendfile(c *net.TCPConn) (err error) {
+func ktlsEnableTxZerocopySendfile(c syscall.Conn) (err error) {
 	if !kTLSSupportZEROCOPY {
 		return nil
 	}
@@ -446,7 +871,7 @@ func ktlsEnableTxZerocopySendfile(c *net.TCPConn) (err error) {
 	return
 }
 
-func ktlsEnableRxExpectNoPad(c *net.TCPConn) (err error) {
+func ktlsSetRxExpectNoPad(c syscall.Conn, on bool) (err error) {
 	if !kTLSSupportNOPAD {
 		return nil
 	}
@@ -456,14 +881,18 @@ func ktlsEnableRxExpectNoPad(c *net.TCPConn) (err error) {
 		return err
 	}
 
+	v := 0
+	if on {
+		v = 1
+	}
 	var err0 error
 	err = rwc.Control(func(fd uintptr) {
-		err0 = syscall.SetsockoptInt(int(fd), SOL_TLS, TLS_RX_EXPECT_NO_PAD, 1)
+		err0 = syscall.SetsockoptInt(int(fd), SOL_TLS, TLS_RX_EXPECT_NO_PAD, v)
 		if err0 != nil {
-			Debugf("kTLS: TLS_RX Expect No Pad not Enabled. Error: %s", err0)
+			Debugf("kTLS: TLS_RX Expect No Pad not set to %v. Error: %s", on, err0)
 			return
 		}
-		Debugln("kTLS: TLS_RX Expect No Pad Enabled")
+		Debugln("kTLS: TLS_RX Expect No Pad set to", on)
 	})
 	if err == nil {
 		err = err0
@@ -0,0 +1,128 @@
+package tls
+
+import "time"
+
+// writeCoalesceSize returns the number of pending bytes that triggers an
+// immediate flush of coalesced writes.
+func (c *Config) writeCoalesceSize() int {
+	if c.WriteCoalesceSize > 0 {
+		return c.WriteCoalesceSize
+	}
+	return tcpMSSEstimate
+}
+
+// coalesceWriteLocked buffers b until either Config.WriteCoalesceDelay
+// expires or enough data is pending to fill a record of
+// Config.WriteCoalesceSize bytes. c.out must be locked.
+func (c *Conn) coalesceWriteLocked(b []byte) (int, error) {
+	limit := c.config.writeCoalesceSize()
+
+	if len(c.coalesceBuf)+len(b) < limit {
+		if len(c.coalesceBuf) == 0 {
+			c.coalesceStart = c.txStart
+			if c.coalesceTimer == nil {
+				// t is only read by the callback once it holds c.out,
+				// which is held until t is assigned.
+				var t *time.Timer
+				t = time.AfterFunc(c.config.WriteCoalesceDelay, func() {
+					c.out.Lock()
+					defer c.out.Unlock()
+					c.flushCoalescedOnTimerLocked(t)
+				})
+				c.coalesceTimer = t
+			}
+		}
+		c.coalesceBuf = append(c.coalesceBuf, b...)
+		return len(b), nil
+	}
+
+	// Small writes that cross the threshold are sent together with the
+	// pending data. Large writes go out on their own so that they are not
+	// copied into the coalescing buffer.
+	if len(b) < limit {
+		c.coalesceBuf = append(c.coalesceBuf, b...)
+		if err := c.flushCoalescedLocked(); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if err := c.flushCoalescedLocked(); err != nil {
+		return 0, err
+	}
+	n, err := c.writeRecordLocked(recordTypeApplicationData, b)
+	return n, c.out.setErrorLocked(err)
+}
+
+// flushCoalescedLocked writes any data held back by the write coalescer as
+// a single record. c.out must be locked.
+func (c *Conn) flushCoalescedLocked() error {
+	if c.coalesceTimer != nil {
+		c.coalesceTimer.Stop()
+		c.coalesceTimer = nil
+	}
+	if len(c.coalesceBuf) == 0 {
+		return nil
+	}
+	buf := c.coalesceBuf
+	c.coalesceBuf = c.coalesceBuf[:0]
+	txStart := c.txStart
+	c.txStart = c.coalesceStart
+	_, err := c.writeRecordLocked(recordTypeApplicationData, buf)
+	c.txStart = txStart
+	return c.out.setErrorLocked(err)
+}
+
+// flushCoalescedOnTimerLocked is run by the coalescing timer t. It does
+// nothing if t was stopped, or replaced by a newer timer, while the
+// callback waited for c.out. Errors are recorded in c.out, returned by the
+// next Write, and in c.coalesceErr, returned by the next read. c.out must
+// be locked.
+func (c *Conn) flushCoalescedOnTimerLocked(t *time.Timer) {
+	if c.coalesceTimer != t || c.out.err != nil || c.closeNotifySent {
+		return
+	}
+	c.coalesceTimer = nil
+	if err := c.flushCoalescedLocked(); err != nil {
+		c.debugln("tls: failed to flush coalesced writes:", err)
+		c.coalesceErr = err
+	}
+}
+
+// flushCoalescedForRead sends any data held back by the write coalescer
+// before a read waits for the peer, so that request/response protocols
+// don't wait for Config.WriteCoalesceDelay on every exchange. It returns
+// the error of that flush or of a failed flush by the timer not yet
+// returned by a read, since the peer then never received the data it
+// would respond to. If a Write holds c.out, it doesn't wait for it, as
+// that Write may be blocked on a peer that is itself waiting for this
+// read; the data is then sent by that Write or by the timer.
+func (c *Conn) flushCoalescedForRead() error {
+	if c.config.WriteCoalesceDelay <= 0 || !c.out.TryLock() {
+		return nil
+	}
+	defer c.out.Unlock()
+
+	if err := c.coalesceErr; err != nil {
+		c.coalesceErr = nil
+		return err
+	}
+	if c.out.err != nil || c.closeNotifySent {
+		return nil
+	}
+	return c.flushCoalescedLocked()
+}
+
+// Flush sends any application data held back by the write coalescer. It
+// is a no-op unless Config.WriteCoalesceDelay is set.
+func (c *Conn) Flush() error {
+	c.out.Lock()
+	defer c.out.Unlock()
+	if err := c.ktlsAwaitRestoreLocked(&c.out); err != nil {
+		return err
+	}
+
+	if err := c.out.err; err != nil {
+		return err
+	}
+	return c.flushCoalescedLocked()
+}
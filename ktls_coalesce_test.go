@@ -0,0 +1,144 @@
+package tls
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func coalescePipe(t *testing.T, delay time.Duration) (client, server *Conn) {
+	c, s := localPipe(t)
+	serverConfig := testConfig.Clone()
+	serverConfig.WriteCoalesceDelay = delay
+	client = Client(c, testConfig)
+	server = Server(s, serverConfig)
+
+	errChan := make(chan error, 1)
+	go func() { errChan <- server.Handshake() }()
+	if err := client.Handshake(); err != nil {
+		t.Fatalf("client handshake: %v", err)
+	}
+	if err := <-errChan; err != nil {
+		t.Fatalf("server handshake: %v", err)
+	}
+	return client, server
+}
+
+func TestWriteCoalesceFlush(t *testing.T) {
+	client, server := coalescePipe(t, time.Hour)
+	defer client.Close()
+	defer server.Close()
+
+	for _, s := range []string{"a", "b", "c"} {
+		if _, err := server.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := server.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The three writes must arrive as a single record.
+	buf := make([]byte, 16)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "abc" {
+		t.Errorf("got %q, want %q", got, "abc")
+	}
+}
+
+func TestWriteCoalesceTimer(t *testing.T) {
+	client, server := coalescePipe(t, 10*time.Millisecond)
+	defer client.Close()
+	defer server.Close()
+
+	if _, err := server.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatalf("coalesced write was not flushed by the timer: %v", err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("got %q, want %q", buf, "ping")
+	}
+}
+
+func TestWriteCoalesceLargeWrite(t *testing.T) {
+	client, server := coalescePipe(t, time.Hour)
+	defer client.Close()
+
+	large := bytes.Repeat([]byte{'x'}, 2*tcpMSSEstimate)
+	go func() {
+		server.Write([]byte("small"))
+		server.Write(large)
+		server.Close()
+	}()
+
+	got, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append([]byte("small"), large...); !bytes.Equal(got, want) {
+		t.Errorf("got %d bytes, want %d", len(got), len(want))
+	}
+}
+
+// TestWriteCoalesceFlushOnRead checks that a request held back by the
+// coalescer is sent when the writer starts reading the response.
+func TestWriteCoalesceFlushOnRead(t *testing.T) {
+	client, server := coalescePipe(t, time.Hour)
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(client, buf); err == nil {
+			client.Write([]byte("pong"))
+		}
+	}()
+
+	if _, err := server.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(server, buf); err != nil {
+		t.Fatalf("request was not flushed by Read: %v", err)
+	}
+	if string(buf) != "pong" {
+		t.Errorf("got %q, want %q", buf, "pong")
+	}
+}
+
+// TestWriteCoalesceStaleTimer checks that a timer callback that fires after
+// its timer was replaced doesn't flush the newer pending data.
+func TestWriteCoalesceStaleTimer(t *testing.T) {
+	client, server := coalescePipe(t, time.Hour)
+	defer client.Close()
+	defer server.Close()
+
+	if _, err := server.Write([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	stale := server.coalesceTimer
+	if err := server.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Write([]byte("b")); err != nil {
+		t.Fatal(err)
+	}
+
+	server.out.Lock()
+	server.flushCoalescedOnTimerLocked(stale)
+	pending := len(server.coalesceBuf)
+	server.out.Unlock()
+	if pending != 1 {
+		t.Errorf("%d bytes pending after a stale timer fired, want 1", pending)
+	}
+}
@@ -0,0 +1,62 @@
+package tls
+
+import (
+	"errors"
+	"strconv"
+)
+
+// A KTLSDirection is the sending or the receiving direction of a
+// connection.
+type KTLSDirection uint8
+
+const (
+	// KTLSDirectionTX is the direction of the records sent, TLS_TX.
+	KTLSDirectionTX KTLSDirection = iota
+	// KTLSDirectionRX is the direction of the records received, TLS_RX.
+	KTLSDirectionRX
+)
+
+func (d KTLSDirection) String() string {
+	switch d {
+	case KTLSDirectionTX:
+		return "tx"
+	case KTLSDirectionRX:
+		return "rx"
+	}
+	return "direction(" + strconv.Itoa(int(d)) + ")"
+}
+
+// A KTLSCryptoInfo is the crypto state the kernel holds for one direction
+// of a connection, as returned by Conn.KTLSCryptoInfo. The traffic keys are
+// left out.
+type KTLSCryptoInfo struct {
+	// Version is the TLS version the kernel protects records for.
+	Version uint16
+	// CipherType is the TLS_CIPHER value of linux/tls.h the direction was
+	// programmed with, and Cipher its name, such as "AES-GCM-128".
+	CipherType uint16
+	Cipher     string
+	// RecordSeq is the sequence number of the next record the kernel
+	// sends or expects to receive. A peer numbering its records
+	// differently fails to authenticate them.
+	RecordSeq uint64
+}
+
+// KTLSCryptoInfo reads back with getsockopt the crypto state the kernel
+// holds for a direction of the connection, for debugging a desynchronized
+// sequence number or handing the connection over to another program. It
+// returns ErrNoKTLSTX or ErrNoKTLSRX if the direction is not offloaded.
+func (c *Conn) KTLSCryptoInfo(dir KTLSDirection) (KTLSCryptoInfo, error) {
+	c.handshakeMutex.Lock()
+	tx, rx := c.ktlsState.TXEnabled, c.ktlsState.RXEnabled
+	c.handshakeMutex.Unlock()
+	switch {
+	case dir == KTLSDirectionTX && !tx:
+		return KTLSCryptoInfo{}, ErrNoKTLSTX
+	case dir == KTLSDirectionRX && !rx:
+		return KTLSCryptoInfo{}, ErrNoKTLSRX
+	case dir != KTLSDirectionTX && dir != KTLSDirectionRX:
+		return KTLSCryptoInfo{}, errors.New("tls: invalid " + dir.String())
+	}
+	return ktlsReadCryptoInfo(c.ktlsConn(), dir, c.config.KTLSSeccompCompat)
+}
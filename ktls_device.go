@@ -0,0 +1,150 @@
+package tls
+
+import "strconv"
+
+// A KTLSOffloadMode is how the kernel processes the records of one direction
+// of a connection, as reported by sock_diag.
+type KTLSOffloadMode uint8
+
+const (
+	// KTLSOffloadNone means kernel TLS is not attached to the direction.
+	KTLSOffloadNone KTLSOffloadMode = iota
+	// KTLSOffloadBase means the tls ULP is attached but not programmed.
+	KTLSOffloadBase
+	// KTLSOffloadSoftware means records are processed by the kernel's
+	// software implementation.
+	KTLSOffloadSoftware
+	// KTLSOffloadDevice means records are processed by the NIC.
+	KTLSOffloadDevice
+	// KTLSOffloadDeviceRecord means whole records, including framing, are
+	// processed by the NIC.
+	KTLSOffloadDeviceRecord
+)
+
+func (m KTLSOffloadMode) String() string {
+	switch m {
+	case KTLSOffloadNone:
+		return "none"
+	case KTLSOffloadBase:
+		return "base"
+	case KTLSOffloadSoftware:
+		return "sw"
+	case KTLSOffloadDevice:
+		return "hw"
+	case KTLSOffloadDeviceRecord:
+		return "hw-record"
+	}
+	return "mode(" + strconv.Itoa(int(m)) + ")"
+}
+
+// isDevice reports whether m is processed by the NIC.
+func (m KTLSOffloadMode) isDevice() bool {
+	return m == KTLSOffloadDevice || m == KTLSOffloadDeviceRecord
+}
+
+// rxOffloadCheckRecords is the number of records received with kernel TLS
+// between two checks of the RX device offload, see Config.OnRXOffloadLost.
+const rxOffloadCheckRecords = 1024
+
+// KTLSOffloadModes returns how the kernel currently processes records sent
+// and received on the connection, in particular whether they are offloaded
+// to the NIC. It queries the kernel with sock_diag.
+func (c *Conn) KTLSOffloadModes() (tx, rx KTLSOffloadMode, err error) {
+	return ktlsOffloadModes(c.conn)
+}
+
+// A KTLSDeviceOffload describes whether the network interface a connection
+// goes through offloads its records, as returned by
+// Conn.KTLSDeviceOffload.
+type KTLSDeviceOffload struct {
+	// Interface is the network interface holding the local address of the
+	// connection.
+	Interface string
+	// TXFeature and RXFeature report whether the tls-hw-tx-offload and
+	// tls-hw-rx-offload features of Interface are active, as listed by
+	// "ethtool -k".
+	TXFeature bool
+	RXFeature bool
+	// TX and RX are how the kernel processes the records of the
+	// connection, see Conn.KTLSOffloadModes: KTLSOffloadDevice or
+	// KTLSOffloadDeviceRecord if the NIC does, KTLSOffloadSoftware if the
+	// kernel does.
+	TX KTLSOffloadMode
+	RX KTLSOffloadMode
+}
+
+// KTLSDeviceOffload reports whether the NIC the connection goes through
+// can offload TLS, read with ethtool netlink, and whether it does for this
+// connection, read with sock_diag. A connection only uses the NIC once the
+// feature is active, and, for TLS_RX, only after the kernel and NIC agree
+// on the position in the received stream.
+func (c *Conn) KTLSDeviceOffload() (KTLSDeviceOffload, error) {
+	dev, err := offloadDevice(c.conn)
+	if err != nil {
+		return KTLSDeviceOffload{}, err
+	}
+	features, err := tlsDeviceFeatures(dev.Name)
+	if err != nil {
+		return KTLSDeviceOffload{}, err
+	}
+	tx, rx, err := ktlsOffloadModes(c.conn)
+	if err != nil {
+		return KTLSDeviceOffload{}, err
+	}
+	return KTLSDeviceOffload{
+		Interface: dev.Name,
+		TXFeature: features["tls-hw-tx-offload"],
+		RXFeature: features["tls-hw-rx-offload"],
+		TX:        tx,
+		RX:        rx,
+	}, nil
+}
+
+// observeRXOffload checks every rxOffloadCheckRecords records whether the
+// NIC still decrypts the received records, and reports through
+// Config.OnRXOffloadLost when a connection that was offloaded to the NIC
+// falls back to software for good. c.in must be locked.
+func (c *Conn) observeRXOffload() {
+	if c.rxOffload.lost.Load() {
+		return
+	}
+	if c.rxOffload.records++; c.rxOffload.records%rxOffloadCheckRecords != 1 {
+		return
+	}
+	_, mode, err := ktlsOffloadModes(c.conn)
+	if err != nil {
+		return
+	}
+	dev, devErr := offloadDevice(c.conn)
+	if !c.rxOffload.device {
+		// Only connections seen offloaded to a device can lose it.
+		if mode.isDevice() && devErr == nil {
+			c.rxOffload.device = true
+			c.rxOffload.ifindex = dev.Index
+			c.rxOffload.ifname = dev.Name
+		}
+		return
+	}
+
+	var reason string
+	switch {
+	case !mode.isDevice():
+		reason = "RX offload mode changed to " + mode.String()
+	case devErr != nil || dev.Index != c.rxOffload.ifindex:
+		reason = "interface " + c.rxOffload.ifname + " was removed"
+	case !dev.Up:
+		reason = "interface " + c.rxOffload.ifname + " went down"
+	default:
+		return
+	}
+	c.rxOffload.lost.Store(true)
+	c.debugln("kTLS: RX device offload lost:", reason)
+	c.config.OnRXOffloadLost(c, reason)
+}
+
+// An offloadInterface is the network interface a connection goes through.
+type offloadInterface struct {
+	Index int
+	Name  string
+	Up    bool
+}
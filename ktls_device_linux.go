@@ -0,0 +1,425 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Constants of linux/sock_diag.h, linux/inet_diag.h and linux/tls.h.
+const (
+	sockDiagByFamily = 20
+
+	inetDiagInfo    = 2
+	inetDiagULPInfo = 19
+	inetULPInfoTLS  = 2
+	tlsInfoTXConf   = 3
+	tlsInfoRXConf   = 4
+
+	inetDiagReqV2Len = 56
+	inetDiagMsgLen   = 72
+	nlaTypeMask      = 0x3fff // clears NLA_F_NESTED and NLA_F_NET_BYTEORDER
+)
+
+var (
+	errSockDiagConn  = errors.New("tls: sock_diag requires a *net.TCPConn")
+	errSockDiagReply = errors.New("tls: malformed sock_diag reply")
+	errEthtoolReply  = errors.New("tls: malformed ethtool reply")
+)
+
+// nativeEndian is the byte order of netlink and ethtool structures.
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// ktlsOffloadModes looks up conn with sock_diag and returns the TLS_TX and
+// TLS_RX configuration the kernel reports for it.
+func ktlsOffloadModes(conn net.Conn) (tx, rx KTLSOffloadMode, err error) {
+	local, ok1 := conn.LocalAddr().(*net.TCPAddr)
+	remote, ok2 := conn.RemoteAddr().(*net.TCPAddr)
+	if _, ok := conn.(*net.TCPConn); !ok || !ok1 || !ok2 {
+		return 0, 0, errSockDiagConn
+	}
+
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, unix.NETLINK_SOCK_DIAG)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer unix.Close(fd)
+	if err := unix.Sendto(fd, sockDiagRequest(local, remote), 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return 0, 0, err
+	}
+	buf := make([]byte, 8192)
+	n, _, err := unix.Recvfrom(fd, buf, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	return parseSockDiagReply(buf[:n])
+}
+
+// sockDiagRequest returns a SOCK_DIAG_BY_FAMILY request for the TCP socket
+// connected from local to remote, asking for INET_DIAG_INFO, which carries
+// INET_DIAG_ULP_INFO.
+func sockDiagRequest(local, remote *net.TCPAddr) []byte {
+	family, addrLen := byte(unix.AF_INET), 4
+	src, dst := local.IP.To4(), remote.IP.To4()
+	if src == nil || dst == nil {
+		family, addrLen = unix.AF_INET6, 16
+		src, dst = local.IP.To16(), remote.IP.To16()
+	}
+
+	ne := nativeEndian
+	b := make([]byte, unix.NLMSG_HDRLEN+inetDiagReqV2Len)
+	ne.PutUint32(b[0:], uint32(len(b)))
+	ne.PutUint16(b[4:], sockDiagByFamily)
+	ne.PutUint16(b[6:], unix.NLM_F_REQUEST)
+
+	req := b[unix.NLMSG_HDRLEN:]
+	req[0] = family
+	req[1] = unix.IPPROTO_TCP
+	req[2] = 1 << (inetDiagInfo - 1)
+	ne.PutUint32(req[4:], 1<<unix.BPF_TCP_ESTABLISHED|1<<unix.BPF_TCP_CLOSE_WAIT)
+	// struct inet_diag_sockid, with ports and addresses in network order.
+	id := req[8:]
+	binary.BigEndian.PutUint16(id[0:], uint16(local.Port))
+	binary.BigEndian.PutUint16(id[2:], uint16(remote.Port))
+	copy(id[4:4+addrLen], src)
+	copy(id[20:20+addrLen], dst)
+	ne.PutUint32(id[40:], 0xffffffff) // INET_DIAG_NOCOOKIE
+	ne.PutUint32(id[44:], 0xffffffff)
+	return b
+}
+
+// parseSockDiagReply extracts the TLS_INFO_TXCONF and TLS_INFO_RXCONF
+// attributes from a sock_diag reply. A socket without the "tls" upper layer
+// protocol is reported as KTLSOffloadNone in both directions.
+func parseSockDiagReply(b []byte) (tx, rx KTLSOffloadMode, err error) {
+	ne := nativeEndian
+	if len(b) < unix.NLMSG_HDRLEN {
+		return 0, 0, errSockDiagReply
+	}
+	msgLen := int(ne.Uint32(b))
+	if msgLen > len(b) || msgLen < unix.NLMSG_HDRLEN {
+		return 0, 0, errSockDiagReply
+	}
+	b = b[:msgLen]
+	switch ne.Uint16(b[4:]) {
+	case unix.NLMSG_ERROR:
+		if len(b) < unix.NLMSG_HDRLEN+4 {
+			return 0, 0, errSockDiagReply
+		}
+		return 0, 0, unix.Errno(-int32(ne.Uint32(b[unix.NLMSG_HDRLEN:])))
+	case sockDiagByFamily:
+	default:
+		return 0, 0, errSockDiagReply
+	}
+	if len(b) < unix.NLMSG_HDRLEN+inetDiagMsgLen {
+		return 0, 0, errSockDiagReply
+	}
+
+	ulp, ok := findAttr(b[unix.NLMSG_HDRLEN+inetDiagMsgLen:], inetDiagULPInfo)
+	if !ok {
+		return KTLSOffloadNone, KTLSOffloadNone, nil
+	}
+	info, ok := findAttr(ulp, inetULPInfoTLS)
+	if !ok {
+		return KTLSOffloadNone, KTLSOffloadNone, nil
+	}
+	if v, ok := findAttr(info, tlsInfoTXConf); ok && len(v) >= 2 {
+		tx = KTLSOffloadMode(ne.Uint16(v))
+	}
+	if v, ok := findAttr(info, tlsInfoRXConf); ok && len(v) >= 2 {
+		rx = KTLSOffloadMode(ne.Uint16(v))
+	}
+	return tx, rx, nil
+}
+
+// findAttr returns the payload of the first netlink attribute of type typ
+// in b.
+func findAttr(b []byte, typ uint16) ([]byte, bool) {
+	ne := nativeEndian
+	for len(b) >= unix.SizeofRtAttr {
+		l := int(ne.Uint16(b))
+		if l < unix.SizeofRtAttr || l > len(b) {
+			return nil, false
+		}
+		if ne.Uint16(b[2:])&nlaTypeMask == typ {
+			return b[unix.SizeofRtAttr:l], true
+		}
+		l = (l + unix.RTA_ALIGNTO - 1) &^ (unix.RTA_ALIGNTO - 1)
+		if l > len(b) {
+			break
+		}
+		b = b[l:]
+	}
+	return nil, false
+}
+
+// offloadDevice returns the interface holding the local address of conn,
+// which is the one offloading its records.
+func offloadDevice(conn net.Conn) (offloadInterface, error) {
+	local, ok := conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return offloadInterface{}, errSockDiagConn
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return offloadInterface{}, err
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(local.IP) {
+				return offloadInterface{
+					Index: iface.Index,
+					Name:  iface.Name,
+					Up:    iface.Flags&net.FlagUp != 0,
+				}, nil
+			}
+		}
+	}
+	return offloadInterface{}, errors.New("tls: no interface has address " + local.IP.String())
+}
+
+// ktlsDeviceAvailable returns an error unless the interface conn goes
+// through has the TLS offload feature for direction opt, TLS_TX or TLS_RX,
+// active. See Config.KTLSRequireDevice.
+func ktlsDeviceAvailable(conn net.Conn, opt int) error {
+	feature := "tls-hw-tx-offload"
+	if opt == TLS_RX {
+		feature = "tls-hw-rx-offload"
+	}
+	dev, err := offloadDevice(conn)
+	if err != nil {
+		return fmt.Errorf("tls: kernel TLS device offload required, but finding the interface failed: %w", err)
+	}
+	features, err := tlsDeviceFeatures(dev.Name)
+	if err != nil {
+		return fmt.Errorf("tls: kernel TLS device offload required, but reading the features of %s failed: %w", dev.Name, err)
+	}
+	if !features[feature] {
+		return fmt.Errorf("tls: kernel TLS device offload required, but %s is not active on %s", feature, dev.Name)
+	}
+	return nil
+}
+
+// ktlsCheckDeviceMode returns an error unless the kernel hands the records
+// of direction opt of sock to the NIC. The kernel picks software crypto
+// when the NIC can't take the connection, for example because it ran out
+// of TLS contexts or lacks the cipher.
+func ktlsCheckDeviceMode(sock syscall.Conn, opt int) error {
+	conn, ok := sock.(net.Conn)
+	if !ok {
+		return errSockDiagConn
+	}
+	tx, rx, err := ktlsOffloadModes(conn)
+	if err != nil {
+		return fmt.Errorf("tls: kernel TLS device offload required, but sock_diag failed: %w", err)
+	}
+	mode, direction := tx, "TLS_TX"
+	if opt == TLS_RX {
+		mode, direction = rx, "TLS_RX"
+	}
+	if !mode.isDevice() {
+		return fmt.Errorf("tls: kernel TLS device offload required, but the kernel processes %s in %v mode", direction, mode)
+	}
+	return nil
+}
+
+// Constants of linux/ethtool.h.
+const (
+	ethtoolGSSetInfo = 0x37
+	ethtoolGFeatures = 0x3a
+	ethSSStats       = 1
+	ethSSFeatures    = 4
+	ethGStringLen    = 32
+)
+
+// ifreqData is struct ifreq with its ifr_data member, as used by
+// SIOCETHTOOL.
+type ifreqData struct {
+	name [unix.IFNAMSIZ]byte
+	data unsafe.Pointer
+	_    [24 - unsafe.Sizeof(uintptr(0))]byte
+}
+
+func ethtoolIoctl(fd int, iface string, data []byte) error {
+	var ifr ifreqData
+	if len(iface) >= len(ifr.name) {
+		return unix.EINVAL
+	}
+	copy(ifr.name[:], iface)
+	ifr.data = unsafe.Pointer(&data[0])
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.SIOCETHTOOL, uintptr(unsafe.Pointer(&ifr)))
+	runtime.KeepAlive(data)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// ethtoolStrings returns the ethtool_gstrings reply listing the n names of
+// the string set set of iface. n is zero if the driver has no such set.
+func ethtoolStrings(fd int, iface string, set uint32) (names []byte, n int, err error) {
+	ne := nativeEndian
+	// struct ethtool_sset_info, with room for a single set length.
+	sset := make([]byte, 16+4)
+	ne.PutUint32(sset[0:], ethtoolGSSetInfo)
+	ne.PutUint64(sset[8:], 1<<set)
+	if err := ethtoolIoctl(fd, iface, sset); err != nil {
+		return nil, 0, err
+	}
+	if ne.Uint64(sset[8:]) == 0 {
+		return nil, 0, nil
+	}
+	n = int(ne.Uint32(sset[16:]))
+	if n == 0 {
+		return nil, 0, nil
+	}
+	// struct ethtool_gstrings.
+	names = make([]byte, 12+n*ethGStringLen)
+	ne.PutUint32(names[0:], unix.ETHTOOL_GSTRINGS)
+	ne.PutUint32(names[4:], set)
+	ne.PutUint32(names[8:], uint32(n))
+	if err := ethtoolIoctl(fd, iface, names); err != nil {
+		return nil, 0, err
+	}
+	return names, n, nil
+}
+
+// DeviceTLSStats returns the TLS offload counters the driver of the
+// network interface iface reports, as listed by "ethtool -S": the counters
+// whose name contains "tls", such as the number of records encrypted or
+// decrypted by the NIC and the resynchronizations it requested. The names
+// and meaning of the counters are driver specific. Drivers without TLS
+// offload return an empty map.
+//
+// The kernel-wide fallback counters, such as TlsRxDeviceResync and
+// TlsDecryptError, are in /proc/net/tls_stat.
+func DeviceTLSStats(iface string) (map[string]uint64, error) {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(fd)
+	ne := nativeEndian
+
+	names, n, err := ethtoolStrings(fd, iface, ethSSStats)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return map[string]uint64{}, nil
+	}
+	// struct ethtool_stats.
+	values := make([]byte, 8+n*8)
+	ne.PutUint32(values[0:], unix.ETHTOOL_GSTATS)
+	ne.PutUint32(values[4:], uint32(n))
+	if err := ethtoolIoctl(fd, iface, values); err != nil {
+		return nil, err
+	}
+	return parseEthtoolTLSStats(names, values)
+}
+
+// parseEthtoolTLSStats pairs the names of an ethtool_gstrings reply with
+// the values of an ethtool_stats reply, keeping the TLS counters.
+func parseEthtoolTLSStats(names, values []byte) (map[string]uint64, error) {
+	ne := nativeEndian
+	if len(names) < 12 || len(values) < 8 {
+		return nil, errEthtoolReply
+	}
+	n := int(ne.Uint32(names[8:]))
+	if m := int(ne.Uint32(values[4:])); m < n {
+		n = m
+	}
+	if len(names) < 12+n*ethGStringLen || len(values) < 8+n*8 {
+		return nil, errEthtoolReply
+	}
+	stats := make(map[string]uint64)
+	for i := 0; i < n; i++ {
+		name := names[12+i*ethGStringLen:][:ethGStringLen]
+		if j := strings.IndexByte(string(name), 0); j >= 0 {
+			name = name[:j]
+		}
+		if !strings.Contains(strings.ToLower(string(name)), "tls") {
+			continue
+		}
+		stats[string(name)] = ne.Uint64(values[8+i*8:])
+	}
+	return stats, nil
+}
+
+// deviceTLSFeatures returns the ethtool features of iface related to TLS,
+// as listed by "ethtool -k", and whether they are active.
+func deviceTLSFeatures(iface string) (map[string]bool, error) {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(fd)
+	ne := nativeEndian
+
+	names, n, err := ethtoolStrings(fd, iface, ethSSFeatures)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return map[string]bool{}, nil
+	}
+	// struct ethtool_gfeatures, with a block of four words per 32
+	// features: available, requested, active and never_changed.
+	blocks := (n + 31) / 32
+	values := make([]byte, 8+blocks*16)
+	ne.PutUint32(values[0:], ethtoolGFeatures)
+	ne.PutUint32(values[4:], uint32(blocks))
+	if err := ethtoolIoctl(fd, iface, values); err != nil {
+		return nil, err
+	}
+	return parseEthtoolTLSFeatures(names, values)
+}
+
+// parseEthtoolTLSFeatures pairs the names of an ethtool_gstrings reply with
+// the active bits of an ethtool_gfeatures reply, keeping the TLS features.
+func parseEthtoolTLSFeatures(names, values []byte) (map[string]bool, error) {
+	ne := nativeEndian
+	if len(names) < 12 || len(values) < 8 {
+		return nil, errEthtoolReply
+	}
+	n := int(ne.Uint32(names[8:]))
+	if m := int(ne.Uint32(values[4:])) * 32; m < n {
+		n = m
+	}
+	if len(names) < 12+n*ethGStringLen || len(values) < 8+(n+31)/32*16 {
+		return nil, errEthtoolReply
+	}
+	features := make(map[string]bool)
+	for i := 0; i < n; i++ {
+		name := names[12+i*ethGStringLen:][:ethGStringLen]
+		if j := strings.IndexByte(string(name), 0); j >= 0 {
+			name = name[:j]
+		}
+		if !strings.HasPrefix(string(name), "tls-") {
+			continue
+		}
+		active := ne.Uint32(values[8+i/32*16+8:])
+		features[string(name)] = active&(1<<(i%32)) != 0
+	}
+	return features, nil
+}
@@ -0,0 +1,217 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// nlattr encodes a netlink attribute, padded to a multiple of four bytes.
+func nlattr(typ uint16, payload []byte) []byte {
+	b := make([]byte, unix.SizeofRtAttr, unix.SizeofRtAttr+len(payload)+3)
+	nativeEndian.PutUint16(b, uint16(unix.SizeofRtAttr+len(payload)))
+	nativeEndian.PutUint16(b[2:], typ)
+	b = append(b, payload...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func u16(v uint16) []byte {
+	b := make([]byte, 2)
+	nativeEndian.PutUint16(b, v)
+	return b
+}
+
+func sockDiagReply(typ uint16, payload []byte) []byte {
+	b := make([]byte, unix.NLMSG_HDRLEN, unix.NLMSG_HDRLEN+len(payload))
+	b = append(b, payload...)
+	nativeEndian.PutUint32(b, uint32(len(b)))
+	nativeEndian.PutUint16(b[4:], typ)
+	return b
+}
+
+func TestParseSockDiagReply(t *testing.T) {
+	const nested = 1 << 15 // NLA_F_NESTED
+	tlsInfo := append(nlattr(1, u16(0x0304)), nlattr(tlsInfoTXConf, u16(2))...)
+	tlsInfo = append(tlsInfo, nlattr(tlsInfoRXConf, u16(3))...)
+	ulp := append(nlattr(1, []byte("tls\x00")), nlattr(inetULPInfoTLS|nested, tlsInfo)...)
+	attrs := append(nlattr(inetDiagInfo, make([]byte, 8)), nlattr(inetDiagULPInfo|nested, ulp)...)
+	msg := append(make([]byte, inetDiagMsgLen), attrs...)
+
+	tx, rx, err := parseSockDiagReply(sockDiagReply(sockDiagByFamily, msg))
+	if err != nil || tx != KTLSOffloadSoftware || rx != KTLSOffloadDevice {
+		t.Errorf("got %v, %v, %v; want sw, hw", tx, rx, err)
+	}
+
+	tx, rx, err = parseSockDiagReply(sockDiagReply(sockDiagByFamily, make([]byte, inetDiagMsgLen)))
+	if err != nil || tx != KTLSOffloadNone || rx != KTLSOffloadNone {
+		t.Errorf("without ULP info: got %v, %v, %v; want none, none", tx, rx, err)
+	}
+
+	errMsg := make([]byte, 4)
+	errno := -int32(unix.ENOENT)
+	nativeEndian.PutUint32(errMsg, uint32(errno))
+	if _, _, err := parseSockDiagReply(sockDiagReply(unix.NLMSG_ERROR, errMsg)); err != unix.ENOENT {
+		t.Errorf("got %v, want ENOENT", err)
+	}
+	if _, _, err := parseSockDiagReply(sockDiagReply(sockDiagByFamily, make([]byte, 8))); err != errSockDiagReply {
+		t.Errorf("truncated reply: got %v, want %v", err, errSockDiagReply)
+	}
+}
+
+func TestSockDiagRequest(t *testing.T) {
+	local := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0x1234}
+	remote := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 443}
+	b := sockDiagRequest(local, remote)
+	if len(b) != unix.NLMSG_HDRLEN+inetDiagReqV2Len || nativeEndian.Uint32(b) != uint32(len(b)) {
+		t.Fatalf("bad request length %d", len(b))
+	}
+	req := b[unix.NLMSG_HDRLEN:]
+	if req[0] != unix.AF_INET || req[1] != unix.IPPROTO_TCP {
+		t.Errorf("family, protocol = %d, %d", req[0], req[1])
+	}
+	id := req[8:]
+	if id[0] != 0x12 || id[1] != 0x34 || id[2] != 0x01 || id[3] != 0xbb {
+		t.Errorf("bad ports % x", id[:4])
+	}
+	if !net.IP(id[4:8]).Equal(local.IP) || !net.IP(id[20:24]).Equal(remote.IP) {
+		t.Errorf("bad addresses % x", id[4:36])
+	}
+}
+
+func TestParseEthtoolTLSStats(t *testing.T) {
+	counters := []string{"rx_packets", "tx_tls_encrypted_packets", "rx_tls_resync_req_pkt"}
+	names := make([]byte, 12+len(counters)*ethGStringLen)
+	nativeEndian.PutUint32(names[8:], uint32(len(counters)))
+	values := make([]byte, 8+len(counters)*8)
+	nativeEndian.PutUint32(values[4:], uint32(len(counters)))
+	for i, name := range counters {
+		copy(names[12+i*ethGStringLen:], name)
+		nativeEndian.PutUint64(values[8+i*8:], uint64(i+1)*10)
+	}
+
+	stats, err := parseEthtoolTLSStats(names, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 || stats["tx_tls_encrypted_packets"] != 20 || stats["rx_tls_resync_req_pkt"] != 30 {
+		t.Errorf("got %v", stats)
+	}
+	if _, err := parseEthtoolTLSStats(names, values[:16]); err != errEthtoolReply {
+		t.Errorf("truncated stats: got %v, want %v", err, errEthtoolReply)
+	}
+}
+
+func TestParseEthtoolTLSFeatures(t *testing.T) {
+	// 40 features, spanning two blocks, with TLS features in both.
+	features := make([]string, 40)
+	for i := range features {
+		features[i] = fmt.Sprintf("feature-%d", i)
+	}
+	features[3] = "tls-hw-tx-offload"
+	features[35] = "tls-hw-rx-offload"
+	features[36] = "tls-hw-record"
+	names := make([]byte, 12+len(features)*ethGStringLen)
+	nativeEndian.PutUint32(names[8:], uint32(len(features)))
+	for i, name := range features {
+		copy(names[12+i*ethGStringLen:], name)
+	}
+	values := make([]byte, 8+2*16)
+	nativeEndian.PutUint32(values[4:], 2)
+	nativeEndian.PutUint32(values[8+8:], 1<<3)
+	nativeEndian.PutUint32(values[8+16+8:], 1<<(35-32))
+
+	got, err := parseEthtoolTLSFeatures(names, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"tls-hw-tx-offload": true, "tls-hw-rx-offload": true, "tls-hw-record": false}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := parseEthtoolTLSFeatures(names, values[:24]); err != errEthtoolReply {
+		t.Errorf("truncated features: got %v, want %v", err, errEthtoolReply)
+	}
+}
+
+func TestKTLSOffloadModes(t *testing.T) {
+	c, s := localPipe(t)
+	defer c.Close()
+	defer s.Close()
+
+	tx, rx, err := Client(c, testConfig).KTLSOffloadModes()
+	if err != nil {
+		t.Skipf("sock_diag unavailable: %v", err)
+	}
+	// The handshake has not run, so the "tls" ULP is not attached.
+	if tx != KTLSOffloadNone || rx != KTLSOffloadNone {
+		t.Errorf("got %v, %v; want none, none", tx, rx)
+	}
+}
+
+func TestParseEthtoolNetlinkTLSFeatures(t *testing.T) {
+	bit := func(name string) []byte {
+		return appendAttr(nil, unix.NLA_F_NESTED|unix.ETHTOOL_A_BITSET_BITS_BIT,
+			appendAttr(nil, unix.ETHTOOL_A_BITSET_BIT_NAME, append([]byte(name), 0)))
+	}
+	bitset := func(names ...string) []byte {
+		var bits []byte
+		for _, name := range names {
+			bits = append(bits, bit(name)...)
+		}
+		b := appendAttr(nil, unix.ETHTOOL_A_BITSET_NOMASK, nil)
+		return appendAttr(b, unix.NLA_F_NESTED|unix.ETHTOOL_A_BITSET_BITS, bits)
+	}
+	attrs := appendAttr(nil, unix.NLA_F_NESTED|unix.ETHTOOL_A_FEATURES_HW,
+		bitset("rx-checksum", "tls-hw-tx-offload", "tls-hw-rx-offload"))
+	attrs = appendAttr(attrs, unix.NLA_F_NESTED|unix.ETHTOOL_A_FEATURES_ACTIVE,
+		bitset("rx-checksum", "tls-hw-tx-offload", "tls-hw-record"))
+
+	got, err := parseEthtoolNetlinkTLSFeatures(attrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"tls-hw-tx-offload": true, "tls-hw-rx-offload": false, "tls-hw-record": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	bad := appendAttr(nil, unix.NLA_F_NESTED|unix.ETHTOOL_A_FEATURES_ACTIVE,
+		appendAttr(nil, unix.NLA_F_NESTED|unix.ETHTOOL_A_BITSET_BITS,
+			appendAttr(nil, unix.ETHTOOL_A_BITSET_BITS_BIT, nil)))
+	if _, err := parseEthtoolNetlinkTLSFeatures(bad); err != errEthtoolNetlinkReply {
+		t.Errorf("bit without a name: got %v, want %v", err, errEthtoolNetlinkReply)
+	}
+}
+
+func TestEthtoolNetlinkTLSFeatures(t *testing.T) {
+	features, err := ethtoolNetlinkTLSFeatures("lo")
+	if err != nil {
+		t.Skipf("ethtool netlink unavailable: %v", err)
+	}
+	if features["tls-hw-tx-offload"] || features["tls-hw-rx-offload"] {
+		t.Errorf("loopback offloads TLS: %v", features)
+	}
+}
+
+func TestKTLSDeviceOffload(t *testing.T) {
+	c, s := localPipe(t)
+	defer c.Close()
+	defer s.Close()
+
+	offload, err := Client(c, testConfig).KTLSDeviceOffload()
+	if err != nil {
+		t.Skipf("device offload unavailable: %v", err)
+	}
+	if offload.Interface == "" || offload.TX.isDevice() || offload.RX.isDevice() {
+		t.Errorf("got %+v, want a software or no offload on an interface", offload)
+	}
+}
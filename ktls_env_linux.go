@@ -0,0 +1,393 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	// kTLSUnsupportedReason explains why kTLSSupport is false, if it is
+	// because of the environment. See KTLSUnsupportedReason.
+	kTLSUnsupportedReason string
+
+	// ktlsProbePending is set at init so that probeKTLSFeatures runs
+	// before kernel TLS is first used. ktlsProbeRelease is the kernel
+	// release, used if the kernel can't be probed.
+	ktlsProbePending bool
+	ktlsProbeRelease string
+	// ktlsProbeMu guards probing the kernel and the kTLSSupport variables
+	// it sets. ktlsProbed is set once the kernel was probed, and
+	// ktlsProbeRetry is when to probe it again, if it lacked the tls
+	// module, or zero.
+	ktlsProbeMu    sync.Mutex
+	ktlsProbed     bool
+	ktlsProbeRetry time.Time
+	// ktlsProbeFallback is set if the kernel could not be probed and its
+	// features were derived from ktlsProbeRelease.
+	ktlsProbeFallback bool
+
+	// ktlsLost holds why kernel TLS stopped working after it was found
+	// usable, such as the tls module being unloaded, or nil while it works.
+	// ktlsLostProbe is when the kernel was last probed since, in Unix
+	// nanoseconds.
+	ktlsLost      atomic.Pointer[string]
+	ktlsLostProbe atomic.Int64
+
+	// ktlsULPProbe is probeTLSULP, replaced by tests.
+	ktlsULPProbe = probeTLSULP
+)
+
+// ktlsReprobeInterval is how often the kernel is probed while kernel TLS
+// is lost, to use it again once the tls module is back.
+const ktlsReprobeInterval = 30 * time.Second
+
+// KTLSUnsupportedReason returns why kernel TLS is unavailable in this
+// environment, such as a WSL1 kernel, a missing tls module or
+// SetKTLSEnabled(false), or the empty string if it can be used. Unless
+// kernel TLS was already used, it probes the kernel the first time it is
+// called.
+func KTLSUnsupportedReason() string {
+	if reason := ktlsDisabledReason(); reason != "" {
+		return reason
+	}
+	_, unsupported := ktlsProbe()
+	if reason := ktlsLostReason(); reason != "" {
+		return reason
+	}
+	return unsupported
+}
+
+// RefreshKTLSSupport probes the kernel again if kernel TLS is unsupported
+// or was lost, so that a tls module loaded or a capability granted since is
+// used right away rather than within 30 seconds, and returns
+// KTLSUnsupportedReason.
+func RefreshKTLSSupport() string {
+	ktlsProbeMu.Lock()
+	if ktlsProbePending && (!ktlsProbed || !kTLSSupport) {
+		ktlsReprobeLocked()
+	}
+	ktlsProbeMu.Unlock()
+	if reason := ktlsLost.Load(); reason != nil && ktlsULPProbe() == nil {
+		ktlsLostProbe.Store(time.Now().UnixNano())
+		if ktlsLost.CompareAndSwap(reason, nil) {
+			Debugln("kTLS: kernel tls module available again")
+		}
+	}
+	return KTLSUnsupportedReason()
+}
+
+// ktlsProbe probes the kernel the first time it is called and, while the
+// kernel lacks the tls module, again every ktlsReprobeInterval. It returns
+// whether the kernel supports kernel TLS and, if not, why.
+func ktlsProbe() (supported bool, reason string) {
+	ktlsProbeMu.Lock()
+	defer ktlsProbeMu.Unlock()
+	if ktlsProbePending && (!ktlsProbed ||
+		!ktlsProbeRetry.IsZero() && !time.Now().Before(ktlsProbeRetry)) {
+		ktlsReprobeLocked()
+	}
+	return kTLSSupport, kTLSUnsupportedReason
+}
+
+// ktlsReprobeLocked discards the result of the previous probe, if any, and
+// probes the kernel. ktlsProbeMu must be held.
+func ktlsReprobeLocked() {
+	kTLSSupport, kTLSUnsupportedReason = true, ""
+	ktlsProbeFallback, ktlsProbeRetry = false, time.Time{}
+	probeKTLSFeatures()
+	ktlsProbed = true
+}
+
+// CurrentKTLSFeatures returns what kernel TLS can currently offload. All
+// features are false while kernel TLS is unsupported or lost, see
+// KTLSUnsupportedReason.
+func CurrentKTLSFeatures() KTLSFeatures {
+	if KTLSUnsupportedReason() != "" {
+		return KTLSFeatures{}
+	}
+	return probedKTLSFeatures()
+}
+
+// probedKTLSFeatures returns the features the kernel accepted when probed.
+func probedKTLSFeatures() KTLSFeatures {
+	return KTLSFeatures{
+		TX:               kTLSSupportTX,
+		RX:               kTLSSupportRX,
+		TLS13TX:          kTLSSupportTLS13TX,
+		TLS13RX:          kTLSSupportTLS13RX,
+		AESGCM128:        kTLSSupportAESGCM128,
+		AESGCM256:        kTLSSupportAESGCM256,
+		AESCCM128:        kTLSSupportAESCCM128,
+		SM4:              kTLSSupportSM4,
+		ARIAGCM:          kTLSSupportARIAGCM,
+		ChaCha20Poly1305: kTLSSupportCHACHA20POLY1305,
+		TXZerocopy:       kTLSSupportZEROCOPY,
+		RXNoPad:          kTLSSupportNOPAD,
+		KeyUpdate:        kTLSSupportKeyUpdate,
+	}
+}
+
+// KTLSCapabilities returns the kernel TLS support of the host, probing the
+// kernel if it wasn't yet, so that operators can log and alert on it.
+func KTLSCapabilities() KTLSHostCapabilities {
+	caps := KTLSHostCapabilities{UnsupportedReason: KTLSUnsupportedReason()}
+	caps.Kernel, _ = kernelRelease()
+	if supported, _ := ktlsProbe(); !supported {
+		return caps
+	}
+	caps.FromKernelVersion = ktlsProbeFallback
+	caps.KTLSFeatures = probedKTLSFeatures()
+	return caps
+}
+
+// ktlsCheckLost is called when programming kernel TLS failed with err. If
+// err suggests that the tls upper layer protocol is missing and probing
+// the kernel confirms it, kernel TLS is marked as lost, hooks registered
+// with NotifyKTLSUnavailable are called the first time, and the reason is
+// returned. Otherwise it returns "".
+func ktlsCheckLost(err error) string {
+	if !errors.Is(err, unix.ENOPROTOOPT) && !errors.Is(err, unix.ENOENT) {
+		return ""
+	}
+	probeErr := ktlsULPProbe()
+	if probeErr == nil {
+		return ""
+	}
+	reason := "kernel tls module unloaded: " + probeErr.Error()
+	ktlsLostProbe.Store(time.Now().UnixNano())
+	if !ktlsLost.CompareAndSwap(nil, &reason) {
+		return *ktlsLost.Load()
+	}
+	Debugln("kTLS: environment unsupported:", reason)
+	notifyKTLSUnavailable(reason)
+	return reason
+}
+
+// ktlsLostReason returns why kernel TLS was lost, or "" if it was not.
+// While it is lost, it probes the kernel every ktlsReprobeInterval and
+// clears the loss once the tls module is back.
+func ktlsLostReason() string {
+	reason := ktlsLost.Load()
+	if reason == nil {
+		return ""
+	}
+	last, now := ktlsLostProbe.Load(), time.Now().UnixNano()
+	if now-last >= int64(ktlsReprobeInterval) && ktlsLostProbe.CompareAndSwap(last, now) {
+		if ktlsULPProbe() == nil && ktlsLost.CompareAndSwap(reason, nil) {
+			Debugln("kTLS: kernel tls module available again")
+			return ""
+		}
+	}
+	return *reason
+}
+
+func ktlsUnsupported(reason string) {
+	Debugln("kTLS: environment unsupported:", reason)
+	kTLSSupport = false
+	kTLSUnsupportedReason = reason
+}
+
+// kernelRelease returns the release of the running kernel, as uname -r.
+func kernelRelease() (string, error) {
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err != nil {
+		return "", err
+	}
+	return unix.ByteSliceToString(uname.Release[:]), nil
+}
+
+// parseKernelRelease returns the major and minor version of a kernel
+// release such as "6.1.0-13-amd64" or "5.15.90.1-microsoft-standard-WSL2".
+func parseKernelRelease(release string) (major, minor int, ok bool) {
+	majorStr, rest, ok := strings.Cut(release, ".")
+	if !ok {
+		return 0, 0, false
+	}
+	end := 0
+	for end < len(rest) && rest[end] >= '0' && rest[end] <= '9' {
+		end++
+	}
+	major, err := strconv.Atoi(majorStr)
+	if err != nil {
+		return 0, 0, false
+	}
+	minor, err = strconv.Atoi(rest[:end])
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+// kernelEnvironment recognizes kernels whose version doesn't describe
+// their kernel TLS support. It returns the name of the environment, or ""
+// for a regular kernel, and false if it can't support kernel TLS at all.
+func kernelEnvironment(release string) (env string, supported bool) {
+	switch {
+	case strings.Contains(release, "Microsoft"):
+		// WSL1 translates system calls and reports a fixed 4.4.0 release.
+		return "WSL1", false
+	case strings.Contains(release, "microsoft"), strings.Contains(release, "WSL"):
+		return "WSL2", true
+	}
+	return "", true
+}
+
+// probeTLSULP checks that the kernel has the "tls" upper layer protocol
+// without establishing a connection: attaching it to a socket that is not
+// connected fails with ENOTCONN if it exists and ENOENT otherwise. The
+// attempt autoloads the tls module when it is not loaded yet, but only for
+// processes with CAP_NET_ADMIN.
+func probeTLSULP() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	err = unix.SetsockoptString(fd, unix.SOL_TCP, TCP_ULP, "tls")
+	if err == nil || errors.Is(err, unix.ENOTCONN) {
+		return nil
+	}
+	return err
+}
+
+// tcpAvailableULPPath lists the upper layer protocols the kernel has
+// loaded. Tests replace it.
+var tcpAvailableULPPath = "/proc/sys/net/ipv4/tcp_available_ulp"
+
+// tlsULPUnavailableReason explains err, returned by probeTLSULP. If the tls
+// module is not loaded and could not be autoloaded, which the kernel only
+// does for processes with CAP_NET_ADMIN, it says so.
+func tlsULPUnavailableReason(err error) string {
+	reason := "kernel tls module not available: " + err.Error()
+	if !errors.Is(err, unix.ENOENT) {
+		return reason
+	}
+	b, rerr := os.ReadFile(tcpAvailableULPPath)
+	if rerr != nil {
+		return reason
+	}
+	ulps := strings.Fields(string(b))
+	for _, ulp := range ulps {
+		if ulp == "tls" {
+			return reason
+		}
+	}
+	return reason + " (not in tcp_available_ulp [" + strings.Join(ulps, " ") +
+		"], load it with modprobe tls: autoloading needs CAP_NET_ADMIN)"
+}
+
+// probeKTLSFeatures sets the kernel TLS features by programming them on
+// loopback connections, rather than deriving them from the kernel version,
+// which vendor kernels with backports make unreliable. If loopback
+// connections can't be made, it falls back to the kernel version. If the
+// kernel lacks the tls module, it is probed again after
+// ktlsReprobeInterval, in case the module was loaded since.
+func probeKTLSFeatures() {
+	if err := ktlsULPProbe(); err != nil {
+		ktlsUnsupported(tlsULPUnavailableReason(err))
+		ktlsProbeRetry = time.Now().Add(ktlsReprobeInterval)
+		return
+	}
+	iv4, iv12 := make([]byte, 4), make([]byte, 12)
+	tx, err := probeKTLS(ktlsEnableAES128GCM, VersionTLS12, TLS_TX, 16, iv4, 0)
+	if err != nil {
+		Debugf("kTLS: probe failed: %v, using the kernel version", err)
+		major, minor, ok := parseKernelRelease(ktlsProbeRelease)
+		if !ok {
+			ktlsUnsupported("probe failed: " + err.Error())
+			ktlsProbeRetry = time.Now().Add(ktlsReprobeInterval)
+			return
+		}
+		ktlsProbeFallback = true
+		setKTLSFeatures(major, minor)
+		debugKTLSFeatures()
+		if !kTLSSupportTX {
+			ktlsUnsupported("kernel " + ktlsProbeRelease + " predates kernel TLS")
+		}
+		return
+	}
+	if !tx {
+		ktlsUnsupported("kernel rejected TLS_TX")
+		return
+	}
+	probe := func(enable func(c syscall.Conn, version uint16, opt int, skip, compat bool, key, iv, seq []byte) error,
+		version uint16, opt, keyLen int, iv []byte, extra int) bool {
+		ok, _ := probeKTLS(enable, version, opt, keyLen, iv, extra)
+		return ok
+	}
+	kTLSSupportTX = true
+	kTLSSupportAESGCM128 = true
+	kTLSSupportRX = probe(ktlsEnableAES128GCM, VersionTLS12, TLS_RX, 16, iv4, 0)
+	kTLSSupportAESGCM256 = probe(ktlsEnableAES256GCM, VersionTLS12, TLS_TX, 32, iv4, 0)
+	kTLSSupportAESCCM128 = probe(ktlsEnableAES128CCM, VersionTLS13, TLS_TX, 16, iv12, 0)
+	kTLSSupportSM4 = probe(ktlsEnableSM4GCM, VersionTLS13, TLS_TX, 16, iv12, 0)
+	kTLSSupportARIAGCM = probe(ktlsEnableARIA128GCM, VersionTLS12, TLS_TX, 16, iv4, 0)
+	kTLSSupportCHACHA20POLY1305 = probe(ktlsEnableCHACHA20POLY1305, VersionTLS12, TLS_TX, 32, iv12, 0)
+	kTLSSupportTLS13TX = probe(ktlsEnableAES128GCM, VersionTLS13, TLS_TX, 16, iv12, 0)
+	major, minor, ok := parseKernelRelease(ktlsProbeRelease)
+	kTLSSupportTLS13RX = ok && ktlsTLS13RXKernel(major, minor) &&
+		probe(ktlsEnableAES128GCM, VersionTLS13, TLS_RX, 16, iv12, 0)
+	kTLSSupportZEROCOPY = probe(ktlsEnableAES128GCM, VersionTLS12, TLS_TX, 16, iv4, TLS_TX_ZEROCOPY_RO)
+	kTLSSupportNOPAD = kTLSSupportTLS13RX &&
+		probe(ktlsEnableAES128GCM, VersionTLS13, TLS_RX, 16, iv12, TLS_RX_EXPECT_NO_PAD)
+	kTLSSupportKeyUpdate = kTLSSupportTLS13TX &&
+		probe(ktlsEnableAES128GCM, VersionTLS13, TLS_TX, 16, iv12, probeRekey)
+	debugKTLSFeatures()
+}
+
+// probeRekey, passed as the extra option of probeKTLS, probes programming
+// opt a second time, as done to install the keys of a TLS 1.3 KeyUpdate.
+const probeRekey = -1
+
+// probeKTLS reports whether opt can be programmed with the given cipher on
+// a fresh loopback connection and, if extra is not zero, whether the
+// SOL_TLS option extra can then be turned on, or opt programmed again if
+// extra is probeRekey. It only returns an error if the loopback connection
+// can't be made.
+func probeKTLS(enable func(c syscall.Conn, version uint16, opt int, skip, compat bool, key, iv, seq []byte) error,
+	version uint16, opt, keyLen int, iv []byte, extra int) (bool, error) {
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return false, err
+	}
+	defer ln.Close()
+	conn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if peer, err := ln.AcceptTCP(); err == nil {
+		peer.Close()
+	}
+	err = enable(conn, version, opt, false, false, make([]byte, keyLen), iv, make([]byte, 8))
+	if err == nil && extra == probeRekey {
+		key := make([]byte, keyLen)
+		key[0] = 1
+		err = enable(conn, version, opt, true, false, key, iv, make([]byte, 8))
+	} else if err == nil && extra != 0 {
+		var rc syscall.RawConn
+		if rc, err = conn.SyscallConn(); err == nil {
+			var err0 error
+			err = rc.Control(func(fd uintptr) {
+				err0 = unix.SetsockoptInt(int(fd), SOL_TLS, extra, 1)
+			})
+			if err == nil {
+				err = err0
+			}
+		}
+	}
+	Debugf("kTLS: probe version %x opt %d extra %d: %v", version, opt, extra, err)
+	return err == nil, nil
+}
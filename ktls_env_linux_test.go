@@ -0,0 +1,471 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseKernelRelease(t *testing.T) {
+	tests := []struct {
+		release      string
+		major, minor int
+		ok           bool
+	}{
+		{"6.1.0-13-amd64", 6, 1, true},
+		{"6.6.87.2-microsoft-standard-WSL2", 6, 6, true},
+		{"5.15.90.1-microsoft-standard-WSL2", 5, 15, true},
+		{"4.4.0-19041-Microsoft", 4, 4, true},
+		{"5.10.0", 5, 10, true},
+		{"6.8", 6, 8, true},
+		{"6.8-rc1", 6, 8, true},
+		{"6", 0, 0, false},
+		{"v6.1", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, tt := range tests {
+		major, minor, ok := parseKernelRelease(tt.release)
+		if major != tt.major || minor != tt.minor || ok != tt.ok {
+			t.Errorf("parseKernelRelease(%q) = %d, %d, %v; want %d, %d, %v",
+				tt.release, major, minor, ok, tt.major, tt.minor, tt.ok)
+		}
+	}
+}
+
+// TestKTLSTLS13RXKernel checks that TLS 1.3 RX stays off on the kernels
+// that lose records, even where the probe succeeds.
+func TestKTLSTLS13RXKernel(t *testing.T) {
+	for _, tt := range []struct {
+		major, minor int
+		want         bool
+	}{
+		{5, 10, false},
+		{5, 15, false},
+		{5, 19, false},
+		{6, 0, true},
+		{6, 8, true},
+	} {
+		if got := ktlsTLS13RXKernel(tt.major, tt.minor); got != tt.want {
+			t.Errorf("ktlsTLS13RXKernel(%d, %d) = %v, want %v", tt.major, tt.minor, got, tt.want)
+		}
+	}
+}
+
+func TestKernelEnvironment(t *testing.T) {
+	tests := []struct {
+		release   string
+		env       string
+		supported bool
+	}{
+		{"6.1.0-13-amd64", "", true},
+		{"5.15.90.1-microsoft-standard-WSL2", "WSL2", true},
+		{"6.6.87.2-microsoft-standard-WSL2+", "WSL2", true},
+		{"4.4.0-19041-Microsoft", "WSL1", false},
+	}
+	for _, tt := range tests {
+		env, supported := kernelEnvironment(tt.release)
+		if env != tt.env || supported != tt.supported {
+			t.Errorf("kernelEnvironment(%q) = %q, %v; want %q, %v",
+				tt.release, env, supported, tt.env, tt.supported)
+		}
+	}
+}
+
+func TestKTLSUnsupportedReason(t *testing.T) {
+	if kTLSSupport != (KTLSUnsupportedReason() == "") {
+		t.Errorf("kTLSSupport = %v with reason %q", kTLSSupport, KTLSUnsupportedReason())
+	}
+	caps := KTLSCapabilities()
+	if caps.Kernel == "" || caps.UnsupportedReason != KTLSUnsupportedReason() {
+		t.Errorf("KTLSCapabilities() = %+v", caps)
+	}
+	if caps.UnsupportedReason == "" && !caps.TX {
+		t.Errorf("kernel TLS supported without TX: %+v", caps)
+	}
+	// The probe must fail gracefully wherever the tests run.
+	probeKTLS(ktlsEnableAES128GCM, VersionTLS12, TLS_TX, 16, make([]byte, 4), 0)
+}
+
+func TestTLSULPUnavailableReason(t *testing.T) {
+	defer func(p string) { tcpAvailableULPPath = p }(tcpAvailableULPPath)
+	tcpAvailableULPPath = filepath.Join(t.TempDir(), "tcp_available_ulp")
+
+	const base = "kernel tls module not available: "
+	if got := tlsULPUnavailableReason(unix.ENOENT); got != base+unix.ENOENT.Error() {
+		t.Errorf("without tcp_available_ulp: %q", got)
+	}
+	if err := os.WriteFile(tcpAvailableULPPath, []byte("espintcp mptcp\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got := tlsULPUnavailableReason(unix.ENOENT)
+	if !strings.HasPrefix(got, base) || !strings.Contains(got, "[espintcp mptcp]") || !strings.Contains(got, "modprobe tls") {
+		t.Errorf("tls missing from tcp_available_ulp: %q", got)
+	}
+	if got := tlsULPUnavailableReason(unix.EPERM); got != base+unix.EPERM.Error() {
+		t.Errorf("EPERM: %q", got)
+	}
+	if err := os.WriteFile(tcpAvailableULPPath, []byte("tls mptcp\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := tlsULPUnavailableReason(unix.ENOENT); got != base+unix.ENOENT.Error() {
+		t.Errorf("tls in tcp_available_ulp: %q", got)
+	}
+}
+
+func TestRefreshKTLSSupport(t *testing.T) {
+	KTLSUnsupportedReason()
+	ktlsProbeMu.Lock()
+	defer func(support bool, reason string, pending, probed bool, retry time.Time, probe func() error) {
+		kTLSSupport, kTLSUnsupportedReason = support, reason
+		ktlsProbePending, ktlsProbed, ktlsProbeRetry = pending, probed, retry
+		ktlsULPProbe = probe
+	}(kTLSSupport, kTLSUnsupportedReason, ktlsProbePending, ktlsProbed, ktlsProbeRetry, ktlsULPProbe)
+	probes := 0
+	ktlsULPProbe = func() error {
+		probes++
+		return unix.ENOENT
+	}
+	ktlsProbePending, ktlsProbed = true, false
+	ktlsProbeMu.Unlock()
+
+	if reason := KTLSUnsupportedReason(); !strings.HasPrefix(reason, "kernel tls module not available") || probes != 1 {
+		t.Fatalf("first use: reason %q after %d probes", reason, probes)
+	}
+	// The result is cached until ktlsReprobeInterval passes.
+	if supported, _ := ktlsProbe(); supported || probes != 1 {
+		t.Errorf("cached: supported %v after %d probes", supported, probes)
+	}
+	if reason := RefreshKTLSSupport(); reason == "" || probes != 2 {
+		t.Errorf("RefreshKTLSSupport: reason %q after %d probes", reason, probes)
+	}
+	ktlsProbeMu.Lock()
+	ktlsProbeRetry = time.Now().Add(-time.Second)
+	ktlsProbeMu.Unlock()
+	if ktlsProbe(); probes != 3 {
+		t.Errorf("expired: %d probes", probes)
+	}
+}
+
+func TestProbeKTLS(t *testing.T) {
+	accept := func(c syscall.Conn, version uint16, opt int, skip, compat bool, key, iv, seq []byte) error {
+		return nil
+	}
+	reject := func(c syscall.Conn, version uint16, opt int, skip, compat bool, key, iv, seq []byte) error {
+		return unix.ENOPROTOOPT
+	}
+	iv := make([]byte, 4)
+	if ok, err := probeKTLS(accept, VersionTLS12, TLS_TX, 16, iv, 0); !ok || err != nil {
+		t.Errorf("accepted option: got %v, %v; want true, nil", ok, err)
+	}
+	if ok, err := probeKTLS(reject, VersionTLS12, TLS_TX, 16, iv, 0); ok || err != nil {
+		t.Errorf("rejected option: got %v, %v; want false, nil", ok, err)
+	}
+	// Without the tls ULP attached by enable, SOL_TLS options fail, as
+	// on kernels without TLS_TX_ZEROCOPY_RO.
+	if ok, err := probeKTLS(accept, VersionTLS12, TLS_TX, 16, iv, TLS_TX_ZEROCOPY_RO); ok || err != nil {
+		t.Errorf("rejected extra option: got %v, %v; want false, nil", ok, err)
+	}
+	// Kernels before 6.14 refuse to program a direction twice.
+	once := func(c syscall.Conn, version uint16, opt int, skip, compat bool, key, iv, seq []byte) error {
+		if skip {
+			return unix.EBUSY
+		}
+		return nil
+	}
+	iv = make([]byte, 12)
+	if ok, err := probeKTLS(accept, VersionTLS13, TLS_TX, 16, iv, probeRekey); !ok || err != nil {
+		t.Errorf("accepted rekey: got %v, %v; want true, nil", ok, err)
+	}
+	if ok, err := probeKTLS(once, VersionTLS13, TLS_TX, 16, iv, probeRekey); ok || err != nil {
+		t.Errorf("rejected rekey: got %v, %v; want false, nil", ok, err)
+	}
+}
+
+func TestKTLSCheckKeyUpdate(t *testing.T) {
+	defer func(supported bool) { kTLSSupportKeyUpdate = supported }(kTLSSupportKeyUpdate)
+
+	c := &Conn{}
+	c.out.cipher = kTLSCipher{}
+	kTLSSupportKeyUpdate = false
+	if err := c.ktlsCheckKeyUpdate(false); err != nil {
+		t.Errorf("TX offloaded, no update requested: %v", err)
+	}
+	if err := c.ktlsCheckKeyUpdate(true); err == nil {
+		t.Error("TX offloaded, update requested: no error without kernel support")
+	}
+	c.in.cipher = kTLSCipher{}
+	if err := c.ktlsCheckKeyUpdate(false); err == nil {
+		t.Error("RX offloaded: no error without kernel support")
+	}
+	kTLSSupportKeyUpdate = true
+	if err := c.ktlsCheckKeyUpdate(true); err != nil {
+		t.Errorf("with kernel support: %v", err)
+	}
+}
+
+func TestKTLSLost(t *testing.T) {
+	defer func(probe func() error) {
+		ktlsULPProbe = probe
+		ktlsLost.Store(nil)
+		ktlsLostProbe.Store(0)
+	}(ktlsULPProbe)
+	var notified []string
+	NotifyKTLSUnavailable(func(reason string) { notified = append(notified, reason) })
+	defer func() { ktlsUnavailableHooks.fns = nil }()
+
+	ktlsULPProbe = func() error { return unix.ENOENT }
+	if reason := ktlsCheckLost(errors.New("unrelated")); reason != "" {
+		t.Errorf("unrelated error marked kernel TLS as lost: %q", reason)
+	}
+	err := &KTLSSyscallError{Syscall: "setsockopt(TLS_TX)", Err: unix.ENOPROTOOPT}
+	reason := ktlsCheckLost(err)
+	if reason == "" || ktlsCheckLost(err) != reason {
+		t.Fatalf("got reason %q", reason)
+	}
+	if len(notified) != 1 || notified[0] != reason {
+		t.Errorf("hooks called with %q, want %q once", notified, reason)
+	}
+	if got := KTLSUnsupportedReason(); got != reason {
+		t.Errorf("KTLSUnsupportedReason() = %q, want %q", got, reason)
+	}
+	if f := CurrentKTLSFeatures(); f != (KTLSFeatures{}) {
+		t.Errorf("features %+v reported while kernel TLS is lost", f)
+	}
+
+	// The module comes back: the next probe clears the loss.
+	ktlsULPProbe = func() error { return nil }
+	if ktlsLostReason() != reason {
+		t.Error("kernel probed again before ktlsReprobeInterval")
+	}
+	ktlsLostProbe.Store(0)
+	if got := ktlsLostReason(); got != "" {
+		t.Errorf("still lost after a successful probe: %q", got)
+	}
+}
+
+func TestKTLSSkipReasons(t *testing.T) {
+	defer func(tx, rx, rx13 bool) {
+		kTLSSupportTX, kTLSSupportRX, kTLSSupportTLS13RX = tx, rx, rx13
+	}(kTLSSupportTX, kTLSSupportRX, kTLSSupportTLS13RX)
+	kc := ktlsCipher{version: VersionTLS13, keyLen: 16, enable: ktlsEnableAES128GCM}
+	key, iv := make([]byte, 16), make([]byte, 12)
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	c := Client(c1, testConfig.Clone())
+	var cipher any
+	kTLSSupportTX, kTLSSupportRX, kTLSSupportTLS13RX = false, true, false
+	if err := c.ktlsEnableTX(kc, key, iv, &cipher); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ktlsEnableRX(kc, key, iv, &cipher); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.ktlsState.TXReason, "kernel does not support TLS_TX"; got != want {
+		t.Errorf("TXReason = %q, want %q", got, want)
+	}
+	if got, want := c.ktlsState.RXReason, "kernel does not support TLS 1.3 TLS_RX"; got != want {
+		t.Errorf("RXReason = %q, want %q", got, want)
+	}
+
+	kTLSSupportTX = true
+	if err := c.ktlsEnableTX(kc, key, iv, &cipher); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.ktlsState.TXReason, "unsupported connection type *net.pipe"; got != want {
+		t.Errorf("TXReason = %q, want %q", got, want)
+	}
+	if c.ktlsState.TXEnabled || cipher != nil {
+		t.Error("TX offload enabled on a pipe")
+	}
+}
+
+func TestKTLSDirectionDisabled(t *testing.T) {
+	kc := ktlsCipher{version: VersionTLS12, keyLen: 16, enable: ktlsEnableAES128GCM}
+	key, iv := make([]byte, 16), make([]byte, 4)
+
+	ln := newLocalListener(t)
+	defer ln.Close()
+	tcpConn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpConn.Close()
+
+	config := testConfig.Clone()
+	config.KTLSTXDisabled = true
+	config.KTLSRXDisabled = true
+	c := Client(tcpConn, config)
+	var txCipher, rxCipher any
+	if err := c.ktlsEnableTX(kc, key, iv, &txCipher); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ktlsEnableRX(kc, key, iv, &rxCipher); err != nil {
+		t.Fatal(err)
+	}
+	if txCipher != nil || rxCipher != nil || c.ktlsState.TXEnabled || c.ktlsState.RXEnabled {
+		t.Fatal("offload enabled for a disabled direction")
+	}
+	if got, want := c.ktlsState.TXReason, "disabled by Config.KTLSTXDisabled"; got != want {
+		t.Errorf("TXReason = %q, want %q", got, want)
+	}
+	if got, want := c.ktlsState.RXReason, "disabled by Config.KTLSRXDisabled"; got != want {
+		t.Errorf("RXReason = %q, want %q", got, want)
+	}
+}
+
+func TestSetKTLSEnabled(t *testing.T) {
+	defer ktlsDisabled.Store(ktlsDisabled.Load())
+	SetKTLSEnabled(false)
+	const want = "disabled by SetKTLSEnabled"
+	if got := KTLSUnsupportedReason(); got != want {
+		t.Errorf("KTLSUnsupportedReason() = %q, want %q", got, want)
+	}
+	if f := CurrentKTLSFeatures(); f != (KTLSFeatures{}) {
+		t.Errorf("CurrentKTLSFeatures() = %+v while disabled", f)
+	}
+	c := &Conn{config: testConfig.Clone()}
+	if err := c.enableKernelTLS(TLS_AES_128_GCM_SHA256, nil, nil, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if c.ktlsState.TXReason != want || c.ktlsState.RXReason != want {
+		t.Errorf("reasons = %q, %q, want %q", c.ktlsState.TXReason, c.ktlsState.RXReason, want)
+	}
+
+	SetKTLSEnabled(true)
+	if got := KTLSUnsupportedReason(); got == want {
+		t.Errorf("KTLSUnsupportedReason() = %q after SetKTLSEnabled(true)", got)
+	}
+}
+
+func TestKTLSMode(t *testing.T) {
+	config := testConfig.Clone()
+	config.KTLSMode = KTLSModeOff
+	c := &Conn{config: config}
+	if err := c.enableKernelTLS(TLS_AES_128_GCM_SHA256, nil, nil, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	const want = "disabled by Config.KTLSMode"
+	if c.ktlsState.TXReason != want || c.ktlsState.RXReason != want {
+		t.Errorf("reasons = %q, %q, want %q", c.ktlsState.TXReason, c.ktlsState.RXReason, want)
+	}
+
+	defer ktlsDisabled.Store(ktlsDisabled.Load())
+	SetKTLSEnabled(false)
+	config = testConfig.Clone()
+	config.KTLSMode = KTLSModeRequire
+	c = &Conn{config: config}
+	err := c.enableKernelTLS(TLS_AES_128_GCM_SHA256, nil, nil, nil, nil, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "disabled by SetKTLSEnabled") {
+		t.Errorf("enableKernelTLS with KTLSModeRequire while disabled = %v", err)
+	}
+
+	config.KTLSTXDisabled = true
+	config.KTLSRXDisabled = true
+	c = &Conn{config: config}
+	if err := c.enableKernelTLS(TLS_AES_128_GCM_SHA256, nil, nil, nil, nil, nil, nil); err != nil {
+		t.Errorf("enableKernelTLS with both directions disabled = %v", err)
+	}
+}
+
+func TestKTLSProtocolModes(t *testing.T) {
+	config := testConfig.Clone()
+	config.KTLSMode = KTLSModeRequire
+	config.KTLSProtocolModes = map[string]KTLSMode{"h2": KTLSModeOff}
+	for _, tt := range []struct {
+		proto, reason string
+	}{
+		{"h2", `disabled by Config.KTLSProtocolModes["h2"]`},
+		{"acme-tls/1", "disabled by ALPN protocol acme-tls/1"},
+	} {
+		c := &Conn{config: config, clientProtocol: tt.proto}
+		if err := c.enableKernelTLS(TLS_AES_128_GCM_SHA256, nil, nil, nil, nil, nil, nil); err != nil {
+			t.Fatalf("%s: %v", tt.proto, err)
+		}
+		if c.ktlsState.TXReason != tt.reason || c.ktlsState.RXReason != tt.reason {
+			t.Errorf("%s: reasons = %q, %q, want %q", tt.proto, c.ktlsState.TXReason, c.ktlsState.RXReason, tt.reason)
+		}
+	}
+
+	defer ktlsDisabled.Store(ktlsDisabled.Load())
+	SetKTLSEnabled(false)
+	c := &Conn{config: config, clientProtocol: "http/1.1"}
+	if err := c.enableKernelTLS(TLS_AES_128_GCM_SHA256, nil, nil, nil, nil, nil, nil); err == nil {
+		t.Error("unlisted protocol did not fall back to KTLSModeRequire")
+	}
+}
+
+func TestKTLSCipherSuites(t *testing.T) {
+	config := testConfig.Clone()
+	if !config.ktlsAllowsSuite(TLS_CHACHA20_POLY1305_SHA256) {
+		t.Error("suite not offloaded without KTLSCipherSuites")
+	}
+	config.KTLSCipherSuites = []uint16{TLS_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}
+	if !config.ktlsAllowsSuite(TLS_AES_128_GCM_SHA256) {
+		t.Error("listed suite not offloaded")
+	}
+
+	c := &Conn{config: config}
+	if err := c.enableKernelTLS(TLS_CHACHA20_POLY1305_SHA256, nil, nil, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if c.ktlsState.TXEnabled || c.ktlsState.RXEnabled {
+		t.Fatal("offload enabled for an unlisted suite")
+	}
+	want := "cipher suite TLS_CHACHA20_POLY1305_SHA256 not in Config.KTLSCipherSuites"
+	if c.ktlsState.TXReason != want || c.ktlsState.RXReason != want {
+		t.Errorf("reasons = %q, %q, want %q", c.ktlsState.TXReason, c.ktlsState.RXReason, want)
+	}
+}
+
+func TestKTLSZerocopySendfileMode(t *testing.T) {
+	defer func(v bool) { kTLSSupportZEROCOPY = v }(kTLSSupportZEROCOPY)
+
+	ln := newLocalListener(t)
+	defer ln.Close()
+	tcpConn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpConn.Close()
+
+	// Without the tls ULP, setting TLS_TX_ZEROCOPY_RO always fails.
+	for _, supported := range []bool{false, true} {
+		kTLSSupportZEROCOPY = supported
+		for _, tt := range []struct {
+			mode    KTLSZerocopySendfileMode
+			wantErr bool
+		}{
+			{KTLSZerocopySendfileAuto, false},
+			{KTLSZerocopySendfileDisabled, false},
+			{KTLSZerocopySendfileRequired, true},
+		} {
+			config := testConfig.Clone()
+			config.KTLSZerocopySendfile = tt.mode
+			c := &Conn{conn: tcpConn, config: config}
+			c.ktlsState.TXZerocopy = true
+			err := c.ktlsEnableTXZerocopySendfile(tcpConn)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("supported=%v mode=%d: err = %v, want error %v", supported, tt.mode, err, tt.wantErr)
+			}
+			var serr *KTLSSyscallError
+			if supported && tt.wantErr && !errors.As(err, &serr) {
+				t.Errorf("supported=%v mode=%d: err = %v, want a KTLSSyscallError", supported, tt.mode, err)
+			}
+			if c.ktlsState.TXZerocopy {
+				t.Errorf("supported=%v mode=%d: TXZerocopy set", supported, tt.mode)
+			}
+		}
+	}
+}
@@ -0,0 +1,194 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+var errEthtoolNetlinkReply = errors.New("tls: malformed ethtool netlink reply")
+
+// tlsDeviceFeatures returns the ethtool features of iface related to TLS,
+// such as tls-hw-tx-offload and tls-hw-rx-offload, and whether they are
+// active. It asks ethtool netlink, which omits the features the driver
+// does not offer, and falls back to the SIOCETHTOOL ioctl on kernels older
+// than Linux 5.6, which lack it.
+func tlsDeviceFeatures(iface string) (map[string]bool, error) {
+	features, err := ethtoolNetlinkTLSFeatures(iface)
+	if err == unix.ENOENT || err == unix.EPROTONOSUPPORT {
+		return deviceTLSFeatures(iface)
+	}
+	return features, err
+}
+
+// ethtoolNetlinkTLSFeatures is deviceTLSFeatures using ethtool netlink.
+// It returns ENOENT if the kernel has no ethtool generic netlink family.
+func ethtoolNetlinkTLSFeatures(iface string) (map[string]bool, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, unix.NETLINK_GENERIC)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(fd)
+
+	var name [len(unix.ETHTOOL_GENL_NAME) + 1]byte
+	copy(name[:], unix.ETHTOOL_GENL_NAME)
+	req := genlRequest(unix.GENL_ID_CTRL, unix.CTRL_CMD_GETFAMILY, 1,
+		appendAttr(nil, unix.CTRL_ATTR_FAMILY_NAME, name[:]))
+	attrs, err := genlRoundTrip(fd, req, unix.GENL_ID_CTRL)
+	if err != nil {
+		return nil, err
+	}
+	id, ok := findAttr(attrs, unix.CTRL_ATTR_FAMILY_ID)
+	if !ok || len(id) < 2 {
+		return nil, errEthtoolNetlinkReply
+	}
+	family := nativeEndian.Uint16(id)
+
+	dev := append([]byte(iface), 0)
+	header := appendAttr(nil, unix.ETHTOOL_A_HEADER_DEV_NAME, dev)
+	req = genlRequest(family, unix.ETHTOOL_MSG_FEATURES_GET, unix.ETHTOOL_GENL_VERSION,
+		appendAttr(nil, unix.NLA_F_NESTED|unix.ETHTOOL_A_FEATURES_HEADER, header))
+	if attrs, err = genlRoundTrip(fd, req, family); err != nil {
+		return nil, err
+	}
+	return parseEthtoolNetlinkTLSFeatures(attrs)
+}
+
+// parseEthtoolNetlinkTLSFeatures extracts the TLS features from the
+// attributes of an ETHTOOL_MSG_FEATURES_GET_REPLY. Without
+// ETHTOOL_FLAG_COMPACT_BITSETS, the kernel lists the bits set in
+// ETHTOOL_A_FEATURES_HW, the features the driver can toggle, and in
+// ETHTOOL_A_FEATURES_ACTIVE by name.
+func parseEthtoolNetlinkTLSFeatures(attrs []byte) (map[string]bool, error) {
+	features := make(map[string]bool)
+	for _, set := range []struct {
+		typ    uint16
+		active bool
+	}{
+		{unix.ETHTOOL_A_FEATURES_HW, false},
+		{unix.ETHTOOL_A_FEATURES_ACTIVE, true},
+	} {
+		bitset, ok := findAttr(attrs, set.typ)
+		if !ok {
+			continue
+		}
+		bits, ok := findAttr(bitset, unix.ETHTOOL_A_BITSET_BITS)
+		if !ok {
+			continue
+		}
+		err := forEachAttr(bits, func(typ uint16, bit []byte) error {
+			if typ != unix.ETHTOOL_A_BITSET_BITS_BIT {
+				return nil
+			}
+			name, ok := findAttr(bit, unix.ETHTOOL_A_BITSET_BIT_NAME)
+			if !ok {
+				return errEthtoolNetlinkReply
+			}
+			s := unix.ByteSliceToString(name)
+			if strings.HasPrefix(s, "tls-") {
+				features[s] = features[s] || set.active
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return features, nil
+}
+
+// genlRequest returns a generic netlink request for command cmd of the
+// family, carrying attrs.
+func genlRequest(family uint16, cmd, version uint8, attrs []byte) []byte {
+	ne := nativeEndian
+	b := make([]byte, unix.NLMSG_HDRLEN+unix.GENL_HDRLEN, unix.NLMSG_HDRLEN+unix.GENL_HDRLEN+len(attrs))
+	b = append(b, attrs...)
+	ne.PutUint32(b[0:], uint32(len(b)))
+	ne.PutUint16(b[4:], family)
+	ne.PutUint16(b[6:], unix.NLM_F_REQUEST)
+	b[unix.NLMSG_HDRLEN] = cmd
+	b[unix.NLMSG_HDRLEN+1] = version
+	return b
+}
+
+// genlRoundTrip sends req on the generic netlink socket fd and returns the
+// attributes of the reply from family.
+func genlRoundTrip(fd int, req []byte, family uint16) ([]byte, error) {
+	if err := unix.Sendto(fd, req, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 32<<10)
+	n, _, err := unix.Recvfrom(fd, buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	return parseGenlReply(buf[:n], family)
+}
+
+// parseGenlReply returns the attributes of a generic netlink reply from
+// family, or the error it carries.
+func parseGenlReply(b []byte, family uint16) ([]byte, error) {
+	ne := nativeEndian
+	if len(b) < unix.NLMSG_HDRLEN {
+		return nil, errEthtoolNetlinkReply
+	}
+	msgLen := int(ne.Uint32(b))
+	if msgLen > len(b) || msgLen < unix.NLMSG_HDRLEN {
+		return nil, errEthtoolNetlinkReply
+	}
+	b = b[:msgLen]
+	switch ne.Uint16(b[4:]) {
+	case unix.NLMSG_ERROR:
+		if len(b) < unix.NLMSG_HDRLEN+4 {
+			return nil, errEthtoolNetlinkReply
+		}
+		return nil, unix.Errno(-int32(ne.Uint32(b[unix.NLMSG_HDRLEN:])))
+	case family:
+	default:
+		return nil, errEthtoolNetlinkReply
+	}
+	if len(b) < unix.NLMSG_HDRLEN+unix.GENL_HDRLEN {
+		return nil, errEthtoolNetlinkReply
+	}
+	return b[unix.NLMSG_HDRLEN+unix.GENL_HDRLEN:], nil
+}
+
+// appendAttr appends a netlink attribute of type typ to b.
+func appendAttr(b []byte, typ uint16, payload []byte) []byte {
+	ne := nativeEndian
+	l := unix.SizeofRtAttr + len(payload)
+	var hdr [unix.SizeofRtAttr]byte
+	ne.PutUint16(hdr[0:], uint16(l))
+	ne.PutUint16(hdr[2:], typ)
+	b = append(b, hdr[:]...)
+	b = append(b, payload...)
+	for ; l%unix.RTA_ALIGNTO != 0; l++ {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// forEachAttr calls f with the type and payload of each netlink attribute
+// in b.
+func forEachAttr(b []byte, f func(typ uint16, payload []byte) error) error {
+	ne := nativeEndian
+	for len(b) >= unix.SizeofRtAttr {
+		l := int(ne.Uint16(b))
+		if l < unix.SizeofRtAttr || l > len(b) {
+			return errEthtoolNetlinkReply
+		}
+		if err := f(ne.Uint16(b[2:])&nlaTypeMask, b[unix.SizeofRtAttr:l]); err != nil {
+			return err
+		}
+		l = (l + unix.RTA_ALIGNTO - 1) &^ (unix.RTA_ALIGNTO - 1)
+		if l > len(b) {
+			break
+		}
+		b = b[l:]
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package tls
+
+import "sync"
+
+// KTLSFeatures describes what kernel TLS can offload in this process.
+type KTLSFeatures struct {
+	TX, RX               bool // TLS 1.2 offload, per direction
+	TLS13TX, TLS13RX     bool // TLS 1.3 offload, per direction
+	AESGCM128, AESGCM256 bool
+	AESCCM128            bool // TLS 1.3 TLS_AES_128_CCM_SHA256 only
+	SM4                  bool // TLS 1.3 TLS_SM4_GCM_SM3 and TLS_SM4_CCM_SM3
+	ARIAGCM              bool // TLS 1.2 ARIA-GCM suites, 128 and 256-bit keys
+	ChaCha20Poly1305     bool
+	TXZerocopy, RXNoPad  bool // TLS_TX_ZEROCOPY_RO and TLS_RX_EXPECT_NO_PAD
+	KeyUpdate            bool // TLS 1.3 keys can be replaced after a KeyUpdate
+}
+
+// KTLSHostCapabilities describes the kernel TLS support of the host, as
+// returned by KTLSCapabilities.
+type KTLSHostCapabilities struct {
+	// KTLSFeatures are the features the kernel accepted when probed. Unlike
+	// CurrentKTLSFeatures, they are kept while kernel TLS is lost.
+	KTLSFeatures
+	// Kernel is the kernel release, as printed by uname -r.
+	Kernel string
+	// FromKernelVersion is true if the kernel could not be probed and the
+	// features were derived from its version instead.
+	FromKernelVersion bool
+	// UnsupportedReason is KTLSUnsupportedReason.
+	UnsupportedReason string
+}
+
+// ktlsUnavailableHooks are the functions registered with
+// NotifyKTLSUnavailable.
+var ktlsUnavailableHooks struct {
+	sync.Mutex
+	fns []func(reason string)
+}
+
+// NotifyKTLSUnavailable registers f to be called when kernel TLS stops
+// working while the process runs, for example because the tls module was
+// unloaded or a live kernel patch removed it. f is called once per loss,
+// from the goroutine that detected it, with the reason also returned by
+// KTLSUnsupportedReason. New connections then use user space until kernel
+// TLS is found to work again.
+func NotifyKTLSUnavailable(f func(reason string)) {
+	ktlsUnavailableHooks.Lock()
+	defer ktlsUnavailableHooks.Unlock()
+	ktlsUnavailableHooks.fns = append(ktlsUnavailableHooks.fns, f)
+}
+
+func notifyKTLSUnavailable(reason string) {
+	ktlsUnavailableHooks.Lock()
+	fns := ktlsUnavailableHooks.fns
+	ktlsUnavailableHooks.Unlock()
+	for _, f := range fns {
+		f(reason)
+	}
+}
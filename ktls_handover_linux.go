@@ -0,0 +1,245 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	errHandoverNotOffloaded = errors.New("tls: Handover requires kernel TLS in both directions")
+	errHandoverPending      = errors.New("tls: Handover with unread buffered data")
+	errHandedOver           = errors.New("tls: connection has been handed over")
+	errHandoverMessage      = errors.New("tls: AdoptConn received a malformed handover message")
+)
+
+// handoverStateVersion is the version of the state HandoverTo sends along
+// with the socket.
+const handoverStateVersion = 1
+
+// Handover detaches the connection from this Conn so that another component
+// or process can continue it using plain read, write and sendfile calls on
+// the returned file.
+//
+// Handover flushes any data held back by the write coalescer and fails
+// unless both directions are offloaded to the kernel and no received data
+// is buffered in user space, since neither could be carried over. The
+// returned file is a duplicate of the socket; the caller is responsible for
+// closing it. After a successful Handover, Read and Write on c return an
+// error and Close releases c's descriptor without sending close_notify.
+//
+// Handover blocks while a Read or Write is in progress.
+func (c *Conn) Handover() (*os.File, KTLSInfo, error) {
+	if err := c.Handshake(); err != nil {
+		return nil, KTLSInfo{}, err
+	}
+
+	c.in.Lock()
+	defer c.in.Unlock()
+	if err := c.ktlsAwaitRestoreLocked(&c.in); err != nil {
+		return nil, KTLSInfo{}, err
+	}
+	c.out.Lock()
+	defer c.out.Unlock()
+
+	if err := c.out.err; err != nil {
+		return nil, KTLSInfo{}, err
+	}
+	if err := c.in.err; err != nil {
+		return nil, KTLSInfo{}, err
+	}
+	if !c.IsKTLSTXEnabled() || !c.IsKTLSRXEnabled() {
+		return nil, KTLSInfo{}, errHandoverNotOffloaded
+	}
+	if c.closeNotifySent {
+		return nil, KTLSInfo{}, errShutdown
+	}
+	if c.input.Len() > 0 || c.hand.Len() > 0 || c.ktlsPauseRing() {
+		return nil, KTLSInfo{}, errHandoverPending
+	}
+	if err := c.flushCoalescedLocked(); err != nil {
+		return nil, KTLSInfo{}, err
+	}
+	if _, err := c.flush(); err != nil {
+		return nil, KTLSInfo{}, c.out.setErrorLocked(err)
+	}
+
+	fc, ok := c.conn.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, KTLSInfo{}, errHandoverNotOffloaded
+	}
+	f, err := fc.File()
+	if err != nil {
+		return nil, KTLSInfo{}, err
+	}
+
+	info := KTLSInfo{
+		Version:            c.vers,
+		CipherSuite:        c.cipherSuite,
+		NegotiatedProtocol: c.clientProtocol,
+		ServerName:         c.serverName,
+		DidResume:          c.didResume,
+	}
+
+	// Freeze c: the session now belongs to the holder of f.
+	c.in.setErrorLocked(errHandedOver)
+	c.out.setErrorLocked(errHandedOver)
+	c.closeNotifySent = true
+
+	return f, info, nil
+}
+
+// HandoverTo hands the connection over to the process at the other end of
+// the Unix socket uc, which continues it with AdoptConn. It calls Handover
+// and sends the socket with SCM_RIGHTS, together with the KTLSInfo and the
+// side of the connection c is, in a single message.
+//
+// Once Handover succeeded, c is frozen even if sending the message fails,
+// in which case the error is returned and the connection is lost.
+func (c *Conn) HandoverTo(uc *net.UnixConn) error {
+	f, info, err := c.Handover()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	state, err := marshalHandoverState(info, c.isClient)
+	if err != nil {
+		return err
+	}
+	// Fd would switch the socket, shared with c, to blocking mode.
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var n, oobn int
+	var err0 error
+	err = rc.Control(func(fd uintptr) {
+		n, oobn, err0 = uc.WriteMsgUnix(state, unix.UnixRights(int(fd)), nil)
+	})
+	if err == nil {
+		err = err0
+	}
+	if err == nil && (n != len(state) || oobn == 0) {
+		err = io.ErrShortWrite
+	}
+	return err
+}
+
+// AdoptConn receives a connection sent by HandoverTo on the Unix socket uc
+// and returns a Conn continuing it, with kernel TLS in both directions.
+// config is used as by Server or Client, but no handshake takes place.
+//
+// The traffic secrets stay with the sender, so an adopted TLS 1.3
+// connection fails if the peer sends a KeyUpdate, and CheckpointKTLS can't
+// restore it. Neither are the peer's certificates handed over:
+// ConnectionState reports no PeerCertificates, VerifiedChains, OCSP
+// response or SCTs. Lacking the resumption secret, an adopted client
+// ignores the session tickets the server sends, whatever
+// Config.ClientSessionCache.
+func AdoptConn(uc *net.UnixConn, config *Config) (*Conn, error) {
+	state := make([]byte, 1024)
+	oob := make([]byte, unix.CmsgSpace(4))
+	n, oobn, flags, _, err := uc.ReadMsgUnix(state, oob)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	var fds []int
+	for i := range msgs {
+		rights, err := unix.ParseUnixRights(&msgs[i])
+		if err == nil {
+			fds = append(fds, rights...)
+		}
+	}
+	if len(fds) != 1 || flags&(unix.MSG_TRUNC|unix.MSG_CTRUNC) != 0 {
+		for _, fd := range fds {
+			unix.Close(fd)
+		}
+		return nil, errHandoverMessage
+	}
+	f := os.NewFile(uintptr(fds[0]), "tls-handover")
+	defer f.Close()
+
+	info, isClient, ok := parseHandoverState(state[:n])
+	if !ok {
+		return nil, errHandoverMessage
+	}
+	conn, err := net.FileConn(f)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{
+		conn:           conn,
+		config:         config,
+		isClient:       isClient,
+		vers:           info.Version,
+		haveVers:       true,
+		cipherSuite:    info.CipherSuite,
+		clientProtocol: info.NegotiatedProtocol,
+		serverName:     info.ServerName,
+		didResume:      info.DidResume,
+	}
+	c.handshakeFn = c.serverHandshake
+	if isClient {
+		c.handshakeFn = c.clientHandshake
+	}
+	c.in.version, c.out.version = info.Version, info.Version
+	c.in.cipher, c.out.cipher = kTLSCipher{}, kTLSCipher{}
+	c.ktlsState.TXEnabled, c.ktlsState.RXEnabled = true, true
+	c.ktlsResyncBase, _ = tlsDeviceResyncs()
+	c.ktlsAdopted = true
+	c.isHandshakeComplete.Store(true)
+	c.countKTLSOffload()
+	return c, nil
+}
+
+// marshalHandoverState encodes the state HandoverTo sends.
+func marshalHandoverState(info KTLSInfo, isClient bool) ([]byte, error) {
+	var flags uint8
+	if isClient {
+		flags |= 1
+	}
+	if info.DidResume {
+		flags |= 2
+	}
+	var b cryptobyte.Builder
+	b.AddUint8(handoverStateVersion)
+	b.AddUint16(info.Version)
+	b.AddUint16(info.CipherSuite)
+	b.AddUint8(flags)
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes([]byte(info.NegotiatedProtocol))
+	})
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes([]byte(info.ServerName))
+	})
+	return b.Bytes()
+}
+
+// parseHandoverState decodes the state encoded by marshalHandoverState.
+func parseHandoverState(state []byte) (info KTLSInfo, isClient, ok bool) {
+	s := cryptobyte.String(state)
+	var version, flags uint8
+	var proto, serverName cryptobyte.String
+	if !s.ReadUint8(&version) || version != handoverStateVersion ||
+		!s.ReadUint16(&info.Version) || !s.ReadUint16(&info.CipherSuite) ||
+		!s.ReadUint8(&flags) ||
+		!s.ReadUint8LengthPrefixed(&proto) ||
+		!s.ReadUint16LengthPrefixed(&serverName) || !s.Empty() {
+		return KTLSInfo{}, false, false
+	}
+	info.NegotiatedProtocol = string(proto)
+	info.ServerName = string(serverName)
+	info.DidResume = flags&2 != 0
+	return info, flags&1 != 0, true
+}
@@ -0,0 +1,133 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestHandoverRequiresOffload(t *testing.T) {
+	client, server := coalescePipe(t, 0)
+	defer client.Close()
+	defer server.Close()
+
+	if client.IsKTLSTXEnabled() && client.IsKTLSRXEnabled() {
+		t.Skip("kernel TLS is enabled in both directions")
+	}
+	if _, _, err := client.Handover(); err != errHandoverNotOffloaded {
+		t.Fatalf("Handover error = %v, want %v", err, errHandoverNotOffloaded)
+	}
+	// A failed Handover leaves the connection usable.
+	go server.Write([]byte("x"))
+	buf := make([]byte, 1)
+	if _, err := client.Read(buf); err != nil {
+		t.Fatalf("Read after failed Handover: %v", err)
+	}
+}
+
+// unixPair returns the two ends of a connected Unix stream socket pair.
+func unixPair(t *testing.T) (a, b *net.UnixConn) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make([]*net.UnixConn, 2)
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "unixpair")
+		c, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns[i] = c.(*net.UnixConn)
+	}
+	return conns[0], conns[1]
+}
+
+func TestHandoverTo(t *testing.T) {
+	ln := newLocalListener(t)
+	defer ln.Close()
+	tcpConn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpConn.Close()
+	peer, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	// Fake both directions being offloaded: records then go to the socket
+	// as plaintext, which the peer reads directly.
+	c := &Conn{conn: tcpConn, config: testConfig.Clone(), isClient: true,
+		vers: VersionTLS13, cipherSuite: TLS_AES_128_GCM_SHA256, clientProtocol: "h2", serverName: "example.com"}
+	c.in.cipher, c.out.cipher = kTLSCipher{}, kTLSCipher{}
+	c.isHandshakeComplete.Store(true)
+
+	a, b := unixPair(t)
+	defer a.Close()
+	defer b.Close()
+	if err := c.HandoverTo(a); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write([]byte("x")); err != errHandedOver {
+		t.Errorf("Write after HandoverTo: %v, want %v", err, errHandedOver)
+	}
+	c.Close()
+
+	adopted, err := AdoptConn(b, testConfig.Clone())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adopted.Close()
+	state := adopted.ConnectionState()
+	if !adopted.isClient || state.Version != VersionTLS13 || state.CipherSuite != TLS_AES_128_GCM_SHA256 ||
+		state.NegotiatedProtocol != "h2" || state.ServerName != "example.com" ||
+		!state.KTLS.TXEnabled || !state.KTLS.RXEnabled {
+		t.Errorf("adopted connection state = %+v", state)
+	}
+	if _, err := adopted.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(peer, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("peer read %q, %v", buf, err)
+	}
+	// The traffic secrets were not handed over.
+	if err := adopted.ktlsCheckKeyUpdate(false); err == nil {
+		t.Error("KeyUpdate accepted without traffic secrets")
+	}
+	// Nor was the resumption secret, so session tickets are ignored.
+	adopted.config.ClientSessionCache = NewLRUClientSessionCache(1)
+	ticket := &newSessionTicketMsgTLS13{lifetime: 3600, label: []byte("ticket")}
+	if err := adopted.handleNewSessionTicket(ticket); err != nil {
+		t.Errorf("NewSessionTicket on an adopted client: %v", err)
+	}
+}
+
+func TestParseHandoverState(t *testing.T) {
+	info := KTLSInfo{Version: VersionTLS12, CipherSuite: TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		NegotiatedProtocol: "http/1.1", ServerName: "a.example", DidResume: true}
+	state, err := marshalHandoverState(info, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, isClient, ok := parseHandoverState(state)
+	if !ok || isClient || got != info {
+		t.Errorf("parseHandoverState = %+v, %v, %v; want %+v", got, isClient, ok, info)
+	}
+	if _, _, ok := parseHandoverState(state[:len(state)-1]); ok {
+		t.Error("truncated state parsed")
+	}
+	state[0]++
+	if _, _, ok := parseHandoverState(state); ok {
+		t.Error("state of an unknown version parsed")
+	}
+}
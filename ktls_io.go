@@ -1,18 +1,27 @@
 package tls
 
-import "io"
+import (
+	"errors"
+	"io"
+	"syscall"
+)
 
-// LimitWriter is a copy of the standard library ioutils.LimitReader,
-// applied to the writer interface.
-// LimitWriter returns a Writer that writes to w
-// but stops with EOF after n bytes.
-// The underlying implementation is a *LimitedWriter.
+// LimitWriter returns a Writer that writes to w but stops after n bytes,
+// the counterpart of io.LimitReader. The returned Writer is a
+// *LimitedWriter.
+//
+// Conn.WriteTo recognizes a *LimitedWriter: it copies at most N bytes of
+// application data, leaving the rest to later reads, and when the
+// LimitedWriter wraps an *os.File or a socket and the connection receives
+// with kernel TLS, it splices them from the socket into the destination
+// without a copy through user space.
 func LimitWriter(w io.Writer, n int64) io.Writer { return &LimitedWriter{w, n} }
 
-// A LimitedWriter writes to W but limits the amount of
-// data returned to just N bytes. Each call to Write
-// updates N to reflect the new amount remaining.
-// Write returns EOF when N <= 0 or when the underlying W returns EOF.
+// A LimitedWriter writes to W but limits the amount of data written to
+// just N bytes. Each call to Write, and each Conn.WriteTo into it, updates
+// N to reflect the new amount remaining. Write returns io.ErrShortWrite
+// when N <= 0 or when p is longer than N, after writing the first N bytes
+// of p.
 type LimitedWriter struct {
 	W io.Writer // underlying writer
 	N int64     // max bytes remaining
@@ -33,4 +42,75 @@ func (l *LimitedWriter) Write(p []byte) (n int, err error) {
 		err = io.ErrShortWrite
 	}
 	return
-}
\ No newline at end of file
+}
+
+// copyTo copies application data to w with Read until EOF, or until N
+// bytes have been copied if w is a *LimitedWriter, so that no data is read
+// from the connection that w can't take.
+func (c *Conn) copyTo(w io.Writer) (n int64, err error) {
+	lw, limited := w.(*LimitedWriter)
+	buf := make([]byte, 32*1024)
+	for {
+		b := buf
+		if limited {
+			if lw.N <= 0 {
+				return n, nil
+			}
+			if int64(len(b)) > lw.N {
+				b = b[:lw.N]
+			}
+		}
+		nr, rerr := c.Read(b)
+		if nr > 0 {
+			nw, werr := w.Write(b[:nr])
+			n += int64(nw)
+			if werr != nil {
+				return n, werr
+			}
+			if nw != nr {
+				return n, io.ErrShortWrite
+			}
+		}
+		if rerr == io.EOF {
+			return n, nil
+		}
+		if rerr != nil {
+			return n, rerr
+		}
+	}
+}
+
+// ErrNoKTLSRX is returned by SpliceTo if the connection doesn't receive with
+// kernel TLS, so its data can't be spliced, and by Conn.KTLSCryptoInfo.
+var ErrNoKTLSRX = errors.New("tls: kernel TLS RX is not enabled")
+
+// SpliceTo moves up to n bytes of application data from the connection to
+// dst with splice, through a pipe, without copying them to user space. dst
+// may be a file, a pipe or a stream socket, such as an *os.File or a
+// *net.TCPConn. The handshake is completed first if needed.
+//
+// SpliceTo returns the number of bytes moved. It stops early at EOF, with a
+// nil error, or when the next data has already been read into user space,
+// for example by a previous Read: the caller then reads it with Read.
+// Records other than application data, such as a NewSessionTicket, are
+// processed without interrupting the splice.
+//
+// If the connection doesn't receive with kernel TLS, or data is buffered in
+// user space before anything is spliced, SpliceTo moves nothing and
+// returns ErrNoKTLSRX, so the caller can fall back to Read or io.Copy.
+func (c *Conn) SpliceTo(dst syscall.Conn, n int64) (int64, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, nil
+	}
+	if err := c.flushCoalescedForRead(); err != nil {
+		return 0, err
+	}
+	written, err, handled := c.spliceLimited(dst, n)
+	if !handled {
+		return 0, ErrNoKTLSRX
+	}
+	return written, err
+}
@@ -0,0 +1,74 @@
+package tls
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLimitedWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := LimitWriter(&buf, 5).(*LimitedWriter)
+	if n, err := w.Write([]byte("abc")); n != 3 || err != nil || w.N != 2 {
+		t.Fatalf("got %d, %v, N = %d; want 3, nil, N = 2", n, err, w.N)
+	}
+	if n, err := w.Write([]byte("defg")); n != 2 || err != io.ErrShortWrite || w.N != 0 {
+		t.Fatalf("got %d, %v, N = %d; want 2, %v, N = 0", n, err, w.N, io.ErrShortWrite)
+	}
+	if n, err := w.Write([]byte("h")); n != 0 || err != io.ErrShortWrite {
+		t.Fatalf("got %d, %v; want 0, %v", n, err, io.ErrShortWrite)
+	}
+	if buf.String() != "abcde" {
+		t.Errorf("wrote %q, want %q", buf.String(), "abcde")
+	}
+}
+
+func TestWriteToLimited(t *testing.T) {
+	client, server := coalescePipe(t, 0)
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		client.Write([]byte("hello, world"))
+		client.Close()
+	}()
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lw := LimitWriter(f, 5).(*LimitedWriter)
+	if n, err := server.WriteTo(lw); n != 5 || err != nil || lw.N != 0 {
+		t.Fatalf("got %d, %v, N = %d; want 5, nil, N = 0", n, err, lw.N)
+	}
+	if got, _ := os.ReadFile(f.Name()); string(got) != "hello" {
+		t.Errorf("file holds %q, want %q", got, "hello")
+	}
+
+	// The rest of the data is left for later reads.
+	var rest bytes.Buffer
+	if n, err := server.WriteTo(&rest); n != 7 || err != nil {
+		t.Fatalf("got %d, %v; want 7, nil", n, err)
+	}
+	if rest.String() != ", world" {
+		t.Errorf("got %q, want %q", rest.String(), ", world")
+	}
+}
+
+func TestSpliceToRequiresKTLSRX(t *testing.T) {
+	client, server := coalescePipe(t, 0)
+	defer client.Close()
+	defer server.Close()
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if n, err := server.SpliceTo(f, 10); n != 0 || err != ErrNoKTLSRX {
+		t.Errorf("SpliceTo = %d, %v; want 0, %v", n, err, ErrNoKTLSRX)
+	}
+}
@@ -0,0 +1,685 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// io_uring definitions from include/uapi/linux/io_uring.h, which x/sys
+// doesn't wrap.
+const (
+	ioringOffSQRing = 0
+	ioringOffSQEs   = 0x10000000
+
+	ioringSetupCQSize    = 1 << 3
+	ioringFeatSingleMmap = 1 << 0
+	ioringEnterGetEvents = 1 << 0
+
+	ioringOpRecvmsg     = 10
+	ioringOpAsyncCancel = 14
+	ioringOpSplice      = 30
+
+	iosqeIOLink         = 1 << 2
+	iosqeBufferSelect   = 1 << 5
+	ioringRecvMultishot = 1 << 1
+
+	ioringCQEFBuffer     = 1 << 0
+	ioringCQEFMore       = 1 << 1
+	ioringCQEBufferShift = 16
+
+	ioringRegisterPbufRing = 22
+)
+
+type ioURingParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFD         uint32
+	resv         [3]uint32
+	sqOff        ioSQRingOffsets
+	cqOff        ioCQRingOffsets
+}
+
+type ioSQRingOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type ioCQRingOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type ioURingSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufGroup    uint16
+	personality uint16
+	spliceFDIn  int32
+	addr3       uint64
+	_           uint64
+}
+
+type ioURingCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+type ioURingBufReg struct {
+	ringAddr    uint64
+	ringEntries uint32
+	bgid        uint16
+	_           uint16
+	_           [3]uint64
+}
+
+type ioURingBuf struct {
+	addr uint64
+	len  uint32
+	bid  uint16
+	_    uint16
+}
+
+// ioURingRecvmsgOut heads each buffer filled by a multishot recvmsg. It is
+// followed by the name and control areas, sized as in the msghdr of the
+// request, and then by the payload.
+type ioURingRecvmsgOut struct {
+	namelen, controllen, payloadlen, flags uint32
+}
+
+const (
+	// ktlsRingSQEntries bounds the submissions in flight at once; each
+	// one is entered right away, so few are needed.
+	ktlsRingSQEntries = 64
+	// ktlsRingCQEntries is the size of the completion queue shared by all
+	// connections. The kernel keeps completions that don't fit and ends
+	// the multishot requests concerned, which are then armed again.
+	ktlsRingCQEntries = 4096
+	// ktlsRingBufs and ktlsRingBufSize describe the buffers provided to
+	// the kernel, each holding a record after its recvmsg header and
+	// record type.
+	ktlsRingBufs    = 512
+	ktlsRingBufSize = maxPlaintext + 64
+	// ktlsRingMaxQueued is the number of received buffers a connection
+	// may hold before its multishot request is canceled, so that a reader
+	// that falls behind doesn't starve the others of buffers. Like TCP's
+	// receive window, the request is armed again once it catches up.
+	ktlsRingMaxQueued = 8
+)
+
+// errKTLSRingUnsupported is returned by ktlsRingRecv.receive when the kernel
+// can't receive with multishot recvmsg, before any data was received.
+var errKTLSRingUnsupported = errors.New("tls: io_uring multishot recvmsg is not supported")
+
+// ktlsRing is the io_uring shared by the connections with
+// Config.KTLSRXIOUring or KTLSSpliceIOUring. It keeps one multishot recvmsg
+// request armed per receiving connection, which the kernel completes into
+// buffers taken from a shared ring as records arrive, without a system
+// call per record, and runs the splices of the others. A single goroutine
+// waits for the completions and hands them to the connections.
+type ktlsRing struct {
+	fd int
+
+	sqMu    sync.Mutex
+	sqHead  *uint32
+	sqTail  *uint32
+	sqMask  uint32
+	sqArray []uint32
+	sqes    []ioURingSQE
+
+	cqHead *uint32
+	cqTail *uint32
+	cqMask uint32
+	cqes   []ioURingCQE
+
+	bufMu   sync.Mutex
+	bufRing []ioURingBuf
+	bufTail uint16
+	bufs    []byte
+
+	mu     sync.Mutex
+	reqs   map[uint64]ktlsRingHandler
+	nextID uint64
+
+	// unsupported is set once the kernel rejected multishot recvmsg.
+	unsupported atomic.Bool
+}
+
+var ktlsRingShared struct {
+	once sync.Once
+	ring *ktlsRing
+	err  error
+}
+
+// getKTLSRing returns the process-wide ring, setting it up on first use.
+func getKTLSRing() (*ktlsRing, error) {
+	ktlsRingShared.once.Do(func() {
+		ktlsRingShared.ring, ktlsRingShared.err = newKTLSRing()
+		if ktlsRingShared.err != nil {
+			Debugln("kTLS: io_uring unavailable:", ktlsRingShared.err)
+			return
+		}
+		go ktlsRingShared.ring.reap()
+	})
+	return ktlsRingShared.ring, ktlsRingShared.err
+}
+
+func newKTLSRing() (_ *ktlsRing, err error) {
+	p := ioURingParams{flags: ioringSetupCQSize, cqEntries: ktlsRingCQEntries}
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, ktlsRingSQEntries, uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, &KTLSSyscallError{Syscall: "io_uring_setup", Err: errno}
+	}
+	r := &ktlsRing{fd: int(fd), reqs: make(map[uint64]ktlsRingHandler)}
+	defer func() {
+		if err != nil {
+			unix.Close(r.fd)
+		}
+	}()
+	if p.features&ioringFeatSingleMmap == 0 {
+		return nil, errors.New("tls: io_uring lacks IORING_FEAT_SINGLE_MMAP")
+	}
+
+	size := p.sqOff.array + p.sqEntries*4
+	if cqSize := p.cqOff.cqes + p.cqEntries*uint32(unsafe.Sizeof(ioURingCQE{})); cqSize > size {
+		size = cqSize
+	}
+	mem, err := unix.Mmap(r.fd, ioringOffSQRing, int(size), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		return nil, &KTLSSyscallError{Syscall: "mmap", Err: err}
+	}
+	sqeMem, err := unix.Mmap(r.fd, ioringOffSQEs, int(p.sqEntries)*int(unsafe.Sizeof(ioURingSQE{})), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		return nil, &KTLSSyscallError{Syscall: "mmap", Err: err}
+	}
+	r.sqHead = (*uint32)(unsafe.Pointer(&mem[p.sqOff.head]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&mem[p.sqOff.tail]))
+	r.sqMask = *(*uint32)(unsafe.Pointer(&mem[p.sqOff.ringMask]))
+	r.sqArray = unsafe.Slice((*uint32)(unsafe.Pointer(&mem[p.sqOff.array])), p.sqEntries)
+	r.sqes = unsafe.Slice((*ioURingSQE)(unsafe.Pointer(&sqeMem[0])), p.sqEntries)
+	r.cqHead = (*uint32)(unsafe.Pointer(&mem[p.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&mem[p.cqOff.tail]))
+	r.cqMask = *(*uint32)(unsafe.Pointer(&mem[p.cqOff.ringMask]))
+	r.cqes = unsafe.Slice((*ioURingCQE)(unsafe.Pointer(&mem[p.cqOff.cqes])), p.cqEntries)
+
+	// The buffers live outside the Go heap, since the kernel writes to
+	// them at any time.
+	ringMem, err := unix.Mmap(-1, 0, ktlsRingBufs*int(unsafe.Sizeof(ioURingBuf{})), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+	if err != nil {
+		return nil, &KTLSSyscallError{Syscall: "mmap", Err: err}
+	}
+	if r.bufs, err = unix.Mmap(-1, 0, ktlsRingBufs*ktlsRingBufSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE); err != nil {
+		return nil, &KTLSSyscallError{Syscall: "mmap", Err: err}
+	}
+	r.bufRing = unsafe.Slice((*ioURingBuf)(unsafe.Pointer(&ringMem[0])), ktlsRingBufs)
+	reg := ioURingBufReg{ringAddr: uint64(uintptr(unsafe.Pointer(&ringMem[0]))), ringEntries: ktlsRingBufs}
+	if _, _, errno := unix.Syscall6(unix.SYS_IO_URING_REGISTER, uintptr(r.fd), ioringRegisterPbufRing, uintptr(unsafe.Pointer(&reg)), 1, 0, 0); errno != 0 {
+		return nil, &KTLSSyscallError{Syscall: "io_uring_register", Err: errno}
+	}
+	for bid := 0; bid < ktlsRingBufs; bid++ {
+		r.bufRing[bid] = ioURingBuf{
+			addr: uint64(uintptr(unsafe.Pointer(&r.bufs[bid*ktlsRingBufSize]))),
+			len:  ktlsRingBufSize,
+			bid:  uint16(bid),
+		}
+	}
+	r.bufTail = ktlsRingBufs
+	r.publishBufTail()
+	return r, nil
+}
+
+// buf returns the provided buffer bid.
+func (r *ktlsRing) buf(bid uint16) []byte {
+	off := int(bid) * ktlsRingBufSize
+	return r.bufs[off : off+ktlsRingBufSize : off+ktlsRingBufSize]
+}
+
+// recycle gives the buffer bid back to the kernel.
+func (r *ktlsRing) recycle(bid uint16) {
+	r.bufMu.Lock()
+	defer r.bufMu.Unlock()
+	e := &r.bufRing[r.bufTail&(ktlsRingBufs-1)]
+	e.addr = uint64(uintptr(unsafe.Pointer(&r.buf(bid)[0])))
+	e.len = ktlsRingBufSize
+	e.bid = bid
+	r.bufTail++
+	r.publishBufTail()
+}
+
+// publishBufTail stores r.bufTail in the tail of the buffer ring, which
+// overlays the last two bytes of its first entry, with an atomic store of
+// the 32-bit word holding it, so that the entries are visible to the
+// kernel first. r.bufMu must be held.
+func (r *ktlsRing) publishBufTail() {
+	w := (*uint32)(unsafe.Pointer(&r.bufRing[0].bid))
+	word := atomic.LoadUint32(w)
+	*(*uint16)(unsafe.Add(unsafe.Pointer(&word), 2)) = r.bufTail
+	atomic.StoreUint32(w, word)
+}
+
+// submit queues sqes, consecutively so that they can be linked, and
+// enters them.
+func (r *ktlsRing) submit(sqes []ioURingSQE) error {
+	r.sqMu.Lock()
+	defer r.sqMu.Unlock()
+	tail := *r.sqTail
+	if tail-atomic.LoadUint32(r.sqHead)+uint32(len(sqes)) > r.sqMask+1 {
+		return &KTLSSyscallError{Syscall: "io_uring_enter", Err: unix.EBUSY}
+	}
+	for i := range sqes {
+		idx := (tail + uint32(i)) & r.sqMask
+		r.sqes[idx] = sqes[i]
+		r.sqArray[idx] = idx
+	}
+	atomic.StoreUint32(r.sqTail, tail+uint32(len(sqes)))
+	for {
+		_, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd), uintptr(len(sqes)), 0, 0, 0, 0)
+		switch errno {
+		case 0, unix.EAGAIN, unix.EBUSY:
+			// On EAGAIN and EBUSY, the entry stays queued, and reap
+			// submits it once it drained the completion queue.
+			return nil
+		case unix.EINTR:
+			continue
+		}
+		if atomic.LoadUint32(r.sqHead) == tail {
+			atomic.StoreUint32(r.sqTail, tail)
+		}
+		return &KTLSSyscallError{Syscall: "io_uring_enter", Err: errno}
+	}
+}
+
+// reap waits for completions and dispatches them to their connections. It
+// runs for the life of the process.
+func (r *ktlsRing) reap() {
+	for {
+		// Also submit the entries that submit left queued.
+		pending := atomic.LoadUint32(r.sqTail) - atomic.LoadUint32(r.sqHead)
+		_, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd), uintptr(pending), 1, ioringEnterGetEvents, 0, 0)
+		if errno != 0 && errno != unix.EINTR && errno != unix.EAGAIN && errno != unix.EBUSY {
+			Debugln("kTLS: io_uring_enter failed:", errno)
+			time.Sleep(10 * time.Millisecond)
+		}
+		head := *r.cqHead
+		for tail := atomic.LoadUint32(r.cqTail); head != tail; head++ {
+			r.complete(r.cqes[head&r.cqMask])
+		}
+		atomic.StoreUint32(r.cqHead, head)
+	}
+}
+
+func (r *ktlsRing) complete(cqe ioURingCQE) {
+	if cqe.userData == 0 {
+		// The completion of a cancellation.
+		return
+	}
+	more := cqe.flags&ioringCQEFMore != 0
+	r.mu.Lock()
+	h := r.reqs[cqe.userData]
+	if !more {
+		delete(r.reqs, cqe.userData)
+	}
+	r.mu.Unlock()
+	if h == nil {
+		if cqe.flags&ioringCQEFBuffer != 0 {
+			r.recycle(uint16(cqe.flags >> ioringCQEBufferShift))
+		}
+		return
+	}
+	h.complete(cqe, more)
+}
+
+// A ktlsRingHandler handles the completions of the requests it submitted.
+// complete is called by reap, one completion at a time; more is set if
+// the request completes again.
+type ktlsRingHandler interface {
+	complete(cqe ioURingCQE, more bool)
+}
+
+// ktlsRingOp collects the results of one-shot requests submitted by run.
+type ktlsRingOp struct {
+	base uint64
+	res  []int32
+	left int
+	done chan struct{}
+}
+
+func (op *ktlsRingOp) complete(cqe ioURingCQE, more bool) {
+	op.res[cqe.userData-op.base] = cqe.res
+	if op.left--; op.left == 0 {
+		close(op.done)
+	}
+}
+
+// run submits the one-shot requests sqes and waits for their results,
+// which it stores in res. Their user data is set by run.
+func (r *ktlsRing) run(sqes []ioURingSQE, res []int32) error {
+	op := &ktlsRingOp{res: res, left: len(sqes), done: make(chan struct{})}
+	r.mu.Lock()
+	op.base = r.nextID + 1
+	r.nextID += uint64(len(sqes))
+	for i := range sqes {
+		sqes[i].userData = op.base + uint64(i)
+		r.reqs[sqes[i].userData] = op
+	}
+	r.mu.Unlock()
+	if err := r.submit(sqes); err != nil {
+		r.mu.Lock()
+		for i := range sqes {
+			delete(r.reqs, sqes[i].userData)
+		}
+		r.mu.Unlock()
+		return err
+	}
+	<-op.done
+	return nil
+}
+
+// ktlsRingRecv receives the records of one connection through the shared
+// ring. The connection's reads and its Close use it concurrently, so its
+// state is protected by mu.
+type ktlsRingRecv struct {
+	ring     *ktlsRing
+	fd       int
+	deadline *atomic.Int64
+	// msg describes the layout of the buffers: no name, and room for the
+	// record type.
+	msg unix.Msghdr
+
+	mu    sync.Mutex
+	cond  sync.Cond
+	timer *time.Timer
+	// id is the user data of the armed multishot request, or zero.
+	// canceling is set once its cancellation was submitted.
+	id        uint64
+	canceling bool
+	queue     []ktlsRingCompletion
+	off       int // bytes of queue[0] already received
+	received  bool
+	err       error
+	closed    bool
+}
+
+// ktlsRingCompletion is a buffer filled by the kernel.
+type ktlsRingCompletion struct {
+	bid     uint16
+	cmsg    []byte
+	payload []byte
+}
+
+// newKTLSRingRecv returns a receiver for the socket fd, whose reads time
+// out at the Unix nanoseconds in deadline, if not zero.
+func newKTLSRingRecv(fd int, deadline *atomic.Int64) (*ktlsRingRecv, error) {
+	ring, err := getKTLSRing()
+	if err != nil {
+		return nil, err
+	}
+	if ring.unsupported.Load() {
+		return nil, errKTLSRingUnsupported
+	}
+	rr := &ktlsRingRecv{ring: ring, fd: fd, deadline: deadline}
+	rr.cond.L = &rr.mu
+	rr.msg.SetControllen(unix.CmsgSpace(1))
+	return rr, nil
+}
+
+// arm submits a multishot recvmsg request. rr.mu must be held.
+func (rr *ktlsRingRecv) arm() error {
+	r := rr.ring
+	r.mu.Lock()
+	r.nextID++
+	id := r.nextID
+	r.reqs[id] = rr
+	r.mu.Unlock()
+	sqe := [1]ioURingSQE{{
+		opcode:   ioringOpRecvmsg,
+		flags:    iosqeBufferSelect,
+		ioprio:   ioringRecvMultishot,
+		fd:       int32(rr.fd),
+		addr:     uint64(uintptr(unsafe.Pointer(&rr.msg))),
+		userData: id,
+	}}
+	if err := r.submit(sqe[:]); err != nil {
+		r.mu.Lock()
+		delete(r.reqs, id)
+		r.mu.Unlock()
+		return err
+	}
+	rr.id, rr.canceling = id, false
+	return nil
+}
+
+// cancel submits the cancellation of the armed request. rr.mu must be
+// held.
+func (rr *ktlsRingRecv) cancel() {
+	if rr.id == 0 || rr.canceling {
+		return
+	}
+	sqe := [1]ioURingSQE{{opcode: ioringOpAsyncCancel, addr: rr.id}}
+	if err := rr.ring.submit(sqe[:]); err != nil {
+		Debugln("kTLS: io_uring cancel failed:", err)
+		return
+	}
+	rr.canceling = true
+}
+
+// complete handles a completion of the request armed by rr.
+func (rr *ktlsRingRecv) complete(cqe ioURingCQE, more bool) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	defer rr.cond.Broadcast()
+	if !more && cqe.userData == rr.id {
+		rr.id, rr.canceling = 0, false
+	}
+
+	if cqe.flags&ioringCQEFBuffer != 0 {
+		bid := uint16(cqe.flags >> ioringCQEBufferShift)
+		buf := rr.ring.buf(bid)
+		out := (*ioURingRecvmsgOut)(unsafe.Pointer(&buf[0]))
+		ctl := int(unsafe.Sizeof(*out))
+		data := ctl + int(rr.msg.Controllen)
+		if rr.closed || rr.err != nil || out.payloadlen == 0 || data+int(out.payloadlen) > len(buf) {
+			rr.ring.recycle(bid)
+			if out.payloadlen == 0 && rr.err == nil {
+				rr.err = io.EOF
+			}
+			return
+		}
+		rr.received = true
+		rr.queue = append(rr.queue, ktlsRingCompletion{
+			bid:     bid,
+			cmsg:    buf[ctl : ctl+int(out.controllen)],
+			payload: buf[data : data+int(out.payloadlen)],
+		})
+		if len(rr.queue) >= ktlsRingMaxQueued {
+			rr.cancel()
+		}
+		return
+	}
+
+	switch errno := unix.Errno(-cqe.res); {
+	case cqe.res == 0:
+		if rr.err == nil {
+			rr.err = io.EOF
+		}
+	case cqe.res > 0, errno == unix.ECANCELED, errno == unix.ENOBUFS:
+		// Armed again by the next receive.
+	case errno == unix.EKEYEXPIRED:
+		// Kernels with TLS 1.3 rekey support stop receiving after a
+		// KeyUpdate until the new keys are programmed. The KeyUpdate is
+		// queued ahead of this completion, so the next receive, which
+		// arms the request again, follows ktlsRekey.
+	case errno == unix.EINVAL && !rr.received:
+		rr.ring.unsupported.Store(true)
+		if rr.err == nil {
+			rr.err = errKTLSRingUnsupported
+		}
+	default:
+		if rr.err == nil {
+			rr.err = &KTLSSyscallError{Syscall: "io_uring recvmsg", Err: errno}
+		}
+	}
+}
+
+// receive receives the next record, or what remains of it, into b and its
+// control message into cmsg. It returns the payload length.
+func (rr *ktlsRingRecv) receive(b, cmsg []byte) (int, error) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	for {
+		if len(rr.queue) > 0 {
+			q := &rr.queue[0]
+			for i := range cmsg {
+				cmsg[i] = 0
+			}
+			copy(cmsg, q.cmsg)
+			n := copy(b, q.payload[rr.off:])
+			rr.off += n
+			if rr.off == len(q.payload) {
+				rr.ring.recycle(q.bid)
+				copy(rr.queue, rr.queue[1:])
+				rr.queue = rr.queue[:len(rr.queue)-1]
+				rr.off = 0
+			}
+			return n, nil
+		}
+		if rr.err != nil {
+			return 0, rr.err
+		}
+		if rr.closed {
+			return 0, net.ErrClosed
+		}
+		if rr.id == 0 {
+			if err := rr.arm(); err != nil {
+				return 0, err
+			}
+		}
+		if d := rr.deadline.Load(); d != 0 {
+			wait := time.Until(time.Unix(0, d))
+			if wait <= 0 {
+				return 0, os.ErrDeadlineExceeded
+			}
+			if rr.timer == nil {
+				rr.timer = time.AfterFunc(wait, rr.wake)
+			} else {
+				rr.timer.Reset(wait)
+			}
+		}
+		rr.cond.Wait()
+	}
+}
+
+// wake wakes up receive, to check the deadline again.
+func (rr *ktlsRingRecv) wake() {
+	rr.mu.Lock()
+	rr.cond.Broadcast()
+	rr.mu.Unlock()
+}
+
+// close cancels the armed request and releases the buffered data. After
+// close, receive fails with net.ErrClosed.
+func (rr *ktlsRingRecv) close() {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	rr.closed = true
+	rr.cancel()
+	for _, q := range rr.queue {
+		rr.ring.recycle(q.bid)
+	}
+	rr.queue = nil
+	if rr.timer != nil {
+		rr.timer.Stop()
+	}
+	rr.cond.Broadcast()
+}
+
+// pause cancels the armed request and waits until the kernel ended it, so
+// that no more data is taken from the socket until the next receive. It
+// reports whether received data is buffered.
+func (rr *ktlsRingRecv) pause() (buffered bool) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	rr.cancel()
+	for rr.id != 0 && rr.canceling {
+		rr.cond.Wait()
+	}
+	return len(rr.queue) > 0
+}
+
+// ktlsRingReceive receives a record with the ring of
+// Config.KTLSRXIOUring, setting it up on first use. It reports false if
+// the ring can't be used, in which case the record must be received with
+// recvmsg. c.in must be locked.
+func (c *Conn) ktlsRingReceive(r *ktlsRecvState, b []byte) (int, error, bool) {
+	rr := c.ktlsRing.Load()
+	if rr == nil {
+		if r.ringFailed {
+			return 0, nil, false
+		}
+		var fd int
+		if err := r.rc.Control(func(s uintptr) { fd = int(s) }); err != nil {
+			return 0, err, true
+		}
+		var err error
+		if rr, err = newKTLSRingRecv(fd, &c.readDeadline); err != nil {
+			r.ringFailed = true
+			return 0, nil, false
+		}
+		c.ktlsRing.Store(rr)
+	}
+	n, err := rr.receive(b, r.cmsg())
+	if err == errKTLSRingUnsupported {
+		Debugln("kTLS: falling back to recvmsg:", err)
+		r.ringFailed = true
+		c.ktlsRing.Store(nil)
+		return 0, nil, false
+	}
+	return n, err, true
+}
+
+// ktlsCloseRing stops receiving with the ring, so that the kernel releases
+// the socket.
+func (c *Conn) ktlsCloseRing() {
+	if rr := c.ktlsRing.Load(); rr != nil {
+		rr.close()
+	}
+}
+
+// ktlsPauseRing stops taking data from the socket with the ring, and
+// reports whether data it received is still buffered. c.in must be locked.
+func (c *Conn) ktlsPauseRing() bool {
+	if rr := c.ktlsRing.Load(); rr != nil {
+		return rr.pause()
+	}
+	return false
+}
+
+// ktlsWakeRing wakes up a receive waiting on the ring, after the read
+// deadline changed.
+func (c *Conn) ktlsWakeRing() {
+	if rr := c.ktlsRing.Load(); rr != nil {
+		rr.wake()
+	}
+}
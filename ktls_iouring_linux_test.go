@@ -0,0 +1,174 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// newTestRingRecv returns a ring receiver on a plain TCP connection, which
+// receives the data written to peer without a record type, or skips the
+// test if io_uring can't be used.
+func newTestRingRecv(t *testing.T, deadline *atomic.Int64) (rr *ktlsRingRecv, conn, peer net.Conn) {
+	ln := newLocalListener(t)
+	defer ln.Close()
+	tcpConn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tcpConn.Close() })
+	peer, err = ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peer.Close() })
+
+	rc, err := tcpConn.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fd int
+	rc.Control(func(s uintptr) { fd = int(s) })
+	if rr, err = newKTLSRingRecv(fd, deadline); err != nil {
+		t.Skipf("io_uring: %v", err)
+	}
+	return rr, tcpConn, peer
+}
+
+func TestKTLSRingRecv(t *testing.T) {
+	rr, _, peer := newTestRingRecv(t, new(atomic.Int64))
+	defer rr.close()
+
+	want := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	go func() {
+		for b := want; len(b) > 0; b = b[1000:] {
+			if len(b) < 1000 {
+				peer.Write(b)
+				break
+			}
+			peer.Write(b[:1000])
+		}
+		peer.Close()
+	}()
+
+	var got []byte
+	cmsg := make([]byte, 24)
+	b := make([]byte, 777)
+	for {
+		n, err := rr.receive(b, cmsg)
+		if err == errKTLSRingUnsupported {
+			t.Skip("io_uring multishot recvmsg is not supported")
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, b[:n]...)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("received %d bytes, want %d", len(got), len(want))
+	}
+	if _, err := rr.receive(b, cmsg); err != io.EOF {
+		t.Errorf("receive after EOF = %v, want io.EOF", err)
+	}
+}
+
+func TestKTLSRingRecvDeadline(t *testing.T) {
+	var deadline atomic.Int64
+	rr, _, peer := newTestRingRecv(t, &deadline)
+	defer rr.close()
+
+	deadline.Store(time.Now().Add(50 * time.Millisecond).UnixNano())
+	b := make([]byte, 16)
+	_, err := rr.receive(b, nil)
+	if err == errKTLSRingUnsupported {
+		t.Skip("io_uring multishot recvmsg is not supported")
+	}
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("receive = %v, want a timeout", err)
+	}
+
+	// The request stays armed, so the data is not lost.
+	deadline.Store(0)
+	peer.Write([]byte("late"))
+	if n, err := rr.receive(b, nil); err != nil || string(b[:n]) != "late" {
+		t.Fatalf("receive = %q, %v; want \"late\", nil", b[:n], err)
+	}
+}
+
+func TestKTLSRingRecvClose(t *testing.T) {
+	rr, conn, peer := newTestRingRecv(t, new(atomic.Int64))
+
+	peer.Write([]byte("x"))
+	if _, err := rr.receive(make([]byte, 1), nil); err == errKTLSRingUnsupported {
+		t.Skip("io_uring multishot recvmsg is not supported")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	// The armed request holds a reference to the socket, which close
+	// must drop for closing the connection to reach the peer.
+	rr.close()
+	conn.Close()
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := peer.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("peer Read = %v, want io.EOF", err)
+	}
+	if _, err := rr.receive(make([]byte, 1), nil); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("receive after close = %v, want net.ErrClosed", err)
+	}
+}
+
+// TestKTLSRingRecvKeyExpired checks that the request ending with
+// EKEYEXPIRED, as it does after a KeyUpdate, doesn't fail the receiver.
+func TestKTLSRingRecvKeyExpired(t *testing.T) {
+	rr := &ktlsRingRecv{id: 1}
+	rr.cond.L = &rr.mu
+	rr.complete(ioURingCQE{userData: 1, res: -int32(unix.EKEYEXPIRED)}, false)
+	if rr.err != nil {
+		t.Errorf("err = %v after EKEYEXPIRED, want nil", rr.err)
+	}
+	if rr.id != 0 {
+		t.Error("request still armed after it ended")
+	}
+}
+
+func TestKTLSRingRecvPause(t *testing.T) {
+	rr, _, peer := newTestRingRecv(t, new(atomic.Int64))
+	defer rr.close()
+
+	peer.Write([]byte("ab"))
+	b := make([]byte, 1)
+	if _, err := rr.receive(b, nil); err == errKTLSRingUnsupported {
+		t.Skip("io_uring multishot recvmsg is not supported")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	if !rr.pause() {
+		t.Fatal("pause reported nothing buffered with 1 byte left")
+	}
+	if n, err := rr.receive(b, nil); err != nil || string(b[:n]) != "b" {
+		t.Fatalf("receive = %q, %v; want \"b\", nil", b[:n], err)
+	}
+	if rr.pause() {
+		t.Fatal("pause reported buffered data after it was received")
+	}
+
+	// The next receive arms the request again.
+	peer.Write([]byte("c"))
+	if n, err := rr.receive(b, nil); err != nil || string(b[:n]) != "c" {
+		t.Fatalf("receive = %q, %v; want \"c\", nil", b[:n], err)
+	}
+}
@@ -0,0 +1,160 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"io"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ringSpliceChunk is the most data moved per pair of splices submitted to
+// the ring, unless Config.SpliceChunkSize is set. Since the splice into the
+// pipe must not wait for the one out of it, which is linked after it, the
+// pipe is grown to hold a whole chunk, within /proc/sys/fs/pipe-max-size.
+const ringSpliceChunk = 1 << 20
+
+// spliceNoOffset tells a splice request to use the current position of a
+// file, as pipes and sockets require.
+const spliceNoOffset = ^uint64(0)
+
+// spliceSQE returns a request splicing n bytes from in, at offset off, to
+// out at its current position.
+func spliceSQE(in int, off uint64, out int, n int, flags uint32) ioURingSQE {
+	return ioURingSQE{
+		opcode:     ioringOpSplice,
+		fd:         int32(out),
+		off:        spliceNoOffset,
+		addr:       off,
+		len:        uint32(n),
+		opFlags:    flags,
+		spliceFDIn: int32(in),
+	}
+}
+
+// splicePair moves up to n bytes from in, at offset off, to out through the
+// empty pipe p, with two linked splices submitted to the ring, so that the
+// chunk costs a single system call and the copies run in the kernel's
+// workers rather than on the calling thread. n must fit in p. If out is
+// full, splicePair waits in the network poller until outRC is writable,
+// which honors its write deadline, and splices the rest of the pipe.
+//
+// It returns the bytes that reached out. An error of the splice from in is
+// returned as a bare unix.Errno, before anything was moved.
+func (r *ktlsRing) splicePair(in int, off uint64, inFlags uint32, p splicePipe, out int, outRC syscall.RawConn, n int) (int64, error) {
+	sqes := [2]ioURingSQE{
+		spliceSQE(in, off, p.wfd, n, inFlags),
+		spliceSQE(p.rfd, spliceNoOffset, out, n, unix.SPLICE_F_MOVE|unix.SPLICE_F_NONBLOCK),
+	}
+	// A short splice into the pipe breaks the link, canceling the second
+	// one, and the pipe is drained below.
+	sqes[0].flags = iosqeIOLink
+	var res [2]int32
+	if err := r.run(sqes[:], res[:]); err != nil {
+		return 0, err
+	}
+	if res[0] < 0 {
+		return 0, unix.Errno(-res[0])
+	}
+
+	filled, drained, last := int64(res[0]), int64(0), res[1]
+	if last > 0 {
+		drained = int64(last)
+	}
+	for drained < filled {
+		switch errno := unix.Errno(-last); {
+		case last == 0:
+			return drained, io.ErrShortWrite
+		case errno == unix.EAGAIN:
+			if err := outRC.Write(fdWritable); err != nil {
+				return drained, err
+			}
+		case last < 0 && errno != unix.ECANCELED:
+			return drained, errno
+		}
+		sqe := [1]ioURingSQE{spliceSQE(p.rfd, spliceNoOffset, out, int(filled-drained), unix.SPLICE_F_MOVE|unix.SPLICE_F_NONBLOCK)}
+		var res [1]int32
+		if err := r.run(sqe[:], res[:]); err != nil {
+			return drained, err
+		}
+		if last = res[0]; last > 0 {
+			drained += int64(last)
+		}
+	}
+	return drained, nil
+}
+
+// fdWritable reports whether writing fd would not block. It also reports
+// true for errors and hang-ups, which the write then returns.
+func fdWritable(fd uintptr) bool {
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLOUT}}
+	for {
+		n, err := unix.Poll(fds, 0)
+		if err == unix.EINTR {
+			continue
+		}
+		return err != nil || n > 0
+	}
+}
+
+// spliceRing returns the ring that SendFile, SendSection, WriteTo and
+// SpliceTo submit their splices to, or nil if Config.KTLSSpliceIOUring is
+// not set or io_uring is unavailable.
+func (c *Conn) spliceRing() *ktlsRing {
+	if !c.config.KTLSSpliceIOUring {
+		return nil
+	}
+	ring, err := getKTLSRing()
+	if err != nil {
+		return nil
+	}
+	return ring
+}
+
+// ringSendfile sends n bytes of the file infd at offset off to the socket
+// sc, a chunk of up to a pipe's capacity at a time, with splices submitted
+// to ring. handled is false if no pipe could be had, in which case nothing
+// was sent.
+func (c *Conn) ringSendfile(ring *ktlsRing, infd uintptr, sc syscall.RawConn, off, n int64) (written int64, err error, handled bool) {
+	pipe, err := getSplicePipe()
+	if err != nil {
+		return 0, nil, false
+	}
+	defer func() {
+		putSplicePipe(pipe, err == nil)
+	}()
+	size := c.config.SpliceChunkSize
+	if size <= 0 {
+		size = ringSpliceChunk
+	}
+	chunk := int64(pipe.grow(size))
+
+	cerr := sc.Control(func(outfd uintptr) {
+		for written < n {
+			m := n - written
+			if m > chunk {
+				m = chunk
+			}
+			var moved int64
+			moved, err = ring.splicePair(int(infd), uint64(off), unix.SPLICE_F_MOVE, pipe, int(outfd), sc, int(m))
+			written += moved
+			off += moved
+			if errno, ok := err.(unix.Errno); ok {
+				err = &KTLSSyscallError{Syscall: "io_uring splice", Err: errno}
+			}
+			if err != nil {
+				return
+			}
+			if moved == 0 {
+				err = io.ErrUnexpectedEOF
+				return
+			}
+		}
+	})
+	if err == nil {
+		err = cerr
+	}
+	return written, err, true
+}
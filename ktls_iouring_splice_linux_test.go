@@ -0,0 +1,150 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// testRingSplice splices n bytes from in at off to out through the ring,
+// a pipe's worth at a time.
+func testRingSplice(t *testing.T, in syscall.Conn, off int64, out syscall.Conn, n int64) (int64, error) {
+	ring, err := getKTLSRing()
+	if err != nil {
+		t.Skipf("io_uring: %v", err)
+	}
+	pipe, err := getSplicePipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pipe.close()
+	chunk := int64(pipe.grow(ringSpliceChunk))
+
+	irc, err := in.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orc, err := out.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written int64
+	irc.Control(func(infd uintptr) {
+		orc.Control(func(outfd uintptr) {
+			for written < n && err == nil {
+				m := n - written
+				if m > chunk {
+					m = chunk
+				}
+				inOff := spliceNoOffset
+				if off >= 0 {
+					inOff = uint64(off + written)
+				}
+				m, err = ring.splicePair(int(infd), inOff, unix.SPLICE_F_MOVE, pipe, int(outfd), orc, int(m))
+				written += m
+				if m == 0 && err == nil {
+					err = io.ErrUnexpectedEOF
+				}
+			}
+		})
+	})
+	return written, err
+}
+
+func TestKTLSRingSpliceFile(t *testing.T) {
+	want := make([]byte, 3<<20+123)
+	rand.New(rand.NewSource(1)).Read(want)
+	name := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(name, want, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	ln := newLocalListener(t)
+	defer ln.Close()
+	conn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	peer, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	// Read slowly at first, so that the socket fills up and the splices
+	// out of the pipe wait for it.
+	got := make(chan []byte, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		b, _ := io.ReadAll(peer)
+		got <- b
+	}()
+	const off = 1000
+	n, err := testRingSplice(t, f, off, conn, int64(len(want)-off))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(want)-off) {
+		t.Fatalf("spliced %d bytes, want %d", n, len(want)-off)
+	}
+	conn.Close()
+	if b := <-got; !bytes.Equal(b, want[off:]) {
+		t.Fatalf("peer received %d bytes, not the file section of %d", len(b), len(want)-off)
+	}
+	if pos, _ := f.Seek(0, io.SeekCurrent); pos != 0 {
+		t.Errorf("file offset moved to %d", pos)
+	}
+
+	// Past the end of the file, nothing is moved.
+	if n, err := testRingSplice(t, f, int64(len(want)), peer.(*net.TCPConn), 1); n != 0 || err != io.ErrUnexpectedEOF {
+		t.Errorf("splice past EOF = %d, %v; want 0, io.ErrUnexpectedEOF", n, err)
+	}
+}
+
+func TestKTLSRingSpliceDeadline(t *testing.T) {
+	f, err := os.Open("/dev/zero")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	ln := newLocalListener(t)
+	defer ln.Close()
+	conn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	peer, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	// The peer never reads, so the write deadline ends the splices.
+	conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	n, err := testRingSplice(t, f, 0, conn, 256<<20)
+	if !os.IsTimeout(err) {
+		t.Fatalf("splice = %d, %v; want a timeout", n, err)
+	}
+	if n == 0 {
+		t.Error("nothing was spliced before the deadline")
+	}
+}
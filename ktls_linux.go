@@ -1,15 +1,16 @@
-//go:build linux
-// +build linux
+//go:build linux && !noktls
+// +build linux,!noktls
 
 package tls
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"os"
-	"strconv"
-	"strings"
+	"syscall"
 	"unsafe"
 
 	"golang.org/x/sys/unix"
@@ -40,6 +41,9 @@ var (
 	// kTLSSupportAESGCM128 is true when kTLSSupport is true
 	kTLSSupportAESGCM128        bool
 	kTLSSupportAESGCM256        bool
+	kTLSSupportAESCCM128        bool
+	kTLSSupportSM4              bool // SM4-GCM and SM4-CCM
+	kTLSSupportARIAGCM          bool // ARIA-GCM-128 and ARIA-GCM-256
 	kTLSSupportCHACHA20POLY1305 bool
 
 	kTLSSupportTLS13TX bool
@@ -54,52 +58,41 @@ var (
 
 	// available in kernel 6+
 	kTLSSupportNOPAD bool
+
+	// kTLSSupportKeyUpdate is true if the keys of an offloaded TLS 1.3
+	// connection can be replaced, available in kernel 6.14+
+	kTLSSupportKeyUpdate bool
 )
 
 func init() {
-	// when kernel tls module enabled, /sys/module/tls is available
-	_, err := os.Stat("/sys/module/tls")
-	if err != nil {
-		Debugln("kTLS: kernel tls module not enabled")
-		return
-	}
-	kTLSSupport = true && kTLSEnabled
-	Debugf("kTLS Enabled Status: %v", kTLSSupport)
-	// no need to check further, as KTLS is disabled
-	if !kTLSSupport {
-		return
+	kTLSSupport = true
+	if reason := ktlsDisabledReason(); reason != "" {
+		Debugf("kTLS: %s", reason)
 	}
 
-	var uname unix.Utsname
-	if err := unix.Uname(&uname); err != nil {
+	release, err := kernelRelease()
+	if err != nil {
 		Debugf("kTLS: call uname failed %v", err)
+		ktlsUnsupported("uname failed: " + err.Error())
 		return
 	}
+	Debugf("Kernel Version: %s", release)
 
-	var buf [65]byte
-	for i, b := range uname.Release {
-		buf[i] = byte(b)
-	}
-	release := string(buf[:])
-	if i := strings.Index(release, "\x00"); i != -1 {
-		release = release[:i]
-	}
-	majorRelease := release[:strings.Index(release, ".")]
-	minorRelease := strings.TrimLeft(release, majorRelease+".")
-	minorRelease = minorRelease[:strings.Index(minorRelease, ".")]
-	major, err := strconv.Atoi(majorRelease)
-	if err != nil {
-		Debugf("kTLS: parse major release failed %v", err)
-		return
-	}
-	minor, err := strconv.Atoi(minorRelease)
-	if err != nil {
-		Debugf("kTLS: parse minor release failed %v", err)
+	env, supported := kernelEnvironment(release)
+	if !supported {
+		ktlsUnsupported(env + " does not implement kernel TLS")
 		return
 	}
+	// The version of a kernel says little about its configuration or the
+	// features backported to it, so probe the kernel on first use instead.
+	Debugf("kTLS: kernel %q, probing capabilities on first use", release)
+	ktlsProbeRelease = release
+	ktlsProbePending = true
+}
 
-	Debugf("Kernel Version: %s", release)
-
+// setKTLSFeatures enables the kernel TLS features of Linux major.minor. It
+// is only used when the kernel can't be probed.
+func setKTLSFeatures(major, minor int) {
 	if (major == 4 && minor >= 13) || major > 4 {
 		kTLSSupportTX = true
 		kTLSSupportAESGCM128 = true
@@ -114,19 +107,44 @@ func init() {
 		kTLSSupportTLS13TX = true
 	}
 
+	if (major == 5 && minor >= 2) || major > 5 {
+		kTLSSupportAESCCM128 = true
+	}
+
 	if (major == 5 && minor >= 11) || major > 5 {
 		kTLSSupportCHACHA20POLY1305 = true
 	}
 
+	if (major == 5 && minor >= 16) || major > 5 {
+		kTLSSupportSM4 = true
+	}
+
+	if (major == 6 && minor >= 1) || major > 6 {
+		kTLSSupportARIAGCM = true
+	}
+
 	if (major == 5 && minor >= 19) || major > 5 {
 		kTLSSupportZEROCOPY = true
 	}
 
-	if major > 5 {
+	if ktlsTLS13RXKernel(major, minor) {
 		kTLSSupportTLS13RX = true
 		kTLSSupportNOPAD = true
 	}
 
+	if (major == 6 && minor >= 14) || major > 6 {
+		kTLSSupportKeyUpdate = true
+	}
+}
+
+// ktlsTLS13RXKernel reports whether TLS 1.3 RX offload can be used on
+// Linux major.minor. Older kernels accept TLS_RX for TLS 1.3 but lose
+// records, so it is kept in user space there whatever a probe says.
+func ktlsTLS13RXKernel(major, minor int) bool {
+	return major > 5
+}
+
+func debugKTLSFeatures() {
 	Debugln("======Supported Features======")
 	Debugf("kTLS TX: %v", kTLSSupportTX)
 	Debugf("kTLS RX: %v", kTLSSupportRX)
@@ -134,133 +152,164 @@ func init() {
 	Debugf("kTLS TLS 1.3 RX: %v", kTLSSupportTLS13RX)
 	Debugf("kTLS TX ZeroCopy: %v", kTLSSupportZEROCOPY)
 	Debugf("kTLS RX Expected No Pad: %v", kTLSSupportNOPAD)
+	Debugf("kTLS KeyUpdate: %v", kTLSSupportKeyUpdate)
 
 	Debugln("=========CipherSuites=========")
 	Debugf("kTLS AES-GCM-128: %v", kTLSSupportAESGCM128)
 	Debugf("kTLS AES-GCM-256: %v", kTLSSupportAESGCM256)
+	Debugf("kTLS AES-CCM-128: %v", kTLSSupportAESCCM128)
+	Debugf("kTLS SM4-GCM/SM4-CCM: %v", kTLSSupportSM4)
+	Debugf("kTLS ARIA-GCM-128/ARIA-GCM-256: %v", kTLSSupportARIAGCM)
 	Debugf("kTLS CHACHA20POLY1305: %v", kTLSSupportCHACHA20POLY1305)
 }
 
+// ReadFrom copies r to the connection. If TX is offloaded to the kernel and
+// r is a regular file, optionally wrapped in an io.LimitedReader, the file
+// is sent with sendfile. Otherwise r is read and written with
+// pipelinedCopy, which encrypts in user space unless TX is offloaded, so
+// sendfile never puts plaintext on a connection protected in user space.
 func (c *Conn) ReadFrom(r io.Reader) (n int64, err error) {
 	if err := c.Handshake(); err != nil {
 		return 0, err
 	}
-	return io.Copy(c.conn, r)
-}
-
-const maxBufferSize int64 = 4 * 1024 * 1024
-
-func (c *Conn) writeToFile(f *os.File, remain int64) (written int64, err error, handled bool) {
-	if remain <= 0 {
-		return 0, nil, false
-	}
-	offset, err := f.Seek(0, io.SeekCurrent)
-	if err != nil {
-		return 0, nil, false
-	}
-	fi, err := f.Stat()
-	if err != nil {
-		return 0, nil, false
-	}
-	if offset+remain > fi.Size() {
-		err = f.Truncate(offset + remain)
-		if err != nil {
-			Debugf("file truncate error: %s", err)
-			return 0, nil, false
+	if _, ok := c.out.cipher.(kTLSCipher); ok && isSendfileSource(r) {
+		if err := c.Flush(); err != nil {
+			return 0, err
 		}
+		n, err = io.Copy(c.conn, r)
+		c.stats.sendfileBytes.Add(uint64(n))
+		return n, err
 	}
+	return c.pipelinedCopy(r)
+}
 
-	// mmap must align on a page boundary
-	// mmap from 0, use data from offset
-	bytes, err := unix.Mmap(int(f.Fd()), 0, int(offset+remain),
-		unix.PROT_WRITE, unix.MAP_SHARED)
-	if err != nil {
-		return 0, nil, false
-	}
-	defer unix.Munmap(bytes)
-
-	bytes = bytes[offset : offset+remain]
-	var (
-		start = int64(0)
-		end   = maxBufferSize
-	)
-
-	for {
-		if end > remain {
-			end = remain
-		}
-		//now := time.Now()
-		n, err := c.Read(bytes[start:end])
-		if err != nil {
-			return start + int64(n), err, true
-		}
-		//log.Printf("read %d bytes, cost %dus", n, time.Since(now).Microseconds())
-		start += int64(n)
-		if start >= remain {
-			break
-		}
-
-		end += int64(n)
+// isSendfileSource reports whether the net package can send r with
+// sendfile.
+func isSendfileSource(r io.Reader) bool {
+	if lr, ok := r.(*io.LimitedReader); ok {
+		r = lr.R
 	}
-	return remain, nil, true
+	f, _ := preadFile(r)
+	return f != nil
 }
 
+// maxSpliceSize is the most data spliceTo moves per splice call, unless
+// Config.SpliceChunkSize is set. The pipe's capacity bounds it in practice.
 var maxSpliceSize int64 = 4 << 20
 
-func (c *Conn) spliceToFile(f *os.File, remain int64) (written int64, err error, handled bool) {
-	tcpConn, ok := c.conn.(*net.TCPConn)
-	if !ok {
+// errKTLSControlRecord is returned by spliceTo when the next record on the
+// socket is not application data and must be read with recvmsg.
+var errKTLSControlRecord = errors.New("tls: next kernel TLS record is not application data")
+
+// spliceTo moves up to remain bytes of application data decrypted by kernel
+// TLS RX from the socket to dst through a pipe, without copying them to
+// user space.
+func (c *Conn) spliceTo(dst syscall.Conn, remain int64) (written int64, err error, handled bool) {
+	sock := c.ktlsConn()
+	if sock == nil || c.ktlsRing.Load() != nil {
+		// Once the ring receives, it may hold data taken from the socket.
 		return 0, nil, false
 	}
-	sc, err := tcpConn.SyscallConn()
+	sc, err := sock.SyscallConn()
 	if err != nil {
 		return 0, nil, false
 	}
-	fsc, err := f.SyscallConn()
+	dsc, err := dst.SyscallConn()
 	if err != nil {
 		return 0, nil, false
 	}
 
-	var pipes [2]int
-	if err := unix.Pipe(pipes[:]); err != nil {
+	pipe, err := getSplicePipe()
+	if err != nil {
 		return 0, nil, false
 	}
-
-	prfd, pwfd := pipes[0], pipes[1]
-	defer destroyTempPipe(prfd, pwfd)
-
+	prfd, pwfd := pipe.rfd, pipe.wfd
+	// The pipe is drained after every chunk, so it is only left holding
+	// data if moving it to dst failed.
+	defer func() {
+		putSplicePipe(pipe, err == nil || err == errKTLSControlRecord)
+	}()
+
+	chunk := maxSpliceSize
+	ring := c.spliceRing()
+	if size := c.config.SpliceChunkSize; size > 0 {
+		chunk = int64(pipe.grow(size))
+	} else if ring != nil {
+		chunk = int64(pipe.grow(ringSpliceChunk))
+	}
 	var (
-		n = maxSpliceSize
+		n = chunk
 		m int64
 	)
 
 	rerr := sc.Read(func(rfd uintptr) (done bool) {
 		for {
-			n = maxSpliceSize
+			n = chunk
 			if n > remain {
 				n = remain
 			}
+			// Kernel TLS decides whether splice blocks from
+			// SPLICE_F_NONBLOCK alone, ignoring the socket's
+			// O_NONBLOCK, and older kernels don't advance the socket
+			// buffer with that flag (see tls_sw_splice_read). So the
+			// splice blocks, and is only made once the socket is
+			// readable, which with kernel TLS means a whole record has
+			// arrived since Linux 5.19. Until then, wait in the poller,
+			// which honors the read deadline.
+			if !fdReadable(rfd) {
+				return false
+			}
+			if ring != nil {
+				cerr := dsc.Control(func(wfd uintptr) {
+					n, err = ring.splicePair(int(rfd), spliceNoOffset, unix.SPLICE_F_MORE, pipe, int(wfd), dsc, int(n))
+				})
+				remain -= n
+				written += n
+				switch {
+				case err == unix.EAGAIN:
+					err = nil
+					return false
+				case err == unix.EINVAL:
+					err = errKTLSControlRecord
+				case err == nil && cerr != nil:
+					err = cerr
+				case err == nil && n > 0 && remain > 0:
+					continue
+				}
+				break
+			}
 			// move tcp data to pipe
-			// FIXME should not use unix.SPLICE_F_NONBLOCK, when use this flag, ktls will not advance socket buffer
-			// refer: https://github.com/torvalds/linux/blob/v5.12/net/tls/tls_sw.c#L2021
-			n, err = unix.Splice(int(rfd), nil, pwfd, nil, int(n), unix.SPLICE_F_MORE)
-			remain -= n
-			written += n
+			n, err = splice(int(rfd), pwfd, int(n), unix.SPLICE_F_MORE)
 			if err == unix.EAGAIN {
 				// return false to wait data from connection
 				err = nil
 				return false
 			}
 
-			if err != nil {
+			if err == unix.EINVAL {
+				// The next record is not application data, which the
+				// kernel leaves for recvmsg.
+				err = errKTLSControlRecord
 				break
 			}
+			if err != nil || n == 0 {
+				// n == 0 is EOF.
+				break
+			}
+			remain -= n
+			written += n
 
-			// move pipe data to file
-			werr := fsc.Write(func(wfd uintptr) (done bool) {
+			// move pipe data to the destination
+			werr := dsc.Write(func(wfd uintptr) (done bool) {
 			bump:
-				m, err = unix.Splice(prfd, nil, int(wfd), nil, int(n),
+				m, err = splice(prfd, int(wfd), int(n),
 					unix.SPLICE_F_MOVE|unix.SPLICE_F_MORE|unix.SPLICE_F_NONBLOCK)
+				if err == unix.EAGAIN {
+					// A socket destination is full, wait until it
+					// is writable.
+					err = nil
+					return false
+				}
 				if err != nil {
 					return true
 				}
@@ -282,47 +331,118 @@ func (c *Conn) spliceToFile(f *os.File, remain int64) (written int64, err error,
 	if err == nil {
 		err = rerr
 	}
+	c.stats.spliceBytes.Add(uint64(written))
 	return written, err, true
 }
 
-// destroyTempPipe destroys a temporary pipe.
-func destroyTempPipe(prfd, pwfd int) error {
-	err := unix.Close(prfd)
-	err1 := unix.Close(pwfd)
-	if err == nil {
-		return err1
+// fdReadable reports whether reading fd would not block. It also reports
+// true for errors and hang-ups, which the read then returns.
+func fdReadable(fd uintptr) bool {
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	for {
+		n, err := unix.Poll(fds, 0)
+		if err == unix.EINTR {
+			continue
+		}
+		return err != nil || n > 0
 	}
-	return err
 }
 
+// WriteTo copies application data from the connection to w until EOF or
+// an error. If w is a *LimitedWriter, see LimitWriter, at most N bytes are
+// copied and N is decreased accordingly.
+//
+// If the connection receives with kernel TLS and w is a *net.TCPConn or a
+// stream *net.UnixConn, or a *LimitedWriter wrapping one of those or an
+// *os.File, the data is spliced from the socket into w through a pipe and
+// never copied to user space. This lets a TLS-terminating proxy forward
+// to its backend with io.Copy.
 func (c *Conn) WriteTo(w io.Writer) (n int64, err error) {
 	if err := c.Handshake(); err != nil {
 		return 0, err
 	}
+	if err := c.flushCoalescedForRead(); err != nil {
+		return 0, err
+	}
 
 	if lw, ok := w.(*LimitedWriter); ok {
-		if f, ok := lw.W.(*os.File); ok {
-			n, err, handled := c.spliceToFile(f, lw.N)
+		if dst, ok := spliceTarget(lw.W, true); ok {
+			n, err, handled := c.spliceLimited(dst, lw.N)
 			if handled {
+				lw.N -= n
+				return n, err
+			}
+		}
+	} else if dst, ok := spliceTarget(w, false); ok {
+		n, err, handled := c.spliceLimited(dst, math.MaxInt64)
+		if handled {
+			if err != nil {
 				return n, err
 			}
+			// Splicing stops early if data is buffered in user
+			// space, copy the rest.
+			m, err := c.copyTo(w)
+			return n + m, err
 		}
 	}
+	return c.copyTo(w)
+}
 
-	// FIXME read at least one record for io.EOF and so on ?
-	//if conn, ok := w.(*net.TCPConn); ok {
-	//	buf := make([]byte, 16*1024)
-	//	n, err := ktlsReadRecord(conn, buf)
-	//	if err != nil {
-	//		wn, _ := w.Write(buf[:n])
-	//		return int64(wn), err
-	//	}
-	//	wn, err := w.Write(buf[:n])
-	//	if err != nil {
-	//		return int64(wn), err
-	//	}
-	//}
-	return io.Copy(w, c.conn)
+// spliceTarget returns w as a destination for spliceTo: a TCP connection or
+// a Unix stream socket, or also a regular file if files is set.
+func spliceTarget(w io.Writer, files bool) (syscall.Conn, bool) {
+	switch w := w.(type) {
+	case *net.TCPConn:
+		return w, true
+	case *net.UnixConn:
+		return w, w.LocalAddr().Network() == "unix"
+	case *os.File:
+		return w, files
+	}
+	return nil, false
+}
+
+// spliceLimited splices up to n bytes into dst with spliceTo, if the
+// connection receives with kernel TLS and no data is buffered in user
+// space. Records the kernel won't splice, such as a NewSessionTicket, are
+// read with recvmsg and processed as Read would before splicing resumes.
+func (c *Conn) spliceLimited(dst syscall.Conn, n int64) (written int64, err error, handled bool) {
+	c.in.Lock()
+	defer c.in.Unlock()
+	if err := c.ktlsAwaitRestoreLocked(&c.in); err != nil {
+		return 0, err, true
+	}
+	if c.ktlsDeferRX {
+		c.enableDeferredKTLSRX()
+	}
+	for written < n {
+		if _, ok := c.in.cipher.(kTLSCipher); !ok || c.in.err != nil ||
+			c.input.Len() > 0 || c.rawInput.Len() > 0 || c.hand.Len() > 0 {
+			break
+		}
+		m, err, ok := c.spliceTo(dst, n-written)
+		if !ok {
+			break
+		}
+		written += m
+		handled = true
+		if err != errKTLSControlRecord {
+			return written, err, true
+		}
+		// The kernel only splices application data and leaves other
+		// records, such as a NewSessionTicket, for recvmsg.
+		err = c.readRecord()
+		for err == nil && c.hand.Len() > 0 {
+			err = c.handlePostHandshakeMessage()
+		}
+		if err == io.EOF {
+			return written, nil, true
+		}
+		if err != nil {
+			return written, err, true
+		}
+	}
+	return written, nil, handled
 }
 
 func (c *Conn) IsKTLSTXEnabled() bool {
@@ -335,92 +455,450 @@ func (c *Conn) IsKTLSRXEnabled() bool {
 	return ok
 }
 
-func (c *Conn) enableKernelTLS(cipherSuiteID uint16, inKey, outKey, inIV, outIV []byte, clientCipher, serverCipher *any) error {
-	if !kTLSSupport {
-		return nil
-	}
+// ktlsFallbackRX records that TLS_RX could not be programmed after TLS_TX
+// was, leaving the connection with kernel TX and user-space RX.
+func (c *Conn) ktlsFallbackRX(err error) {
+	c.ktlsState.RXReason = "TLS_RX setsockopt failed: " + err.Error()
+	c.ktlsReport.Store(true)
+}
+
+// ktlsCipherForSuite returns how to program the given cipher suite into the
+// kernel, or false if the running kernel cannot offload it.
+func ktlsCipherForSuite(cipherSuiteID uint16) (ktlsCipher, bool) {
 	switch cipherSuiteID {
 	// Kernel TLS 1.2
 	case TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_RSA_WITH_AES_128_GCM_SHA256:
 		if !kTLSSupportAESGCM128 {
-			return nil
+			return ktlsCipher{}, false
 		}
 		Debugln("try to enable kernel tls AES_128_GCM for tls 1.2")
-		return ktlsEnableAES(c, VersionTLS12, ktlsEnableAES128GCM, 16, inKey, outKey, inIV, outIV, clientCipher, serverCipher)
+		return ktlsCipher{VersionTLS12, 16, ktlsEnableAES128GCM}, true
 	case TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, TLS_RSA_WITH_AES_256_GCM_SHA384:
 		if !kTLSSupportAESGCM256 {
-			return nil
+			return ktlsCipher{}, false
 		}
 		Debugln("try to enable kernel tls AES_256_GCM for tls 1.2")
-		return ktlsEnableAES(c, VersionTLS12, ktlsEnableAES256GCM, 32, inKey, outKey, inIV, outIV, clientCipher, serverCipher)
+		return ktlsCipher{VersionTLS12, 32, ktlsEnableAES256GCM}, true
 	case TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256, TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256:
 		if !kTLSSupportCHACHA20POLY1305 {
-			return nil
+			return ktlsCipher{}, false
 		}
 		Debugln("try to enable kernel tls CHACHA20_POLY1305 for tls 1.2")
-		return ktlsEnableCHACHA20(c, VersionTLS12, inKey, outKey, inIV, outIV, clientCipher, serverCipher)
+		return ktlsCipher{VersionTLS12, 32, ktlsEnableCHACHA20POLY1305}, true
+	case TLS_ECDHE_RSA_WITH_ARIA_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_ARIA_128_GCM_SHA256, TLS_RSA_WITH_ARIA_128_GCM_SHA256:
+		if !kTLSSupportARIAGCM {
+			return ktlsCipher{}, false
+		}
+		Debugln("try to enable kernel tls ARIA_GCM_128 for tls 1.2")
+		return ktlsCipher{VersionTLS12, 16, ktlsEnableARIA128GCM}, true
+	case TLS_ECDHE_RSA_WITH_ARIA_256_GCM_SHA384, TLS_ECDHE_ECDSA_WITH_ARIA_256_GCM_SHA384, TLS_RSA_WITH_ARIA_256_GCM_SHA384:
+		if !kTLSSupportARIAGCM {
+			return ktlsCipher{}, false
+		}
+		Debugln("try to enable kernel tls ARIA_GCM_256 for tls 1.2")
+		return ktlsCipher{VersionTLS12, 32, ktlsEnableARIA256GCM}, true
 
 	// Kernel TLS 1.3
 	case TLS_AES_128_GCM_SHA256:
 		if !kTLSSupportAESGCM128 || !kTLSSupportTLS13TX {
-			return nil
+			return ktlsCipher{}, false
 		}
 		Debugln("try to enable kernel tls AES_128_GCM for tls 1.3")
-		return ktlsEnableAES(c, VersionTLS13, ktlsEnableAES128GCM, 16, inKey, outKey, inIV, outIV, clientCipher, serverCipher)
+		return ktlsCipher{VersionTLS13, 16, ktlsEnableAES128GCM}, true
 	case TLS_AES_256_GCM_SHA384:
 		if !kTLSSupportAESGCM256 || !kTLSSupportTLS13TX {
-			return nil
+			return ktlsCipher{}, false
 		}
 		Debugln("try to enable kernel tls AES_256_GCM tls 1.3")
-		return ktlsEnableAES(c, VersionTLS13, ktlsEnableAES256GCM, 32, inKey, outKey, inIV, outIV, clientCipher, serverCipher)
+		return ktlsCipher{VersionTLS13, 32, ktlsEnableAES256GCM}, true
 	case TLS_CHACHA20_POLY1305_SHA256:
 		if !kTLSSupportCHACHA20POLY1305 || !kTLSSupportTLS13TX {
-			return nil
+			return ktlsCipher{}, false
 		}
 		Debugln("try to enable kernel tls CHACHA20_POLY1305 for tls 1.3")
-		return ktlsEnableCHACHA20(c, VersionTLS13, inKey, outKey, inIV, outIV, clientCipher, serverCipher)
+		return ktlsCipher{VersionTLS13, 32, ktlsEnableCHACHA20POLY1305}, true
+	case TLS_AES_128_CCM_SHA256:
+		if !kTLSSupportAESCCM128 || !kTLSSupportTLS13TX {
+			return ktlsCipher{}, false
+		}
+		Debugln("try to enable kernel tls AES_128_CCM for tls 1.3")
+		return ktlsCipher{VersionTLS13, 16, ktlsEnableAES128CCM}, true
+	case TLS_SM4_GCM_SM3:
+		if !kTLSSupportSM4 || !kTLSSupportTLS13TX {
+			return ktlsCipher{}, false
+		}
+		Debugln("try to enable kernel tls SM4_GCM for tls 1.3")
+		return ktlsCipher{VersionTLS13, 16, ktlsEnableSM4GCM}, true
+	case TLS_SM4_CCM_SM3:
+		if !kTLSSupportSM4 || !kTLSSupportTLS13TX {
+			return ktlsCipher{}, false
+		}
+		Debugln("try to enable kernel tls SM4_CCM for tls 1.3")
+		return ktlsCipher{VersionTLS13, 16, ktlsEnableSM4CCM}, true
+	}
+	return ktlsCipher{}, false
+}
+
+func (c *Conn) enableKernelTLS(cipherSuiteID uint16, inKey, outKey, inIV, outIV []byte, txCipher, rxCipher *any) (err error) {
+	if c.config.KTLSDeferEnable && !c.isHandshakeComplete.Load() {
+		c.debugln("kTLS: deferred until EnableKTLS")
+		c.ktlsDeferEnable = true
+		c.ktlsState.TXReason = "deferred until EnableKTLS"
+		c.ktlsState.RXReason = c.ktlsState.TXReason
+		return nil
+	}
+	// The keys are not needed once each direction is offloaded or left to
+	// user space, except by a deferred TLS_RX.
+	defer func() {
+		c.out.wipeKey()
+		if !c.ktlsDeferRX {
+			c.in.wipeKey()
+		}
+	}()
+	// Report the outcome once it is final, which for a deferred TLS_RX is
+	// only after the first Read.
+	defer func() {
+		if err == nil && !c.ktlsDeferRX {
+			c.ktlsReport.Store(true)
+		}
+	}()
+	defer func() {
+		if err == nil {
+			err = c.ktlsCheckRequired()
+		}
+	}()
+	if mode, source := c.ktlsMode(); mode == KTLSModeOff {
+		c.ktlsState.TXReason = "disabled by " + source
+		c.ktlsState.RXReason = c.ktlsState.TXReason
+		c.ktlsState.TXDisabled, c.ktlsState.RXDisabled = true, true
+		return nil
+	}
+	if !c.config.ktlsAllowsSuite(cipherSuiteID) {
+		c.debugf("kTLS: cipher suite %s not in Config.KTLSCipherSuites", CipherSuiteName(cipherSuiteID))
+		c.ktlsState.TXReason = "cipher suite " + CipherSuiteName(cipherSuiteID) + " not in Config.KTLSCipherSuites"
+		c.ktlsState.RXReason = c.ktlsState.TXReason
+		c.ktlsState.TXDisabled, c.ktlsState.RXDisabled = true, true
+		return nil
+	}
+	if reason := ktlsDisabledReason(); reason != "" {
+		c.ktlsState.TXReason = reason
+		c.ktlsState.RXReason = reason
+		c.ktlsState.TXDisabled, c.ktlsState.RXDisabled = true, true
+		return nil
+	}
+	if supported, reason := ktlsProbe(); !supported {
+		c.ktlsState.TXReason = "kernel does not support TLS"
+		if reason != "" {
+			c.ktlsState.TXReason = "environment unsupported: " + reason
+		}
+		c.ktlsState.RXReason = c.ktlsState.TXReason
+		return nil
+	}
+	if reason := ktlsLostReason(); reason != "" {
+		c.ktlsState.TXReason = "environment unsupported: " + reason
+		c.ktlsState.RXReason = c.ktlsState.TXReason
+		return nil
+	}
+	if c.config.KTLSSkipLocal && isLocalPeer(c.conn) {
+		c.debugln("kTLS: skipped, peer is on the same host")
+		c.ktlsState.TXReason = "peer is on the same host"
+		c.ktlsState.RXReason = c.ktlsState.TXReason
+		return nil
+	}
+	kc, ok := ktlsCipherForSuite(cipherSuiteID)
+	if !ok {
+		c.ktlsState.TXReason = "cipher suite " + CipherSuiteName(cipherSuiteID) + " is not supported"
+		c.ktlsState.RXReason = c.ktlsState.TXReason
+		return nil
+	}
+	if err := c.ktlsEnableTX(kc, outKey, outIV, txCipher); err != nil {
+		return err
+	}
+	if (c.config.KTLSDeferRX || c.ktlsDeferRX) && !c.config.KTLSRXDisabled {
+		c.debugln("kTLS: TLS_RX deferred until the first Read")
+		c.ktlsDeferRX = true
+		c.ktlsState.RXReason = "deferred until the first Read"
+		return nil
+	}
+	if err := c.ktlsEnableRX(kc, inKey, inIV, rxCipher); err != nil {
+		if !c.ktlsState.TXEnabled {
+			return err
+		}
+		// TX is already offloaded and the two directions are
+		// independent, so keep decrypting in user space.
+		c.ktlsFallbackRX(err)
 	}
 	return nil
 }
 
-func ktlsReadRecord(c *net.TCPConn, b []byte) (recordType, int, error) {
-	// cmsg for record type
-	buffer := make([]byte, unix.CmsgSpace(1))
-	cmsg := (*unix.Cmsghdr)(unsafe.Pointer(&buffer[0]))
-	cmsg.SetLen(unix.CmsgLen(1))
+// ktlsCheckKeyUpdate returns an error if a received KeyUpdate can't be
+// followed because c.in, or c.out if the peer requested an update too, is
+// offloaded to a kernel that can't replace its keys. Older kernels keep
+// the old keys, so the connection can't continue.
+func (c *Conn) ktlsCheckKeyUpdate(updateRequested bool) error {
+	if c.ktlsAdopted {
+		return errors.New("tls: received KeyUpdate on an adopted connection, without its traffic secrets")
+	}
+	if kTLSSupportKeyUpdate {
+		return nil
+	}
+	if _, ok := c.in.cipher.(kTLSCipher); ok {
+		return errors.New("tls: received KeyUpdate, but the kernel can't replace the TLS_RX key")
+	}
+	if _, ok := c.out.cipher.(kTLSCipher); ok && updateRequested {
+		return errors.New("tls: peer requested KeyUpdate, but the kernel can't replace the TLS_TX key")
+	}
+	return nil
+}
 
-	var iov unix.Iovec
-	iov.Base = &b[0]
-	iov.SetLen(len(b))
+// ktlsRekey programs the keys derived from a KeyUpdate traffic secret into
+// the kernel for the offloaded half connection hc, and updates hc to match.
+// The kernel restarts the record sequence, like a user-space rekey does.
+func (c *Conn) ktlsRekey(hc *halfConn, suite *cipherSuiteTLS13, secret []byte) error {
+	kc, ok := ktlsCipherForSuite(c.cipherSuite)
+	if !ok {
+		return errors.New("tls: internal error: offloaded cipher suite not supported by kernel TLS")
+	}
+	opt, direction, syscallName := TLS_RX, "rx", "setsockopt(TLS_RX)"
+	if hc == &c.out {
+		opt, direction, syscallName = TLS_TX, "tx", "setsockopt(TLS_TX)"
+	}
+	key, iv := suite.trafficKey(secret)
+	defer wipe(key)
+	defer wipe(iv)
+	var seq [8]byte
+	if err := kc.enable(c.ktlsConn(), kc.version, opt, true, c.config.KTLSSeccompCompat, key, iv, seq[:]); err != nil {
+		err = &KTLSSyscallError{Syscall: syscallName, Err: err}
+		c.debugln("kTLS: key update failed:", err)
+		c.logWarn("tls: updating kernel TLS keys failed", "direction", direction, "error", err)
+		recordKTLSError(err)
+		return err
+	}
+	hc.trafficSecret, hc.seq = secret, seq
+	if n := c.config.KTLSVerifyInterval; n > 0 {
+		if hc == &c.out {
+			c.ktlsVerifyTX = newKTLSVerifier(n, TLS_TX, kc.version, suite.aead(key, iv), seq, c.config.KTLSSeccompCompat)
+		} else {
+			c.ktlsVerifyRX = newKTLSVerifier(n, TLS_RX, kc.version, suite.aead(key, iv), seq, c.config.KTLSSeccompCompat)
+		}
+	}
+	c.debugln("kTLS: keys updated, direction:", direction)
+	c.logInfo("tls: kernel TLS keys updated", "direction", direction)
+	return nil
+}
 
-	var msg unix.Msghdr
-	msg.Control = &buffer[0]
-	msg.Controllen = cmsg.Len
-	msg.Iov = &iov
-	msg.Iovlen = 1
+// enableDeferredKTLSRX programs TLS_RX for a connection that postponed it
+// with Config.KTLSDeferRX. It does nothing until every record read so far
+// has been consumed, since the kernel can only take over at a record
+// boundary of the socket stream. c.in must be locked.
+func (c *Conn) enableDeferredKTLSRX() {
+	if !c.ktlsDeferRX || c.in.err != nil ||
+		c.input.Len() > 0 || c.rawInput.Len() > 0 || c.hand.Len() > 0 {
+		return
+	}
+	c.ktlsDeferRX = false
+	c.ktlsReport.Store(true)
 
-	rwc, err := c.SyscallConn()
+	kc, ok := ktlsCipherForSuite(c.cipherSuite)
+	if !ok {
+		return
+	}
+	// The handshake is over, so a failure here must not break the
+	// connection: user space simply keeps decrypting.
+	c.handshakeMutex.Lock()
+	defer c.handshakeMutex.Unlock()
+	if err := c.ktlsEnableRX(kc, c.in.key, c.in.iv, &c.in.cipher); err != nil {
+		c.ktlsFallbackRX(err)
+	}
+	c.in.wipeKey()
+	if err := c.ktlsCheckRequired(); err != nil {
+		c.in.setErrorLocked(err)
+	}
+}
+
+// ktlsReadRecord reads a record from the socket with TLS_RX enabled into b.
+// c.in must be locked.
+func (c *Conn) ktlsReadRecord(b []byte) (recordType, int, error) {
+	return c.ktlsRecvRecord(b, 0)
+}
+
+// msgWaitAll asks recvmsg to fill the buffer across records.
+const msgWaitAll = unix.MSG_WAITALL
+
+// ktlsRecvState holds the arguments and results of the recvmsg calls that
+// receive records with kernel TLS RX. It is kept on the Conn, with the
+// callback passed to the socket's RawConn bound once, so that receiving a
+// record doesn't allocate.
+type ktlsRecvState struct {
+	rc            syscall.RawConn
+	seccompCompat bool
+	recv          func(fd uintptr) bool
+	// ringFailed is set once receiving with io_uring was found not to
+	// work, see Conn.ktlsRingReceive.
+	ringFailed bool
+
+	// cmsgBuf backs the control message, aligned for a unix.Cmsghdr.
+	cmsgBuf [4]uint64
+	iov     unix.Iovec
+	msg     unix.Msghdr
+
+	b     []byte
+	flags int
+	n     int
+	err   error
+
+	// The iovecs and buffers of ReadVectored, see receiveVectored.
+	vec  []unix.Iovec
+	bufs [][]byte
+
+	// The recvmmsg arguments and results of ReadRecords, one entry per
+	// message, see receiveBatch.
+	recvBatch func(fd uintptr) bool
+	msgs      []mmsghdr
+	iovs      []unix.Iovec
+	cmsgs     [][4]uint64
+	types     []recordType
+	lens      []int
+	wait      bool
+}
+
+func newKTLSRecvState(sock syscall.Conn, seccompCompat bool) (*ktlsRecvState, error) {
+	rc, err := sock.SyscallConn()
 	if err != nil {
-		return 0, 0, err
+		return nil, err
 	}
+	r := &ktlsRecvState{rc: rc, seccompCompat: seccompCompat}
+	r.recv = r.recvmsg
+	r.recvBatch = r.recvmmsg
+	return r, nil
+}
 
-	var n int
-	err0 := rwc.Read(func(fd uintptr) bool {
-		flags := 0
-		n, err = recvmsg(fd, &msg, flags)
-		if err == unix.EAGAIN {
-			// data is not ready, goroutine will be parked
-			return false
+// cmsg returns the control message buffer, large enough for the record
+// type.
+func (r *ktlsRecvState) cmsg() []byte {
+	return unsafe.Slice((*byte)(unsafe.Pointer(&r.cmsgBuf[0])), unix.CmsgSpace(1))
+}
+
+// recvmsg is the RawConn.Read callback that receives a record into r.b.
+func (r *ktlsRecvState) recvmsg(fd uintptr) bool {
+	if r.seccompCompat {
+		// If seccompCompat is set, recvmsg is called through the x/sys
+		// wrapper, see Config.KTLSSeccompCompat.
+		if r.bufs != nil {
+			r.n, _, _, _, r.err = unix.RecvmsgBuffers(int(fd), r.bufs, r.cmsg(), r.flags)
+		} else {
+			r.n, _, _, _, r.err = unix.Recvmsg(int(fd), r.b, r.cmsg(), r.flags)
 		}
-		// n should not be zero when err == nil
-		if err == nil && n == 0 {
-			err = io.EOF
+	} else {
+		r.n, r.err = recvmsg(fd, &r.msg, r.flags)
+	}
+	if r.err == unix.EAGAIN {
+		// data is not ready, goroutine will be parked
+		return false
+	}
+	if r.err != nil {
+		r.err = &KTLSSyscallError{Syscall: "recvmsg", Err: r.err}
+	}
+	// n should not be zero when err == nil
+	if r.err == nil && r.n == 0 {
+		r.err = io.EOF
+	}
+	return true
+}
+
+// receive receives the next record into b with recvmsg, leaving its type
+// in the control message. It returns the payload length.
+func (r *ktlsRecvState) receive(b []byte, flags int) (int, error) {
+	r.iov.Base = &b[0]
+	r.iov.SetLen(len(b))
+	r.b = b
+	n, err := r.receiveIovecs(&r.iov, 1, flags)
+	// Don't keep the caller's buffer alive.
+	r.b, r.iov.Base = nil, nil
+	return n, err
+}
+
+// receiveVectored is receive, scattering the record into bufs in order.
+// Empty buffers are skipped. bufs holds at most maxReadVectoredBufs
+// buffers, not all empty.
+func (r *ktlsRecvState) receiveVectored(bufs [][]byte, flags int) (int, error) {
+	r.vec = r.vec[:0]
+	for _, b := range bufs {
+		if len(b) == 0 {
+			continue
 		}
-		return true
-	})
+		iov := unix.Iovec{Base: &b[0]}
+		iov.SetLen(len(b))
+		r.vec = append(r.vec, iov)
+	}
+	r.bufs = bufs
+	n, err := r.receiveIovecs(&r.vec[0], len(r.vec), flags)
+	r.bufs = nil
+	for i := range r.vec {
+		r.vec[i].Base = nil
+	}
+	return n, err
+}
+
+// receiveIovecs receives the next record into the iovlen iovecs at iov.
+func (r *ktlsRecvState) receiveIovecs(iov *unix.Iovec, iovlen int, flags int) (int, error) {
+	buffer := r.cmsg()
+	cmsg := (*unix.Cmsghdr)(unsafe.Pointer(&buffer[0]))
+	*cmsg = unix.Cmsghdr{}
+	cmsg.SetLen(unix.CmsgLen(1))
+
+	r.msg.Control = &buffer[0]
+	r.msg.Controllen = cmsg.Len
+	r.msg.Iov = iov
+	r.msg.SetIovlen(iovlen)
+	r.flags, r.n, r.err = flags, 0, nil
+
+	err0 := r.rc.Read(r.recv)
+	n, err := r.n, r.err
+	r.msg.Iov = nil
 	if err0 != nil {
 		err = err0
 	}
+	return n, err
+}
+
+// ktlsRecvRecord is ktlsReadRecord with the given recvmsg flags. c.in must
+// be locked.
+func (c *Conn) ktlsRecvRecord(b []byte, flags int) (recordType, int, error) {
+	r, err := c.ktlsRecvStateLocked()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var (
+		n       int
+		handled bool
+	)
+	if c.config.KTLSRXIOUring {
+		// The ring ignores MSG_WAITALL, so ReadExact gets a record at a
+		// time.
+		n, err, handled = c.ktlsRingReceive(r, b)
+	}
+	if !handled {
+		n, err = r.receive(b, flags)
+	}
+	return c.ktlsReceived(r, n, err)
+}
+
+// ktlsRecvRecordVectored is ktlsReadRecord, scattering the record into
+// bufs. c.in must be locked.
+func (c *Conn) ktlsRecvRecordVectored(bufs [][]byte) (recordType, int, error) {
+	r, err := c.ktlsRecvStateLocked()
+	if err != nil {
+		return 0, 0, err
+	}
+	n, err := r.receiveVectored(bufs, 0)
+	return c.ktlsReceived(r, n, err)
+}
+
+// ktlsReceived returns the type and length of the record r received n
+// bytes of, or the error receiving it. c.in must be locked.
+func (c *Conn) ktlsReceived(r *ktlsRecvState, n int, err error) (recordType, int, error) {
 	if err != nil {
 		Debugln("kTLS: recvmsg failed:", err)
 		// fix bufio panic due to n == -1
@@ -436,56 +914,73 @@ func ktlsReadRecord(c *net.TCPConn, b []byte) (recordType, int, error) {
 		return 0, 0, nil
 	}
 
+	typ, err := c.ktlsRecordType(r.cmsg())
+	if err != nil {
+		return 0, 0, err
+	}
+	return typ, n, nil
+}
+
+// ktlsRecvStateLocked returns c.ktlsRecv, creating it on first use. c.in
+// must be locked.
+func (c *Conn) ktlsRecvStateLocked() (*ktlsRecvState, error) {
+	if c.ktlsRecv == nil {
+		r, err := newKTLSRecvState(c.ktlsConn(), c.config.KTLSSeccompCompat)
+		if err != nil {
+			return nil, err
+		}
+		c.ktlsRecv = r
+	}
+	return c.ktlsRecv, nil
+}
+
+// ktlsRecordType returns the record type in the control message buffer of
+// a received record, and counts the record. c.in must be locked.
+func (c *Conn) ktlsRecordType(buffer []byte) (recordType, error) {
+	cmsg := (*unix.Cmsghdr)(unsafe.Pointer(&buffer[0]))
 	if cmsg.Level != SOL_TLS {
 		Debugf("kTLS: unsupported cmsg level: %d", cmsg.Level)
-		return 0, 0, fmt.Errorf("unsupported cmsg level: %d", cmsg.Level)
+		return 0, fmt.Errorf("unsupported cmsg level: %d", cmsg.Level)
 	}
 	if cmsg.Type != TLS_GET_RECORD_TYPE {
 		Debugf("kTLS: unsupported cmsg type: %d", cmsg.Type)
-		return 0, 0, fmt.Errorf("unsupported cmsg type: %d", cmsg.Type)
+		return 0, fmt.Errorf("unsupported cmsg type: %d", cmsg.Type)
 	}
 	typ := recordType(buffer[unix.SizeofCmsghdr])
-	Debugf("kTLS: recvmsg, type: %d, payload len: %d", typ, n)
-	return typ, n, nil
-}
-
-func ktlsReadDataFromRecord(c *net.TCPConn, b []byte) (int, error) {
-	typ, n, err := ktlsReadRecord(c, b)
-	if err != nil {
-		return n, err
-	}
-	switch typ {
-	case recordTypeAlert:
-		if n < 2 {
-			return 0, fmt.Errorf("ktls alert payload too short")
-		}
-		if alert(b[1]) == alertCloseNotify {
-			return 0, io.EOF
+	c.stats.ktlsRecordsRead.Add(1)
+	if typ != recordTypeApplicationData {
+		c.stats.ktlsControlRecordsRead.Add(1)
+		if c.ktlsState.RXNoPad {
+			c.ktlsNoPadViolation()
 		}
-		return 0, fmt.Errorf("unsupported ktls alert type: %d", b[0])
-	case recordTypeApplicationData:
-		return n, nil
-	default:
-		return 0, fmt.Errorf("unsupported ktls record type: %d", typ)
 	}
+	return typ, nil
 }
 
-func recvmsg(fd uintptr, msg *unix.Msghdr, flags int) (n int, err error) {
-	r0, _, e1 := unix.Syscall(unix.SYS_RECVMSG, fd, uintptr(unsafe.Pointer(msg)), uintptr(flags))
-	n = int(r0)
-	if e1 != 0 {
-		err = errnoErr(e1)
+// ktlsNoPadMaxViolations is the number of non-application data records,
+// each decrypted twice by a kernel expecting no padding, after which
+// TLS_RX_EXPECT_NO_PAD is turned off. It leaves room for the session
+// tickets servers send after the handshake.
+const ktlsNoPadMaxViolations = 4
+
+// ktlsNoPadViolation records that the kernel had to decrypt a record again
+// because TLS_RX_EXPECT_NO_PAD was set, and turns it off if that keeps
+// happening. The kernel counts these in TlsRxNoPadViolation. c.in must be
+// locked.
+func (c *Conn) ktlsNoPadViolation() {
+	if c.ktlsNoPadOff.Load() {
+		return
 	}
-	return
-}
-
-func sendmsg(fd uintptr, msg *unix.Msghdr, flags int) (n int, err error) {
-	r0, _, e1 := unix.Syscall(unix.SYS_SENDMSG, fd, uintptr(unsafe.Pointer(msg)), uintptr(flags))
-	n = int(r0)
-	if e1 != 0 {
-		err = errnoErr(e1)
+	c.ktlsNoPadViolations++
+	if c.ktlsNoPadViolations < ktlsNoPadMaxViolations {
+		return
 	}
-	return
+	if err := ktlsSetRxExpectNoPad(c.ktlsConn(), false); err != nil {
+		c.logWarn("tls: turning off TLS_RX_EXPECT_NO_PAD failed", "error", err)
+		return
+	}
+	c.ktlsNoPadOff.Store(true)
+	c.debugln("kTLS: TLS_RX_EXPECT_NO_PAD turned off, peer sends records that are not application data")
 }
 
 // Do the interface allocations only once for common
@@ -512,7 +1007,10 @@ func errnoErr(e unix.Errno) error {
 	return e
 }
 
-func ktlsSendCtrlMessage(c *net.TCPConn, typ recordType, b []byte) (int, error) {
+// ktlsSendCtrlMessage sends a record of type typ on a socket with TLS_TX
+// enabled. If seccompCompat is set, sendmsg is called through the x/sys
+// wrapper, see Config.KTLSSeccompCompat.
+func ktlsSendCtrlMessage(c syscall.Conn, typ recordType, b []byte, seccompCompat bool) (int, error) {
 	// cmsg for record type
 	buffer := make([]byte, unix.CmsgSpace(1))
 	cmsg := (*unix.Cmsghdr)(unsafe.Pointer(&buffer[0]))
@@ -536,16 +1034,30 @@ func ktlsSendCtrlMessage(c *net.TCPConn, typ recordType, b []byte) (int, error)
 		return 0, err
 	}
 
+	// The poller parks the goroutine on EAGAIN until the socket is
+	// writable or the write deadline expires. A partial send leaves the
+	// rest of the message to further records of the same type.
 	var n int
 	err0 := rwc.Write(func(fd uintptr) bool {
-		flags := 0
-		n, err = sendmsg(fd, &msg, flags)
-		if err == unix.EAGAIN {
-			// data is not ready, goroutine will be parked
-			return false
-		}
-		if err != nil {
-			Debugln("kTLS: sendmsg failed:", err)
+		for n < len(b) {
+			var m int
+			if seccompCompat {
+				m, err = unix.SendmsgN(int(fd), b[n:], buffer, nil, 0)
+			} else {
+				iov.Base = &b[n]
+				iov.SetLen(len(b) - n)
+				m, err = sendmsg(fd, &msg, 0)
+			}
+			if err == unix.EAGAIN {
+				// socket buffer is full, goroutine will be parked
+				return false
+			}
+			if err != nil {
+				Debugln("kTLS: sendmsg failed:", err)
+				err = &KTLSSyscallError{Syscall: "sendmsg", Err: err}
+				return true
+			}
+			n += m
 		}
 		return true
 	})
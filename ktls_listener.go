@@ -0,0 +1,227 @@
+package tls
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// defaultEagerHandshakeTimeout bounds the eager handshakes of a listener
+// made by NewKTLSListener when neither KTLSListenerOptions.HandshakeTimeout
+// nor Config.HandshakeTimeout is set, so that idle clients don't pin a
+// goroutine forever.
+const defaultEagerHandshakeTimeout = 10 * time.Second
+
+// defaultMaxPendingHandshakes bounds the connections a listener made by
+// NewKTLSListener holds with EagerHandshake when
+// KTLSListenerOptions.MaxPendingHandshakes is not set.
+const defaultMaxPendingHandshakes = 256
+
+// KTLSListenerOptions configures a listener made by NewKTLSListener.
+type KTLSListenerOptions struct {
+	// EagerHandshake performs the handshake of each connection before
+	// Accept returns it. The handshakes run concurrently, so that a slow
+	// client doesn't hold up the others. Connections whose handshake fails
+	// are closed and never returned.
+	EagerHandshake bool
+
+	// HandshakeTimeout bounds the time from accepting a connection with
+	// EagerHandshake to returning it from Accept: connections still in
+	// their handshake or not taken by Accept by then are closed. If zero,
+	// Config.HandshakeTimeout applies, or 10 seconds if that is zero too.
+	HandshakeTimeout time.Duration
+
+	// MaxPendingHandshakes bounds the connections accepted with
+	// EagerHandshake that Accept has not returned yet, whether in their
+	// handshake or waiting for Accept. Once reached, no more are accepted
+	// from the inner listener, leaving them in the kernel's listen backlog,
+	// until one is returned or closed. If zero, 256 is used.
+	MaxPendingHandshakes int
+
+	// OnAccept, if not nil, is called with the kernel TLS outcome of each
+	// connection, once it is final: after the handshake, or with
+	// Config.KTLSDeferRX after the first Read. It is called in addition to
+	// Config.OnKTLSEnabled and Config.OnKTLSFallback, including for the
+	// Configs returned by Config.GetConfigForClient.
+	OnAccept func(conn *Conn, state KTLSState)
+
+	// OnHandshakeError, if not nil, is called when an eager handshake
+	// fails, before the connection is closed.
+	OnHandshakeError func(conn *Conn, err error)
+}
+
+// NewKTLSListener returns a Listener that wraps the connections accepted
+// from inner with Server, like NewListener, reports the kernel TLS outcome
+// of each one to opts.OnAccept and, with opts.EagerHandshake, completes
+// their handshakes before returning them from Accept.
+func NewKTLSListener(inner net.Listener, config *Config, opts KTLSListenerOptions) net.Listener {
+	l := &ktlsListener{
+		Listener: inner,
+		config:   config,
+		opts:     opts,
+	}
+	if opts.OnAccept != nil {
+		l.config = reportingConfig(config, opts.OnAccept)
+	}
+	if opts.EagerHandshake {
+		l.timeout = opts.HandshakeTimeout
+		if l.timeout == 0 {
+			l.timeout = config.HandshakeTimeout
+		}
+		if l.timeout == 0 {
+			l.timeout = defaultEagerHandshakeTimeout
+		}
+		pending := opts.MaxPendingHandshakes
+		if pending <= 0 {
+			pending = defaultMaxPendingHandshakes
+		}
+		l.pending = make(chan struct{}, pending)
+		l.conns = make(chan *Conn)
+		l.done = make(chan struct{})
+		go l.acceptLoop()
+	}
+	return l
+}
+
+// reportingConfig returns a copy of config whose kernel TLS callbacks, and
+// those of the Configs returned by its GetConfigForClient, also call f.
+func reportingConfig(config *Config, f func(*Conn, KTLSState)) *Config {
+	config = config.Clone()
+	onEnabled, onFallback := config.OnKTLSEnabled, config.OnKTLSFallback
+	config.OnKTLSEnabled = func(c *Conn, state KTLSState) {
+		if onEnabled != nil {
+			onEnabled(c, state)
+		}
+		f(c, state)
+	}
+	config.OnKTLSFallback = func(c *Conn, state KTLSState) {
+		if onFallback != nil {
+			onFallback(c, state)
+		}
+		f(c, state)
+	}
+	if getConfig := config.GetConfigForClient; getConfig != nil {
+		config.GetConfigForClient = func(chi *ClientHelloInfo) (*Config, error) {
+			cfg, err := getConfig(chi)
+			if cfg == nil || err != nil {
+				return cfg, err
+			}
+			cfg = reportingConfig(cfg, f)
+			cfg.GetConfigForClient = nil
+			return cfg, nil
+		}
+	}
+	return config
+}
+
+// A ktlsListener is a Listener made by NewKTLSListener.
+type ktlsListener struct {
+	net.Listener
+	config *Config
+	opts   KTLSListenerOptions
+
+	// With EagerHandshake, acceptLoop sends the connections that completed
+	// their handshake on conns. done is closed once it stopped, after err
+	// is set. pending holds a slot for each connection accepted from
+	// Listener and not yet returned or closed, and timeout is the
+	// deadline of those connections.
+	conns   chan *Conn
+	done    chan struct{}
+	once    sync.Once
+	err     error
+	pending chan struct{}
+	timeout time.Duration
+}
+
+// Accept waits for and returns the next incoming TLS connection, of type
+// *Conn.
+func (l *ktlsListener) Accept() (net.Conn, error) {
+	if l.conns == nil {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		return Server(c, l.config), nil
+	}
+	select {
+	case c := <-l.conns:
+		// The deadline is cleared here, rather than by handshake, so that
+		// it can't override one set by the caller.
+		c.SetDeadline(time.Time{})
+		return c, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+// Close closes the inner listener. Connections still in their eager
+// handshake are closed once it completes.
+func (l *ktlsListener) Close() error {
+	err := l.Listener.Close()
+	if l.done != nil {
+		l.stop(net.ErrClosed)
+	}
+	return err
+}
+
+func (l *ktlsListener) stop(err error) {
+	l.once.Do(func() {
+		l.err = err
+		close(l.done)
+	})
+}
+
+func (l *ktlsListener) acceptLoop() {
+	var delay time.Duration
+	for {
+		select {
+		case l.pending <- struct{}{}:
+		case <-l.done:
+			return
+		}
+		c, err := l.Listener.Accept()
+		if err != nil {
+			<-l.pending
+			// Back off and retry as net/http does on temporary errors,
+			// which include running out of file descriptors (EMFILE and
+			// ENFILE) and connections reset before they were accepted.
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Temporary() {
+				if delay = 2*delay + 5*time.Millisecond; delay > time.Second {
+					delay = time.Second
+				}
+				time.Sleep(delay)
+				continue
+			}
+			l.stop(err)
+			return
+		}
+		delay = 0
+		go l.handshake(Server(c, l.config))
+	}
+}
+
+// handshake completes the handshake of c and hands it to Accept, or closes
+// it once l.timeout has passed since it was accepted.
+func (l *ktlsListener) handshake(c *Conn) {
+	defer func() { <-l.pending }()
+	deadline := time.Now().Add(l.timeout)
+	c.SetDeadline(deadline)
+	if err := c.Handshake(); err != nil {
+		if l.opts.OnHandshakeError != nil {
+			l.opts.OnHandshakeError(c, err)
+		}
+		c.Close()
+		return
+	}
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case l.conns <- c:
+	case <-timer.C:
+		c.Close()
+	case <-l.done:
+		c.Close()
+	}
+}
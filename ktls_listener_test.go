@@ -0,0 +1,194 @@
+package tls
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestKTLSListenerOnAccept(t *testing.T) {
+	for _, eager := range []bool{false, true} {
+		states := make(chan KTLSState, 1)
+		fallbacks := 0
+		config := testConfig.Clone()
+		config.OnKTLSFallback = func(*Conn, KTLSState) { fallbacks++ }
+		config.OnKTLSEnabled = config.OnKTLSFallback
+		ln := NewKTLSListener(newLocalListener(t), config, KTLSListenerOptions{
+			EagerHandshake: eager,
+			OnAccept:       func(c *Conn, state KTLSState) { states <- state },
+		})
+
+		errs := make(chan error, 1)
+		go func() {
+			c, err := Dial("tcp", ln.Addr().String(), testConfig)
+			if err == nil {
+				c.Close()
+			}
+			errs <- err
+		}()
+		c, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.(*Conn).ConnectionState().HandshakeComplete; got != eager {
+			t.Errorf("eager %v: HandshakeComplete = %v after Accept", eager, got)
+		}
+		if err := c.(*Conn).Handshake(); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+		state := <-states
+		if !state.TXEnabled && state.TXReason == "" {
+			t.Errorf("eager %v: OnAccept state %+v", eager, state)
+		}
+		if fallbacks != 1 {
+			t.Errorf("eager %v: Config callbacks called %d times, want 1", eager, fallbacks)
+		}
+		c.Close()
+		ln.Close()
+	}
+}
+
+// emfileListener fails its first Accept with EMFILE, as a process out of
+// file descriptors does.
+type emfileListener struct {
+	net.Listener
+	failed bool
+}
+
+func (l *emfileListener) Accept() (net.Conn, error) {
+	if !l.failed {
+		l.failed = true
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept4", syscall.EMFILE)}
+	}
+	return l.Listener.Accept()
+}
+
+func TestKTLSListenerAcceptRetry(t *testing.T) {
+	inner := &emfileListener{Listener: newLocalListener(t)}
+	ln := NewKTLSListener(inner, testConfig, KTLSListenerOptions{EagerHandshake: true})
+	defer ln.Close()
+
+	go func() {
+		c, err := Dial("tcp", ln.Addr().String(), testConfig)
+		if err == nil {
+			c.Close()
+		}
+	}()
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept after EMFILE = %v, want a connection", err)
+	}
+	c.Close()
+	if !inner.failed {
+		t.Error("inner listener never failed")
+	}
+}
+
+func TestKTLSListenerEagerHandshakeError(t *testing.T) {
+	failed := make(chan error, 1)
+	ln := NewKTLSListener(newLocalListener(t), testConfig, KTLSListenerOptions{
+		EagerHandshake:   true,
+		HandshakeTimeout: time.Second,
+		OnHandshakeError: func(c *Conn, err error) { failed <- err },
+	})
+	defer ln.Close()
+
+	bad, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	defer bad.Close()
+	if err := <-failed; err == nil {
+		t.Error("OnHandshakeError called without an error")
+	}
+
+	go func() {
+		if c, err := Dial("tcp", ln.Addr().String(), testConfig); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.(*Conn).ConnectionState().HandshakeComplete {
+		t.Error("Accept returned a connection before its handshake")
+	}
+	c.Close()
+
+	ln.Close()
+	if _, err := ln.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Accept after Close = %v, want net.ErrClosed", err)
+	}
+}
+
+func TestKTLSListenerMaxPendingHandshakes(t *testing.T) {
+	failed := make(chan error, 1)
+	ln := NewKTLSListener(newLocalListener(t), testConfig, KTLSListenerOptions{
+		EagerHandshake:       true,
+		HandshakeTimeout:     200 * time.Millisecond,
+		MaxPendingHandshakes: 1,
+		OnHandshakeError:     func(c *Conn, err error) { failed <- err },
+	})
+	defer ln.Close()
+
+	// A client that never sends its ClientHello holds the only slot until
+	// its handshake times out.
+	idle, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	go func() {
+		if c, err := Dial("tcp", ln.Addr().String(), testConfig); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	select {
+	case err := <-failed:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("idle handshake failed with %v, want a timeout", err)
+		}
+	default:
+		t.Error("Accept returned a connection while the idle one held the only slot")
+	}
+}
+
+func TestKTLSListenerGetConfigForClient(t *testing.T) {
+	states := make(chan KTLSState, 1)
+	config := &Config{GetConfigForClient: func(*ClientHelloInfo) (*Config, error) {
+		return testConfig, nil
+	}}
+	ln := NewKTLSListener(newLocalListener(t), config, KTLSListenerOptions{
+		EagerHandshake: true,
+		OnAccept:       func(c *Conn, state KTLSState) { states <- state },
+	})
+	defer ln.Close()
+	go func() {
+		if c, err := Dial("tcp", ln.Addr().String(), testConfig); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	select {
+	case <-states:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnAccept not called for a Config from GetConfigForClient")
+	}
+}
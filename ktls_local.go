@@ -0,0 +1,17 @@
+package tls
+
+import "net"
+
+// isLocalPeer reports whether the peer of conn is on the same host: its
+// remote address is a loopback address or conn's own local address.
+func isLocalPeer(conn net.Conn) bool {
+	remote, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	if remote.IP.IsLoopback() {
+		return true
+	}
+	local, ok := conn.LocalAddr().(*net.TCPAddr)
+	return ok && local.IP.Equal(remote.IP)
+}
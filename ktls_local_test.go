@@ -0,0 +1,34 @@
+package tls
+
+import (
+	"net"
+	"testing"
+)
+
+type addrConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c addrConn) LocalAddr() net.Addr  { return c.local }
+func (c addrConn) RemoteAddr() net.Addr { return c.remote }
+
+func TestIsLocalPeer(t *testing.T) {
+	tcp := func(ip string) net.Addr { return &net.TCPAddr{IP: net.ParseIP(ip), Port: 443} }
+	tests := []struct {
+		local, remote net.Addr
+		want          bool
+	}{
+		{tcp("127.0.0.1"), tcp("127.0.0.1"), true},
+		{tcp("::1"), tcp("::1"), true},
+		{tcp("10.0.0.1"), tcp("127.0.0.53"), true},
+		{tcp("10.0.0.1"), tcp("10.0.0.1"), true},
+		{tcp("10.0.0.1"), tcp("10.0.0.2"), false},
+		{&net.UnixAddr{Name: "a"}, &net.UnixAddr{Name: "b"}, false},
+	}
+	for _, tt := range tests {
+		if got := isLocalPeer(addrConn{local: tt.local, remote: tt.remote}); got != tt.want {
+			t.Errorf("isLocalPeer(%v -> %v) = %v, want %v", tt.local, tt.remote, got, tt.want)
+		}
+	}
+}
@@ -3,15 +3,26 @@
 package tls
 
 import (
+	"fmt"
 	"log"
 )
 
 const Dev = true
 
+// Debugln and Debugf log messages that are not about a connection to the
+// Logger set with SetLogger or, without one, with the log package.
 func Debugln(a ...interface{}) {
+	if l := globalLogger(); l != nil {
+		l.Debug(sprintln(a...))
+		return
+	}
 	log.Println(a...)
 }
 
 func Debugf(format string, a ...interface{}) {
+	if l := globalLogger(); l != nil {
+		l.Debug(fmt.Sprintf(format, a...))
+		return
+	}
 	log.Printf(format, a...)
 }
@@ -1,8 +1,21 @@
 //go:build !debug
+
 package tls
 
+import "fmt"
+
 const Dev = false
 
-func Debugln(a ...interface{}) {}
+// Debugln and Debugf pass messages that are not about a connection to the
+// Logger set with SetLogger, if any.
+func Debugln(a ...interface{}) {
+	if l := globalLogger(); l != nil {
+		l.Debug(sprintln(a...))
+	}
+}
 
-func Debugf(format string, a ...interface{}) {}
\ No newline at end of file
+func Debugf(format string, a ...interface{}) {
+	if l := globalLogger(); l != nil {
+		l.Debug(fmt.Sprintf(format, a...))
+	}
+}
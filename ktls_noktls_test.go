@@ -0,0 +1,29 @@
+//go:build linux && noktls
+// +build linux,noktls
+
+package tls
+
+import "testing"
+
+func TestNoKTLSBuildTag(t *testing.T) {
+	if got, want := KTLSUnsupportedReason(), "kernel TLS excluded by the noktls build tag"; got != want {
+		t.Errorf("KTLSUnsupportedReason() = %q, want %q", got, want)
+	}
+	client, server := localPipe(t)
+	defer client.Close()
+	defer server.Close()
+	c := Client(client, testConfig)
+	s := Server(server, testConfig)
+	errs := make(chan error, 1)
+	go func() { errs <- c.Handshake() }()
+	if err := s.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	state := c.ConnectionState().KTLS
+	if state.TXEnabled || state.RXEnabled || state.TXReason == "" {
+		t.Errorf("KTLSState = %+v", state)
+	}
+}
@@ -1,26 +1,201 @@
-//go:build !linux
-// +build !linux
+//go:build !linux || noktls
+// +build !linux noktls
 
 package tls
 
 import (
+	"errors"
+	"io"
 	"net"
+	"os"
+	"runtime"
+	"syscall"
 )
 
 const kTLSOverhead = 0
 
-func (c *Conn) enableKernelTLS(cipherSuiteID uint16, inKey, outKey, inIV, outIV []byte) error {
+// enableKernelTLS records in the connection's KTLSState why it stays in
+// user space. With Config.KTLSDeferEnable, it still defers until
+// EnableKTLS, so that callers behave the same everywhere.
+func (c *Conn) enableKernelTLS(cipherSuiteID uint16, inKey, outKey, inIV, outIV []byte, txCipher, rxCipher *any) error {
+	if c.config.KTLSDeferEnable && !c.isHandshakeComplete.Load() {
+		c.ktlsDeferEnable = true
+		c.ktlsState.TXReason = "deferred until EnableKTLS"
+		c.ktlsState.RXReason = c.ktlsState.TXReason
+		return nil
+	}
+	c.ktlsState.TXReason = "environment unsupported: " + KTLSUnsupportedReason()
+	if mode, source := c.ktlsMode(); mode == KTLSModeOff {
+		c.ktlsState.TXReason = "disabled by " + source
+		c.ktlsState.TXDisabled, c.ktlsState.RXDisabled = true, true
+	}
+	c.ktlsState.RXReason = c.ktlsState.TXReason
+	return c.ktlsCheckRequired()
+}
+
+func (c *Conn) enableDeferredKTLSRX() {}
+
+func (c *Conn) ktlsSaveState() error { return nil }
+
+func (c *Conn) ktlsReprogram() error { return nil }
+
+func (c *Conn) ktlsCheckKeyUpdate(updateRequested bool) error { return nil }
+
+func (c *Conn) ktlsRekey(hc *halfConn, suite *cipherSuiteTLS13, secret []byte) error {
+	return errors.New("tls: kernel TLS requires Linux")
+}
+
+func (v *ktlsVerifier) record(sock syscall.Conn, data []byte) error {
 	return nil
 }
 
-func ktlsSendCtrlMessage(c *net.TCPConn, typ recordType, b []byte) (int, error) {
+func ktlsSendCtrlMessage(c syscall.Conn, typ recordType, b []byte, seccompCompat bool) (int, error) {
 	panic("not implement")
 }
 
-func ktlsReadDataFromRecord(c *net.TCPConn, b []byte) (int, error) {
+func (c *Conn) ktlsReadRecord(b []byte) (recordType, int, error) {
 	panic("not implement")
 }
 
-func ktlsReadRecord(c *net.TCPConn, b []byte) (recordType, int, error) {
+// WriteTo copies application data from the connection to w until EOF or
+// an error. If w is a *LimitedWriter, see LimitWriter, at most N bytes are
+// copied and N is decreased accordingly.
+func (c *Conn) WriteTo(w io.Writer) (n int64, err error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	if err := c.flushCoalescedForRead(); err != nil {
+		return 0, err
+	}
+	return c.copyTo(w)
+}
+
+// ReadFrom copies r to the connection with pipelinedCopy.
+func (c *Conn) ReadFrom(r io.Reader) (n int64, err error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	return c.pipelinedCopy(r)
+}
+
+func (c *Conn) IsKTLSTXEnabled() bool { return false }
+
+func (c *Conn) IsKTLSRXEnabled() bool { return false }
+
+// Handover is only supported on Linux, where kernel TLS is available.
+func (c *Conn) Handover() (*os.File, KTLSInfo, error) {
+	return nil, KTLSInfo{}, errors.New("tls: Handover requires kernel TLS")
+}
+
+func (c *Conn) HandoverTo(uc *net.UnixConn) error {
+	return errors.New("tls: Handover requires kernel TLS")
+}
+
+// AdoptConn is only supported on Linux, where kernel TLS is available.
+func AdoptConn(uc *net.UnixConn, config *Config) (*Conn, error) {
+	return nil, errors.New("tls: AdoptConn requires kernel TLS")
+}
+
+func tcpSendQueueLen(conn net.Conn, unacked bool) (int, error) {
+	return 0, errors.New("tls: SyncSent requires Linux")
+}
+
+func tcpMaxSeg(conn net.Conn) int { return 0 }
+
+func ktlsReadCryptoInfo(sock syscall.Conn, dir KTLSDirection, compat bool) (KTLSCryptoInfo, error) {
+	return KTLSCryptoInfo{}, errors.New("tls: kernel TLS requires Linux")
+}
+
+// KTLSUnsupportedReason returns why kernel TLS is unavailable in this
+// environment, or the empty string if it can be used.
+func KTLSUnsupportedReason() string {
+	if runtime.GOOS == "linux" {
+		return "kernel TLS excluded by the noktls build tag"
+	}
+	return "kernel TLS requires Linux"
+}
+
+// RefreshKTLSSupport returns KTLSUnsupportedReason.
+func RefreshKTLSSupport() string {
+	return KTLSUnsupportedReason()
+}
+
+func tcpSegments(conn net.Conn, compat bool) (retransmits, sent uint64, err error) {
+	return 0, 0, errors.New("tls: TCP_INFO requires Linux")
+}
+
+func tlsDeviceResyncs() (uint64, error) {
+	return 0, errors.New("tls: tls_stat requires Linux")
+}
+
+func (c *Conn) sendfileSection(f *os.File, off, n int64) (written int64, err error, handled bool) {
+	return 0, nil, false
+}
+
+func (c *Conn) spliceLimited(dst syscall.Conn, n int64) (written int64, err error, handled bool) {
+	return 0, nil, false
+}
+
+func ktlsOffloadModes(conn net.Conn) (tx, rx KTLSOffloadMode, err error) {
+	return 0, 0, errors.New("tls: sock_diag requires Linux")
+}
+
+func offloadDevice(conn net.Conn) (offloadInterface, error) {
+	return offloadInterface{}, errors.New("tls: offload devices require Linux")
+}
+
+func tlsDeviceFeatures(iface string) (map[string]bool, error) {
+	return nil, errors.New("tls: ethtool requires Linux")
+}
+
+// KTLSStats is only supported on Linux.
+func KTLSStats() (KTLSStat, error) {
+	return KTLSStat{}, errors.New("tls: KTLSStats requires Linux")
+}
+
+// DeviceTLSStats is only supported on Linux, where NICs offload kernel TLS.
+func DeviceTLSStats(iface string) (map[string]uint64, error) {
+	return nil, errors.New("tls: DeviceTLSStats requires Linux")
+}
+
+const msgWaitAll = 0
+
+// ktlsRecvState and ktlsRingRecv are only used with kernel TLS, which
+// requires Linux.
+type ktlsRecvState struct{}
+type ktlsRingRecv struct{}
+
+func (c *Conn) ktlsCloseRing() {}
+func (c *Conn) ktlsWakeRing()  {}
+
+func (c *Conn) ktlsRecvRecord(b []byte, flags int) (recordType, int, error) {
+	panic("not implement")
+}
+
+func (c *Conn) ktlsRecvRecordVectored(bufs [][]byte) (recordType, int, error) {
 	panic("not implement")
 }
+
+func (c *Conn) ktlsRecvRecords(bufs [][]byte, wait bool) ([]recordType, []int, error) {
+	panic("not implement")
+}
+
+func bindAddressNoPort(network, address string, c syscall.RawConn) error {
+	return nil
+}
+
+// KTLSCapabilities reports no kernel TLS support, as it requires Linux.
+func KTLSCapabilities() KTLSHostCapabilities {
+	return KTLSHostCapabilities{UnsupportedReason: KTLSUnsupportedReason()}
+}
+
+// CurrentKTLSFeatures returns no features, as kernel TLS requires Linux.
+func CurrentKTLSFeatures() KTLSFeatures {
+	return KTLSFeatures{}
+}
+
+func (r *KTLSReport) gatherSystem() {}
+
+// SetSplicePipePool configures the pipes used to splice data from kernel
+// TLS sockets, which require Linux. It does nothing on other systems.
+func SetSplicePipePool(idle, size int) {}
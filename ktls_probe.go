@@ -0,0 +1,284 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+)
+
+// probeKTLSTransfer is the amount of data ProbeKTLS sends in each
+// direction, enough for several full records.
+const probeKTLSTransfer = 64<<10 + 123
+
+// probeKTLSTimeout bounds each connection made by ProbeKTLS.
+const probeKTLSTimeout = 10 * time.Second
+
+// A KTLSProbeReport is the outcome of ProbeKTLS.
+type KTLSProbeReport struct {
+	// UnsupportedReason is KTLSUnsupportedReason at the time of the probe.
+	UnsupportedReason string
+	// Results holds one entry per TLS version probed, TLS 1.2 first.
+	Results []KTLSProbeResult
+}
+
+// A KTLSProbeResult describes a connection made by ProbeKTLS.
+type KTLSProbeResult struct {
+	Version     uint16
+	CipherSuite uint16
+	// Client and Server are the kernel TLS states of the two ends.
+	Client, Server KTLSState
+	// Err is the error that failed the handshake or the transfers, if any.
+	Err error
+}
+
+// OK reports whether both ends offloaded both directions and the data
+// sent each way arrived intact.
+func (r KTLSProbeResult) OK() bool {
+	return r.Err == nil &&
+		r.Client.TXEnabled && r.Client.RXEnabled &&
+		r.Server.TXEnabled && r.Server.RXEnabled
+}
+
+// OK reports whether kernel TLS worked end-to-end for every TLS version.
+func (r KTLSProbeReport) OK() bool {
+	if len(r.Results) == 0 {
+		return false
+	}
+	for _, res := range r.Results {
+		if !res.OK() {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns a line per probed TLS version.
+func (r KTLSProbeReport) String() string {
+	var b strings.Builder
+	if r.UnsupportedReason != "" {
+		fmt.Fprintf(&b, "kernel TLS unsupported: %s\n", r.UnsupportedReason)
+	}
+	for _, res := range r.Results {
+		fmt.Fprintf(&b, "%s %s: ", probeVersionName(res.Version), CipherSuiteName(res.CipherSuite))
+		switch {
+		case res.Err != nil:
+			fmt.Fprintf(&b, "failed: %v\n", res.Err)
+		case res.OK():
+			b.WriteString("ok\n")
+		default:
+			fmt.Fprintf(&b, "not offloaded: %s\n", probeStateSummary(res))
+		}
+	}
+	return b.String()
+}
+
+func probeVersionName(v uint16) string {
+	switch v {
+	case VersionTLS12:
+		return "TLS 1.2"
+	case VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
+
+// probeStateSummary describes why the directions of res were not
+// offloaded, giving a reason shared by all of them only once.
+func probeStateSummary(res KTLSProbeResult) string {
+	var parts []string
+	reasons := make(map[string]bool)
+	for _, d := range []struct {
+		name    string
+		enabled bool
+		reason  string
+	}{
+		{"client TX", res.Client.TXEnabled, res.Client.TXReason},
+		{"client RX", res.Client.RXEnabled, res.Client.RXReason},
+		{"server TX", res.Server.TXEnabled, res.Server.TXReason},
+		{"server RX", res.Server.RXEnabled, res.Server.RXReason},
+	} {
+		if !d.enabled {
+			parts = append(parts, d.name+": "+d.reason)
+			reasons[d.reason] = true
+		}
+	}
+	if len(parts) == 4 && len(reasons) == 1 {
+		return res.Client.TXReason
+	}
+	return strings.Join(parts, "; ")
+}
+
+// ProbeKTLS checks that kernel TLS works end-to-end on this host: for TLS
+// 1.2 and TLS 1.3, it performs a handshake over loopback with an ephemeral
+// certificate, offloads both ends to the kernel and sends data in both
+// directions, checking that it arrives intact. Unlike KTLSCapabilities,
+// which only programs the kernel, it exercises the record path, so it suits
+// CI and startup health checks.
+//
+// ProbeKTLS honors SetKTLSEnabled. It returns an error only if the probe
+// could not be set up, such as when no loopback listener can be created;
+// failures of kernel TLS itself are reported in the results.
+func ProbeKTLS() (KTLSProbeReport, error) {
+	report := KTLSProbeReport{UnsupportedReason: KTLSUnsupportedReason()}
+	cert, err := probeCertificate()
+	if err != nil {
+		return report, err
+	}
+	for _, p := range []struct {
+		version uint16
+		suite   uint16
+	}{
+		{VersionTLS12, TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		{VersionTLS13, TLS_AES_128_GCM_SHA256},
+	} {
+		res, err := probeKTLSVersion(cert, p.version, p.suite)
+		if err != nil {
+			return report, err
+		}
+		report.Results = append(report.Results, res)
+	}
+	return report, nil
+}
+
+// probeKTLSVersion makes a loopback connection with the given version and
+// cipher suite and sends data both ways.
+func probeKTLSVersion(cert Certificate, version, suite uint16) (KTLSProbeResult, error) {
+	res := KTLSProbeResult{Version: version, CipherSuite: suite}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return res, err
+	}
+	defer ln.Close()
+
+	serverConfig := &Config{
+		Certificates: []Certificate{cert},
+		MinVersion:   version,
+		MaxVersion:   version,
+		CipherSuites: []uint16{suite},
+	}
+	type serverResult struct {
+		state KTLSState
+		err   error
+	}
+	done := make(chan serverResult, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			done <- serverResult{err: err}
+			return
+		}
+		s := Server(conn, serverConfig)
+		defer s.Close()
+		s.SetDeadline(time.Now().Add(probeKTLSTimeout))
+		err = probeExchange(s, false)
+		done <- serverResult{s.ConnectionState().KTLS, err}
+	}()
+
+	conn, err := net.DialTimeout("tcp", ln.Addr().String(), probeKTLSTimeout)
+	if err != nil {
+		return res, err
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	c := Client(conn, &Config{
+		RootCAs:      roots,
+		ServerName:   "ktls-probe",
+		MinVersion:   version,
+		MaxVersion:   version,
+		CipherSuites: []uint16{suite},
+	})
+	c.SetDeadline(time.Now().Add(probeKTLSTimeout))
+	res.Err = probeExchange(c, true)
+	res.Client = c.ConnectionState().KTLS
+	c.Close()
+
+	sr := <-done
+	res.Server = sr.state
+	if res.Err == nil {
+		res.Err = sr.err
+	}
+	return res, nil
+}
+
+// probeExchange sends probeKTLSTransfer bytes on c and reads as many back,
+// the client sending first, and checks what it read.
+func probeExchange(c *Conn, client bool) error {
+	if err := c.Handshake(); err != nil {
+		return err
+	}
+	sent := probePattern(client)
+	want := probePattern(!client)
+	got := make([]byte, len(want))
+	if client {
+		if _, err := c.Write(sent); err != nil {
+			return err
+		}
+	}
+	if _, err := io.ReadFull(c, got); err != nil {
+		return err
+	}
+	if !bytes.Equal(got, want) {
+		return errors.New("tls: data received over kernel TLS is corrupted")
+	}
+	if !client {
+		if _, err := c.Write(sent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// probePattern returns the data ProbeKTLS sends from the client or the
+// server, distinct for each so that a reflected stream is detected.
+func probePattern(client bool) []byte {
+	b := make([]byte, probeKTLSTransfer)
+	seed := byte(0)
+	if client {
+		seed = 0x5a
+	}
+	for i := range b {
+		b[i] = byte(i*7) ^ seed
+	}
+	return b
+}
+
+// probeCertificate returns an ephemeral self-signed certificate for
+// ProbeKTLS.
+func probeCertificate() (Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return Certificate{}, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ktls-probe"},
+		DNSNames:     []string{"ktls-probe"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return Certificate{}, err
+	}
+	return Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
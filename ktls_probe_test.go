@@ -0,0 +1,50 @@
+package tls
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProbeKTLSLoopback(t *testing.T) {
+	report, err := ProbeKTLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Results) != 2 {
+		t.Fatalf("got %d results, want 2", len(report.Results))
+	}
+	for _, res := range report.Results {
+		if res.Err != nil {
+			t.Errorf("%x: %v", res.Version, res.Err)
+		}
+	}
+	if report.UnsupportedReason != "" {
+		if report.OK() {
+			t.Error("OK() with kernel TLS unsupported")
+		}
+		if !strings.Contains(report.String(), "not offloaded") {
+			t.Errorf("String() = %q", report.String())
+		}
+		return
+	}
+	if !report.OK() {
+		t.Errorf("kernel TLS supported but the probe failed:\n%s", report)
+	}
+}
+
+func TestProbeKTLSLoopbackDisabled(t *testing.T) {
+	defer ktlsDisabled.Store(ktlsDisabled.Load())
+	SetKTLSEnabled(false)
+	report, err := ProbeKTLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() {
+		t.Error("OK() while disabled by SetKTLSEnabled")
+	}
+	for _, res := range report.Results {
+		if res.Err != nil || res.Client.TXEnabled || res.Server.RXEnabled {
+			t.Errorf("%x: %+v", res.Version, res)
+		}
+	}
+}
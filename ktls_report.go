@@ -0,0 +1,198 @@
+package tls
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A KTLSReport describes the kernel TLS support of the host and process,
+// to be attached to bug reports. It holds no addresses, host names or key
+// material: addresses found in error messages are replaced by "<addr>".
+// It can be formatted with String or encoding/json.
+type KTLSReport struct {
+	GoVersion string `json:"go_version"`
+	GOOS      string `json:"goos"`
+	GOARCH    string `json:"goarch"`
+	// Kernel is the kernel release, as printed by uname -r.
+	Kernel string `json:"kernel,omitempty"`
+	// UnsupportedReason is KTLSUnsupportedReason.
+	UnsupportedReason string       `json:"unsupported_reason,omitempty"`
+	Features          KTLSFeatures `json:"features"`
+	// Module describes the tls kernel module: whether it is loaded and
+	// the values of its parameters.
+	Module map[string]string `json:"module,omitempty"`
+	// TLSStat holds the counters of /proc/net/tls_stat.
+	TLSStat map[string]uint64 `json:"tls_stat,omitempty"`
+	// Interfaces describes the TLS offload of the network interfaces
+	// that are up, other than loopback.
+	Interfaces []KTLSInterfaceReport `json:"interfaces,omitempty"`
+	// RecentErrors are the last errors met while enabling kernel TLS in
+	// this process, oldest first.
+	RecentErrors []KTLSReportError `json:"recent_errors,omitempty"`
+	// Errors are the errors met while gathering the report.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// A KTLSInterfaceReport describes the TLS offload of a network interface.
+type KTLSInterfaceReport struct {
+	Name string `json:"name"`
+	// Features are the ethtool features of the interface related to TLS,
+	// such as tls-hw-tx-offload, and whether they are active.
+	Features map[string]bool `json:"features,omitempty"`
+	// Stats are the TLS counters of the driver, see DeviceTLSStats.
+	Stats map[string]uint64 `json:"stats,omitempty"`
+}
+
+// A KTLSReportError is an error met while enabling kernel TLS.
+type KTLSReportError struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+}
+
+// Report gathers a KTLSReport describing the kernel TLS support of the
+// host and process.
+func Report() KTLSReport {
+	r := KTLSReport{
+		GoVersion:         runtime.Version(),
+		GOOS:              runtime.GOOS,
+		GOARCH:            runtime.GOARCH,
+		UnsupportedReason: KTLSUnsupportedReason(),
+		Features:          CurrentKTLSFeatures(),
+		RecentErrors:      recentKTLSErrors(),
+	}
+	r.gatherSystem()
+	for i := range r.Errors {
+		r.Errors[i] = sanitizeReportText(r.Errors[i])
+	}
+	return r
+}
+
+// JSON returns the report as indented JSON.
+func (r KTLSReport) JSON() []byte {
+	b, _ := json.MarshalIndent(r, "", "  ")
+	return b
+}
+
+// String returns the report as text.
+func (r KTLSReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "go: %s %s/%s\n", r.GoVersion, r.GOOS, r.GOARCH)
+	fmt.Fprintf(&b, "kernel: %s\n", r.Kernel)
+	if r.UnsupportedReason != "" {
+		fmt.Fprintf(&b, "kernel TLS: unsupported: %s\n", r.UnsupportedReason)
+	} else {
+		fmt.Fprintf(&b, "kernel TLS: supported\n")
+	}
+	fmt.Fprintf(&b, "features: %+v\n", r.Features)
+	if len(r.Module) > 0 {
+		b.WriteString("module:\n")
+		for _, k := range sortedStringKeys(r.Module) {
+			fmt.Fprintf(&b, "  %s: %s\n", k, r.Module[k])
+		}
+	}
+	if len(r.TLSStat) > 0 {
+		b.WriteString("tls_stat:\n")
+		for _, k := range sortedStringKeys(r.TLSStat) {
+			fmt.Fprintf(&b, "  %s: %d\n", k, r.TLSStat[k])
+		}
+	}
+	for _, iface := range r.Interfaces {
+		fmt.Fprintf(&b, "interface %s:\n", iface.Name)
+		for _, k := range sortedStringKeys(iface.Features) {
+			fmt.Fprintf(&b, "  %s: %v\n", k, iface.Features[k])
+		}
+		for _, k := range sortedStringKeys(iface.Stats) {
+			fmt.Fprintf(&b, "  %s: %d\n", k, iface.Stats[k])
+		}
+	}
+	if len(r.RecentErrors) > 0 {
+		b.WriteString("recent errors:\n")
+		for _, e := range r.RecentErrors {
+			fmt.Fprintf(&b, "  %s %s\n", e.Time.UTC().Format(time.RFC3339), e.Error)
+		}
+	}
+	if len(r.Errors) > 0 {
+		b.WriteString("report errors:\n")
+		for _, e := range r.Errors {
+			fmt.Fprintf(&b, "  %s\n", e)
+		}
+	}
+	return b.String()
+}
+
+func sortedStringKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// maxRecentKTLSErrors is the number of errors kept for Report.
+const maxRecentKTLSErrors = 16
+
+var recentKTLSErrorLog struct {
+	sync.Mutex
+	errs []KTLSReportError
+}
+
+// recordKTLSError keeps err, met while enabling kernel TLS, for Report.
+func recordKTLSError(err error) {
+	e := KTLSReportError{Time: time.Now(), Error: sanitizeReportText(err.Error())}
+	recentKTLSErrorLog.Lock()
+	defer recentKTLSErrorLog.Unlock()
+	if len(recentKTLSErrorLog.errs) == maxRecentKTLSErrors {
+		copy(recentKTLSErrorLog.errs, recentKTLSErrorLog.errs[1:])
+		recentKTLSErrorLog.errs = recentKTLSErrorLog.errs[:maxRecentKTLSErrors-1]
+	}
+	recentKTLSErrorLog.errs = append(recentKTLSErrorLog.errs, e)
+}
+
+func recentKTLSErrors() []KTLSReportError {
+	recentKTLSErrorLog.Lock()
+	defer recentKTLSErrorLog.Unlock()
+	return append([]KTLSReportError(nil), recentKTLSErrorLog.errs...)
+}
+
+// sanitizeReportText replaces the IP addresses in s, with or without a
+// port, by "<addr>". Addresses joined by "->", as in the errors of net,
+// are replaced separately.
+func sanitizeReportText(s string) string {
+	words := strings.Fields(s)
+	changed := false
+	for i, w := range words {
+		parts := strings.Split(w, "->")
+		for j, p := range parts {
+			if q, ok := sanitizeAddr(p); ok {
+				parts[j] = q
+				changed = true
+			}
+		}
+		words[i] = strings.Join(parts, "->")
+	}
+	if !changed {
+		return s
+	}
+	return strings.Join(words, " ")
+}
+
+// sanitizeAddr replaces w by "<addr>", keeping surrounding punctuation, if
+// it is an IP address with an optional port.
+func sanitizeAddr(w string) (string, bool) {
+	core := strings.TrimRight(w, ",;:)")
+	host := strings.TrimPrefix(core, "(")
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if net.ParseIP(strings.Trim(host, "[]")) == nil {
+		return w, false
+	}
+	return strings.Replace(w, core, "<addr>", 1), true
+}
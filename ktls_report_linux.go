@@ -0,0 +1,73 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// gatherSystem fills in the parts of r read from the kernel.
+func (r *KTLSReport) gatherSystem() {
+	var err error
+	if r.Kernel, err = kernelRelease(); err != nil {
+		r.Errors = append(r.Errors, "uname: "+err.Error())
+	}
+	if stats, err := KTLSStats(); err == nil {
+		r.TLSStat = stats.Counters
+	} else if !os.IsNotExist(err) {
+		r.Errors = append(r.Errors, "tls_stat: "+err.Error())
+	}
+	r.Module = tlsModuleInfo()
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		r.Errors = append(r.Errors, "interfaces: "+err.Error())
+		return
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		ir := KTLSInterfaceReport{Name: iface.Name}
+		if ir.Features, err = tlsDeviceFeatures(iface.Name); err != nil {
+			r.Errors = append(r.Errors, iface.Name+" features: "+err.Error())
+		}
+		if ir.Stats, err = DeviceTLSStats(iface.Name); err != nil {
+			r.Errors = append(r.Errors, iface.Name+" stats: "+err.Error())
+		}
+		r.Interfaces = append(r.Interfaces, ir)
+	}
+}
+
+// tlsModuleInfo describes the tls kernel module from /sys/module/tls.
+func tlsModuleInfo() map[string]string {
+	const dir = "/sys/module/tls"
+	info := make(map[string]string)
+	if _, err := os.Stat(dir); err != nil {
+		info["state"] = "not loaded"
+		return info
+	}
+	state, err := os.ReadFile(filepath.Join(dir, "initstate"))
+	if err != nil {
+		// Built-in modules have no initstate.
+		info["state"] = "built-in"
+	} else {
+		info["state"] = strings.TrimSpace(string(state))
+	}
+	for _, name := range []string{"version", "srcversion"} {
+		if b, err := os.ReadFile(filepath.Join(dir, name)); err == nil {
+			info[name] = strings.TrimSpace(string(b))
+		}
+	}
+	params, _ := os.ReadDir(filepath.Join(dir, "parameters"))
+	for _, p := range params {
+		if b, err := os.ReadFile(filepath.Join(dir, "parameters", p.Name())); err == nil {
+			info["parameters/"+p.Name()] = strings.TrimSpace(string(b))
+		}
+	}
+	return info
+}
@@ -0,0 +1,63 @@
+package tls
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeReportText(t *testing.T) {
+	tests := []struct{ in, out string }{
+		{"setsockopt(TLS_TX): operation not permitted", "setsockopt(TLS_TX): operation not permitted"},
+		{"write tcp 10.0.0.1:443->192.168.1.2:5555: broken pipe", "write tcp <addr>-><addr>: broken pipe"},
+		{"dial tcp 10.0.0.1:443: connection refused", "dial tcp <addr>: connection refused"},
+		{"no interface has address fe80::1", "no interface has address <addr>"},
+		{"peer [2001:db8::1]:443, closed", "peer <addr>, closed"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeReportText(tt.in); got != tt.out {
+			t.Errorf("sanitizeReportText(%q) = %q, want %q", tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestReportRecentErrors(t *testing.T) {
+	recentKTLSErrorLog.Lock()
+	saved := recentKTLSErrorLog.errs
+	recentKTLSErrorLog.errs = nil
+	recentKTLSErrorLog.Unlock()
+	defer func() {
+		recentKTLSErrorLog.Lock()
+		recentKTLSErrorLog.errs = saved
+		recentKTLSErrorLog.Unlock()
+	}()
+
+	for i := 0; i < maxRecentKTLSErrors+3; i++ {
+		recordKTLSError(fmt.Errorf("error %d from 10.0.0.1", i))
+	}
+	r := Report()
+	if len(r.RecentErrors) != maxRecentKTLSErrors {
+		t.Fatalf("got %d recent errors, want %d", len(r.RecentErrors), maxRecentKTLSErrors)
+	}
+	if got, want := r.RecentErrors[0].Error, "error 3 from <addr>"; got != want {
+		t.Errorf("oldest error = %q, want %q", got, want)
+	}
+
+	var decoded KTLSReport
+	if err := json.Unmarshal(r.JSON(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.GoVersion != r.GoVersion || len(decoded.RecentErrors) != len(r.RecentErrors) {
+		t.Errorf("JSON round trip = %+v, want %+v", decoded, r)
+	}
+	s := r.String()
+	for _, want := range []string{"go: " + r.GoVersion, "kernel:", "recent errors:", "error 18 from <addr>"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("report text lacks %q:\n%s", want, s)
+		}
+	}
+	if strings.Contains(s, "10.0.0.1") {
+		t.Errorf("report text contains an address:\n%s", s)
+	}
+}
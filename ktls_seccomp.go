@@ -0,0 +1,78 @@
+package tls
+
+import (
+	"encoding/json"
+	"errors"
+	"syscall"
+)
+
+// ktlsSyscalls lists the system calls made by the kernel TLS paths on top
+// of those every Go network program needs, and what they are used for.
+var ktlsSyscalls = []struct {
+	name, use string
+}{
+	{"uname", "detecting the kernel version at init"},
+	{"setsockopt", "attaching the tls ULP and programming TLS_TX and TLS_RX"},
+	{"getsockopt", "reading back kernel TLS state and TCP_MAXSEG"},
+	{"recvmsg", "receiving records and their content type"},
+	{"recvmmsg", "receiving several records at once with ReadRecords"},
+	{"sendmsg", "sending alert and handshake records"},
+	{"sendfile", "ReadFrom a regular file"},
+	{"splice", "WriteTo a regular file or socket, and SpliceTo"},
+	{"pipe2", "WriteTo a regular file or socket, and SpliceTo"},
+	{"ioctl", "SIOCOUTQ and SIOCOUTQNSD for SyncSent, SIOCETHTOOL for NIC TLS counters"},
+	{"socket", "NETLINK_SOCK_DIAG and NETLINK_GENERIC sockets reading NIC TLS offload"},
+	{"io_uring_setup", "Config.KTLSRXIOUring and KTLSSpliceIOUring"},
+	{"io_uring_enter", "Config.KTLSRXIOUring and KTLSSpliceIOUring"},
+	{"io_uring_register", "Config.KTLSRXIOUring and KTLSSpliceIOUring"},
+}
+
+// KTLSSyscalls returns the names of the system calls that kernel TLS makes
+// beyond those of the Go runtime and net package. A seccomp profile must
+// allow all of them for offload to work; see also Config.KTLSSeccompCompat
+// and KTLSSeccompProfile.
+func KTLSSyscalls() []string {
+	names := make([]string, len(ktlsSyscalls))
+	for i, sc := range ktlsSyscalls {
+		names[i] = sc.name
+	}
+	return names
+}
+
+// KTLSSeccompProfile returns an entry of the "syscalls" array of a Docker
+// or OCI seccomp profile that allows KTLSSyscalls, for merging into the
+// profile of a container:
+//
+//	{"names": ["uname", "setsockopt", ...], "action": "SCMP_ACT_ALLOW"}
+func KTLSSeccompProfile() []byte {
+	b, err := json.MarshalIndent(struct {
+		Names  []string `json:"names"`
+		Action string   `json:"action"`
+	}{KTLSSyscalls(), "SCMP_ACT_ALLOW"}, "", "\t")
+	if err != nil {
+		panic("tls: internal error: " + err.Error())
+	}
+	return b
+}
+
+// A KTLSSyscallError reports a failed system call made for kernel TLS.
+type KTLSSyscallError struct {
+	Syscall string // the failed call, such as "recvmsg"
+	Err     error
+}
+
+func (e *KTLSSyscallError) Error() string {
+	msg := "tls: kTLS " + e.Syscall + ": " + e.Err.Error()
+	if e.blocked() {
+		msg += " (blocked by a seccomp profile? see KTLSSyscalls)"
+	}
+	return msg
+}
+
+func (e *KTLSSyscallError) Unwrap() error { return e.Err }
+
+// blocked reports whether e looks like the call was refused by a seccomp
+// filter rather than failed in the kernel.
+func (e *KTLSSyscallError) blocked() bool {
+	return errors.Is(e.Err, syscall.EPERM) || errors.Is(e.Err, syscall.ENOSYS)
+}
@@ -0,0 +1,48 @@
+package tls
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestKTLSSeccompProfile(t *testing.T) {
+	var profile struct {
+		Names  []string `json:"names"`
+		Action string   `json:"action"`
+	}
+	if err := json.Unmarshal(KTLSSeccompProfile(), &profile); err != nil {
+		t.Fatal(err)
+	}
+	if profile.Action != "SCMP_ACT_ALLOW" {
+		t.Errorf("action = %q", profile.Action)
+	}
+	if strings.Join(profile.Names, ",") != strings.Join(KTLSSyscalls(), ",") {
+		t.Errorf("names = %v, want %v", profile.Names, KTLSSyscalls())
+	}
+	for _, name := range []string{"setsockopt", "recvmsg", "sendmsg"} {
+		found := false
+		for _, n := range profile.Names {
+			found = found || n == name
+		}
+		if !found {
+			t.Errorf("%s missing from %v", name, profile.Names)
+		}
+	}
+}
+
+func TestKTLSSyscallError(t *testing.T) {
+	err := error(&KTLSSyscallError{Syscall: "setsockopt(TLS_TX)", Err: syscall.EPERM})
+	if !errors.Is(err, syscall.EPERM) {
+		t.Errorf("errors.Is(%v, EPERM) = false", err)
+	}
+	if !strings.Contains(err.Error(), "seccomp") {
+		t.Errorf("%q does not mention seccomp", err)
+	}
+	err = &KTLSSyscallError{Syscall: "recvmsg", Err: syscall.ECONNRESET}
+	if strings.Contains(err.Error(), "seccomp") {
+		t.Errorf("%q mentions seccomp", err)
+	}
+}
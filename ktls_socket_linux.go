@@ -0,0 +1,64 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxUnwrapDepth bounds the Unwrap chains followed by ktlsSocket.
+const maxUnwrapDepth = 16
+
+// ktlsSocket returns the TCP socket kernel TLS can be programmed on for
+// conn. Unless unwrap is set, see Config.KTLSUnwrapConn, that is only conn
+// itself if it is a *net.TCPConn, since a wrapper may hold bytes read from
+// the socket that kernel TLS RX would then skip. With unwrap, it is conn
+// itself if it is a TCP socket implementing syscall.Conn, such as a type
+// embedding a *net.TCPConn, or else the first such socket found by
+// following Unwrap() net.Conn methods.
+//
+// Once kernel TLS RX is enabled, records are read from the socket directly,
+// bypassing the wrappers' Read methods, and writes of control records
+// bypass their Write methods, so wrappers must pass data through unchanged.
+// Other TLS implementations, whose NetConn methods return the socket their
+// records are sent on, are deliberately not unwrapped.
+func ktlsSocket(conn net.Conn, unwrap bool) (syscall.Conn, bool) {
+	if !unwrap {
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			return tcpConn, true
+		}
+		return nil, false
+	}
+	for i := 0; i < maxUnwrapDepth && conn != nil; i++ {
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			return tcpConn, true
+		}
+		if sc, ok := conn.(syscall.Conn); ok {
+			return sc, isTCPSocket(sc)
+		}
+		u, ok := conn.(interface{ Unwrap() net.Conn })
+		if !ok {
+			return nil, false
+		}
+		conn = u.Unwrap()
+	}
+	return nil, false
+}
+
+// isTCPSocket reports whether sc is a TCP socket.
+func isTCPSocket(sc syscall.Conn) bool {
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var proto int
+	var err0 error
+	err = rc.Control(func(fd uintptr) {
+		proto, err0 = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_PROTOCOL)
+	})
+	return err == nil && err0 == nil && proto == unix.IPPROTO_TCP
+}
@@ -0,0 +1,679 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// embeddingConn wraps a *net.TCPConn by embedding it, like connection
+// trackers do.
+type embeddingConn struct {
+	*net.TCPConn
+}
+
+// unwrapConn wraps a net.Conn without exposing its socket, like PROXY
+// protocol decoders do.
+type unwrapConn struct {
+	net.Conn
+}
+
+func (c unwrapConn) Unwrap() net.Conn { return c.Conn }
+
+func TestKTLSSocket(t *testing.T) {
+	ln := newLocalListener(t)
+	defer ln.Close()
+	tcpConn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpConn.Close()
+
+	ul, err := net.Listen("unix", filepath.Join(t.TempDir(), "sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ul.Close()
+	unixConn, err := net.Dial("unix", ul.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unixConn.Close()
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	tests := []struct {
+		name     string
+		conn     net.Conn
+		ok       bool
+		unwrapOK bool
+	}{
+		{"TCPConn", tcpConn, true, true},
+		{"embedding", embeddingConn{tcpConn}, false, true},
+		{"unwrap", unwrapConn{tcpConn}, false, true},
+		{"nested unwrap", unwrapConn{unwrapConn{embeddingConn{tcpConn}}}, false, true},
+		{"unix", unixConn, false, false},
+		{"unwrap unix", unwrapConn{unixConn}, false, false},
+		{"pipe", p1, false, false},
+		{"unwrap pipe", unwrapConn{p1}, false, false},
+		{"TLS", Client(tcpConn, testConfig.Clone()), false, false},
+	}
+	for _, tt := range tests {
+		if _, ok := ktlsSocket(tt.conn, false); ok != tt.ok {
+			t.Errorf("%s: ktlsSocket ok = %v, want %v", tt.name, ok, tt.ok)
+		}
+		if _, ok := ktlsSocket(tt.conn, true); ok != tt.unwrapOK {
+			t.Errorf("%s: ktlsSocket with unwrap ok = %v, want %v", tt.name, ok, tt.unwrapOK)
+		}
+	}
+}
+
+// TestKTLSSendAlertControlMessage checks that alerts on a connection with
+// kernel TLS TX are sent with sendmsg and a record type control message.
+// Without the tls ULP, TCP ignores the control message and sends the alert
+// as is, which lets the test see it.
+func TestKTLSSendAlertControlMessage(t *testing.T) {
+	ln := newLocalListener(t)
+	defer ln.Close()
+	tcpConn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpConn.Close()
+	peer, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	log := &recordLog{}
+	config := testConfig.Clone()
+	config.RecordObserver = log
+	c := &Conn{conn: tcpConn, config: config, ktlsSock: tcpConn}
+	c.out.cipher = kTLSCipher{}
+	if err := c.sendAlert(alertCloseNotify); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(peer, buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf[0] != alertLevelWarning || alert(buf[1]) != alertCloseNotify {
+		t.Errorf("sent %x, want a close_notify alert", buf)
+	}
+	if len(log.records) != 1 || log.records[0].ContentType != uint8(recordTypeAlert) || !log.records[0].KTLS {
+		t.Errorf("observed %+v, want one kernel TLS alert record", log.records)
+	}
+}
+
+func TestKTLSFallbackOnError(t *testing.T) {
+	defer func(tx, rx bool) {
+		kTLSSupportTX, kTLSSupportRX = tx, rx
+	}(kTLSSupportTX, kTLSSupportRX)
+	kTLSSupportTX, kTLSSupportRX = true, true
+
+	ln := newLocalListener(t)
+	defer ln.Close()
+	tcpConn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpConn.Close()
+
+	kc := ktlsCipher{version: VersionTLS12, keyLen: 16,
+		enable: func(syscall.Conn, uint16, int, bool, bool, []byte, []byte, []byte) error {
+			return syscall.ENOTSUP
+		},
+	}
+	key, iv := make([]byte, 16), make([]byte, 4)
+
+	config := testConfig.Clone()
+	c := Client(tcpConn, config)
+	var cipher any
+	if err := c.ktlsEnableTX(kc, key, iv, &cipher); !errors.Is(err, syscall.ENOTSUP) {
+		t.Fatalf("ktlsEnableTX = %v, want ENOTSUP", err)
+	}
+
+	config.KTLSFallbackOnError = true
+	c = Client(tcpConn, config)
+	if err := c.ktlsEnableTX(kc, key, iv, &cipher); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ktlsEnableRX(kc, key, iv, &cipher); err != nil {
+		t.Fatal(err)
+	}
+	if cipher != nil || c.ktlsState.TXEnabled || c.ktlsState.RXEnabled {
+		t.Error("offload enabled after a failed setsockopt")
+	}
+	for _, reason := range []string{c.ktlsState.TXReason, c.ktlsState.RXReason} {
+		if !strings.Contains(reason, syscall.ENOTSUP.Error()) {
+			t.Errorf("reason %q doesn't mention the error", reason)
+		}
+	}
+	if !c.ktlsReport.Load() {
+		t.Error("fallback not reported")
+	}
+}
+
+// TestKTLSRequireDevice checks that a direction is not programmed when the
+// interface, here loopback, can't offload TLS.
+func TestKTLSRequireDevice(t *testing.T) {
+	defer func(tx, rx bool) {
+		kTLSSupportTX, kTLSSupportRX = tx, rx
+	}(kTLSSupportTX, kTLSSupportRX)
+	kTLSSupportTX, kTLSSupportRX = true, true
+
+	ln := newLocalListener(t)
+	defer ln.Close()
+	tcpConn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpConn.Close()
+
+	kc := ktlsCipher{version: VersionTLS12, keyLen: 16,
+		enable: func(syscall.Conn, uint16, int, bool, bool, []byte, []byte, []byte) error {
+			t.Error("kernel programmed without device offload")
+			return nil
+		},
+	}
+	key, iv := make([]byte, 16), make([]byte, 4)
+
+	config := testConfig.Clone()
+	config.KTLSRequireDevice = true
+	c := Client(tcpConn, config)
+	var cipher any
+	if err := c.ktlsEnableTX(kc, key, iv, &cipher); err == nil || !strings.Contains(err.Error(), "device offload required") {
+		t.Fatalf("ktlsEnableTX = %v, want a device offload error", err)
+	}
+
+	config.KTLSFallbackOnError = true
+	c = Client(tcpConn, config)
+	if err := c.ktlsEnableTX(kc, key, iv, &cipher); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ktlsEnableRX(kc, key, iv, &cipher); err != nil {
+		t.Fatal(err)
+	}
+	if cipher != nil || c.ktlsState.TXEnabled || c.ktlsState.RXEnabled {
+		t.Error("offload enabled without device offload")
+	}
+	for _, reason := range []string{c.ktlsState.TXReason, c.ktlsState.RXReason} {
+		if !strings.Contains(reason, "device offload required") {
+			t.Errorf("reason %q doesn't mention device offload", reason)
+		}
+	}
+}
+
+func TestKTLSCheckDeviceMode(t *testing.T) {
+	c, s := localPipe(t)
+	defer c.Close()
+	defer s.Close()
+	err := ktlsCheckDeviceMode(c.(*net.TCPConn), TLS_TX)
+	if err == nil {
+		t.Fatal("a socket without the tls ULP passed the device check")
+	}
+	if strings.Contains(err.Error(), "sock_diag failed") {
+		t.Skip(err)
+	}
+	if !strings.Contains(err.Error(), "TLS_TX in none mode") {
+		t.Errorf("err = %v, want it to report the none mode", err)
+	}
+}
+
+func TestKTLSCryptoInfoWiped(t *testing.T) {
+	ln := newLocalListener(t)
+	defer ln.Close()
+	tcpConn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpConn.Close()
+
+	// Whether or not the kernel accepts it, the keys must not outlive
+	// the call.
+	info := kTLSCryptoInfoAESGCM128{info: kTLSCryptoInfo{version: VersionTLS12, cipherType: kTLS_CIPHER_AES_GCM_128}}
+	for i := range info.key {
+		info.key[i] = 0xaa
+	}
+	ktlsSetCryptoInfo(tcpConn, TLS_TX, false, false, unsafe.Pointer(&info), unsafe.Sizeof(info))
+	if info != (kTLSCryptoInfoAESGCM128{}) {
+		t.Errorf("crypto_info not wiped: %+v", info)
+	}
+
+	// setsockopt and getsockopt take raw buffers for any option.
+	rc, err := tcpConn.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got int32
+	rc.Control(func(fd uintptr) {
+		on := int32(1)
+		if err := setsockopt(fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, unsafe.Pointer(&on), 4); err != nil {
+			t.Fatal(err)
+		}
+		n := uint32(4)
+		if err := getsockopt(fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, unsafe.Pointer(&got), &n); err != nil || n != 4 {
+			t.Fatalf("getsockopt: %v, %d bytes", err, n)
+		}
+	})
+	if got != 1 {
+		t.Errorf("SO_KEEPALIVE = %d, want 1", got)
+	}
+}
+
+// TestKTLSKeysWiped checks that the traffic keys kept for kernel TLS are
+// zeroed once enabling it is settled, whether or not it was enabled.
+func TestKTLSKeysWiped(t *testing.T) {
+	for _, version := range []uint16{VersionTLS12, VersionTLS13} {
+		c, s := localPipe(t)
+		config := testConfig.Clone()
+		config.MinVersion, config.MaxVersion = version, version
+		client, server := Client(c, config), Server(s, config)
+		errs := make(chan error, 1)
+		go func() { errs <- server.Handshake() }()
+		if err := client.Handshake(); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+		for _, conn := range []*Conn{client, server} {
+			for _, hc := range []*halfConn{&conn.in, &conn.out} {
+				if hc.key != nil || hc.iv != nil {
+					t.Errorf("%x: key %x and IV %x kept after the handshake", version, hc.key, hc.iv)
+				}
+			}
+		}
+		client.Close()
+		server.Close()
+	}
+
+	c := &Conn{ktlsSavedTX: []byte{1, 2}, ktlsSavedRX: []byte{3}}
+	saved := c.ktlsSavedTX
+	c.ktlsWipeSaved()
+	if c.ktlsSavedTX != nil || c.ktlsSavedRX != nil || !bytes.Equal(saved, []byte{0, 0}) {
+		t.Errorf("saved crypto_info not wiped: %x", saved)
+	}
+}
+
+func TestKTLSCryptoInfo(t *testing.T) {
+	ci := kTLSCryptoInfoCHACHA20POLY1305{info: kTLSCryptoInfo{version: VersionTLS13, cipherType: kTLS_CIPHER_CHACHA20_POLY1305}}
+	ci.recSeq = [8]byte{0, 0, 0, 0, 0, 0, 1, 2}
+	b := (*[kTLSCryptoInfoSize_CHACHA20_POLY1305]byte)(unsafe.Pointer(&ci))[:]
+	want := KTLSCryptoInfo{Version: VersionTLS13, CipherType: kTLS_CIPHER_CHACHA20_POLY1305, Cipher: "CHACHA20-POLY1305", RecordSeq: 0x102}
+	if got, err := parseKTLSCryptoInfo(b); err != nil || got != want {
+		t.Errorf("parseKTLSCryptoInfo = %+v, %v; want %+v", got, err, want)
+	}
+	if _, err := parseKTLSCryptoInfo(b[:len(b)-1]); err == nil {
+		t.Error("truncated crypto_info parsed")
+	}
+
+	ln := newLocalListener(t)
+	defer ln.Close()
+	tcpConn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpConn.Close()
+	c := &Conn{conn: tcpConn, config: testConfig.Clone()}
+	if _, err := c.KTLSCryptoInfo(KTLSDirectionTX); err != ErrNoKTLSTX {
+		t.Errorf("TX: got %v, want %v", err, ErrNoKTLSTX)
+	}
+	if _, err := c.KTLSCryptoInfo(KTLSDirectionRX); err != ErrNoKTLSRX {
+		t.Errorf("RX: got %v, want %v", err, ErrNoKTLSRX)
+	}
+	if _, err := c.KTLSCryptoInfo(KTLSDirection(2)); err == nil {
+		t.Error("invalid direction accepted")
+	}
+	// Without the tls ULP, the kernel has nothing to report.
+	c.ktlsState.TXEnabled = true
+	if _, err := c.KTLSCryptoInfo(KTLSDirectionTX); err == nil {
+		t.Error("crypto_info read from a socket without kernel TLS")
+	}
+}
+
+func TestReportKTLSAfterHandshake(t *testing.T) {
+	var fallbacks, enabled int
+	var got KTLSState
+	config := testConfig.Clone()
+	config.OnKTLSFallback = func(conn *Conn, state KTLSState) {
+		fallbacks++
+		got = state
+	}
+	config.OnKTLSEnabled = func(conn *Conn, state KTLSState) {
+		enabled++
+		got = state
+	}
+
+	c, s := localPipe(t)
+	client := Client(c, config)
+	server := Server(s, testConfig.Clone())
+	defer client.Close()
+	defer server.Close()
+	errc := make(chan error, 1)
+	go func() { errc <- server.Handshake() }()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	if fallbacks+enabled != 1 {
+		t.Fatalf("got %d OnKTLSFallback and %d OnKTLSEnabled calls, want one in total", fallbacks, enabled)
+	}
+	if got != client.ConnectionState().KTLS {
+		t.Errorf("reported state = %+v, want %+v", got, client.ConnectionState().KTLS)
+	}
+	if enabled == 1 {
+		return
+	}
+	if !got.TXEnabled && got.TXReason == "" {
+		t.Error("TX left to user space without a reason")
+	}
+	if !got.RXEnabled && got.RXReason == "" {
+		t.Error("RX left to user space without a reason")
+	}
+}
+
+// TestReadFromUserSpaceTX checks that ReadFrom sends a file the peer can
+// decrypt. Without kernel TLS TX the file must be encrypted in user space
+// rather than sent to the socket as is.
+func TestReadFromUserSpaceTX(t *testing.T) {
+	c, s := localPipe(t)
+	client := Client(c, testConfig.Clone())
+	server := Server(s, testConfig.Clone())
+	defer client.Close()
+	defer server.Close()
+
+	want := bytes.Repeat([]byte("plaintext "), 10000)
+	f, err := os.CreateTemp(t.TempDir(), "readfrom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(want); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		if err := server.Handshake(); err != nil {
+			errc <- err
+			return
+		}
+		_, err := server.ReadFrom(f)
+		errc <- err
+	}()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(client, got); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("received data doesn't match the file")
+	}
+}
+
+// TestSpliceToSocket checks that spliceTo forwards data between sockets,
+// waiting when the destination is full. Without the tls ULP the source is a
+// plain TCP socket, which splices the same way.
+func TestSpliceToSocket(t *testing.T) {
+	tcpPair := func() (*net.TCPConn, net.Conn) {
+		ln := newLocalListener(t)
+		defer ln.Close()
+		conn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		peer, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn, peer
+	}
+	src, client := tcpPair()
+	defer src.Close()
+	defer client.Close()
+	dst, backend := tcpPair()
+	defer dst.Close()
+	defer backend.Close()
+
+	want := make([]byte, 8<<20)
+	for i := range want {
+		want[i] = byte(i * 7)
+	}
+	go func() {
+		client.Write(want)
+		client.Close()
+	}()
+	got := make(chan []byte, 1)
+	go func() {
+		b, _ := io.ReadAll(backend)
+		got <- b
+	}()
+
+	config := testConfig.Clone()
+	config.SpliceChunkSize = 256 << 10
+	c := &Conn{conn: src, config: config, ktlsSock: src}
+	n, err, handled := c.spliceTo(dst, int64(len(want))+1)
+	if !handled || err != nil || n != int64(len(want)) {
+		t.Fatalf("spliceTo = %d, %v, %v; want %d, nil, true", n, err, handled, len(want))
+	}
+	dst.Close()
+	if !bytes.Equal(<-got, want) {
+		t.Error("backend received different data")
+	}
+}
+
+func TestSpliceTarget(t *testing.T) {
+	dir := t.TempDir()
+	ul, err := net.Listen("unix", filepath.Join(dir, "stream"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ul.Close()
+	stream, err := net.Dial("unix", ul.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	dgram, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: filepath.Join(dir, "dgram"), Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dgram.Close()
+	f, err := os.CreateTemp(dir, "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tests := []struct {
+		name  string
+		w     io.Writer
+		files bool
+		ok    bool
+	}{
+		{"unix stream", stream, false, true},
+		{"unixgram", dgram, false, false},
+		{"file", f, false, false},
+		{"file allowed", f, true, true},
+		{"buffer", &bytes.Buffer{}, true, false},
+	}
+	for _, tt := range tests {
+		if _, ok := spliceTarget(tt.w, tt.files); ok != tt.ok {
+			t.Errorf("%s: spliceTarget ok = %v, want %v", tt.name, ok, tt.ok)
+		}
+	}
+}
+
+// TestSpliceToPipe checks SpliceTo into a pipe on a Conn that believes RX is
+// offloaded over a plain TCP connection, which splices like kernel TLS does
+// for application data.
+func TestSpliceToPipe(t *testing.T) {
+	ln := newLocalListener(t)
+	defer ln.Close()
+	tcpConn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpConn.Close()
+	peer, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+	defer pw.Close()
+
+	c := &Conn{conn: tcpConn, config: testConfig.Clone(), ktlsSock: tcpConn}
+	c.isHandshakeComplete.Store(true)
+	c.in.cipher = kTLSCipher{}
+
+	go peer.Write([]byte("hello, world"))
+	if n, err := c.SpliceTo(pw, 5); n != 5 || err != nil {
+		t.Fatalf("SpliceTo = %d, %v; want 5, nil", n, err)
+	}
+	got := make([]byte, 5)
+	if _, err := io.ReadFull(pr, got); err != nil || string(got) != "hello" {
+		t.Errorf("pipe holds %q, %v; want %q", got, err, "hello")
+	}
+
+	peer.Close()
+	if n, err := c.SpliceTo(pw, 100); n != 7 || err != nil {
+		t.Fatalf("SpliceTo to EOF = %d, %v; want 7, nil", n, err)
+	}
+	if stats := c.Stats(); stats.SpliceBytes != 12 || stats.RXCopyBytes != 0 {
+		t.Errorf("SpliceBytes, RXCopyBytes = %d, %d; want 12, 0", stats.SpliceBytes, stats.RXCopyBytes)
+	}
+}
+
+// TestSpliceToDeadline checks that spliceTo waits for data in the poller,
+// so that the read deadline interrupts it.
+func TestSpliceToDeadline(t *testing.T) {
+	ln := newLocalListener(t)
+	defer ln.Close()
+	tcpConn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpConn.Close()
+	peer, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+	defer pw.Close()
+
+	c := &Conn{conn: tcpConn, config: testConfig.Clone(), ktlsSock: tcpConn}
+	go func() {
+		peer.Write([]byte("early"))
+	}()
+	tcpConn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	n, err, _ := c.spliceTo(pw, 100)
+	if !errors.Is(err, os.ErrDeadlineExceeded) || n != int64(len("early")) {
+		t.Errorf("spliceTo = %d, %v; want %d, %v", n, err, len("early"), os.ErrDeadlineExceeded)
+	}
+}
+
+// TestKTLSRecvAllocs checks that receiving with the state kept on the Conn
+// doesn't allocate. A plain TCP socket returns no record type, but takes
+// the same path through recvmsg.
+func TestKTLSRecvAllocs(t *testing.T) {
+	ln := newLocalListener(t)
+	defer ln.Close()
+	tcpConn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpConn.Close()
+	peer, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	const runs = 100
+	msg := []byte("record")
+	// AllocsPerRun makes one more call to warm up, and the seccompCompat
+	// case one more.
+	go peer.Write(bytes.Repeat(msg, runs+2))
+
+	for _, seccompCompat := range []bool{false, true} {
+		r, err := newKTLSRecvState(tcpConn, seccompCompat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seccompCompat {
+			// The x/sys wrapper allocates; only check that it works.
+			if n, err := r.receive(make([]byte, len(msg)), msgWaitAll); n != len(msg) || err != nil {
+				t.Fatalf("receive = %d, %v; want %d, nil", n, err, len(msg))
+			}
+			continue
+		}
+		b := make([]byte, len(msg))
+		allocs := testing.AllocsPerRun(runs, func() {
+			if n, err := r.receive(b, msgWaitAll); n != len(msg) || err != nil {
+				t.Fatalf("receive = %d, %v; want %d, nil", n, err, len(msg))
+			}
+		})
+		if allocs != 0 {
+			t.Errorf("receive allocates %v times, want 0", allocs)
+		}
+	}
+}
+
+func TestKTLSNoPadViolation(t *testing.T) {
+	ln := newLocalListener(t)
+	defer ln.Close()
+	tcpConn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpConn.Close()
+
+	c := &Conn{conn: tcpConn, config: testConfig.Clone(), ktlsSock: tcpConn}
+	c.ktlsState = KTLSState{RXEnabled: true, RXNoPad: true}
+	for i := 1; i < ktlsNoPadMaxViolations; i++ {
+		c.ktlsNoPadViolation()
+	}
+	if !c.ConnectionState().KTLS.RXNoPad {
+		t.Fatalf("TLS_RX_EXPECT_NO_PAD turned off after %d violations", ktlsNoPadMaxViolations-1)
+	}
+	c.ktlsNoPadViolation()
+	if c.ConnectionState().KTLS.RXNoPad {
+		t.Fatalf("TLS_RX_EXPECT_NO_PAD still on after %d violations", ktlsNoPadMaxViolations)
+	}
+}
@@ -0,0 +1,174 @@
+package tls
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A KTLSStat holds the kernel-wide kernel TLS counters of
+// /proc/net/tls_stat, as returned by KTLSStats. Counters a kernel does not
+// report are zero.
+//
+// A device offloaded connection that the kernel moves back to software
+// crypto, for example because the NIC lost its state, shows up as
+// RxDeviceResync and DecryptError growing, or as CurrTxDevice and
+// CurrRxDevice dropping while CurrTxSw and CurrRxSw grow.
+type KTLSStat struct {
+	// CurrTxSw, CurrRxSw, CurrTxDevice and CurrRxDevice are the numbers of
+	// sockets currently offloaded to software or device crypto, per
+	// direction (TlsCurrTxSw, TlsCurrRxSw, TlsCurrTxDevice and
+	// TlsCurrRxDevice).
+	CurrTxSw     uint64
+	CurrRxSw     uint64
+	CurrTxDevice uint64
+	CurrRxDevice uint64
+
+	// TxSw, RxSw, TxDevice and RxDevice count the sockets ever offloaded
+	// to software or device crypto, per direction (TlsTxSw, TlsRxSw,
+	// TlsTxDevice and TlsRxDevice).
+	TxSw     uint64
+	RxSw     uint64
+	TxDevice uint64
+	RxDevice uint64
+
+	// DecryptError counts records that failed to decrypt
+	// (TlsDecryptError).
+	DecryptError uint64
+	// RxDeviceResync counts the resynchronizations requested by NICs
+	// decrypting received records (TlsRxDeviceResync).
+	RxDeviceResync uint64
+	// DecryptRetry counts records decrypted again after the NIC handed
+	// them over partially decrypted (TlsDecryptRetry).
+	DecryptRetry uint64
+	// RxNoPadViolation counts records decrypted again because
+	// TLS_RX_EXPECT_NO_PAD was set, see Config.KTLSRXExpectNoPad
+	// (TlsRxNoPadViolation).
+	RxNoPadViolation uint64
+
+	// Counters holds every counter of /proc/net/tls_stat by name,
+	// including those without a field above.
+	Counters map[string]uint64
+}
+
+// parseKTLSStat parses the "name value" lines of /proc/net/tls_stat.
+func parseKTLSStat(r io.Reader) (KTLSStat, error) {
+	s := KTLSStat{Counters: make(map[string]uint64)}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return KTLSStat{}, fmt.Errorf("tls: parsing tls_stat counter %s: %w", fields[0], err)
+		}
+		s.Counters[fields[0]] = v
+		if p := s.field(fields[0]); p != nil {
+			*p = v
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return KTLSStat{}, err
+	}
+	return s, nil
+}
+
+// field returns the field of s holding the counter name, or nil.
+func (s *KTLSStat) field(name string) *uint64 {
+	switch name {
+	case "TlsCurrTxSw":
+		return &s.CurrTxSw
+	case "TlsCurrRxSw":
+		return &s.CurrRxSw
+	case "TlsCurrTxDevice":
+		return &s.CurrTxDevice
+	case "TlsCurrRxDevice":
+		return &s.CurrRxDevice
+	case "TlsTxSw":
+		return &s.TxSw
+	case "TlsRxSw":
+		return &s.RxSw
+	case "TlsTxDevice":
+		return &s.TxDevice
+	case "TlsRxDevice":
+		return &s.RxDevice
+	case "TlsDecryptError":
+		return &s.DecryptError
+	case "TlsRxDeviceResync":
+		return &s.RxDeviceResync
+	case "TlsDecryptRetry":
+		return &s.DecryptRetry
+	case "TlsRxNoPadViolation":
+		return &s.RxNoPadViolation
+	}
+	return nil
+}
+
+// Sub returns the change of the counters of s since prev. The gauges
+// CurrTxSw, CurrRxSw, CurrTxDevice and CurrRxDevice are kept as in s, since
+// they can decrease. A counter missing from prev is taken as zero.
+func (s KTLSStat) Sub(prev KTLSStat) KTLSStat {
+	d := KTLSStat{Counters: make(map[string]uint64, len(s.Counters))}
+	for name, v := range s.Counters {
+		if !strings.HasPrefix(name, "TlsCurr") && v >= prev.Counters[name] {
+			v -= prev.Counters[name]
+		}
+		d.Counters[name] = v
+		if p := d.field(name); p != nil {
+			*p = v
+		}
+	}
+	return d
+}
+
+// readKTLSStat reads the counters for KTLSStatWatcher. Tests replace it.
+var readKTLSStat = KTLSStats
+
+// A KTLSStatWatcher polls /proc/net/tls_stat and reports how the counters
+// changed, so that the kernel silently falling back from device to
+// software crypto can be noticed.
+type KTLSStatWatcher struct {
+	// Interval is the time between two reads of the counters. If zero,
+	// ten seconds is used.
+	Interval time.Duration
+
+	// OnUpdate is called after every read but the first, with the current
+	// counters and their change since the previous read, see KTLSStat.Sub.
+	OnUpdate func(current, delta KTLSStat)
+}
+
+// Run polls the counters until ctx is done, and returns ctx.Err(), or
+// until reading them fails, and returns that error.
+func (w *KTLSStatWatcher) Run(ctx context.Context) error {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	prev, err := readKTLSStat()
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		cur, err := readKTLSStat()
+		if err != nil {
+			return err
+		}
+		if w.OnUpdate != nil {
+			w.OnUpdate(cur, cur.Sub(prev))
+		}
+		prev = cur
+	}
+}
@@ -0,0 +1,17 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import "os"
+
+// KTLSStats returns the kernel-wide kernel TLS counters of
+// /proc/net/tls_stat. The file only exists once the tls module is loaded.
+func KTLSStats() (KTLSStat, error) {
+	f, err := os.Open("/proc/net/tls_stat")
+	if err != nil {
+		return KTLSStat{}, err
+	}
+	defer f.Close()
+	return parseKTLSStat(f)
+}
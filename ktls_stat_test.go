@@ -0,0 +1,90 @@
+package tls
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testKTLSStat = `TlsCurrTxSw                     	2
+TlsCurrRxSw                     	2
+TlsCurrTxDevice                 	1
+TlsCurrRxDevice                 	0
+TlsTxSw                         	10
+TlsRxSw                         	7
+TlsTxDevice                     	3
+TlsRxDevice                     	1
+TlsDecryptError                 	4
+TlsRxDeviceResync               	5
+TlsDecryptRetry                 	6
+TlsRxNoPadViolation             	8
+TlsTxRekeyOk                    	9
+`
+
+func TestParseKTLSStat(t *testing.T) {
+	s, err := parseKTLSStat(strings.NewReader(testKTLSStat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := KTLSStat{
+		CurrTxSw: 2, CurrRxSw: 2, CurrTxDevice: 1,
+		TxSw: 10, RxSw: 7, TxDevice: 3, RxDevice: 1,
+		DecryptError: 4, RxDeviceResync: 5, DecryptRetry: 6, RxNoPadViolation: 8,
+	}
+	if s.Counters["TlsTxRekeyOk"] != 9 || len(s.Counters) != 13 {
+		t.Errorf("Counters = %v", s.Counters)
+	}
+	want.Counters = s.Counters
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("parseKTLSStat = %+v, want %+v", s, want)
+	}
+
+	if _, err := parseKTLSStat(strings.NewReader("TlsTxSw x\n")); err == nil {
+		t.Error("parseKTLSStat accepted a malformed counter")
+	}
+}
+
+func TestKTLSStatSub(t *testing.T) {
+	prev, _ := parseKTLSStat(strings.NewReader("TlsCurrRxDevice 3\nTlsRxSw 7\nTlsDecryptError 1\n"))
+	cur, _ := parseKTLSStat(strings.NewReader("TlsCurrRxDevice 1\nTlsRxSw 9\nTlsDecryptError 4\nTlsDecryptRetry 2\n"))
+	d := cur.Sub(prev)
+	if d.CurrRxDevice != 1 || d.RxSw != 2 || d.DecryptError != 3 || d.DecryptRetry != 2 {
+		t.Errorf("Sub = %+v", d)
+	}
+	if d.Counters["TlsRxSw"] != 2 {
+		t.Errorf("Sub Counters = %v", d.Counters)
+	}
+}
+
+func TestKTLSStatWatcher(t *testing.T) {
+	defer func(f func() (KTLSStat, error)) { readKTLSStat = f }(readKTLSStat)
+	var resyncs uint64
+	readKTLSStat = func() (KTLSStat, error) {
+		resyncs += 2
+		return parseKTLSStat(strings.NewReader("TlsRxDeviceResync " + strconv.FormatUint(resyncs, 10) + "\n"))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var updates int
+	w := &KTLSStatWatcher{
+		Interval: time.Millisecond,
+		OnUpdate: func(current, delta KTLSStat) {
+			updates++
+			if delta.RxDeviceResync != 2 || current.RxDeviceResync != resyncs {
+				t.Errorf("OnUpdate(%+v, %+v)", current, delta)
+			}
+			if updates == 3 {
+				cancel()
+			}
+		},
+	}
+	if err := w.Run(ctx); err != context.Canceled {
+		t.Errorf("Run = %v, want context.Canceled", err)
+	}
+	if updates != 3 {
+		t.Errorf("got %d updates, want 3", updates)
+	}
+}
@@ -0,0 +1,255 @@
+package tls
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// A Histogram is a snapshot of a distribution of observed values.
+//
+// Counts[i] is the number of values less than or equal to Bounds[i] and
+// greater than Bounds[i-1]. The last element of Counts, which has no
+// corresponding bound, counts the values greater than every bound.
+type Histogram struct {
+	Bounds []int64
+	Counts []uint64
+	Count  uint64 // total number of observed values
+	Sum    int64  // sum of all observed values
+}
+
+// Mean returns the average of the observed values, or zero if none were
+// observed.
+func (h Histogram) Mean() float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	return float64(h.Sum) / float64(h.Count)
+}
+
+// ConnStats is a snapshot of the statistics collected for a Conn.
+type ConnStats struct {
+	// CorrelationID is the correlation ID of the connection's context, see
+	// Conn.SetContext.
+	CorrelationID string
+
+	// TXRecordSize is the distribution of application data payload sizes,
+	// in bytes, handed to the socket per record. With kernel TLS TX
+	// enabled it counts the payload of each sendmsg, which the kernel may
+	// further split into records of at most 16 KiB.
+	TXRecordSize Histogram
+	// RXRecordSize is the distribution of application data payload sizes,
+	// in bytes, per received record.
+	RXRecordSize Histogram
+
+	// TXLatency is the distribution of the time, in nanoseconds, between a
+	// call to Write and its data being handed to the socket. It includes
+	// any time spent in the write coalescer.
+	TXLatency Histogram
+	// RXLatency is the distribution of the time, in nanoseconds, between a
+	// record being received from the socket and its last byte being
+	// returned by Read.
+	RXLatency Histogram
+
+	// HandshakeLatency is the distribution of the duration, in
+	// nanoseconds, of the successful handshakes of the connection,
+	// including the time spent enabling kernel TLS.
+	HandshakeLatency Histogram
+	// KTLSTXEnableLatency and KTLSRXEnableLatency are the distributions
+	// of the time, in nanoseconds, spent programming TLS_TX and TLS_RX
+	// into the kernel, including attaching the "tls" upper layer protocol
+	// to the socket. Failed attempts are counted too.
+	KTLSTXEnableLatency Histogram
+	KTLSRXEnableLatency Histogram
+
+	// RXOffloadLost reports whether the NIC stopped decrypting the
+	// records received with kernel TLS, see Config.OnRXOffloadLost.
+	RXOffloadLost bool
+
+	// KTLSRecordsRead counts the records received with kernel TLS RX, and
+	// KTLSControlRecordsRead those of them that were not application
+	// data. KTLSControlRecordsSent counts the alerts and handshake
+	// messages sent with kernel TLS TX as control messages.
+	KTLSRecordsRead        uint64
+	KTLSControlRecordsRead uint64
+	KTLSControlRecordsSent uint64
+
+	// SendfileBytes counts the application data sent with sendfile, by
+	// ReadFrom, SendSection and SendFile, and SpliceBytes that spliced from
+	// the socket, by WriteTo and SpliceTo. Neither is copied through user
+	// space. TXCopyBytes and RXCopyBytes count the application data
+	// copied between user-space buffers and the socket instead, whether or
+	// not the kernel encrypted or decrypted it.
+	SendfileBytes uint64
+	SpliceBytes   uint64
+	TXCopyBytes   uint64
+	RXCopyBytes   uint64
+}
+
+var (
+	recordSizeBounds = []int64{64, 128, 256, 512, 1024, 2048, 4096, 8192, 16384}
+	latencyBounds    = []int64{
+		int64(time.Microsecond),
+		int64(10 * time.Microsecond),
+		int64(100 * time.Microsecond),
+		int64(time.Millisecond),
+		int64(10 * time.Millisecond),
+		int64(100 * time.Millisecond),
+		int64(time.Second),
+	}
+)
+
+// maxHistogramBuckets bounds the number of buckets of a histogram,
+// including the overflow bucket.
+const maxHistogramBuckets = 16
+
+// histogram is a lock-free fixed-bucket histogram. Its zero value is ready
+// to use; the bucket bounds are supplied by the caller on every use.
+type histogram struct {
+	counts [maxHistogramBuckets]atomic.Uint64
+	count  atomic.Uint64
+	sum    atomic.Int64
+}
+
+func (h *histogram) observe(bounds []int64, v int64) {
+	i := 0
+	for i < len(bounds) && v > bounds[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.count.Add(1)
+	h.sum.Add(v)
+}
+
+func (h *histogram) snapshot(bounds []int64) Histogram {
+	s := Histogram{
+		Bounds: bounds,
+		Counts: make([]uint64, len(bounds)+1),
+		Count:  h.count.Load(),
+		Sum:    h.sum.Load(),
+	}
+	for i := range s.Counts {
+		s.Counts[i] = h.counts[i].Load()
+	}
+	return s
+}
+
+// connStats holds the live counters behind ConnStats. It is safe for
+// concurrent use, so Stats can be called while Read or Write is blocked.
+type connStats struct {
+	txRecordSize histogram
+	rxRecordSize histogram
+	txLatency    histogram
+	rxLatency    histogram
+
+	handshakeLatency histogram
+	ktlsTXEnable     histogram
+	ktlsRXEnable     histogram
+
+	ktlsRecordsRead        atomic.Uint64
+	ktlsControlRecordsRead atomic.Uint64
+	ktlsControlRecordsSent atomic.Uint64
+
+	sendfileBytes atomic.Uint64
+	spliceBytes   atomic.Uint64
+	txCopyBytes   atomic.Uint64
+	rxCopyBytes   atomic.Uint64
+}
+
+// KTLSProcessStats are process-wide kernel TLS counters, as returned by
+// ProcessKTLSStats.
+type KTLSProcessStats struct {
+	// OffloadedConns is the number of connections, not yet closed, with at
+	// least one direction offloaded to the kernel.
+	OffloadedConns int64
+	// TXFallbacks and RXFallbacks count the handshakes after which the
+	// corresponding direction was left to user space, as reported to
+	// Config.OnKTLSFallback. Directions disabled by configuration are not
+	// counted.
+	TXFallbacks uint64
+	RXFallbacks uint64
+}
+
+var ktlsProcessStats struct {
+	offloadedConns atomic.Int64
+	txFallbacks    atomic.Uint64
+	rxFallbacks    atomic.Uint64
+}
+
+// ProcessKTLSStats returns the kernel TLS counters of the process. Package
+// ktlsexpvar publishes them with expvar.
+func ProcessKTLSStats() KTLSProcessStats {
+	return KTLSProcessStats{
+		OffloadedConns: ktlsProcessStats.offloadedConns.Load(),
+		TXFallbacks:    ktlsProcessStats.txFallbacks.Load(),
+		RXFallbacks:    ktlsProcessStats.rxFallbacks.Load(),
+	}
+}
+
+// countKTLSOffload counts c in KTLSProcessStats.OffloadedConns, once a
+// direction has been offloaded, until uncountKTLSOffload.
+func (c *Conn) countKTLSOffload() {
+	if c.ktlsCounted.CompareAndSwap(false, true) {
+		ktlsProcessStats.offloadedConns.Add(1)
+	}
+}
+
+func (c *Conn) uncountKTLSOffload() {
+	if c.ktlsCounted.CompareAndSwap(true, false) {
+		ktlsProcessStats.offloadedConns.Add(-1)
+	}
+}
+
+// Stats returns a snapshot of the statistics collected for the connection.
+// It is safe to call concurrently with Read and Write.
+func (c *Conn) Stats() ConnStats {
+	return ConnStats{
+		CorrelationID: c.CorrelationID(),
+		TXRecordSize:  c.stats.txRecordSize.snapshot(recordSizeBounds),
+		RXRecordSize:  c.stats.rxRecordSize.snapshot(recordSizeBounds),
+		TXLatency:     c.stats.txLatency.snapshot(latencyBounds),
+		RXLatency:     c.stats.rxLatency.snapshot(latencyBounds),
+
+		HandshakeLatency:    c.stats.handshakeLatency.snapshot(latencyBounds),
+		KTLSTXEnableLatency: c.stats.ktlsTXEnable.snapshot(latencyBounds),
+		KTLSRXEnableLatency: c.stats.ktlsRXEnable.snapshot(latencyBounds),
+
+		RXOffloadLost: c.rxOffload.lost.Load(),
+
+		KTLSRecordsRead:        c.stats.ktlsRecordsRead.Load(),
+		KTLSControlRecordsRead: c.stats.ktlsControlRecordsRead.Load(),
+		KTLSControlRecordsSent: c.stats.ktlsControlRecordsSent.Load(),
+
+		SendfileBytes: c.stats.sendfileBytes.Load(),
+		SpliceBytes:   c.stats.spliceBytes.Load(),
+		TXCopyBytes:   c.stats.txCopyBytes.Load(),
+		RXCopyBytes:   c.stats.rxCopyBytes.Load(),
+	}
+}
+
+// observeTXRecord records an application data record of n bytes handed to
+// the socket on behalf of the Write that started at c.txStart. c.out must
+// be locked.
+func (c *Conn) observeTXRecord(n int) {
+	c.stats.txRecordSize.observe(recordSizeBounds, int64(n))
+	c.stats.txCopyBytes.Add(uint64(n))
+	if !c.txStart.IsZero() {
+		c.stats.txLatency.observe(latencyBounds, int64(time.Since(c.txStart)))
+	}
+}
+
+// observeRXRecord records a received application data record of n bytes.
+// c.in must be locked.
+func (c *Conn) observeRXRecord(n int) {
+	c.stats.rxRecordSize.observe(recordSizeBounds, int64(n))
+	c.stats.rxCopyBytes.Add(uint64(n))
+	c.rxRecordAt = time.Now()
+}
+
+// observeRXDelivered records that the current record has been fully
+// returned by Read. c.in must be locked.
+func (c *Conn) observeRXDelivered() {
+	if !c.rxRecordAt.IsZero() {
+		c.stats.rxLatency.observe(latencyBounds, int64(time.Since(c.rxRecordAt)))
+		c.rxRecordAt = time.Time{}
+	}
+}
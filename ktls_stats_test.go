@@ -0,0 +1,103 @@
+package tls
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	var h histogram
+	bounds := []int64{10, 100}
+	for _, v := range []int64{1, 10, 11, 100, 1000} {
+		h.observe(bounds, v)
+	}
+	s := h.snapshot(bounds)
+	if want := []uint64{2, 2, 1}; !equalUint64s(s.Counts, want) {
+		t.Errorf("Counts = %v, want %v", s.Counts, want)
+	}
+	if s.Count != 5 || s.Sum != 1122 {
+		t.Errorf("Count, Sum = %d, %d, want 5, 1122", s.Count, s.Sum)
+	}
+}
+
+func equalUint64s(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestConnStats(t *testing.T) {
+	client, server := coalescePipe(t, 0)
+	defer client.Close()
+
+	go func() {
+		server.Write(make([]byte, 100))
+		server.Write(make([]byte, 5000))
+		server.Close()
+	}()
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(client); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, rx := server.Stats(), client.Stats()
+	if tx.TXRecordSize.Count == 0 || tx.TXRecordSize.Sum != 5100 {
+		t.Errorf("server TXRecordSize = %+v, want a sum of 5100 bytes", tx.TXRecordSize)
+	}
+	if tx.TXLatency.Count != tx.TXRecordSize.Count {
+		t.Errorf("server TXLatency.Count = %d, want %d", tx.TXLatency.Count, tx.TXRecordSize.Count)
+	}
+	if rx.RXRecordSize.Sum != 5100 {
+		t.Errorf("client RXRecordSize.Sum = %d, want 5100", rx.RXRecordSize.Sum)
+	}
+	if tx.TXCopyBytes != 5100 || rx.RXCopyBytes != 5100 {
+		t.Errorf("TXCopyBytes, RXCopyBytes = %d, %d; want 5100", tx.TXCopyBytes, rx.RXCopyBytes)
+	}
+	if tx.SendfileBytes != 0 || rx.SpliceBytes != 0 || rx.KTLSRecordsRead != 0 {
+		t.Errorf("kernel TLS counters = %+v, want zero without kernel TLS", rx)
+	}
+	if rx.RXLatency.Count != rx.RXRecordSize.Count {
+		t.Errorf("client RXLatency.Count = %d, want %d", rx.RXLatency.Count, rx.RXRecordSize.Count)
+	}
+	if tx.HandshakeLatency.Count != 1 || rx.HandshakeLatency.Count != 1 {
+		t.Errorf("HandshakeLatency.Count = %d, %d; want 1", tx.HandshakeLatency.Count, rx.HandshakeLatency.Count)
+	}
+	if rx.HandshakeLatency.Sum <= 0 {
+		t.Errorf("client HandshakeLatency.Sum = %d, want a positive duration", rx.HandshakeLatency.Sum)
+	}
+}
+
+func TestProcessKTLSStats(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := Client(c1, testConfig.Clone())
+	before := ProcessKTLSStats()
+
+	c.countKTLSOffload()
+	c.countKTLSOffload()
+	if got := ProcessKTLSStats().OffloadedConns; got != before.OffloadedConns+1 {
+		t.Errorf("OffloadedConns = %d, want %d", got, before.OffloadedConns+1)
+	}
+
+	c.ktlsState = KTLSState{TXEnabled: true, RXReason: "test"}
+	c.ktlsReport.Store(true)
+	c.reportKTLS()
+	after := ProcessKTLSStats()
+	if after.TXFallbacks != before.TXFallbacks || after.RXFallbacks != before.RXFallbacks+1 {
+		t.Errorf("fallbacks = %+v, want one more RX fallback than %+v", after, before)
+	}
+
+	c.Close()
+	c.Close()
+	if got := ProcessKTLSStats().OffloadedConns; got != before.OffloadedConns {
+		t.Errorf("OffloadedConns after Close = %d, want %d", got, before.OffloadedConns)
+	}
+}
@@ -0,0 +1,156 @@
+package tls
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReportKTLSFallback(t *testing.T) {
+	var calls int
+	var got KTLSState
+	config := testConfig.Clone()
+	config.OnKTLSFallback = func(conn *Conn, state KTLSState) {
+		calls++
+		got = state
+		// The callback must be able to use the connection.
+		if conn.ConnectionState().KTLS != state {
+			t.Errorf("ConnectionState().KTLS does not match the reported state")
+		}
+	}
+	c := &Conn{config: config}
+	c.ktlsState = KTLSState{TXEnabled: true, RXReason: "TLS_RX setsockopt failed"}
+
+	c.reportKTLS()
+	if calls != 0 {
+		t.Fatalf("OnKTLSFallback called without a pending report")
+	}
+	c.ktlsReport.Store(true)
+	c.reportKTLS()
+	c.reportKTLS()
+	if calls != 1 {
+		t.Fatalf("OnKTLSFallback called %d times, want 1", calls)
+	}
+	if got != c.ktlsState {
+		t.Errorf("OnKTLSFallback state = %+v, want %+v", got, c.ktlsState)
+	}
+
+	var enabled int
+	config.OnKTLSEnabled = func(conn *Conn, state KTLSState) {
+		enabled++
+		got = state
+	}
+	c.ktlsState = KTLSState{TXEnabled: true, RXEnabled: true}
+	c.ktlsReport.Store(true)
+	c.reportKTLS()
+	if calls != 1 || enabled != 1 {
+		t.Fatalf("got %d OnKTLSFallback and %d OnKTLSEnabled calls, want 1 and 1", calls, enabled)
+	}
+	if got != c.ktlsState {
+		t.Errorf("OnKTLSEnabled state = %+v, want %+v", got, c.ktlsState)
+	}
+
+	// A direction disabled by configuration is not a fallback.
+	before := ProcessKTLSStats()
+	c.ktlsState = KTLSState{TXEnabled: true, RXDisabled: true, RXReason: "disabled by Config.KTLSRXDisabled"}
+	c.ktlsReport.Store(true)
+	c.reportKTLS()
+	if calls != 1 || enabled != 2 {
+		t.Fatalf("got %d OnKTLSFallback and %d OnKTLSEnabled calls, want 1 and 2", calls, enabled)
+	}
+	c.ktlsState = KTLSState{TXDisabled: true, RXDisabled: true, TXReason: "disabled by Config.KTLSMode", RXReason: "disabled by Config.KTLSMode"}
+	c.ktlsReport.Store(true)
+	c.reportKTLS()
+	if calls != 1 || enabled != 2 {
+		t.Fatalf("got %d OnKTLSFallback and %d OnKTLSEnabled calls, want 1 and 2", calls, enabled)
+	}
+	if after := ProcessKTLSStats(); after.TXFallbacks != before.TXFallbacks || after.RXFallbacks != before.RXFallbacks {
+		t.Errorf("fallbacks went from %d/%d to %d/%d for disabled directions", before.TXFallbacks, before.RXFallbacks, after.TXFallbacks, after.RXFallbacks)
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	c, s := localPipe(t)
+	defer c.Close()
+
+	serverConfig := testConfig.Clone()
+	serverConfig.HandshakeTimeout = 20 * time.Millisecond
+	server := Server(s, serverConfig)
+
+	// The client never sends a ClientHello.
+	errChan := make(chan error, 1)
+	go func() { errChan <- server.Handshake() }()
+	select {
+	case err := <-errChan:
+		if err != context.DeadlineExceeded {
+			t.Errorf("got %v, want context.DeadlineExceeded", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handshake did not time out")
+	}
+}
+
+// recordingSessionCache is a ClientSessionCache that records stored
+// sessions.
+type recordingSessionCache struct {
+	puts int
+}
+
+func (c *recordingSessionCache) Get(string) (*ClientSessionState, bool) { return nil, false }
+
+func (c *recordingSessionCache) Put(_ string, cs *ClientSessionState) {
+	if cs != nil {
+		c.puts++
+	}
+}
+
+// TestKTLSRXNewSessionTicket checks that a NewSessionTicket received as a
+// handshake record from the kernel reaches the client session cache.
+func TestKTLSRXNewSessionTicket(t *testing.T) {
+	cache := &recordingSessionCache{}
+	clientConfig := testConfig.Clone()
+	clientConfig.MinVersion = VersionTLS13
+	clientConfig.ClientSessionCache = cache
+	serverConfig := testConfig.Clone()
+	serverConfig.SessionTicketsDisabled = true
+	c, s := localPipe(t)
+	client := Client(c, clientConfig)
+	server := Server(s, serverConfig)
+	defer client.Close()
+	defer server.Close()
+
+	errs := make(chan error, 1)
+	go func() { errs <- server.Handshake() }()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := (&newSessionTicketMsgTLS13{
+		lifetime: 3600,
+		ageAdd:   1,
+		nonce:    []byte{0},
+		label:    []byte("ticket"),
+	}).marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.in.Lock()
+	defer client.in.Unlock()
+	// Stand in for the kernel: with TLS_RX enabled, recvmsg returns the
+	// decrypted record and its type.
+	client.in.cipher = kTLSCipher{}
+	client.ktlsPendingType, client.ktlsPending = recordTypeHandshake, msg
+	err = client.readRecord()
+	for err == nil && client.hand.Len() > 0 {
+		err = client.handlePostHandshakeMessage()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.puts != 1 {
+		t.Errorf("%d sessions stored, want 1", cache.puts)
+	}
+}
@@ -0,0 +1,50 @@
+package tls
+
+import "fmt"
+
+// ktlsVerifier checks an offloaded direction against the user-space cipher
+// it replaced, by running a sample of the data sent or received over it
+// through a shadow socket programmed with the kernel's crypto state. See
+// Config.KTLSVerifyInterval.
+type ktlsVerifier struct {
+	interval int
+	opt      int    // TLS_TX or TLS_RX
+	version  uint16 // protocol version of the records
+	cipher   any    // user-space AEAD for the direction's traffic key
+	seq      [8]byte
+	calls    uint64 // sends or receives since offload was enabled
+	records  uint64 // records known to have been sent since then
+	compat   bool   // Config.KTLSSeccompCompat
+}
+
+func newKTLSVerifier(interval, opt int, version uint16, cipher any, seq [8]byte, compat bool) *ktlsVerifier {
+	return &ktlsVerifier{
+		interval: interval,
+		opt:      opt,
+		version:  version,
+		cipher:   cipher,
+		seq:      seq,
+		compat:   compat,
+	}
+}
+
+// A KTLSVerifyError is returned by Read and Write when
+// Config.KTLSVerifyInterval is set and the kernel's handling of an
+// offloaded direction doesn't match the user-space cipher it replaced.
+type KTLSVerifyError struct {
+	// Direction is "TX" or "RX".
+	Direction string
+	// Field names what didn't match: "record" if a sample record
+	// encrypted by one side didn't decrypt to the same data on the other,
+	// "rec_seq" if the kernel's record sequence number fell behind the
+	// records sent, or "crypto_info" if the kernel's state couldn't be
+	// parsed.
+	Field string
+	// Calls is the number of sends or receives over the direction since
+	// offload was enabled.
+	Calls uint64
+}
+
+func (e *KTLSVerifyError) Error() string {
+	return fmt.Sprintf("tls: kernel TLS %s %s mismatch after %d calls", e.Direction, e.Field, e.Calls)
+}
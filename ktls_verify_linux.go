@@ -0,0 +1,193 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// ktlsShadowTimeout bounds how long a shadow check waits for its loopback
+// sockets, so that a kernel swallowing the sample doesn't hang the
+// connection.
+const ktlsShadowTimeout = 5 * time.Second
+
+// record notes that data went through the offloaded direction in one send
+// or receive on sock and, every v.interval calls, checks the kernel's
+// handling of it.
+func (v *ktlsVerifier) record(sock syscall.Conn, data []byte) error {
+	v.calls++
+	if v.opt == TLS_TX {
+		// The kernel splits a send into records of at most maxPlaintext
+		// bytes, and more when aligning records to the MSS.
+		v.records += uint64((len(data) + maxPlaintext - 1) / maxPlaintext)
+	}
+	if v.calls%uint64(v.interval) != 0 {
+		return nil
+	}
+	return v.check(sock, data)
+}
+
+// check reads the crypto_info back from the kernel, checks its record
+// sequence number and programs it, as read, into a shadow socket that
+// handles sample in the same direction, see shadowTX and shadowRX.
+func (v *ktlsVerifier) check(sock syscall.Conn, sample []byte) error {
+	var info [256]byte
+	defer func() { info = [256]byte{} }()
+	n, err := ktlsGetCryptoInfo(sock, v.opt, v.compat, info[:])
+	if err != nil {
+		// Older kernels cannot report the state back; nothing to check.
+		Debugf("kTLS: verify: getsockopt(SOL_TLS, %d) failed: %s", v.opt, err)
+		return nil
+	}
+	recSeq, err := v.compareSeq(info[:n])
+	if err != nil || v.cipher == nil {
+		return err
+	}
+	if len(sample) > maxPlaintext {
+		sample = sample[:maxPlaintext]
+	}
+	if len(sample) == 0 {
+		return nil
+	}
+
+	var match bool
+	if v.opt == TLS_TX {
+		match, err = v.shadowTX(info[:n], recSeq, sample)
+	} else {
+		match, err = v.shadowRX(info[:n], recSeq, sample)
+	}
+	if err != nil {
+		// The check itself couldn't run, which says nothing about the
+		// connection.
+		Debugf("kTLS: verify: shadow socket failed: %s", err)
+		return nil
+	}
+	if !match {
+		return v.mismatch("record")
+	}
+	return nil
+}
+
+// compareSeq returns the record sequence number of a crypto_info struct as
+// returned by getsockopt, which is its last field for every cipher, and
+// checks that it advanced by at least the records sent since offload.
+func (v *ktlsVerifier) compareSeq(b []byte) (recSeq [8]byte, err error) {
+	if len(b) < int(unsafe.Sizeof(kTLSCryptoInfo{}))+len(recSeq) {
+		return recSeq, v.mismatch("crypto_info")
+	}
+	copy(recSeq[:], b[len(b)-len(recSeq):])
+	if binary.BigEndian.Uint64(recSeq[:]) < binary.BigEndian.Uint64(v.seq[:])+v.records {
+		return recSeq, v.mismatch("rec_seq")
+	}
+	return recSeq, nil
+}
+
+// shadowTX sends sample through a loopback socket with TLS_TX set to info
+// and reports whether the record the kernel produced decrypts to sample
+// with the user-space cipher.
+func (v *ktlsVerifier) shadowTX(info []byte, recSeq [8]byte, sample []byte) (bool, error) {
+	tx, peer, err := ktlsShadowPair()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Close()
+	defer peer.Close()
+	if err := ktlsSetCryptoInfo(tx, TLS_TX, false, v.compat, unsafe.Pointer(&info[0]), uintptr(len(info))); err != nil {
+		return false, err
+	}
+	if _, err := tx.Write(sample); err != nil {
+		return false, err
+	}
+
+	record := make([]byte, recordHeaderLen)
+	if _, err := io.ReadFull(peer, record); err != nil {
+		return false, err
+	}
+	record = append(record, make([]byte, int(record[3])<<8|int(record[4]))...)
+	if _, err := io.ReadFull(peer, record[recordHeaderLen:]); err != nil {
+		return false, err
+	}
+	hc := &halfConn{version: v.version, cipher: v.cipher, seq: recSeq}
+	plaintext, typ, err := hc.decrypt(record)
+	return err == nil && typ == recordTypeApplicationData && bytes.Equal(plaintext, sample), nil
+}
+
+// shadowRX encrypts sample with the user-space cipher, receives it on a
+// loopback socket with TLS_RX set to info and reports whether the kernel
+// decrypted it back to sample.
+func (v *ktlsVerifier) shadowRX(info []byte, recSeq [8]byte, sample []byte) (bool, error) {
+	peer, rx, err := ktlsShadowPair()
+	if err != nil {
+		return false, err
+	}
+	defer peer.Close()
+	defer rx.Close()
+	if err := ktlsSetCryptoInfo(rx, TLS_RX, false, v.compat, unsafe.Pointer(&info[0]), uintptr(len(info))); err != nil {
+		return false, err
+	}
+
+	vers := v.version
+	if vers == VersionTLS13 {
+		vers = VersionTLS12
+	}
+	record := []byte{byte(recordTypeApplicationData), byte(vers >> 8), byte(vers), byte(len(sample) >> 8), byte(len(sample))}
+	hc := &halfConn{version: v.version, cipher: v.cipher, seq: recSeq}
+	record, err = hc.encrypt(record, sample, rand.Reader)
+	if err != nil {
+		return false, err
+	}
+	if _, err := peer.Write(record); err != nil {
+		return false, err
+	}
+
+	plaintext := make([]byte, len(sample))
+	if _, err := io.ReadFull(rx, plaintext); err != nil {
+		if errors.Is(err, syscall.EBADMSG) {
+			return false, nil
+		}
+		return false, err
+	}
+	return bytes.Equal(plaintext, sample), nil
+}
+
+// ktlsShadowPair returns a connected pair of loopback TCP sockets for a
+// shadow check.
+func ktlsShadowPair() (dialed, accepted *net.TCPConn, err error) {
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer ln.Close()
+	ln.SetDeadline(time.Now().Add(ktlsShadowTimeout))
+	dialed, err = net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		return nil, nil, err
+	}
+	accepted, err = ln.AcceptTCP()
+	if err != nil {
+		dialed.Close()
+		return nil, nil, err
+	}
+	deadline := time.Now().Add(ktlsShadowTimeout)
+	dialed.SetDeadline(deadline)
+	accepted.SetDeadline(deadline)
+	return dialed, accepted, nil
+}
+
+func (v *ktlsVerifier) mismatch(field string) error {
+	dir := "TX"
+	if v.opt == TLS_RX {
+		dir = "RX"
+	}
+	Debugf("kTLS: verify: %s %s mismatch after %d calls", dir, field, v.calls)
+	return &KTLSVerifyError{Direction: dir, Field: field, Calls: v.calls}
+}
@@ -0,0 +1,83 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"unsafe"
+)
+
+func TestKTLSVerifyRecSeq(t *testing.T) {
+	state := func(recSeq byte) []byte {
+		ci := kTLSCryptoInfoAESGCM128{info: kTLSCryptoInfo{version: VersionTLS13, cipherType: kTLS_CIPHER_AES_GCM_128}}
+		ci.recSeq = [8]byte{7: recSeq}
+		return append([]byte(nil), (*[kTLSCryptoInfoSize_AES_GCM_128]byte)(unsafe.Pointer(&ci))[:]...)
+	}
+
+	tests := []struct {
+		name  string
+		b     []byte
+		field string
+	}{
+		{"advanced", state(9), ""},
+		{"exact", state(8), ""},
+		{"behind", state(7), "rec_seq"},
+		{"short", state(8)[:6], "crypto_info"},
+	}
+	for _, tt := range tests {
+		v := newKTLSVerifier(1, TLS_TX, VersionTLS13, nil, [8]byte{7: 5}, false)
+		v.records = 3
+		_, err := v.compareSeq(tt.b)
+		if tt.field == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tt.name, err)
+			}
+			continue
+		}
+		var verr *KTLSVerifyError
+		if !errors.As(err, &verr) || verr.Field != tt.field || verr.Direction != "TX" {
+			t.Errorf("%s: got %v, want a %s TX mismatch", tt.name, err, tt.field)
+		}
+	}
+}
+
+// TestKTLSVerifyShadow programs both directions of a loopback connection
+// and checks that the shadow check accepts the user-space cipher for the
+// key that was programmed and rejects one for another key.
+func TestKTLSVerifyShadow(t *testing.T) {
+	ln := newLocalListener(t)
+	defer ln.Close()
+	tcpConn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpConn.Close()
+
+	suite := cipherSuiteTLS13ByID(TLS_AES_128_GCM_SHA256)
+	key, iv := bytes.Repeat([]byte{1}, 16), bytes.Repeat([]byte{2}, 12)
+	otherKey := bytes.Repeat([]byte{3}, 16)
+	var seq [8]byte
+	for _, opt := range []int{TLS_TX, TLS_RX} {
+		if err := ktlsEnableAES128GCM(tcpConn, VersionTLS13, opt, opt == TLS_RX, false, key, iv, seq[:]); err != nil {
+			t.Skipf("kernel TLS unavailable: %v", err)
+		}
+	}
+
+	sample := []byte("shadow sample")
+	for _, opt := range []int{TLS_TX, TLS_RX} {
+		v := newKTLSVerifier(1, opt, VersionTLS13, suite.aead(key, iv), seq, false)
+		if err := v.check(tcpConn, sample); err != nil {
+			t.Errorf("opt %d: check with the programmed key: %v", opt, err)
+		}
+
+		v = newKTLSVerifier(1, opt, VersionTLS13, suite.aead(otherKey, iv), seq, false)
+		var verr *KTLSVerifyError
+		if err := v.check(tcpConn, sample); !errors.As(err, &verr) || verr.Field != "record" {
+			t.Errorf("opt %d: check with another key = %v, want a record mismatch", opt, err)
+		}
+	}
+}
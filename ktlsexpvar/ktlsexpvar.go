@@ -0,0 +1,40 @@
+// Package ktlsexpvar publishes the kernel TLS state of the process with
+// package expvar, so that it is served on /debug/vars. Importing it for its
+// side effects is enough:
+//
+//	import _ "github.com/secure-for-ai/goktls/ktlsexpvar"
+//
+// The variables are:
+//
+//	ktls.features            the offload features of the kernel, see tls.CurrentKTLSFeatures
+//	ktls.unsupported_reason  why kernel TLS is not used, see tls.KTLSUnsupportedReason
+//	ktls.offloaded_conns     the open connections with a direction offloaded
+//	ktls.tx_fallbacks        the handshakes that left TX to user space
+//	ktls.rx_fallbacks        the handshakes that left RX to user space
+//
+// The counters are those of tls.ProcessKTLSStats.
+package ktlsexpvar
+
+import (
+	"expvar"
+
+	tls "github.com/secure-for-ai/goktls"
+)
+
+func init() {
+	expvar.Publish("ktls.features", expvar.Func(func() any {
+		return tls.CurrentKTLSFeatures()
+	}))
+	expvar.Publish("ktls.unsupported_reason", expvar.Func(func() any {
+		return tls.KTLSUnsupportedReason()
+	}))
+	expvar.Publish("ktls.offloaded_conns", expvar.Func(func() any {
+		return tls.ProcessKTLSStats().OffloadedConns
+	}))
+	expvar.Publish("ktls.tx_fallbacks", expvar.Func(func() any {
+		return tls.ProcessKTLSStats().TXFallbacks
+	}))
+	expvar.Publish("ktls.rx_fallbacks", expvar.Func(func() any {
+		return tls.ProcessKTLSStats().RXFallbacks
+	}))
+}
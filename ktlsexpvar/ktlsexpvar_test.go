@@ -0,0 +1,29 @@
+package ktlsexpvar
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+
+	tls "github.com/secure-for-ai/goktls"
+)
+
+func TestPublished(t *testing.T) {
+	var features tls.KTLSFeatures
+	if err := json.Unmarshal([]byte(expvar.Get("ktls.features").String()), &features); err != nil {
+		t.Fatalf("ktls.features: %v", err)
+	}
+	if features != tls.CurrentKTLSFeatures() {
+		t.Errorf("ktls.features = %+v, want %+v", features, tls.CurrentKTLSFeatures())
+	}
+	for _, name := range []string{"ktls.offloaded_conns", "ktls.tx_fallbacks", "ktls.rx_fallbacks"} {
+		var n int64
+		if err := json.Unmarshal([]byte(expvar.Get(name).String()), &n); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	var reason string
+	if err := json.Unmarshal([]byte(expvar.Get("ktls.unsupported_reason").String()), &reason); err != nil {
+		t.Errorf("ktls.unsupported_reason: %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+// Package ktlshttp serves HTTP over connections of this module's TLS
+// package, so that responses written from files, such as those of
+// http.ServeFile, http.ServeContent and http.FileServer, are sent with
+// sendfile once kernel TLS TX is enabled.
+//
+// net/http only treats crypto/tls connections as TLS, so it serves these
+// connections as it would plain ones: http.Request.TLS is nil, use
+// ConnectionState instead. Since the response writer then hands file bodies
+// to the connection's ReadFrom, which Conn implements with sendfile, no
+// plaintext copy of the file is made in user space.
+//
+// Only HTTP/1.1 benefits: HTTP/2 frames responses itself and never reaches
+// ReadFrom. Unless Server.ServeH2 is set, h2 is therefore not offered with
+// ALPN and clients fall back to HTTP/1.1.
+//
+// On the client side, Dialer makes the connections of an http.Transport
+// with this package, so that response bodies can be decrypted by the
+// kernel, and WithClientConn tells which connection served a request.
+package ktlshttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	tls "github.com/secure-for-ai/goktls"
+)
+
+// A Server is an http.Server serving HTTPS over kernel TLS. Its TLSConfig
+// and TLSNextProto fields are ignored; set Config instead. The
+// ListenAndServe method of http.Server still serves plain HTTP.
+type Server struct {
+	http.Server
+
+	// Config is the TLS configuration of the server. It must include at
+	// least one certificate or set GetCertificate, unless
+	// ListenAndServeTLS or ServeTLS is given certificate files. If its
+	// NextProtos is empty, "http/1.1" is offered, preceded by "h2" if
+	// ServeH2 is set.
+	Config *tls.Config
+
+	// ServeH2, if not nil, serves the connections that negotiated h2 with
+	// ALPN, for example with golang.org/x/net/http2:
+	//
+	//	ServeH2: func(c *tls.Conn, h http.Handler) {
+	//		h2.ServeConn(c, &http2.ServeConnOpts{Handler: h})
+	//	}
+	//
+	// Those connections are not tracked by Shutdown or Close.
+	ServeH2 func(c *tls.Conn, h http.Handler)
+
+	connContextOnce sync.Once
+}
+
+// connKey is the context key under which ConnContext stores the *tls.Conn
+// of a request.
+type connKey struct{}
+
+// ConnectionState returns the TLS state of the connection r was received
+// on, including its kernel TLS state, or false if r was not served by a
+// Server.
+func ConnectionState(r *http.Request) (tls.ConnectionState, bool) {
+	c, ok := r.Context().Value(connKey{}).(*tls.Conn)
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+	return c.ConnectionState(), true
+}
+
+// ListenAndServeTLS listens on s.Addr, or ":https" if it is empty, and
+// serves HTTPS, as http.Server.ListenAndServeTLS.
+func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	addr := s.Addr
+	if addr == "" {
+		addr = ":https"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.ServeTLS(ln, certFile, keyFile)
+}
+
+// ServeTLS serves HTTPS on the TCP connections accepted by ln, loading the
+// certificate from certFile and keyFile if they are not empty. It always
+// returns a non-nil error, http.ErrServerClosed after Shutdown or Close.
+func (s *Server) ServeTLS(ln net.Listener, certFile, keyFile string) error {
+	config := s.config()
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+	s.connContextOnce.Do(func() {
+		connContext := s.ConnContext
+		s.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+			if connContext != nil {
+				ctx = connContext(ctx, c)
+			}
+			return context.WithValue(ctx, connKey{}, c)
+		}
+	})
+	return s.Server.Serve(s.newListener(ln, config))
+}
+
+// Serve serves HTTPS on the TCP connections accepted by ln, with the
+// certificates of s.Config.
+func (s *Server) Serve(ln net.Listener) error {
+	return s.ServeTLS(ln, "", "")
+}
+
+// config returns a copy of s.Config with NextProtos set.
+func (s *Server) config() *tls.Config {
+	config := &tls.Config{}
+	if s.Config != nil {
+		config = s.Config.Clone()
+	}
+	if len(config.NextProtos) == 0 {
+		if s.ServeH2 != nil {
+			config.NextProtos = append(config.NextProtos, "h2")
+		}
+		config.NextProtos = append(config.NextProtos, "http/1.1")
+	}
+	return config
+}
+
+// handshakeTimeout returns the timeout of the handshakes, or zero to use
+// that of tls.KTLSListenerOptions.
+func (s *Server) handshakeTimeout() time.Duration {
+	if s.ReadHeaderTimeout > 0 {
+		return s.ReadHeaderTimeout
+	}
+	return s.ReadTimeout
+}
+
+// newListener returns a listener completing the handshakes of the
+// connections accepted by inner.
+func (s *Server) newListener(inner net.Listener, config *tls.Config) net.Listener {
+	return &listener{
+		Listener: tls.NewKTLSListener(inner, config, tls.KTLSListenerOptions{
+			EagerHandshake:   true,
+			HandshakeTimeout: s.handshakeTimeout(),
+			OnHandshakeError: func(c *tls.Conn, err error) {
+				if s.ErrorLog != nil {
+					s.ErrorLog.Printf("http: TLS handshake error from %s: %v", c.RemoteAddr(), err)
+				}
+			},
+		}),
+		srv: s,
+	}
+}
+
+// A listener hands the connections that negotiated h2 to Server.ServeH2,
+// and the others to http.Server.Serve.
+type listener struct {
+	net.Listener
+	srv *Server
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		tc := c.(*tls.Conn)
+		if l.srv.ServeH2 == nil || tc.ConnectionState().NegotiatedProtocol != "h2" {
+			return c, nil
+		}
+		h := l.srv.Handler
+		if h == nil {
+			h = http.DefaultServeMux
+		}
+		go l.srv.ServeH2(tc, h)
+	}
+}
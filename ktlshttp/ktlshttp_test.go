@@ -0,0 +1,125 @@
+package ktlshttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	stdtls "crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	tls "github.com/secure-for-ai/goktls"
+)
+
+func testCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ktlshttp"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestServeFile(t *testing.T) {
+	content := bytes.Repeat([]byte("kernel tls sendfile "), 20000)
+	path := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var state tls.ConnectionState
+	var stateOK bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/file", func(w http.ResponseWriter, r *http.Request) {
+		state, stateOK = ConnectionState(r)
+		http.ServeFile(w, r, path)
+	})
+	srv := &Server{
+		Server: http.Server{Handler: mux},
+		Config: &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}},
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- srv.Serve(ln) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &stdtls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+	defer client.CloseIdleConnections()
+	resp, err := client.Get("https://" + ln.Addr().String() + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, content) {
+		t.Errorf("got %d bytes, want the %d bytes of the file", len(body), len(content))
+	}
+	if resp.ProtoMajor != 1 || resp.TLS == nil || resp.TLS.NegotiatedProtocol != "http/1.1" {
+		t.Errorf("proto %s, TLS %+v: want HTTP/1.1 negotiated with ALPN", resp.Proto, resp.TLS)
+	}
+	if !stateOK || !state.HandshakeComplete || state.NegotiatedProtocol != "http/1.1" {
+		t.Errorf("ConnectionState = %+v, %v", state, stateOK)
+	}
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != http.ErrServerClosed {
+		t.Errorf("Serve returned %v, want http.ErrServerClosed", err)
+	}
+}
+
+func TestServeH2(t *testing.T) {
+	served := make(chan string, 1)
+	srv := &Server{
+		Config: &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}},
+		ServeH2: func(c *tls.Conn, h http.Handler) {
+			served <- c.ConnectionState().NegotiatedProtocol
+			c.Close()
+		},
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	go srv.Serve(ln)
+
+	c, err := stdtls.Dial("tcp", ln.Addr().String(), &stdtls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2", "http/1.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if got := <-served; got != "h2" {
+		t.Errorf("ServeH2 called for %q", got)
+	}
+}
@@ -0,0 +1,88 @@
+package ktlshttp
+
+import (
+	"context"
+	"net"
+	"net/http/httptrace"
+	"sync"
+
+	tls "github.com/secure-for-ai/goktls"
+)
+
+// A Dialer makes the HTTPS connections of an http.Transport with this
+// package, so that response bodies are decrypted by the kernel once kernel
+// TLS RX is enabled:
+//
+//	d := &ktlshttp.Dialer{Config: config}
+//	client := &http.Client{Transport: &http.Transport{DialTLSContext: d.DialTLSContext}}
+//
+// The Transport's TLSClientConfig and TLSHandshakeTimeout are then ignored.
+// Since the Transport only speaks HTTP/2 over crypto/tls connections, the
+// connections offer only "http/1.1" with ALPN, unless Config.NextProtos
+// says otherwise.
+type Dialer struct {
+	// NetDialer is the dialer of the underlying TCP connections. If nil,
+	// the net.Dialer zero value is used.
+	NetDialer *net.Dialer
+
+	// Config is the TLS configuration of the connections. If nil, the zero
+	// configuration is used. The server name is taken from the dialed
+	// address if Config.ServerName is empty.
+	Config *tls.Config
+
+	// OnConn, if not nil, is called with each connection once its
+	// handshake completed, for example to log or count the connections
+	// whose ConnectionState().KTLS shows that offload was not enabled.
+	OnConn func(c *tls.Conn)
+}
+
+// DialTLSContext connects to addr and performs the TLS handshake. It has
+// the signature of http.Transport.DialTLSContext, and the returned
+// net.Conn is a *tls.Conn.
+func (d *Dialer) DialTLSContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	config := &tls.Config{}
+	if d.Config != nil {
+		config = d.Config.Clone()
+	}
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = []string{"http/1.1"}
+	}
+	td := &tls.Dialer{NetDialer: d.NetDialer, Config: config}
+	c, err := td.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if d.OnConn != nil {
+		d.OnConn(c.(*tls.Conn))
+	}
+	return c, nil
+}
+
+// WithClientConn returns a copy of ctx that records the connection a
+// request made with it is sent on, and a function returning that
+// connection, or nil if the request has not obtained one yet or it is not
+// a *tls.Conn, as when the Transport doesn't use a Dialer:
+//
+//	ctx, conn := ktlshttp.WithClientConn(ctx)
+//	resp, err := client.Do(req.WithContext(ctx))
+//	...
+//	if c := conn(); c != nil && !c.ConnectionState().KTLS.RXEnabled { ... }
+//
+// Any httptrace.ClientTrace already in ctx keeps being called.
+func WithClientConn(ctx context.Context) (context.Context, func() *tls.Conn) {
+	var mu sync.Mutex
+	var conn *tls.Conn
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			c, _ := info.Conn.(*tls.Conn)
+			mu.Lock()
+			conn = c
+			mu.Unlock()
+		},
+	}
+	return httptrace.WithClientTrace(ctx, trace), func() *tls.Conn {
+		mu.Lock()
+		defer mu.Unlock()
+		return conn
+	}
+}
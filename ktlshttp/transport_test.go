@@ -0,0 +1,72 @@
+package ktlshttp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	tls "github.com/secure-for-ai/goktls"
+)
+
+func TestDialer(t *testing.T) {
+	content := bytes.Repeat([]byte("kernel tls download "), 20000)
+	srv := &Server{
+		Server: http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(content)
+		})},
+		Config: &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}},
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	go srv.Serve(ln)
+
+	dialed := make(chan *tls.Conn, 1)
+	d := &Dialer{
+		Config: &tls.Config{InsecureSkipVerify: true},
+		OnConn: func(c *tls.Conn) { dialed <- c },
+	}
+	transport := &http.Transport{DialTLSContext: d.DialTLSContext, ForceAttemptHTTP2: true}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+
+	ctx, conn := WithClientConn(context.Background())
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://"+ln.Addr().String()+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, content) {
+		t.Errorf("got %d bytes, want %d", len(body), len(content))
+	}
+	if resp.ProtoMajor != 1 {
+		t.Errorf("proto %s, want HTTP/1.1", resp.Proto)
+	}
+	c := conn()
+	if c == nil {
+		t.Fatal("WithClientConn recorded no connection")
+	}
+	if got := <-dialed; got != c {
+		t.Error("OnConn and WithClientConn saw different connections")
+	}
+	state := c.ConnectionState()
+	if state.NegotiatedProtocol != "http/1.1" {
+		t.Errorf("NegotiatedProtocol = %q", state.NegotiatedProtocol)
+	}
+	if !state.KTLS.RXEnabled && state.KTLS.RXReason == "" {
+		t.Error("RX neither offloaded nor explained")
+	}
+}
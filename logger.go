@@ -0,0 +1,79 @@
+package tls
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// A Logger receives log messages about connections, such as whether kernel
+// TLS could be enabled and why not. args are alternating keys and values,
+// as for log/slog, whose *slog.Logger implements Logger.
+//
+// Debug receives the messages enabled by Conn.SetDebug and debug builds;
+// they are passed to a Logger regardless, and it is up to the Logger to
+// discard them.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+}
+
+// loggerBox holds the Logger set with SetLogger.
+type loggerBox struct{ Logger }
+
+var defaultLogger atomic.Pointer[loggerBox]
+
+// SetLogger sets the Logger used by connections whose Config has no
+// Logger, and for messages that are not about a connection, such as the
+// kernel TLS features found at startup. A nil l restores the default of
+// writing debug messages with the standard log package.
+func SetLogger(l Logger) {
+	if l == nil {
+		defaultLogger.Store(nil)
+		return
+	}
+	defaultLogger.Store(&loggerBox{l})
+}
+
+// globalLogger returns the Logger set with SetLogger, or nil.
+func globalLogger() Logger {
+	if b := defaultLogger.Load(); b != nil {
+		return b.Logger
+	}
+	return nil
+}
+
+// logger returns the Logger of c, or nil.
+func (c *Conn) logger() Logger {
+	if c.config != nil && c.config.Logger != nil {
+		return c.config.Logger
+	}
+	return globalLogger()
+}
+
+// logArgs prepends the attributes identifying c to args.
+func (c *Conn) logArgs(args []any) []any {
+	if id := c.CorrelationID(); id != "" {
+		return append([]any{"correlation_id", id}, args...)
+	}
+	return args
+}
+
+// logInfo and logWarn log msg for c to its Logger, if any.
+func (c *Conn) logInfo(msg string, args ...any) {
+	if l := c.logger(); l != nil {
+		l.Info(msg, c.logArgs(args)...)
+	}
+}
+
+func (c *Conn) logWarn(msg string, args ...any) {
+	if l := c.logger(); l != nil {
+		l.Warn(msg, c.logArgs(args)...)
+	}
+}
+
+// sprintln is fmt.Sprintln without the trailing newline.
+func sprintln(a ...interface{}) string {
+	return strings.TrimSuffix(fmt.Sprintln(a...), "\n")
+}
@@ -0,0 +1,70 @@
+package tls
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// testLogger is a Logger recording its messages as "level msg args".
+type testLogger struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (l *testLogger) log(level, msg string, args []any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.msgs = append(l.msgs, fmt.Sprint(level, " ", msg, " ", args))
+}
+
+func (l *testLogger) Debug(msg string, args ...any) { l.log("DEBUG", msg, args) }
+func (l *testLogger) Info(msg string, args ...any)  { l.log("INFO", msg, args) }
+func (l *testLogger) Warn(msg string, args ...any)  { l.log("WARN", msg, args) }
+
+func (l *testLogger) messages() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.msgs...)
+}
+
+func TestLogger(t *testing.T) {
+	global := &testLogger{}
+	SetLogger(global)
+	defer SetLogger(nil)
+
+	connLogger := &testLogger{}
+	config := testConfig.Clone()
+	config.Logger = connLogger
+	c, s := localPipe(t)
+	client := Client(c, config)
+	client.SetContext(WithCorrelationID(client.Context(), "log-me"))
+	server := Server(s, testConfig.Clone())
+	defer client.Close()
+	defer server.Close()
+
+	client.debugf("kTLS: %s", "client")
+	client.logWarn("tls: test", "direction", "tx")
+	server.debugln("kTLS:", "server")
+	Debugf("kTLS: %s", "global")
+
+	got := connLogger.messages()
+	want := []string{
+		"DEBUG kTLS: client [correlation_id log-me]",
+		"WARN tls: test [correlation_id log-me direction tx]",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Config.Logger got %q, want %q", got, want)
+	}
+	got = global.messages()
+	want = []string{"DEBUG kTLS: server []", "DEBUG kTLS: global []"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("SetLogger logger got %q, want %q", got, want)
+	}
+
+	SetLogger(nil)
+	server.logInfo("tls: dropped")
+	if n := len(global.messages()); n != 2 {
+		t.Errorf("logger received %d messages after SetLogger(nil), want 2", n)
+	}
+}
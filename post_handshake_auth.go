@@ -0,0 +1,353 @@
+package tls
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"errors"
+	"hash"
+	"io"
+)
+
+// postHandshakeAuthRequest is a post-handshake CertificateRequest waiting for
+// the client's answer. See RFC 8446, Section 4.6.2.
+type postHandshakeAuthRequest struct {
+	context    []byte
+	clientAuth ClientAuthType
+	// transcript is the handshake context of the exchange: the handshake
+	// transcript followed by the CertificateRequest.
+	transcript hash.Hash
+}
+
+var (
+	errPostHandshakeAuthConn    = errors.New("tls: post-handshake authentication requires a TLS 1.3 server connection")
+	errPostHandshakeAuthOffered = errors.New("tls: client did not offer post-handshake authentication")
+	errPostHandshakeAuthPending = errors.New("tls: post-handshake certificate request already in progress")
+)
+
+// RequestClientCertificate asks the client for a certificate using TLS 1.3
+// post-handshake authentication, and reads from the connection until the
+// client's answer has been verified. The client must have offered
+// post-handshake authentication with Config.PostHandshakeAuth.
+//
+// The answer is verified according to clientAuth, as Config.ClientAuth would
+// be during the handshake, and Config.VerifyPeerCertificate and
+// Config.VerifyConnection are called. On success the certificates are
+// reported by ConnectionState. Any error is fatal to the connection.
+//
+// Application data received before the answer is kept and returned by later
+// calls to Read. RequestClientCertificate must not be called concurrently
+// with Read, and the client only answers while it is reading from the
+// connection. If ctx is canceled before the answer is verified, the
+// connection is closed.
+//
+// The exchange uses handshake records, which are sent and received through
+// kernel TLS when the connection is offloaded.
+func (c *Conn) RequestClientCertificate(ctx context.Context, clientAuth ClientAuthType) (ret error) {
+	if err := c.Handshake(); err != nil {
+		return err
+	}
+	if c.isClient || c.vers != VersionTLS13 {
+		return errPostHandshakeAuthConn
+	}
+
+	if ctx.Done() != nil {
+		done := make(chan struct{})
+		interruptRes := make(chan error, 1)
+		defer func() {
+			close(done)
+			if ctxErr := <-interruptRes; ctxErr != nil {
+				// Return context error to user.
+				ret = ctxErr
+			}
+		}()
+		go func() {
+			select {
+			case <-ctx.Done():
+				// Close the connection, discarding the error
+				_ = c.conn.Close()
+				interruptRes <- ctx.Err()
+			case <-done:
+				interruptRes <- nil
+			}
+		}()
+	}
+
+	c.in.Lock()
+	defer c.in.Unlock()
+	if err := c.ktlsAwaitRestoreLocked(&c.in); err != nil {
+		return err
+	}
+
+	if c.postHandshakeTranscript == nil {
+		return errPostHandshakeAuthOffered
+	}
+	if c.postHandshakeAuth != nil {
+		return errPostHandshakeAuthPending
+	}
+	if err := c.sendPostHandshakeCertificateRequest(clientAuth); err != nil {
+		return err
+	}
+
+	// Hold on to application data that arrives before the answer, since
+	// c.input must be empty to read the next record.
+	var buffered []byte
+	for c.postHandshakeAuth != nil {
+		if c.input.Len() > 0 {
+			data, _ := io.ReadAll(&c.input)
+			buffered = append(buffered, data...)
+		}
+		if err := c.readRecord(); err != nil {
+			return err
+		}
+		for c.hand.Len() > 0 {
+			if err := c.handlePostHandshakeMessage(); err != nil {
+				return c.in.setErrorLocked(err)
+			}
+		}
+	}
+	if len(buffered) > 0 {
+		data, _ := io.ReadAll(&c.input)
+		c.input.Reset(append(buffered, data...))
+	}
+
+	return nil
+}
+
+// sendPostHandshakeCertificateRequest sends a CertificateRequest with a
+// fresh certificate_request_context and records it in c.postHandshakeAuth.
+// c.in must be locked.
+func (c *Conn) sendPostHandshakeCertificateRequest(clientAuth ClientAuthType) error {
+	suite := cipherSuiteTLS13ByID(c.cipherSuite)
+	if suite == nil {
+		return errors.New("tls: internal error: unknown cipher suite")
+	}
+	transcript := cloneHash(c.postHandshakeTranscript, suite.hash)
+	if transcript == nil {
+		return errors.New("tls: internal error: failed to clone hash")
+	}
+
+	certReq := new(certificateRequestMsgTLS13)
+	certReq.context = make([]byte, 16)
+	if _, err := io.ReadFull(c.config.rand(), certReq.context); err != nil {
+		return err
+	}
+	certReq.ocspStapling = true
+	certReq.scts = true
+	certReq.supportedSignatureAlgorithms = supportedSignatureAlgorithms()
+	if c.config.ClientCAs != nil {
+		certReq.certificateAuthorities = c.config.ClientCAs.Subjects()
+	}
+	data, err := certReq.marshal()
+	if err != nil {
+		return err
+	}
+	transcript.Write(data)
+
+	c.out.Lock()
+	defer c.out.Unlock()
+
+	if err := c.out.err; err != nil {
+		return err
+	}
+	if err := c.flushCoalescedLocked(); err != nil {
+		return err
+	}
+	if _, err := c.writeRecordLocked(recordTypeHandshake, data); err != nil {
+		return c.out.setErrorLocked(err)
+	}
+
+	c.postHandshakeAuth = &postHandshakeAuthRequest{
+		context:    certReq.context,
+		clientAuth: clientAuth,
+		transcript: transcript,
+	}
+	return nil
+}
+
+// handlePostHandshakeCertificateRequest answers a CertificateRequest received
+// by a client after the handshake with its Certificate, CertificateVerify and
+// Finished messages.
+func (c *Conn) handlePostHandshakeCertificateRequest(certReq *certificateRequestMsgTLS13) error {
+	if !c.isClient || c.postHandshakeTranscript == nil || len(certReq.context) == 0 {
+		c.sendAlert(alertUnexpectedMessage)
+		return errors.New("tls: received unexpected post-handshake certificate request")
+	}
+
+	suite := cipherSuiteTLS13ByID(c.cipherSuite)
+	if suite == nil {
+		return c.sendAlert(alertInternalError)
+	}
+	transcript := cloneHash(c.postHandshakeTranscript, suite.hash)
+	if transcript == nil {
+		return c.sendAlert(alertInternalError)
+	}
+	if err := transcriptMsg(certReq, transcript); err != nil {
+		return err
+	}
+
+	cert, err := c.getClientCertificate(&CertificateRequestInfo{
+		AcceptableCAs:    certReq.certificateAuthorities,
+		SignatureSchemes: certReq.supportedSignatureAlgorithms,
+		Version:          c.vers,
+		ctx:              context.Background(),
+	})
+	if err != nil {
+		return err
+	}
+
+	certMsg := new(certificateMsgTLS13)
+	certMsg.context = certReq.context
+	certMsg.certificate = *cert
+	certMsg.scts = certReq.scts && len(cert.SignedCertificateTimestamps) > 0
+	certMsg.ocspStapling = certReq.ocspStapling && len(cert.OCSPStaple) > 0
+	msgs := []handshakeMessage{certMsg}
+	if err := transcriptMsg(certMsg, transcript); err != nil {
+		return err
+	}
+
+	// If we send an empty certificate message, skip the CertificateVerify.
+	if len(cert.Certificate) != 0 {
+		certVerifyMsg := new(certificateVerifyMsg)
+		certVerifyMsg.hasSignatureAlgorithm = true
+		certVerifyMsg.signatureAlgorithm, err = selectSignatureScheme(c.vers, cert, certReq.supportedSignatureAlgorithms)
+		if err != nil {
+			c.sendAlert(alertHandshakeFailure)
+			return err
+		}
+		sigType, sigHash, err := typeAndHashFromSignatureScheme(certVerifyMsg.signatureAlgorithm)
+		if err != nil {
+			return c.sendAlert(alertInternalError)
+		}
+		signed := signedMessage(sigHash, clientSignatureContext, transcript)
+		signOpts := crypto.SignerOpts(sigHash)
+		if sigType == signatureRSAPSS {
+			signOpts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: sigHash}
+		}
+		sig, err := c.config.handshakeSign(cert.PrivateKey.(crypto.Signer), signed, signOpts)
+		if err != nil {
+			c.sendAlert(alertInternalError)
+			return errors.New("tls: failed to sign handshake: " + err.Error())
+		}
+		certVerifyMsg.signature = sig
+		msgs = append(msgs, certVerifyMsg)
+		if err := transcriptMsg(certVerifyMsg, transcript); err != nil {
+			return err
+		}
+	}
+
+	c.out.Lock()
+	defer c.out.Unlock()
+
+	// The Finished key is derived from the current traffic secret, which
+	// can't change while c.out is locked.
+	msgs = append(msgs, &finishedMsg{
+		verifyData: suite.finishedHash(c.out.trafficSecret, transcript),
+	})
+	if err := c.flushCoalescedLocked(); err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		data, err := msg.marshal()
+		if err != nil {
+			return err
+		}
+		if _, err := c.writeRecordLocked(recordTypeHandshake, data); err != nil {
+			return c.out.setErrorLocked(err)
+		}
+	}
+	return nil
+}
+
+// handlePostHandshakeCertificate processes the client's answer to a
+// CertificateRequest sent by RequestClientCertificate, starting with its
+// Certificate message.
+func (c *Conn) handlePostHandshakeCertificate(certMsg *certificateMsgTLS13) error {
+	req := c.postHandshakeAuth
+	if c.isClient || req == nil {
+		c.sendAlert(alertUnexpectedMessage)
+		return errors.New("tls: received unexpected post-handshake certificates message")
+	}
+	c.postHandshakeAuth = nil
+	if !bytes.Equal(certMsg.context, req.context) {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: received certificates message with an unknown context")
+	}
+	if err := transcriptMsg(certMsg, req.transcript); err != nil {
+		return err
+	}
+
+	// The peer certificates are protected by handshakeMutex.
+	c.handshakeMutex.Lock()
+	defer c.handshakeMutex.Unlock()
+
+	if err := c.processCertsFromClientAuth(certMsg.certificate, req.clientAuth); err != nil {
+		return err
+	}
+	if c.config.VerifyConnection != nil {
+		if err := c.config.VerifyConnection(c.connectionStateLocked()); err != nil {
+			c.sendAlert(alertBadCertificate)
+			return err
+		}
+	}
+
+	if len(certMsg.certificate.Certificate) != 0 {
+		// certificateVerifyMsg is included in the transcript, but not until
+		// after we verify the handshake signature, since the state before
+		// this message was sent is used.
+		msg, err := c.readHandshake(nil)
+		if err != nil {
+			return err
+		}
+		certVerify, ok := msg.(*certificateVerifyMsg)
+		if !ok {
+			c.sendAlert(alertUnexpectedMessage)
+			return unexpectedMessageError(certVerify, msg)
+		}
+
+		// See RFC 8446, Section 4.4.3.
+		if !isSupportedSignatureAlgorithm(certVerify.signatureAlgorithm, supportedSignatureAlgorithms()) {
+			c.sendAlert(alertIllegalParameter)
+			return errors.New("tls: client certificate used with invalid signature algorithm")
+		}
+		sigType, sigHash, err := typeAndHashFromSignatureScheme(certVerify.signatureAlgorithm)
+		if err != nil {
+			return c.sendAlert(alertInternalError)
+		}
+		if sigType == signaturePKCS1v15 || sigHash == crypto.SHA1 {
+			c.sendAlert(alertIllegalParameter)
+			return errors.New("tls: client certificate used with invalid signature algorithm")
+		}
+		signed := signedMessage(sigHash, clientSignatureContext, req.transcript)
+		if err := verifyHandshakeSignature(sigType, c.peerCertificates[0].PublicKey,
+			sigHash, signed, certVerify.signature); err != nil {
+			c.sendAlert(alertDecryptError)
+			return errors.New("tls: invalid signature by the client certificate: " + err.Error())
+		}
+		if err := transcriptMsg(certVerify, req.transcript); err != nil {
+			return err
+		}
+	}
+
+	msg, err := c.readHandshake(nil)
+	if err != nil {
+		return err
+	}
+	finished, ok := msg.(*finishedMsg)
+	if !ok {
+		c.sendAlert(alertUnexpectedMessage)
+		return unexpectedMessageError(finished, msg)
+	}
+	suite := cipherSuiteTLS13ByID(c.cipherSuite)
+	if suite == nil {
+		return c.sendAlert(alertInternalError)
+	}
+	if !hmac.Equal(suite.finishedHash(c.in.trafficSecret, req.transcript), finished.verifyData) {
+		c.sendAlert(alertDecryptError)
+		return errors.New("tls: invalid client finished hash")
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package tls
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func postHandshakeAuthPipe(t *testing.T, clientConfig *Config) (client, server *Conn) {
+	c, s := localPipe(t)
+	client = Client(c, clientConfig)
+	serverConfig := testConfig.Clone()
+	serverConfig.MaxVersion = VersionTLS13
+	server = Server(s, serverConfig)
+
+	errs := make(chan error, 1)
+	go func() { errs <- client.Handshake() }()
+	if err := server.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+func TestPostHandshakeAuth(t *testing.T) {
+	clientConfig := testConfig.Clone()
+	clientConfig.MaxVersion = VersionTLS13
+	clientConfig.PostHandshakeAuth = true
+	clientConfig.Certificates = []Certificate{{
+		Certificate: [][]byte{testRSACertificate},
+		PrivateKey:  testRSAPrivateKey,
+	}}
+	client, server := postHandshakeAuthPipe(t, clientConfig)
+	defer client.Close()
+	defer server.Close()
+
+	// The client sends application data ahead of its answer and then reads,
+	// which processes the CertificateRequest.
+	read := make(chan []byte, 1)
+	go func() {
+		if _, err := client.Write([]byte("hello")); err != nil {
+			t.Error(err)
+		}
+		buf := make([]byte, 4)
+		n, err := client.Read(buf)
+		if err != nil {
+			t.Error(err)
+		}
+		read <- buf[:n]
+	}()
+
+	if err := server.RequestClientCertificate(context.Background(), RequireAnyClientCert); err != nil {
+		t.Fatal(err)
+	}
+	if certs := server.ConnectionState().PeerCertificates; len(certs) != 1 || !bytes.Equal(certs[0].Raw, testRSACertificate) {
+		t.Errorf("got %d peer certificates, want the client certificate", len(certs))
+	}
+
+	// Application data received before the answer is not lost.
+	buf := make([]byte, 5)
+	if n, err := server.Read(buf); err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("got %q, %v; want %q", buf[:n], err, "hello")
+	}
+
+	if _, err := server.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-read; string(got) != "ping" {
+		t.Errorf("client read %q, want %q", got, "ping")
+	}
+}
+
+func TestPostHandshakeAuthNoCertificate(t *testing.T) {
+	clientConfig := testConfig.Clone()
+	clientConfig.MaxVersion = VersionTLS13
+	clientConfig.PostHandshakeAuth = true
+	clientConfig.Certificates = nil
+	client, server := postHandshakeAuthPipe(t, clientConfig)
+	defer client.Close()
+	defer server.Close()
+
+	go client.Read(make([]byte, 1))
+
+	if err := server.RequestClientCertificate(context.Background(), RequireAnyClientCert); err == nil {
+		t.Fatal("RequestClientCertificate succeeded without a client certificate")
+	}
+}
+
+func TestPostHandshakeAuthNotOffered(t *testing.T) {
+	clientConfig := testConfig.Clone()
+	clientConfig.MaxVersion = VersionTLS13
+	client, server := postHandshakeAuthPipe(t, clientConfig)
+	defer client.Close()
+	defer server.Close()
+
+	if err := server.RequestClientCertificate(context.Background(), RequireAnyClientCert); err != errPostHandshakeAuthOffered {
+		t.Errorf("got %v, want %v", err, errPostHandshakeAuthOffered)
+	}
+	if err := client.RequestClientCertificate(context.Background(), RequireAnyClientCert); err != errPostHandshakeAuthConn {
+		t.Errorf("got %v, want %v", err, errPostHandshakeAuthConn)
+	}
+}
@@ -0,0 +1,106 @@
+package tls
+
+import "io"
+
+// ReadExact reads exactly len(b) bytes of application data into b, like
+// io.ReadFull, for protocols made of fixed-size frames. It returns the
+// number of bytes read, which is less than len(b) only together with an
+// error: io.EOF if the connection was closed before any byte was read and
+// io.ErrUnexpectedEOF if it was closed in the middle of b. Deadlines apply
+// to the whole call and a timeout leaves the bytes read so far in b.
+//
+// When the connection receives with kernel TLS, records are decrypted by
+// recvmsg with MSG_WAITALL directly into b, so that a single system call
+// fills b with as many records as the socket holds, instead of one call
+// and one copy per record. Since the runtime keeps the socket
+// non-blocking, waiting for the next records happens in the network
+// poller, which honors the read deadline. Post-handshake messages and
+// alerts received in the middle of b are processed as Read would.
+func (c *Conn) ReadExact(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	if err := c.flushCoalescedForRead(); err != nil {
+		return 0, err
+	}
+
+	// Report a deferred TLS_RX fallback once c.in is released.
+	defer c.reportKTLS()
+
+	c.in.Lock()
+	defer c.in.Unlock()
+	if err := c.ktlsAwaitRestoreLocked(&c.in); err != nil {
+		return 0, err
+	}
+
+	if c.ktlsDeferRX {
+		c.enableDeferredKTLSRX()
+	}
+
+	n := 0
+	for n < len(b) {
+		if c.input.Len() > 0 {
+			m, _ := c.input.Read(b[n:])
+			n += m
+			if c.input.Len() == 0 {
+				c.observeRXDelivered()
+			}
+			continue
+		}
+
+		var err error
+		if c.canReadExactDirect(len(b) - n) {
+			var m int
+			m, err = c.readExactDirect(b[n:])
+			n += m
+		} else {
+			err = c.readRecord()
+		}
+		for err == nil && c.hand.Len() > 0 {
+			err = c.handlePostHandshakeMessage()
+		}
+		if err != nil {
+			if err == io.EOF && n > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+	}
+	c.releaseRawInput()
+	return n, nil
+}
+
+// canReadExactDirect reports whether ReadExact can receive the next n
+// bytes straight into the caller's buffer with kernel TLS. That requires
+// room for a whole record, in case the next one is not application data,
+// and nothing read ahead in user space. c.in must be locked.
+func (c *Conn) canReadExactDirect(n int) bool {
+	if _, ok := c.in.cipher.(kTLSCipher); !ok {
+		return false
+	}
+	return n >= maxPlaintext && c.ktlsVerifyRX == nil && c.ktlsPending == nil &&
+		c.rawInput.Len() == 0 && c.hand.Len() == 0 && c.in.err == nil
+}
+
+// readExactDirect receives application data into b with recvmsg and
+// MSG_WAITALL. A record of another type is stashed in c.ktlsPending and
+// processed by readRecord. c.in must be locked.
+func (c *Conn) readExactDirect(b []byte) (int, error) {
+	typ, n, err := c.ktlsRecvRecord(b, msgWaitAll)
+	if err != nil {
+		return 0, err
+	}
+	if c.config.OnRXOffloadLost != nil {
+		c.observeRXOffload()
+	}
+	if typ != recordTypeApplicationData {
+		c.ktlsPendingType = typ
+		c.ktlsPending = append([]byte(nil), b[:n]...)
+		return 0, c.readRecord()
+	}
+	c.retryCount = 0
+	c.observeRecord(false, typ, b[:n], true)
+	c.observeRXRecord(n)
+	c.observeRXDelivered()
+	return n, nil
+}
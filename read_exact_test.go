@@ -0,0 +1,70 @@
+package tls
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReadExact(t *testing.T) {
+	client, server := coalescePipe(t, 0)
+	defer client.Close()
+	defer server.Close()
+
+	want := make([]byte, 3*maxPlaintext+100)
+	for i := range want {
+		want[i] = byte(i)
+	}
+	go func() {
+		// Frames arrive split over records of various sizes.
+		for _, chunk := range [][]byte{want[:10], want[10 : maxPlaintext+5], want[maxPlaintext+5:]} {
+			if _, err := client.Write(chunk); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		client.Close()
+	}()
+
+	got := make([]byte, len(want)-50)
+	if n, err := server.ReadExact(got); err != nil || n != len(got) {
+		t.Fatalf("got %d, %v; want %d", n, err, len(got))
+	}
+	if !bytes.Equal(got, want[:len(got)]) {
+		t.Fatal("ReadExact returned the wrong data")
+	}
+
+	rest := make([]byte, 100)
+	n, err := server.ReadExact(rest)
+	if err != io.ErrUnexpectedEOF || n != 50 {
+		t.Fatalf("got %d, %v; want 50, %v", n, err, io.ErrUnexpectedEOF)
+	}
+	if !bytes.Equal(rest[:n], want[len(got):]) {
+		t.Error("ReadExact returned the wrong tail")
+	}
+	if n, err := server.ReadExact(rest); err != io.EOF || n != 0 {
+		t.Errorf("got %d, %v; want 0, %v", n, err, io.EOF)
+	}
+}
+
+func TestReadExactDeadline(t *testing.T) {
+	client, server := coalescePipe(t, 0)
+	defer client.Close()
+	defer server.Close()
+
+	if _, err := client.Write([]byte("partial")); err != nil {
+		t.Fatal(err)
+	}
+	server.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	buf := make([]byte, 16)
+	n, err := server.ReadExact(buf)
+	if !errors.Is(err, os.ErrDeadlineExceeded) || n != len("partial") {
+		t.Fatalf("got %d, %v; want %d, %v", n, err, len("partial"), os.ErrDeadlineExceeded)
+	}
+	if string(buf[:n]) != "partial" {
+		t.Errorf("got %q", buf[:n])
+	}
+}
@@ -0,0 +1,83 @@
+package tls
+
+// capturedRecord is a record read by readRecord while ReadRecord captures
+// them.
+type capturedRecord struct {
+	typ  recordType
+	data []byte
+}
+
+// ReadRecord reads the next record and returns its content type, such as
+// 23 for application data or 22 for handshake messages, and its
+// plaintext, for proxies and protocol analyzers that need to see record
+// boundaries and the records Read consumes or rejects.
+//
+// Records are still processed as Read would: post-handshake messages are
+// handled, warning alerts and empty records are returned although Read
+// drops them, and a fatal alert or a close_notify comes with the error it
+// ends the connection with, io.EOF for the latter, or is followed by it on
+// the next call. Application data that Read left
+// buffered is returned first, as a single record. When the connection
+// receives with kernel TLS, records are read with recvmsg as by Read, one
+// per call, so their boundaries are those of the peer.
+func (c *Conn) ReadRecord() (contentType uint8, payload []byte, err error) {
+	if err := c.Handshake(); err != nil {
+		return 0, nil, err
+	}
+	if err := c.flushCoalescedForRead(); err != nil {
+		return 0, nil, err
+	}
+
+	// Report a deferred TLS_RX fallback once c.in is released.
+	defer c.reportKTLS()
+
+	c.in.Lock()
+	defer c.in.Unlock()
+	if err := c.ktlsAwaitRestoreLocked(&c.in); err != nil {
+		return 0, nil, err
+	}
+
+	if c.ktlsDeferRX {
+		c.enableDeferredKTLSRX()
+	}
+
+	if len(c.capturedRecords) == 0 {
+		if c.input.Len() > 0 {
+			payload = make([]byte, c.input.Len())
+			c.input.Read(payload)
+			c.observeRXDelivered()
+			c.releaseRawInput()
+			return uint8(recordTypeApplicationData), payload, nil
+		}
+
+		c.captureRecords = true
+		err = c.readRecord()
+		for err == nil && c.hand.Len() > 0 {
+			err = c.handlePostHandshakeMessage()
+		}
+		c.captureRecords = false
+
+		// The application data was captured, so it is delivered here
+		// rather than left for Read.
+		if c.input.Len() > 0 {
+			c.input.Reset(nil)
+			c.observeRXDelivered()
+		}
+		c.releaseRawInput()
+		if len(c.capturedRecords) == 0 {
+			return 0, nil, err
+		}
+	}
+
+	// An error comes with the last record read before it. Permanent ones
+	// are returned again by readRecord.
+	r := c.capturedRecords[0]
+	c.capturedRecords[0] = capturedRecord{}
+	c.capturedRecords = c.capturedRecords[1:]
+	if len(c.capturedRecords) > 0 {
+		err = nil
+	} else {
+		c.capturedRecords = nil
+	}
+	return uint8(r.typ), r.data, err
+}
@@ -0,0 +1,62 @@
+package tls
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestReadRecord(t *testing.T) {
+	client, server := coalescePipe(t, 0)
+	defer server.Close()
+
+	go func() {
+		for _, s := range []string{"hello", "world"} {
+			if _, err := client.Write([]byte(s)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		client.Close()
+	}()
+
+	// What Read leaves buffered comes first, as a record.
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(server, b); err != nil {
+		t.Fatal(err)
+	}
+	typ, payload, err := server.ReadRecord()
+	if err != nil || typ != uint8(recordTypeApplicationData) || string(payload) != "llo" {
+		t.Fatalf("ReadRecord = %d, %q, %v; want 23, \"llo\", nil", typ, payload, err)
+	}
+
+	// The records keep the boundaries of the writes, and the close_notify
+	// alert is returned with io.EOF.
+	var got [][]byte
+	for {
+		typ, payload, err := server.ReadRecord()
+		if typ == uint8(recordTypeApplicationData) {
+			got = append(got, payload)
+		} else if typ == uint8(recordTypeAlert) {
+			if !bytes.Equal(payload, []byte{alertLevelWarning, byte(alertCloseNotify)}) {
+				t.Errorf("alert record %x, want close_notify", payload)
+			}
+			if err != io.EOF {
+				t.Fatalf("ReadRecord of close_notify = %v, want io.EOF", err)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadRecord = %d, %q, %v", typ, payload, err)
+		}
+	}
+	if len(got) != 1 || string(got[0]) != "world" {
+		t.Fatalf("ReadRecord returned %q, want [\"world\"]", got)
+	}
+	if _, _, err := server.ReadRecord(); err != io.EOF {
+		t.Errorf("ReadRecord after close_notify = %v, want io.EOF", err)
+	}
+	if _, err := server.Read(b); err != io.EOF {
+		t.Errorf("Read after close_notify = %v, want io.EOF", err)
+	}
+}
@@ -0,0 +1,141 @@
+package tls
+
+// readRecordsMinBuf is the smallest buffer ReadRecords receives into
+// directly, the size Read receives records with.
+const readRecordsMinBuf = 0xfff
+
+// ReadRecords reads application data into bufs, filling each buffer with
+// what a single receive returned, and returns the number of buffers
+// filled, after reslicing each of them to the data it holds. Callers
+// reusing bufs restore the lengths first, for example with
+// bufs[i] = bufs[i][:cap(bufs[i])]. It blocks until at least one buffer is
+// filled, and then only fills those whose data has already arrived. The
+// buffers filled before an error are returned along with it.
+//
+// When the connection receives with kernel TLS and every buffer holds at
+// least 4095 bytes, records are decrypted by a single recvmmsg call
+// straight into as many buffers as there are records waiting, up to 64,
+// instead of one recvmsg call and one copy per record, which dominates the
+// CPU time of traffic made of small records. Record boundaries are not
+// preserved: the kernel may put several records in a buffer, or split one
+// across buffers. Post-handshake messages and alerts received among them
+// are processed as Read would, and the buffers they were received into are
+// moved after the filled ones. Otherwise, each buffer gets what a Read
+// would return.
+func (c *Conn) ReadRecords(bufs [][]byte) (int, error) {
+	if len(bufs) == 0 {
+		return 0, nil
+	}
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	if err := c.flushCoalescedForRead(); err != nil {
+		return 0, err
+	}
+
+	// Report a deferred TLS_RX fallback once c.in is released.
+	defer c.reportKTLS()
+
+	c.in.Lock()
+	defer c.in.Unlock()
+	if err := c.ktlsAwaitRestoreLocked(&c.in); err != nil {
+		return 0, err
+	}
+
+	if c.ktlsDeferRX {
+		c.enableDeferredKTLSRX()
+	}
+
+	n := 0
+	for n < len(bufs) {
+		if c.input.Len() > 0 {
+			m, _ := c.input.Read(bufs[n])
+			bufs[n] = bufs[n][:m]
+			n++
+			if c.input.Len() == 0 {
+				c.observeRXDelivered()
+			}
+			continue
+		}
+
+		var err error
+		if c.canReadRecordsDirect(bufs[n:]) {
+			var m int
+			m, err = c.readRecordsDirect(bufs[n:], n == 0)
+			n += m
+			if err == nil && n > 0 {
+				break
+			}
+		} else if n > 0 {
+			break
+		} else {
+			err = c.readRecord()
+		}
+		for err == nil && c.hand.Len() > 0 {
+			err = c.handlePostHandshakeMessage()
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// canReadRecordsDirect reports whether ReadRecords can receive into bufs
+// with recvmmsg. That requires buffers as large as those of Read, in case
+// an alert or handshake message arrives, and nothing read ahead in user
+// space. c.in must be locked.
+func (c *Conn) canReadRecordsDirect(bufs [][]byte) bool {
+	if _, ok := c.in.cipher.(kTLSCipher); !ok {
+		return false
+	}
+	if c.ktlsVerifyRX != nil || c.ktlsPending != nil || c.rawInput.Len() != 0 ||
+		c.hand.Len() != 0 || c.in.err != nil || c.ktlsRing.Load() != nil ||
+		c.config.KTLSSeccompCompat {
+		return false
+	}
+	for _, b := range bufs {
+		if len(b) < readRecordsMinBuf {
+			return false
+		}
+	}
+	return true
+}
+
+// readRecordsDirect receives into bufs with recvmmsg and returns the
+// number of buffers filled with application data, which it moves to the
+// front of bufs. The other records are processed in order, through
+// c.ktlsPending. Unless wait is set, it returns 0 rather than wait for
+// data. c.in must be locked.
+func (c *Conn) readRecordsDirect(bufs [][]byte, wait bool) (int, error) {
+	types, lens, err := c.ktlsRecvRecords(bufs, wait)
+	n := 0
+	for i, typ := range types {
+		if c.config.OnRXOffloadLost != nil {
+			c.observeRXOffload()
+		}
+		b := bufs[i][:lens[i]]
+		if typ != recordTypeApplicationData {
+			c.ktlsPendingType = typ
+			c.ktlsPending = append([]byte(nil), b...)
+			err := c.readRecord()
+			for err == nil && c.hand.Len() > 0 {
+				err = c.handlePostHandshakeMessage()
+			}
+			if err != nil {
+				return n, err
+			}
+			continue
+		}
+		c.retryCount = 0
+		c.observeRecord(false, typ, b, true)
+		c.observeRXRecord(len(b))
+		c.observeRXDelivered()
+		if n != i {
+			bufs[i] = bufs[n]
+		}
+		bufs[n] = b
+		n++
+	}
+	return n, err
+}
@@ -0,0 +1,120 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"io"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// mmsghdr is struct mmsghdr, which x/sys doesn't define.
+type mmsghdr struct {
+	hdr unix.Msghdr
+	len uint32
+}
+
+// maxRecvBatch is the most messages received by a recvmmsg call.
+const maxRecvBatch = 64
+
+// batchCmsg returns the control message buffer of message i.
+func (r *ktlsRecvState) batchCmsg(i int) []byte {
+	return unsafe.Slice((*byte)(unsafe.Pointer(&r.cmsgs[i][0])), unix.CmsgSpace(1))
+}
+
+// recvmmsg is the RawConn.Read callback that receives the batch.
+func (r *ktlsRecvState) recvmmsg(fd uintptr) bool {
+	r.n, r.err = recvmmsg(fd, r.msgs, 0)
+	if r.err == unix.EAGAIN {
+		if r.wait {
+			return false
+		}
+		r.n, r.err = 0, nil
+		return true
+	}
+	if r.err != nil {
+		r.err = &KTLSSyscallError{Syscall: "recvmmsg", Err: r.err}
+	}
+	return true
+}
+
+// receiveBatch receives a message into each of bufs, up to maxRecvBatch,
+// with a single recvmmsg, and returns how many were received. Unless wait
+// is set, it returns 0 rather than wait for the first one.
+func (r *ktlsRecvState) receiveBatch(bufs [][]byte, wait bool) (int, error) {
+	vlen := len(bufs)
+	if vlen > maxRecvBatch {
+		vlen = maxRecvBatch
+	}
+	if len(r.msgs) < vlen {
+		r.msgs = make([]mmsghdr, vlen)
+		r.iovs = make([]unix.Iovec, vlen)
+		r.cmsgs = make([][4]uint64, vlen)
+	}
+	r.msgs = r.msgs[:vlen]
+	for i := range r.msgs {
+		buffer := r.batchCmsg(i)
+		cmsg := (*unix.Cmsghdr)(unsafe.Pointer(&buffer[0]))
+		*cmsg = unix.Cmsghdr{}
+		cmsg.SetLen(unix.CmsgLen(1))
+
+		r.iovs[i].Base = &bufs[i][0]
+		r.iovs[i].SetLen(len(bufs[i]))
+		r.msgs[i] = mmsghdr{}
+		r.msgs[i].hdr.Iov = &r.iovs[i]
+		r.msgs[i].hdr.SetIovlen(1)
+		r.msgs[i].hdr.Control = &buffer[0]
+		r.msgs[i].hdr.SetControllen(len(buffer))
+	}
+	r.wait, r.n, r.err = wait, 0, nil
+
+	err0 := r.rc.Read(r.recvBatch)
+	n, err := r.n, r.err
+	// Don't keep the caller's buffers alive.
+	for i := range r.msgs {
+		r.iovs[i].Base = nil
+	}
+	if err0 != nil {
+		err = err0
+	}
+	return n, err
+}
+
+// ktlsRecvRecords receives into bufs with recvmmsg, a message of a single
+// record type per buffer, and returns the type and length of each, in
+// slices reused by the next call. Unless wait is set, it returns nothing
+// rather than wait for data. If the connection was closed, it returns the
+// messages before the end, or io.EOF if there are none. c.in must be
+// locked.
+func (c *Conn) ktlsRecvRecords(bufs [][]byte, wait bool) ([]recordType, []int, error) {
+	r, err := c.ktlsRecvStateLocked()
+	if err != nil {
+		return nil, nil, err
+	}
+	n, err := r.receiveBatch(bufs, wait)
+	if err != nil {
+		Debugln("kTLS: recvmmsg failed:", err)
+		return nil, nil, err
+	}
+	r.types, r.lens = r.types[:0], r.lens[:0]
+	for i := 0; i < n; i++ {
+		m := int(r.msgs[i].len)
+		if m == 0 {
+			// The kernel fills the rest of the batch with empty messages
+			// at EOF.
+			if i == 0 {
+				return nil, nil, io.EOF
+			}
+			break
+		}
+		typ, err := c.ktlsRecordType(r.batchCmsg(i))
+		if err != nil {
+			return r.types, r.lens, err
+		}
+		r.types = append(r.types, typ)
+		r.lens = append(r.lens, m)
+	}
+	return r.types, r.lens, nil
+}
@@ -0,0 +1,61 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"net"
+	"testing"
+)
+
+func TestKTLSRecvBatch(t *testing.T) {
+	ln := newLocalListener(t)
+	defer ln.Close()
+	tcpConn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpConn.Close()
+	peer, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	r, err := newKTLSRecvState(tcpConn, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bufs := [][]byte{make([]byte, 4), make([]byte, 4), make([]byte, 4)}
+	if n, err := r.receiveBatch(bufs, false); n != 0 || err != nil {
+		t.Fatalf("receiveBatch without data = %d, %v; want 0, nil", n, err)
+	}
+
+	// A stream socket fills the buffers in turn with what has arrived.
+	peer.Write([]byte("0123456789"))
+	n, err := r.receiveBatch(bufs, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []byte
+	for i := 0; i < n; i++ {
+		got = append(got, bufs[i][:r.msgs[i].len]...)
+	}
+	for len(got) < 10 {
+		m, err := r.receiveBatch(bufs, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < m; i++ {
+			got = append(got, bufs[i][:r.msgs[i].len]...)
+		}
+	}
+	if string(got) != "0123456789" {
+		t.Fatalf("received %q", got)
+	}
+
+	peer.Close()
+	if n, err := r.receiveBatch(bufs, true); err != nil || n == 0 || r.msgs[0].len != 0 {
+		t.Fatalf("receiveBatch at EOF = %d, %v; want empty messages", n, err)
+	}
+}
@@ -0,0 +1,70 @@
+package tls
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestReadRecords(t *testing.T) {
+	for _, size := range []int{maxPlaintext, 100} {
+		client, server := coalescePipe(t, 0)
+
+		want := make([]byte, 3*maxPlaintext+100)
+		for i := range want {
+			want[i] = byte(i)
+		}
+		go func() {
+			for _, chunk := range [][]byte{want[:10], want[10:20], want[20 : maxPlaintext+5], want[maxPlaintext+5:]} {
+				if _, err := client.Write(chunk); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+			client.Close()
+		}()
+
+		bufs := make([][]byte, 4)
+		for i := range bufs {
+			bufs[i] = make([]byte, size)
+		}
+		var got []byte
+		for {
+			for i := range bufs {
+				bufs[i] = bufs[i][:cap(bufs[i])]
+			}
+			n, err := server.ReadRecords(bufs)
+			if n < 0 || n > len(bufs) {
+				t.Fatalf("ReadRecords = %d buffers, with %d given", n, len(bufs))
+			}
+			for _, b := range bufs[:n] {
+				if len(b) == 0 {
+					t.Fatal("ReadRecords returned an empty buffer")
+				}
+				got = append(got, b...)
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n == 0 {
+				t.Fatal("ReadRecords returned no buffer and no error")
+			}
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("buffers of %d bytes: ReadRecords returned %d bytes, want %d", size, len(got), len(want))
+		}
+		server.Close()
+	}
+}
+
+func TestReadRecordsEmpty(t *testing.T) {
+	client, server := coalescePipe(t, 0)
+	defer client.Close()
+	defer server.Close()
+	if n, err := server.ReadRecords(nil); n != 0 || err != nil {
+		t.Errorf("ReadRecords(nil) = %d, %v; want 0, nil", n, err)
+	}
+}
@@ -0,0 +1,129 @@
+package tls
+
+import (
+	"bytes"
+	"sync/atomic"
+)
+
+// A Buffer holds application data returned by Conn.ReadRetain. The memory
+// comes from a pool shared by all connections and is reference counted: the
+// caller of ReadRetain owns one reference, Retain adds one and Release drops
+// one. Once the last reference is dropped, the memory is reused and the
+// slices returned by Bytes must no longer be used.
+//
+// The zero Buffer is empty, and Retain and Release are no-ops on it.
+type Buffer struct {
+	rb *retainedBuf
+}
+
+type retainedBuf struct {
+	data   []byte
+	pooled *[]byte
+	refs   atomic.Int32
+}
+
+// Bytes returns the data held by b.
+func (b Buffer) Bytes() []byte {
+	if b.rb == nil {
+		return nil
+	}
+	return b.rb.data
+}
+
+// Len returns the length of the data held by b.
+func (b Buffer) Len() int {
+	return len(b.Bytes())
+}
+
+// Retain adds a reference to b, for example before passing it to another
+// goroutine, and returns b.
+func (b Buffer) Retain() Buffer {
+	if b.rb != nil {
+		b.rb.refs.Add(1)
+	}
+	return b
+}
+
+// Release drops a reference to b. It panics if b has no references left.
+func (b Buffer) Release() {
+	if b.rb == nil {
+		return
+	}
+	switch refs := b.rb.refs.Add(-1); {
+	case refs == 0:
+		putRecordBuf(b.rb.pooled)
+		b.rb.data, b.rb.pooled = nil, nil
+	case refs < 0:
+		panic("tls: Buffer released too many times")
+	}
+}
+
+func newBuffer(data []byte, pooled *[]byte) Buffer {
+	rb := &retainedBuf{data: data, pooled: pooled}
+	rb.refs.Store(1)
+	return Buffer{rb}
+}
+
+// ReadRetain reads application data like Read, but returns it in a pooled
+// Buffer that the caller must Release, instead of copying it into a slice
+// provided by the caller. This suits pipelines that pass buffers between
+// stages.
+//
+// When the connection receives with kernel TLS, the Buffer is the one recvmsg
+// decrypted the record into and no copy is made. Otherwise the record is
+// copied out of the connection's input buffer, which holds undecrypted
+// read-ahead.
+func (c *Conn) ReadRetain() (Buffer, error) {
+	if err := c.Handshake(); err != nil {
+		return Buffer{}, err
+	}
+	if err := c.flushCoalescedForRead(); err != nil {
+		return Buffer{}, err
+	}
+
+	// Report a deferred TLS_RX fallback once c.in is released.
+	defer c.reportKTLS()
+
+	c.in.Lock()
+	defer c.in.Unlock()
+	if err := c.ktlsAwaitRestoreLocked(&c.in); err != nil {
+		return Buffer{}, err
+	}
+
+	if c.ktlsDeferRX {
+		c.enableDeferredKTLSRX()
+	}
+
+	fresh := c.input.Len() == 0
+	for c.input.Len() == 0 {
+		if err := c.readRecord(); err != nil {
+			return Buffer{}, err
+		}
+		for c.hand.Len() > 0 {
+			if err := c.handlePostHandshakeMessage(); err != nil {
+				return Buffer{}, err
+			}
+		}
+	}
+	n := c.input.Len()
+
+	// kernel TLS receives each record at the start of c.rawInput's pooled
+	// buffer, without advancing it, so the buffer can be handed over.
+	if _, ok := c.in.cipher.(kTLSCipher); ok && fresh && c.rawInputBuf != nil && c.rawInput.Len() == 0 {
+		pooled := c.rawInputBuf
+		data := c.rawInput.Bytes()[:n]
+		*pooled = data[:0]
+		c.rawInputBuf = nil
+		c.rawInput = bytes.Buffer{}
+		c.input.Reset(nil)
+		c.observeRXDelivered()
+		return newBuffer(data, pooled), nil
+	}
+
+	pooled := getRecordBuf(n)
+	data := (*pooled)[:n]
+	c.input.Read(data)
+	c.observeRXDelivered()
+	c.releaseRawInput()
+	return newBuffer(data, pooled), nil
+}
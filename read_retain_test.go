@@ -0,0 +1,57 @@
+package tls
+
+import (
+	"testing"
+)
+
+func TestReadRetain(t *testing.T) {
+	client, server := coalescePipe(t, 0)
+	defer client.Close()
+	defer server.Close()
+
+	if _, err := server.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := client.ReadRetain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b.Bytes()) != "hello" {
+		t.Errorf("got %q, want %q", b.Bytes(), "hello")
+	}
+
+	// Data left over by Read is returned first.
+	if _, err := server.Write([]byte("world")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2)
+	if _, err := client.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	b2, err := client.ReadRetain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b2.Bytes()) != "rld" {
+		t.Errorf("got %q, want %q", b2.Bytes(), "rld")
+	}
+	b2.Release()
+
+	// The first buffer stays valid while it is referenced.
+	b.Retain()
+	b.Release()
+	if string(b.Bytes()) != "hello" {
+		t.Errorf("got %q after Release of a retained Buffer", b.Bytes())
+	}
+	b.Release()
+	if b.Bytes() != nil {
+		t.Error("Bytes not cleared after the last Release")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("extra Release did not panic")
+		}
+	}()
+	b.Release()
+}
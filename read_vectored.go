@@ -0,0 +1,136 @@
+package tls
+
+// maxReadVectoredBufs is the most buffers a single recvmsg call scatters
+// into, UIO_MAXIOV.
+const maxReadVectoredBufs = 1024
+
+// ReadVectored reads application data into bufs in order, as Read would
+// into their concatenation, and returns the number of bytes read. Like
+// Read, it blocks until some data is available and returns at most a
+// record's worth, so that protocols splitting their frames into a header
+// and a payload buffer get both from a single call.
+//
+// When the connection receives with kernel TLS, nothing is read ahead in
+// user space and bufs hold at least 4095 bytes in total, the record is
+// decrypted by a single recvmsg call scattering it straight into bufs,
+// instead of into the connection's buffer and then copied. Post-handshake
+// messages and alerts received this way are processed as Read would.
+func (c *Conn) ReadVectored(bufs [][]byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, b := range bufs {
+		total += len(b)
+	}
+	if total == 0 {
+		return 0, nil
+	}
+	if err := c.flushCoalescedForRead(); err != nil {
+		return 0, err
+	}
+
+	// Report a deferred TLS_RX fallback once c.in is released.
+	defer c.reportKTLS()
+
+	c.in.Lock()
+	defer c.in.Unlock()
+	if err := c.ktlsAwaitRestoreLocked(&c.in); err != nil {
+		return 0, err
+	}
+
+	if c.ktlsDeferRX {
+		c.enableDeferredKTLSRX()
+	}
+
+	for c.input.Len() == 0 {
+		var (
+			n   int
+			err error
+		)
+		if c.canReadVectoredDirect(bufs, total) {
+			n, err = c.readVectoredDirect(bufs)
+		} else {
+			err = c.readRecord()
+		}
+		for err == nil && c.hand.Len() > 0 {
+			err = c.handlePostHandshakeMessage()
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+
+	n := 0
+	for _, b := range bufs {
+		m, _ := c.input.Read(b)
+		n += m
+		if c.input.Len() == 0 {
+			break
+		}
+	}
+	if c.input.Len() == 0 {
+		c.observeRXDelivered()
+	}
+
+	// As in Read, return a waiting close-notify alert along with the data.
+	if c.input.Len() == 0 && c.rawInput.Len() > 0 &&
+		recordType(c.rawInput.Bytes()[0]) == recordTypeAlert {
+		if err := c.readRecord(); err != nil {
+			return n, err
+		}
+	}
+	c.releaseRawInput()
+	return n, nil
+}
+
+// canReadVectoredDirect reports whether ReadVectored can receive the next
+// record straight into bufs, which hold total bytes, with kernel TLS. That
+// requires as much room as Read receives records with, in case the record
+// is not application data, and nothing read ahead in user space. c.in must
+// be locked.
+func (c *Conn) canReadVectoredDirect(bufs [][]byte, total int) bool {
+	if _, ok := c.in.cipher.(kTLSCipher); !ok {
+		return false
+	}
+	return total >= readRecordsMinBuf && len(bufs) <= maxReadVectoredBufs &&
+		c.ktlsVerifyRX == nil && c.ktlsPending == nil && c.rawInput.Len() == 0 &&
+		c.hand.Len() == 0 && c.in.err == nil && c.ktlsRing.Load() == nil
+}
+
+// readVectoredDirect receives a record scattered into bufs with recvmsg. A
+// record of another type is gathered into c.ktlsPending and processed by
+// readRecord. c.in must be locked.
+func (c *Conn) readVectoredDirect(bufs [][]byte) (int, error) {
+	typ, n, err := c.ktlsRecvRecordVectored(bufs)
+	if err != nil {
+		return 0, err
+	}
+	if c.config.OnRXOffloadLost != nil {
+		c.observeRXOffload()
+	}
+	if typ != recordTypeApplicationData {
+		c.ktlsPendingType = typ
+		c.ktlsPending = gatherBuffers(bufs, n)
+		return 0, c.readRecord()
+	}
+	c.retryCount = 0
+	if c.config.RecordObserver != nil {
+		c.observeRecord(false, typ, gatherBuffers(bufs, n), true)
+	}
+	c.observeRXRecord(n)
+	c.observeRXDelivered()
+	return n, nil
+}
+
+// gatherBuffers returns a copy of the first n bytes held by bufs.
+func gatherBuffers(bufs [][]byte, n int) []byte {
+	data := make([]byte, 0, n)
+	for _, b := range bufs {
+		if len(b) >= n-len(data) {
+			return append(data, b[:n-len(data)]...)
+		}
+		data = append(data, b...)
+	}
+	return data
+}
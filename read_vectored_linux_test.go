@@ -0,0 +1,57 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func TestKTLSRecvVectored(t *testing.T) {
+	ln := newLocalListener(t)
+	defer ln.Close()
+	tcpConn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpConn.Close()
+	peer, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	for _, seccompCompat := range []bool{false, true} {
+		r, err := newKTLSRecvState(tcpConn, seccompCompat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		peer.Write([]byte("0123456789"))
+		hdr, payload := make([]byte, 4), make([]byte, 16)
+		n, err := r.receiveVectored([][]byte{hdr, nil, payload}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n < 10 {
+			m, err := r.receiveVectored([][]byte{payload[n-4:]}, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n += m
+		}
+		if got := string(hdr) + string(payload[:n-4]); got != "0123456789" {
+			t.Fatalf("seccompCompat %v: received %q", seccompCompat, got)
+		}
+		if r.bufs != nil || r.vec[0].Base != nil {
+			t.Errorf("seccompCompat %v: receiveVectored kept the buffers", seccompCompat)
+		}
+	}
+
+	peer.Close()
+	r, _ := newKTLSRecvState(tcpConn, false)
+	if _, err := r.receiveVectored([][]byte{make([]byte, 4)}, 0); err != io.EOF {
+		t.Errorf("receiveVectored at EOF = %v, want io.EOF", err)
+	}
+}
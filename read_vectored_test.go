@@ -0,0 +1,63 @@
+package tls
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestReadVectored(t *testing.T) {
+	client, server := coalescePipe(t, 0)
+	defer server.Close()
+
+	want := make([]byte, maxPlaintext+100)
+	for i := range want {
+		want[i] = byte(i)
+	}
+	go func() {
+		for _, chunk := range [][]byte{want[:10], want[10:]} {
+			if _, err := client.Write(chunk); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		client.Close()
+	}()
+
+	// The first record is scattered over the header and the start of the
+	// payload buffer.
+	hdr, payload := make([]byte, 4), make([]byte, 3000)
+	n, err := server.ReadVectored([][]byte{hdr, nil, payload})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 10 || !bytes.Equal(hdr, want[:4]) || !bytes.Equal(payload[:6], want[4:10]) {
+		t.Fatalf("ReadVectored = %d, %x %x; want 10, %x", n, hdr, payload[:6], want[:10])
+	}
+
+	got := append([]byte(nil), want[:10]...)
+	for {
+		n, err := server.ReadVectored([][]byte{hdr, payload})
+		if n > len(hdr) {
+			got = append(append(got, hdr...), payload[:n-len(hdr)]...)
+		} else {
+			got = append(got, hdr[:n]...)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			t.Fatal("ReadVectored returned no data and no error")
+		}
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("ReadVectored returned %d bytes, want %d", len(got), len(want))
+	}
+
+	if n, err := server.ReadVectored([][]byte{nil, {}}); n != 0 || err != nil {
+		t.Errorf("ReadVectored into empty buffers = %d, %v; want 0, nil", n, err)
+	}
+}
@@ -0,0 +1,67 @@
+package tls
+
+// mssRecheckRecords is the number of records after which AlignRecordsToMSS
+// queries the MSS again, to follow path MTU changes.
+const mssRecheckRecords = 64
+
+// alignedMSS returns the TCP maximum segment size to align records to, or 0
+// if Config.AlignRecordsToMSS is not set or the MSS is unknown. c.out must
+// be locked.
+func (c *Conn) alignedMSS() int {
+	if !c.config.AlignRecordsToMSS || c.config.RecordPadding != nil {
+		return 0
+	}
+	if c.mssRecords <= 0 {
+		c.mss = tcpMaxSeg(c.conn)
+		c.mssRecords = mssRecheckRecords
+	}
+	c.mssRecords--
+	return c.mss
+}
+
+// maxAlignedPayload returns the largest payload whose record fills an
+// integral number of segments of mss bytes, or maxPlaintext if mss is 0.
+func (c *Conn) maxAlignedPayload(mss int) int {
+	if mss <= 0 {
+		return maxPlaintext
+	}
+	for k := (maxPlaintext + recordBufOverhead) / mss; k > 0; k-- {
+		if n := c.payloadForRecordSize(k * mss); n > 0 && n <= maxPlaintext {
+			return n
+		}
+	}
+	return maxPlaintext
+}
+
+// ktlsRecordOverhead returns the bytes added to each record, besides the
+// header and TLS 1.3 content type, by the kernel's AEAD ciphers: the tag and,
+// for AES-GCM in TLS 1.2, the explicit nonce.
+func (c *Conn) ktlsRecordOverhead() int {
+	const tagLen, explicitNonceLen = 16, 8
+	if c.vers != VersionTLS13 &&
+		c.cipherSuite != TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305 &&
+		c.cipherSuite != TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305 {
+		return tagLen + explicitNonceLen
+	}
+	return tagLen
+}
+
+// writeAlignedKTLS writes application data to a connection with kernel TLS
+// TX in chunks of maxPayloadSizeForWrite, each of which the kernel sends as
+// one record. c.out must be locked.
+func (c *Conn) writeAlignedKTLS(data []byte) (int, error) {
+	var n int
+	for len(data) > 0 {
+		m := len(data)
+		if maxPayload := c.maxPayloadSizeForWrite(recordTypeApplicationData); m > maxPayload {
+			m = maxPayload
+		}
+		w, err := c.write(data[:m])
+		n += w
+		if err != nil {
+			return n, err
+		}
+		data = data[m:]
+	}
+	return n, nil
+}
@@ -0,0 +1,32 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// tcpMaxSeg returns the current TCP_MAXSEG of conn, or 0 if it is not a TCP
+// connection or the option can't be read.
+func tcpMaxSeg(conn net.Conn) int {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return 0
+	}
+	rwc, err := tcpConn.SyscallConn()
+	if err != nil {
+		return 0
+	}
+	var mss int
+	var err0 error
+	err = rwc.Control(func(fd uintptr) {
+		mss, err0 = unix.GetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_MAXSEG)
+	})
+	if err != nil || err0 != nil {
+		return 0
+	}
+	return mss
+}
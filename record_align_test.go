@@ -0,0 +1,79 @@
+package tls
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+// writeSizesConn records the size of each write to the connection.
+type writeSizesConn struct {
+	net.Conn
+	mu    sync.Mutex
+	sizes []int
+}
+
+func (c *writeSizesConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	c.sizes = append(c.sizes, len(b))
+	c.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+func TestAlignRecordsToMSS(t *testing.T) {
+	const mss = 1448
+	for _, dynamic := range []bool{false, true} {
+		c, s := localPipe(t)
+		sc := &writeSizesConn{Conn: s}
+		serverConfig := testConfig.Clone()
+		serverConfig.MaxVersion = VersionTLS13
+		serverConfig.AlignRecordsToMSS = true
+		serverConfig.DynamicRecordSizingDisabled = !dynamic
+		client := Client(c, testConfig)
+		server := Server(sc, serverConfig)
+
+		errChan := make(chan error, 1)
+		go func() { errChan <- server.Handshake() }()
+		if err := client.Handshake(); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+
+		// The pipe's loopback MSS is too large to be interesting.
+		server.out.Lock()
+		server.mss, server.mssRecords = mss, 1<<30
+		server.out.Unlock()
+		sc.mu.Lock()
+		sc.sizes = nil
+		sc.mu.Unlock()
+
+		data := make([]byte, 100000)
+		go func() {
+			server.Write(data)
+			server.Close()
+		}()
+		if _, err := io.ReadAll(client); err != nil {
+			t.Fatal(err)
+		}
+		client.Close()
+
+		sc.mu.Lock()
+		sizes := sc.sizes
+		sc.mu.Unlock()
+		// The last records are the remainder of data and the close_notify.
+		for i, size := range sizes[:len(sizes)-2] {
+			if size%mss != 0 {
+				t.Errorf("dynamic %v: record %d is %d bytes, not a multiple of %d", dynamic, i, size, mss)
+			}
+		}
+		if dynamic && sizes[0] != mss {
+			t.Errorf("first record is %d bytes, want %d", sizes[0], mss)
+		}
+		if !dynamic && sizes[0] != 11*mss {
+			t.Errorf("first record is %d bytes, want %d", sizes[0], 11*mss)
+		}
+	}
+}
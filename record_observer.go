@@ -0,0 +1,52 @@
+package tls
+
+// RecordInfo describes a record passed to a RecordObserver.
+type RecordInfo struct {
+	// Sent is true for records sent on the connection and false for
+	// records received.
+	Sent bool
+	// ContentType is the record's content type, such as 23 for
+	// application data.
+	ContentType uint8
+	// Length is the length of the record's plaintext. With kernel TLS TX,
+	// application data is observed per sendmsg, which the kernel may
+	// split into several records.
+	Length int
+	// KTLS is true if the record was protected by kernel TLS.
+	KTLS bool
+	// Sample holds up to Config.RecordSampleBytes leading bytes of the
+	// plaintext. It is only valid for the duration of the call.
+	Sample []byte
+}
+
+// A RecordObserver is given the records flowing through a connection, for
+// integrating data loss prevention or intrusion detection without wrapping
+// the Conn. See Config.RecordObserver.
+type RecordObserver interface {
+	// ObserveRecord is called with each observed record. It is called with
+	// the connection's read or write lock held, so it must be fast and
+	// must not call Read, Write or Close on conn.
+	ObserveRecord(conn *Conn, info RecordInfo)
+}
+
+// observeRecord passes a record to Config.RecordObserver, if set. c.in or
+// c.out must be locked, depending on the direction.
+func (c *Conn) observeRecord(sent bool, typ recordType, data []byte, ktls bool) {
+	obs := c.config.RecordObserver
+	if obs == nil {
+		return
+	}
+	info := RecordInfo{
+		Sent:        sent,
+		ContentType: uint8(typ),
+		Length:      len(data),
+		KTLS:        ktls,
+	}
+	if n := c.config.RecordSampleBytes; n > 0 {
+		if n > len(data) {
+			n = len(data)
+		}
+		info.Sample = data[:n:n]
+	}
+	obs.ObserveRecord(c, info)
+}
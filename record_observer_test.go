@@ -0,0 +1,72 @@
+package tls
+
+import (
+	"sync"
+	"testing"
+)
+
+// recordLog is a RecordObserver keeping what it observed.
+type recordLog struct {
+	mu      sync.Mutex
+	records []RecordInfo
+}
+
+func (l *recordLog) ObserveRecord(conn *Conn, info RecordInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	info.Sample = append([]byte(nil), info.Sample...)
+	l.records = append(l.records, info)
+}
+
+// appData returns the application data records observed in one direction.
+func (l *recordLog) appData(sent bool) []RecordInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var records []RecordInfo
+	for _, r := range l.records {
+		if r.Sent == sent && r.ContentType == uint8(recordTypeApplicationData) {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+func TestRecordObserver(t *testing.T) {
+	c, s := localPipe(t)
+	clientLog, serverLog := new(recordLog), new(recordLog)
+	clientConfig := testConfig.Clone()
+	clientConfig.RecordObserver = clientLog
+	clientConfig.RecordSampleBytes = 4
+	serverConfig := testConfig.Clone()
+	serverConfig.RecordObserver = serverLog
+	client := Client(c, clientConfig)
+	server := Server(s, serverConfig)
+	defer client.Close()
+	defer server.Close()
+
+	go client.Write([]byte("hello, world"))
+	buf := make([]byte, 12)
+	if n, err := server.Read(buf); err != nil || string(buf[:n]) != "hello, world" {
+		t.Fatalf("got %q, %v", buf[:n], err)
+	}
+
+	sent := clientLog.appData(true)
+	if len(sent) != 1 || sent[0].Length != 12 || string(sent[0].Sample) != "hell" {
+		t.Errorf("client observed %+v", sent)
+	}
+	received := serverLog.appData(false)
+	if len(received) != 1 || received[0].Length != 12 || received[0].Sample != nil {
+		t.Fatalf("server observed %+v", received)
+	}
+	if received[0].KTLS != server.IsKTLSRXEnabled() {
+		t.Errorf("KTLS = %v, want %v", received[0].KTLS, server.IsKTLSRXEnabled())
+	}
+
+	var handshake bool
+	for _, r := range serverLog.records {
+		handshake = handshake || r.ContentType == uint8(recordTypeHandshake) && !r.Sent
+	}
+	if !handshake {
+		t.Error("server observed no handshake records")
+	}
+}
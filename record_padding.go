@@ -0,0 +1,30 @@
+package tls
+
+// PadToMultiple returns a Config.RecordPadding policy that pads each record
+// so that its application data plus padding is a multiple of blockSize
+// bytes, hiding the exact length of the data it carries.
+func PadToMultiple(blockSize int) func(n int) int {
+	return func(n int) int {
+		if blockSize <= 1 {
+			return 0
+		}
+		return (blockSize - n%blockSize) % blockSize
+	}
+}
+
+// recordPadding returns the number of bytes of padding to add to a TLS 1.3
+// record carrying n bytes of application data, capped so that the record
+// does not exceed the maximum plaintext size.
+func (c *Config) recordPadding(n int) int {
+	if c.RecordPadding == nil {
+		return 0
+	}
+	pad := c.RecordPadding(n)
+	if pad < 0 {
+		return 0
+	}
+	if pad > maxPlaintext-n {
+		pad = maxPlaintext - n
+	}
+	return pad
+}
@@ -0,0 +1,72 @@
+package tls
+
+import (
+	"net"
+	"sync"
+	"testing"
+)
+
+// lastWriteConn records the size of the last write to the connection.
+type lastWriteConn struct {
+	net.Conn
+	mu   sync.Mutex
+	last int
+}
+
+func (c *lastWriteConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	c.last = len(b)
+	c.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+func TestRecordPadding(t *testing.T) {
+	c, s := localPipe(t)
+	sc := &lastWriteConn{Conn: s}
+	serverConfig := testConfig.Clone()
+	serverConfig.MaxVersion = VersionTLS13
+	serverConfig.RecordPadding = PadToMultiple(256)
+	client := Client(c, testConfig)
+	server := Server(sc, serverConfig)
+	defer client.Close()
+	defer server.Close()
+
+	errChan := make(chan error, 1)
+	go func() { errChan <- server.Handshake() }()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+
+	go server.Write([]byte("hi"))
+	buf := make([]byte, 16)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "hi" {
+		t.Errorf("got %q, want %q", buf[:n], "hi")
+	}
+
+	// 256 bytes of data and padding, the content type and the AEAD tag.
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if want := recordHeaderLen + 256 + 1 + 16; sc.last != want {
+		t.Errorf("record is %d bytes, want %d", sc.last, want)
+	}
+}
+
+func TestPadToMultiple(t *testing.T) {
+	pad := PadToMultiple(512)
+	for n, want := range map[int]int{0: 0, 1: 511, 512: 0, 513: 511} {
+		if got := pad(n); got != want {
+			t.Errorf("pad(%d) = %d, want %d", n, got, want)
+		}
+	}
+	config := &Config{RecordPadding: PadToMultiple(1 << 15)}
+	if got := config.recordPadding(maxPlaintext - 10); got != 10 {
+		t.Errorf("padding was not capped at the maximum record size: got %d", got)
+	}
+}
@@ -0,0 +1,79 @@
+package tls
+
+import (
+	"bytes"
+	"sync"
+)
+
+// recordBufSizes are the capacities of the size classes of the record buffer
+// pools, which are shared by all connections. The largest class fits a full
+// TLS 1.2 ciphertext record plus the read-ahead of readFromUntil.
+var recordBufSizes = [...]int{1 << 10, 4 << 10, 20 << 10}
+
+var recordBufPools [len(recordBufSizes)]sync.Pool
+
+// recordBufOverhead is added to the payload length when sizing a buffer for
+// an outgoing record, to leave room for the header, MAC and padding.
+const recordBufOverhead = 512
+
+// getRecordBuf returns an empty buffer with a capacity of at least n, taken
+// from the smallest size class that fits. Buffers larger than the largest
+// class are not pooled.
+func getRecordBuf(n int) *[]byte {
+	for i, size := range recordBufSizes {
+		if n > size {
+			continue
+		}
+		if b, ok := recordBufPools[i].Get().(*[]byte); ok {
+			*b = (*b)[:0]
+			return b
+		}
+		b := make([]byte, 0, size)
+		return &b
+	}
+	b := make([]byte, 0, n)
+	return &b
+}
+
+// putRecordBuf returns b to the largest size class it can serve. Buffers
+// smaller than the smallest class, or that grew well past the largest one,
+// are left to the garbage collector.
+func putRecordBuf(b *[]byte) {
+	c := cap(*b)
+	if c > 2*recordBufSizes[len(recordBufSizes)-1] {
+		return
+	}
+	for i := len(recordBufSizes) - 1; i >= 0; i-- {
+		if c >= recordBufSizes[i] {
+			recordBufPools[i].Put(b)
+			return
+		}
+	}
+}
+
+// acquireRawInput makes sure c.rawInput can hold n more bytes, backing it
+// with a pooled buffer if it has none. c.in must be locked.
+func (c *Conn) acquireRawInput(n int) {
+	if c.rawInputBuf == nil && c.rawInput.Cap() == 0 {
+		c.rawInputBuf = getRecordBuf(n)
+		c.rawInput = *bytes.NewBuffer(*c.rawInputBuf)
+	}
+	c.rawInput.Grow(n)
+}
+
+// releaseRawInput returns the buffer backing c.rawInput to the pool once
+// all buffered input was consumed, so that idle connections don't retain a
+// record-sized buffer each. c.in must be locked.
+func (c *Conn) releaseRawInput() {
+	if c.rawInputBuf == nil || c.rawInput.Len() != 0 || c.input.Len() != 0 {
+		return
+	}
+	// Reset rewinds the buffer so that Bytes covers its whole capacity,
+	// which might have grown past the pooled buffer.
+	c.rawInput.Reset()
+	*c.rawInputBuf = c.rawInput.Bytes()
+	putRecordBuf(c.rawInputBuf)
+	c.rawInputBuf = nil
+	c.rawInput = bytes.Buffer{}
+	c.input.Reset(nil)
+}
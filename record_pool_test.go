@@ -0,0 +1,58 @@
+package tls
+
+import (
+	"testing"
+)
+
+func TestRecordBufPool(t *testing.T) {
+	for _, n := range []int{0, 100, 1 << 10, 1<<10 + 1, 16 << 10, 20 << 10} {
+		b := getRecordBuf(n)
+		if len(*b) != 0 || cap(*b) < n {
+			t.Errorf("getRecordBuf(%d): len %d, cap %d", n, len(*b), cap(*b))
+		}
+		putRecordBuf(b)
+	}
+
+	// Buffers beyond the largest class are allocated to size.
+	if b := getRecordBuf(64 << 10); cap(*b) != 64<<10 {
+		t.Errorf("getRecordBuf(64K): cap %d", cap(*b))
+	}
+}
+
+func TestRawInputReleased(t *testing.T) {
+	client, server := coalescePipe(t, 0)
+	defer client.Close()
+	defer server.Close()
+
+	if client.rawInputBuf != nil || client.rawInput.Cap() != 0 {
+		t.Errorf("client retains a %d byte input buffer after the handshake", client.rawInput.Cap())
+	}
+
+	if _, err := server.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := client.Read(buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("got %q, %v", buf, err)
+	}
+	if client.rawInputBuf != nil || client.rawInput.Cap() != 0 {
+		t.Errorf("client retains a %d byte input buffer after a Read", client.rawInput.Cap())
+	}
+
+	// A partial Read keeps the buffer until the record is consumed.
+	if _, err := server.Write([]byte("world")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Read(buf[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if client.rawInputBuf == nil {
+		t.Error("client released its input buffer with pending data")
+	}
+	if n, err := client.Read(buf); err != nil || string(buf[:n]) != "rld" {
+		t.Fatalf("got %q, %v", buf[:n], err)
+	}
+	if client.rawInputBuf != nil {
+		t.Error("client retains its input buffer after consuming the record")
+	}
+}
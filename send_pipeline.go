@@ -0,0 +1,165 @@
+package tls
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// sendPipelineBufSize is the size of the two buffers of pipelinedCopy.
+const sendPipelineBufSize = 256 << 10
+
+var sendPipelineBufPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, sendPipelineBufSize)
+		return &b
+	},
+}
+
+// sendChunk is a buffer filled by the reading side of pipelinedCopy.
+type sendChunk struct {
+	buf *[]byte
+	n   int
+	err error
+}
+
+// pipelinedCopy copies r to c using two pooled buffers, so that the next
+// chunk is read from r while the previous one is encrypted and written.
+// It is used when the data can't be spliced into the socket, because r is
+// not a regular file or because TX is not offloaded to the kernel.
+//
+// Regular files, alone or wrapped in an io.LimitedReader, are read with
+// pread at increasing offsets, and their offset is advanced by the number
+// of bytes written to c once the copy ends, so that data read ahead but not
+// sent is not lost.
+func (c *Conn) pipelinedCopy(r io.Reader) (written int64, err error) {
+	limit := int64(-1)
+	lr, _ := r.(*io.LimitedReader)
+	if lr != nil {
+		limit = lr.N
+		if limit <= 0 {
+			return 0, nil
+		}
+		r = lr.R
+	}
+	var ra io.ReaderAt
+	f, offset := preadFile(r)
+	if f != nil {
+		ra = f
+	}
+
+	written, err = c.pipelinedSend(r, ra, offset, limit)
+
+	if f != nil {
+		if _, serr := f.Seek(offset+written, io.SeekStart); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	if lr != nil {
+		lr.N -= written
+	}
+	return written, err
+}
+
+// pipelinedSend writes up to limit bytes, or everything if limit is
+// negative, to c using two pooled buffers. The data is read from ra at
+// increasing offsets starting at offset if ra is not nil, and from r
+// otherwise. It stops without error at the end of the data.
+func (c *Conn) pipelinedSend(r io.Reader, ra io.ReaderAt, offset, limit int64) (written int64, err error) {
+	free := make(chan *[]byte, 2)
+	full := make(chan sendChunk, 2)
+	free <- sendPipelineBufPool.Get().(*[]byte)
+	free <- sendPipelineBufPool.Get().(*[]byte)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(full)
+		pos, remain := offset, limit
+		for {
+			// Don't read ahead once the copy ended.
+			select {
+			case <-done:
+				return
+			default:
+			}
+			var buf *[]byte
+			select {
+			case buf = <-free:
+			case <-done:
+				return
+			}
+			b := *buf
+			if remain >= 0 && int64(len(b)) > remain {
+				b = b[:remain]
+			}
+			var n int
+			var err error
+			if ra != nil {
+				n, err = ra.ReadAt(b, pos)
+				pos += int64(n)
+				if err == io.EOF && n > 0 {
+					err = nil
+				}
+			} else {
+				n, err = r.Read(b)
+			}
+			if remain >= 0 {
+				if remain -= int64(n); remain == 0 && err == nil {
+					err = io.EOF
+				}
+			}
+			full <- sendChunk{buf, n, err}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for chunk := range full {
+		if chunk.n > 0 {
+			m, werr := c.Write((*chunk.buf)[:chunk.n])
+			written += int64(m)
+			if werr != nil {
+				err = werr
+			}
+		}
+		free <- chunk.buf
+		if err != nil {
+			break
+		}
+		if chunk.err != nil {
+			if chunk.err != io.EOF {
+				err = chunk.err
+			}
+			break
+		}
+	}
+	close(done)
+	// Wait for a read in progress, which still uses r.
+	for chunk := range full {
+		free <- chunk.buf
+	}
+	close(free)
+	for buf := range free {
+		sendPipelineBufPool.Put(buf)
+	}
+	return written, err
+}
+
+// preadFile returns r as a regular file and its current offset, if it is
+// one.
+func preadFile(r io.Reader) (*os.File, int64) {
+	f, ok := r.(*os.File)
+	if !ok {
+		return nil, 0
+	}
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return nil, 0
+	}
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, 0
+	}
+	return f, offset
+}
@@ -0,0 +1,68 @@
+package tls
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPipelinedCopy(t *testing.T) {
+	data := make([]byte, 3*sendPipelineBufSize+100)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	path := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	client, server := coalescePipe(t, 0)
+	defer client.Close()
+	defer server.Close()
+
+	copyAndCheck := func(r io.Reader, want []byte) {
+		t.Helper()
+		read := make(chan []byte, 1)
+		go func() {
+			got := make([]byte, len(want))
+			if _, err := io.ReadFull(client, got); err != nil {
+				t.Error(err)
+			}
+			read <- got
+		}()
+		n, err := server.pipelinedCopy(r)
+		if err != nil || n != int64(len(want)) {
+			t.Fatalf("pipelinedCopy = %d, %v; want %d", n, err, len(want))
+		}
+		if got := <-read; !bytes.Equal(got, want) {
+			t.Error("client received different data")
+		}
+	}
+
+	// A limited regular file is read with pread and its offset advanced by
+	// the number of bytes sent.
+	if _, err := f.Seek(10, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	lr := &io.LimitedReader{R: f, N: 2*sendPipelineBufSize + 5}
+	copyAndCheck(lr, data[10:10+2*sendPipelineBufSize+5])
+	if lr.N != 0 {
+		t.Errorf("LimitedReader.N = %d, want 0", lr.N)
+	}
+	if off, _ := f.Seek(0, io.SeekCurrent); off != 10+2*sendPipelineBufSize+5 {
+		t.Errorf("file offset = %d, want %d", off, 10+2*sendPipelineBufSize+5)
+	}
+
+	// The rest of the file.
+	copyAndCheck(f, data[10+2*sendPipelineBufSize+5:])
+
+	// A reader that is not a file.
+	copyAndCheck(io.MultiReader(bytes.NewReader(data)), data)
+}
@@ -0,0 +1,72 @@
+package tls
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+var (
+	errSendSectionRange = errors.New("tls: SendSection with a negative offset or length")
+	errSendFileRange    = errors.New("tls: SendFile with a negative offset or length")
+)
+
+// ErrNoKTLSTX is returned by SendFile if the connection doesn't send with
+// kernel TLS, so the file can't be sent with sendfile, and by
+// Conn.KTLSCryptoInfo.
+var ErrNoKTLSTX = errors.New("tls: kernel TLS TX is not enabled")
+
+// SendSection writes the n bytes of r starting at offset off to the
+// connection, as for serving a byte range. If TX is offloaded to the kernel
+// and r is a regular *os.File, the section is sent with sendfile without
+// changing the file's offset. Otherwise it is read with ReadAt into pooled
+// buffers, the next chunk being read while the previous one is written.
+//
+// SendSection returns the number of bytes written, and io.ErrUnexpectedEOF
+// if r ends before off+n.
+func (c *Conn) SendSection(r io.ReaderAt, off, n int64) (int64, error) {
+	if off < 0 || n < 0 {
+		return 0, errSendSectionRange
+	}
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if f, ok := r.(*os.File); ok {
+		if written, err, handled := c.sendfileSection(f, off, n); handled {
+			return written, err
+		}
+	}
+	written, err := c.pipelinedSend(nil, r, off, n)
+	if err == nil && written < n {
+		err = io.ErrUnexpectedEOF
+	}
+	return written, err
+}
+
+// SendFile writes the n bytes of f starting at offset off to the connection
+// with sendfile, after completing the handshake if needed. Unlike
+// SendSection and ReadFrom, it never falls back to encrypting in user
+// space: it returns ErrNoKTLSTX, having written nothing, unless TX is
+// offloaded to the kernel and f is a regular file. The file's offset is
+// not changed.
+//
+// Partial writes are retried until the section is sent. The write deadline
+// of the connection applies, and if it expires SendFile returns the number
+// of bytes sent so far with an error wrapping os.ErrDeadlineExceeded.
+// SendFile returns io.ErrUnexpectedEOF if f ends before off+n.
+func (c *Conn) SendFile(f *os.File, off, n int64) (int64, error) {
+	if off < 0 || n < 0 {
+		return 0, errSendFileRange
+	}
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	written, err, handled := c.sendfileSection(f, off, n)
+	if !handled {
+		return 0, ErrNoKTLSTX
+	}
+	return written, err
+}
@@ -0,0 +1,94 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxSendfileSection bounds the length of each sendfile call of
+// sendfileSection.
+const maxSendfileSection = 4 << 20
+
+// sendfileSection sends n bytes of f at offset off with sendfile, if TX is
+// offloaded to the kernel and f is a regular file. handled is false if it
+// could not be used, in which case nothing was sent.
+func (c *Conn) sendfileSection(f *os.File, off, n int64) (written int64, err error, handled bool) {
+	if _, ok := c.out.cipher.(kTLSCipher); !ok {
+		return 0, nil, false
+	}
+	sock := c.ktlsConn()
+	if sock == nil {
+		return 0, nil, false
+	}
+	if fi, err := f.Stat(); err != nil || !fi.Mode().IsRegular() {
+		return 0, nil, false
+	}
+	fsc, err := f.SyscallConn()
+	if err != nil {
+		return 0, nil, false
+	}
+	sc, err := sock.SyscallConn()
+	if err != nil {
+		return 0, nil, false
+	}
+
+	if err := c.Flush(); err != nil {
+		return 0, err, true
+	}
+	if ring := c.spliceRing(); ring != nil {
+		cerr := fsc.Control(func(infd uintptr) {
+			written, err, handled = c.ringSendfile(ring, infd, sc, off, n)
+		})
+		if handled {
+			if err == nil {
+				err = cerr
+			}
+			c.stats.sendfileBytes.Add(uint64(written))
+			return written, err, true
+		}
+	}
+	var werr, serr error
+	cerr := fsc.Control(func(infd uintptr) {
+		werr = sc.Write(func(outfd uintptr) bool {
+			for written < n {
+				chunk := n - written
+				if chunk > maxSendfileSection {
+					chunk = maxSendfileSection
+				}
+				m, err := unix.Sendfile(int(outfd), int(infd), &off, int(chunk))
+				if m > 0 {
+					written += int64(m)
+				}
+				switch {
+				case err == unix.EAGAIN:
+					// The socket is full, wait until it is writable.
+					return false
+				case err == unix.EINTR:
+					continue
+				case err != nil:
+					serr = os.NewSyscallError("sendfile", err)
+					return true
+				case m == 0:
+					serr = io.ErrUnexpectedEOF
+					return true
+				}
+			}
+			return true
+		})
+	})
+	c.stats.sendfileBytes.Add(uint64(written))
+	switch {
+	case serr != nil:
+		err = serr
+	case werr != nil:
+		err = werr
+	default:
+		err = cerr
+	}
+	return written, err, true
+}
@@ -0,0 +1,91 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// sendFileConn returns a Conn that believes TX is offloaded over a plain TCP
+// connection, so that sendfile puts the file on the wire as is, and the
+// peer of that connection.
+func sendFileConn(t *testing.T) (*Conn, net.Conn) {
+	ln := newLocalListener(t)
+	defer ln.Close()
+	tcpConn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tcpConn.Close() })
+	peer, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peer.Close() })
+
+	c := &Conn{conn: tcpConn, config: testConfig.Clone(), ktlsSock: tcpConn}
+	c.isHandshakeComplete.Store(true)
+	c.out.cipher = kTLSCipher{}
+	return c, peer
+}
+
+func TestSendFile(t *testing.T) {
+	data := make([]byte, 1<<20)
+	for i := range data {
+		data[i] = byte(i * 13)
+	}
+	path := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	c, peer := sendFileConn(t)
+	want := data[100 : len(data)-100]
+	read := make(chan []byte, 1)
+	go func() {
+		got := make([]byte, len(want))
+		io.ReadFull(peer, got)
+		read <- got
+	}()
+	if n, err := c.SendFile(f, 100, int64(len(want))); n != int64(len(want)) || err != nil {
+		t.Fatalf("SendFile = %d, %v; want %d, nil", n, err, len(want))
+	}
+	if got := <-read; !bytes.Equal(got, want) {
+		t.Error("SendFile sent the wrong data")
+	}
+	if pos, _ := f.Seek(0, io.SeekCurrent); pos != 0 {
+		t.Errorf("file offset = %d, want 0", pos)
+	}
+	if stats := c.Stats(); stats.SendfileBytes != uint64(len(want)) || stats.TXCopyBytes != 0 {
+		t.Errorf("SendfileBytes, TXCopyBytes = %d, %d; want %d, 0", stats.SendfileBytes, stats.TXCopyBytes, len(want))
+	}
+
+	big := filepath.Join(t.TempDir(), "big")
+	if err := os.WriteFile(big, make([]byte, 64<<20), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	bf, err := os.Open(big)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bf.Close()
+	// Nobody reads from peer, so the socket fills up until the deadline.
+	c.conn.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	n, err := c.SendFile(bf, 0, 64<<20)
+	if !errors.Is(err, os.ErrDeadlineExceeded) || n == 0 || n >= 64<<20 {
+		t.Errorf("SendFile past the deadline = %d, %v; want a partial write and %v", n, err, os.ErrDeadlineExceeded)
+	}
+}
@@ -0,0 +1,78 @@
+package tls
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSendSection(t *testing.T) {
+	data := make([]byte, 2*sendPipelineBufSize+100)
+	for i := range data {
+		data[i] = byte(i * 13)
+	}
+	path := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	client, server := coalescePipe(t, 0)
+	defer client.Close()
+	defer server.Close()
+
+	send := func(r io.ReaderAt, off, n int64, want []byte, wantErr error) {
+		t.Helper()
+		read := make(chan []byte, 1)
+		go func() {
+			got := make([]byte, len(want))
+			if _, err := io.ReadFull(client, got); err != nil {
+				t.Error(err)
+			}
+			read <- got
+		}()
+		written, err := server.SendSection(r, off, n)
+		if err != wantErr || written != int64(len(want)) {
+			t.Fatalf("SendSection(%d, %d) = %d, %v; want %d, %v", off, n, written, err, len(want), wantErr)
+		}
+		if got := <-read; !bytes.Equal(got, want) {
+			t.Errorf("SendSection(%d, %d) sent the wrong data", off, n)
+		}
+	}
+
+	send(f, 10, sendPipelineBufSize+5, data[10:10+sendPipelineBufSize+5], nil)
+	if pos, _ := f.Seek(0, io.SeekCurrent); pos != 0 {
+		t.Errorf("file offset = %d, want 0", pos)
+	}
+	send(bytes.NewReader(data), 1000, 5000, data[1000:6000], nil)
+	send(f, int64(len(data))-50, 100, data[len(data)-50:], io.ErrUnexpectedEOF)
+	send(bytes.NewReader(data), 0, 0, nil, nil)
+
+	if _, err := server.SendSection(f, -1, 10); err != errSendSectionRange {
+		t.Errorf("got %v, want %v", err, errSendSectionRange)
+	}
+}
+
+func TestSendFileRequiresKTLSTX(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	client, server := coalescePipe(t, 0)
+	defer client.Close()
+	defer server.Close()
+	if n, err := server.SendFile(f, 0, 10); n != 0 || err != ErrNoKTLSTX {
+		t.Errorf("SendFile = %d, %v; want 0, %v", n, err, ErrNoKTLSTX)
+	}
+	if _, err := server.SendFile(f, 0, -1); err != errSendFileRange {
+		t.Errorf("got %v, want %v", err, errSendFileRange)
+	}
+}
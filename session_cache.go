@@ -0,0 +1,446 @@
+package tls
+
+import (
+	"container/list"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/x509"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// marshal serializes cs, including the server certificates, so that it can be
+// restored by unmarshal in another process.
+func (cs *ClientSessionState) marshal() ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddUint16(cs.vers)
+	b.AddUint16(cs.cipherSuite)
+	addUint64(&b, uint64(cs.receivedAt.UnixNano()))
+	var useBy uint64
+	if !cs.useBy.IsZero() {
+		useBy = uint64(cs.useBy.UnixNano())
+	}
+	addUint64(&b, useBy)
+	b.AddUint32(cs.ageAdd)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(cs.sessionTicket)
+	})
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(cs.masterSecret)
+	})
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(cs.nonce)
+	})
+	marshalCertificateList(&b, cs.serverCertificates)
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, chain := range cs.verifiedChains {
+			marshalCertificateList(b, chain)
+		}
+	})
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(cs.ocspResponse)
+	})
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, sct := range cs.scts {
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddBytes(sct)
+			})
+		}
+	})
+	return b.Bytes()
+}
+
+func (cs *ClientSessionState) unmarshal(data []byte) bool {
+	*cs = ClientSessionState{}
+	s := cryptobyte.String(data)
+	var receivedAt, useBy uint64
+	if ok := s.ReadUint16(&cs.vers) &&
+		s.ReadUint16(&cs.cipherSuite) &&
+		readUint64(&s, &receivedAt) &&
+		readUint64(&s, &useBy) &&
+		s.ReadUint32(&cs.ageAdd) &&
+		readUint16LengthPrefixed(&s, &cs.sessionTicket) &&
+		readUint16LengthPrefixed(&s, &cs.masterSecret) &&
+		len(cs.masterSecret) != 0 &&
+		readUint8LengthPrefixed(&s, &cs.nonce) &&
+		unmarshalCertificateList(&s, &cs.serverCertificates); !ok {
+		return false
+	}
+	cs.receivedAt = time.Unix(0, int64(receivedAt))
+	if useBy != 0 {
+		cs.useBy = time.Unix(0, int64(useBy))
+	}
+
+	var chains cryptobyte.String
+	if !s.ReadUint24LengthPrefixed(&chains) {
+		return false
+	}
+	for !chains.Empty() {
+		var chain []*x509.Certificate
+		if !unmarshalCertificateList(&chains, &chain) {
+			return false
+		}
+		cs.verifiedChains = append(cs.verifiedChains, chain)
+	}
+	if !readUint24LengthPrefixed(&s, &cs.ocspResponse) {
+		return false
+	}
+	var scts cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&scts) {
+		return false
+	}
+	for !scts.Empty() {
+		var sct []byte
+		if !readUint16LengthPrefixed(&scts, &sct) {
+			return false
+		}
+		cs.scts = append(cs.scts, sct)
+	}
+	return s.Empty()
+}
+
+func marshalCertificateList(b *cryptobyte.Builder, certs []*x509.Certificate) {
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, cert := range certs {
+			b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddBytes(cert.Raw)
+			})
+		}
+	})
+}
+
+func unmarshalCertificateList(s *cryptobyte.String, out *[]*x509.Certificate) bool {
+	var certList cryptobyte.String
+	if !s.ReadUint24LengthPrefixed(&certList) {
+		return false
+	}
+	for !certList.Empty() {
+		var der []byte
+		if !readUint24LengthPrefixed(&certList, &der) {
+			return false
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return false
+		}
+		*out = append(*out, cert)
+	}
+	return true
+}
+
+// sessionCacheFileAD is the additional data authenticated with the contents
+// of a session cache file, to version the format.
+var sessionCacheFileAD = []byte("goktls client session cache v1")
+
+var (
+	errSessionCacheKey  = errors.New("tls: session cache key must be 32 bytes")
+	errSessionCacheFile = errors.New("tls: malformed or undecryptable session cache file")
+)
+
+// A PersistentClientSessionCache is a ClientSessionCache that uses an LRU
+// caching strategy, expires entries after a fixed lifetime and can store its
+// contents in a file, so that a client can resume sessions across process
+// restarts.
+//
+// The file is encrypted and authenticated with AES-256-GCM, since it holds
+// the secrets needed to resume the sessions. It is rewritten in the
+// background after the cache changes, with changes made while a write is
+// in progress batched into the next one, so Save should be called before
+// the process exits to make sure the last changes are written.
+type PersistentClientSessionCache struct {
+	mu       sync.Mutex
+	m        map[string]*list.Element
+	q        *list.List
+	capacity int
+	ttl      time.Duration
+	path     string
+	aead     cipher.AEAD
+	dirty    bool // changed since the last snapshot written to the file
+	saving   bool // a background write is running
+
+	// fileMu serializes writes of the file and is acquired before mu, so
+	// that snapshots are written in the order they were taken.
+	fileMu sync.Mutex
+}
+
+type persistentSessionCacheEntry struct {
+	sessionKey string
+	state      *ClientSessionState
+	expires    time.Time
+}
+
+// NewPersistentClientSessionCache returns a PersistentClientSessionCache with
+// the given capacity. If capacity is < 1, a default capacity is used instead.
+//
+// Entries expire ttl after they are added, or when the server's ticket
+// lifetime ends if that is sooner. If ttl is <= 0, only the ticket lifetime
+// is enforced. TLS 1.2 tickets carry no lifetime and are kept for at most
+// seven days.
+//
+// If path is not empty, the cache is loaded from the file at path, if it
+// exists, and saved to it whenever it changes. key must then be a 32-byte
+// key used to encrypt the file. Entries that have expired are dropped when
+// the file is loaded. A file that can't be read, decrypted or parsed, such
+// as one written with another key, is logged and ignored, and the cache
+// starts empty and replaces it on the next change.
+func NewPersistentClientSessionCache(capacity int, ttl time.Duration, path string, key []byte) (*PersistentClientSessionCache, error) {
+	const defaultSessionCacheCapacity = 64
+
+	if capacity < 1 {
+		capacity = defaultSessionCacheCapacity
+	}
+	c := &PersistentClientSessionCache{
+		m:        make(map[string]*list.Element),
+		q:        list.New(),
+		capacity: capacity,
+		ttl:      ttl,
+		path:     path,
+	}
+	if path == "" {
+		return c, nil
+	}
+
+	if len(key) != 32 {
+		return nil, errSessionCacheKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if c.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	if err := c.load(); err != nil {
+		Debugln("tls: ignoring client session cache file:", err)
+		c.m = make(map[string]*list.Element)
+		c.q.Init()
+	}
+	return c, nil
+}
+
+// Put adds the provided (sessionKey, cs) pair to the cache. If cs is nil, the
+// entry corresponding to sessionKey is removed from the cache instead.
+func (c *PersistentClientSessionCache) Put(sessionKey string, cs *ClientSessionState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cs == nil {
+		if elem, ok := c.m[sessionKey]; ok {
+			c.q.Remove(elem)
+			delete(c.m, sessionKey)
+		}
+	} else {
+		c.putLocked(sessionKey, cs, c.expiry(cs, time.Now()))
+	}
+
+	if c.path == "" {
+		return
+	}
+	c.dirty = true
+	if !c.saving {
+		c.saving = true
+		go c.saveInBackground()
+	}
+}
+
+// saveInBackground writes the cache to its file until no changes are left
+// unwritten.
+func (c *PersistentClientSessionCache) saveInBackground() {
+	for {
+		c.fileMu.Lock()
+		c.mu.Lock()
+		if !c.dirty {
+			c.saving = false
+			c.mu.Unlock()
+			c.fileMu.Unlock()
+			return
+		}
+		plaintext, err := c.marshalLocked()
+		c.mu.Unlock()
+		if err == nil {
+			err = c.writeFile(plaintext)
+		}
+		c.fileMu.Unlock()
+		if err != nil {
+			Debugln("tls: failed to save client session cache:", err)
+		}
+	}
+}
+
+// Get returns the ClientSessionState value associated with a given key. It
+// returns (nil, false) if no value is found or the value has expired.
+func (c *PersistentClientSessionCache) Get(sessionKey string) (*ClientSessionState, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.m[sessionKey]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*persistentSessionCacheEntry)
+	if !time.Now().Before(entry.expires) {
+		c.q.Remove(elem)
+		delete(c.m, sessionKey)
+		return nil, false
+	}
+	c.q.MoveToFront(elem)
+	return entry.state, true
+}
+
+// Save writes the cache to its file and returns once it is on disk. The
+// cache is saved in the background whenever it changes, but Save should be
+// called before the process exits, so that the last changes are not lost.
+func (c *PersistentClientSessionCache) Save() error {
+	if c.path == "" {
+		return nil
+	}
+	c.fileMu.Lock()
+	defer c.fileMu.Unlock()
+	c.mu.Lock()
+	plaintext, err := c.marshalLocked()
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return c.writeFile(plaintext)
+}
+
+// expiry returns the time at which cs, added to the cache at now, expires.
+func (c *PersistentClientSessionCache) expiry(cs *ClientSessionState, now time.Time) time.Time {
+	// The ticket lifetime is measured against the clock of the Config that
+	// received it, which may differ from the wall clock.
+	lifetime := maxSessionTicketLifetime
+	if !cs.useBy.IsZero() {
+		lifetime = cs.useBy.Sub(cs.receivedAt)
+	}
+	expires := now.Add(lifetime)
+	if c.ttl > 0 && now.Add(c.ttl).Before(expires) {
+		expires = now.Add(c.ttl)
+	}
+	return expires
+}
+
+func (c *PersistentClientSessionCache) putLocked(sessionKey string, cs *ClientSessionState, expires time.Time) {
+	if elem, ok := c.m[sessionKey]; ok {
+		entry := elem.Value.(*persistentSessionCacheEntry)
+		entry.state = cs
+		entry.expires = expires
+		c.q.MoveToFront(elem)
+		return
+	}
+
+	if c.q.Len() < c.capacity {
+		entry := &persistentSessionCacheEntry{sessionKey, cs, expires}
+		c.m[sessionKey] = c.q.PushFront(entry)
+		return
+	}
+
+	elem := c.q.Back()
+	entry := elem.Value.(*persistentSessionCacheEntry)
+	delete(c.m, entry.sessionKey)
+	entry.sessionKey = sessionKey
+	entry.state = cs
+	entry.expires = expires
+	c.q.MoveToFront(elem)
+	c.m[sessionKey] = elem
+}
+
+// marshalLocked returns the contents of the cache as stored in its file,
+// before encryption, and marks them as written. Entries are written from
+// least to most recently used. c.mu must be held.
+func (c *PersistentClientSessionCache) marshalLocked() ([]byte, error) {
+	var b cryptobyte.Builder
+	for elem := c.q.Back(); elem != nil; elem = elem.Prev() {
+		entry := elem.Value.(*persistentSessionCacheEntry)
+		state, err := entry.state.marshal()
+		if err != nil {
+			return nil, err
+		}
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes([]byte(entry.sessionKey))
+		})
+		addUint64(&b, uint64(entry.expires.UnixNano()))
+		b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(state)
+		})
+	}
+	c.dirty = false
+	return b.Bytes()
+}
+
+// writeFile encrypts plaintext and atomically replaces the cache file with
+// it. The new file is synced before it replaces the old one, so that a
+// crash leaves either of them intact. c.fileMu must be held.
+func (c *PersistentClientSessionCache) writeFile(plaintext []byte) error {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(defaultConfig().rand(), nonce); err != nil {
+		return err
+	}
+	data := c.aead.Seal(nonce, nonce, plaintext, sessionCacheFileAD)
+
+	f, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), c.path)
+}
+
+// load populates the cache from its file, if it exists.
+func (c *PersistentClientSessionCache) load() error {
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	nonceSize := c.aead.NonceSize()
+	if len(data) < nonceSize {
+		return errSessionCacheFile
+	}
+	plaintext, err := c.aead.Open(nil, data[:nonceSize], data[nonceSize:], sessionCacheFileAD)
+	if err != nil {
+		return errSessionCacheFile
+	}
+
+	now := time.Now()
+	s := cryptobyte.String(plaintext)
+	for !s.Empty() {
+		var sessionKey, state []byte
+		var expires uint64
+		if !readUint16LengthPrefixed(&s, &sessionKey) ||
+			!readUint64(&s, &expires) ||
+			!readUint24LengthPrefixed(&s, &state) {
+			return errSessionCacheFile
+		}
+		cs := new(ClientSessionState)
+		if !cs.unmarshal(state) {
+			return errSessionCacheFile
+		}
+		if t := time.Unix(0, int64(expires)); now.Before(t) {
+			c.putLocked(string(sessionKey), cs, t)
+		}
+	}
+	return nil
+}
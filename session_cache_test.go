@@ -0,0 +1,123 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClientSessionStateMarshal(t *testing.T) {
+	cert := testConfig.Certificates[0].Leaf
+	if cert == nil {
+		var err error
+		if cert, err = testConfig.Certificates[0].leaf(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cs := &ClientSessionState{
+		sessionTicket:      []byte("ticket"),
+		vers:               VersionTLS13,
+		cipherSuite:        TLS_AES_128_GCM_SHA256,
+		masterSecret:       []byte("secret"),
+		serverCertificates: []*x509.Certificate{cert},
+		verifiedChains:     [][]*x509.Certificate{{cert, cert}},
+		receivedAt:         time.Unix(100, 5),
+		ocspResponse:       []byte("ocsp"),
+		scts:               [][]byte{[]byte("a"), []byte("b")},
+		nonce:              []byte("nonce"),
+		useBy:              time.Unix(200, 0),
+		ageAdd:             42,
+	}
+	b, err := cs.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got ClientSessionState
+	if !got.unmarshal(b) {
+		t.Fatal("failed to unmarshal")
+	}
+	if !reflect.DeepEqual(&got, cs) {
+		t.Errorf("round trip mismatch:\ngot  %+v\nwant %+v", &got, cs)
+	}
+	if got.unmarshal(b[:len(b)-1]) {
+		t.Error("unmarshaled a truncated session")
+	}
+}
+
+func TestPersistentClientSessionCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions")
+	key := bytes.Repeat([]byte{1}, 32)
+	cache, err := NewPersistentClientSessionCache(2, 0, path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientConfig := testConfig.Clone()
+	clientConfig.ClientSessionCache = cache
+	provider := &staticTicketKeys{current: [32]byte{1}}
+	if resumeWith(t, clientConfig, provider) {
+		t.Fatal("first handshake resumed")
+	}
+
+	// A new cache loaded from the same file resumes the session.
+	if err := cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if cache, err = NewPersistentClientSessionCache(2, 0, path, key); err != nil {
+		t.Fatal(err)
+	}
+	clientConfig.ClientSessionCache = cache
+	if !resumeWith(t, clientConfig, provider) {
+		t.Error("session was not resumed from the persisted cache")
+	}
+
+	// A file that can't be decrypted, or is truncated, is ignored.
+	if cache, err = NewPersistentClientSessionCache(2, 0, path, bytes.Repeat([]byte{2}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	if cache.q.Len() != 0 {
+		t.Error("loaded the cache file with the wrong key")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data[:len(data)/2], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if cache, err = NewPersistentClientSessionCache(2, 0, path, key); err != nil {
+		t.Fatal(err)
+	}
+	if cache.q.Len() != 0 {
+		t.Error("loaded a truncated cache file")
+	}
+}
+
+func TestPersistentClientSessionCacheExpiry(t *testing.T) {
+	cache, err := NewPersistentClientSessionCache(2, time.Nanosecond, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Put("a", &ClientSessionState{})
+	time.Sleep(time.Millisecond)
+	if _, ok := cache.Get("a"); ok {
+		t.Error("got an expired entry")
+	}
+
+	if cache, err = NewPersistentClientSessionCache(2, 0, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"a", "b", "c"} {
+		cache.Put(k, &ClientSessionState{})
+	}
+	if _, ok := cache.Get("a"); ok {
+		t.Error("least recently used entry was not evicted")
+	}
+	if _, ok := cache.Get("c"); !ok {
+		t.Error("most recent entry is missing")
+	}
+}
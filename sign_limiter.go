@@ -0,0 +1,155 @@
+package tls
+
+import (
+	"crypto"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var errHandshakeSignTimeout = errors.New("tls: handshake private key operation timed out")
+
+// A HandshakeSignLimiter bounds the private key operations run by
+// handshakes, the signatures and, for RSA key exchange, decryptions made
+// with Certificate.PrivateKey. It is meant for keys held in remote or
+// hardware signers, where a burst of handshakes would otherwise queue an
+// unbounded number of slow operations.
+//
+// A HandshakeSignLimiter is set with Config.HandshakeSignLimiter and may be
+// shared by several Configs. It is safe for concurrent use.
+type HandshakeSignLimiter struct {
+	timeout time.Duration
+	// slots holds one element per operation in flight. It is nil if the
+	// number of concurrent operations is not limited.
+	slots chan struct{}
+
+	queued    atomic.Int64
+	inFlight  atomic.Int64
+	completed atomic.Uint64
+	failed    atomic.Uint64
+	timedOut  atomic.Uint64
+	wait      histogram
+}
+
+// HandshakeSignStats is a snapshot of the statistics collected by a
+// HandshakeSignLimiter.
+type HandshakeSignStats struct {
+	Queued    int64  // operations waiting for a free slot
+	InFlight  int64  // operations running
+	Completed uint64 // operations that succeeded
+	Failed    uint64 // operations that returned an error
+	// TimedOut counts the operations a handshake gave up on. An operation
+	// that times out while running is still counted as Completed or Failed
+	// once the signer returns.
+	TimedOut uint64
+	// QueueWait is the distribution of the time, in nanoseconds, that
+	// operations waited for a free slot.
+	QueueWait Histogram
+}
+
+// NewHandshakeSignLimiter returns a HandshakeSignLimiter that runs at most
+// maxConcurrent operations at a time, or any number if maxConcurrent is
+// < 1. If timeout is positive, a handshake fails if its operation has not
+// completed within timeout, including the time spent waiting for a slot.
+//
+// An operation that times out while running keeps its slot until the
+// signer returns, so that a stalled signer is not handed more work.
+func NewHandshakeSignLimiter(maxConcurrent int, timeout time.Duration) *HandshakeSignLimiter {
+	l := &HandshakeSignLimiter{timeout: timeout}
+	if maxConcurrent > 0 {
+		l.slots = make(chan struct{}, maxConcurrent)
+	}
+	return l
+}
+
+// Stats returns a snapshot of the statistics collected by l.
+func (l *HandshakeSignLimiter) Stats() HandshakeSignStats {
+	return HandshakeSignStats{
+		Queued:    l.queued.Load(),
+		InFlight:  l.inFlight.Load(),
+		Completed: l.completed.Load(),
+		Failed:    l.failed.Load(),
+		TimedOut:  l.timedOut.Load(),
+		QueueWait: l.wait.snapshot(latencyBounds),
+	}
+}
+
+// do runs op subject to the limits of l.
+func (l *HandshakeSignLimiter) do(op func() ([]byte, error)) ([]byte, error) {
+	var deadline <-chan time.Time
+	if l.timeout > 0 {
+		t := time.NewTimer(l.timeout)
+		defer t.Stop()
+		deadline = t.C
+	}
+
+	if l.slots != nil {
+		start := time.Now()
+		l.queued.Add(1)
+		select {
+		case l.slots <- struct{}{}:
+			l.queued.Add(-1)
+		case <-deadline:
+			l.queued.Add(-1)
+			l.timedOut.Add(1)
+			return nil, errHandshakeSignTimeout
+		}
+		l.wait.observe(latencyBounds, int64(time.Since(start)))
+	}
+
+	run := func() ([]byte, error) {
+		l.inFlight.Add(1)
+		out, err := op()
+		l.inFlight.Add(-1)
+		if err != nil {
+			l.failed.Add(1)
+		} else {
+			l.completed.Add(1)
+		}
+		if l.slots != nil {
+			<-l.slots
+		}
+		return out, err
+	}
+	if deadline == nil {
+		return run()
+	}
+
+	type result struct {
+		out []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		out, err := run()
+		done <- result{out, err}
+	}()
+	select {
+	case r := <-done:
+		return r.out, r.err
+	case <-deadline:
+		l.timedOut.Add(1)
+		return nil, errHandshakeSignTimeout
+	}
+}
+
+// handshakeSign signs digest with priv, subject to c.HandshakeSignLimiter.
+func (c *Config) handshakeSign(priv crypto.Signer, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if c.HandshakeSignLimiter == nil {
+		return priv.Sign(c.rand(), digest, opts)
+	}
+	return c.HandshakeSignLimiter.do(func() ([]byte, error) {
+		return priv.Sign(c.rand(), digest, opts)
+	})
+}
+
+// handshakeDecrypt decrypts msg with priv, subject to
+// c.HandshakeSignLimiter.
+func (c *Config) handshakeDecrypt(priv crypto.Decrypter, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	if c.HandshakeSignLimiter == nil {
+		return priv.Decrypt(c.rand(), msg, opts)
+	}
+	return c.HandshakeSignLimiter.do(func() ([]byte, error) {
+		return priv.Decrypt(c.rand(), msg, opts)
+	})
+}
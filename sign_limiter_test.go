@@ -0,0 +1,82 @@
+package tls
+
+import (
+	"crypto"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type slowSigner struct {
+	crypto.Signer
+	delay time.Duration
+}
+
+func (s slowSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	time.Sleep(s.delay)
+	return s.Signer.Sign(rand, digest, opts)
+}
+
+func TestHandshakeSignLimiterTimeout(t *testing.T) {
+	limiter := NewHandshakeSignLimiter(1, 10*time.Millisecond)
+	serverConfig := testConfig.Clone()
+	serverConfig.HandshakeSignLimiter = limiter
+	cert := serverConfig.Certificates[0]
+	cert.PrivateKey = slowSigner{cert.PrivateKey.(crypto.Signer), 200 * time.Millisecond}
+	serverConfig.Certificates = []Certificate{cert}
+
+	c, s := localPipe(t)
+	defer c.Close()
+	server := Server(s, serverConfig)
+	defer server.Close()
+	go Client(c, testConfig).Handshake()
+
+	err := server.Handshake()
+	if err == nil || !strings.Contains(err.Error(), errHandshakeSignTimeout.Error()) {
+		t.Errorf("got %v, want a signing timeout", err)
+	}
+	if stats := limiter.Stats(); stats.TimedOut != 1 {
+		t.Errorf("TimedOut = %d, want 1", stats.TimedOut)
+	}
+}
+
+func TestHandshakeSignLimiterConcurrency(t *testing.T) {
+	limiter := NewHandshakeSignLimiter(2, 0)
+	var running, peak atomic.Int32
+	op := func() ([]byte, error) {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		running.Add(-1)
+		return nil, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limiter.do(op)
+		}()
+	}
+	wg.Wait()
+
+	if p := peak.Load(); p > 2 {
+		t.Errorf("%d operations ran at once, want at most 2", p)
+	}
+	stats := limiter.Stats()
+	if stats.Completed != 10 || stats.Queued != 0 || stats.InFlight != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if stats.QueueWait.Count != 10 {
+		t.Errorf("QueueWait.Count = %d, want 10", stats.QueueWait.Count)
+	}
+}
@@ -0,0 +1,54 @@
+package tls
+
+import (
+	"testing"
+)
+
+func TestHandshakeSM4(t *testing.T) {
+	defer func(suites, noAES []uint16) {
+		defaultCipherSuitesTLS13, defaultCipherSuitesTLS13NoAES = suites, noAES
+	}(defaultCipherSuitesTLS13, defaultCipherSuitesTLS13NoAES)
+	// Make the client offer only the RFC 8998 suite under test.
+	defaultCipherSuitesTLS13, defaultCipherSuitesTLS13NoAES = nil, nil
+
+	for _, suite := range []uint16{TLS_SM4_GCM_SM3, TLS_SM4_CCM_SM3} {
+		t.Run(CipherSuiteName(suite), func(t *testing.T) {
+			clientConfig := testConfig.Clone()
+			clientConfig.MinVersion = VersionTLS13
+			clientConfig.CipherSuites = []uint16{suite}
+			c, s := localPipe(t)
+			client := Client(c, clientConfig)
+			serverConfig := testConfig.Clone()
+			serverConfig.CipherSuites = []uint16{suite}
+			server := Server(s, serverConfig)
+			defer client.Close()
+			defer server.Close()
+
+			errs := make(chan error, 1)
+			go func() {
+				buf := make([]byte, 5)
+				_, err := server.Read(buf)
+				if err == nil {
+					_, err = server.Write(buf)
+				}
+				errs <- err
+			}()
+			if _, err := client.Write([]byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 5)
+			if _, err := client.Read(buf); err != nil {
+				t.Fatal(err)
+			}
+			if err := <-errs; err != nil {
+				t.Fatal(err)
+			}
+			if string(buf) != "hello" {
+				t.Errorf("got %q", buf)
+			}
+			if got := client.ConnectionState().CipherSuite; got != suite {
+				t.Errorf("negotiated %s, want %s", CipherSuiteName(got), CipherSuiteName(suite))
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+//go:build linux && !noktls && !386 && !s390x
+// +build linux,!noktls,!386,!s390x
+
+package tls
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// recvmsg and sendmsg call the system calls directly with a prepared
+// msghdr, unlike the x/sys wrappers, which build one and allocate on every
+// call.
+
+func recvmsg(fd uintptr, msg *unix.Msghdr, flags int) (n int, err error) {
+	r0, _, e1 := unix.Syscall(unix.SYS_RECVMSG, fd, uintptr(unsafe.Pointer(msg)), uintptr(flags))
+	n = int(r0)
+	if e1 != 0 {
+		err = errnoErr(e1)
+	}
+	return
+}
+
+func recvmmsg(fd uintptr, msgs []mmsghdr, flags int) (n int, err error) {
+	r0, _, e1 := unix.Syscall6(unix.SYS_RECVMMSG, fd, uintptr(unsafe.Pointer(&msgs[0])), uintptr(len(msgs)), uintptr(flags), 0, 0)
+	n = int(r0)
+	if e1 != 0 {
+		err = errnoErr(e1)
+	}
+	return
+}
+
+func sendmsg(fd uintptr, msg *unix.Msghdr, flags int) (n int, err error) {
+	r0, _, e1 := unix.Syscall(unix.SYS_SENDMSG, fd, uintptr(unsafe.Pointer(msg)), uintptr(flags))
+	n = int(r0)
+	if e1 != 0 {
+		err = errnoErr(e1)
+	}
+	return
+}
+
+// getsockopt reads opt into the *n bytes at p, setting *n to its length,
+// which the x/sys wrappers only return as a string, copying them.
+func getsockopt(fd uintptr, level, opt int, p unsafe.Pointer, n *uint32) (err error) {
+	_, _, e1 := unix.Syscall6(unix.SYS_GETSOCKOPT, fd, uintptr(level), uintptr(opt), uintptr(p), uintptr(unsafe.Pointer(n)), 0)
+	if e1 != 0 {
+		err = errnoErr(e1)
+	}
+	return
+}
+
+// setsockopt sets opt to the n bytes at p, which the x/sys wrappers only
+// take as a string, copying them.
+func setsockopt(fd uintptr, level, opt int, p unsafe.Pointer, n uintptr) (err error) {
+	_, _, e1 := unix.Syscall6(unix.SYS_SETSOCKOPT, fd, uintptr(level), uintptr(opt), uintptr(p), n, 0)
+	if e1 != 0 {
+		err = errnoErr(e1)
+	}
+	return
+}
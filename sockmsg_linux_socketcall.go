@@ -0,0 +1,76 @@
+//go:build linux && !noktls && (386 || s390x)
+// +build linux
+// +build !noktls
+// +build 386 s390x
+
+package tls
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// On 386 and s390x, kernels before 4.3 only provide the socket system calls
+// through socketcall, which is also what the x/sys wrappers use.
+
+// socketcall call numbers, see linux/net.h.
+const (
+	socketcallSetsockopt = 14
+	socketcallGetsockopt = 15
+	socketcallSendmsg    = 16
+	socketcallRecvmsg    = 17
+	socketcallRecvmmsg   = 19
+)
+
+func socketcall(call int, fd uintptr, msg *unix.Msghdr, flags int) (n int, err error) {
+	args := [3]uintptr{fd, uintptr(unsafe.Pointer(msg)), uintptr(flags)}
+	r0, _, e1 := unix.Syscall(unix.SYS_SOCKETCALL, uintptr(call), uintptr(unsafe.Pointer(&args)), 0)
+	n = int(r0)
+	if e1 != 0 {
+		err = errnoErr(e1)
+	}
+	return
+}
+
+func recvmsg(fd uintptr, msg *unix.Msghdr, flags int) (n int, err error) {
+	return socketcall(socketcallRecvmsg, fd, msg, flags)
+}
+
+func recvmmsg(fd uintptr, msgs []mmsghdr, flags int) (n int, err error) {
+	args := [5]uintptr{fd, uintptr(unsafe.Pointer(&msgs[0])), uintptr(len(msgs)), uintptr(flags), 0}
+	r0, _, e1 := unix.Syscall(unix.SYS_SOCKETCALL, socketcallRecvmmsg, uintptr(unsafe.Pointer(&args)), 0)
+	n = int(r0)
+	if e1 != 0 {
+		err = errnoErr(e1)
+	}
+	return
+}
+
+func sendmsg(fd uintptr, msg *unix.Msghdr, flags int) (n int, err error) {
+	return socketcall(socketcallSendmsg, fd, msg, flags)
+}
+
+func getsockopt(fd uintptr, level, opt int, p unsafe.Pointer, n *uint32) (err error) {
+	args := [5]uintptr{fd, uintptr(level), uintptr(opt), uintptr(p), uintptr(unsafe.Pointer(n))}
+	_, _, e1 := unix.Syscall(unix.SYS_SOCKETCALL, socketcallGetsockopt, uintptr(unsafe.Pointer(&args)), 0)
+	if e1 != 0 {
+		err = errnoErr(e1)
+	}
+	return
+}
+
+func setsockopt(fd uintptr, level, opt int, p unsafe.Pointer, n uintptr) (err error) {
+	args := [5]uintptr{fd, uintptr(level), uintptr(opt), uintptr(p), n}
+	_, _, e1 := unix.Syscall(unix.SYS_SOCKETCALL, socketcallSetsockopt, uintptr(unsafe.Pointer(&args)), 0)
+	if e1 != 0 {
+		err = errnoErr(e1)
+	}
+	return
+}
+
+func init() {
+	ktlsSyscalls = append(ktlsSyscalls, struct{ name, use string }{
+		"socketcall", "recvmsg, recvmmsg and sendmsg on 386 and s390x",
+	})
+}
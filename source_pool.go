@@ -0,0 +1,110 @@
+package tls
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"syscall"
+)
+
+// PortReusePolicy is how a SourcePool shares local ports between
+// connections to different destinations.
+type PortReusePolicy int
+
+const (
+	// PortReuseShared lets the kernel pick the local port when connecting
+	// rather than when binding the source address, with
+	// IP_BIND_ADDRESS_NO_PORT on Linux. A port is then only unique per
+	// destination, so each source IP offers the whole ephemeral range to
+	// every destination.
+	PortReuseShared PortReusePolicy = iota
+
+	// PortReuseExclusive binds each connection to a port of its own, as
+	// binding to a source address normally does. Each source IP then
+	// offers the ephemeral range once, across all destinations.
+	PortReuseExclusive
+)
+
+// A SourcePool rotates the local addresses a Dialer connects from, for
+// clients that run out of ephemeral ports long before they run out of CPU,
+// such as crawlers and proxies. See Dialer.SourcePool.
+//
+// Connections are spread over IPs in turn. When a source IP has no port
+// left for a destination, or has the wrong address family for it, the
+// next one is tried, until each has been tried once.
+type SourcePool struct {
+	// IPs are the local addresses to connect from. They must be assigned
+	// to the host.
+	IPs []net.IP
+
+	// Ports is how local ports are shared between destinations.
+	Ports PortReusePolicy
+
+	next atomic.Uint32
+}
+
+var errEmptySourcePool = errors.New("tls: SourcePool has no IPs")
+
+// dialContext dials addr from the pool's IPs, starting with the next one
+// in turn, using netDialer for every other setting.
+func (p *SourcePool) dialContext(ctx context.Context, netDialer *net.Dialer, network, addr string) (net.Conn, error) {
+	if len(p.IPs) == 0 {
+		return nil, errEmptySourcePool
+	}
+	start := int(p.next.Add(1) - 1)
+	var firstErr error
+	for i := range p.IPs {
+		ip := p.IPs[(start+i)%len(p.IPs)]
+		nd := *netDialer
+		nd.LocalAddr = &net.TCPAddr{IP: ip}
+		if p.Ports == PortReuseShared {
+			nd.Control = chainControl(netDialer.Control, bindAddressNoPort)
+		}
+		conn, err := nd.DialContext(ctx, sourceNetwork(network, ip), addr)
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if !sourceExhausted(err) || ctx.Err() != nil {
+			return nil, err
+		}
+	}
+	return nil, firstErr
+}
+
+// sourceNetwork narrows network to the address family of ip, so that a
+// destination is only reached over a family the source IP can serve.
+func sourceNetwork(network string, ip net.IP) string {
+	if network != "tcp" {
+		return network
+	}
+	if ip.To4() != nil {
+		return "tcp4"
+	}
+	return "tcp6"
+}
+
+// sourceExhausted reports whether err means that another source IP may
+// succeed where this one failed.
+func sourceExhausted(err error) bool {
+	var addrErr *net.AddrError
+	return errors.Is(err, syscall.EADDRNOTAVAIL) || errors.Is(err, syscall.EADDRINUSE) ||
+		errors.As(err, &addrErr)
+}
+
+// chainControl returns a net.Dialer Control function calling first, if not
+// nil, and then second.
+func chainControl(first, second func(network, address string, c syscall.RawConn) error) func(network, address string, c syscall.RawConn) error {
+	if first == nil {
+		return second
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		if err := first(network, address, c); err != nil {
+			return err
+		}
+		return second(network, address, c)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// bindAddressNoPort sets IP_BIND_ADDRESS_NO_PORT, which defers choosing
+// the local port from bind to connect, when the destination is known.
+func bindAddressNoPort(network, address string, c syscall.RawConn) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_BIND_ADDRESS_NO_PORT, 1)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
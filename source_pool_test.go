@@ -0,0 +1,80 @@
+package tls
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestSourcePool(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	for _, ports := range []PortReusePolicy{PortReuseShared, PortReuseExclusive} {
+		// ::1 can't reach an IPv4 destination, so the pool moves on.
+		pool := &SourcePool{
+			IPs:   []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1"), net.ParseIP("127.0.0.2")},
+			Ports: ports,
+		}
+		var got []string
+		for i := 0; i < 3; i++ {
+			conn, err := pool.dialContext(context.Background(), new(net.Dialer), "tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatalf("policy %d: %v", ports, err)
+			}
+			got = append(got, conn.LocalAddr().(*net.TCPAddr).IP.String())
+			conn.Close()
+		}
+		want := []string{"127.0.0.1", "127.0.0.2", "127.0.0.2"}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("policy %d: dialed from %v, want %v", ports, got, want)
+				break
+			}
+		}
+	}
+
+	if _, err := new(SourcePool).dialContext(context.Background(), new(net.Dialer), "tcp", ln.Addr().String()); err != errEmptySourcePool {
+		t.Errorf("got %v, want %v", err, errEmptySourcePool)
+	}
+}
+
+func TestDialerSourcePool(t *testing.T) {
+	ln := newLocalListener(t)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		server := Server(conn, testConfig)
+		server.Handshake()
+	}()
+
+	host, _, _ := net.SplitHostPort(ln.Addr().String())
+	d := Dialer{
+		Config:     testConfig,
+		SourcePool: &SourcePool{IPs: []net.IP{net.ParseIP(host)}},
+	}
+	conn, err := d.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if ip := conn.LocalAddr().(*net.TCPAddr).IP; !ip.Equal(net.ParseIP(host)) {
+		t.Errorf("dialed from %v, want %v", ip, host)
+	}
+}
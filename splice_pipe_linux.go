@@ -0,0 +1,151 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// defaultSplicePipesIdle is the number of idle pipes kept by default, see
+// SetSplicePipePool.
+const defaultSplicePipesIdle = 16
+
+// splicePipe is a pipe through which spliceTo moves data from a socket.
+type splicePipe struct {
+	rfd, wfd int
+	// size is the capacity of the pipe in bytes, or 0 if unknown.
+	size int
+}
+
+// splicePipes holds the idle pipes and the settings of SetSplicePipePool.
+var splicePipes = struct {
+	sync.Mutex
+	idle    []splicePipe
+	maxIdle int
+	size    int
+}{maxIdle: defaultSplicePipesIdle}
+
+// SetSplicePipePool configures the pipes through which Conn.WriteTo and
+// Conn.SpliceTo splice data from kernel TLS sockets. Up to idle pipes are
+// kept open for reuse, rather than creating and closing a pipe on every
+// call; idle 0 disables the pool. If size is positive, new pipes are
+// resized to size bytes with F_SETPIPE_SZ, if the kernel allows it (see
+// /proc/sys/fs/pipe-max-size); otherwise they have the kernel's default
+// size. Pipes already idle are closed. The default is 16 idle pipes of the
+// default size.
+func SetSplicePipePool(idle, size int) {
+	splicePipes.Lock()
+	old := splicePipes.idle
+	splicePipes.idle = nil
+	splicePipes.maxIdle, splicePipes.size = idle, size
+	splicePipes.Unlock()
+	for _, p := range old {
+		p.close()
+	}
+}
+
+// getSplicePipe returns an empty pipe, from the pool if one is idle.
+func getSplicePipe() (splicePipe, error) {
+	splicePipes.Lock()
+	if n := len(splicePipes.idle); n > 0 {
+		p := splicePipes.idle[n-1]
+		splicePipes.idle = splicePipes.idle[:n-1]
+		splicePipes.Unlock()
+		return p, nil
+	}
+	size := splicePipes.size
+	splicePipes.Unlock()
+
+	var fds [2]int
+	if err := unix.Pipe2(fds[:], unix.O_CLOEXEC); err != nil {
+		return splicePipe{}, err
+	}
+	p := splicePipe{rfd: fds[0], wfd: fds[1]}
+	p.size, _ = unix.FcntlInt(uintptr(p.wfd), unix.F_GETPIPE_SZ, 0)
+	if size > 0 {
+		// Best effort: unprivileged processes can't exceed
+		// pipe-max-size, and the default size still works.
+		p.grow(size)
+	}
+	return p, nil
+}
+
+// grow raises the capacity of p to at least size bytes, but no more than
+// pipe-max-size, and returns the resulting capacity, or size if it is
+// unknown. A pipe is never shrunk, since it may be reused with a larger
+// Config.SpliceChunkSize.
+func (p *splicePipe) grow(size int) int {
+	if max := pipeMaxSize(); size > max {
+		size = max
+	}
+	if p.size < size {
+		if n, err := unix.FcntlInt(uintptr(p.wfd), unix.F_SETPIPE_SZ, size); err == nil {
+			p.size = n
+		}
+	}
+	if p.size == 0 {
+		return size
+	}
+	if p.size < size {
+		return p.size
+	}
+	return size
+}
+
+var (
+	pipeMaxSizeOnce  sync.Once
+	pipeMaxSizeValue = 1 << 20
+)
+
+// pipeMaxSize returns /proc/sys/fs/pipe-max-size, the largest pipe an
+// unprivileged process may create, or its default of 1 MiB if it can't be
+// read.
+func pipeMaxSize() int {
+	pipeMaxSizeOnce.Do(func() {
+		b, err := os.ReadFile("/proc/sys/fs/pipe-max-size")
+		if err != nil {
+			return
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil && n > 0 {
+			pipeMaxSizeValue = n
+		}
+	})
+	return pipeMaxSizeValue
+}
+
+// putSplicePipe returns p to the pool, or closes it if the pool is full or
+// p may still hold data.
+func putSplicePipe(p splicePipe, empty bool) {
+	if empty {
+		splicePipes.Lock()
+		if len(splicePipes.idle) < splicePipes.maxIdle {
+			splicePipes.idle = append(splicePipes.idle, p)
+			splicePipes.Unlock()
+			return
+		}
+		splicePipes.Unlock()
+	}
+	p.close()
+}
+
+func (p splicePipe) close() error {
+	err := unix.Close(p.rfd)
+	err1 := unix.Close(p.wfd)
+	if err == nil {
+		return err1
+	}
+	return err
+}
+
+// splice moves up to n bytes from rfd to wfd at their current offsets. It
+// returns an int64 on every architecture, unlike unix.Splice.
+func splice(rfd, wfd, n, flags int) (int64, error) {
+	m, err := unix.Splice(rfd, nil, wfd, nil, n, flags)
+	return int64(m), err
+}
@@ -0,0 +1,71 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestSplicePipePool(t *testing.T) {
+	defer SetSplicePipePool(defaultSplicePipesIdle, 0)
+
+	SetSplicePipePool(1, 1<<16)
+	p1, err := getSplicePipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, err := unix.FcntlInt(uintptr(p1.wfd), unix.F_GETPIPE_SZ, 0); err != nil || size != 1<<16 {
+		t.Errorf("pipe size = %d, %v; want %d", size, err, 1<<16)
+	}
+	putSplicePipe(p1, true)
+	if p2, _ := getSplicePipe(); p2 != p1 {
+		t.Errorf("got pipe %v, want the idle pipe %v", p2, p1)
+	}
+
+	// A pipe that may hold data is closed, as are pipes beyond the
+	// idle limit.
+	putSplicePipe(p1, false)
+	if _, err := unix.FcntlInt(uintptr(p1.rfd), unix.F_GETFD, 0); err != unix.EBADF {
+		t.Errorf("pipe not closed: %v", err)
+	}
+	p3, _ := getSplicePipe()
+	p4, _ := getSplicePipe()
+	putSplicePipe(p3, true)
+	putSplicePipe(p4, true)
+	if len(splicePipes.idle) != 1 {
+		t.Errorf("%d idle pipes, want 1", len(splicePipes.idle))
+	}
+
+	SetSplicePipePool(0, 0)
+	if len(splicePipes.idle) != 0 {
+		t.Errorf("%d idle pipes after disabling the pool, want 0", len(splicePipes.idle))
+	}
+	if _, err := unix.FcntlInt(uintptr(p3.rfd), unix.F_GETFD, 0); err != unix.EBADF {
+		t.Errorf("idle pipe not closed: %v", err)
+	}
+}
+
+func TestSplicePipeGrow(t *testing.T) {
+	p, err := getSplicePipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.close()
+
+	if got := p.grow(1 << 12); got != 1<<12 {
+		t.Errorf("grow(4096) = %d, want 4096", got)
+	}
+	if got := p.grow(256 << 10); got != 256<<10 || p.size < 256<<10 {
+		t.Errorf("grow(256 KiB) = %d, size %d; want 256 KiB", got, p.size)
+	}
+	if got := p.grow(1 << 30); got > pipeMaxSize() {
+		t.Errorf("grow(1 TiB) = %d, want at most pipe-max-size %d", got, pipeMaxSize())
+	}
+	size, err := unix.FcntlInt(uintptr(p.wfd), unix.F_GETPIPE_SZ, 0)
+	if err != nil || size != p.size {
+		t.Errorf("pipe size = %d, %v; want %d", size, err, p.size)
+	}
+}
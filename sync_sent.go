@@ -0,0 +1,47 @@
+package tls
+
+import (
+	"context"
+	"time"
+)
+
+// syncSentMaxInterval bounds the polling interval of SyncSent.
+const syncSentMaxInterval = 50 * time.Millisecond
+
+// SyncSent flushes any data held back by the write coalescer and waits until
+// the kernel's send queue for the connection is empty, that is until all
+// data written so far has been handed to the network. If acked is true, it
+// also waits until the peer has acknowledged all of it at the TCP level,
+// which gives a durability point before, for example, deleting the source
+// of a transfer. An acknowledgement only means the data reached the peer's
+// kernel, not that the peer application read it.
+//
+// SyncSent returns ctx.Err() if ctx is done first. It requires a TCP
+// connection on Linux and returns an error otherwise.
+func (c *Conn) SyncSent(ctx context.Context, acked bool) error {
+	if err := c.Flush(); err != nil {
+		return err
+	}
+
+	interval := time.Millisecond
+	for {
+		n, err := tcpSendQueueLen(c.conn, acked)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if interval *= 2; interval > syncSentMaxInterval {
+			interval = syncSentMaxInterval
+		}
+	}
+}
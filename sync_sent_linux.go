@@ -0,0 +1,42 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"errors"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+var errSyncSentConn = errors.New("tls: SyncSent requires a TCP connection")
+
+// tcpSendQueueLen returns the number of bytes in the send queue of conn that
+// were not sent yet or, if unacked is true, that were not acknowledged by
+// the peer yet.
+func tcpSendQueueLen(conn net.Conn, unacked bool) (int, error) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return 0, errSyncSentConn
+	}
+	rwc, err := tcpConn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	// SIOCOUTQ counts unacknowledged bytes, SIOCOUTQNSD only the ones not
+	// yet sent. See tcp(7).
+	req := uint(unix.SIOCOUTQNSD)
+	if unacked {
+		req = unix.SIOCOUTQ
+	}
+	var n int
+	var err0 error
+	err = rwc.Control(func(fd uintptr) {
+		n, err0 = unix.IoctlGetInt(int(fd), req)
+	})
+	if err == nil {
+		err = err0
+	}
+	return n, err
+}
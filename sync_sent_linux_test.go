@@ -0,0 +1,55 @@
+//go:build linux && !noktls
+// +build linux,!noktls
+
+package tls
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSyncSent(t *testing.T) {
+	client, server := coalescePipe(t, time.Hour)
+	defer client.Close()
+	defer server.Close()
+
+	data := bytes.Repeat([]byte("a"), 1<<20)
+	read := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(client, make([]byte, len(data)))
+		read <- err
+	}()
+
+	if _, err := server.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, acked := range []bool{false, true} {
+		if err := server.SyncSent(ctx, acked); err != nil {
+			t.Fatalf("SyncSent(%v): %v", acked, err)
+		}
+		if n, err := tcpSendQueueLen(server.conn, acked); err != nil || n != 0 {
+			t.Errorf("send queue holds %d bytes after SyncSent(%v), err %v", n, acked, err)
+		}
+	}
+	if err := <-read; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncSentNotTCP(t *testing.T) {
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+
+	conn := &Conn{conn: c, config: testConfig}
+	conn.isHandshakeComplete.Store(true)
+	if err := conn.SyncSent(context.Background(), false); err != errSyncSentConn {
+		t.Errorf("got %v, want %v", err, errSyncSentConn)
+	}
+}
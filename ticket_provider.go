@@ -0,0 +1,104 @@
+package tls
+
+import "time"
+
+// A TicketKeyProvider supplies the session ticket keys used by a server. It
+// allows the keys to be kept in an external key management service and
+// shared by every server terminating connections for the same host, instead
+// of each server rotating its own keys in memory.
+//
+// Keys are fetched on the first handshake that needs them and cached by the
+// Config until the time returned by NextRotation. Fetches run in the
+// background, so that handshakes keep using the cached keys while new ones
+// are fetched and a slow key management service doesn't stall them; only
+// the first fetch is waited for, and for at most a second. If a fetch
+// fails, the previously cached keys remain in use and the fetch is retried
+// a minute later. Until keys have been fetched successfully, the server
+// neither issues nor accepts session tickets.
+type TicketKeyProvider interface {
+	// TicketKeys returns the key used to encrypt new session tickets,
+	// followed by zero or more previous keys that are still accepted when
+	// decrypting tickets. Keys have the same format as SessionTicketKey.
+	TicketKeys() (current [32]byte, previous [][32]byte, err error)
+
+	// NextRotation returns the time at which the keys returned by the last
+	// call to TicketKeys should be fetched again. If it is not in the
+	// future, the keys are cached for a day.
+	NextRotation() time.Time
+}
+
+// ticketKeyProviderRetry is how long a server keeps using its cached session
+// ticket keys after TicketKeyProvider.TicketKeys fails before retrying.
+const ticketKeyProviderRetry = time.Minute
+
+// ticketKeyProviderWait is how long a handshake waits for the first fetch
+// of session ticket keys before continuing without tickets.
+const ticketKeyProviderWait = time.Second
+
+// providerTicketKeysRLocked returns the session ticket keys supplied by
+// c.TicketKeyProvider, starting a fetch if the cached keys are due for
+// rotation and none is running. c.mutex must be held for reading.
+func (c *Config) providerTicketKeysRLocked() []ticketKey {
+	// Fast path for the common case where the keys are fresh enough.
+	if c.time().Before(c.providerTicketKeysRefresh) {
+		return c.providerTicketKeys
+	}
+
+	c.mutex.RUnlock()
+	defer c.mutex.RLock()
+	c.mutex.Lock()
+	keys, done := c.providerTicketKeys, c.providerTicketKeysFetch
+	// Re-check the condition in case it changed since obtaining the new lock.
+	if done == nil && !c.time().Before(c.providerTicketKeysRefresh) {
+		done = make(chan struct{})
+		c.providerTicketKeysFetch = done
+		go c.fetchProviderTicketKeys(done)
+	}
+	c.mutex.Unlock()
+	if keys != nil || done == nil {
+		return keys
+	}
+
+	t := time.NewTimer(ticketKeyProviderWait)
+	defer t.Stop()
+	select {
+	case <-done:
+	case <-t.C:
+		return nil
+	}
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.providerTicketKeys
+}
+
+// fetchProviderTicketKeys fetches the keys from c.TicketKeyProvider without
+// holding c.mutex, caches them and closes done.
+func (c *Config) fetchProviderTicketKeys(done chan struct{}) {
+	defer close(done)
+
+	current, previous, err := c.TicketKeyProvider.TicketKeys()
+	var keys []ticketKey
+	var next time.Time
+	if err == nil {
+		keys = make([]ticketKey, 0, len(previous)+1)
+		keys = append(keys, c.ticketKeyFromBytes(current))
+		for _, k := range previous {
+			keys = append(keys, c.ticketKeyFromBytes(k))
+		}
+		next = c.TicketKeyProvider.NextRotation()
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.providerTicketKeysFetch = nil
+	if err != nil {
+		Debugln("tls: failed to fetch session ticket keys:", err)
+		c.providerTicketKeysRefresh = c.time().Add(ticketKeyProviderRetry)
+		return
+	}
+	c.providerTicketKeys = keys
+	if !next.After(c.time()) {
+		next = c.time().Add(ticketKeyRotation)
+	}
+	c.providerTicketKeysRefresh = next
+}
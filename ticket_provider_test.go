@@ -0,0 +1,150 @@
+package tls
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type staticTicketKeys struct {
+	current  [32]byte
+	previous [][32]byte
+	err      error
+	calls    int
+}
+
+func (p *staticTicketKeys) TicketKeys() ([32]byte, [][32]byte, error) {
+	p.calls++
+	return p.current, p.previous, p.err
+}
+
+func (p *staticTicketKeys) NextRotation() time.Time {
+	return time.Now().Add(time.Hour)
+}
+
+// resumeWith runs a TLS 1.3 handshake against a server using provider and
+// reports whether the session was resumed.
+func resumeWith(t *testing.T, clientConfig *Config, provider TicketKeyProvider) bool {
+	serverConfig := testConfig.Clone()
+	serverConfig.MaxVersion = VersionTLS13
+	serverConfig.TicketKeyProvider = provider
+
+	c, s := localPipe(t)
+	client := Client(c, clientConfig)
+	server := Server(s, serverConfig)
+	defer client.Close()
+	defer server.Close()
+
+	errChan := make(chan error, 1)
+	go func() {
+		err := server.Handshake()
+		if err == nil {
+			// Give the client a chance to read the session ticket.
+			_, err = server.Write([]byte("x"))
+		}
+		errChan <- err
+	}()
+	if err := client.Handshake(); err != nil {
+		t.Fatalf("client handshake: %v", err)
+	}
+	if _, err := client.Read(make([]byte, 1)); err != nil {
+		t.Fatalf("client read: %v", err)
+	}
+	if err := <-errChan; err != nil {
+		t.Fatalf("server handshake: %v", err)
+	}
+	return client.ConnectionState().DidResume
+}
+
+func TestTicketKeyProvider(t *testing.T) {
+	clientConfig := testConfig.Clone()
+	clientConfig.ClientSessionCache = NewLRUClientSessionCache(1)
+
+	key := [32]byte{1}
+	first := &staticTicketKeys{current: key}
+	if resumeWith(t, clientConfig, first) {
+		t.Fatal("first handshake resumed")
+	}
+
+	// A ticket issued by one server is accepted by another server sharing
+	// the provider's keys, including after rotation.
+	second := &staticTicketKeys{current: [32]byte{2}, previous: [][32]byte{key}}
+	if !resumeWith(t, clientConfig, second) {
+		t.Error("handshake with a rotated key was not resumed")
+	}
+
+	other := &staticTicketKeys{current: [32]byte{3}}
+	if resumeWith(t, clientConfig, other) {
+		t.Error("handshake resumed with an unknown ticket key")
+	}
+}
+
+func TestTicketKeyProviderError(t *testing.T) {
+	clientConfig := testConfig.Clone()
+	clientConfig.ClientSessionCache = NewLRUClientSessionCache(1)
+
+	// Without keys the server must complete the handshake without issuing
+	// a ticket, rather than fail to encrypt one.
+	failing := &staticTicketKeys{err: errors.New("unavailable")}
+	for i := 0; i < 2; i++ {
+		if resumeWith(t, clientConfig, failing) {
+			t.Fatal("handshake resumed without ticket keys")
+		}
+	}
+}
+
+func TestTicketKeyProviderCache(t *testing.T) {
+	p := &staticTicketKeys{current: [32]byte{1}}
+	config := testConfig.Clone()
+	config.TicketKeyProvider = p
+
+	for i := 0; i < 3; i++ {
+		if keys := config.ticketKeys(nil); len(keys) != 1 {
+			t.Fatalf("got %d keys, want 1", len(keys))
+		}
+	}
+	if p.calls != 1 {
+		t.Errorf("provider called %d times, want 1", p.calls)
+	}
+}
+
+// blockingTicketKeys is a TicketKeyProvider whose fetches after the first
+// block until release is closed.
+type blockingTicketKeys struct {
+	staticTicketKeys
+	release chan struct{}
+}
+
+func (p *blockingTicketKeys) TicketKeys() ([32]byte, [][32]byte, error) {
+	if p.calls > 0 {
+		<-p.release
+	}
+	return p.staticTicketKeys.TicketKeys()
+}
+
+// TestTicketKeyProviderRefresh checks that handshakes keep using the cached
+// keys while a refresh is blocked in the provider.
+func TestTicketKeyProviderRefresh(t *testing.T) {
+	p := &blockingTicketKeys{staticTicketKeys{current: [32]byte{1}}, make(chan struct{})}
+	config := testConfig.Clone()
+	config.TicketKeyProvider = p
+
+	if keys := config.ticketKeys(nil); len(keys) != 1 {
+		t.Fatalf("got %d keys, want 1", len(keys))
+	}
+	config.mutex.Lock()
+	config.providerTicketKeysRefresh = time.Time{}
+	config.mutex.Unlock()
+
+	got := make(chan int, 1)
+	go func() { got <- len(config.ticketKeys(nil)) }()
+	select {
+	case n := <-got:
+		if n != 1 {
+			t.Errorf("got %d keys during the refresh, want 1", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handshake blocked on the key refresh")
+	}
+	close(p.release)
+}
@@ -114,10 +114,42 @@ func (timeoutError) Temporary() bool { return true }
 // DialWithDialer uses context.Background internally; to specify the context,
 // use Dialer.DialContext with NetDialer set to the desired dialer.
 func DialWithDialer(dialer *net.Dialer, network, addr string, config *Config) (*Conn, error) {
-	return dial(context.Background(), dialer, network, addr, config)
+	return dial(context.Background(), dialer, nil, network, addr, config)
 }
 
-func dial(ctx context.Context, netDialer *net.Dialer, network, addr string, config *Config) (*Conn, error) {
+// DialWithDialerKTLS is like DialWithDialer, and also returns whether the
+// kernel offloaded each direction of the connection and, if not, why. If
+// the handshake fails, the state is that of the failed connection, which
+// explains the failure when kernel TLS caused it, as with KTLSModeRequire.
+func DialWithDialerKTLS(dialer *net.Dialer, network, addr string, config *Config) (*Conn, KTLSState, error) {
+	return dialKTLS(context.Background(), dialer, nil, network, addr, config)
+}
+
+func dial(ctx context.Context, netDialer *net.Dialer, pool *SourcePool, network, addr string, config *Config) (*Conn, error) {
+	conn, err := dialConn(ctx, netDialer, pool, network, addr, config)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func dialKTLS(ctx context.Context, netDialer *net.Dialer, pool *SourcePool, network, addr string, config *Config) (*Conn, KTLSState, error) {
+	conn, err := dialConn(ctx, netDialer, pool, network, addr, config)
+	if conn == nil {
+		return nil, KTLSState{}, err
+	}
+	conn.handshakeMutex.Lock()
+	state := conn.kernelTLSState()
+	conn.handshakeMutex.Unlock()
+	if err != nil {
+		return nil, state, err
+	}
+	return conn, state, nil
+}
+
+// dialConn dials a connection and performs its handshake. If the handshake
+// fails, the closed connection is returned along with the error.
+func dialConn(ctx context.Context, netDialer *net.Dialer, pool *SourcePool, network, addr string, config *Config) (*Conn, error) {
 	if netDialer.Timeout != 0 {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, netDialer.Timeout)
@@ -130,7 +162,13 @@ func dial(ctx context.Context, netDialer *net.Dialer, network, addr string, conf
 		defer cancel()
 	}
 
-	rawConn, err := netDialer.DialContext(ctx, network, addr)
+	var rawConn net.Conn
+	var err error
+	if pool != nil {
+		rawConn, err = pool.dialContext(ctx, netDialer, network, addr)
+	} else {
+		rawConn, err = netDialer.DialContext(ctx, network, addr)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -156,7 +194,7 @@ func dial(ctx context.Context, netDialer *net.Dialer, network, addr string, conf
 	conn := Client(rawConn, config)
 	if err := conn.HandshakeContext(ctx); err != nil {
 		rawConn.Close()
-		return nil, err
+		return conn, err
 	}
 	return conn, nil
 }
@@ -184,6 +222,11 @@ type Dialer struct {
 	// configuration; see the documentation of Config for the
 	// defaults.
 	Config *Config
+
+	// SourcePool, if not nil, selects the local address of each
+	// connection in place of NetDialer.LocalAddr, rotating over several
+	// source IPs.
+	SourcePool *SourcePool
 }
 
 // Dial connects to the given network address and initiates a TLS
@@ -214,7 +257,7 @@ func (d *Dialer) netDialer() *net.Dialer {
 //
 // The returned Conn, if any, will always be of type *Conn.
 func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
-	c, err := dial(ctx, d.netDialer(), network, addr, d.Config)
+	c, err := dial(ctx, d.netDialer(), d.SourcePool, network, addr, d.Config)
 	if err != nil {
 		// Don't return c (a typed nil) in an interface.
 		return nil, err
@@ -222,6 +265,12 @@ func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Con
 	return c, nil
 }
 
+// DialContextKTLS is like DialContext, and also returns the kernel TLS
+// outcome of the connection, as DialWithDialerKTLS.
+func (d *Dialer) DialContextKTLS(ctx context.Context, network, addr string) (*Conn, KTLSState, error) {
+	return dialKTLS(ctx, d.netDialer(), d.SourcePool, network, addr, d.Config)
+}
+
 // LoadX509KeyPair reads and parses a public/private key pair from a pair
 // of files. The files must contain PEM encoded data. The certificate file
 // may contain intermediate certificates following the leaf certificate to
@@ -315,6 +315,44 @@ func TestDialer(t *testing.T) {
 	}
 }
 
+func TestDialKTLS(t *testing.T) {
+	ln := NewListener(newLocalListener(t), testConfig)
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.(*Conn).Handshake()
+			c.Close()
+		}
+	}()
+
+	config := testConfig.Clone()
+	config.KTLSMode = KTLSModeOff
+	conn, state, err := DialWithDialerKTLS(new(net.Dialer), "tcp", ln.Addr().String(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if want := "disabled by Config.KTLSMode"; state.TXReason != want || state.RXReason != want {
+		t.Errorf("state = %+v, want reasons %q", state, want)
+	}
+
+	defer ktlsDisabled.Store(ktlsDisabled.Load())
+	SetKTLSEnabled(false)
+	config.KTLSMode = KTLSModeRequire
+	d := &Dialer{Config: config}
+	conn, state, err = d.DialContextKTLS(context.Background(), "tcp", ln.Addr().String())
+	if err == nil || conn != nil {
+		t.Fatalf("DialContextKTLS with KTLSModeRequire while disabled = %v, %v", conn, err)
+	}
+	if want := "disabled by SetKTLSEnabled"; state.TXReason != want {
+		t.Errorf("TXReason = %q, want %q", state.TXReason, want)
+	}
+}
+
 func isTimeoutError(err error) bool {
 	if ne, ok := err.(net.Error); ok {
 		return ne.Timeout()
@@ -735,7 +773,7 @@ func TestWarningAlertFlood(t *testing.T) {
 }
 
 func TestCloneFuncFields(t *testing.T) {
-	const expectedCount = 6
+	const expectedCount = 16
 	called := 0
 
 	c1 := Config{
@@ -763,6 +801,40 @@ func TestCloneFuncFields(t *testing.T) {
 			called |= 1 << 5
 			return nil
 		},
+		OnKTLSFallback: func(*Conn, KTLSState) {
+			called |= 1 << 6
+		},
+		RecordPadding: func(int) int {
+			called |= 1 << 7
+			return 0
+		},
+		CTPolicy: func([]SCTResult) error {
+			called |= 1 << 8
+			return nil
+		},
+		GetTLSARecords: func(string) ([]TLSARecord, error) {
+			called |= 1 << 9
+			return nil, nil
+		},
+		OnUnknownRecord: func(uint8, []byte) {
+			called |= 1 << 10
+		},
+		DebugConn: func(*Conn) bool {
+			called |= 1 << 11
+			return false
+		},
+		OnAdvice: func(*Conn, Advice) {
+			called |= 1 << 12
+		},
+		OnRXOffloadLost: func(*Conn, string) {
+			called |= 1 << 13
+		},
+		OnWarningAlert: func(*Conn, AlertDescription) {
+			called |= 1 << 14
+		},
+		OnKTLSEnabled: func(*Conn, KTLSState) {
+			called |= 1 << 15
+		},
 	}
 
 	c2 := c1.Clone()
@@ -773,6 +845,16 @@ func TestCloneFuncFields(t *testing.T) {
 	c2.GetConfigForClient(nil)
 	c2.VerifyPeerCertificate(nil, nil)
 	c2.VerifyConnection(ConnectionState{})
+	c2.OnKTLSFallback(nil, KTLSState{})
+	c2.RecordPadding(0)
+	c2.CTPolicy(nil)
+	c2.GetTLSARecords("")
+	c2.OnUnknownRecord(0, nil)
+	c2.DebugConn(nil)
+	c2.OnAdvice(nil, Advice{})
+	c2.OnRXOffloadLost(nil, "")
+	c2.OnWarningAlert(nil, 0)
+	c2.OnKTLSEnabled(nil, KTLSState{})
 
 	if called != (1<<expectedCount)-1 {
 		t.Fatalf("expected %d calls but saw calls %b", expectedCount, called)
@@ -791,7 +873,9 @@ func TestCloneNonFuncFields(t *testing.T) {
 		switch fn := typ.Field(i).Name; fn {
 		case "Rand":
 			f.Set(reflect.ValueOf(io.Reader(os.Stdin)))
-		case "Time", "GetCertificate", "GetConfigForClient", "VerifyPeerCertificate", "VerifyConnection", "GetClientCertificate":
+		case "Time", "GetCertificate", "GetConfigForClient", "VerifyPeerCertificate", "VerifyConnection", "GetClientCertificate",
+			"OnKTLSFallback", "RecordPadding", "CTPolicy", "GetTLSARecords", "OnUnknownRecord", "DebugConn", "OnAdvice",
+			"OnRXOffloadLost", "OnWarningAlert", "OnKTLSEnabled":
 			// DeepEqual can't compare functions. If you add a
 			// function field to this list, you must also change
 			// TestCloneFuncFields to ensure that the func field is
@@ -808,25 +892,53 @@ func TestCloneNonFuncFields(t *testing.T) {
 			f.Set(reflect.ValueOf(NewLRUClientSessionCache(10)))
 		case "KeyLogWriter":
 			f.Set(reflect.ValueOf(io.Writer(os.Stdout)))
+		case "TicketKeyProvider":
+			f.Set(reflect.ValueOf(TicketKeyProvider(&staticTicketKeys{})))
+		case "RecordObserver":
+			f.Set(reflect.ValueOf(RecordObserver(&recordLog{})))
+		case "Logger":
+			f.Set(reflect.ValueOf(Logger(&testLogger{})))
 		case "NextProtos":
 			f.Set(reflect.ValueOf([]string{"a", "b"}))
 		case "ServerName":
 			f.Set(reflect.ValueOf("b"))
 		case "ClientAuth":
 			f.Set(reflect.ValueOf(VerifyClientCertIfGiven))
-		case "InsecureSkipVerify", "SessionTicketsDisabled", "DynamicRecordSizingDisabled", "PreferServerCipherSuites":
+		case "InsecureSkipVerify", "SessionTicketsDisabled", "DynamicRecordSizingDisabled", "PreferServerCipherSuites",
+			"KTLSDeferRX", "KTLSDeferEnable", "PostHandshakeAuth", "AlignRecordsToMSS", "KTLSSkipLocal", "KTLSUnwrapConn",
+			"KTLSSeccompCompat", "KTLSFallbackOnError", "KTLSRXIOUring", "KTLSSpliceIOUring", "KTLSRequireDevice", "KTLSTXDisabled", "KTLSRXDisabled",
+			"KTLSRXExpectNoPad":
 			f.Set(reflect.ValueOf(true))
 		case "MinVersion", "MaxVersion":
 			f.Set(reflect.ValueOf(uint16(VersionTLS12)))
 		case "SessionTicketKey":
 			f.Set(reflect.ValueOf([32]byte{}))
-		case "CipherSuites":
+		case "CTLogs":
+			f.Set(reflect.ValueOf([]*CTLog{{Description: "log"}}))
+		case "CipherSuites", "KTLSCipherSuites":
 			f.Set(reflect.ValueOf([]uint16{1, 2}))
 		case "CurvePreferences":
 			f.Set(reflect.ValueOf([]CurveID{CurveP256}))
 		case "Renegotiation":
 			f.Set(reflect.ValueOf(RenegotiateOnceAsClient))
-		case "mutex", "autoSessionTicketKeys", "sessionTicketKeys":
+		case "KTLSZerocopySendfile":
+			f.Set(reflect.ValueOf(KTLSZerocopySendfileRequired))
+		case "KTLSMode":
+			f.Set(reflect.ValueOf(KTLSModeRequire))
+		case "KTLSProtocolModes":
+			f.Set(reflect.ValueOf(map[string]KTLSMode{"h2": KTLSModeOff}))
+		case "UnknownRecords":
+			f.Set(reflect.ValueOf(UnknownRecordSkip))
+		case "WriteCoalesceDelay", "HandshakeTimeout", "CloseTimeout":
+			f.Set(reflect.ValueOf(time.Millisecond))
+		case "WriteCoalesceSize", "RecordSampleBytes", "SpliceChunkSize":
+			f.Set(reflect.ValueOf(4096))
+		case "KTLSVerifyInterval":
+			f.Set(reflect.ValueOf(16))
+		case "HandshakeSignLimiter":
+			f.Set(reflect.ValueOf(NewHandshakeSignLimiter(1, time.Second)))
+		case "mutex", "autoSessionTicketKeys", "sessionTicketKeys", "providerTicketKeys", "providerTicketKeysRefresh",
+			"providerTicketKeysFetch":
 			continue // these are unexported fields that are handled separately
 		default:
 			t.Errorf("all fields must be accounted for, but saw unknown field %q", fn)
@@ -835,6 +947,8 @@ func TestCloneNonFuncFields(t *testing.T) {
 	// Set the unexported fields related to session ticket keys, which are copied with Clone().
 	c1.autoSessionTicketKeys = []ticketKey{c1.ticketKeyFromBytes(c1.SessionTicketKey)}
 	c1.sessionTicketKeys = []ticketKey{c1.ticketKeyFromBytes(c1.SessionTicketKey)}
+	c1.providerTicketKeys = []ticketKey{c1.ticketKeyFromBytes(c1.SessionTicketKey)}
+	c1.providerTicketKeysRefresh = time.Unix(1, 0)
 
 	c2 := c1.Clone()
 	if !reflect.DeepEqual(&c1, c2) {
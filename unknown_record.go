@@ -0,0 +1,35 @@
+package tls
+
+// UnknownRecordPolicy is the policy for records with an unrecognized content
+// type, see Config.UnknownRecords.
+type UnknownRecordPolicy int
+
+const (
+	// UnknownRecordError fails the connection with an unexpected_message
+	// alert, as required by RFC 8446, Section 5.
+	UnknownRecordError UnknownRecordPolicy = iota
+
+	// UnknownRecordSkip silently drops the record.
+	UnknownRecordSkip
+
+	// UnknownRecordDeliver drops the record after passing it to
+	// Config.OnUnknownRecord.
+	UnknownRecordDeliver
+)
+
+// handleUnknownRecord applies Config.UnknownRecords to a record of type typ
+// received after the handshake. It reports whether the record was dropped.
+// c.in must be locked.
+func (c *Conn) handleUnknownRecord(typ recordType, data []byte) bool {
+	switch c.config.UnknownRecords {
+	case UnknownRecordSkip:
+		c.debugf("tls: skipping record of unknown type %d, length %d", typ, len(data))
+		return true
+	case UnknownRecordDeliver:
+		if c.config.OnUnknownRecord != nil {
+			c.config.OnUnknownRecord(uint8(typ), data)
+		}
+		return true
+	}
+	return false
+}
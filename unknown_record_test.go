@@ -0,0 +1,70 @@
+package tls
+
+import (
+	"testing"
+)
+
+const recordTypeHeartbeat recordType = 24
+
+func testUnknownRecord(t *testing.T, version uint16, clientConfig *Config) (string, error) {
+	c, s := localPipe(t)
+	clientConfig.MaxVersion = version
+	client := Client(c, clientConfig)
+	server := Server(s, testConfig.Clone())
+	defer client.Close()
+	defer server.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := server.Handshake(); err != nil {
+			t.Error(err)
+			return
+		}
+		server.out.Lock()
+		_, err := server.writeRecordLocked(recordTypeHeartbeat, []byte("heartbeat"))
+		server.out.Unlock()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		server.Write([]byte("data"))
+	}()
+
+	buf := make([]byte, 4)
+	n, err := client.Read(buf)
+	client.Close()
+	<-done
+	return string(buf[:n]), err
+}
+
+func TestUnknownRecordPolicy(t *testing.T) {
+	for _, v := range []uint16{VersionTLS12, VersionTLS13} {
+		config := testConfig.Clone()
+		if _, err := testUnknownRecord(t, v, config); err == nil {
+			t.Errorf("%x: unknown record type accepted with UnknownRecordError", v)
+		}
+
+		config = testConfig.Clone()
+		config.UnknownRecords = UnknownRecordSkip
+		if got, err := testUnknownRecord(t, v, config); err != nil || got != "data" {
+			t.Errorf("%x: UnknownRecordSkip: got %q, %v; want %q", v, got, err, "data")
+		}
+
+		var delivered []string
+		config = testConfig.Clone()
+		config.UnknownRecords = UnknownRecordDeliver
+		config.OnUnknownRecord = func(typ uint8, data []byte) {
+			if recordType(typ) != recordTypeHeartbeat {
+				t.Errorf("%x: got record type %d, want %d", v, typ, recordTypeHeartbeat)
+			}
+			delivered = append(delivered, string(data))
+		}
+		if got, err := testUnknownRecord(t, v, config); err != nil || got != "data" {
+			t.Errorf("%x: UnknownRecordDeliver: got %q, %v; want %q", v, got, err, "data")
+		}
+		if len(delivered) != 1 || delivered[0] != "heartbeat" {
+			t.Errorf("%x: delivered %q, want [heartbeat]", v, delivered)
+		}
+	}
+}